@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// AuditCommand implements the audit subcommand
+func AuditCommand(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+
+	// Define flags
+	manifestPath := fs.String("manifest", "", "Manifest file to check against (required)")
+	remote := fs.String("remote", "", "Where the parts were offloaded: s3://bucket/prefix or a directory")
+	create := fs.String("create", "", "Write the manifest from the local parts of download PREFIX")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel audit --manifest FILE (--create PREFIX | --remote REMOTE)
+
+Check that offloaded parts really arrived before deleting the local copies.
+First record the size and hashes of the local parts in a manifest, then
+audit the remote against it: every part must exist with the right size,
+and its hash is compared whenever the remote reports one (S3 single-request
+ETags and x-amz-checksum-sha256, or every file of a directory remote).
+
+Options:
+  --manifest FILE    Manifest file (required)
+  --create PREFIX    Write the manifest from the local parts of download PREFIX
+  --remote REMOTE    s3://bucket/prefix or a directory (e.g. an rclone mount).
+                     For R2 and other S3-compatible stores set AWS_ENDPOINT_URL.
+
+Exits non-zero if any part is missing, truncated or corrupted.
+
+Examples:
+  rapel audit --manifest file.bin.manifest.json --create file.bin
+  AWS_ENDPOINT_URL=https://<account>.r2.cloudflarestorage.com \
+    rapel audit --manifest file.bin.manifest.json --remote s3://bucket/backups
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *manifestPath == "" {
+		fs.Usage()
+		return fmt.Errorf("--manifest is required")
+	}
+	if (*create == "") == (*remote == "") {
+		fs.Usage()
+		return fmt.Errorf("exactly one of --create and --remote is required")
+	}
+
+	if *create != "" {
+		return createManifest(*create, *manifestPath)
+	}
+
+	m, err := manifest.Load(*manifestPath)
+	if err != nil {
+		return err
+	}
+	r, err := manifest.OpenRemote(*remote)
+	if err != nil {
+		return err
+	}
+
+	report, err := manifest.Audit(context.Background(), m, r)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range report.Problems {
+		fmt.Printf("FAIL  %s: %s\n", p.Part.Name, p.Reason)
+	}
+	fmt.Printf("%d/%d parts present with the right size, %d of them hash-verified\n",
+		report.Checked, len(m.Parts), report.Verified)
+
+	if !report.OK() {
+		return fmt.Errorf("%d of %d parts failed the audit, keep the local copies", len(report.Problems), len(m.Parts))
+	}
+	return nil
+}
+
+// createManifest hashes the local parts of download prefix into path.
+// Parts that are missing locally are left out, with a warning. Once a
+// download finishes its state is gone, so the parts on disk are scanned
+// and the chunk size is taken from the largest one.
+func createManifest(prefix, path string) error {
+	state, err := downloader.LoadDownloadArguments(prefix)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state, err = scanParts(prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	m := &manifest.Manifest{
+		File:      state.FilenamePrefix,
+		Size:      state.TotalSize,
+		ChunkSize: state.ChunkSize,
+		Parts:     []manifest.Part{},
+	}
+
+	var missing int
+	for i := 0; i < state.NumChunks(); i++ {
+		partPath := state.PartPath(i)
+		size, sha, md, err := manifest.HashFile(partPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if size != state.ChunkSizeAt(i) {
+			return fmt.Errorf("%s is %d bytes, expected %d", partPath, size, state.ChunkSizeAt(i))
+		}
+
+		start, end := state.ChunkRange(i)
+		m.Parts = append(m.Parts, manifest.Part{
+			Index:  i,
+			Name:   filepath.Base(partPath),
+			Start:  start,
+			End:    end,
+			Size:   size,
+			SHA256: sha,
+			MD5:    md,
+		})
+	}
+	if len(m.Parts) == 0 {
+		return fmt.Errorf("no parts of %s found", prefix)
+	}
+
+	if err := m.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s with %d parts\n", path, len(m.Parts))
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d of %d parts are not present locally and are not in the manifest\n", missing, state.NumChunks())
+	}
+	return nil
+}
+
+// scanParts rebuilds the layout of a finished download from its part
+// files. Every part but the last has the full chunk size, so the largest
+// part gives the chunk size and the last one the total.
+func scanParts(prefix string) (*downloader.DownloadArguments, error) {
+	matches, err := filepath.Glob(prefix + ".*.part")
+	if err != nil {
+		return nil, err
+	}
+
+	last := -1
+	var chunkSize, lastSize int64
+	for _, match := range matches {
+		var index int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(match, prefix+"."), "%d.part", &index); err != nil || storage.PartName(prefix, index) != match {
+			continue
+		}
+		st, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		chunkSize = max(chunkSize, st.Size())
+		if index > last {
+			last, lastSize = index, st.Size()
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("no download state or parts found for %s", prefix)
+	}
+
+	return downloader.NewDownloadArguments("", int64(last)*chunkSize+lastSize, chunkSize, prefix), nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// CleanCommand implements the clean subcommand
+func CleanCommand(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	all := fs.Bool("all", false, "Also remove the .part files and state of downloads that can still resume")
+	tmpOnly := fs.Bool("tmp-only", false, "Only remove unfinished chunk files (.tmp)")
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing it")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel clean [options] [PREFIX]
+
+Remove what downloads left in the current directory: PREFIX's, or every
+download's. A download whose .PREFIX-args.json is gone (it finished, or was
+given up on) is abandoned: its .tmp files and remaining state files are
+removed. Its .part files are kept, for a merge, as are the post-part
+failures 'rapel status' lists and S3 upload state. Downloads another rapel
+process is running are skipped.
+
+Options:
+  --all        Remove every file of the downloads, .part files, manifest and
+               interrupted merges included, even if they could still resume
+  --tmp-only   Only remove unfinished chunk files (.tmp, .tail, .packing)
+  --dry-run    List what would be removed without removing it
+
+Examples:
+  rapel clean --dry-run
+  rapel clean --tmp-only
+  rapel clean --all file.bin
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("at most one PREFIX")
+	}
+
+	report, err := downloader.Clean(fs.Arg(0), downloader.CleanOptions{All: *all, TmpOnly: *tmpOnly, DryRun: *dryRun})
+	if report == nil {
+		return err
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	for _, f := range report.Files {
+		fmt.Printf("%s %s (%s)\n", verb, f.Path, formatSize(f.Size))
+	}
+	for _, prefix := range report.Skipped {
+		fmt.Printf("Skipped %s: a rapel process is downloading it\n", prefix)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("Would reclaim %s from %d file(s) of %d download(s)\n", formatSize(report.Bytes()), len(report.Files), report.Downloads())
+	} else {
+		fmt.Printf("Reclaimed %s from %d file(s) of %d download(s)\n", formatSize(report.Bytes()), len(report.Files), report.Downloads())
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redraw/rapel/internal/crypt"
+)
+
+// loadKey returns the chunk encryption key named by spec: env:VAR reads
+// the passphrase from an environment variable and file:PATH from the
+// first line of a file. An empty spec means no encryption.
+func loadKey(flagName, spec string) (*crypt.Key, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	kind, value, _ := strings.Cut(spec, ":")
+	var passphrase string
+	switch kind {
+	case "env":
+		passphrase = os.Getenv(value)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s: environment variable %s is empty or not set", flagName, value)
+		}
+	case "file":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", flagName, err)
+		}
+		passphrase, _, _ = strings.Cut(string(data), "\n")
+		passphrase = strings.TrimSuffix(passphrase, "\r")
+	case "age":
+		return nil, fmt.Errorf("%s: age recipients are not supported, use a passphrase with env:VAR or file:PATH", flagName)
+	default:
+		return nil, fmt.Errorf("%s: want env:VAR or file:PATH, got %q", flagName, spec)
+	}
+
+	key, err := crypt.NewKey(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", flagName, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redraw/rapel/internal/control"
+)
+
+// CtlCommand implements the ctl subcommand
+func CtlCommand(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel ctl PREFIX get
+       rapel ctl PREFIX set KEY=VALUE...
+
+Adjust a download running in the current directory without restarting it.
+
+Settings:
+  jobs=N       Concurrent chunks (running chunks finish before a decrease applies)
+  limit=SIZE   Max download rate per second (K, M, G suffix); 0 or off removes it
+
+On Unix, SIGUSR1 and SIGUSR2 sent to the rapel process add or remove one job.
+
+Examples:
+  rapel ctl file.bin get
+  rapel ctl file.bin set jobs=8 limit=2M
+  rapel ctl file.bin set limit=off
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX and a command are required")
+	}
+
+	prefix, command := fs.Arg(0), fs.Arg(1)
+
+	var request string
+	switch command {
+	case "get":
+		request = "get"
+	case "set":
+		if fs.NArg() < 3 {
+			return fmt.Errorf("set needs at least one KEY=VALUE")
+		}
+		settings := []string{"set"}
+		for _, kv := range fs.Args()[2:] {
+			normalized, err := normalizeSetting(kv)
+			if err != nil {
+				return err
+			}
+			settings = append(settings, normalized)
+		}
+		request = strings.Join(settings, " ")
+	default:
+		return fmt.Errorf("unknown command %q (known: get, set)", command)
+	}
+
+	reply, err := control.Send(control.SocketPath(prefix), request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(formatControlStatus(reply))
+	return nil
+}
+
+// normalizeSetting converts human-friendly values (limit=2M, limit=off) to
+// the plain numbers the control socket expects.
+func normalizeSetting(kv string) (string, error) {
+	key, value, ok := strings.Cut(kv, "=")
+	if !ok {
+		return "", fmt.Errorf("invalid setting %q, want KEY=VALUE", kv)
+	}
+
+	if key == "limit" {
+		if value == "off" || value == "none" {
+			return "limit=0", nil
+		}
+		n, err := parseSize(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid limit %q: %w", value, err)
+		}
+		return "limit=" + strconv.FormatInt(n, 10), nil
+	}
+
+	return kv, nil
+}
+
+// formatControlStatus renders "jobs=4 limit=2000000" for humans.
+func formatControlStatus(status string) string {
+	var out []string
+	for _, kv := range strings.Fields(status) {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "limit" {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				if n == 0 {
+					value = "unlimited"
+				} else {
+					value = formatSize(n) + "/s"
+				}
+			}
+		}
+		out = append(out, key+"="+value)
+	}
+	return strings.Join(out, " ")
+}
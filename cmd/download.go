@@ -1,21 +1,43 @@
-// Package cmd implements the rapel CLI subcommands: download and merge.
+// Package cmd implements the rapel CLI subcommands, one Command function
+// each, which main picks by the first argument.
 package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/redraw/rapel/internal/config"
 	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/failure"
 	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/listing"
+	"github.com/redraw/rapel/internal/logging"
 	"github.com/redraw/rapel/internal/merger"
+	"github.com/redraw/rapel/internal/notify"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/rpc"
+	"github.com/redraw/rapel/internal/schedule"
+	"github.com/redraw/rapel/internal/stats"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/redraw/rapel/schema"
 )
 
 // DownloadCommand implements the download subcommand
@@ -23,16 +45,113 @@ func DownloadCommand(args []string) error {
 	fs := flag.NewFlagSet("download", flag.ExitOnError)
 
 	// Define flags
-	chunkSizeStr := fs.String("c", "100M", "Chunk size (e.g., 50M, 1G)")
-	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	logOpts := addLogFlags(fs)
+	profOpts := addProfileFlags(fs)
+	tlsOpts := addTLSFlags(fs)
+	fpOpts := addFingerprintFlags(fs)
+	chunkSizeStr := fs.String("c", "100M", "Chunk size (e.g., 50M, 1G, auto)")
+	maxChunks := fs.Int("max-chunks", downloader.DefaultMaxChunks, "Refuse to cut the file into more chunks than this (0 = no cap)")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050), or several comma-separated to spread requests over")
+	proxyMaxFailures := fs.Int("proxy-max-failures", 3, "With several -x proxies, take one out of rotation after this many connection failures in a row")
+	proxyCooldown := fs.Duration("proxy-cooldown", 30*time.Second, "With several -x proxies, how long a failing one stays out before it is probed again")
+	configPath := fs.String("config", "", "Config file (default: ~/.config/rapel/config.json if present)")
 	retries := fs.Int("r", 10, "Retries per request")
+	retryOnStatusStr := fs.String("retry-on-status", "", "Comma-separated HTTP statuses to retry even though they are client errors (e.g. 403,404)")
+	retryBudget := fs.Int("retry-budget", 0, "Give up after this many retries across all chunks (0 = unlimited)")
+	backoffPolicy := fs.String("backoff", downloader.BackoffExponential, "How the wait between retries grows: exponential, linear or constant")
+	backoffBase := fs.Duration("backoff-base", time.Second, "Unit of the wait between retries (exponential: 2x, 4x, ...; linear: 1x, 2x, ...; constant: 1x)")
+	backoffMax := fs.Duration("backoff-max", time.Minute, "Longest wait between retries")
+	jitter := fs.Float64("jitter", 0, "Take up to this fraction (0-1) off each wait between retries at random")
+	pace := fs.Duration("pace", 0, "Average gap between request starts, jittered 50-150% (e.g., 2s; 0 = off)")
+	banCooldown := fs.Duration("ban-cooldown", 0, "When the server starts refusing every worker with 403/429 after serving them, pause all requests this long (0 = off)")
+	banCmd := fs.String("ban-cmd", "", "Command to run when a ban cool-down starts, e.g. to rotate a proxy (supports {status})")
+	maxTime := fs.Duration("max-time", 0, "Give up once the whole download has taken this long, e.g. 2h (0 = no limit)")
 	noHead := fs.Bool("no-head", false, "Skip HEAD request (requires --size)")
 	sizeStr := fs.String("size", "", "Total size in bytes (required if --no-head)")
+	contentDisposition := fs.Bool("content-disposition", true, "Name the file after the server's Content-Disposition header when it sends one")
 	jobs := fs.Int("jobs", 1, "Concurrent chunks")
 	force := fs.Bool("force", false, "Force re-download even if state exists")
+	ifLocked := fs.String("if-locked", downloader.LockedFail, "When another rapel process is downloading the same file here: fail, wait or status")
+	ifExists := fs.String("if-exists", downloader.ExistsResume, "When the output file or another download's state already exists: resume, skip, overwrite, rename or ask")
+	skipComplete := fs.Bool("skip-complete", false, "Download nothing when the output file already has the remote's size (and MD5, when the server sends one)")
+	noVerifyDigest := fs.Bool("no-verify-digest", false, "Don't check the download against the checksums the server sends (Digest, Content-MD5, x-amz-checksum-*, x-goog-hash)")
+	checksumRepairs := fs.Int("checksum-repairs", 1, "When the file fails the server's checksum, download the chunks to blame again and check again, up to N times (0 = fail at once)")
+	continueFile := fs.Bool("continue-file", false, "Continue an output file left by a plain download (curl, wget) instead of downloading it again")
+	onCollision := fs.String("on-collision", downloader.CollisionError, "When another URL's unfinished download uses the filename a --plan gives: error, host, hash or overwrite")
+	recoverState := fs.Bool("recover", false, "Rebuild a corrupt or missing state file from backups or the chunk files on disk")
+	stateBackups := fs.Int("state-backups", 1, "Previous generations of each state file to keep as .1, .2, ... (0 = none)")
+	decompress := fs.Bool("decompress", false, "With --merge, decompress gzip/bzip2/zstd/xz/brotli data while merging")
+	mergeJobs := fs.Int("merge-jobs", 4, "With --merge, chunk files to copy into the output at once")
+	reflink := fs.Bool("reflink", false, "With --merge, share the chunks' disk blocks with the output instead of copying, where supported")
+	sparse := fs.Bool("sparse", false, "With --merge, leave blocks of zeros in the output as holes")
 	merge := fs.Bool("merge", false, "Merge chunks after download (auto-detects output name)")
-	postPart := fs.String("post-part", "", "Command to run after each part completes (supports {part}, {idx}, {base})")
-	postPartJobs := fs.Int("post-part-jobs", 0, "Max concurrent post-part commands (0 = unlimited)")
+	globoff := fs.Bool("globoff", false, "Take braces in the URL as they are rather than as a URL template")
+	recursive := fs.Bool("recursive", false, "Download every file under a directory URL (WebDAV or an HTML index) into a copy of its tree; implies --merge")
+	accept := fs.String("accept", "", "With --recursive, only download files whose names match one of these comma-separated globs (e.g. '*.iso,*.img')")
+	reject := fs.String("reject", "", "With --recursive, skip files whose names match one of these comma-separated globs")
+	postPart := addPostPartFlags(fs)
+	postPartBackfill := fs.Bool("post-part-backfill", false, "Run the post-part command for the chunks already on disk, without downloading")
+	writeManifest := fs.Bool("write-manifest", false, "Keep <prefix>.manifest.json with each chunk's byte range, size and SHA-256, and the whole file's once complete")
+	transferLog := fs.Bool("transfer-log", true, "Record every chunk request in .<prefix>-transfers.jsonl, for rapel report")
+	partMeta := fs.String("part-meta", "", "Also describe each part next to it: sidecar (<part>.json) or xattr (Linux only); implies --write-manifest")
+	fetchCmd := fs.String("fetch-cmd", "", "Fetch each byte range by running this command and reading its stdout (supports {url}, {start}, {end}, {idx}, {base})")
+	urlCmd := fs.String("url-cmd", "", "Run this command before every request and fetch the URL it prints, e.g. a freshly presigned one (supports {url}, {start}, {end}, {idx}, {base})")
+	pipePart := fs.String("pipe-part", "", "Stream each chunk into this command's stdin instead of writing to disk (supports {part}, {idx}, {base}, {start}, {end})")
+	hooks := addHookFlags(fs)
+	shell := fs.String("shell", "", "Shell for --post-part, --pipe-part, --fetch-cmd, --url-cmd and --ban-cmd: sh, bash, cmd, powershell, ... (default: sh, cmd on Windows)")
+	sequential := fs.Bool("sequential", false, "Fetch chunks strictly in order, one request at a time over one connection, for servers that refuse out-of-order ranges")
+	order := fs.String("order", downloader.OrderSequential, "Which pending chunks start first: sequential, random, rarest-last or tail-first")
+	coalesceStr := fs.String("coalesce", "1M", "Fetch adjacent chunks smaller than this in one request of up to this size (0 = off)")
+	packParts := fs.Int("pack-parts", 0, "Concatenate every N completed chunks into one file to save inodes (0 = off)")
+	maxConnsPerHost := fs.Int("max-conns-per-host", 0, "Max connections per host, busy or idle, shared by all chunks (0 = unlimited)")
+	maxPerHost := fs.Int("max-per-host", 0, "Max requests in flight to one host across all rapel downloads using these flags (0 = unlimited)")
+	maxTotalConns := fs.Int("max-total-connections", 0, "Max requests in flight across all rapel downloads using these flags (0 = unlimited)")
+	keepAlive := fs.Duration("keepalive", 30*time.Second, "TCP keep-alive probe interval (0 = off)")
+	tcpFastOpen := fs.Bool("tcp-fastopen", false, "Use TCP Fast Open for new connections (Linux only)")
+	var resolveFlags stringList
+	fs.Var(&resolveFlags, "resolve", "Connect to HOST:PORT at ADDR instead of what DNS returns: HOST:PORT:ADDR[,ADDR] (repeatable)")
+	pinIP := fs.Bool("pin-ip", false, "Keep connecting to the address each host was first reached at, even if DNS rotates")
+	sourceIP := fs.String("source-ip", "", "Local address to connect from")
+	iface := fs.String("interface", "", "Network interface to connect from")
+	dialTimeout := fs.Duration("dial-timeout", 30*time.Second, "Timeout for establishing a connection (TCP and TLS)")
+	probeTimeout := fs.Duration("probe-timeout", 15*time.Second, "Give up on the request that sizes the file after this long per attempt (0 = dial and read timeouts only)")
+	probeRetries := fs.Int("probe-retries", 2, "Retry the request that sizes the file this many times after a timeout or network error")
+	readBufferStr := fs.String("read-buffer", "32K", "Socket read buffer and copy size per connection (e.g., 256K)")
+	maxSkipStr := fs.String("max-skip", "16M", "When a range request gets the whole file, discard up to this many bytes to reach the range (0 = switch to a single stream at once)")
+	noEndgame := fs.Bool("no-endgame", false, "Don't split straggler chunks across extra connections near the end")
+	limitRateStr := fs.String("limit-rate", "", "Max download rate per second across all chunks (e.g., 500K, 2M)")
+	minSpeedStr := fs.String("min-speed", "", "Reconnect a chunk whose throughput stays below this rate per second (e.g., 100K)")
+	stallTimeout := fs.Duration("stall-timeout", 30*time.Second, "How long a chunk may stay below --min-speed before reconnecting")
+	diskThrottle := fs.Bool("disk-throttle", true, "Lower --jobs while the disk can't keep up with the writes, and raise it back once it does")
+	diskLimitStr := fs.String("disk-limit", "", "Max rate per second written to the chunk files, apart from --limit-rate (e.g., 200M)")
+	writeBufferStr := fs.String("write-buffer", "1M", "Bytes of each chunk gathered in memory before they are written to its file (0 = written as received)")
+	fsync := fs.String("fsync", storage.SyncChunk, "When chunk files are flushed to the disk: never, chunk (before each is marked complete) or interval")
+	fsyncInterval := fs.Duration("fsync-interval", 5*time.Second, "With --fsync interval, how often a chunk being written is flushed")
+	metricsListen := fs.String("metrics-listen", "", "Serve Prometheus metrics on this address (e.g., :9090)")
+	metricsFile := fs.String("metrics-file", "", "Write Prometheus metrics to this file every 5s (node_exporter textfile collector)")
+	notifyURL := fs.String("notify-url", "", "POST a JSON event to this URL on start, completion and failure")
+	notifyDesktop := fs.Bool("notify-desktop", false, "Show a desktop notification (or ring the terminal bell) when done")
+	eventsFD := fs.Int("events-fd", 0, "Write length-prefixed JSON lifecycle events to this inherited file descriptor (e.g., 3)")
+	eventsFile := fs.String("events-file", "", "Write length-prefixed JSON lifecycle events to this file or named pipe")
+	rpcSocket := fs.String("rpc-socket", "", "Serve a JSON-RPC interface to follow and control the download on this Unix socket")
+	encryptParts := fs.String("encrypt-parts", "", "Encrypt .tmp/.part files with a passphrase from env:VAR or file:PATH")
+	growing := fs.Duration("growing", 0, "The file is still being written: keep fetching as it grows, finishing once its size is unchanged for this long (e.g. 5m)")
+	growInterval := fs.Duration("grow-interval", 30*time.Second, "With --growing, how often to re-check the file's size")
+	follow := fs.Bool("follow", false, "Append new bytes to the output file as they appear, like tail -f, resuming where the last run stopped")
+	followInterval := fs.Duration("follow-interval", 2*time.Second, "With --follow, how often to poll once caught up")
+	followIdle := fs.Duration("follow-idle", 0, "With --follow, stop once the file hasn't grown for this long (0 = never)")
+	storageURL := fs.String("storage", "", "Write chunks straight to this storage instead of local files (s3://bucket/key)")
+	deadlineStr := fs.String("deadline", "", "Finish by this time (e.g., 6h, 07:00, 2026-01-02T07:00:00Z): warn early if impossible, lifting --limit-rate if needed")
+	onlyChunksStr := fs.String("only-chunks", "", "Re-fetch only these chunks, e.g. 5,17,200-230 (others are left untouched)")
+	planFile := fs.String("plan", "", "Download only the chunks of this plan from 'rapel plan split'")
+	byteRangeStr := fs.String("byte-range", "", "Re-fetch only the chunks overlapping these byte ranges, e.g. 1G-2G (end exclusive)")
+	outputDirFlag := fs.String("output-dir", "", "With --merge, write the merged file to this directory")
+	scheduleStr := fs.String("schedule", "", "Only download in these daily windows, e.g. '23:00-07:00' or '23:00-07:00,12:00-13:00@500K'")
+	workdir := fs.String("workdir", "", "Keep chunks and relative --log-file in DIR ('auto' = <prefix>.rapel)")
+	tui := fs.Bool("tui", false, "Show interactive dashboard with per-chunk progress")
+	chunkMap := fs.Bool("chunk-map", false, "Add a map of the chunks (# done, - in progress, . pending) to the progress line")
+	dryRunFlag := fs.Bool("dry-run", false, "Print the chunk plan, Range support, time and disk estimates, then exit without downloading")
+	assumeSpeedStr := fs.String("assume-speed", "", "With --dry-run, estimate the time at this rate per second (e.g., 10M)")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage: rapel download [options] URL
@@ -41,31 +160,400 @@ Download a file using chunked HTTP Range requests with resume support.
 
 IMPORTANT: Flags must be specified BEFORE the URL.
 
+A URL with {001..120} (numeric range, zero-padded like the bounds) or {a,b,c}
+downloads every file it expands to, each as its own download, with --jobs
+shared between them. Quote it so the shell doesn't expand it first, or give
+--globoff for a URL whose braces are part of it.
+
+hf://org/repo/path (hf://datasets/... or hf://spaces/... for those repos,
+org/repo@rev for a revision), zenodo://record/file and ia://item/path
+download a file from Hugging Face, Zenodo or the Internet Archive. HF_TOKEN
+and ZENODO_TOKEN are sent to the hub for private files.
+
 Options:
-  -c SIZE            Chunk size (K, M, G suffix). Default: 100M
-  -x URL             Proxy URL (e.g., socks5h://127.0.0.1:9050)
-  -r N               Retries per request. Default: 10
+  -c SIZE            Chunk size (K, M, G or Ki, Mi, Gi suffix). Default: 100M.
+                     A -c larger than the file is refused with a suggested
+                     size. auto picks one from the file size, --jobs and the
+                     HEAD round trip. On resume, another -c re-slices the
+                     chunks not started
+  --max-chunks N     Refuse a -c that cuts the file into more than N chunks
+                     (each is a file). Default: 100000 (0 = no cap)
+  -x URL             Proxy URL (e.g., socks5h://127.0.0.1:9050). Default:
+                     HTTPS_PROXY or HTTP_PROXY from the environment.
+                     Hosts in NO_PROXY and config proxy_rules take precedence.
+                     Several, comma-separated, share the requests; one whose
+                     connections keep failing is left out for a while
+  --proxy-max-failures N  With several -x proxies, take one out of rotation
+                     after N resets, timeouts or cut-short bodies in a row.
+                     Default: 3
+  --proxy-cooldown D How long a failing proxy stays out before the next
+                     request probes it; each failed probe doubles it (up to
+                     10m). Default: 30s
+  --config FILE      Config file. Default: ~/.config/rapel/config.json if present
+  -r N               Retries per request. Default: 10. Client errors (4xx) other
+                     than 408, 425 and 429 fail at once
+  --retry-on-status LIST  Also retry these comma-separated statuses, e.g.
+                     403,500,502 for a host whose auth layer fails now and then
+  --retry-budget N   Give up after N retries in total across all chunks, instead
+                     of each chunk retrying -r times on its own. Default: 0 (off)
+  --backoff POLICY   How the wait between retries grows: exponential (base
+                     x2, x4, x8, ...), linear (x1, x2, x3, ...) or constant.
+                     Default: exponential
+  --backoff-base D   Unit of the wait between retries. Default: 1s
+  --backoff-max D    Longest wait between retries. Default: 1m
+  --jitter F         Take up to fraction F (0-1) off each wait at random, so
+                     chunks that failed together retry apart. Default: 0
+  --pace D           Start requests at least about D apart (each gap jittered
+                     50-150%%), including retries and endgame helpers, instead
+                     of all --jobs at once. Default: 0 (off)
+  --ban-cooldown D   When every worker is suddenly refused with 403/429 after
+                     data was flowing, hold all requests for D (or the server's
+                     Retry-After, if longer) instead of burning retries on the
+                     ban; the refused attempts aren't counted. Default: 0 (off)
+  --ban-cmd CMD      Run CMD when a cool-down starts, e.g. to rotate a proxy or
+                     request a new Tor circuit. Placeholder: {status}
+  --max-time D       Give up once the download has taken D (e.g. 2h), keeping the
+                     finished chunks for a later resume. Default: 0 (no limit)
   --no-head          Skip HEAD request (requires --size)
   --size BYTES       Total size in bytes (required if --no-head)
+  --content-disposition=false  Name chunks after the URL even when the server
+                     suggests a filename (only asked for when sizing with HEAD)
   --jobs N           Concurrent chunks. Default: 1
-  --force            Force re-download even if state exists
+  --force            Force re-download even if state exists (--if-exists overwrite)
+  --if-exists P      When the output file already exists, or saved state for
+                     another URL or size: resume (default: resume this
+                     download's state, download again over the output file,
+                     refuse another's state), skip (download nothing),
+                     overwrite (start over), rename (download as
+                     "file (1).bin") or ask (on the terminal)
+  --skip-complete    Download nothing when the output file already has the
+                     remote's size, and its MD5 when the server sends one
+                     (Content-MD5, or an S3-style ETag)
+  --no-verify-digest Don't check the download against the checksums the
+                     server sends: Digest, Repr-Digest, Content-MD5,
+                     x-amz-checksum-* or x-goog-hash for the whole file, and
+                     Content-MD5 or Content-Digest for each range
+  --checksum-repairs N  When the file fails the server's checksum, find the
+                     chunks to blame (against the manifest, or else by
+                     downloading each again to compare), download them again
+                     and check again, up to N times. Default: 1 (0 = fail)
+  --continue-file    Continue an output file without saved state, such as one
+                     curl or wget left unfinished, fetching only the rest;
+                     implies --skip-complete
+  --if-locked P      When another rapel process is downloading the same file in
+                     this directory: fail (default, "download already in
+                     progress"), wait (until it exits, then run as if started
+                     then) or status (show its progress, read-only, until it
+                     exits; the merge is left to it)
+  --on-collision P   When an unfinished download of another URL already uses the
+                     filename a --plan gives in this directory (the chunks and
+                     state of other downloads carry the URL hash, so never clash):
+                     error (default), host or hash (rename to latest-<host>.tar.gz
+                     or latest-<urlhash>.tar.gz), or overwrite (replace it)
+  --recover          If the state file is corrupt or missing, restore the newest
+                     matching backup, or rebuild it from the .part/.tmp files on
+                     disk and the size the server reports
+  --state-backups N  Keep N previous generations of each state file (.1 newest).
+                     Default: 1 (0 = none)
   --merge            Merge chunks after download (auto-detects output name)
+  --globoff          Take { and } in the URL as they are, not as a template
+  --recursive        URL is a directory: list it with WebDAV PROPFIND, or
+                     follow the links of its HTML index page, and download
+                     every file below it, each chunked like a URL template's,
+                     into a directory named after URL's (in --output-dir if
+                     given) with the same tree. Implies --merge
+  --accept LIST      With --recursive, only files whose names match one of
+                     these comma-separated globs, e.g. '*.iso,*.img'
+  --reject LIST      With --recursive, skip files whose names match one of
+                     these globs, even if accepted
+  --decompress       With --merge, decompress gzip/bzip2/zstd/xz/br data while
+                     merging; chunks stay compressed so resume is unaffected
+  --merge-jobs N     With --merge, copy N chunk files into the preallocated
+                     output at once. Default: 4
+  --reflink          With --merge, clone the chunks into the output (Btrfs,
+                     XFS, ...) instead of copying them where possible
+  --sparse           With --merge, leave 4 KiB blocks of zeros in the output as
+                     holes that take no disk space
   --post-part CMD    Command to run after each part completes
-                     Placeholders: {part} {idx} {base}
+                     Placeholders: {part} {idx} {base} {start} {end}
+  --post-part-exec CMD
+                     Like --post-part, but run CMD directly instead of through
+                     a shell: it is split into words (quotes keep spaces) and
+                     placeholders are filled into each word, so file names
+                     can't inject shell syntax
   --post-part-jobs N Max concurrent post-part commands. Default: 0 (unlimited)
-
+  --post-part-timeout DUR
+                     Kill a post-part command running longer than DUR
+  --post-part-retries N
+                     Run a failed post-part command up to N more times
+  --post-part-retry-on LIST
+                     Only retry these comma-separated exit statuses, e.g.
+                     1,75 (a timeout is always retried). Default: any
+  --post-part-backoff-base D
+                     Unit of the wait between post-part retries, doubled each
+                     time. Default: 500ms (1s, 2s, 4s, ...)
+  --post-part-backoff-max D
+                     Longest wait between post-part retries. Default: 30s
+  --post-part-max-failures N
+                     Stop the download once N post-part commands failed after
+                     all their retries. Failed ones are listed by rapel status
+  --post-part-backfill
+                     Don't download: run the post-part command for the chunks
+                     already on disk, e.g. after adding or fixing it (same as
+                     rapel hooks run)
+  --write-manifest   Keep <prefix>.manifest.json listing each chunk's byte
+                     range, size and SHA-256, hashed before --post-part runs,
+                     plus the whole file's SHA-256 once complete, for
+                     rapel audit and rapel merge --verify
+  --transfer-log=false  Don't record every chunk request (times, bytes,
+                     attempt, host) in .<prefix>-transfers.jsonl, which
+                     rapel report summarizes
+  --part-meta MODE   Also record each chunk's file, URL, byte range and hashes
+                     next to it, for parts consumed without the state files:
+                     sidecar (<part>.json) or xattr (user.rapel.part, Linux
+                     only). Implies --write-manifest
+  --pipe-part CMD    Stream each chunk into CMD's stdin; nothing is written to disk
+                     Placeholders: {part} {idx} {base} {start} {end}
+  --fetch-cmd CMD    Fetch each byte range from CMD's stdout instead of HTTP GET
+                     Placeholders: {url} {start} {end} {idx} {base}
+                     Non-HTTP URLs need --size
+  --url-cmd CMD      Run CMD before every request (each chunk attempt) and send
+                     it to the URL CMD prints, for URLs signed for minutes;
+                     URL then only names the download. The file is sized with
+                     a one-byte GET, as signed URLs rarely allow HEAD
+                     Placeholders: {url} {start} {end} {idx} {base}
+  --hook-env KEY[=V] Pass KEY (or set KEY=V) in the hook environment; repeatable.
+                     Hooks otherwise get only PATH, HOME, USER, LANG and similar
+  --hook-inherit-env Give hooks rapel's full environment
+  --hook-dir DIR     Working directory for hooks ({part} becomes absolute)
+  --hook-no-network  Run hooks in an empty network namespace (Linux only)
+  --shell SHELL      Shell for --post-part, --pipe-part, --fetch-cmd, --url-cmd
+                     and --ban-cmd: sh, bash, cmd, powershell, pwsh, ...
+                     Default: sh (cmd on Windows)
+  --sequential       Fetch chunks strictly in file order, one request at a time
+                     over one kept-alive connection, for servers that refuse
+                     ranges that go backwards or overlap in one session. Chunk
+                     files, resume and hooks work as usual; implies --jobs 1
+                     and --no-endgame
+  --order O          Which pending chunks start first: sequential (default,
+                     from the start of the file), random, rarest-last (chunks
+                     with partial data first) or tail-first (the last chunk,
+                     then the rest from the start, so a player can probe a
+                     partial merge for headers and indexes at the end)
+  --coalesce SIZE    Fetch adjacent pending chunks smaller than SIZE with one
+                     request of up to SIZE; each still gets its own file.
+                     Default: 1M (0 = off)
+  --pack-parts N     Concatenate each run of N completed chunks into one
+                     <prefix>.NNNNNN-MMMMMM.part file. Default: 0 (off)
+  --max-conns-per-host N  Cap connections per host, busy or idle (0 = unlimited).
+                     All chunks share one connection pool
+  --max-per-host N   Cap requests in flight to one host across every rapel
+                     download on this machine that passes it, so parallel
+                     downloads from one origin don't add up (0 = unlimited)
+  --max-total-connections N  Same, across all hosts (0 = unlimited)
+  --keepalive D      TCP keep-alive probe interval. Default: 30s (0 = off)
+  --tcp-fastopen     Use TCP Fast Open for new connections (Linux only)
+  --resolve HOST:PORT:ADDR[,ADDR]  Connect to HOST:PORT at ADDR instead of
+                     what DNS returns (PORT may be *); repeatable
+  --pin-ip           Keep connecting to the address each host was first
+                     reached at, so DNS rotation can't switch CDN edges
+  --source-ip ADDR   Local address to connect from
+  --interface NAME   Network interface to connect from
+  --dial-timeout D   Timeout for connecting, including TLS. Default: 30s
+  --probe-timeout D  Limit on each attempt of the HEAD request that sizes the
+                     file, so a dead host fails fast. Default: 15s
+                     (0 = dial and read timeouts only)
+  --probe-retries N  Attempts after a timed-out or failed HEAD. Default: 2
+  --read-buffer SIZE Read buffer per connection (K, M suffix). Default: 32K
+  --max-skip SIZE    When the server answers a range request with the whole
+                     file, read and discard up to SIZE bytes to reach the
+                     range; further in, the rest is fetched in a single
+                     stream. Default: 16M (0 = single stream at once)
+  --no-endgame       Don't split straggler chunks across extra connections
+                     once 95%% of the file is downloaded
+  --limit-rate SIZE  Max download rate per second (K, M, G suffix). Default: unlimited
+  --min-speed SIZE   Reconnect a chunk whose speed stays below SIZE/s (K, M, G suffix).
+                     Default: off (wait for the read timeout)
+  --stall-timeout D  How long a chunk may stay below --min-speed. Default: 30s
+  --disk-throttle=false  Keep --jobs even while writing the chunks takes
+                     most of the transfer time (slow, SMR or USB disks)
+  --disk-limit SIZE  Max rate per second written to the chunk files (K, M, G
+                     suffix), to leave disk bandwidth to other programs.
+                     Default: unlimited
+  --write-buffer SIZE  Gather this much of each chunk in memory between
+                     writes to its file (K, M suffix). Default: 1M (0 = off)
+  --fsync P          When chunk files are flushed to the disk: never (leave
+                     it to the OS), chunk (before each is renamed to .part)
+                     or interval (also every --fsync-interval). Default: chunk
+  --fsync-interval D How often --fsync interval flushes. Default: 5s
+  --metrics-listen ADDR  Serve Prometheus metrics at http://ADDR/metrics
+  --metrics-file PATH    Rewrite PATH with the same metrics every 5s
+  --notify-url URL   POST JSON to URL on start, complete and error (with size,
+                     duration, and the merged file's SHA-256)
+  --notify-desktop   Desktop notification when done (notify-send, osascript,
+                     or the terminal bell)
+  --events-fd N      Write lifecycle events for wrapper programs to inherited
+                     file descriptor N: each a 4-byte big-endian length, then JSON
+  --events-file PATH Same, written to a file or named pipe
+  --rpc-socket PATH  Serve JSON-RPC 2.0 on a Unix socket at PATH, for GUIs: the
+                     status, events and progress, pause, resume, set and cancel
+  --encrypt-parts SRC  Encrypt .tmp/.part files (AES-256-GCM) with the passphrase
+                     in env:VAR or file:PATH; merging decrypts them. Turns off
+                     the endgame, whose tails would sit unencrypted on disk
+  --growing D        The file is still being written (a log, a recording): after
+                     fetching what exists, re-check its size every
+                     --grow-interval and fetch the new data, finishing once the
+                     size has been unchanged for D (e.g. 5m). Default: 0 (off)
+  --grow-interval D  How often --growing re-checks the size. Default: 30s
+  --follow           Tail the file: write its bytes to one output file (named
+                     like the merged file, in --output-dir if given) and keep
+                     requesting what comes after, appending new bytes as they
+                     appear. No chunks. Runs until interrupted, --max-time or
+                     --follow-idle; run again to resume where it stopped
+  --follow-interval D  How often --follow polls once caught up. Default: 2s
+  --follow-idle D    Stop following once the file hasn't grown for D.
+                     Default: 0 (never)
+  --storage URL      Upload each chunk as a part of an S3 multipart upload to
+                     s3://bucket/key and complete it at the end; nothing is
+                     merged locally. Chunks need -c 5Mi or more. Credentials from
+                     AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, AWS_REGION,
+                     AWS_ENDPOINT_URL for S3-compatible services
+  --deadline WHEN    Finish by WHEN: a duration (6h), a local time (07:00, the
+                     next one) or RFC 3339. Warns as soon as the current speed
+                     can't make it and lifts --limit-rate when it is in the way
+  --only-chunks LIST Re-fetch only these chunks (e.g. 5,17,200-230), discarding
+                     what is stored for them; other chunks are left untouched
+  --plan FILE        Download only the chunks of a plan from 'rapel plan split',
+                     with its size and chunk size, resuming them as usual; the
+                     other chunks are left to the other plans' machines
+  --byte-range LIST  Re-fetch only the chunks overlapping these byte ranges
+                     (e.g. 1G-2G; END exclusive, empty END = end of file)
+  --output-dir DIR   With --merge, write the merged file to DIR (copied if on
+                     another filesystem, needing its size free on both)
+  --schedule SPEC    Only download inside daily local-time windows, pausing
+                     outside them; '@RATE' sets a window's rate limit
+                     (e.g., '23:00-07:00,12:00-13:00@500K')
+  --workdir DIR      Keep chunks and a relative --log-file in DIR;
+                     'auto' uses <prefix>.rapel, named after the file an
+                     hf://, zenodo:// or ia:// identifier resolves to (not
+                     for a URL template). --merge writes the output here
+  --tui              Interactive dashboard; keys: +/- jobs, [/] rate limit, q quit
+  --chunk-map        Add a map of the chunks to the progress line: # done,
+                     - in progress, . pending, up to 24 cells
+  --dry-run          Size the file, check Range support with a 1 MB sample, and
+                     print the chunk plan, what a resume would reuse, the time
+                     it would take and the disk space needed, then exit
+                     without writing anything. Fails if something would stop
+                     the download
+  --assume-speed SIZE  Speed for the --dry-run time estimate. Default:
+                     --limit-rate, else the sampled speed times --jobs
+%s%s%s%s
 Examples:
   rapel download https://example.com/file.bin
   rapel download -c 50M --jobs 4 https://example.com/file.bin
   rapel download -x socks5h://127.0.0.1:9050 https://example.com/file.bin
+  rapel download --jobs 8 -x socks5h://a@127.0.0.1:9050,socks5h://b@127.0.0.1:9050 https://example.com/file.bin
+  rapel download --cacert corp-ca.pem --cert me.pem --key me.key https://mirror.internal/file.bin
   rapel download --merge https://example.com/file.bin
+  rapel download --dry-run -c 50M --jobs 8 --merge https://example.com/file.bin
+  rapel download --workdir auto --merge https://example.com/file.bin
+  rapel download --min-speed 100K --stall-timeout 30s https://example.com/file.bin
+  rapel download --tui --jobs 4 --limit-rate 5M https://example.com/file.bin
   rapel download --post-part 'rclone move {part} r2:bucket/' https://example.com/file.bin
-`)
+  rapel download --pipe-part 'rclone rcat r2:bucket/{part}' https://example.com/file.bin
+  rapel download --growing 10m --merge https://example.com/stream.ts
+  rapel download --follow https://example.com/logs/app.log
+  rapel download --size 1073741824 --fetch-cmd 'mytool --range {start}-{end} {url}' proto://host/file.bin
+  rapel download --url-cmd 'aws s3 presign {url} --expires-in 300' --hook-env AWS_PROFILE s3://bucket/file.bin
+  rapel download --jobs 8 --merge 'https://example.com/dataset/part-{001..120}.bin'
+  rapel download --recursive --accept '*.iso' --jobs 8 https://mirror.example.com/pub/isos/
+  rapel download -c auto --jobs 8 --merge hf://org/model/model.safetensors
+`, logUsage, tlsUsage, fingerprintUsage, profileUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Resolved before entering the workdir, which changes the meaning of
+	// relative paths
+	var outputDir string
+	if *outputDirFlag != "" {
+		abs, err := filepath.Abs(*outputDirFlag)
+		if err != nil {
+			return fmt.Errorf("invalid output dir: %w", err)
+		}
+		outputDir = abs
+	}
+	eventsPath := *eventsFile
+	if eventsPath != "" {
+		abs, err := filepath.Abs(eventsPath)
+		if err != nil {
+			return fmt.Errorf("invalid events file: %w", err)
+		}
+		eventsPath = abs
+	}
+	rpcPath := *rpcSocket
+	if rpcPath != "" {
+		abs, err := filepath.Abs(rpcPath)
+		if err != nil {
+			return fmt.Errorf("invalid RPC socket: %w", err)
+		}
+		rpcPath = abs
+	}
+	if *planFile != "" {
+		abs, err := filepath.Abs(*planFile)
+		if err != nil {
+			return fmt.Errorf("invalid plan file: %w", err)
+		}
+		*planFile = abs
+	}
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := fpOpts.config()
+	if err != nil {
+		return err
+	}
+	encryptKey, err := loadKey("--encrypt-parts", *encryptParts)
+	if err != nil {
+		return err
+	}
+
+	// Enter the workdir first so a relative --log-file lands inside it.
+	// The merged file goes where rapel was started unless --output-dir says otherwise.
+	if *workdir != "" && fs.NArg() > 0 {
+		dir := *workdir
+		if dir == "auto" {
+			if dir, err = autoWorkdir(fs.Arg(0), *globoff, *fetchCmd == "" && *urlCmd == ""); err != nil {
+				return err
+			}
+		}
+		origDir, err := enterWorkdir(dir, !*dryRunFlag)
+		if err != nil {
+			return err
+		}
+		if outputDir == "" {
+			outputDir = origDir
+		}
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
 	}
+	defer closeLog()
 
-	if err := fs.Parse(args); err != nil {
+	stopProfile, err := profOpts.start()
+	if err != nil {
 		return err
 	}
+	defer stopProfile()
+
+	if tlsConfig.Insecure {
+		slog.Warn("--insecure: server certificates are not verified")
+	}
 
 	if fs.NArg() < 1 {
 		fs.Usage()
@@ -74,11 +562,32 @@ Examples:
 
 	url := fs.Arg(0)
 
+	// A dry run only reports
+	if *dryRunFlag {
+		*eventsFD, eventsPath = 0, ""
+	}
+	eventsOut, err := events.Open(*eventsFD, eventsPath)
+	if err != nil {
+		return err
+	}
+	defer eventsOut.Close()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
 	// Parse chunk size
-	chunkSize, err := parseSize(*chunkSizeStr)
+	chunkSize, autoChunkSize, err := parseChunkSize(*chunkSizeStr, 100*1000*1000)
 	if err != nil {
-		return fmt.Errorf("invalid chunk size: %w", err)
+		return err
 	}
+	explicitChunkSize := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			explicitChunkSize = !autoChunkSize
+		}
+	})
 
 	// Parse total size if provided
 	var totalSize int64
@@ -89,97 +598,908 @@ Examples:
 		}
 	}
 
+	// Parse rate limit if provided
+	var rateLimit int64
+	if *limitRateStr != "" {
+		rateLimit, err = parseSize(*limitRateStr)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+	}
+
+	var diskLimit int64
+	if *diskLimitStr != "" {
+		diskLimit, err = parseSize(*diskLimitStr)
+		if err != nil {
+			return fmt.Errorf("invalid disk limit: %w", err)
+		}
+	}
+	writeBuffer, err := parseSize(*writeBufferStr)
+	if err != nil || writeBuffer < 0 {
+		return fmt.Errorf("invalid --write-buffer: %s", *writeBufferStr)
+	}
+	if !slices.Contains(storage.SyncPolicies, *fsync) {
+		return fmt.Errorf("invalid --fsync %q: use %s", *fsync, strings.Join(storage.SyncPolicies, ", "))
+	}
+	if *fsyncInterval <= 0 {
+		return fmt.Errorf("--fsync-interval must be positive")
+	}
+
+	// Parse minimum speed if provided
+	var minSpeed int64
+	if *minSpeedStr != "" {
+		minSpeed, err = parseSize(*minSpeedStr)
+		if err != nil {
+			return fmt.Errorf("invalid min speed: %w", err)
+		}
+	}
+
+	readBuffer, err := parseSize(*readBufferStr)
+	if err != nil || readBuffer <= 0 {
+		return fmt.Errorf("invalid read buffer size: %s", *readBufferStr)
+	}
+	maxSkip, err := parseSize(*maxSkipStr)
+	if err != nil || maxSkip < 0 {
+		return fmt.Errorf("invalid --max-skip: %s", *maxSkipStr)
+	}
+	var resolve []httpclient.Resolve
+	for _, r := range resolveFlags {
+		rule, err := httpclient.ParseResolve(r)
+		if err != nil {
+			return err
+		}
+		resolve = append(resolve, rule)
+	}
+	if *sourceIP != "" && *iface != "" {
+		return fmt.Errorf("--source-ip cannot be combined with --interface")
+	}
+	if *proxyMaxFailures < 1 || *proxyCooldown <= 0 {
+		return fmt.Errorf("--proxy-max-failures must be at least 1 and --proxy-cooldown positive")
+	}
+
+	var assumeSpeed int64
+	if *assumeSpeedStr != "" {
+		if !*dryRunFlag {
+			return fmt.Errorf("--assume-speed requires --dry-run")
+		}
+		if assumeSpeed, err = parseSize(*assumeSpeedStr); err != nil || assumeSpeed <= 0 {
+			return fmt.Errorf("invalid --assume-speed: %s", *assumeSpeedStr)
+		}
+	}
+
+	if !slices.Contains(downloader.Orders, *order) {
+		return fmt.Errorf("invalid --order %q: use %s", *order, strings.Join(downloader.Orders, ", "))
+	}
+	if *sequential && (*jobs > 1 || *order != downloader.OrderSequential) {
+		return fmt.Errorf("--sequential cannot be combined with --jobs above 1 or another --order")
+	}
+
+	// Parse coalescing limit
+	coalesce, err := parseSize(*coalesceStr)
+	if err != nil {
+		return fmt.Errorf("invalid coalesce size: %w", err)
+	}
+
+	// Parse download schedule if provided
+	var sched schedule.Schedule
+	if *scheduleStr != "" {
+		sched, err = schedule.Parse(*scheduleStr, parseSize)
+		if err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+
 	// Validate --no-head requires --size
 	if *noHead && totalSize == 0 {
 		return fmt.Errorf("--no-head requires --size")
 	}
 
+	// Only HTTP URLs can be sized with a HEAD request
+	if *fetchCmd != "" && totalSize == 0 && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("--fetch-cmd with a non-HTTP URL requires --size")
+	}
+
+	// Signed URLs are fetched by rapel itself, in ranges
+	if *urlCmd != "" && (*fetchCmd != "" || *follow || *growing > 0) {
+		return fmt.Errorf("--url-cmd cannot be combined with --fetch-cmd, --follow or --growing")
+	}
+
+	// A directory's files are each merged into their place in its tree
+	if *recursive {
+		if totalSize > 0 || *follow || *growing > 0 || *storageURL != "" || *pipePart != "" || *planFile != "" || *onlyChunksStr != "" ||
+			*byteRangeStr != "" || *postPartBackfill || *tui || *metricsListen != "" || *metricsFile != "" || *rpcSocket != "" ||
+			*ifExists == downloader.ExistsAsk || *ifExists == downloader.ExistsRename {
+			return fmt.Errorf("--recursive cannot be combined with --size, --follow, --growing, --storage, --pipe-part, --plan, " +
+				"--only-chunks, --byte-range, --post-part-backfill, --tui, --metrics-listen, --metrics-file, --rpc-socket or --if-exists ask or rename")
+		}
+		*merge = true
+	} else if *accept != "" || *reject != "" {
+		return fmt.Errorf("--accept and --reject require --recursive")
+	}
+
+	if *decompress && !*merge {
+		return fmt.Errorf("--decompress requires --merge (or use 'rapel merge --decompress')")
+	}
+	if *mergeJobs < 1 {
+		return fmt.Errorf("--merge-jobs must be at least 1")
+	}
+	if *checksumRepairs < 0 {
+		return fmt.Errorf("--checksum-repairs must be at least 0")
+	}
+
+	if err := postPart.validate(); err != nil {
+		return err
+	}
+	hasPostPart := postPart.set()
+	if *postPartBackfill && (!hasPostPart || *dryRunFlag) {
+		return fmt.Errorf("--post-part-backfill needs --post-part or --post-part-exec, and cannot be combined with --dry-run")
+	}
+
+	// Piped chunks never exist on disk, so there is nothing to merge or hook
+	if *pipePart != "" && (*merge || hasPostPart) {
+		return fmt.Errorf("--pipe-part cannot be combined with --merge or --post-part")
+	}
+	if diskLimit > 0 && (*pipePart != "" || *storageURL != "") {
+		return fmt.Errorf("--disk-limit cannot be combined with --pipe-part or --storage, which write no chunk files")
+	}
+
+	var deadline time.Time
+	if *deadlineStr != "" {
+		if deadline, err = parseDeadline(*deadlineStr, time.Now()); err != nil {
+			return fmt.Errorf("invalid --deadline: %w", err)
+		}
+	}
+
+	var onlyChunks, byteRanges []downloader.Span
+	if *onlyChunksStr != "" {
+		if onlyChunks, err = downloader.ParseChunkSpans(*onlyChunksStr); err != nil {
+			return fmt.Errorf("invalid --only-chunks: %w", err)
+		}
+	}
+	if *byteRangeStr != "" {
+		if byteRanges, err = downloader.ParseByteSpans(*byteRangeStr, parseSize); err != nil {
+			return fmt.Errorf("invalid --byte-range: %w", err)
+		}
+	}
+	if (onlyChunks != nil || byteRanges != nil) && (*pipePart != "" || *storageURL != "") {
+		return fmt.Errorf("--only-chunks and --byte-range need local chunk files, not --pipe-part or --storage")
+	}
+
+	retryOnStatus, err := parseStatusList(*retryOnStatusStr)
+	if err != nil {
+		return fmt.Errorf("invalid --retry-on-status: %w", err)
+	}
+	if !slices.Contains(downloader.BackoffPolicies, *backoffPolicy) {
+		return fmt.Errorf("invalid --backoff %q: use %s", *backoffPolicy, strings.Join(downloader.BackoffPolicies, ", "))
+	}
+	if *backoffBase <= 0 || *backoffMax <= 0 {
+		return fmt.Errorf("--backoff-base and --backoff-max must be positive")
+	}
+	if *jitter < 0 || *jitter > 1 {
+		return fmt.Errorf("--jitter must be between 0 and 1")
+	}
+	backoff := downloader.Backoff{Policy: *backoffPolicy, Base: *backoffBase, Max: *backoffMax, Jitter: *jitter}
+	if *retryBudget < 0 || *maxTime < 0 || *stateBackups < 0 || *pace < 0 || *banCooldown < 0 {
+		return fmt.Errorf("--retry-budget, --max-time, --state-backups, --pace and --ban-cooldown cannot be negative")
+	}
+	if *banCmd != "" && *banCooldown == 0 {
+		return fmt.Errorf("--ban-cmd requires --ban-cooldown")
+	}
+
+	// Growing files are sized with HEAD, and chunks at the end change
+	// after they were first finished
+	if *growing < 0 || *growInterval <= 0 {
+		return fmt.Errorf("--growing cannot be negative and --grow-interval must be positive")
+	}
+	if *growing > 0 && (totalSize > 0 || hasPostPart || *pipePart != "" || *storageURL != "" || *packParts > 1 || onlyChunks != nil || byteRanges != nil) {
+		return fmt.Errorf("--growing cannot be combined with --size, --post-part, --pipe-part, --storage, --pack-parts, --only-chunks or --byte-range")
+	}
+
+	// Following writes one output file in order, with no chunks
+	if *followInterval <= 0 || *followIdle < 0 {
+		return fmt.Errorf("--follow-interval must be positive and --follow-idle cannot be negative")
+	}
+	if *follow && (*growing > 0 || *merge || totalSize > 0 || hasPostPart || *pipePart != "" || *storageURL != "" ||
+		*packParts > 1 || onlyChunks != nil || byteRanges != nil || *fetchCmd != "" || encryptKey != nil || *recoverState || *tui || *dryRunFlag) {
+		return fmt.Errorf("--follow cannot be combined with --growing, --merge, --size, --post-part, --pipe-part, --storage, --pack-parts, " +
+			"--only-chunks, --byte-range, --fetch-cmd, --encrypt-parts, --recover, --tui or --dry-run")
+	}
+
+	if !slices.Contains(downloader.CollisionPolicies, *onCollision) {
+		return fmt.Errorf("invalid --on-collision %q: use %s", *onCollision, strings.Join(downloader.CollisionPolicies, ", "))
+	}
+	// The object key in --storage and the --follow output are fixed up front
+	if *onCollision != downloader.CollisionError && (*storageURL != "" || *follow) {
+		return fmt.Errorf("--on-collision cannot be combined with --storage or --follow")
+	}
+
+	if !slices.Contains(downloader.ExistsPolicies, *ifExists) {
+		return fmt.Errorf("invalid --if-exists %q: use %s", *ifExists, strings.Join(downloader.ExistsPolicies, ", "))
+	}
+	if *force {
+		if *ifExists != downloader.ExistsResume && *ifExists != downloader.ExistsOverwrite {
+			return fmt.Errorf("--force is --if-exists overwrite, it cannot be combined with --if-exists %s", *ifExists)
+		}
+		*ifExists = downloader.ExistsOverwrite
+	}
+	if !slices.Contains(downloader.LockedPolicies, *ifLocked) {
+		return fmt.Errorf("invalid --if-locked %q: use %s", *ifLocked, strings.Join(downloader.LockedPolicies, ", "))
+	}
+	if *ifLocked != downloader.LockedFail && *follow {
+		return fmt.Errorf("--if-locked cannot be combined with --follow")
+	}
+	if *ifExists != downloader.ExistsResume && (*storageURL != "" || *follow) {
+		return fmt.Errorf("--if-exists cannot be combined with --storage or --follow")
+	}
+	if (*skipComplete || *continueFile) && (*ifExists != downloader.ExistsResume || *storageURL != "" || *follow || *growing > 0) {
+		return fmt.Errorf("--skip-complete and --continue-file cannot be combined with --force, --if-exists, --storage, --follow or --growing")
+	}
+	// The file's bytes become plain chunk files
+	if *continueFile && (*encryptParts != "" || *packParts > 1 || *pipePart != "") {
+		return fmt.Errorf("--continue-file cannot be combined with --encrypt-parts, --pack-parts or --pipe-part")
+	}
+
+	// Each file a template expands to is a download of its own, running
+	// side by side with the others in this process
+	urls, err := templateURLs(url, *globoff)
+	if err != nil {
+		return err
+	}
+	// hf://, zenodo:// and ia:// name a file the hub serves from an
+	// https:// URL. A command given the URL takes it as it is
+	var hubHeader http.Header
+	if *fetchCmd == "" && *urlCmd == "" {
+		if urls, hubHeader, err = resolveHubURLs(urls); err != nil {
+			return err
+		}
+		if len(urls) == 1 {
+			url = urls[0]
+		}
+	}
+	if len(urls) > 1 && (totalSize > 0 || *follow || *growing > 0 || *storageURL != "" || onlyChunks != nil || byteRanges != nil ||
+		*tui || *metricsListen != "" || *metricsFile != "" || *rpcSocket != "" || *ifExists == downloader.ExistsAsk) {
+		return fmt.Errorf("a URL template cannot be combined with --size, --follow, --growing, --storage, --only-chunks, " +
+			"--byte-range, --tui, --metrics-listen, --metrics-file, --rpc-socket or --if-exists ask")
+	}
+	if len(urls) > 1 && (*postPartBackfill || *recursive) {
+		return fmt.Errorf("a URL template cannot be combined with --post-part-backfill or --recursive")
+	}
+
+	// A plan fixes the file's name, size and chunks; merging waits until
+	// every machine's chunks are together
+	var subPlan *downloader.SubPlan
+	if *planFile != "" {
+		if totalSize > 0 || explicitChunkSize || autoChunkSize || onlyChunks != nil || byteRanges != nil || *growing > 0 || *follow ||
+			*storageURL != "" || *pipePart != "" || *merge || len(urls) > 1 || *ifExists == downloader.ExistsRename {
+			return fmt.Errorf("--plan cannot be combined with --size, -c, --only-chunks, --byte-range, --growing, --follow, " +
+				"--storage, --pipe-part, --merge, --if-exists rename or a URL template")
+		}
+		if subPlan, err = downloader.LoadSubPlan(*planFile); err != nil {
+			return err
+		}
+	}
+
+	// The socket follows a download of the file's chunks
+	if *rpcSocket != "" && (*follow || *postPartBackfill || *dryRunFlag) {
+		return fmt.Errorf("--rpc-socket cannot be combined with --follow, --post-part-backfill or --dry-run")
+	}
+
+	if *recoverState && (*force || *ifExists == downloader.ExistsOverwrite || *storageURL != "") {
+		return fmt.Errorf("--recover cannot be combined with --force, --if-exists overwrite or --storage")
+	}
+
+	if encryptKey != nil && (*pipePart != "" || *storageURL != "") {
+		return fmt.Errorf("--encrypt-parts cannot be combined with --pipe-part or --storage")
+	}
+
+	// Packed chunks no longer have their own .part file to hand to a hook
+	if *packParts > 1 && (hasPostPart || *pipePart != "") {
+		return fmt.Errorf("--pack-parts cannot be combined with --post-part or --pipe-part")
+	}
+
+	// The manifest lists the .part files, hashed where they were written
+	if *partMeta != "" {
+		if !slices.Contains(downloader.PartMetaModes, *partMeta) {
+			return fmt.Errorf("invalid --part-meta %q: use %s", *partMeta, strings.Join(downloader.PartMetaModes, ", "))
+		}
+		*writeManifest = true
+	}
+	if *writeManifest && (*pipePart != "" || *storageURL != "" || *packParts > 1) {
+		return fmt.Errorf("--write-manifest cannot be combined with --pipe-part, --storage or --pack-parts")
+	}
+
+	// Chunks that live elsewhere can't be merged, hooked or packed locally
+	var store storage.Storage
+	var s3Store *storage.S3
+	if *storageURL != "" {
+		if !strings.HasPrefix(*storageURL, "s3://") {
+			return fmt.Errorf("unsupported storage %q (supported: s3://bucket/key)", *storageURL)
+		}
+		if *merge || hasPostPart || *pipePart != "" || *packParts > 1 || *outputDirFlag != "" {
+			return fmt.Errorf("--storage cannot be combined with --merge, --post-part, --pipe-part, --pack-parts or --output-dir")
+		}
+		if !autoChunkSize && chunkSize < storage.S3MinPartSize {
+			return fmt.Errorf("S3 parts must be at least 5 MiB, use -c %d or more", storage.S3MinPartSize)
+		}
+		s3Store, err = storage.NewS3(*storageURL, downloader.DefaultPrefix(url))
+		if err != nil {
+			return err
+		}
+		s3Store.StateBackups = *stateBackups
+		s3Store.Retries = *retries
+		s3Store.Backoff = backoff.Delay
+		store = s3Store
+	}
+
+	var followOutput string
+	if *follow && outputDir != "" {
+		followOutput = filepath.Join(outputDir, downloader.DefaultPrefix(url))
+	}
+
+	// Connection slots shared with other rapel processes
+	if *maxPerHost < 0 || *maxTotalConns < 0 {
+		return fmt.Errorf("--max-per-host and --max-total-connections cannot be negative")
+	}
+	var budget *httpclient.Budget
+	if *maxPerHost > 0 || *maxTotalConns > 0 {
+		dataDir, err := registry.DataDir()
+		if err != nil {
+			return fmt.Errorf("connection budget: %w", err)
+		}
+		budget = &httpclient.Budget{Dir: filepath.Join(dataDir, "slots"), PerHost: *maxPerHost, Total: *maxTotalConns}
+	}
+
+	// The merge counts towards the ETA
+	var mergeRate float64
+	mergeRateSource := ""
+	if *merge {
+		mergeRate, mergeRateSource = expectedMergeRate()
+	}
+
 	// Create downloader config
 	config := downloader.Config{
 		URL:                 url,
 		ChunkSize:           chunkSize,
+		ExplicitChunkSize:   explicitChunkSize,
+		AutoChunkSize:       autoChunkSize,
+		MaxChunks:           *maxChunks,
 		MaxConcurrency:      *jobs,
-		Force:               *force,
+		Force:               *ifExists == downloader.ExistsOverwrite,
+		OnCollision:         *onCollision,
+		IfExists:            *ifExists,
+		IfLocked:            *ifLocked,
+		SkipComplete:        *skipComplete,
+		ContinueFile:        *continueFile,
+		OutputDir:           outputDir,
+		Recover:             *recoverState,
+		MaxTime:             *maxTime,
+		RetryOnStatus:       retryOnStatus,
+		RetryBudget:         *retryBudget,
+		Backoff:             backoff,
+		StateBackups:        *stateBackups,
+		Pace:                *pace,
 		TotalSize:           totalSize,
-		PostPartCmd:         *postPart,
-		PostPartConcurrency: *postPartJobs,
+		ProbeTimeout:        *probeTimeout,
+		ProbeRetries:        *probeRetries,
+		ContentDisposition:  *contentDisposition,
+		PostPartCmd:         *postPart.cmd,
+		PostPartExec:        postPart.argv,
+		PostPartConcurrency: *postPart.jobs,
+		PostPartTimeout:     *postPart.timeout,
+		PostPartRetries:     *postPart.retries,
+		PostPartRetryOn:     postPart.retryOnList,
+		PostPartBackoff:     postPart.backoff(),
+		PostPartMaxFailures: *postPart.maxFailures,
+		PipePartCmd:         *pipePart,
+		FetchCmd:            *fetchCmd,
+		URLCmd:              *urlCmd,
+		RateLimit:           rateLimit,
+		TUI:                 *tui,
+		ChunkMap:            *chunkMap,
+		NoEndgame:           *noEndgame,
+		MinSpeed:            minSpeed,
+		DiskThrottle:        *diskThrottle,
+		DiskLimit:           diskLimit,
+		WriteBuffer:         int(writeBuffer),
+		Fsync:               *fsync,
+		FsyncInterval:       *fsyncInterval,
+		StallTimeout:        *stallTimeout,
+		MetricsListen:       *metricsListen,
+		MetricsFile:         *metricsFile,
+		Schedule:            sched,
+		Events:              eventsOut,
+		Order:               *order,
+		Sequential:          *sequential,
+		Coalesce:            coalesce,
+		PackParts:           *packParts,
+		Storage:             store,
+		Encrypt:             encryptKey,
+		BanCooldown:         *banCooldown,
+		BanCmd:              *banCmd,
+		Growing:             *growing,
+		GrowInterval:        *growInterval,
+		Follow:              *follow,
+		FollowOutput:        followOutput,
+		FollowInterval:      *followInterval,
+		FollowIdle:          *followIdle,
+		OnlyChunks:          onlyChunks,
+		ByteRanges:          byteRanges,
+		Deadline:            deadline,
+		ChecksumRepairs:     *checksumRepairs,
+		MergeRate:           mergeRate,
+		WriteManifest:       *writeManifest,
+		PartMeta:            *partMeta,
+		TransferLog:         *transferLog,
+		Hooks:               hooks.sandbox(*shell),
 		HTTPConfig: httpclient.Config{
-			ProxyURL:       *proxyURL,
-			MaxRetries:     *retries,
-			ConnectTimeout: 30 * time.Second,
-			ReadTimeout:    60 * time.Second,
+			ProxyURL:        *proxyURL,
+			ProxyRules:      cfg.ProxyRules,
+			Breaker:         httpclient.Breaker{MaxFailures: *proxyMaxFailures, Cooldown: *proxyCooldown},
+			MaxRetries:      *retries,
+			ConnectTimeout:  *dialTimeout,
+			ReadTimeout:     60 * time.Second,
+			MaxConnsPerHost: *maxConnsPerHost,
+			Budget:          budget,
+			KeepAlive:       keepAlivePeriod(*keepAlive),
+			TCPFastOpen:     *tcpFastOpen,
+			Resolve:         resolve,
+			PinIP:           *pinIP,
+			SourceIP:        *sourceIP,
+			Interface:       *iface,
+			ReadBufferSize:  int(readBuffer),
+			MaxSkip:         maxSkip,
+			TLS:             tlsConfig,
+			Fingerprint:     fingerprint,
+			NoVerifyDigest:  *noVerifyDigest,
+			Header:          hubHeader,
 		},
 	}
 
-	// Create downloader
-	dl, err := downloader.NewDownloader(config)
-	if err != nil {
-		return fmt.Errorf("failed to create downloader: %w", err)
+	if subPlan != nil {
+		if err := subPlan.Apply(&config, url); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Plan %d of %d: chunks %s of %s", subPlan.Part, subPlan.Parts, subPlan.Chunks, subPlan.Prefix),
+			"part", subPlan.Part, "parts", subPlan.Parts, "chunks", subPlan.Chunks)
 	}
 
 	// Setup signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var mirror *mirrorTree
+	if *recursive {
+		mirror, err = listMirror(ctx, config, url, outputDir, listing.Options{Accept: globList(*accept), Reject: globList(*reject)})
+		if err != nil {
+			return err
+		}
+		urls = mirror.urls
+	}
+
+	if *dryRunFlag {
+		opts := dryRunOptions{
+			jobs:        *jobs,
+			rateLimit:   rateLimit,
+			assumeSpeed: assumeSpeed,
+			merge:       *merge,
+			mergeRate:   mergeRate,
+			mergeSource: mergeRateSource,
+			outputDir:   outputDir,
+			remote:      store != nil || *pipePart != "",
+		}
+		if len(urls) > 1 || mirror != nil {
+			return dryRunTemplate(ctx, config, urls, mirror, opts)
+		}
+		dl, err := downloader.NewDownloader(config)
+		if err != nil {
+			return fmt.Errorf("failed to create downloader: %w", err)
+		}
+		return dryRun(ctx, dl, opts)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-sigChan
-		fmt.Println("\nReceived interrupt signal, shutting down...")
+		slog.Info("Received interrupt signal, shutting down...")
 		cancel()
 	}()
 
-	// Perform download
-	if err := dl.Download(ctx); err != nil {
-		if errors.Is(err, context.Canceled) {
-			fmt.Println("Download cancelled")
-			return nil
+	// The chunks are there already: only their hooks run
+	if *postPartBackfill {
+		dl, err := downloader.NewDownloader(config)
+		if err != nil {
+			return fmt.Errorf("failed to create downloader: %w", err)
 		}
-		return err
+		prefix := config.Prefix
+		if prefix == "" {
+			prefix = downloader.HashedPrefix(config.URL, downloader.DefaultPrefix(config.URL))
+		}
+		return dl.BackfillPostPart(ctx, prefix, false)
 	}
 
-	// Merge if requested
-	if *merge {
-		fmt.Println("\nMerging chunks...")
+	run := &downloadRun{
+		merge:     *merge,
+		follow:    *follow,
+		s3Store:   s3Store,
+		events:    eventsOut,
+		rpcSocket: rpcPath,
+		notifier:  &notify.Notifier{URL: *notifyURL, Desktop: *notifyDesktop},
+		mirror:    mirror,
+		mergeConfig: merger.Config{
+			Output:     "", // Auto-detect output name
+			Delete:     false,
+			Decompress: *decompress,
+			OutputDir:  outputDir,
+			Key:        encryptKey,
+			Jobs:       *mergeJobs,
+			Reflink:    *reflink,
+			Sparse:     *sparse,
+		},
+	}
+	if len(urls) > 1 || mirror != nil {
+		err = run.template(ctx, config, urls)
+	} else {
+		err = run.run(ctx, config)
+	}
+	if errors.Is(err, context.Canceled) {
+		slog.Info("Download cancelled")
+		return nil
+	}
+	return err
+}
+
+// downloadRun is what happens to a configured download: the transfer,
+// then completing the --storage upload or the --merge, and the events and
+// notifications reporting it.
+type downloadRun struct {
+	merge       bool
+	follow      bool
+	s3Store     *storage.S3
+	events      *events.Writer
+	rpcSocket   string // serve the --rpc-socket here
+	notifier    *notify.Notifier
+	mirror      *mirrorTree   // with --recursive, where each URL goes
+	mergeConfig merger.Config // Pattern is set per download
+}
+
+// run downloads config.URL and merges it or completes the upload.
+func (r *downloadRun) run(ctx context.Context, config downloader.Config) error {
+	// Another process mustn't start on the chunks while they are merged
+	config.HoldLock = r.merge || r.s3Store != nil
+	// The socket's subscribers get every event, so its server comes first
+	var rpcServer *rpc.Server
+	if r.rpcSocket != "" {
+		rpcServer = rpc.NewServer()
+		r.events = r.events.Tee(func(ev json.RawMessage) { rpcServer.Notify(schema.RPCNotifyEvent, ev) })
+		config.Events = r.events
+	}
+	dl, err := downloader.NewDownloader(config)
+	if err != nil {
+		return fmt.Errorf("failed to create downloader: %w", err)
+	}
+	defer dl.ReleaseLock()
+	if rpcServer != nil {
+		var stop func()
+		if ctx, stop, err = serveRPC(ctx, r.rpcSocket, rpcServer, dl); err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	url := config.URL
+	started := time.Now()
+	r.notifier.Notify(notify.Event{
+		Event: notify.EventStart,
+		URL:   redact.URL(url),
+		File:  downloader.DefaultPrefix(url),
+		Size:  config.TotalSize,
+	})
+
+	// Perform download, then merge if requested
+	var outputs []string
+	var took phaseTimes
+	err = func() error {
+		downloadStart := time.Now()
+		err := dl.Download(ctx)
+		took.download = time.Since(downloadStart)
+		if err != nil {
+			return err
+		}
+		if r.follow {
+			return nil
+		}
+
+		if r.s3Store != nil {
+			logging.Blank()
+			slog.Info("Completing upload to " + r.s3Store.URL())
+			if err := r.s3Store.Merge(ctx, dl.GetArguments().NumChunks()); err != nil {
+				return err
+			}
+			slog.Info("Upload complete: "+r.s3Store.URL(), "output", r.s3Store.URL())
+			outputs = []string{r.s3Store.URL()}
+			return nil
+		}
+
+		if !r.merge {
+			return nil
+		}
+		if missing := dl.MissingChunks(); missing > 0 {
+			return fmt.Errorf("cannot merge: %d chunks are still missing, run without --only-chunks/--byte-range to fetch them", missing)
+		}
+
+		logging.Blank()
+		if estimate := dl.MergeEstimate(); estimate > 0 {
+			slog.Info(fmt.Sprintf("Merging chunks (about %s)...", estimate.Round(time.Second)), "estimate_seconds", estimate.Seconds())
+		} else {
+			slog.Info("Merging chunks...")
+		}
+		mergeStart := time.Now()
 
 		args := dl.GetArguments()
-		pattern := fmt.Sprintf("%s.*.part", args.FilenamePrefix)
+		r.events.Emit(events.MergeStart, "file", args.FilenamePrefix)
 
-		m := merger.NewMerger(merger.Config{
-			Output:  "", // Auto-detect output name
-			Pattern: pattern,
-			Delete:  false,
-		})
+		mergeConfig := r.mergeConfig
+		mergeConfig.Pattern = fmt.Sprintf("%s.*.part", args.FilenamePrefix)
+		m := merger.NewMerger(mergeConfig)
 
-		if err := m.Merge(); err != nil {
+		err = m.Merge()
+		took.merge = time.Since(mergeStart)
+		if err != nil {
 			return fmt.Errorf("failed to merge: %w", err)
 		}
+		outputs = m.Outputs()
+		r.events.Emit(events.MergeComplete, "outputs", outputs, "seconds", took.merge.Seconds())
+		if err := stats.RecordMerge(args.TotalSize, took.merge); err != nil {
+			slog.Debug(fmt.Sprintf("cannot record merge stats: %v", err))
+		}
+		return nil
+	}()
+
+	emitDone(r.events, err, took)
+	if errors.Is(err, downloader.ErrSkipped) {
+		return nil
+	}
+
+	if r.notifier.Enabled() {
+		r.notifier.Notify(outcomeEvent(dl, url, started, took, outputs, err))
+	}
+	return err
+}
+
+// keepAlivePeriod maps --keepalive to the HTTP client's setting, where 0
+// means the default and a negative value turns probes off.
+func keepAlivePeriod(d time.Duration) time.Duration {
+	if d == 0 {
+		return -1
+	}
+	return d
+}
+
+// defaultMergeRate is the merge speed assumed, in bytes per second, until
+// a merge has been recorded in the stats.
+const defaultMergeRate = 200e6
+
+// expectedMergeRate returns the speed to estimate a merge with and where
+// it comes from.
+func expectedMergeRate() (float64, string) {
+	if s, err := stats.Load(); err == nil && s.MergeRate() > 0 {
+		return s.MergeRate(), "average of earlier merges"
+	}
+	return defaultMergeRate, "assumed"
+}
+
+// phaseTimes is how long each phase of a download run took.
+type phaseTimes struct {
+	download time.Duration
+	merge    time.Duration // 0 without --merge
+}
+
+// emitDone reports how the download ended, and how long its phases took,
+// on the event stream.
+func emitDone(w *events.Writer, err error, took phaseTimes) {
+	timing := []any{"download_seconds", took.download.Seconds()}
+	if took.merge > 0 {
+		timing = append(timing, "merge_seconds", took.merge.Seconds())
+	}
+	switch {
+	case err == nil:
+		w.Emit(events.Done, append([]any{"status", "complete"}, timing...)...)
+	case errors.Is(err, downloader.ErrSkipped):
+		w.Emit(events.Done, append([]any{"status", "skipped"}, timing...)...)
+	case errors.Is(err, context.Canceled):
+		w.Emit(events.Done, append([]any{"status", "cancelled"}, timing...)...)
+	default:
+		kind := failure.Classify(err)
+		w.Emit(events.Done, append([]any{"status", "error", "error", err, "error_kind", kind.Name, "exit_code", kind.Code}, timing...)...)
+	}
+}
+
+// outcomeEvent builds the notification for a finished download. The
+// checksum covers the merged file, so it is only set with --merge.
+func outcomeEvent(dl *downloader.Downloader, url string, started time.Time, took phaseTimes, outputs []string, err error) notify.Event {
+	ev := notify.Event{
+		Event:           notify.EventComplete,
+		URL:             redact.URL(url),
+		File:            downloader.DefaultPrefix(url),
+		DurationSeconds: time.Since(started).Seconds(),
+		DownloadSeconds: took.download.Seconds(),
+		MergeSeconds:    took.merge.Seconds(),
+	}
+	if args := dl.GetArguments(); args != nil {
+		ev.File = args.FilenamePrefix
+		ev.Size = args.TotalSize
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		ev.Event = notify.EventCancelled
+	case err != nil:
+		ev.Event = notify.EventError
+		ev.Error = err.Error()
+	case len(outputs) == 1:
+		ev.Output = outputs[0]
+		if strings.HasPrefix(outputs[0], "s3://") {
+			break
+		}
+		sum, err := fileSHA256(outputs[0])
+		if err != nil {
+			slog.Warn(fmt.Sprintf("cannot checksum %s: %v", outputs[0], err))
+		}
+		ev.SHA256 = sum
+	}
+
+	return ev
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// autoWorkdir names the directory --workdir auto keeps a download in,
+// after the file the URL stands for: a hub identifier is resolved first,
+// as the download will be, unless resolveHub is false because a command
+// is given the URL as it is. A template stands for several files, so it
+// names none.
+func autoWorkdir(url string, globoff, resolveHub bool) (string, error) {
+	urls, err := templateURLs(url, globoff)
+	if err != nil {
+		return "", err
+	}
+	if len(urls) > 1 {
+		return "", fmt.Errorf("--workdir auto can't name a directory after a URL template of %d files; give --workdir DIR", len(urls))
+	}
+	url = urls[0]
+	if resolveHub {
+		if url, _, err = resolveHubURL(url); err != nil {
+			return "", err
+		}
 	}
+	return downloader.DefaultPrefix(url) + ".rapel", nil
+}
+
+// enterWorkdir creates and changes into the per-download directory,
+// returning the directory rapel was started in. Without create, a
+// missing directory is left alone and the current one kept.
+func enterWorkdir(workdir string, create bool) (string, error) {
+	origDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	if !create {
+		if _, err := os.Stat(workdir); err != nil {
+			return origDir, nil
+		}
+	} else if err := os.MkdirAll(workdir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workdir: %w", err)
+	}
+	if err := os.Chdir(workdir); err != nil {
+		return "", fmt.Errorf("failed to enter workdir: %w", err)
+	}
+
+	return origDir, nil
+}
 
-	return nil
+// parseDeadline parses a duration from now, a local "HH:MM" (the next
+// occurrence) or an RFC 3339 time.
+func parseDeadline(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("%q is not in the future", s)
+		}
+		return now.Add(d), nil
+	}
+
+	if t, err := time.ParseInLocation("15:04", s, now.Location()); err == nil {
+		next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a duration, HH:MM or RFC 3339 time", s)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the past", s)
+	}
+	return t, nil
 }
 
-// parseSize parses a size string with K, M, G suffix
+// parseStatusList parses comma-separated HTTP status codes
+func parseStatusList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("%q is not an HTTP status", strings.TrimSpace(field))
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// parseChunkSize parses a -c value: a size, or "auto" to pick one once
+// the file's size is known (placeholder is returned as the size then).
+func parseChunkSize(s string, placeholder int64) (size int64, auto bool, err error) {
+	if s == "auto" {
+		return placeholder, true, nil
+	}
+	if size, err = parseSize(s); err != nil {
+		return 0, false, fmt.Errorf("invalid chunk size: %w", err)
+	}
+	return size, false, nil
+}
+
+// parseSize parses a size string with K, M, G suffix (powers of 1000) or
+// Ki, Mi, Gi suffix (powers of 1024)
 func parseSize(s string) (int64, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return 0, fmt.Errorf("empty size")
 	}
 
+	base := int64(1000)
+	if len(s) > 1 && (s[len(s)-1] == 'i' || s[len(s)-1] == 'I') {
+		base = 1024
+		s = s[:len(s)-1]
+	}
+
 	multiplier := int64(1)
 	suffix := s[len(s)-1]
 
 	switch suffix {
 	case 'K', 'k':
-		multiplier = 1000
+		multiplier = base
 		s = s[:len(s)-1]
 	case 'M', 'm':
-		multiplier = 1000 * 1000
+		multiplier = base * base
 		s = s[:len(s)-1]
 	case 'G', 'g':
-		multiplier = 1000 * 1000 * 1000
+		multiplier = base * base * base
 		s = s[:len(s)-1]
+	default:
+		if base == 1024 {
+			return 0, fmt.Errorf("invalid size %q: i needs a K, M or G before it", s+"i")
+		}
 	}
 
 	value, err := strconv.ParseInt(s, 10, 64)
@@ -189,3 +1509,19 @@ func parseSize(s string) (int64, error) {
 
 	return value * multiplier, nil
 }
+
+// parseExitStatusList parses comma-separated process exit statuses
+func parseExitStatusList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("%q is not an exit status (1-255)", strings.TrimSpace(field))
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoWorkdir(t *testing.T) {
+	tests := []struct {
+		url        string
+		resolveHub bool
+		want       string
+	}{
+		{"https://example.com/files/file.bin?sig=x", true, "file.bin.rapel"},
+		{"https://example.com/pub/isos/", true, "isos.rapel"},
+		// Named after the file the identifier resolves to
+		{"zenodo://123/data%20set.zip", true, "data set.zip.rapel"},
+		{"hf://org/repo@v1/weights/model.safetensors", true, "model.safetensors.rapel"},
+		// A template of one file is that file
+		{"https://example.com/part-{7..7}.bin", true, "part-7.bin.rapel"},
+		// --fetch-cmd and --url-cmd take the identifier as it is
+		{"zenodo://123/data.zip", false, "data.zip.rapel"},
+	}
+	for _, tt := range tests {
+		got, err := autoWorkdir(tt.url, false, tt.resolveHub)
+		require.NoError(t, err, tt.url)
+		assert.Equal(t, tt.want, got, tt.url)
+	}
+
+	_, err := autoWorkdir("https://example.com/part-{001..120}.bin", false, true)
+	assert.ErrorContains(t, err, "URL template of 120 files")
+	// --globoff takes the braces as they are
+	got, err := autoWorkdir("https://example.com/part-{001..120}.bin", true, true)
+	require.NoError(t, err)
+	assert.Equal(t, "part-{001..120}.bin.rapel", got)
+	_, err = autoWorkdir("zenodo://123", false, true)
+	assert.Error(t, err)
+}
+
+func TestEnterWorkdir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	start, err := os.Getwd()
+	require.NoError(t, err)
+
+	// A dry run doesn't create a missing directory, nor enter it
+	orig, err := enterWorkdir("f.rapel", false)
+	require.NoError(t, err)
+	assert.Equal(t, start, orig)
+	assert.NoDirExists(t, "f.rapel")
+
+	orig, err = enterWorkdir("f.rapel", true)
+	require.NoError(t, err)
+	assert.Equal(t, start, orig)
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(start, "f.rapel"), cwd)
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"100K", 100_000},
+		{"5M", 5_000_000},
+		{"2g", 2_000_000_000},
+		{"100Ki", 100 << 10},
+		{"5Mi", 5 << 20},
+		{"2GI", 2 << 30},
+		{" 6M ", 6_000_000},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, bad := range []string{"", "M", "Mi", "5i", "5Xi", "1.5M"} {
+		_, err := parseSize(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/logging"
+)
+
+// dryRunChunks is how many chunks at each end of the plan are listed.
+const dryRunChunks = 3
+
+// dryRunOptions are the download flags a dry run reports on.
+type dryRunOptions struct {
+	jobs        int
+	rateLimit   int64
+	assumeSpeed int64
+	merge       bool
+	mergeRate   float64 // bytes/s
+	mergeSource string  // where mergeRate comes from
+	outputDir   string
+	remote      bool // chunks go to --storage or --pipe-part, not local files
+}
+
+// dryRun prints what the download would do and returns an error if
+// something would stop it: a failed request, a state conflict, or too
+// little disk space.
+func dryRun(ctx context.Context, dl *downloader.Downloader, opts dryRunOptions) error {
+	plan, err := dl.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+
+	slog.Info("URL        : "+plan.URL, "url", plan.URL)
+	if plan.FromHeader {
+		slog.Info("File       : "+plan.File+" (from Content-Disposition)", "file", plan.File)
+	} else {
+		slog.Info("File       : "+plan.File, "file", plan.File)
+	}
+	slog.Info("Size       : "+formatSize(plan.Size), "bytes", plan.Size)
+	if plan.Skipped {
+		slog.Info("State      : the file already exists, it would be skipped (--if-exists skip)")
+		return nil
+	}
+	slog.Info("Chunk size : "+formatSize(plan.ChunkSize), "chunk_size", plan.ChunkSize)
+	complete, partial := plan.Count(downloader.ChunkComplete), plan.Count(downloader.ChunkPartial)
+	slog.Info(fmt.Sprintf("Chunks     : %d (%d complete, %d partial)", plan.Chunks(), complete, partial),
+		"chunks", plan.Chunks(), "complete", complete, "partial", partial)
+
+	switch probe := plan.Probe; {
+	case probe == nil:
+		slog.Info("Ranges     : not checked (--fetch-cmd)")
+	case probe.Error != "":
+		problems = append(problems, "the range request failed: "+probe.Error)
+		slog.Warn("Ranges     : request failed: "+probe.Error, "error", probe.Error)
+	case !probe.Ranges:
+		slog.Warn(fmt.Sprintf("Ranges     : not supported, the server answered %d instead of 206: the download would fall back to a single stream", probe.Status),
+			"status", probe.Status)
+	default:
+		slog.Info("Ranges     : supported", "status", probe.Status)
+	}
+
+	switch {
+	case plan.Conflict != nil:
+		problems = append(problems, plan.Conflict.Error())
+		slog.Warn("State      : " + plan.Conflict.Error())
+	case plan.Resume:
+		slog.Info("State      : resuming the saved download")
+	default:
+		slog.Info("State      : new download")
+	}
+
+	slog.Info("To fetch   : "+formatSize(plan.Remaining), "remaining", plan.Remaining)
+	eta := time.Duration(-1)
+	if speed, source := dryRunSpeed(plan, opts); speed > 0 {
+		eta = time.Duration(float64(plan.Remaining) / float64(speed) * float64(time.Second))
+		slog.Info(fmt.Sprintf("Time       : about %s at %s/s (%s)", eta.Round(time.Second), formatSize(speed), source),
+			"seconds", int64(eta.Seconds()), "speed", speed)
+	} else {
+		slog.Info("Time       : unknown, pass --assume-speed")
+	}
+	if opts.merge && opts.mergeRate > 0 {
+		merge := time.Duration(float64(plan.Size) / opts.mergeRate * float64(time.Second))
+		slog.Info(fmt.Sprintf("Merge      : about %s at %s/s (%s)", merge.Round(time.Second), formatSize(int64(opts.mergeRate)), opts.mergeSource),
+			"merge_seconds", int64(merge.Seconds()), "merge_speed", int64(opts.mergeRate))
+		if eta >= 0 {
+			slog.Info(fmt.Sprintf("Total      : about %s", (eta+merge).Round(time.Second)), "total_seconds", int64((eta + merge).Seconds()))
+		}
+	}
+
+	problems = append(problems, dryRunDisk(plan, opts)...)
+
+	if plan.Listed {
+		logging.Blank()
+		for i := 0; i < plan.Chunks(); i++ {
+			if i == dryRunChunks && plan.Chunks() > 2*dryRunChunks {
+				slog.Info(fmt.Sprintf("  ... %d more chunks", plan.Chunks()-2*dryRunChunks))
+				i = plan.Chunks() - dryRunChunks
+			}
+			start := int64(i) * plan.ChunkSize
+			end := start + plan.ChunkSize - 1
+			if end >= plan.Size {
+				end = plan.Size - 1
+			}
+			slog.Info(fmt.Sprintf("  chunk %d: bytes %d-%d (%s) %s", i, start, end, formatSize(end-start+1), chunkStatusName(plan.Status[i])),
+				"chunk", i, "start", start, "end", end)
+		}
+	}
+
+	logging.Blank()
+	if len(problems) > 0 {
+		return fmt.Errorf("dry run found %d problem(s): %s", len(problems), problems[0])
+	}
+	slog.Info("Dry run: nothing was downloaded to disk or changed")
+	return nil
+}
+
+// dryRunSpeed picks the speed to estimate the time with, and says where
+// it comes from.
+func dryRunSpeed(plan *downloader.Plan, opts dryRunOptions) (int64, string) {
+	switch {
+	case opts.assumeSpeed > 0:
+		return opts.assumeSpeed, "--assume-speed"
+	case opts.rateLimit > 0:
+		return opts.rateLimit, "--limit-rate"
+	case plan.Probe != nil && plan.Probe.Throughput > 0:
+		// A small sample over one connection; more jobs only help up to
+		// the link's capacity, so this is optimistic
+		return int64(plan.Probe.Throughput) * int64(opts.jobs), fmt.Sprintf("sampled speed of one connection x %d jobs", opts.jobs)
+	}
+	return 0, ""
+}
+
+// dryRunDisk reports the space the download needs next to what is free,
+// returning problems for filesystems that are too small.
+func dryRunDisk(plan *downloader.Plan, opts dryRunOptions) []string {
+	var problems []string
+	check := func(label, dir string, need int64) {
+		free, err := fsutil.FreeSpace(dir)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("%s: needs %s, free space unknown: %v", label, formatSize(need), err))
+			return
+		}
+		if need > free {
+			problems = append(problems, fmt.Sprintf("%s needs %s but only %s is free", dir, formatSize(need), formatSize(free)))
+			slog.Warn(fmt.Sprintf("%s: needs %s, only %s free in %s", label, formatSize(need), formatSize(free), dir),
+				"needed", need, "free", free)
+			return
+		}
+		slog.Info(fmt.Sprintf("%s: needs %s, %s free in %s", label, formatSize(need), formatSize(free), dir),
+			"needed", need, "free", free)
+	}
+
+	// Chunks stay on disk until merged, and the merged file is assembled
+	// next to them before moving to --output-dir
+	chunks := plan.Remaining
+	if opts.remote {
+		chunks = 0
+	}
+	if !opts.merge {
+		check("Disk       ", ".", chunks)
+		return problems
+	}
+	check("Disk       ", ".", chunks+plan.Size)
+	if opts.outputDir != "" {
+		// Only used if it is on another filesystem, where it gets a copy
+		check("Output disk", opts.outputDir, plan.Size)
+	}
+	return problems
+}
+
+// chunkStatusName describes a chunk in the plan listing.
+func chunkStatusName(s downloader.ChunkStatus) string {
+	switch s {
+	case downloader.ChunkComplete:
+		return "complete"
+	case downloader.ChunkPartial:
+		return "partial"
+	default:
+		return "to fetch"
+	}
+}
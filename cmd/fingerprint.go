@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// fingerprintFlags holds the flags that shape how requests present
+// themselves, shared by subcommands that download.
+type fingerprintFlags struct {
+	userAgent     *string
+	userAgentFile *string
+	referer       *string
+	randomize     *bool
+}
+
+// addFingerprintFlags registers --user-agent, --user-agent-file, --referer
+// and --randomize-headers on fs.
+func addFingerprintFlags(fs *flag.FlagSet) *fingerprintFlags {
+	return &fingerprintFlags{
+		userAgent:     fs.String("user-agent", "", "User-Agent header of every request"),
+		userAgentFile: fs.String("user-agent-file", "", "File of User-Agents, one per line, sent in turn per request"),
+		referer:       fs.String("referer", "", "Referer header of every request"),
+		randomize:     fs.Bool("randomize-headers", false, "Vary Accept and Accept-Language from request to request"),
+	}
+}
+
+// config returns the fingerprint for the HTTP client. --user-agent-file is
+// read here, before a workdir is entered.
+func (ff *fingerprintFlags) config() (httpclient.Fingerprint, error) {
+	fp := httpclient.Fingerprint{Referer: *ff.referer, Randomize: *ff.randomize}
+	switch {
+	case *ff.userAgent != "" && *ff.userAgentFile != "":
+		return httpclient.Fingerprint{}, fmt.Errorf("--user-agent and --user-agent-file are mutually exclusive")
+	case *ff.userAgent != "":
+		fp.UserAgents = []string{*ff.userAgent}
+	case *ff.userAgentFile != "":
+		agents, err := readUserAgents(*ff.userAgentFile)
+		if err != nil {
+			return httpclient.Fingerprint{}, err
+		}
+		fp.UserAgents = agents
+	}
+	return fp, nil
+}
+
+// readUserAgents reads a --user-agent-file: one User-Agent per line,
+// blank lines and lines starting with # skipped.
+func readUserAgents(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --user-agent-file: %w", err)
+	}
+	defer f.Close()
+
+	var agents []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		agents = append(agents, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --user-agent-file: %w", err)
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("--user-agent-file %s has no User-Agents", path)
+	}
+	return agents, nil
+}
+
+// fingerprintUsage is the help text for the fingerprint flags.
+const fingerprintUsage = `
+Request headers:
+  --user-agent UA      User-Agent header of every request (default: Go's)
+  --user-agent-file FILE
+                       User-Agents, one per line (# comments), sent in turn:
+                       each request gets the next one
+  --referer URL        Referer header of every request
+  --randomize-headers  Vary Accept and Accept-Language per request. Go sends
+                       HTTP/1.1 headers in a fixed order; over HTTP/2 the
+                       order already varies
+`
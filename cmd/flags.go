@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/redraw/rapel/internal/failure"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// FlagOrderError is returned for a flag given after the positional
+// arguments. The flag package stops parsing at the first of those, so
+// "rapel download URL -j 8" would otherwise run with one job and never
+// mention -j. The message always starts with "flag after arguments:" for
+// scripts to match on. It is a failure.ErrUsage, so main exits with
+// status 2.
+type FlagOrderError struct {
+	Command string
+	Flag    string // the first flag-like argument, as given
+	After   string // the positional argument before it
+}
+
+func (e *FlagOrderError) Error() string {
+	return fmt.Sprintf("flag after arguments: %s comes after %q and would be ignored; put options first: rapel %s [options] ...",
+		e.Flag, e.After, e.Command)
+}
+
+func (e *FlagOrderError) Is(target error) bool { return target == failure.ErrUsage }
+
+// parseFlags parses args like fs.Parse, then refuses flag-like arguments
+// left after the positional ones. Arguments after an explicit -- are
+// taken as they are, whether it comes before the positional arguments or
+// among them; in the latter case it is dropped from fs.Args.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+		return nil
+	}
+	for i, arg := range rest {
+		if i == 0 {
+			continue
+		}
+		if arg == "--" {
+			// Parsing only the positionals behind a -- sets no flag again
+			positional := append(append([]string{"--"}, rest[:i]...), rest[i+1:]...)
+			return fs.Parse(positional)
+		}
+		if len(arg) > 1 && arg[0] == '-' {
+			return &FlagOrderError{Command: fs.Name(), Flag: arg, After: rest[0]}
+		}
+	}
+	return nil
+}
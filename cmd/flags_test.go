@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      string
+		wantJobs  int
+		wantArgs  []string
+		wantFlag  string // the misplaced flag reported, if any
+		wantAfter string
+	}{
+		{name: "flags first", args: "-j 8 URL", wantJobs: 8, wantArgs: []string{"URL"}},
+		{name: "flag after URL", args: "URL -j 8", wantFlag: "-j", wantAfter: "URL"},
+		{name: "long flag after URL", args: "-j 2 URL OUT --jobs=8", wantFlag: "--jobs=8", wantAfter: "URL"},
+		{name: "-- before positionals", args: "-j 8 -- -file.bin -j", wantJobs: 8, wantArgs: []string{"-file.bin", "-j"}},
+		{name: "- as positional", args: "URL -", wantJobs: 1, wantArgs: []string{"URL", "-"}},
+		{name: "- as only positional", args: "-j 3 -", wantJobs: 3, wantArgs: []string{"-"}},
+		{name: "-- after URL", args: "URL -- -file.bin", wantJobs: 1, wantArgs: []string{"URL", "-file.bin"}},
+		{name: "-- last", args: "-j 4 URL --", wantJobs: 4, wantArgs: []string{"URL"}},
+		{name: "flag before -- after URL", args: "URL -j 8 -- x", wantFlag: "-j", wantAfter: "URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("download", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			jobs := fs.Int("j", 1, "")
+			fs.IntVar(jobs, "jobs", 1, "")
+
+			err := parseFlags(fs, strings.Fields(tt.args))
+			if tt.wantFlag != "" {
+				var orderErr *FlagOrderError
+				require.ErrorAs(t, err, &orderErr)
+				assert.Equal(t, tt.wantFlag, orderErr.Flag)
+				assert.Equal(t, tt.wantAfter, orderErr.After)
+				assert.True(t, errors.Is(err, failure.ErrUsage))
+				assert.True(t, strings.HasPrefix(err.Error(), "flag after arguments: "), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantJobs, *jobs)
+			assert.Equal(t, tt.wantArgs, fs.Args())
+		})
+	}
+}
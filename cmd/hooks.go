@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// postPartFlags are the --post-part options, shared by download and
+// hooks run.
+type postPartFlags struct {
+	cmd         *string
+	exec        *string
+	jobs        *int
+	timeout     *time.Duration
+	retries     *int
+	retryOn     *string
+	backoffBase *time.Duration
+	backoffMax  *time.Duration
+	maxFailures *int
+
+	argv        []string // exec split into words, by validate
+	retryOnList []int
+}
+
+func addPostPartFlags(fs *flag.FlagSet) *postPartFlags {
+	return &postPartFlags{
+		cmd:         fs.String("post-part", "", "Command to run after each part completes (supports {part}, {idx}, {base}, {start}, {end})"),
+		exec:        fs.String("post-part-exec", "", "Program and arguments to run after each part completes, without a shell (same placeholders as --post-part)"),
+		jobs:        fs.Int("post-part-jobs", 0, "Max concurrent post-part commands (0 = unlimited)"),
+		timeout:     fs.Duration("post-part-timeout", 0, "Kill a post-part command running longer than this (0 = no limit)"),
+		retries:     fs.Int("post-part-retries", 0, "Times to run a failed post-part command again"),
+		retryOn:     fs.String("post-part-retry-on", "", "Comma-separated exit statuses of the post-part command worth a retry (default: any)"),
+		backoffBase: fs.Duration("post-part-backoff-base", 500*time.Millisecond, "Unit of the wait between post-part retries, doubled each time"),
+		backoffMax:  fs.Duration("post-part-backoff-max", 30*time.Second, "Longest wait between post-part retries"),
+		maxFailures: fs.Int("post-part-max-failures", 0, "Stop once this many post-part commands failed for good (0 = never)"),
+	}
+}
+
+// validate checks the flags once parsed.
+func (p *postPartFlags) validate() error {
+	if *p.exec != "" {
+		if *p.cmd != "" {
+			return fmt.Errorf("--post-part and --post-part-exec cannot be combined")
+		}
+		var err error
+		if p.argv, err = downloader.SplitArgs(*p.exec); err != nil {
+			return fmt.Errorf("invalid --post-part-exec: %w", err)
+		}
+	}
+	if *p.retries < 0 {
+		return fmt.Errorf("--post-part-retries cannot be negative")
+	}
+	var err error
+	if p.retryOnList, err = parseExitStatusList(*p.retryOn); err != nil {
+		return fmt.Errorf("invalid --post-part-retry-on: %w", err)
+	}
+	if *p.backoffBase <= 0 || *p.backoffMax <= 0 {
+		return fmt.Errorf("--post-part-backoff-base and --post-part-backoff-max must be positive")
+	}
+	if *p.maxFailures < 0 {
+		return fmt.Errorf("--post-part-max-failures cannot be negative")
+	}
+	return nil
+}
+
+// set returns whether a post-part command is given.
+func (p *postPartFlags) set() bool {
+	return *p.cmd != "" || p.argv != nil
+}
+
+// backoff returns the wait between retries.
+func (p *postPartFlags) backoff() downloader.Backoff {
+	return downloader.Backoff{Base: *p.backoffBase, Max: *p.backoffMax}
+}
+
+// apply copies the validated flags into config.
+func (p *postPartFlags) apply(config *downloader.Config) {
+	config.PostPartCmd = *p.cmd
+	config.PostPartExec = p.argv
+	config.PostPartConcurrency = *p.jobs
+	config.PostPartTimeout = *p.timeout
+	config.PostPartRetries = *p.retries
+	config.PostPartRetryOn = p.retryOnList
+	config.PostPartBackoff = p.backoff()
+	config.PostPartMaxFailures = *p.maxFailures
+}
+
+// hookFlags are the options sandboxing hooks, shared by download and
+// hooks run. --shell is each command's own, as it covers other commands
+// in download.
+type hookFlags struct {
+	env        stringList
+	inheritEnv *bool
+	dir        *string
+	noNetwork  *bool
+}
+
+func addHookFlags(fs *flag.FlagSet) *hookFlags {
+	h := &hookFlags{}
+	fs.Var(&h.env, "hook-env", "Environment variable for hooks: KEY passes it through, KEY=VALUE sets it (repeatable)")
+	h.inheritEnv = fs.Bool("hook-inherit-env", false, "Give hooks rapel's full environment")
+	h.dir = fs.String("hook-dir", "", "Working directory for hooks")
+	h.noNetwork = fs.Bool("hook-no-network", false, "Run hooks without network access (Linux only)")
+	return h
+}
+
+// sandbox returns the hook sandbox the flags describe, running commands
+// with shell.
+func (h *hookFlags) sandbox(shell string) downloader.HookSandbox {
+	return downloader.HookSandbox{
+		InheritEnv: *h.inheritEnv,
+		Env:        h.env,
+		Dir:        *h.dir,
+		NoNetwork:  *h.noNetwork,
+		Shell:      shell,
+	}
+}
+
+// HooksCommand implements the hooks subcommand
+func HooksCommand(args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel hooks run [options] PREFIX
+
+Run hooks for chunks downloaded earlier.
+`)
+	}
+
+	if len(args) == 0 {
+		usage()
+		return fmt.Errorf("a hooks command is required: run")
+	}
+	switch args[0] {
+	case "run":
+		return hooksRun(args[1:])
+	case "-h", "-help", "--help":
+		usage()
+		return nil
+	default:
+		usage()
+		return fmt.Errorf("unknown hooks command %q (known: run)", args[0])
+	}
+}
+
+func hooksRun(args []string) error {
+	fs := flag.NewFlagSet("hooks run", flag.ExitOnError)
+	postPart := addPostPartFlags(fs)
+	hooks := addHookFlags(fs)
+	shell := fs.String("shell", "", "Shell for --post-part: sh, bash, cmd, powershell, ... (default: sh, cmd on Windows)")
+	failedOnly := fs.Bool("failed", false, "Only run the chunks whose post-part command failed before, as rapel status lists them")
+	logOpts := addLogFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel hooks run [options] PREFIX
+
+Run the post-part command for the chunks of PREFIX already complete on
+disk, as rapel download would have after each, without downloading
+anything: for a hook added or fixed after the chunks were downloaded. The
+download's state is used while it is there; once it's gone, the layout
+is worked out from the .part files. Not while the download itself runs.
+
+Options:
+  --post-part CMD    Command to run for each chunk
+                     Placeholders: {part} {idx} {base} {start} {end}
+  --post-part-exec CMD
+                     Like --post-part, but run CMD directly instead of through
+                     a shell
+  --post-part-jobs N Max concurrent post-part commands. Default: 10
+  --post-part-timeout DUR
+                     Kill a post-part command running longer than DUR
+  --post-part-retries N
+                     Run a failed post-part command up to N more times
+  --post-part-retry-on LIST
+                     Only retry these comma-separated exit statuses. Default: any
+  --post-part-backoff-base D
+                     Unit of the wait between retries, doubled each time.
+                     Default: 500ms
+  --post-part-backoff-max D
+                     Longest wait between retries. Default: 30s
+  --post-part-max-failures N
+                     Stop once N commands failed after all their retries
+  --failed           Only run the chunks listed by rapel status as failed
+  --hook-env KEY[=VALUE]
+                     Pass KEY through to the command, or set it (repeatable)
+  --hook-inherit-env Give the command rapel's full environment
+  --hook-dir DIR     Working directory for the command ({part} becomes absolute)
+  --hook-no-network  Run the command in an empty network namespace (Linux only)
+  --shell SHELL      Shell for --post-part (default: sh, cmd on Windows)
+%s
+Examples:
+  rapel hooks run --post-part 'rclone move {part} remote:bucket/' file.bin
+  rapel hooks run --failed --post-part-retries 3 --post-part 'rclone move {part} remote:bucket/' file.bin
+`, logUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX is required")
+	}
+	if err := postPart.validate(); err != nil {
+		return err
+	}
+	if !postPart.set() {
+		return fmt.Errorf("--post-part or --post-part-exec is required")
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	config := downloader.Config{Hooks: hooks.sandbox(*shell)}
+	postPart.apply(&config)
+	d, err := downloader.NewDownloader(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = d.BackfillPostPart(ctx, fs.Arg(0), *failedOnly)
+	if errors.Is(err, context.Canceled) {
+		slog.Info("Interrupted: the remaining chunks were skipped")
+	}
+	return err
+}
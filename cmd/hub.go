@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/redraw/rapel/internal/hub"
+)
+
+// resolveHubURL resolves an hf://, zenodo:// or ia:// identifier into the
+// URL to download from and the headers to send there. Other URLs are
+// returned as they are, with no headers.
+func resolveHubURL(url string) (string, http.Header, error) {
+	r, ok, err := hub.Resolve(url, hub.Builtin())
+	if err != nil || !ok {
+		return url, nil, err
+	}
+	return r.URL, r.Header, nil
+}
+
+// resolveHubURLs resolves each of the URLs a template expands to. They
+// share the headers: a template's URLs are all on one hub.
+func resolveHubURLs(urls []string) ([]string, http.Header, error) {
+	resolved := make([]string, len(urls))
+	var header http.Header
+	for i, url := range urls {
+		var err error
+		if resolved[i], header, err = resolveHubURL(url); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resolved, header, nil
+}
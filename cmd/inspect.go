@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// InspectCommand implements the inspect subcommand
+func InspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+
+	// Define flags
+	showSecrets := fs.Bool("show-secrets", false, "Print the unredacted URL (asks for confirmation)")
+	yes := fs.Bool("yes", false, "Confirm --show-secrets without prompting")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel inspect [options] PREFIX
+
+Show the saved state of a download. Secrets (signatures, tokens, passwords)
+are redacted unless --show-secrets is given and confirmed.
+
+Options:
+  --show-secrets   Print the unredacted URL (asks for confirmation)
+  --yes            Confirm --show-secrets without prompting (for scripts)
+
+Examples:
+  rapel inspect file.bin
+  rapel inspect --show-secrets file.bin
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX is required")
+	}
+
+	prefix := fs.Arg(0)
+
+	state, err := downloader.LoadDownloadArguments(prefix)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no download state found for %s", prefix)
+	}
+
+	url := state.URL
+	if *showSecrets {
+		if !*yes && !confirm("This prints credentials to the terminal. Type 'yes' to continue: ") {
+			return fmt.Errorf("not confirmed, secrets not shown")
+		}
+
+		secretURL, err := downloader.LoadSecretURL(prefix)
+		if err != nil {
+			return err
+		}
+		if secretURL != "" {
+			url = secretURL
+		}
+	}
+
+	completed, err := state.CompletedChunks()
+	if err != nil {
+		return err
+	}
+
+	var parts, tmps int
+	for i := 0; i < state.NumChunks(); i++ {
+		if completed[i] {
+			parts++
+		} else if _, err := os.Stat(state.TmpPath(i)); err == nil {
+			tmps++
+		}
+	}
+
+	fmt.Printf("Prefix     : %s\n", state.FilenamePrefix)
+	fmt.Printf("URL        : %s\n", url)
+	if state.URLHash != "" {
+		fmt.Printf("URL SHA256 : %s\n", state.URLHash)
+	}
+	fmt.Printf("Size       : %d bytes\n", state.TotalSize)
+	if len(state.Layout) > 0 {
+		fmt.Printf("Chunk size : %d bytes, mixed with the sizes of chunks kept by a re-slice\n", state.ChunkSize)
+	} else {
+		fmt.Printf("Chunk size : %d bytes\n", state.ChunkSize)
+	}
+	fmt.Printf("Chunks     : %d (%d complete, %d in progress)\n", state.NumChunks(), parts, tmps)
+
+	progress, err := downloader.LoadProgressState(prefix)
+	if err != nil {
+		return err
+	}
+	if progress != nil && progress.Elapsed > 0 {
+		fmt.Printf("Downloaded : %s in %s (avg %s/s)\n", formatSize(progress.Bytes), progress.Elapsed.Round(time.Second),
+			formatSize(int64(float64(progress.Bytes)/progress.Elapsed.Seconds())))
+	}
+
+	return nil
+}
+
+// confirm prints prompt and reports whether the user typed "yes".
+func confirm(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(line), "yes")
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// ListCommand implements the list subcommand
+func ListCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+
+	// Define flags
+	asJSON := fs.Bool("json", false, "Print entries as JSON")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel list [options]
+
+List downloads recorded in the state registry
+($XDG_DATA_HOME/rapel/state, default ~/.local/share/rapel/state).
+
+Options:
+  --json   Print entries as JSON
+
+Examples:
+  rapel list
+  rapel list --json | jq '.[] | select(.status == "failed")'
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	entries, err := registry.List()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []*registry.Entry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No downloads recorded")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tSIZE\tUPDATED\tPATH")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			e.ID, e.Status, e.TotalSize, e.UpdatedAt.Local().Format(time.DateTime), e.OutputPath())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"flag"
+
+	"github.com/redraw/rapel/internal/logging"
+)
+
+// logFlags holds the logging flags shared by all subcommands.
+type logFlags struct {
+	quiet       *bool
+	verbose     *bool
+	veryVerbose *bool
+	format      *string
+	file        *string
+}
+
+// addLogFlags registers -q, -v, -vv, --log-format and --log-file on fs.
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		quiet:       fs.Bool("q", false, "Quiet: only warnings and errors"),
+		verbose:     fs.Bool("v", false, "Verbose: include debug messages"),
+		veryVerbose: fs.Bool("vv", false, "Very verbose: include trace messages"),
+		format:      fs.String("log-format", "text", "Log format: text or json"),
+		file:        fs.String("log-file", "", "Write logs to file instead of stdout"),
+	}
+}
+
+// setup installs the logger and returns a function closing the log file.
+// With toStderr, console output goes to stderr so stdout can carry data.
+func (lf *logFlags) setup(toStderr bool) (func() error, error) {
+	verbose := 0
+	if *lf.verbose {
+		verbose = 1
+	}
+	if *lf.veryVerbose {
+		verbose = 2
+	}
+
+	return logging.Setup(logging.Options{
+		Quiet:   *lf.quiet,
+		Verbose: verbose,
+		Format:  *lf.format,
+		File:    *lf.file,
+		Stderr:  toStderr,
+	})
+}
+
+// logUsage is the help text for the logging flags.
+const logUsage = `
+Logging:
+  -q                 Quiet: only warnings and errors, no progress
+  -v, -vv            Verbose (debug) / very verbose (trace) output
+  --log-format FMT   text (default) or json
+  --log-file PATH    Write logs to PATH instead of stdout
+`
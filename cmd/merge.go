@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/redraw/rapel/internal/manifest"
 	"github.com/redraw/rapel/internal/merger"
 )
 
@@ -13,9 +14,22 @@ func MergeCommand(args []string) error {
 	fs := flag.NewFlagSet("merge", flag.ExitOnError)
 
 	// Define flags
+	logOpts := addLogFlags(fs)
+	profOpts := addProfileFlags(fs)
 	output := fs.String("o", "", "Output filename (auto-detected if not provided)")
 	pattern := fs.String("pattern", "*.part", "Pattern for chunk files")
 	delete := fs.Bool("delete", false, "Delete chunks after merging")
+	outputDir := fs.String("output-dir", "", "Write the merged file to this directory")
+	decompress := fs.Bool("decompress", false, "Decompress gzip/bzip2/zstd/xz/brotli data while merging")
+	toStdout := fs.Bool("stdout", false, "Write the merged data to stdout instead of a file (logs go to stderr)")
+	decryptParts := fs.String("decrypt-parts", "", "Passphrase for chunks written with --encrypt-parts: env:VAR or file:PATH")
+	allowGaps := fs.Bool("allow-gaps", false, "Merge even if chunks are missing, duplicated or truncated")
+	jobs := fs.Int("jobs", 4, "Chunk files to copy into the output at once")
+	reflink := fs.Bool("reflink", false, "Share the chunks' disk blocks with the output instead of copying, where the filesystem supports it")
+	sparse := fs.Bool("sparse", false, "Leave blocks of zeros in the output as holes that take no disk space")
+	var from stringList
+	fs.Var(&from, "from", "Directory to find chunk files in, repeatable (default: current directory)")
+	verify := fs.Bool("verify", false, "Check the chunks and the merged file against the download's --write-manifest manifest")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage: rapel merge [options]
@@ -26,26 +40,96 @@ If multiple .part groups are found, all groups are merged into separate files.
 Options:
   -o FILE        Output filename (auto-detected from pattern if not provided)
   --pattern GLOB Pattern for chunk files. Default: *.part
+  --from DIR     Look for chunk files in DIR instead of the current directory.
+                 Repeat it to merge chunks downloaded on several machines
+                 with 'rapel plan split', each copied into a directory
   --delete       Delete chunk files after merging
-
+  --output-dir DIR  Write the merged file to DIR. On another filesystem the
+                 file is copied there, needing its full size free on both
+  --decompress   Decompress while merging (gzip, bzip2; zstd, xz, br via the
+                 zstd/xz/brotli commands) and drop the .gz/.zst/... extension
+  --stdout       Write the merged data to stdout; logs go to stderr. Only one
+                 group is streamed, and nothing is written unless every chunk
+                 from 0 on is present and complete
+  --jobs N       Preallocate the output and copy N chunk files into it at once,
+                 each at its offset. Default: 4 (1 = one after another, which
+                 may be faster on spinning disks)
+  --reflink      Clone the chunks into the output (Btrfs, XFS, ...) instead of
+                 copying them; chunks that can't be cloned are copied
+  --sparse       Leave 4 KiB blocks of zeros in the output as holes, which take
+                 no disk space (disk images, VM files, preallocated archives)
+  --verify       Check each chunk file's size and SHA-256 against the manifest
+                 --write-manifest wrote (FILE.manifest.json) before merging, and
+                 the merged file against its whole-file SHA-256 after
+  --allow-gaps   Merge even if chunks are missing, duplicated or truncated, or
+                 the download is unfinished (only warns). The result is corrupt
+  --decrypt-parts SRC  Passphrase for chunks downloaded with --encrypt-parts,
+                 from env:VAR or file:PATH
+%s%s
 Examples:
   rapel merge                              # Merge all .part groups
   rapel merge -o file.bin                  # Merge specific group
   rapel merge --pattern 'file.*.part'      # Merge group matching pattern
   rapel merge --pattern 'file.*.part' --delete
-`)
+  rapel merge --decompress                 # dump.sql.gz.*.part -> dump.sql
+  rapel merge --stdout -o backup.tar.gz | tar xz
+  rapel merge --verify -o file.bin         # Chunks fetched back from offload
+  rapel merge --from a --from b -o file.bin   # Chunks from two machines
+`, logUsage, profileUsage)
 	}
 
-	if err := fs.Parse(args); err != nil {
+	if err := parseFlags(fs, args); err != nil {
 		return err
 	}
 
+	if *toStdout && *outputDir != "" {
+		return fmt.Errorf("--stdout cannot be combined with --output-dir")
+	}
+	if *toStdout && *verify {
+		return fmt.Errorf("--verify cannot be combined with --stdout")
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+
+	key, err := loadKey("--decrypt-parts", *decryptParts)
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := logOpts.setup(*toStdout)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	stopProfile, err := profOpts.start()
+	if err != nil {
+		return err
+	}
+	defer stopProfile()
+
 	// Create merger
-	m := merger.NewMerger(merger.Config{
-		Output:  *output,
-		Pattern: *pattern,
-		Delete:  *delete,
-	})
+	config := merger.Config{
+		Output:     *output,
+		Pattern:    *pattern,
+		Delete:     *delete,
+		Decompress: *decompress,
+		OutputDir:  *outputDir,
+		Dirs:       from,
+		AllowGaps:  *allowGaps,
+		Key:        key,
+		Jobs:       *jobs,
+		Reflink:    *reflink,
+		Sparse:     *sparse,
+	}
+	if *toStdout {
+		config.Writer = os.Stdout
+	}
+	if *verify {
+		config.Verify = &manifest.Verifier{}
+	}
+	m := merger.NewMerger(config)
 
 	// Perform merge
 	if err := m.Merge(); err != nil {
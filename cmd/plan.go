@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// PlanCommand implements the plan subcommand
+func PlanCommand(args []string) error {
+	if len(args) == 0 || args[0] != "split" {
+		fmt.Fprintf(os.Stderr, "Usage: rapel plan split [options] N URL\n")
+		return fmt.Errorf("unknown plan command, use: split")
+	}
+	return planSplit(args[1:])
+}
+
+// planSplit cuts a download into sub-plans for several machines.
+func planSplit(args []string) error {
+	fs := flag.NewFlagSet("plan split", flag.ExitOnError)
+
+	logOpts := addLogFlags(fs)
+	chunkSizeStr := fs.String("c", "100M", "Chunk size (e.g., 50M, 1G, auto)")
+	sizeStr := fs.String("size", "", "Total file size, skipping the HEAD request")
+	dir := fs.String("d", ".", "Directory to write the plans to")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Give up on the HEAD request after this long")
+	tlsOpts := addTLSFlags(fs)
+	fpOpts := addFingerprintFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel plan split [options] N URL
+
+Cut a download into N plans of contiguous chunk ranges, written to
+<file>.plan-1ofN.json ... <file>.plan-NofN.json, to run on N machines (or
+IPs) at once against hosts that throttle each IP:
+
+  rapel download --plan file.bin.plan-2of3.json URL
+
+Each machine resumes its own chunks like any download. Copy their
+directories back and merge them together:
+
+  rapel merge --from a --from b --from c -o file.bin
+
+Options:
+  -c SIZE        Chunk size (K, M, G suffix), or auto to fit the file size
+                 and the number of plans. Default: 100M
+  --size SIZE    Total file size; skips the HEAD request
+  -d DIR         Write the plans to DIR. Default: current directory
+  -x URL         Proxy URL for the HEAD request
+  --timeout D    Give up on the HEAD request after D. Default: 30s
+%s%s%s
+Examples:
+  rapel plan split 3 https://example.com/file.bin
+  rapel plan split -c 50M -d plans 4 https://example.com/file.bin
+`, tlsUsage, fingerprintUsage, logUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("N and URL are required")
+	}
+
+	n, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid number of plans %q", fs.Arg(0))
+	}
+	chunkSize, autoChunkSize, err := parseChunkSize(*chunkSizeStr, 100*1000*1000)
+	if err != nil {
+		return err
+	}
+	var totalSize int64
+	if *sizeStr != "" {
+		if totalSize, err = parseSize(*sizeStr); err != nil {
+			return fmt.Errorf("invalid size: %w", err)
+		}
+	}
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := fpOpts.config()
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	url, header, err := resolveHubURL(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	dl, err := downloader.NewDownloader(downloader.Config{
+		URL:            url,
+		ChunkSize:      chunkSize,
+		AutoChunkSize:  autoChunkSize,
+		MaxConcurrency: n, // -c auto: a few chunks per plan
+		TotalSize:      totalSize,
+		HTTPConfig: httpclient.Config{
+			ProxyURL:       *proxyURL,
+			MaxRetries:     3,
+			ConnectTimeout: *timeout,
+			ReadTimeout:    *timeout,
+			TLS:            tlsConfig,
+			Fingerprint:    fingerprint,
+			Header:         header,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create downloader: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	plans, err := dl.Split(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range plans {
+		path, err := p.Save(*dir)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("%s: chunks %s", path, p.Chunks), "plan", path, "chunks", p.Chunks)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// ProbeCommand implements the probe subcommand
+func ProbeCommand(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+
+	// Define flags
+	sampleStr := fs.String("sample", "1M", "Bytes to download from each mirror")
+	timeout := fs.Duration("timeout", 15*time.Second, "Give up on a mirror after this long")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	asJSON := fs.Bool("json", false, "Print results as JSON")
+	autoSelect := fs.Bool("auto-select", false, "Print only the best usable mirror URL")
+	tlsOpts := addTLSFlags(fs)
+	fpOpts := addFingerprintFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel probe [options] URL [URL...]
+
+Download a small sample range from each candidate mirror and rank them
+by throughput. Mirrors that fail or ignore Range requests rank last,
+since they cannot serve chunked downloads.
+
+Options:
+  --sample SIZE    Bytes to download from each mirror (default: 1M)
+  --timeout DUR    Give up on a mirror after this long (default: 15s)
+  -x URL           Proxy URL (e.g., socks5h://127.0.0.1:9050)
+  --json           Print results as JSON, best first
+  --auto-select    Print only the best usable mirror URL; fails if none is usable
+%s%s
+Examples:
+  rapel probe https://a.example.com/f.iso https://b.example.com/f.iso
+  rapel download "$(rapel probe --auto-select $MIRRORS)"
+`, tlsUsage, fingerprintUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	urls := fs.Args()
+	if len(urls) == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one URL is required")
+	}
+
+	sample, err := parseSize(*sampleStr)
+	if err != nil {
+		return fmt.Errorf("invalid sample size: %w", err)
+	}
+	if sample <= 0 {
+		return fmt.Errorf("sample size must be positive")
+	}
+
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := fpOpts.config()
+	if err != nil {
+		return err
+	}
+	if tlsConfig.Insecure {
+		slog.Warn("--insecure: server certificates are not verified")
+	}
+
+	client, err := httpclient.NewClient(httpclient.Config{
+		ProxyURL:       *proxyURL,
+		ConnectTimeout: *timeout,
+		TLS:            tlsConfig,
+		Fingerprint:    fingerprint,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	// Probe concurrently so a slow mirror doesn't hold up the rest
+	results := make([]httpclient.ProbeResult, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			results[i] = client.Probe(ctx, url, sample)
+		}()
+	}
+	wg.Wait()
+
+	httpclient.RankProbes(results)
+	warnSizeMismatch(results)
+
+	if *autoSelect {
+		if !results[0].Usable() {
+			return fmt.Errorf("no usable mirror among %d candidates", len(results))
+		}
+		fmt.Println(results[0].URL)
+		return nil
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tSPEED\tLATENCY\tRANGES\tSIZE\tURL")
+	for i, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\t%s (%s)\n", i+1, redact.URL(r.URL), r.Error)
+			continue
+		}
+		ranges := "yes"
+		if !r.Ranges {
+			ranges = "no"
+		}
+		size := "-"
+		if r.Size > 0 {
+			size = formatSize(r.Size)
+		}
+		fmt.Fprintf(tw, "%d\t%s/s\t%s\t%s\t%s\t%s\n",
+			i+1, formatSize(int64(r.Throughput)), r.Latency.Round(time.Millisecond), ranges, size, redact.URL(r.URL))
+	}
+	return tw.Flush()
+}
+
+// warnSizeMismatch warns when usable mirrors disagree on the file size,
+// which usually means one of them serves a different version.
+func warnSizeMismatch(results []httpclient.ProbeResult) {
+	var size int64
+	for _, r := range results {
+		if !r.Usable() || r.Size == 0 {
+			continue
+		}
+		if size == 0 {
+			size = r.Size
+		} else if r.Size != size {
+			fmt.Fprintln(os.Stderr, "Warning: mirrors report different file sizes")
+			return
+		}
+	}
+}
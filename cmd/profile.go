@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+// profileFlags holds the profiling flags shared by the subcommands that
+// move data.
+type profileFlags struct {
+	listen     *string
+	cpuProfile *string
+	memProfile *string
+}
+
+// addProfileFlags registers --pprof, --cpu-profile and --mem-profile on fs.
+func addProfileFlags(fs *flag.FlagSet) *profileFlags {
+	return &profileFlags{
+		listen:     fs.String("pprof", "", "Serve Go's pprof endpoints at http://ADDR/debug/pprof/ (e.g., :6060)"),
+		cpuProfile: fs.String("cpu-profile", "", "Write a CPU profile of the whole run to this file"),
+		memProfile: fs.String("mem-profile", "", "Write a heap profile to this file on exit"),
+	}
+}
+
+// start begins profiling and returns a function that writes the profiles
+// and stops the pprof server. It is meant to be deferred, so the profiles
+// are written however the command ends.
+func (pf *profileFlags) start() (func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if *pf.listen != "" {
+		ln, err := net.Listen("tcp", *pf.listen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for pprof: %w", err)
+		}
+		// Not http.DefaultServeMux: nothing else should end up exposed
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		srv := &http.Server{Handler: mux}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn(fmt.Sprintf("pprof server stopped: %v", err))
+			}
+		}()
+		slog.Info(fmt.Sprintf("pprof at http://%s/debug/pprof/", ln.Addr()), "addr", ln.Addr().String())
+		stops = append(stops, func() { srv.Close() })
+	}
+
+	if *pf.cpuProfile != "" {
+		f, err := os.Create(*pf.cpuProfile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			stop()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			rpprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				slog.Warn(fmt.Sprintf("failed to write CPU profile: %v", err))
+				return
+			}
+			slog.Debug("CPU profile written to " + *pf.cpuProfile)
+		})
+	}
+
+	if *pf.memProfile != "" {
+		path := *pf.memProfile
+		stops = append(stops, func() {
+			if err := writeHeapProfile(path); err != nil {
+				slog.Warn(fmt.Sprintf("failed to write heap profile: %v", err))
+				return
+			}
+			slog.Debug("Heap profile written to " + path)
+		})
+	}
+
+	return stop, nil
+}
+
+// writeHeapProfile writes the live heap, after a collection, to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// profileUsage is the help text for the profiling flags.
+const profileUsage = `
+Profiling:
+  --pprof ADDR       Serve Go's pprof endpoints at http://ADDR/debug/pprof/
+                     while running (e.g., :6060 or 127.0.0.1:6060)
+  --cpu-profile FILE Write a CPU profile of the whole run to FILE
+  --mem-profile FILE Write a heap profile to FILE on exit
+`
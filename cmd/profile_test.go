@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileFlags(t *testing.T) {
+	// A free port, released for --pprof to take
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "mem.out")
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	pf := addProfileFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--pprof", addr, "--cpu-profile", cpu, "--mem-profile", mem}))
+
+	stop, err := pf.start()
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://" + addr + "/debug/pprof/cmdline")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Only the pprof endpoints are served
+	resp, err = http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	stop()
+	for _, path := range []string{cpu, mem} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Positive(t, info.Size(), path)
+	}
+	_, err = http.Get("http://" + addr + "/debug/pprof/cmdline")
+	assert.Error(t, err, "server still up after stop")
+
+	// A profile that can't be created fails before anything runs
+	fs = flag.NewFlagSet("download", flag.ContinueOnError)
+	pf = addProfileFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--cpu-profile", filepath.Join(dir, "missing", "cpu.out")}))
+	_, err = pf.start()
+	assert.ErrorContains(t, err, "failed to create CPU profile")
+}
+
+func TestProfileFlagsListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	pf := addProfileFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--pprof", ln.Addr().String()}))
+	_, err = pf.start()
+	assert.ErrorContains(t, err, "failed to listen for pprof")
+}
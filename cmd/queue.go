@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redraw/rapel/internal/queue"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// QueueCommand implements the queue subcommand
+func QueueCommand(args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel queue add [--priority N] [--weight N] [--dir DIR] [-- download options] URL
+       rapel queue list [--json]
+       rapel queue rm ID... | --finished
+       rapel queue start [options]
+
+Keep a list of downloads to run later, one after another or a few at a
+time. start runs the pending ones, highest priority first, then the one
+whose --deadline comes first, then the one that has run least for its
+weight, and otherwise in the order they were added, each as 'rapel download' in the directory it was added from, with
+its output in a log file. Interrupted, the running jobs stop as a download
+does and are pending again for the next start.
+
+Examples:
+  rapel queue add https://example.com/a.iso
+  rapel queue add --priority 10 -- -c 100M --jobs 4 --merge https://example.com/b.iso
+  rapel queue start --jobs 2 --limit-rate 20M
+  rapel queue list
+`)
+	}
+
+	if len(args) == 0 {
+		usage()
+		return fmt.Errorf("a queue command is required: add, list, rm or start")
+	}
+
+	switch args[0] {
+	case "add":
+		return queueAdd(args[1:])
+	case "list":
+		return queueList(args[1:])
+	case "rm":
+		return queueRemove(args[1:])
+	case "start":
+		return queueStart(args[1:])
+	case "-h", "-help", "--help":
+		usage()
+		return nil
+	default:
+		usage()
+		return fmt.Errorf("unknown queue command %q (known: add, list, rm, start)", args[0])
+	}
+}
+
+func queueAdd(args []string) error {
+	fs := flag.NewFlagSet("queue add", flag.ExitOnError)
+	priority := fs.Int("priority", 0, "Jobs with a higher priority run first")
+	weight := fs.Int("weight", 1, "The job's share of running time and of the queue's rate limit, next to other jobs")
+	dir := fs.String("dir", "", "Directory to download into (default: the current directory)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel queue add [options] [-- download options] URL
+
+Add a download to the queue. Options for the download itself, as for
+rapel download, go after --. A --deadline among them is fixed when the job
+is added, and jobs of one priority run in order of their deadlines.
+
+Options:
+  --priority N   Jobs with a higher priority run first (default 0; may be negative)
+  --weight N     The job's share next to the other jobs (default 1): of the
+                 queue's --limit-rate while they run together, and of the
+                 running time when jobs take turns
+  --dir DIR      Directory to download into (default: the current directory)
+
+Examples:
+  rapel queue add https://example.com/a.iso
+  rapel queue add --priority 10 -- -c 100M --jobs 4 --merge https://example.com/b.iso
+  rapel queue add -- --deadline 07:00 https://example.com/c.iso
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("URL is required")
+	}
+	if url := fs.Arg(fs.NArg() - 1); url[0] == '-' {
+		return fmt.Errorf("the URL must come last, after the download options: got %s", url)
+	}
+	if *weight < 1 {
+		return fmt.Errorf("--weight must be at least 1")
+	}
+
+	// A relative --deadline is from now, not from whenever the job starts
+	downloadArgs, at := deadlineArg(fs.Args())
+	var deadline time.Time
+	if at >= 0 {
+		var err error
+		if deadline, err = parseDeadline(downloadArgs[at], time.Now()); err != nil {
+			return fmt.Errorf("invalid --deadline: %w", err)
+		}
+		downloadArgs[at] = deadline.Format(time.RFC3339)
+	}
+
+	workDir := *dir
+	if workDir == "" {
+		workDir = "."
+	}
+	workDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(workDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("--dir %s is not a directory", workDir)
+	}
+
+	queueDir, err := queue.Dir()
+	if err != nil {
+		return err
+	}
+	var job *queue.Job
+	err = queue.Update(queueDir, func(q *queue.Queue) error {
+		job = q.Add(downloadArgs, workDir, *priority)
+		job.Weight, job.Deadline = *weight, deadline
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Queued job %d: %s\n", job.ID, redact.URL(job.URL()))
+	return nil
+}
+
+// deadlineArg returns a copy of the download arguments args, with a
+// --deadline=WHEN among the options split in two, and the index of WHEN,
+// or -1 without a deadline.
+func deadlineArg(args []string) ([]string, int) {
+	args = slices.Clone(args)
+	for i, arg := range args[:len(args)-1] {
+		if arg == "--" {
+			break
+		}
+		name, value, inline := strings.Cut(arg, "=")
+		if name != "--deadline" && name != "-deadline" {
+			continue
+		}
+		if inline {
+			args = slices.Insert(args, i+1, value)
+			args[i] = name
+		}
+		if i+1 < len(args)-1 {
+			return args, i + 1
+		}
+	}
+	return args, -1
+}
+
+func queueList(args []string) error {
+	fs := flag.NewFlagSet("queue list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print jobs as JSON")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel queue list [options]
+
+List the queue's jobs: running ones first, then pending ones in the order
+they will run, then finished ones.
+
+Options:
+  --json   Print jobs as JSON
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	queueDir, err := queue.Dir()
+	if err != nil {
+		return err
+	}
+	q, err := queue.Load(queueDir)
+	if err != nil {
+		return err
+	}
+	jobs := q.Sorted()
+
+	if *asJSON {
+		for _, j := range jobs {
+			j.Args[len(j.Args)-1] = redact.URL(j.URL())
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jobs)
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("The queue is empty")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPRIORITY\tWEIGHT\tSTATUS\tURL\tDIR")
+	for _, j := range jobs {
+		status := j.Status
+		if j.Status == queue.StatusFailed {
+			status = fmt.Sprintf("failed (%s, exit %d)", j.ErrorKind, j.ExitCode)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t%s\n", j.ID, j.Priority, max(j.Weight, 1), status, redact.URL(j.URL()), j.Dir)
+	}
+	return tw.Flush()
+}
+
+func queueRemove(args []string) error {
+	fs := flag.NewFlagSet("queue rm", flag.ExitOnError)
+	finished := fs.Bool("finished", false, "Remove every job that is done or failed")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel queue rm ID...
+       rapel queue rm --finished
+
+Remove jobs from the queue. Downloaded files and chunks are left alone. A
+running job can't be removed.
+
+Options:
+  --finished   Remove every job that is done or failed
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *finished == (fs.NArg() > 0) {
+		fs.Usage()
+		return fmt.Errorf("give either job IDs or --finished")
+	}
+
+	ids := make([]int, fs.NArg())
+	for i, arg := range fs.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid job ID %q", arg)
+		}
+		ids[i] = id
+	}
+
+	queueDir, err := queue.Dir()
+	if err != nil {
+		return err
+	}
+	removed := 0
+	err = queue.Update(queueDir, func(q *queue.Queue) error {
+		if *finished {
+			for _, j := range q.Sorted() {
+				if j.Status == queue.StatusDone || j.Status == queue.StatusFailed {
+					ids = append(ids, j.ID)
+				}
+			}
+		}
+		for _, id := range ids {
+			if err := q.Remove(id); err != nil {
+				return err
+			}
+			os.Remove(queue.LogPath(queueDir, id))
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d job(s)\n", removed)
+	return nil
+}
+
+func queueStart(args []string) error {
+	fs := flag.NewFlagSet("queue start", flag.ExitOnError)
+	jobs := fs.Int("jobs", 1, "Downloads run at once")
+	maxPerHost := fs.Int("max-active-per-host", 0, "Downloads run at once from one host (0 = up to --jobs)")
+	limitRateStr := fs.String("limit-rate", "", "Max download rate per second shared by the running downloads by weight")
+	maxTotalConns := fs.Int("max-total-connections", 0, "Max requests in flight across the running downloads (0 = unlimited)")
+	watch := fs.Bool("watch", false, "Keep running once the queue is empty, and start jobs as they are added")
+	retryFailed := fs.Bool("retry-failed", false, "Put failed jobs back in the queue first")
+	logOpts := addLogFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel queue start [options]
+
+Run the queue's pending jobs until none are left. Jobs added meanwhile are
+picked up. Only one rapel queue start runs at a time.
+
+Options:
+  --jobs N                    Downloads run at once (default 1: one after another)
+  --max-active-per-host N     Downloads run at once from one host; the next job for
+                              another host runs meanwhile (0 = up to --jobs)
+  --limit-rate SIZE           Max download rate per second, split between the running
+                              downloads by --weight and split again as jobs start
+                              and end (a job's own --limit-rate wins)
+  --max-total-connections N   Max requests in flight across the running downloads
+  --watch                     Keep running once the queue is empty, and start jobs
+                              as they are added
+  --retry-failed              Put failed jobs back in the queue first
+%s
+Each job's output goes to a log file named by rapel queue list --json.
+`, logUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	if *maxPerHost < 0 {
+		return fmt.Errorf("--max-active-per-host cannot be negative")
+	}
+
+	// Every job gets the same flags ahead of its own, which take precedence
+	var shared []string
+	var limitRate int64
+	if *limitRateStr != "" {
+		limit, err := parseSize(*limitRateStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid --limit-rate %q", *limitRateStr)
+		}
+		limitRate = limit
+	}
+	if *maxTotalConns > 0 {
+		shared = append(shared, "--max-total-connections", strconv.Itoa(*maxTotalConns))
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate the rapel executable: %w", err)
+	}
+	queueDir, err := queue.Dir()
+	if err != nil {
+		return err
+	}
+
+	if *retryFailed {
+		err := queue.Update(queueDir, func(q *queue.Queue) error {
+			for _, j := range q.Jobs {
+				if j.Status == queue.StatusFailed {
+					j.Status = queue.StatusPending
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := &queue.Runner{
+		Dir:        queueDir,
+		Jobs:       *jobs,
+		MaxPerHost: *maxPerHost,
+		LimitRate:  limitRate,
+		Program:    exe,
+		Args: func(job *queue.Job, limitRate int64) []string {
+			args := append([]string{"download"}, shared...)
+			if limitRate > 0 {
+				args = append(args, "--limit-rate", strconv.FormatInt(limitRate, 10))
+			}
+			jobArgs, at := deadlineArg(job.Args)
+			if at >= 0 && !job.Deadline.After(time.Now()) {
+				// Late already: download it anyway rather than fail on the flag
+				slog.Warn(fmt.Sprintf("Job %d is past its deadline of %s", job.ID, job.Deadline.Format(time.RFC3339)), "job", job.ID)
+				jobArgs = slices.Delete(jobArgs, at-1, at+1)
+			}
+			return append(args, jobArgs...)
+		},
+		Watch: *watch,
+	}
+	return r.Run(ctx)
+}
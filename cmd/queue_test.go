@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineArg(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		at   int
+	}{
+		{"none", []string{"-c", "10M", "https://x/a"}, []string{"-c", "10M", "https://x/a"}, -1},
+		{"separate", []string{"--deadline", "6h", "https://x/a"}, []string{"--deadline", "6h", "https://x/a"}, 1},
+		{"inline", []string{"-j", "4", "-deadline=07:00", "https://x/a"}, []string{"-j", "4", "-deadline", "07:00", "https://x/a"}, 3},
+		{"missing value", []string{"--deadline", "https://x/a"}, []string{"--deadline", "https://x/a"}, -1},
+		{"after --", []string{"--", "--deadline", "6h", "https://x/a"}, []string{"--", "--deadline", "6h", "https://x/a"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]string(nil), tt.args...)
+			got, at := deadlineArg(tt.args)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.at, at)
+			assert.Equal(t, original, tt.args, "args changed in place")
+		})
+	}
+}
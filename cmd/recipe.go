@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/redraw/rapel/internal/recipe"
+)
+
+// RecipeCommand implements the recipe subcommand
+func RecipeCommand(args []string) error {
+	fs := flag.NewFlagSet("recipe", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel recipe list
+       rapel recipe show NAME [PARAM=VALUE...]
+
+Built-in workflows that take several commands or many flags, such as
+downloading into a bucket or over Tor. show explains a recipe and prints
+its commands with the values given filled in, as a shell script to copy
+or run; parameters left out take their defaults, and the ones without a
+default must be given.
+
+Examples:
+  rapel recipe list
+  rapel recipe show tor url=https://example.com/file.bin
+  rapel recipe show r2 url=https://example.com/f.iso account=ID bucket=isos key=f.iso | sh
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "list":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range recipe.All {
+			fmt.Fprintf(tw, "%s\t%s\n", r.Name, r.Summary)
+		}
+		return tw.Flush()
+
+	case "show":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return fmt.Errorf("recipe NAME is required")
+		}
+		r, ok := recipe.Lookup(fs.Arg(1))
+		if !ok {
+			return fmt.Errorf("unknown recipe %q, see rapel recipe list", fs.Arg(1))
+		}
+		values := make(map[string]string)
+		for _, kv := range fs.Args()[2:] {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("invalid parameter %q, want PARAM=VALUE", kv)
+			}
+			values[name] = value
+		}
+		steps, err := r.Expand(values)
+		if err != nil {
+			printRecipe(os.Stderr, r)
+			return err
+		}
+		printRecipe(os.Stdout, r)
+		fmt.Println()
+		for _, s := range steps {
+			if s.Comment != "" {
+				fmt.Printf("# %s\n", s.Comment)
+			}
+			fmt.Println(s.Shell())
+		}
+		return nil
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown recipe command, use: list, show")
+	}
+}
+
+// printRecipe writes r's description and parameters as shell comments,
+// so show's output stays a runnable script.
+func printRecipe(w *os.File, r recipe.Recipe) {
+	fmt.Fprintf(w, "# %s: %s\n#\n", r.Name, r.Summary)
+	for _, line := range strings.Split(r.Description, "\n") {
+		fmt.Fprintf(w, "# %s\n", line)
+	}
+	fmt.Fprintf(w, "#\n# Parameters:\n")
+	for _, p := range r.Params {
+		def := "required"
+		if p.Default != "" {
+			def = "default " + p.Default
+		}
+		fmt.Fprintf(w, "#   %-13s %s (%s)\n", p.Name, p.Help, def)
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/redraw/rapel/internal/downloader"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/listing"
+	"github.com/redraw/rapel/internal/merger"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// mirrorTree is what --recursive found under a directory URL: the files to
+// download, and where each goes in the local copy of the tree.
+type mirrorTree struct {
+	urls  []string
+	files map[string]mirrorFile // by URL
+}
+
+// mirrorFile is where a listed file is downloaded and merged to.
+type mirrorFile struct {
+	prefix string // chunks and state, named after its whole path so files of one name in different directories don't meet
+	dir    string // directory the merged file goes to
+	name   string
+}
+
+// listMirror lists the directory at url and lays the files out under
+// outputDir (or the current directory), in a directory named after url's
+// last path segment.
+func listMirror(ctx context.Context, config downloader.Config, url, outputDir string, opts listing.Options) (*mirrorTree, error) {
+	client, err := httpclient.NewClient(config.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Listing " + redact.URL(url))
+	files, err := listing.List(ctx, client.HTTPClient(), url, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to download under %s", redact.URL(url))
+	}
+
+	if outputDir == "" {
+		outputDir = "."
+	}
+	root := filepath.Join(outputDir, downloader.DefaultPrefix(url))
+	tree := &mirrorTree{files: make(map[string]mirrorFile, len(files))}
+	used := make(map[string]bool, len(files))
+	for _, f := range files {
+		if _, ok := tree.files[f.URL]; ok {
+			continue
+		}
+		dir, name := path.Split(f.Path)
+		flat := strings.ReplaceAll(f.Path, "/", "_")
+		prefix := flat
+		for n := 1; used[prefix]; n++ {
+			prefix = fmt.Sprintf("%d_%s", n, flat)
+		}
+		used[prefix] = true
+		tree.urls = append(tree.urls, f.URL)
+		tree.files[f.URL] = mirrorFile{prefix: prefix, dir: filepath.Join(root, filepath.FromSlash(dir)), name: name}
+	}
+	slog.Info(fmt.Sprintf("Found %d files, mirroring them into %s", len(tree.urls), root), "files", len(tree.urls), "output", root)
+	return tree, nil
+}
+
+// apply points the download of config.URL, and with merge its merge, at
+// the file's place in the tree, creating its directory.
+func (t *mirrorTree) apply(config *downloader.Config, merge *merger.Config) error {
+	f := t.files[config.URL]
+	config.Prefix = f.prefix
+	config.ContentDisposition = false
+	// A tree's files come in every size, those below -c in a single chunk
+	config.ExplicitChunkSize = false
+	config.OutputDir = f.dir
+	config.OutputName = f.name
+	if merge == nil {
+		return nil
+	}
+	merge.OutputDir = f.dir
+	merge.OutputName = f.name
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return nil
+}
+
+// globList splits a comma-separated list of --accept or --reject globs.
+func globList(s string) []string {
+	var globs []string
+	for _, glob := range strings.Split(s, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			globs = append(globs, glob)
+		}
+	}
+	return globs
+}
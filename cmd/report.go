@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// Tables rapel report --csv prints.
+const (
+	reportTimeline = "timeline"
+	reportChunks   = "chunks"
+	reportSources  = "sources"
+)
+
+// ReportCommand implements the report subcommand
+func ReportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	csvTable := fs.String("csv", "", "Print one table as CSV: timeline, chunks or sources")
+	interval := fs.Duration("interval", 0, "Timeline bucket size (default: picked from the busy time)")
+	top := fs.Int("top", 10, "Rows of the retry hotspot and slowest chunk tables")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel report [options] PREFIX
+
+Summarize a download's transfer log, .PREFIX-transfers.jsonl, which records
+every chunk request of every run: when it started and ended, the bytes it
+received, its attempt and where it went. The report shows the throughput
+over time, the chunks that needed retries, the slowest chunks and each
+source's share, for debugging a flaky origin or tuning -c and --jobs.
+
+Options:
+  --csv TABLE     Print one table as CSV instead: timeline (bytes per
+                  interval), chunks (every chunk) or sources
+  --interval D    Timeline bucket size (e.g. 10s, 1m). Default: picked so the
+                  time with requests in flight fits in about 30 rows
+  --top N         Rows of the retry hotspot and slowest chunk tables.
+                  Default: 10
+
+Examples:
+  rapel report file.bin
+  rapel report --interval 1m file.bin
+  rapel report --csv chunks file.bin > chunks.csv
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX is required")
+	}
+	switch *csvTable {
+	case "", reportTimeline, reportChunks, reportSources:
+	default:
+		return fmt.Errorf("invalid --csv %q (want timeline, chunks or sources)", *csvTable)
+	}
+	if *interval < 0 || *top < 1 {
+		return fmt.Errorf("--interval must be >= 0 and --top >= 1")
+	}
+
+	transfers, err := downloader.ReadTransferLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r := downloader.SummarizeTransfers(transfers, *interval)
+	if *csvTable != "" {
+		return writeReportCSV(r, *csvTable)
+	}
+	return printReport(r, *top)
+}
+
+// printReport prints r as tables.
+func printReport(r downloader.TransferReport, top int) error {
+	if r.Transfers == 0 {
+		fmt.Println("No transfers recorded")
+		return nil
+	}
+	fmt.Printf("Requests  : %d, %d failed, %d interrupted\n", r.Transfers, r.Failed, r.Interrupted)
+	busy := r.Busy.Round(time.Second)
+	if busy == 0 {
+		busy = r.Busy.Round(time.Millisecond)
+	}
+	fmt.Printf("Received  : %s in %s with requests in flight (%s/s)\n", formatSize(r.Bytes), busy, formatSize(int64(r.Speed())))
+	fmt.Printf("Period    : %s to %s\n", r.First.Local().Format(time.DateTime), r.Last.Local().Format(time.DateTime))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nTHROUGHPUT (per %s)\n", r.Interval)
+	fmt.Fprintln(tw, "TIME\tBYTES\tSPEED\tREQUESTS\tFAILED")
+	for _, b := range r.Timeline {
+		fmt.Fprintf(tw, "%s\t%s\t%s/s\t%d\t%d\n", b.Start.Local().Format(time.DateTime), formatSize(b.Bytes),
+			formatSize(int64(float64(b.Bytes)/r.Interval.Seconds())), b.Transfers, b.Failed)
+	}
+
+	if hot := r.RetryHotspots(top); len(hot) > 0 {
+		fmt.Fprintln(tw, "\nRETRY HOTSPOTS")
+		fmt.Fprintln(tw, "CHUNK\tRANGE\tATTEMPTS\tFAILED\tLAST ERROR")
+		for _, c := range hot {
+			fmt.Fprintf(tw, "%d\t%d-%d\t%d\t%d\t%s\n", c.Chunk, c.From, c.To, c.Attempts, c.Failed, c.LastError)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nSLOWEST CHUNKS")
+	fmt.Fprintln(tw, "CHUNK\tRANGE\tBYTES\tTIME\tSPEED\tATTEMPTS")
+	for _, c := range r.Slowest(top) {
+		fmt.Fprintf(tw, "%d\t%d-%d\t%s\t%s\t%s/s\t%d\n", c.Chunk, c.From, c.To, formatSize(c.Bytes),
+			c.Time.Round(time.Millisecond), formatSize(int64(c.Speed())), c.Attempts)
+	}
+
+	fmt.Fprintln(tw, "\nSOURCES")
+	fmt.Fprintln(tw, "SOURCE\tREQUESTS\tFAILED\tBYTES\tSPEED PER REQUEST")
+	for _, s := range r.Sources {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s/s\n", s.Source, s.Transfers, s.Failed, formatSize(s.Bytes), formatSize(int64(s.Speed())))
+	}
+	return tw.Flush()
+}
+
+// writeReportCSV writes one of r's tables as CSV, with plain numbers:
+// bytes, seconds and bytes per second.
+func writeReportCSV(r downloader.TransferReport, table string) error {
+	w := csv.NewWriter(os.Stdout)
+	seconds := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+	speed := func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) }
+
+	switch table {
+	case reportTimeline:
+		w.Write([]string{"start", "bytes", "speed", "requests", "failed"})
+		for _, b := range r.Timeline {
+			w.Write([]string{b.Start.Format(time.RFC3339Nano), strconv.FormatInt(b.Bytes, 10),
+				speed(float64(b.Bytes) / r.Interval.Seconds()), strconv.Itoa(b.Transfers), strconv.Itoa(b.Failed)})
+		}
+	case reportChunks:
+		w.Write([]string{"chunk", "from", "to", "attempts", "failed", "bytes", "seconds", "speed", "last_error"})
+		for _, c := range r.Chunks {
+			w.Write([]string{strconv.Itoa(c.Chunk), strconv.FormatInt(c.From, 10), strconv.FormatInt(c.To, 10),
+				strconv.Itoa(c.Attempts), strconv.Itoa(c.Failed), strconv.FormatInt(c.Bytes, 10),
+				seconds(c.Time), speed(c.Speed()), c.LastError})
+		}
+	case reportSources:
+		w.Write([]string{"source", "requests", "failed", "bytes", "seconds", "speed"})
+		for _, s := range r.Sources {
+			w.Write([]string{s.Source, strconv.Itoa(s.Transfers), strconv.Itoa(s.Failed), strconv.FormatInt(s.Bytes, 10),
+				seconds(s.Time), speed(s.Speed())})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/rpc"
+	"github.com/redraw/rapel/schema"
+)
+
+// rpcProgressEvery is how often the --rpc-socket's subscribers are sent
+// the download's progress.
+const rpcProgressEvery = time.Second
+
+// serveRPC serves the --rpc-socket at path with srv for dl. It returns a
+// context derived from ctx that the cancel method cancels, as Ctrl-C
+// would, and a function that stops serving.
+func serveRPC(ctx context.Context, path string, srv *rpc.Server, dl *downloader.Downloader) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	err := srv.Listen(path, func(method string, params json.RawMessage) (any, error) {
+		if method == schema.RPCCancel {
+			slog.Info("Cancel requested over the RPC socket, shutting down...")
+			cancel()
+			return true, nil
+		}
+		return dl.HandleRPC(method, params)
+	})
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(rpcProgressEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if state := dl.RPCState(); state.State == schema.RPCDownloading || state.State == schema.RPCPaused {
+				srv.Notify(schema.RPCNotifyProgress, state)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		srv.Close()
+		cancel()
+	}, nil
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// SampleCommand implements the sample subcommand
+func SampleCommand(args []string) error {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+
+	// Define flags
+	duration := fs.Duration("duration", 30*time.Second, "How long to sample for")
+	jobs := fs.Int("jobs", 4, "Concurrent range requests")
+	rangeStr := fs.String("range", "8M", "Bytes to request at a time")
+	sizeStr := fs.String("size", "", "File size, if the server doesn't answer HEAD requests")
+	timeout := fs.Duration("timeout", 15*time.Second, "Timeout for connecting and for response headers")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	asJSON := fs.Bool("json", false, "Print the summary and recommendation as JSON")
+	tlsOpts := addTLSFlags(fs)
+	fpOpts := addFingerprintFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel sample [options] URL
+
+Download throwaway ranges from random offsets of URL with --jobs
+connections for --duration, then report the throughput, latency, failed
+requests and how the server answered the ranges, with a suggested --jobs
+and -c for the real download. Nothing is written to disk.
+
+Options:
+  --duration DUR   How long to sample for (default: 30s)
+  --jobs N         Concurrent range requests (default: 4)
+  --range SIZE     Bytes to request at a time (default: 8M)
+  --size BYTES     File size, if the server doesn't answer HEAD requests
+  --timeout DUR    Timeout for connecting and for response headers (default: 15s)
+  -x URL           Proxy URL (e.g., socks5h://127.0.0.1:9050)
+  --json           Print the summary and recommendation as JSON
+%s%s
+Examples:
+  rapel sample https://example.com/file.bin
+  rapel sample --duration 1m --jobs 16 https://example.com/file.bin
+`, tlsUsage, fingerprintUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one URL is required")
+	}
+	url := fs.Arg(0)
+
+	if *duration <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	rangeSize, err := parseSize(*rangeStr)
+	if err != nil {
+		return fmt.Errorf("invalid range size: %w", err)
+	}
+	if rangeSize <= 0 {
+		return fmt.Errorf("range size must be positive")
+	}
+	var size int64
+	if *sizeStr != "" {
+		if size, err = parseSize(*sizeStr); err != nil {
+			return fmt.Errorf("invalid size: %w", err)
+		}
+	}
+
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := fpOpts.config()
+	if err != nil {
+		return err
+	}
+	if tlsConfig.Insecure {
+		slog.Warn("--insecure: server certificates are not verified")
+	}
+
+	client, err := httpclient.NewClient(httpclient.Config{
+		ProxyURL:       *proxyURL,
+		ConnectTimeout: *timeout,
+		TLS:            tlsConfig,
+		Fingerprint:    fingerprint,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if size <= 0 {
+		headCtx, cancel := context.WithTimeout(ctx, *timeout)
+		remote, err := client.Head(headCtx, url)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%w (pass --size to skip the HEAD request)", err)
+		}
+		size = remote.Size
+	}
+	rangeSize = min(rangeSize, size)
+
+	slog.Info(fmt.Sprintf("Sampling %s (%s) for %s with %d connections", redact.URL(url), formatSize(size), *duration, *jobs))
+
+	// Ctrl+C ends the run early; what was sampled so far is still reported
+	runCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		samples []httpclient.RangeSample
+		moved   atomic.Int64
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for range *jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := countingDiscard{&moved}
+			for runCtx.Err() == nil {
+				// Random offsets, so a cache in front of the server
+				// doesn't serve every request from the same few ranges
+				from := rand.Int64N(size - rangeSize + 1)
+				s := client.SampleRange(runCtx, url, from, from+rangeSize-1, w)
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+				if s.Ignored {
+					// Every request would send the whole file again
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	summary := httpclient.SummarizeSamples(samples, *jobs, moved.Load(), elapsed)
+	advice := summary.Recommend(size)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			URL            string                   `json:"url"`
+			Size           int64                    `json:"size"`
+			Summary        httpclient.SampleSummary `json:"summary"`
+			Recommendation httpclient.SampleAdvice  `json:"recommendation"`
+		}{redact.URL(url), size, summary, advice})
+	}
+
+	printSampleSummary(summary)
+	fmt.Println()
+	fmt.Println("Recommendation:")
+	fmt.Printf("  rapel download --jobs %d -c %s %s\n", advice.Jobs, chunkSizeArg(advice.ChunkSize), redact.URL(url))
+	for _, note := range advice.Notes {
+		fmt.Println("  - " + note)
+	}
+	return nil
+}
+
+// printSampleSummary prints what a sampling run measured.
+func printSampleSummary(s httpclient.SampleSummary) {
+	fmt.Printf("Duration    : %s\n", s.Duration.Round(100*time.Millisecond))
+	fmt.Printf("Requests    : %d (%d complete)\n", s.Requests, s.Completed)
+	fmt.Printf("Downloaded  : %s\n", formatSize(s.Bytes))
+	fmt.Printf("Throughput  : %s/s (%s/s per connection)\n", formatSize(int64(s.Throughput)), formatSize(int64(s.PerConn)))
+	fmt.Printf("Latency     : %s median, %s p95\n", s.LatencyP50.Round(time.Millisecond), s.LatencyP95.Round(time.Millisecond))
+
+	ranges := "206 with the requested range"
+	switch {
+	case s.Ignored > 0:
+		ranges = "ignored (200 with the whole file)"
+	case s.Mismatch > 0:
+		ranges = fmt.Sprintf("206, but %d responses covered other bytes", s.Mismatch)
+	case s.Bytes == 0:
+		ranges = "unknown (no request was answered)"
+	}
+	fmt.Printf("Ranges      : %s\n", ranges)
+
+	if len(s.Faults) == 0 {
+		fmt.Println("Failures    : none")
+		return
+	}
+	faults := make([]string, 0, len(s.Faults))
+	for fault, n := range s.Faults {
+		faults = append(faults, fmt.Sprintf("%d %s", n, fault))
+	}
+	sort.Strings(faults)
+	fmt.Printf("Failures    : %.1f%% of requests:", 100*s.FaultRate())
+	for _, f := range faults {
+		fmt.Print(" " + f)
+	}
+	fmt.Println()
+	codes := make([]int, 0, len(s.Statuses))
+	for code := range s.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("              status %d: %d\n", code, s.Statuses[code])
+	}
+}
+
+// chunkSizeArg writes a chunk size as a -c value.
+func chunkSizeArg(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1000 * 1000 * 1000}, {"M", 1000 * 1000}, {"K", 1000}} {
+		if n >= u.size && n%u.size == 0 {
+			return fmt.Sprintf("%d%s", n/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// countingDiscard drops what is written to it, adding the byte count to n.
+type countingDiscard struct {
+	n *atomic.Int64
+}
+
+func (w countingDiscard) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
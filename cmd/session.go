@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// ExportCommand implements the export subcommand
+func ExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+
+	// Define flags
+	output := fs.String("o", "", "Archive to write (default: PREFIX.rapel.tar.gz, - for stdout)")
+	parts := fs.Bool("parts", false, "Include the chunk files")
+	secrets := fs.Bool("include-secrets", false, "Include the unredacted URL")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel export [options] PREFIX
+
+Pack the state of an unfinished download in the current directory into a
+.tar.gz, for rapel import to continue it on another machine or in another
+directory: its arguments, progress, hash state, manifest and the list of
+completed chunks. Chunk files are only included with --parts; otherwise
+copy them separately, or let the download fetch them again.
+
+Options:
+  -o FILE            Archive to write (default: PREFIX.rapel.tar.gz, - for stdout)
+  --parts            Include the .part and .tmp chunk files (and a --follow output)
+  --include-secrets  Include the unredacted URL (signatures, tokens, passwords)
+
+Examples:
+  rapel export file.bin
+  rapel export --parts -o - file.bin | ssh host 'rapel import --dir /data -'
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX is required")
+	}
+	prefix := fs.Arg(0)
+
+	path := *output
+	if path == "" {
+		path = prefix + ".rapel.tar.gz"
+	}
+	var f *os.File
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		w = f
+	}
+
+	s, err := downloader.Export(w, prefix, downloader.ExportOptions{Parts: *parts, Secrets: *secrets})
+	if f != nil {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %w", path, closeErr)
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	what := "state"
+	if *parts {
+		what = "state and chunk files"
+	}
+	slog.Info(fmt.Sprintf("Exported %s of %s (%d/%d chunks complete) to %s", what, prefix, len(s.Completed), s.Chunks, path),
+		"prefix", prefix, "completed", len(s.Completed), "chunks", s.Chunks, "output", path)
+	return nil
+}
+
+// ImportCommand implements the import subcommand
+func ImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+
+	// Define flags
+	dir := fs.String("dir", ".", "Directory to continue the download in")
+	force := fs.Bool("force", false, "Replace state and chunk files already there")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel import [options] FILE
+
+Unpack an archive written by rapel export, so that running the same
+rapel download in --dir continues the download. Paths in the state that
+pointed into the exported directory are rewritten to point into --dir.
+FILE may be - for stdin.
+
+Options:
+  --dir DIR   Directory to continue the download in (default: .)
+  --force     Replace state and chunk files already there
+
+Examples:
+  rapel import file.bin.rapel.tar.gz
+  rapel import --dir /data file.bin.rapel.tar.gz
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one FILE is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	res, err := downloader.Import(r, downloader.ImportOptions{Dir: *dir, Force: *force})
+	if err != nil {
+		return err
+	}
+
+	s := res.Session
+	slog.Info(fmt.Sprintf("Imported %s into %s (%d/%d chunks complete when exported from %s)", s.Prefix, res.Dir, len(s.Completed), s.Chunks, s.Dir),
+		"prefix", s.Prefix, "dir", res.Dir, "completed", len(s.Completed), "chunks", s.Chunks)
+	if len(res.Missing) > 0 {
+		slog.Warn(fmt.Sprintf("%d completed chunks have no file in %s: copy the .part files there before resuming, or they are downloaded again", len(res.Missing), res.Dir),
+			"missing", len(res.Missing))
+	}
+	fmt.Printf("Resume by running the same rapel download command in %s\n", res.Dir)
+	return nil
+}
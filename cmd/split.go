@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/redraw/rapel/internal/splitter"
+)
+
+// SplitCommand implements the split subcommand
+func SplitCommand(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+
+	// Define flags
+	logOpts := addLogFlags(fs)
+	profOpts := addProfileFlags(fs)
+	chunkSizeStr := fs.String("c", "100M", "Chunk size (e.g., 50M, 1G)")
+	prefix := fs.String("o", "", "Chunk filename prefix (default: input file name)")
+	url := fs.String("url", "", "Origin URL to record in the state file (default: file:// URL of the input)")
+	force := fs.Bool("force", false, "Overwrite existing chunk files")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel split [options] FILE
+
+Split a local file into <prefix>.NNNNNN.part chunks plus a .{prefix}-args.json
+state file in the current directory, the inverse of merge. With --url, a later
+'rapel download URL' sees every chunk as already downloaded.
+
+Options:
+  -c SIZE        Chunk size (K, M, G suffix). Default: 100M
+  -o PREFIX      Chunk filename prefix. Default: input file name
+  --url URL      Origin URL to record. Default: file:// URL of the input
+  --force        Overwrite existing chunk files
+%s%s
+Examples:
+  rapel split -c 50M backup.tar
+  rapel split --url https://example.com/file.bin file.bin
+`, logUsage, profileUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	stopProfile, err := profOpts.start()
+	if err != nil {
+		return err
+	}
+	defer stopProfile()
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("FILE is required")
+	}
+
+	chunkSize, err := parseSize(*chunkSizeStr)
+	if err != nil {
+		return fmt.Errorf("invalid chunk size: %w", err)
+	}
+
+	s := splitter.NewSplitter(splitter.Config{
+		Input:     fs.Arg(0),
+		ChunkSize: chunkSize,
+		Prefix:    *prefix,
+		URL:       *url,
+		Force:     *force,
+	})
+
+	return s.Split()
+}
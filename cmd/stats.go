@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redraw/rapel/internal/stats"
+)
+
+// StatsCommand implements the stats subcommand
+func StatsCommand(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+
+	// Define flags
+	asJSON := fs.Bool("json", false, "Print stats as JSON")
+	reset := fs.Bool("reset", false, "Delete all recorded stats")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel stats [options]
+
+Show lifetime download statistics: total bytes downloaded, download
+outcomes, and per-host totals. Stats are kept in
+$XDG_DATA_HOME/rapel/stats.json (default ~/.local/share/rapel/stats.json).
+
+Options:
+  --json    Print stats as JSON
+  --reset   Delete all recorded stats
+
+Examples:
+  rapel stats
+  rapel stats --json | jq '.hosts'
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *reset {
+		if err := stats.Reset(); err != nil {
+			return fmt.Errorf("failed to reset stats: %w", err)
+		}
+		fmt.Println("Stats reset")
+		return nil
+	}
+
+	s, err := stats.Load()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	if s.FirstRecord.IsZero() {
+		fmt.Println("No downloads recorded")
+		return nil
+	}
+
+	fmt.Printf("Downloaded: %s since %s\n", formatSize(s.Bytes), s.FirstRecord.Local().Format(time.DateOnly))
+	fmt.Printf("Downloads:  %d succeeded, %d failed, %d cancelled\n", s.Succeeded, s.Failed, s.Cancelled)
+
+	if len(s.Hosts) == 0 {
+		return nil
+	}
+
+	// Busiest hosts first
+	hosts := make([]string, 0, len(s.Hosts))
+	for host := range s.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := s.Hosts[hosts[i]], s.Hosts[hosts[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tBYTES\tOK\tFAILED\tCANCELLED")
+	for _, host := range hosts {
+		c := s.Hosts[host]
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", host, formatSize(c.Bytes), c.Succeeded, c.Failed, c.Cancelled)
+	}
+	return tw.Flush()
+}
+
+// formatSize formats bytes in human-readable format
+func formatSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	units := []string{"KB", "MB", "GB", "TB"}
+	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+)
+
+// statusReport is what rapel status --json prints.
+type statusReport struct {
+	Prefix         string                  `json:"prefix"`
+	InProgress     bool                    `json:"in_progress"` // the download's state is still there
+	Chunks         int                     `json:"chunks,omitempty"`
+	Complete       int                     `json:"complete,omitempty"`
+	PostPartFailed []downloader.DeadLetter `json:"post_part_failed"`
+}
+
+// StatusCommand implements the status subcommand
+func StatusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	clearList := fs.Bool("clear", false, "Forget the failed post-part commands")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel status [options] PREFIX
+
+Show how far a download got and the chunks whose post-part command failed
+after all its retries. They stay listed, even once the download is done,
+until a later run's command succeeds for them or --clear drops the list.
+Exits with status 1 while any are listed.
+
+Options:
+  --json    Print the status as JSON
+  --clear   Forget the failed post-part commands (after handling them by hand)
+
+Examples:
+  rapel status file.bin
+  rapel status --json file.bin | jq -r '.post_part_failed[].part'
+`)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("PREFIX is required")
+	}
+	prefix := fs.Arg(0)
+
+	failed, err := downloader.LoadDeadLetters(prefix)
+	if err != nil {
+		return err
+	}
+	if *clearList {
+		n := failed.Len()
+		if err := failed.Delete(); err != nil {
+			return err
+		}
+		fmt.Printf("Forgot %d failed post-part command(s)\n", n)
+		return nil
+	}
+
+	report := statusReport{Prefix: prefix, PostPartFailed: failed.Failed}
+	if report.PostPartFailed == nil {
+		report.PostPartFailed = []downloader.DeadLetter{}
+	}
+	state, err := downloader.LoadDownloadArguments(prefix)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		completed, err := state.CompletedChunks()
+		if err != nil {
+			return err
+		}
+		report.InProgress = true
+		report.Chunks = state.NumChunks()
+		for _, done := range completed {
+			if done {
+				report.Complete++
+			}
+		}
+	}
+	if state == nil && failed.Len() == 0 {
+		return fmt.Errorf("no download state or post-part failures found for %s", prefix)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		if report.InProgress {
+			fmt.Printf("%s: %d of %d chunks complete\n", prefix, report.Complete, report.Chunks)
+		} else {
+			fmt.Printf("%s: no download in progress\n", prefix)
+		}
+		if len(report.PostPartFailed) == 0 {
+			fmt.Println("No failed post-part commands")
+			return nil
+		}
+		fmt.Printf("\nPost-part command failed for %d chunk(s):\n", len(report.PostPartFailed))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHUNK\tPART\tATTEMPTS\tFAILED AT\tERROR")
+		for _, dl := range report.PostPartFailed {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", dl.Chunk, dl.Part, dl.Attempts, dl.FailedAt.Local().Format(time.DateTime), dl.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(report.PostPartFailed) > 0 {
+		return fmt.Errorf("the post-part command failed for %d chunk(s)", len(report.PostPartFailed))
+	}
+	return nil
+}
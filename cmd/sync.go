@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/zsync"
+)
+
+// SyncCommand implements the sync subcommand
+func SyncCommand(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+
+	// Define flags
+	logOpts := addLogFlags(fs)
+	profOpts := addProfileFlags(fs)
+	tlsOpts := addTLSFlags(fs)
+	fpOpts := addFingerprintFlags(fs)
+	control := fs.String("zsync", "", "zsync control file, path or URL (default: URL.zsync)")
+	jobs := fs.Int("jobs", 4, "Concurrent range requests")
+	retries := fs.Int("r", 5, "Retries per range request")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	dialTimeout := fs.Duration("dial-timeout", 30*time.Second, "Timeout for establishing a connection (TCP and TLS)")
+	dryRun := fs.Bool("dry-run", false, "Only report how much would be downloaded")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel sync [options] URL FILE
+
+Update FILE, an older or damaged copy of URL, by downloading only the
+blocks it lacks. The blocks of the remote file are described by a zsync
+control file, which many mirrors publish next to large images as
+URL.zsync. FILE is scanned at every byte offset, so data that moved
+between versions is reused too.
+
+When every reused block is already at its final offset, FILE is patched
+in place. Otherwise the new version is assembled in FILE.zsync-part,
+which needs room for a second copy, and renamed over FILE. Downloaded
+blocks are checked against the control file before being written, and
+the result against its SHA-1.
+
+Options:
+  --zsync SRC        Control file, local path or URL. Default: URL.zsync
+  --jobs N           Concurrent range requests. Default: 4
+  -r N               Retries per range request. Default: 5
+  -x URL             Proxy URL (e.g., socks5h://127.0.0.1:9050)
+  --dial-timeout D   Timeout for establishing a connection. Default: 30s
+  --dry-run          Only report how much of FILE can be reused
+%s%s%s%s
+Examples:
+  rapel sync https://example.com/image.iso image.iso
+  rapel sync --zsync image.iso.zsync https://mirror.example.com/image.iso image.iso
+`, tlsUsage, fingerprintUsage, logUsage, profileUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("a URL and a local file are required")
+	}
+	url, path := fs.Arg(0), fs.Arg(1)
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	if *retries < 0 {
+		return fmt.Errorf("-r must not be negative")
+	}
+
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := fpOpts.config()
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	stopProfile, err := profOpts.start()
+	if err != nil {
+		return err
+	}
+	defer stopProfile()
+
+	if tlsConfig.Insecure {
+		slog.Warn("--insecure: server certificates are not verified")
+	}
+
+	local, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open local copy: %w (use rapel download for a first copy)", err)
+	}
+	defer local.Close()
+
+	client, err := httpclient.NewClient(httpclient.Config{
+		ProxyURL:       *proxyURL,
+		ConnectTimeout: *dialTimeout,
+		TLS:            tlsConfig,
+		Fingerprint:    fingerprint,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := *control
+	if source == "" {
+		source = url + ".zsync"
+	}
+	ctl, err := loadControl(ctx, client, source)
+	if err != nil {
+		return err
+	}
+
+	// A control file for another version would fail block checks halfway
+	// through; the size usually gives it away before anything is written
+	if remote, err := client.Head(ctx, url); err != nil {
+		slog.Debug("Could not check the remote size", "error", err)
+	} else if remote.Size != ctl.Length {
+		return fmt.Errorf("server reports %d bytes but the control file says %d: it describes another version", remote.Size, ctl.Length)
+	}
+
+	slog.Info(fmt.Sprintf("Scanning %s against %d blocks of %d bytes", path, len(ctl.Blocks), ctl.BlockSize),
+		"file", path, "blocks", len(ctl.Blocks), "block_size", ctl.BlockSize)
+	plan, err := zsync.Match(local, ctl)
+	if err != nil {
+		return err
+	}
+	local.Close()
+
+	reused := plan.Reused()
+	missing := ctl.Length - reused
+	percent := 100.0
+	if ctl.Length > 0 {
+		percent = float64(reused) / float64(ctl.Length) * 100
+	}
+	spans := plan.Missing(int((8 << 20) / ctl.BlockSize))
+	slog.Info(fmt.Sprintf("Local copy provides %s of %s (%.1f%%), %s to download in %d ranges",
+		formatSize(reused), formatSize(ctl.Length), percent, formatSize(missing), len(spans)),
+		"reused", reused, "download", missing, "ranges", len(spans), "in_place", plan.InPlace())
+
+	if *dryRun {
+		return nil
+	}
+
+	start := time.Now()
+	err = plan.Apply(ctx, path, zsync.ApplyOptions{
+		Jobs:    *jobs,
+		Retries: *retries,
+		Fetch: func(ctx context.Context, start, end int64, w io.Writer) error {
+			return client.DownloadRange(ctx, url, start, end, w)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+
+	slog.Info(fmt.Sprintf("Sync complete: %s (%s downloaded in %s)", path, formatSize(missing), time.Since(start).Round(time.Second)),
+		"file", path, "downloaded", missing)
+	return nil
+}
+
+// loadControl reads a zsync control file from a path or URL.
+func loadControl(ctx context.Context, client *httpclient.Client, source string) (*zsync.Control, error) {
+	var data []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var buf bytes.Buffer
+		if err := client.Get(ctx, source, &buf); err != nil {
+			return nil, fmt.Errorf("failed to fetch control file %s: %w (point --zsync at one)", redact.URL(source), err)
+		}
+		data = buf.Bytes()
+	} else {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, fmt.Errorf("failed to read control file: %w", err)
+		}
+	}
+
+	ctl, err := zsync.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", redact.URL(source), err)
+	}
+	return ctl, nil
+}
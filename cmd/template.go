@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/logging"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// maxTemplateURLs bounds what a URL template may expand to, so a typo in a
+// range can't start millions of downloads.
+const maxTemplateURLs = 100000
+
+// templateURLs returns the URLs url stands for: every one its template
+// expands to, or url alone with --globoff.
+func templateURLs(url string, globoff bool) ([]string, error) {
+	if globoff {
+		return []string{url}, nil
+	}
+	return expandURLTemplate(url)
+}
+
+// expandURLTemplate expands the brace expressions in url, as a shell
+// would: {1..120} or {001..120} (zero-padded to the width of the bounds)
+// for a numeric range, and {a,b,c} for a list. Several expressions give
+// every combination, in order. Braces holding neither are kept as they are.
+func expandURLTemplate(url string) ([]string, error) {
+	open := -1
+	for i := 0; i < len(url); i++ {
+		switch url[i] {
+		case '{':
+			open = i
+		case '}':
+			if open < 0 {
+				continue
+			}
+			items, err := braceItems(url[open+1 : i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL template %s: %w", url[open:i+1], err)
+			}
+			if items == nil {
+				open = -1
+				continue
+			}
+			rest, err := expandURLTemplate(url[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			if len(items)*len(rest) > maxTemplateURLs {
+				return nil, fmt.Errorf("URL template expands to more than %d URLs", maxTemplateURLs)
+			}
+			urls := make([]string, 0, len(items)*len(rest))
+			for _, item := range items {
+				for _, r := range rest {
+					urls = append(urls, url[:open]+item+r)
+				}
+			}
+			return urls, nil
+		}
+	}
+	return []string{url}, nil
+}
+
+// braceItems returns what the brace expression body stands for, or nil if
+// it is no range or list.
+func braceItems(body string) ([]string, error) {
+	if from, to, ok := strings.Cut(body, ".."); ok {
+		first, err1 := strconv.Atoi(from)
+		last, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil {
+			return nil, nil
+		}
+		if first > last {
+			return nil, fmt.Errorf("range runs backwards")
+		}
+		if last-first >= maxTemplateURLs {
+			return nil, fmt.Errorf("range has more than %d numbers", maxTemplateURLs)
+		}
+		width := 0
+		if (len(from) > 1 && from[0] == '0') || (len(to) > 1 && to[0] == '0') {
+			width = max(len(from), len(to))
+		}
+		items := make([]string, 0, last-first+1)
+		for n := first; n <= last; n++ {
+			items = append(items, fmt.Sprintf("%0*d", width, n))
+		}
+		return items, nil
+	}
+	if strings.Contains(body, ",") {
+		return strings.Split(body, ","), nil
+	}
+	return nil, nil
+}
+
+// template downloads every URL a template expanded to, or every file of a
+// mirror into its place in the tree, each as a download of its own (state,
+// chunk files and merged file) but sharing --jobs: up to that many files
+// are downloaded at once, with no more than that many chunks in flight
+// between them. A failed download doesn't stop the others.
+func (r *downloadRun) template(ctx context.Context, config downloader.Config, urls []string) error {
+	jobs := config.MaxConcurrency
+	slog.Info(fmt.Sprintf("Downloading %d files, %d jobs shared between them", len(urls), jobs), "files", len(urls), "jobs", jobs)
+	pool := downloader.NewJobPool(jobs)
+	events := r.events
+
+	running := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	for _, url := range urls {
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-running }()
+
+			c := config
+			c.URL = url
+			c.Pool = pool
+			c.Events = events.With("url", redact.URL(url))
+			run := *r
+			run.events = c.Events
+			var err error
+			if r.mirror != nil {
+				err = r.mirror.apply(&c, &run.mergeConfig)
+			}
+			if err == nil {
+				err = run.run(ctx, c)
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error(fmt.Sprintf("%s: %v", redact.URL(url), err), "url", redact.URL(url), "error", err)
+				mu.Lock()
+				failed = append(failed, redact.URL(url))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logging.Blank()
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d downloads failed: %s", len(failed), len(urls), strings.Join(failed, ", "))
+	}
+	slog.Info(fmt.Sprintf("All %d downloads complete", len(urls)), "files", len(urls))
+	return nil
+}
+
+// dryRunTemplate prints the plan of every URL a template expanded to, or
+// of every file of a mirror.
+func dryRunTemplate(ctx context.Context, config downloader.Config, urls []string, mirror *mirrorTree, opts dryRunOptions) error {
+	problems := 0
+	for i, url := range urls {
+		if i > 0 {
+			logging.Blank()
+		}
+		c := config
+		c.URL = url
+		if mirror != nil {
+			mirror.apply(&c, nil)
+		}
+		dl, err := downloader.NewDownloader(c)
+		if err == nil {
+			err = dryRun(ctx, dl, opts)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("%s: %v", redact.URL(url), err), "url", redact.URL(url), "error", err)
+			problems++
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d of %d downloads can't go ahead as planned", problems, len(urls))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandURLTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want []string
+	}{
+		{"no template", "https://example.com/file.bin", []string{"https://example.com/file.bin"}},
+		{"range", "https://example.com/part-{1..3}.bin", []string{
+			"https://example.com/part-1.bin", "https://example.com/part-2.bin", "https://example.com/part-3.bin",
+		}},
+		{"zero-padded range", "https://example.com/part-{08..10}.bin", []string{
+			"https://example.com/part-08.bin", "https://example.com/part-09.bin", "https://example.com/part-10.bin",
+		}},
+		{"padded to the wider bound", "https://example.com/{1..002}", []string{
+			"https://example.com/001", "https://example.com/002",
+		}},
+		{"list", "https://example.com/{train,test}.tar", []string{
+			"https://example.com/train.tar", "https://example.com/test.tar",
+		}},
+		{"several expressions", "https://example.com/{a,b}/{1..2}", []string{
+			"https://example.com/a/1", "https://example.com/a/2", "https://example.com/b/1", "https://example.com/b/2",
+		}},
+		{"braces of neither kept", "https://example.com/{id}/{1..2}", []string{
+			"https://example.com/{id}/1", "https://example.com/{id}/2",
+		}},
+		{"unmatched open brace", "https://example.com/{a,b", []string{"https://example.com/{a,b"}},
+		{"unmatched close brace", "https://example.com/a,b}/{1..2}", []string{
+			"https://example.com/a,b}/1", "https://example.com/a,b}/2",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandURLTemplate(tt.url)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpandURLTemplateErrors(t *testing.T) {
+	_, err := expandURLTemplate("https://example.com/part-{10..1}.bin")
+	assert.ErrorContains(t, err, "invalid URL template {10..1}: range runs backwards")
+
+	_, err = expandURLTemplate(fmt.Sprintf("https://example.com/{0..%d}", maxTemplateURLs))
+	assert.ErrorContains(t, err, "range has more than")
+
+	// Each range is within the cap, but not every combination of them
+	_, err = expandURLTemplate("https://example.com/{1..1000}/{1..1000}")
+	assert.ErrorContains(t, err, fmt.Sprintf("URL template expands to more than %d URLs", maxTemplateURLs))
+
+	urls, err := expandURLTemplate(fmt.Sprintf("https://example.com/{1..%d}", maxTemplateURLs))
+	require.NoError(t, err)
+	assert.Len(t, urls, maxTemplateURLs)
+}
+
+func TestBraceItems(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"1..3", []string{"1", "2", "3"}},
+		{"-1..1", []string{"-1", "0", "1"}},
+		{"5..5", []string{"5"}},
+		{"01..3", []string{"01", "02", "03"}},
+		{"a,b,", []string{"a", "b", ""}},
+		{"a..b", nil},
+		{"1..", nil},
+		{"id", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := braceItems(tt.body)
+		require.NoError(t, err, tt.body)
+		assert.Equal(t, tt.want, got, tt.body)
+	}
+}
+
+func TestTemplateURLsGloboff(t *testing.T) {
+	url := "https://example.com/part-{1..3}.bin"
+	urls, err := templateURLs(url, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{url}, urls)
+
+	urls, err = templateURLs(url, false)
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+}
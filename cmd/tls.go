@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// tlsFlags holds the TLS flags shared by subcommands that make requests.
+type tlsFlags struct {
+	caCert     *string
+	cert       *string
+	key        *string
+	insecure   *bool
+	minVersion *string
+}
+
+// addTLSFlags registers --cacert, --cert, --key, --insecure and
+// --tls-min-version on fs.
+func addTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		caCert:     fs.String("cacert", "", "PEM CA bundle to trust in addition to the system roots"),
+		cert:       fs.String("cert", "", "PEM client certificate for mutual TLS (needs --key)"),
+		key:        fs.String("key", "", "PEM private key for --cert"),
+		insecure:   fs.Bool("insecure", false, "Don't verify server certificates"),
+		minVersion: fs.String("tls-min-version", "", "Lowest TLS version to accept: 1.0, 1.1, 1.2 or 1.3"),
+	}
+}
+
+// config returns the TLS settings for the HTTP client, with absolute file
+// paths so they survive entering a workdir. The files are read when the
+// client is created.
+func (tf *tlsFlags) config() (httpclient.TLSConfig, error) {
+	if *tf.minVersion != "" {
+		if _, err := httpclient.ParseTLSVersion(*tf.minVersion); err != nil {
+			return httpclient.TLSConfig{}, err
+		}
+	}
+	if (*tf.cert == "") != (*tf.key == "") {
+		return httpclient.TLSConfig{}, fmt.Errorf("--cert and --key must be given together")
+	}
+
+	cfg := httpclient.TLSConfig{Insecure: *tf.insecure, MinVersion: *tf.minVersion}
+	for _, f := range []struct {
+		dst  *string
+		path string
+	}{{&cfg.CACertFile, *tf.caCert}, {&cfg.CertFile, *tf.cert}, {&cfg.KeyFile, *tf.key}} {
+		if f.path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(f.path)
+		if err != nil {
+			return httpclient.TLSConfig{}, fmt.Errorf("invalid path %s: %w", f.path, err)
+		}
+		*f.dst = abs
+	}
+	return cfg, nil
+}
+
+// tlsUsage is the help text for the TLS flags.
+const tlsUsage = `
+TLS:
+  --cacert FILE        Trust the CAs in FILE (PEM) in addition to the system roots
+  --cert FILE          Client certificate for mutual TLS (PEM); needs --key
+  --key FILE           Private key for --cert (PEM, unencrypted)
+  --insecure           Don't verify server certificates
+  --tls-min-version V  Lowest TLS version to accept: 1.0, 1.1, 1.2 or 1.3
+`
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/uploader"
+)
+
+// UploadCommand implements the upload subcommand
+func UploadCommand(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+
+	// Define flags
+	logOpts := addLogFlags(fs)
+	profOpts := addProfileFlags(fs)
+	tlsOpts := addTLSFlags(fs)
+	chunkSizeStr := fs.String("c", "16M", "Part size (e.g., 16M, 100M)")
+	jobs := fs.Int("jobs", 4, "Concurrent part uploads")
+	retries := fs.Int("r", 5, "Retries per part")
+	force := fs.Bool("force", false, "Discard the saved upload state and start over")
+	stateBackups := fs.Int("state-backups", 1, "Previous generations of the upload state file to keep")
+	proxyURL := fs.String("x", "", "Proxy URL (e.g., socks5h://127.0.0.1:9050)")
+	dialTimeout := fs.Duration("dial-timeout", 30*time.Second, "Timeout for establishing a connection (TCP and TLS)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: rapel upload [options] FILE TARGET
+
+Upload FILE in parts, several at a time. Finished parts are recorded in
+.FILE-upload.json in the current directory, so an interrupted upload
+continues where it stopped when run again with the same FILE and TARGET.
+
+Targets:
+  s3://bucket/key        S3 multipart upload (credentials as for
+                         download --storage). Parts need -c 5Mi or more
+  tus+https://host/path  tus.io upload created at the endpoint. Parts go up
+                         in parallel if the server supports concatenation,
+                         otherwise in order, resuming at the server's offset
+  https://host/path      PUT of each part with a Content-Range header
+
+Options:
+  -c SIZE            Part size (K, M, G or Ki, Mi, Gi suffix). Default: 16M
+  --jobs N           Concurrent part uploads. Default: 4
+  -r N               Retries per part. Default: 5
+  --force            Discard the saved upload state and start over
+  --state-backups N  Keep N previous generations of the state file. Default: 1
+  -x URL             Proxy URL (e.g., socks5h://127.0.0.1:9050)
+  --dial-timeout D   Timeout for establishing a connection. Default: 30s
+%s%s%s
+Examples:
+  rapel upload backup.tar s3://bucket/backups/backup.tar
+  rapel upload -c 50M video.mp4 tus+https://uploads.example.com/files/
+  rapel upload --jobs 8 image.iso https://dav.example.com/images/image.iso
+`, tlsUsage, logUsage, profileUsage)
+	}
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("a file and a target are required")
+	}
+	file, targetURL := fs.Arg(0), fs.Arg(1)
+
+	chunkSize, err := parseSize(*chunkSizeStr)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("invalid part size: %s", *chunkSizeStr)
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	if *retries < 0 || *stateBackups < 0 {
+		return fmt.Errorf("-r and --state-backups cannot be negative")
+	}
+
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := logOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	stopProfile, err := profOpts.start()
+	if err != nil {
+		return err
+	}
+	defer stopProfile()
+
+	if tlsConfig.Insecure {
+		slog.Warn("--insecure: server certificates are not verified")
+	}
+
+	client, err := httpclient.NewClient(httpclient.Config{
+		ProxyURL:       *proxyURL,
+		ConnectTimeout: *dialTimeout,
+		ReadTimeout:    5 * time.Minute,
+		TLS:            tlsConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	target, err := uploader.NewTarget(targetURL, client.HTTPClient())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	u := uploader.New(uploader.Config{
+		File:         file,
+		TargetURL:    targetURL,
+		Target:       target,
+		ChunkSize:    chunkSize,
+		Jobs:         *jobs,
+		Retries:      *retries,
+		Force:        *force,
+		StateBackups: *stateBackups,
+	})
+	if err := u.Upload(ctx); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package config loads the optional rapel configuration file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// Config holds settings that are awkward to express as flags.
+//
+// Example config.json:
+//
+//	{
+//	  "proxy_rules": [
+//	    {"host": "*.internal", "proxy": "direct"},
+//	    {"host": "*.onion", "proxy": "socks5h://127.0.0.1:9050"}
+//	  ]
+//	}
+type Config struct {
+	ProxyRules []httpclient.ProxyRule `json:"proxy_rules"`
+}
+
+// DefaultPath returns the default config file location
+// (e.g. ~/.config/rapel/config.json on Linux).
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rapel", "config.json")
+}
+
+// Load reads the config file at path. If path is empty the default location
+// is used, and a missing default file yields an empty Config.
+func Load(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath()
+		if path == "" {
+			return &Config{}, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for _, rule := range cfg.ProxyRules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid proxy rule in %s: %w", path, err)
+		}
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,121 @@
+// Package control implements the per-download control socket that lets
+// `rapel ctl` adjust a running download. The protocol is one request line
+// per connection, answered by one line starting with "ok " or "error ".
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds how long Send waits for a running download to answer.
+const dialTimeout = 5 * time.Second
+
+// SocketPath returns the control socket path for prefix. Like the lock
+// file, it lives next to the chunk files in the download directory.
+func SocketPath(prefix string) string {
+	return fmt.Sprintf(".%s.sock", prefix)
+}
+
+// Handler executes one request line and returns the reply text.
+type Handler func(line string) (string, error)
+
+// Server accepts control connections until closed.
+type Server struct {
+	ln net.Listener
+	h  Handler
+	wg sync.WaitGroup
+}
+
+// Listen creates the socket at path, readable by the user alone, and serves
+// requests with h. The caller must hold the download's lock, so any existing
+// socket file is stale.
+func Listen(path string, h Handler) (*Server, error) {
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to create control socket: %w", err)
+	}
+
+	s := &Server{ln: ln, h: h}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops accepting requests and removes the socket.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+// handle answers a single request. Requests are tiny and rare, so they are
+// served one at a time.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+
+	reply, err := s.h(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Fprintf(conn, "error %s\n", err)
+		return
+	}
+	fmt.Fprintf(conn, "ok %s\n", reply)
+}
+
+// Send delivers one request to the socket at path and returns the reply.
+func Send(path, line string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("no running download (missing %s)", path)
+		}
+		return "", fmt.Errorf("cannot reach download: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read reply: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+
+	if msg, ok := strings.CutPrefix(reply, "error "); ok {
+		return "", errors.New(msg)
+	}
+	return strings.TrimPrefix(reply, "ok "), nil
+}
@@ -0,0 +1,38 @@
+package control
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendAndServe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SocketPath("file.bin"))
+
+	srv, err := Listen(path, func(line string) (string, error) {
+		if line == "fail" {
+			return "", errors.New("bad request")
+		}
+		return "got " + line, nil
+	})
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reply, err := Send(path, "set jobs=2")
+	require.NoError(t, err)
+	assert.Equal(t, "got set jobs=2", reply)
+
+	_, err = Send(path, "fail")
+	assert.EqualError(t, err, "bad request")
+
+	require.NoError(t, srv.Close())
+
+	_, err = Send(path, "get")
+	assert.ErrorContains(t, err, "no running download")
+}
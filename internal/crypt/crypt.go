@@ -0,0 +1,543 @@
+// Package crypt encrypts chunk files at rest with a passphrase.
+//
+// An encrypted file is a header followed by records of at most 64KB of
+// plaintext, each sealed with AES-256-GCM. The file key is derived from
+// the passphrase with PBKDF2 (the slow part, done once per salt) and
+// HKDF with a per-segment salt. Records are numbered through the nonce,
+// so they can't be reordered, and a finished file ends with an empty
+// final record, so truncation is noticed. A file being written can always
+// be cut back to its last whole record and continued, which is how
+// interrupted chunks resume. The records written after that go in a new
+// segment: another header, with a fresh salt and so a fresh key, holding
+// the plaintext offset it starts at. A record cut off (or a final record
+// taken back) may have reached the disk, so its nonce is never used again
+// under the same key. Encrypted files may be concatenated, as
+// --pack-parts does; readers decode them one after another.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	magic        = "RAPELENC"
+	version      = 1
+	headerSize   = len(magic) + 1 + 4 + saltSize + saltSize + 8 + checkSize
+	saltSize     = 16
+	checkSize    = 8
+	recordSize   = 64 << 10 // plaintext bytes per record
+	tagSize      = 16
+	finalFlag    = 1 << 31
+	kdfIteration = 600_000
+)
+
+// ErrWrongKey is returned for a file encrypted with another passphrase.
+var ErrWrongKey = errors.New("wrong passphrase")
+
+// Key encrypts and decrypts files with one passphrase.
+type Key struct {
+	passphrase string
+	iterations int
+
+	mu      sync.Mutex
+	salt    []byte            // KDF salt for files this Key creates
+	masters map[string][]byte // derived master keys by KDF salt
+}
+
+// NewKey returns a Key for passphrase.
+func NewKey(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the passphrase is empty")
+	}
+	return &Key{passphrase: passphrase, iterations: kdfIteration, masters: make(map[string][]byte)}, nil
+}
+
+// master returns the PBKDF2 key for salt, deriving it on first use.
+func (k *Key) master(salt []byte, iterations int) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := fmt.Sprintf("%x/%d", salt, iterations)
+	if m, ok := k.masters[id]; ok {
+		return m, nil
+	}
+	m, err := pbkdf2.Key(sha256.New, k.passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	k.masters[id] = m
+	return m, nil
+}
+
+// header is the parsed start of a segment of an encrypted file.
+type header struct {
+	iterations int
+	kdfSalt    []byte
+	fileSalt   []byte
+	offset     uint64 // plaintext bytes in the file before the segment
+	check      []byte
+}
+
+func (h *header) marshal() []byte {
+	b := make([]byte, 0, headerSize)
+	b = append(b, magic...)
+	b = append(b, version)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.iterations))
+	b = append(b, h.kdfSalt...)
+	b = append(b, h.fileSalt...)
+	b = binary.BigEndian.AppendUint64(b, h.offset)
+	return append(b, h.check...)
+}
+
+func parseHeader(b []byte) (*header, error) {
+	if len(b) < headerSize || string(b[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not an encrypted chunk file")
+	}
+	if b[len(magic)] != version {
+		return nil, fmt.Errorf("unsupported encryption version %d", b[len(magic)])
+	}
+	b = b[len(magic)+1:]
+	h := &header{iterations: int(binary.BigEndian.Uint32(b))}
+	b = b[4:]
+	h.kdfSalt, b = b[:saltSize], b[saltSize:]
+	h.fileSalt, b = b[:saltSize], b[saltSize:]
+	h.offset, b = binary.BigEndian.Uint64(b), b[8:]
+	h.check = b[:checkSize]
+	return h, nil
+}
+
+// cipher derives the file's AEAD and key check value.
+func (k *Key) cipher(h *header) (cipher.AEAD, []byte, error) {
+	master, err := k.master(h.kdfSalt, h.iterations)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileKey, err := hkdf.Key(sha256.New, master, h.fileSalt, "rapel chunk file", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	mac := hmac.New(sha256.New, fileKey)
+	mac.Write([]byte("rapel key check"))
+	check := mac.Sum(nil)[:checkSize]
+
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, check, err
+}
+
+// newHeader starts a new segment at plaintext offset: a fresh file salt
+// under the Key's KDF salt.
+func (k *Key) newHeader(offset uint64) (*header, cipher.AEAD, error) {
+	k.mu.Lock()
+	if k.salt == nil {
+		k.salt = make([]byte, saltSize)
+		rand.Read(k.salt)
+	}
+	h := &header{iterations: k.iterations, kdfSalt: k.salt, fileSalt: make([]byte, saltSize), offset: offset}
+	k.mu.Unlock()
+	rand.Read(h.fileSalt)
+
+	aead, check, err := k.cipher(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.check = check
+	return h, aead, nil
+}
+
+// open checks a segment header against the Key.
+func (k *Key) open(b []byte) (*header, cipher.AEAD, error) {
+	h, err := parseHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, check, err := k.cipher(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal(check, h.check) {
+		return nil, nil, ErrWrongKey
+	}
+	return h, aead, nil
+}
+
+func nonce(seq uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], seq)
+	return n
+}
+
+// additionalData authenticates a record's length prefix and its segment's
+// offset, so segments can't be dropped or moved within a file.
+func additionalData(lenPrefix []byte, offset uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(lenPrefix), offset)
+}
+
+// isHeader reports whether the 4 bytes where a record may start begin a
+// segment header instead. Record lengths are at most 64KB, with the final
+// flag in the top bit, so they never start with the magic.
+func isHeader(b []byte) bool {
+	return string(b) == magic[:4]
+}
+
+// IsEncrypted reports whether the file at path starts like an encrypted
+// chunk file.
+func IsEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, len(magic))
+	_, err = io.ReadFull(f, b)
+	return err == nil && string(b) == magic
+}
+
+// PlainSize returns the plaintext size of the encrypted file at path,
+// which needs no key: record headers carry the lengths. A torn last
+// record, as an interrupted write leaves, doesn't count.
+func PlainSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size, _, _, err := scan(f, info.Size())
+	return size, err
+}
+
+// scan walks the record headers of the (possibly concatenated) encrypted
+// files in r. It returns the plaintext size, the offset after the last
+// whole record and the number of records since the last segment header.
+func scan(r io.ReaderAt, size int64) (plain, end int64, records uint64, err error) {
+	var pos int64
+	hdr := make([]byte, headerSize)
+	var lenBuf [4]byte
+	inFile := false
+	for pos < size {
+		if !inFile {
+			if size-pos < int64(headerSize) {
+				break
+			}
+			if _, err := r.ReadAt(hdr, pos); err != nil {
+				return 0, 0, 0, err
+			}
+			if _, err := parseHeader(hdr); err != nil {
+				return 0, 0, 0, err
+			}
+			pos += int64(headerSize)
+			end, records, inFile = pos, 0, true
+			continue
+		}
+		if size-pos < 4 {
+			break
+		}
+		if _, err := r.ReadAt(lenBuf[:], pos); err != nil {
+			return 0, 0, 0, err
+		}
+		if isHeader(lenBuf[:]) {
+			// A segment continuing the file after a resume
+			inFile = false
+			continue
+		}
+		v := binary.BigEndian.Uint32(lenBuf[:])
+		n := int64(v &^ finalFlag)
+		if n > recordSize {
+			return 0, 0, 0, fmt.Errorf("corrupt record at offset %d", pos)
+		}
+		if pos+4+n+tagSize > size {
+			break
+		}
+		pos += 4 + n + tagSize
+		plain += n
+		end = pos
+		records++
+		if v&finalFlag != 0 {
+			inFile = false
+		}
+	}
+	return plain, end, records, nil
+}
+
+// Writer encrypts what is written to it into records.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	offset uint64 // of the segment being written
+	seq    uint64
+	buf    []byte
+}
+
+// NewWriter starts a new encrypted file on w.
+func (k *Key) NewWriter(w io.Writer) (*Writer, error) {
+	return k.newSegment(w, 0)
+}
+
+// newSegment writes a segment header for plaintext offset to w and
+// returns a Writer for the segment's records.
+func (k *Key) newSegment(w io.Writer, offset uint64) (*Writer, error) {
+	h, aead, err := k.newHeader(offset)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(h.marshal()); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, offset: offset, buf: make([]byte, 0, recordSize)}, nil
+}
+
+// Write buffers p, sealing a record every 64KB.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(0); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush seals buffered data as a short record, so everything written so
+// far is on disk and can be resumed from.
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.seal(0)
+}
+
+// Finish flushes and writes the final record. Nothing may be written after.
+func (w *Writer) Finish() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.seal(finalFlag)
+}
+
+func (w *Writer) seal(flag uint32) error {
+	rec := make([]byte, 4, 4+len(w.buf)+tagSize)
+	binary.BigEndian.PutUint32(rec, uint32(len(w.buf))|flag)
+	rec = w.aead.Seal(rec, nonce(w.seq), w.buf, additionalData(rec[:4], w.offset))
+	if _, err := w.w.Write(rec); err != nil {
+		return err
+	}
+	w.seq++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// OpenAppend opens the encrypted file at path for appending: a torn last
+// record is cut off and a final record removed, and writing continues the
+// file in a new segment. A missing file, or one with nothing sealed yet,
+// is started afresh. It returns the file, a Writer on it and the
+// plaintext bytes the file already holds.
+func (k *Key) OpenAppend(path string) (*os.File, *Writer, int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	fail := func(err error) (*os.File, *Writer, int64, error) {
+		f.Close()
+		return nil, nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	afresh := func() (*os.File, *Writer, int64, error) {
+		if err := f.Truncate(0); err != nil {
+			return fail(err)
+		}
+		w, err := k.NewWriter(f)
+		if err != nil {
+			return fail(err)
+		}
+		return f, w, 0, nil
+	}
+
+	hdr := make([]byte, headerSize)
+	if info.Size() < int64(headerSize) {
+		return afresh()
+	}
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return fail(err)
+	}
+	if _, _, err := k.open(hdr); err != nil {
+		return fail(err)
+	}
+
+	plain, end, records, err := scan(f, info.Size())
+	if err != nil {
+		return fail(err)
+	}
+
+	// A final record means the file was finished but never renamed: take
+	// the final record back off so appending continues the file
+	if records > 0 {
+		var lenBuf [4]byte
+		last := end - tagSize - 4
+		if _, err := f.ReadAt(lenBuf[:], last); err == nil && binary.BigEndian.Uint32(lenBuf[:]) == finalFlag {
+			end, records = last, records-1
+		}
+	}
+
+	if end == int64(headerSize) {
+		// Nothing was sealed yet
+		return afresh()
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	w, err := k.newSegment(f, uint64(plain))
+	if err != nil {
+		return fail(err)
+	}
+	return f, w, plain, nil
+}
+
+// Reader decrypts (possibly concatenated) encrypted files.
+type Reader struct {
+	r      io.Reader
+	key    *Key
+	aead   cipher.AEAD // nil between files
+	offset uint64      // of the current segment
+	pos    uint64      // plaintext bytes read from the current file
+	seq    uint64
+	buf    []byte // decrypted data not yet returned
+	rec    []byte
+	read   bool // at least one file was read
+}
+
+// NewReader returns a Reader decrypting r.
+func (k *Key) NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, key: k, rec: make([]byte, recordSize+tagSize)}
+}
+
+// Read returns decrypted data. It fails on a wrong key, on a record that
+// was tampered with, and on a file that ends before its final record.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next record into buf.
+func (r *Reader) next() error {
+	if r.aead == nil {
+		hdr := make([]byte, headerSize)
+		n, err := io.ReadFull(r.r, hdr)
+		if n == 0 && err == io.EOF && r.read {
+			return io.EOF
+		}
+		if err != nil {
+			return fmt.Errorf("truncated encryption header: %w", err)
+		}
+		if err := r.segment(hdr, 0); err != nil {
+			return err
+		}
+		r.read = true
+	}
+
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(r.r, lenBuf[:]); err != nil {
+			return fmt.Errorf("encrypted file is truncated: %w", io.ErrUnexpectedEOF)
+		}
+		if !isHeader(lenBuf[:]) {
+			break
+		}
+		hdr := make([]byte, headerSize)
+		copy(hdr, lenBuf[:])
+		if _, err := io.ReadFull(r.r, hdr[4:]); err != nil {
+			return fmt.Errorf("truncated encryption header: %w", err)
+		}
+		if err := r.segment(hdr, r.pos); err != nil {
+			return err
+		}
+	}
+	v := binary.BigEndian.Uint32(lenBuf[:])
+	n := int(v &^ finalFlag)
+	if n > recordSize {
+		return fmt.Errorf("corrupt encrypted record")
+	}
+	rec := r.rec[:n+tagSize]
+	if _, err := io.ReadFull(r.r, rec); err != nil {
+		return fmt.Errorf("encrypted file is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	plain, err := r.aead.Open(rec[:0], nonce(r.seq), rec, additionalData(lenBuf[:], r.offset))
+	if err != nil {
+		return fmt.Errorf("encrypted record %d failed authentication", r.seq)
+	}
+	r.seq++
+	r.pos += uint64(n)
+	r.buf = plain
+	if v&finalFlag != 0 {
+		r.aead = nil
+	}
+	return nil
+}
+
+// segment starts decrypting the segment with header hdr, which must begin
+// at plaintext offset pos of the file.
+func (r *Reader) segment(hdr []byte, pos uint64) error {
+	h, aead, err := r.key.open(hdr)
+	if err != nil {
+		return err
+	}
+	if h.offset != pos {
+		return fmt.Errorf("encrypted segment for offset %d found at %d", h.offset, pos)
+	}
+	r.aead, r.offset, r.pos, r.seq = aead, h.offset, pos, 0
+	return nil
+}
+
+// SniffHeader returns the first n plaintext bytes of the encrypted file at
+// path, or fewer if it is shorter.
+func (k *Key) SniffHeader(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var b bytes.Buffer
+	_, err = io.CopyN(&b, k.NewReader(f), int64(n))
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T, passphrase string) *Key {
+	k, err := NewKey(passphrase)
+	require.NoError(t, err)
+	k.iterations = 1000 // keep tests fast
+	return k
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := testKey(t, "secret")
+	data := randomBytes(3*recordSize + 123)
+
+	var buf bytes.Buffer
+	w, err := k.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data[:1000])
+	require.NoError(t, err)
+	_, err = w.Write(data[1000:])
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), string(data[:64]))
+
+	got, err := io.ReadAll(k.NewReader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Truncated before the final record
+	_, err = io.ReadAll(k.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-20])))
+	assert.ErrorContains(t, err, "truncated")
+
+	// Tampered
+	tampered := bytes.Clone(buf.Bytes())
+	tampered[headerSize+10] ^= 1
+	_, err = io.ReadAll(k.NewReader(bytes.NewReader(tampered)))
+	assert.ErrorContains(t, err, "failed authentication")
+
+	// Another passphrase
+	_, err = io.ReadAll(testKey(t, "other").NewReader(bytes.NewReader(buf.Bytes())))
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestOpenAppend(t *testing.T) {
+	k := testKey(t, "secret")
+	path := filepath.Join(t.TempDir(), "f.000000.tmp")
+	data := randomBytes(2*recordSize + 500)
+
+	f, w, n, err := k.OpenAppend(path)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	_, err = w.Write(data[:recordSize+100])
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, f.Close())
+
+	// A torn record from an interrupted write is cut off
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 9, 9, 9})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	size, err := PlainSize(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(recordSize+100), size)
+
+	f, w, n, err = k.OpenAppend(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(recordSize+100), n)
+	_, err = w.Write(data[n:])
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	require.NoError(t, f.Close())
+
+	// Packed: two files back to back
+	second, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var packed bytes.Buffer
+	packed.Write(second)
+	packed.Write(second)
+	got, err := io.ReadAll(k.NewReader(&packed))
+	require.NoError(t, err)
+	assert.Equal(t, append(bytes.Clone(data), data...), got)
+
+	_, _, _, err = testKey(t, "other").OpenAppend(path)
+	assert.ErrorIs(t, err, ErrWrongKey)
+	assert.True(t, IsEncrypted(path))
+}
+
+// sealedRecords maps each record of an encrypted file to its ciphertext,
+// keyed by the segment's salts and the record's nonce.
+func sealedRecords(t *testing.T, b []byte) map[string][]byte {
+	records := make(map[string][]byte)
+	var salts []byte
+	var seq uint64
+	for pos := 0; pos < len(b); {
+		if isHeader(b[pos : pos+4]) {
+			h, err := parseHeader(b[pos:])
+			require.NoError(t, err)
+			salts = append(bytes.Clone(h.kdfSalt), h.fileSalt...)
+			seq = 0
+			pos += headerSize
+			continue
+		}
+		n := int(binary.BigEndian.Uint32(b[pos:]) &^ finalFlag)
+		if pos+4+n+tagSize > len(b) {
+			break // torn
+		}
+		records[fmt.Sprintf("%x/%x", salts, nonce(seq))] = b[pos : pos+4+n+tagSize]
+		pos += 4 + n + tagSize
+		seq++
+	}
+	return records
+}
+
+func TestOpenAppendNeverReusesNonce(t *testing.T) {
+	k := testKey(t, "secret")
+	path := filepath.Join(t.TempDir(), "f.000000.tmp")
+	data := randomBytes(2*recordSize + 500)
+
+	var snapshots [][]byte
+	appendData := func(want int64, upto int, finish bool) {
+		t.Helper()
+		f, w, n, err := k.OpenAppend(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, n)
+		_, err = w.Write(data[n:upto])
+		require.NoError(t, err)
+		if finish {
+			require.NoError(t, w.Finish())
+		} else {
+			require.NoError(t, w.Flush())
+		}
+		require.NoError(t, f.Close())
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		snapshots = append(snapshots, b)
+	}
+
+	appendData(0, recordSize+100, false)
+	// The last record is torn after it reached the disk
+	require.NoError(t, os.Truncate(path, int64(len(snapshots[0])-10)))
+	appendData(recordSize, recordSize+200, false)
+	appendData(recordSize+200, recordSize+300, true)
+	// Finished but not renamed: the final record is taken back
+	appendData(recordSize+300, len(data), true)
+
+	seen := make(map[string][]byte)
+	for _, b := range snapshots {
+		for id, rec := range sealedRecords(t, b) {
+			if prev, ok := seen[id]; ok {
+				assert.Equal(t, prev, rec, "key and nonce %s sealed two records", id)
+			}
+			seen[id] = rec
+		}
+	}
+
+	got, err := io.ReadAll(k.NewReader(bytes.NewReader(snapshots[len(snapshots)-1])))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// A segment dropped from the middle is noticed
+	last := snapshots[len(snapshots)-1]
+	second := bytes.Index(last[headerSize:], []byte(magic)) + headerSize
+	third := bytes.Index(last[second+headerSize:], []byte(magic)) + second + headerSize
+	dropped := append(bytes.Clone(last[:second]), last[third:]...)
+	_, err = io.ReadAll(k.NewReader(bytes.NewReader(dropped)))
+	assert.ErrorContains(t, err, "encrypted segment")
+}
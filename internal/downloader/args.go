@@ -4,6 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/storage"
 )
 
 // DownloadArguments holds the arguments that determine chunk layout and file
@@ -12,30 +18,91 @@ import (
 // can refuse to resume against incompatible inputs. Runtime knobs like
 // --jobs, --post-part, proxy, retries, etc. are intentionally NOT persisted —
 // they can change freely between runs without invalidating chunks on disk.
+//
+// URL is stored redacted (signatures, tokens and passwords replaced) and the
+// download is identified by URLHash, a SHA-256 fingerprint of the full URL.
+// When the URL carries secrets, the full URL is kept separately in
+// .{prefix}-secrets.json with owner-only permissions.
+//
+// Every chunk but the last has ChunkSize bytes, unless a resume with
+// another -c re-sliced what was left to download: Layout then says where
+// the chunks are.
+//
+// FilenamePrefix carries a short hash of the URL when rapel picked the
+// name (see HashedPrefix); OutputName is then what the merged file is
+// called.
 type DownloadArguments struct {
-	URL            string `json:"url"`
-	TotalSize      int64  `json:"total_size"`
-	ChunkSize      int64  `json:"chunk_size"`
-	FilenamePrefix string `json:"filename_prefix"`
+	URL            string    `json:"url"`
+	URLHash        string    `json:"url_sha256,omitempty"`
+	TotalSize      int64     `json:"total_size"`
+	ChunkSize      int64     `json:"chunk_size"`
+	Layout         []Segment `json:"layout,omitempty"`
+	FilenamePrefix string    `json:"filename_prefix"`
+	OutputName     string    `json:"output_name,omitempty"`
+
+	starts   []int64 // where each chunk starts, from Layout
+	filePath string  // unexported, set by Load or the first Save
+	rawURL   string  // full URL, only known for freshly created args
+	backups  int     // previous generations of the args file kept by Save
+}
 
-	filePath string // unexported, set after New/Load
+// Segment is a stretch of the file cut into chunks of ChunkSize bytes,
+// the last one possibly shorter.
+type Segment struct {
+	Offset    int64 `json:"offset"`
+	Size      int64 `json:"size"`
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// setLayout makes layout the chunk layout, nil for ChunkSize throughout.
+func (a *DownloadArguments) setLayout(layout []Segment) {
+	a.Layout = layout
+	a.starts = nil
+	for _, seg := range layout {
+		for at := seg.Offset; at < seg.Offset+seg.Size; at += seg.ChunkSize {
+			a.starts = append(a.starts, at)
+		}
+	}
+}
+
+// secretsFile holds the unredacted values for a download.
+type secretsFile struct {
+	URL string `json:"url"`
+}
+
+// Matches reports whether these args were created for url.
+// Args written before URL fingerprints existed are compared verbatim.
+func (a *DownloadArguments) Matches(url string) bool {
+	if a.URLHash != "" {
+		return a.URLHash == redact.Fingerprint(url)
+	}
+	return a.URL == url
 }
 
 // NewDownloadArguments creates a new DownloadArguments.
 func NewDownloadArguments(url string, totalSize, chunkSize int64, prefix string) *DownloadArguments {
 	return &DownloadArguments{
-		URL:            url,
+		URL:            redact.URL(url),
+		URLHash:        redact.Fingerprint(url),
+		rawURL:         url,
 		TotalSize:      totalSize,
 		ChunkSize:      chunkSize,
 		FilenamePrefix: prefix,
-		filePath:       fmt.Sprintf(".%s-args.json", prefix),
 	}
 }
 
+// ArgsPath returns the args file of prefix.
+func ArgsPath(prefix string) string {
+	return registry.StatePath(prefix, "args.json")
+}
+
 // LoadDownloadArguments loads args from a JSON file, or returns (nil, nil) if not found.
 func LoadDownloadArguments(prefix string) (*DownloadArguments, error) {
-	filePath := fmt.Sprintf(".%s-args.json", prefix)
+	return loadArgsFile(ArgsPath(prefix))
+}
 
+// loadArgsFile loads args from filePath; see LoadDownloadArguments.
+func loadArgsFile(filePath string) (*DownloadArguments, error) {
 	data, err := os.ReadFile(filePath)
 	if os.IsNotExist(err) {
 		return nil, nil
@@ -48,11 +115,21 @@ func LoadDownloadArguments(prefix string) (*DownloadArguments, error) {
 	if err := json.Unmarshal(data, &args); err != nil {
 		return nil, fmt.Errorf("failed to parse args file: %w", err)
 	}
+	if err := args.checkLayout(); err != nil {
+		return nil, fmt.Errorf("invalid args file: %w", err)
+	}
+	args.setLayout(args.Layout)
 
 	args.filePath = filePath
 	return &args, nil
 }
 
+// KeepBackups makes Save keep n previous generations of the args file
+// (.{prefix}-args.json.1 being the newest).
+func (a *DownloadArguments) KeepBackups(n int) {
+	a.backups = n
+}
+
 // Save writes args to a JSON file atomically. Should be called once at the start of a download.
 func (a *DownloadArguments) Save() error {
 	data, err := json.MarshalIndent(a, "", "  ")
@@ -60,30 +137,108 @@ func (a *DownloadArguments) Save() error {
 		return fmt.Errorf("failed to marshal args: %w", err)
 	}
 
-	tmpPath := a.filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+	// New args are saved where the download keeps its state, which
+	// registering it may have decided since they were made
+	if a.filePath == "" {
+		a.filePath = ArgsPath(a.FilenamePrefix)
+	}
+	if err := fsutil.RotateBackups(a.filePath, a.backups); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(a.filePath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write args file: %w", err)
 	}
 
-	if err := os.Rename(tmpPath, a.filePath); err != nil {
-		return fmt.Errorf("failed to rename args file: %w", err)
+	if a.rawURL != "" && redact.HasSecrets(a.rawURL) {
+		if err := a.saveSecrets(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Delete removes the args file.
+// saveSecrets writes the full URL to the owner-only secrets file.
+func (a *DownloadArguments) saveSecrets() error {
+	data, err := json.MarshalIndent(secretsFile{URL: a.rawURL}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	path := SecretsPath(a.FilenamePrefix)
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSecretURL returns the full URL stored for prefix, or "" if the URL had no secrets.
+func LoadSecretURL(prefix string) (string, error) {
+	data, err := os.ReadFile(SecretsPath(prefix))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	var secrets secretsFile
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return "", fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+	return secrets.URL, nil
+}
+
+// SecretsPath returns the secrets filename for prefix.
+func SecretsPath(prefix string) string {
+	return registry.StatePath(prefix, "secrets.json")
+}
+
+// Delete removes the args file, its backups and any secrets file.
 func (a *DownloadArguments) Delete() error {
+	if a.filePath == "" {
+		a.filePath = ArgsPath(a.FilenamePrefix)
+	}
+	fsutil.RemoveBackups(a.filePath)
+	if err := os.Remove(SecretsPath(a.FilenamePrefix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Remove(a.filePath)
 }
 
+// checkLayout reports a Layout that doesn't cover the file exactly once.
+func (a *DownloadArguments) checkLayout() error {
+	var at int64
+	for _, seg := range a.Layout {
+		if seg.Offset != at || seg.Size <= 0 || seg.ChunkSize <= 0 {
+			return fmt.Errorf("chunk layout has a gap or overlap at byte %d", at)
+		}
+		at += seg.Size
+	}
+	if len(a.Layout) > 0 && at != a.TotalSize {
+		return fmt.Errorf("chunk layout covers %d bytes, not %d", at, a.TotalSize)
+	}
+	return nil
+}
+
 // NumChunks returns the total number of chunks for this download.
 func (a *DownloadArguments) NumChunks() int {
+	if len(a.Layout) > 0 {
+		return len(a.starts)
+	}
 	return int((a.TotalSize + a.ChunkSize - 1) / a.ChunkSize)
 }
 
 // ChunkRange returns the byte range [start, end] (inclusive) for chunk i.
 func (a *DownloadArguments) ChunkRange(i int) (start, end int64) {
+	if len(a.Layout) > 0 {
+		start, end = a.starts[i], a.TotalSize-1
+		if i+1 < len(a.starts) {
+			end = a.starts[i+1] - 1
+		}
+		return
+	}
 	start = int64(i) * a.ChunkSize
 	end = start + a.ChunkSize - 1
 	if end >= a.TotalSize {
@@ -92,6 +247,14 @@ func (a *DownloadArguments) ChunkRange(i int) (start, end int64) {
 	return
 }
 
+// ChunkAt returns the chunk holding byte offset pos.
+func (a *DownloadArguments) ChunkAt(pos int64) int {
+	if len(a.Layout) > 0 {
+		return sort.Search(len(a.starts), func(i int) bool { return a.starts[i] > pos }) - 1
+	}
+	return int(pos / a.ChunkSize)
+}
+
 // ChunkSizeAt returns the number of bytes in chunk i.
 func (a *DownloadArguments) ChunkSizeAt(i int) int64 {
 	start, end := a.ChunkRange(i)
@@ -100,10 +263,10 @@ func (a *DownloadArguments) ChunkSizeAt(i int) int64 {
 
 // PartPath returns the .part filename for chunk i.
 func (a *DownloadArguments) PartPath(i int) string {
-	return fmt.Sprintf("%s.%06d.part", a.FilenamePrefix, i)
+	return storage.PartName(a.FilenamePrefix, i)
 }
 
 // TmpPath returns the .tmp filename for chunk i.
 func (a *DownloadArguments) TmpPath(i int) string {
-	return fmt.Sprintf("%s.%06d.tmp", a.FilenamePrefix, i)
+	return storage.TmpName(a.FilenamePrefix, i)
 }
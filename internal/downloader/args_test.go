@@ -100,3 +100,16 @@ func TestChunkSizeAt(t *testing.T) {
 	assert.Equal(t, int64(1000), args.ChunkSizeAt(1))
 	assert.Equal(t, int64(500), args.ChunkSizeAt(2)) // last chunk is smaller
 }
+
+func TestArgumentsRedactURL(t *testing.T) {
+	url := "https://example.com/file.bin?X-Amz-Signature=abc"
+	args := NewDownloadArguments(url, 1000, 100, "file.bin")
+
+	assert.Equal(t, "https://example.com/file.bin?X-Amz-Signature=REDACTED", args.URL)
+	assert.True(t, args.Matches(url))
+	assert.False(t, args.Matches("https://example.com/file.bin?X-Amz-Signature=other"))
+
+	// Args saved before fingerprints existed compare the URL verbatim
+	legacy := &DownloadArguments{URL: "https://example.com/file.bin"}
+	assert.True(t, legacy.Matches("https://example.com/file.bin"))
+}
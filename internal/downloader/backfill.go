@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// BackfillPostPart runs the post-part command for prefix's chunks already
+// complete on disk, as the download would have after each, without a
+// request to the server: for a hook added or fixed after the chunks were
+// downloaded. It works from the download's state, or once that is gone
+// from the .part files alone. With failedOnly, only the chunks listed as
+// failed (see DeadLetters) run. Retries, timeouts, the failure limit and
+// the failure list work as during a download.
+func (d *Downloader) BackfillPostPart(ctx context.Context, prefix string, failedOnly bool) error {
+	if !d.config.HasPostPartCmd() {
+		return fmt.Errorf("no post-part command to run")
+	}
+
+	lock, err := registry.Acquire(registry.LockPath(prefix))
+	if errors.Is(err, registry.ErrLocked) {
+		return fmt.Errorf("%w: its post-part commands run there", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	args, err := LoadDownloadArguments(prefix)
+	if err != nil {
+		return err
+	}
+	if args == nil {
+		if args, err = partsLayout(prefix); err != nil {
+			return err
+		}
+	}
+	d.args = args
+	d.progress = NewProgressTracker(args)
+	if d.deadLetters, err = LoadDeadLetters(prefix); err != nil {
+		return err
+	}
+
+	failed := make(map[int]bool)
+	for _, dl := range d.deadLetters.Failed {
+		failed[dl.Chunk] = true
+	}
+	var chunks []int
+	for i := 0; i < args.NumChunks(); i++ {
+		if failedOnly && !failed[i] {
+			continue
+		}
+		if _, err := os.Stat(args.PartPath(i)); err == nil {
+			chunks = append(chunks, i)
+		}
+	}
+	if len(chunks) == 0 {
+		slog.Info(fmt.Sprintf("No chunk files of %s to run the post-part command for", prefix), "file", prefix)
+		return nil
+	}
+	slog.Info(fmt.Sprintf("Running the post-part command for %d chunks of %s", len(chunks), prefix),
+		"file", prefix, "chunks", len(chunks))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	d.stopDownload = cancel
+	d.postPartCh = make(chan int, len(chunks))
+	// Interrupted, the commands running finish and the rest are skipped
+	stop := context.AfterFunc(ctx, func() { d.postPartStop.Store(true) })
+	defer stop()
+	d.startPostPartWorkers(ctx)
+	for _, i := range chunks {
+		d.postPartCh <- i
+	}
+	close(d.postPartCh)
+	d.postPartWg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	if n := d.postPartFailed.Load(); n > 0 {
+		return fmt.Errorf("the post-part command failed for %d of %d chunks, listed by rapel status %s", n, len(chunks), prefix)
+	}
+	slog.Info(fmt.Sprintf("Ran the post-part command for %d chunks", len(chunks)), "file", prefix, "chunks", len(chunks))
+	return nil
+}
+
+// partsLayout rebuilds the layout of a finished download, whose state is
+// gone, from its .part files: the chunk size is the largest of them and
+// the file ends with the last one. Chunks moved away by an earlier hook
+// leave gaps, which are fine as long as one full chunk is left.
+func partsLayout(prefix string) (*DownloadArguments, error) {
+	files, err := scanChunkFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var chunkSize int64
+	var last chunkOnDisk
+	for _, f := range files {
+		if !f.complete {
+			continue
+		}
+		if f.first != f.last {
+			return nil, fmt.Errorf("%s packs several chunks, which post-part commands don't run for", f.name)
+		}
+		chunkSize = max(chunkSize, f.size)
+		last = f
+	}
+	if chunkSize == 0 {
+		return nil, fmt.Errorf("no download state or chunk files found for %s", prefix)
+	}
+	return NewDownloadArguments("", int64(last.first)*chunkSize+last.size, chunkSize, prefix), nil
+}
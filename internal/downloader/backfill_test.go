@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillPostPart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	// A finished download, state gone, chunk 1 already moved away
+	require.NoError(t, os.WriteFile(storage.PartName("f", 0), make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(storage.PartName("f", 2), make([]byte, 40), 0644))
+
+	d, err := NewDownloader(Config{PostPartCmd: "echo {idx} {start} {end} >> ran"})
+	require.NoError(t, err)
+	require.NoError(t, d.BackfillPostPart(context.Background(), "f", false))
+
+	ran, err := os.ReadFile("ran")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(ran)), "\n")
+	assert.ElementsMatch(t, []string{"0 0 99", "2 200 239"}, lines)
+
+	// Only the chunk that failed before
+	l, err := LoadDeadLetters("f")
+	require.NoError(t, err)
+	require.NoError(t, l.Add(DeadLetter{Chunk: 2, Error: "exit status 1", ExitCode: 1, Attempts: 1}))
+	require.NoError(t, os.Remove("ran"))
+
+	d, err = NewDownloader(Config{PostPartCmd: "echo {idx} >> ran; exit {idx}"})
+	require.NoError(t, err)
+	err = d.BackfillPostPart(context.Background(), "f", true)
+	assert.ErrorContains(t, err, "failed for 1 of 1 chunks")
+	ran, err = os.ReadFile("ran")
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(ran))
+	l, err = LoadDeadLetters("f")
+	require.NoError(t, err)
+	require.Len(t, l.Failed, 1)
+	assert.Equal(t, 2, l.Failed[0].ExitCode)
+}
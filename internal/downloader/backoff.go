@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff policies: how the wait before a retry grows with the attempt.
+const (
+	BackoffExponential = "exponential" // Base × 2^attempt
+	BackoffLinear      = "linear"      // Base × attempt
+	BackoffConstant    = "constant"    // Base
+)
+
+// BackoffPolicies lists the valid values of Backoff.Policy.
+var BackoffPolicies = []string{BackoffExponential, BackoffLinear, BackoffConstant}
+
+// Default backoff: 2s, 4s, 8s, ... up to a minute.
+const (
+	defaultBackoffBase = time.Second
+	defaultBackoffMax  = time.Minute
+)
+
+// Backoff is the wait between attempts of a chunk request. Every retry
+// loop uses it, ranged, piped, endgame tail or single stream, and there
+// is no retry below them in the HTTP client, so the longest a chunk can
+// wait in all is the sum of Delay over --retries attempts.
+type Backoff struct {
+	Policy string        // one of BackoffPolicies ("" = exponential)
+	Base   time.Duration // 0 = 1s
+	Max    time.Duration // cap on a single wait (0 = 1m)
+	Jitter float64       // take up to this fraction off each wait at random, so chunks that failed together don't retry together (0-1)
+}
+
+// Delay returns the wait before retry attempt (1 for the first retry).
+func (b Backoff) Delay(attempt int) time.Duration {
+	base, limit := b.Base, b.Max
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	if limit <= 0 {
+		limit = defaultBackoffMax
+	}
+
+	var delay time.Duration
+	switch b.Policy {
+	case BackoffConstant:
+		delay = base
+	case BackoffLinear:
+		delay = base * time.Duration(attempt)
+	default:
+		delay = base
+		for i := 0; i < attempt && delay < limit; i++ {
+			delay *= 2
+		}
+	}
+	if delay > limit || delay < 0 {
+		delay = limit
+	}
+
+	if b.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * b.Jitter * rand.Float64())
+	}
+	return delay
+}
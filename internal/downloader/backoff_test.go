@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	// The zero value keeps the old 2s, 4s, ... up to 1m
+	var b Backoff
+	assert.Equal(t, 2*time.Second, b.Delay(1))
+	assert.Equal(t, 32*time.Second, b.Delay(5))
+	assert.Equal(t, time.Minute, b.Delay(6))
+	assert.Equal(t, time.Minute, b.Delay(100))
+
+	b = Backoff{Policy: BackoffLinear, Base: 3 * time.Second, Max: 10 * time.Second}
+	assert.Equal(t, 3*time.Second, b.Delay(1))
+	assert.Equal(t, 9*time.Second, b.Delay(3))
+	assert.Equal(t, 10*time.Second, b.Delay(4))
+
+	b = Backoff{Policy: BackoffConstant, Base: 500 * time.Millisecond}
+	assert.Equal(t, 500*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 500*time.Millisecond, b.Delay(8))
+
+	// Jitter only shortens a wait, by up to its fraction
+	b = Backoff{Policy: BackoffConstant, Base: time.Second, Jitter: 0.5}
+	for range 100 {
+		d := b.Delay(1)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.LessOrEqual(t, d, time.Second)
+	}
+}
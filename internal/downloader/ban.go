@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// errBanCooldown marks a refusal absorbed by a ban cool-down. It isn't
+// the chunk's failure, so it doesn't use up a retry.
+var errBanCooldown = errors.New("server refusing requests, waiting out a cool-down")
+
+// maxBanWorkers caps how many refusals in a row make a ban, so a large
+// --jobs doesn't delay noticing one.
+const maxBanWorkers = 3
+
+// banGuard notices when a server that was serving the download starts
+// refusing every new request with 403 or 429, as a rate limit or an IP ban
+// does, and holds new requests for a cool-down instead of letting each
+// chunk burn its retries while the ban lasts. A ban is only assumed
+// after a success, so a URL that never worked fails as usual, and only
+// once per success, so a permanent ban still ends the download.
+type banGuard struct {
+	cooldown time.Duration // 0 = off
+	workers  int           // refusals in a row that make a ban
+
+	mu      sync.Mutex
+	served  bool
+	refused int // since the last request that returned data
+	until   time.Time
+}
+
+// newBanGuard returns a guard for a download with jobs concurrent chunks.
+func newBanGuard(cooldown time.Duration, jobs int) banGuard {
+	workers := maxBanWorkers
+	if jobs < workers {
+		workers = max(jobs, 1)
+	}
+	return banGuard{cooldown: cooldown, workers: workers}
+}
+
+// banStatus reports whether err is a refusal that may mean a ban.
+func banStatus(err error) (*httpclient.StatusError, bool) {
+	var se *httpclient.StatusError
+	if errors.As(err, &se) && (se.Code == http.StatusForbidden || se.Code == http.StatusTooManyRequests) {
+		return se, true
+	}
+	return nil, false
+}
+
+// observe records the outcome of a request: transferred says whether any
+// data came back. It returns the cool-down to start if
+// this refusal completes a ban, or 0.
+func (g *banGuard) observe(transferred bool, err error, now time.Time) time.Duration {
+	if g.cooldown <= 0 {
+		return 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if transferred {
+		g.served = true
+		g.refused = 0
+		return 0
+	}
+	se, ok := banStatus(err)
+	if !ok || !g.served {
+		return 0
+	}
+	g.refused++
+	if g.refused < g.workers {
+		return 0
+	}
+
+	cooldown := max(g.cooldown, retryAfter(se.RetryAfter, now))
+	g.served = false
+	g.refused = 0
+	g.until = now.Add(cooldown)
+	return cooldown
+}
+
+// holding reports whether a cool-down is in progress.
+func (g *banGuard) holding(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return now.Before(g.until)
+}
+
+// wait blocks until any cool-down is over or ctx is done.
+func (g *banGuard) wait(ctx context.Context) error {
+	g.mu.Lock()
+	until := g.until
+	g.mu.Unlock()
+
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// noteFetch feeds a request's outcome to the ban guard and, when it
+// completes a ban, announces the cool-down and runs --ban-cmd. It
+// reports whether err was absorbed by a cool-down: the retry it caused
+// shouldn't count against the chunk.
+func (d *Downloader) noteFetch(ctx context.Context, transferred bool, err error) bool {
+	now := time.Now()
+	cooldown := d.ban.observe(transferred, err, now)
+	if cooldown > 0 {
+		se, _ := banStatus(err)
+		d.progress.PrintMessage("Server refused %d requests in a row with %d after serving them, likely a rate limit or ban; cooling down for %s",
+			d.ban.workers, se.Code, cooldown)
+		d.config.Events.Emit(events.BanCooldown, "status", se.Code, "seconds", int(cooldown.Seconds()), "until", now.Add(cooldown).Format(time.RFC3339))
+		d.runBanCmd(ctx, se.Code)
+		// Reconnect after the cool-down rather than reuse what the ban saw
+		d.client.CloseIdleConnections()
+		return true
+	}
+	_, refused := banStatus(err)
+	return refused && d.ban.holding(now)
+}
+
+// runBanCmd runs --ban-cmd, e.g. to rotate a proxy or ask Tor for a new
+// circuit. It runs with the hook environment but keeps network access.
+// A failure is only reported.
+func (d *Downloader) runBanCmd(ctx context.Context, status int) {
+	if d.config.BanCmd == "" {
+		return
+	}
+	cmdStr := strings.ReplaceAll(d.config.BanCmd, "{status}", strconv.Itoa(status))
+	cmd := d.config.Hooks.command(ctx, cmdStr)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		d.progress.PrintMessage("--ban-cmd failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+}
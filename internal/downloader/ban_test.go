@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanGuard(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	refused := &httpclient.StatusError{Code: 429}
+	g := newBanGuard(time.Minute, 8)
+	assert.Equal(t, maxBanWorkers, g.workers)
+
+	// Refusals before anything was served are ordinary failures
+	for range 5 {
+		assert.Zero(t, g.observe(false, refused, now))
+	}
+
+	assert.Zero(t, g.observe(true, nil, now))
+	assert.Zero(t, g.observe(false, refused, now))
+	assert.Zero(t, g.observe(true, nil, now), "data in between starts the count over")
+	assert.Zero(t, g.observe(false, refused, now))
+	assert.Zero(t, g.observe(false, errors.New("connection reset"), now), "not a ban status")
+	assert.Zero(t, g.observe(false, &httpclient.StatusError{Code: 403}, now))
+	assert.Equal(t, time.Minute, g.observe(false, refused, now))
+	assert.True(t, g.holding(now.Add(30*time.Second)))
+	assert.False(t, g.holding(now.Add(time.Minute)))
+
+	// Still refused after the cool-down: no second one until data flows again
+	later := now.Add(2 * time.Minute)
+	for range 5 {
+		assert.Zero(t, g.observe(false, refused, later))
+	}
+
+	// A longer Retry-After wins
+	assert.Zero(t, g.observe(true, nil, later))
+	for range 2 {
+		assert.Zero(t, g.observe(false, refused, later))
+	}
+	assert.Equal(t, 5*time.Minute, g.observe(false, &httpclient.StatusError{Code: 429, RetryAfter: "300"}, later))
+
+	off := newBanGuard(0, 4)
+	assert.Zero(t, off.observe(true, nil, now))
+	for range 4 {
+		assert.Zero(t, off.observe(false, refused, now))
+	}
+
+	assert.Equal(t, 1, newBanGuard(time.Minute, 1).workers)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2*time.Minute, retryAfter("120", now))
+	assert.Equal(t, 90*time.Second, retryAfter("Fri, 02 Jan 2026 03:01:30 GMT", now))
+	assert.Zero(t, retryAfter("", now))
+	assert.Zero(t, retryAfter("soon", now))
+}
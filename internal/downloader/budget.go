@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// errMaxTime is the cancellation cause once Config.MaxTime has passed.
+var errMaxTime = errors.New("download exceeded --max-time")
+
+// retryBudget caps retries across all chunks of a download, so a server
+// that keeps failing ends the job instead of every chunk retrying on its
+// own for hours.
+type retryBudget struct {
+	limit int // 0 = unlimited
+
+	mu      sync.Mutex
+	used    int
+	chunks  map[int]bool
+	lastErr error
+}
+
+// spend records a retry of chunk index after err. Once the budget is used
+// up it returns an error summarizing the failures instead.
+func (b *retryBudget) spend(index int, err error) error {
+	if b.limit <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.chunks == nil {
+		b.chunks = make(map[int]bool)
+	}
+	if b.used >= b.limit {
+		return fmt.Errorf("retry budget of %d exhausted by %d chunks; the server keeps failing (last error: %v)",
+			b.limit, len(b.chunks), b.lastErr)
+	}
+	b.used++
+	b.chunks[index] = true
+	b.lastErr = err
+	return nil
+}
+
+// checkRetry returns an error if the request that failed with err isn't
+// worth repeating. A client error answers the same however often it is
+// asked, so 4xx statuses end the chunk at once, except 408, 425 and 429,
+// which say to come back later. 401 and 403 are retried with --url-cmd,
+// whose fresh URL may fix an expired signature, and 403 with
+// --ban-cooldown, which may be a ban. Config.RetryOnStatus adds codes on
+// top, for a flaky auth layer in front of the server. A 200 to a range
+// request isn't retried either: the download switches to a single stream.
+// Everything else, 5xx and network errors included, is retried.
+func (d *Downloader) checkRetry(err error) error {
+	var se *httpclient.StatusError
+	if errors.Is(err, httpclient.ErrRangeIgnored) {
+		return err
+	}
+	if errors.Is(err, errBanCooldown) || !errors.As(err, &se) {
+		return nil
+	}
+	switch code := se.Code; {
+	case code < 400 || code >= 500, slices.Contains(d.config.RetryOnStatus, code):
+		return nil
+	case code == http.StatusRequestTimeout || code == http.StatusTooEarly || code == http.StatusTooManyRequests:
+		return nil
+	case d.config.URLCmd != "" && (code == http.StatusUnauthorized || code == http.StatusForbidden):
+		return nil
+	case d.config.BanCooldown > 0 && code == http.StatusForbidden:
+		return nil
+	}
+	return fmt.Errorf("%w, not retried (--retry-on-status %d retries it)", err, se.Code)
+}
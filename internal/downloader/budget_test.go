@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudget(t *testing.T) {
+	unlimited := &retryBudget{}
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, unlimited.spend(i, errors.New("boom")))
+	}
+
+	b := &retryBudget{limit: 3}
+	assert.NoError(t, b.spend(1, errors.New("first")))
+	assert.NoError(t, b.spend(2, errors.New("second")))
+	assert.NoError(t, b.spend(1, errors.New("status 503")))
+	err := b.spend(4, errors.New("never recorded"))
+	assert.EqualError(t, err, "retry budget of 3 exhausted by 2 chunks; the server keeps failing (last error: status 503)")
+}
+
+func TestCheckRetry(t *testing.T) {
+	status := func(code int) error {
+		return fmt.Errorf("chunk 1: %w", &httpclient.StatusError{Code: code})
+	}
+	d := &Downloader{}
+
+	assert.NoError(t, d.checkRetry(errors.New("connection reset")))
+	for _, code := range []int{408, 425, 429, 500, 502, 503} {
+		assert.NoError(t, d.checkRetry(status(code)), code)
+	}
+	assert.EqualError(t, d.checkRetry(status(404)), "chunk 1: unexpected status code: 404, not retried (--retry-on-status 404 retries it)")
+	assert.Error(t, d.checkRetry(status(403)))
+	assert.Error(t, d.checkRetry(status(401)))
+
+	d.config.RetryOnStatus = []int{403, 404}
+	assert.NoError(t, d.checkRetry(status(403)))
+	assert.NoError(t, d.checkRetry(status(404)))
+	assert.Error(t, d.checkRetry(status(401)))
+
+	// A fresh URL may cure an expired signature, and a 403 may be a ban
+	d.config = Config{URLCmd: "sign"}
+	assert.NoError(t, d.checkRetry(status(401)))
+	d.config = Config{BanCooldown: time.Minute}
+	assert.NoError(t, d.checkRetry(status(403)))
+	assert.NoError(t, d.checkRetry(fmt.Errorf("%w: %w", errBanCooldown, status(403))))
+	assert.Error(t, d.checkRetry(status(401)))
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// restartChunk removes what chunk index has on disk, finished or not, so
+// it is downloaded again from its start.
+func (d *Downloader) restartChunk(index int) {
+	d.discardFile(d.args.TmpPath(index))
+	d.discardFile(d.args.PartPath(index))
+	d.digests.Delete(index)
+	d.progress.SeedChunk(index, 0)
+}
+
+// verifyRemoteDigests checks the finished chunks, read in order, against
+// the checksums of the whole file the HEAD response carried. Chunks that
+// are no longer all on disk, or not on it at all, can't be checked. On a
+// mismatch, up to ChecksumRepairs times, the chunks to blame are
+// downloaded again and the file checked again.
+func (d *Downloader) verifyRemoteDigests(ctx context.Context) error {
+	if len(d.remoteDigests) == 0 || d.config.HTTPConfig.NoVerifyDigest || d.pipeState != nil {
+		return nil
+	}
+	if _, local := d.storage.(*storage.Local); !local {
+		return nil
+	}
+
+	names := httpclient.NewVerifier(d.remoteDigests).Algorithms()
+	algs := strings.Join(names, ", ")
+	mismatch, err := d.checkRemoteDigests()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Not checking the file against the server's %s: %v", algs, err))
+		return nil
+	}
+	for round := 1; mismatch != nil && round <= d.config.ChecksumRepairs; round++ {
+		repaired, err := d.repairChunks(ctx, mismatch, round)
+		if err != nil {
+			return err
+		}
+		if !repaired {
+			break
+		}
+		if mismatch, err = d.checkRemoteDigests(); err != nil {
+			return err
+		}
+	}
+	if mismatch != nil {
+		return fmt.Errorf("%w; the chunks are kept, --force downloads the file again", mismatch)
+	}
+	slog.Info("Checksum   : matches the server's "+algs, "algorithms", names)
+	return nil
+}
+
+// checkRemoteDigests reads the chunks in order and returns the mismatch
+// of the whole file's checksums, if any, or err if a chunk can't be read.
+func (d *Downloader) checkRemoteDigests() (mismatch, err error) {
+	v := httpclient.NewVerifier(d.remoteDigests)
+	for i := 0; i < d.args.NumChunks(); i++ {
+		if err := d.hashPart(v, i); err != nil {
+			return nil, err
+		}
+	}
+	return v.Check(d.args.FilenamePrefix), nil
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/failure"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// digestServer serves content with reprDigest as the whole file's SHA-256
+// and, with rangeMD5, each range's Content-MD5, corrupting the first
+// response that starts at corruptAt.
+func digestServer(t *testing.T, content []byte, reprDigest []byte, corruptAt int, rangeMD5 bool) *httptest.Server {
+	var corrupted atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(reprDigest)+":")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(content)-1)
+		body := bytes.Clone(content[start : end+1])
+		if rangeMD5 {
+			sum := md5.Sum(body)
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		if start == corruptAt && end > start && corrupted.CompareAndSwap(false, true) {
+			body[len(body)-1] ^= 1
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServerDigests(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	sha := sha256.Sum256(content)
+
+	downloadWith := func(srv *httptest.Server, set func(*Config)) error {
+		config := Config{
+			URL:            srv.URL + "/f",
+			Prefix:         "f",
+			ChunkSize:      100,
+			MaxConcurrency: 1,
+			Backoff:        Backoff{Base: time.Millisecond},
+			HTTPConfig:     httpclient.Config{MaxRetries: 2, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		}
+		set(&config)
+		d, err := NewDownloader(config)
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+	download := func(srv *httptest.Server, coalesce int64) error {
+		return downloadWith(srv, func(c *Config) { c.Coalesce = coalesce })
+	}
+	chunks := func() []byte {
+		var got []byte
+		for i := range 3 {
+			data, err := os.ReadFile(fmt.Sprintf("f.%06d.part", i))
+			require.NoError(t, err)
+			got = append(got, data...)
+		}
+		return got
+	}
+
+	// A range that doesn't match its Content-MD5 is fetched again
+	t.Run("range", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, download(digestServer(t, content, sha[:], 100, true), 0))
+		assert.Equal(t, content, chunks())
+	})
+
+	// With a coalesced request, every chunk it reached
+	t.Run("coalesced", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, download(digestServer(t, content, sha[:], 0, true), 1000))
+		assert.Equal(t, content, chunks())
+	})
+
+	// A whole file that doesn't match fails the download, keeping the chunks
+	t.Run("file", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		wrong := sha256.Sum256([]byte("something else"))
+		err := download(digestServer(t, content, wrong[:], -1, true), 0)
+		assert.ErrorIs(t, err, failure.ErrChecksum)
+		assert.ErrorContains(t, err, "f: content doesn't match the server's sha256 checksum")
+		assert.Equal(t, content, chunks())
+		_, err = LoadDownloadArguments("f")
+		assert.NoError(t, err)
+	})
+	// A chunk received wrong, with nothing to tell at the time, is found
+	// by downloading the chunks again and comparing; the manifest gets
+	// the repaired chunk's hashes
+	t.Run("repair", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, downloadWith(digestServer(t, content, sha[:], 100, false), func(c *Config) {
+			c.ChecksumRepairs = 1
+			c.WriteManifest = true
+		}))
+		assert.Equal(t, content, chunks())
+		m, err := manifest.Load(manifest.PathFor("f"))
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sha[:]), m.SHA256)
+		part := sha256.Sum256(content[100:200])
+		assert.Equal(t, hex.EncodeToString(part[:]), m.Parts[1].SHA256)
+	})
+
+	// The server sends the same bytes again: nothing to repair
+	t.Run("unrepairable", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		wrong := sha256.Sum256([]byte("something else"))
+		err := downloadWith(digestServer(t, content, wrong[:], -1, false), func(c *Config) { c.ChecksumRepairs = 2 })
+		assert.ErrorIs(t, err, failure.ErrChecksum)
+		assert.Equal(t, content, chunks())
+	})
+}
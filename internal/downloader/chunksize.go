@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// DefaultMaxChunks is the default cap on how many chunks a download is cut
+// into. Each chunk is a file, a request and a line of state, so a chunk
+// size far too small for the file fills directories with hundreds of
+// thousands of files long before it saves any time.
+const DefaultMaxChunks = 100000
+
+// Bounds and assumptions of -c auto.
+const (
+	autoMinChunkSize = 10 * 1000 * 1000       // below this, each request's setup costs too much
+	autoMaxChunkSize = 1000 * 1000 * 1000     // above this, a failed chunk loses too much
+	autoChunksPerJob = 4                      // so jobs finishing early find more work
+	autoAssumedSpeed = 10 * 1000 * 1000       // per connection, without --limit-rate
+	autoRTTMultiple  = 20                     // transfer time per request, in round trips
+	autoDefaultRTT   = 100 * time.Millisecond // without a HEAD request to time
+)
+
+// applyAutoChunkSize replaces Config.ChunkSize with autoChunkSize for a
+// fresh download of totalSize bytes with -c auto.
+func (d *Downloader) applyAutoChunkSize(totalSize int64) {
+	if !d.config.AutoChunkSize || totalSize <= 0 {
+		return
+	}
+	d.config.ChunkSize = autoChunkSize(totalSize, d.config.MaxConcurrency, d.headRTT, d.config.RateLimit, d.config.MaxChunks)
+	slog.Info(fmt.Sprintf("-c auto: %s chunks (%s file, %d jobs, %s round trip)",
+		formatBytes(d.config.ChunkSize), formatBytes(totalSize), max(d.config.MaxConcurrency, 1), d.headRTT.Round(time.Millisecond)),
+		"chunk_size", d.config.ChunkSize, "size", totalSize, "rtt", d.headRTT)
+}
+
+// autoChunkSize picks a chunk size for a totalSize file fetched with jobs
+// connections. It aims at autoChunksPerJob chunks per job, but keeps each
+// request transferring for autoRTTMultiple round trips (its bandwidth-delay
+// product times that) so the round trip a request costs stays small, and
+// stays within --max-chunks and the autoMin/autoMax bounds. The result is
+// rounded up to 1, 2 or 5 times a power of ten megabytes.
+func autoChunkSize(totalSize int64, jobs int, rtt time.Duration, rateLimit int64, maxChunks int) int64 {
+	jobs = max(jobs, 1)
+	if rtt <= 0 {
+		rtt = autoDefaultRTT
+	}
+	speed := int64(autoAssumedSpeed)
+	if rateLimit > 0 {
+		speed = max(rateLimit/int64(jobs), 1)
+	}
+	bdp := int64(float64(speed) * rtt.Seconds())
+
+	chunk := totalSize / int64(jobs*autoChunksPerJob)
+	chunk = max(chunk, autoRTTMultiple*bdp, autoMinChunkSize)
+	if chunk > autoMaxChunkSize {
+		chunk = autoMaxChunkSize
+	}
+	if maxChunks > 0 {
+		chunk = max(chunk, (totalSize+int64(maxChunks)-1)/int64(maxChunks))
+	}
+	if chunk = httpclient.RoundChunkSize(chunk); chunk > totalSize {
+		chunk = totalSize
+	}
+	return chunk
+}
+
+// checkChunkSize rejects a chunk size that makes no sense for a file of
+// totalSize bytes, suggesting one that does.
+func (d *Downloader) checkChunkSize(totalSize int64) error {
+	chunkSize := d.config.ChunkSize
+	if chunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	if totalSize <= 0 {
+		return nil
+	}
+
+	// Only a chunk size asked for explicitly: the default is larger than
+	// many files, which then simply download in one piece
+	if d.config.ExplicitChunkSize && chunkSize > totalSize {
+		return fmt.Errorf("chunk size %s is larger than the file (%s), so it would download in a single chunk; use -c %s or less",
+			formatBytes(chunkSize), formatBytes(totalSize), sizeArg(suggestChunkSize(totalSize, d.config.MaxConcurrency), false))
+	}
+
+	chunks := (totalSize + chunkSize - 1) / chunkSize
+	if limit := int64(d.config.MaxChunks); limit > 0 && chunks > limit {
+		return fmt.Errorf("chunk size %s cuts %s into %d chunks, more than --max-chunks %d; use -c %s or more, or raise --max-chunks",
+			formatBytes(chunkSize), formatBytes(totalSize), chunks, limit, sizeArg((totalSize+limit-1)/limit, true))
+	}
+	return nil
+}
+
+// suggestChunkSize returns a chunk size giving each of jobs workers at
+// least one chunk of a totalSize file.
+func suggestChunkSize(totalSize int64, jobs int) int64 {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return (totalSize + int64(jobs) - 1) / int64(jobs)
+}
+
+// sizeArg writes n as a -c value, rounded up or down to whole units where
+// it is large enough that the rounding doesn't matter.
+func sizeArg(n int64, up bool) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1000 * 1000 * 1000}, {"M", 1000 * 1000}, {"K", 1000}} {
+		if n >= 10*u.size {
+			if up {
+				n += u.size - 1
+			}
+			return fmt.Sprintf("%d%s", n/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", n)
+}
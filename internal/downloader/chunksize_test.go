@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckChunkSize(t *testing.T) {
+	check := func(config Config, totalSize int64) error {
+		return (&Downloader{config: config}).checkChunkSize(totalSize)
+	}
+
+	assert.ErrorContains(t, check(Config{ChunkSize: 0}, 100), "must be positive")
+
+	// The default chunk size may be larger than the file
+	assert.NoError(t, check(Config{ChunkSize: 100e6}, 5e6))
+	err := check(Config{ChunkSize: 100e6, ExplicitChunkSize: true, MaxConcurrency: 4}, 50e6)
+	assert.ErrorContains(t, err, "larger than the file (50.0 MB)")
+	assert.ErrorContains(t, err, "use -c 12M or less")
+
+	assert.NoError(t, check(Config{ChunkSize: 10e3, MaxChunks: 100000}, 1e9))
+	err = check(Config{ChunkSize: 10e3, MaxChunks: 100000}, 5e9)
+	assert.ErrorContains(t, err, "into 500000 chunks, more than --max-chunks 100000")
+	assert.ErrorContains(t, err, "use -c 50K or more")
+	assert.NoError(t, check(Config{ChunkSize: 10e3}, 5e9))
+}
+
+func TestSizeArg(t *testing.T) {
+	assert.Equal(t, "9999", sizeArg(9999, true))
+	assert.Equal(t, "50K", sizeArg(50e3, true))
+	assert.Equal(t, "51K", sizeArg(50001, true))
+	assert.Equal(t, "50K", sizeArg(50999, false))
+	assert.Equal(t, "12G", sizeArg(12e9, false))
+}
+
+func TestAutoChunkSize(t *testing.T) {
+	ms := time.Millisecond
+
+	// A few chunks per job, rounded up
+	assert.Equal(t, int64(200e6), autoChunkSize(2e9, 4, 50*ms, 0, DefaultMaxChunks))
+	assert.Equal(t, int64(1e9), autoChunkSize(2e12, 4, 50*ms, 0, DefaultMaxChunks))
+	assert.Equal(t, int64(50e6), autoChunkSize(2e9, 16, 50*ms, 0, DefaultMaxChunks))
+
+	// A long round trip needs longer requests; a rate limit shorter ones
+	assert.Equal(t, int64(100e6), autoChunkSize(2e9, 16, 500*ms, 0, DefaultMaxChunks))
+	assert.Equal(t, int64(10e6), autoChunkSize(2e8, 16, 500*ms, 16e6, DefaultMaxChunks))
+
+	// Never more than --max-chunks, never past the file
+	assert.Equal(t, int64(2e9), autoChunkSize(200e12, 4, 50*ms, 0, DefaultMaxChunks))
+	assert.Equal(t, int64(3e6), autoChunkSize(3e6, 4, 50*ms, 0, DefaultMaxChunks))
+}
@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// CleanOptions selects what Clean removes.
+type CleanOptions struct {
+	All     bool // every download's files, not only abandoned ones': .part files, live state and records included
+	TmpOnly bool // only unfinished chunk files (.tmp, endgame tails, .packing)
+	DryRun  bool // report what would be removed, removing nothing
+}
+
+// CleanedFile is a file Clean removed, or would remove.
+type CleanedFile struct {
+	Prefix string
+	Path   string
+	Size   int64
+}
+
+// CleanReport is what Clean removed, or would remove.
+type CleanReport struct {
+	Files   []CleanedFile
+	Skipped []string // prefixes a running rapel process holds the lock of
+}
+
+// Bytes returns the size of the files removed.
+func (r *CleanReport) Bytes() int64 {
+	var n int64
+	for _, f := range r.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// Downloads returns how many downloads the files removed belong to.
+func (r *CleanReport) Downloads() int {
+	var prefixes []string
+	for _, f := range r.Files {
+		if !slices.Contains(prefixes, f.Prefix) {
+			prefixes = append(prefixes, f.Prefix)
+		}
+	}
+	return len(prefixes)
+}
+
+// leftoverKind sorts the files a download leaves in its directory by what
+// removing them costs.
+type leftoverKind int
+
+const (
+	leftoverTmp      leftoverKind = iota // unfinished chunks: refetched if removed
+	leftoverState                        // resume state, its backups, a stale lock or socket
+	leftoverPart                         // finished chunks and interrupted merges
+	leftoverRecord                       // post-part failures and S3 uploads: not rapel's alone to forget
+	leftoverManifest                     // --write-manifest's, next to the merged file
+)
+
+// leftoverPatterns map file names in a download's directory to its prefix
+// and their kind. Only the files marked owns make a prefix a download's:
+// a lock or an interrupted merge may be another command's, a manifest any
+// file's.
+var leftoverPatterns = []struct {
+	re   *regexp.Regexp
+	kind leftoverKind
+	owns bool
+}{
+	{regexp.MustCompile(`^(.+)\.\d{6,}\.tmp(\.tail)?$`), leftoverTmp, true},
+	{regexp.MustCompile(`^(.+)\.\d{6,}-\d{6,}\.packing$`), leftoverTmp, true},
+	{regexp.MustCompile(`^\.(.+)-(args|progress|hash|piped|follow|secrets|reslice)\.json(\.tmp|\.\d+|\.corrupt)?$`), leftoverState, true},
+	{regexp.MustCompile(`^\.(.+)-transfers\.jsonl$`), leftoverState, true},
+	{regexp.MustCompile(`^\.(.+)\.(lock|sock)$`), leftoverState, false},
+	{regexp.MustCompile(`^(.+)\.\d{6,}(-\d{6,})?\.part(\.json)?$`), leftoverPart, true},
+	{regexp.MustCompile(`^(.+)\.assembling(\.json)?$`), leftoverPart, false},
+	{regexp.MustCompile(`^\.(.+)-(post-part-failed|s3)\.json(\.tmp|\.\d+)?$`), leftoverRecord, true},
+	{regexp.MustCompile(`^(.+)\.manifest\.json$`), leftoverManifest, false},
+}
+
+// leftover is a file of a download in the current directory, or its state
+// kept in the registry.
+type leftover struct {
+	path string
+	kind leftoverKind
+}
+
+// findLeftovers returns the files of the downloads in the current
+// directory, by prefix, with the state the registry keeps for them.
+func findLeftovers() (map[string][]leftover, error) {
+	dirs := []string{"."}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if registered, err := registry.List(); err == nil {
+		for _, e := range registered {
+			if dir := registry.StateDir(e.Prefix); e.Dir == cwd && dir != "." && !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	found := make(map[string][]leftover)
+	owned := make(map[string]bool)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			for _, p := range leftoverPatterns {
+				if m := p.re.FindStringSubmatch(e.Name()); m != nil {
+					found[m[1]] = append(found[m[1]], leftover{path: filepath.Join(dir, e.Name()), kind: p.kind})
+					owned[m[1]] = owned[m[1]] || p.owns
+					break
+				}
+			}
+		}
+	}
+	for prefix := range found {
+		if !owned[prefix] {
+			delete(found, prefix)
+		}
+	}
+	return found, nil
+}
+
+// Clean removes what downloads left in the current directory: prefix's,
+// or every download's if prefix is "". A download is abandoned once its
+// .{prefix}-args.json is gone, as when it finished or was given up on:
+// its unfinished chunks and remaining state are removed, and its .part
+// files, post-part failures and S3 upload state kept. With All, every
+// download's files are removed, whether it can still resume or not.
+// TmpOnly narrows either to the unfinished chunks. A download another
+// process is running is skipped.
+func Clean(prefix string, opts CleanOptions) (*CleanReport, error) {
+	found, err := findLeftovers()
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for p := range found {
+		if prefix == "" || p == prefix {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	if prefix != "" && len(prefixes) == 0 {
+		return nil, fmt.Errorf("no download files found for %s", prefix)
+	}
+
+	report := &CleanReport{}
+	for _, p := range prefixes {
+		if err := cleanPrefix(p, found[p], opts, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// cleanPrefix removes the files of the download with prefix that opts
+// select, adding them to report.
+func cleanPrefix(prefix string, files []leftover, opts CleanOptions, report *CleanReport) error {
+	_, err := os.Stat(ArgsPath(prefix))
+	abandoned := errors.Is(err, os.ErrNotExist)
+	if !abandoned && !opts.All {
+		return nil
+	}
+
+	// Holding the lock keeps a download from starting meanwhile; its
+	// release removes the lock file
+	lockPath := registry.LockPath(prefix)
+	if opts.DryRun {
+		if registry.Locked(lockPath) {
+			report.Skipped = append(report.Skipped, prefix)
+			return nil
+		}
+	} else {
+		lock, err := registry.Acquire(lockPath)
+		if errors.Is(err, registry.ErrLocked) {
+			report.Skipped = append(report.Skipped, prefix)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	for _, f := range files {
+		switch {
+		case opts.TmpOnly && f.kind != leftoverTmp:
+			continue
+		case !opts.All && f.kind != leftoverTmp && f.kind != leftoverState:
+			continue
+		}
+		info, err := os.Lstat(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.DryRun && f.path != lockPath {
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", f.path, err)
+			}
+		}
+		report.Files = append(report.Files, CleanedFile{Prefix: prefix, Path: f.path, Size: info.Size()})
+	}
+	if !opts.DryRun {
+		registry.PruneStateDir(prefix)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClean(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	write := func(names ...string) {
+		for _, name := range names {
+			require.NoError(t, os.WriteFile(name, []byte("1234"), 0644))
+		}
+	}
+	cleaned := func(r *CleanReport) []string {
+		var paths []string
+		for _, f := range r.Files {
+			paths = append(paths, f.Path)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// old.bin was given up on, live.bin can resume, busy.bin is running
+	write("old.bin.000000.part", "old.bin.000001.tmp", "old.bin.000001.tmp.tail", ".old.bin-progress.json",
+		".old.bin-args.json.1", ".old.bin-post-part-failed.json", ".old.bin-transfers.jsonl", "old.bin.manifest.json")
+	live := NewDownloadArguments("https://example.com/live.bin", 300, 100, "live.bin")
+	require.NoError(t, live.Save())
+	write("live.bin.000000.part", "live.bin.000001.tmp")
+	write("busy.bin.000000.tmp")
+	lock, err := registry.Acquire(registry.LockPath("busy.bin"))
+	require.NoError(t, err)
+	defer lock.Release()
+	// Not a download's
+	write("notes.manifest.json", ".other-upload.lock")
+
+	r, err := Clean("", CleanOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".old.bin-args.json.1", ".old.bin-progress.json", ".old.bin-transfers.jsonl", "old.bin.000001.tmp", "old.bin.000001.tmp.tail"}, cleaned(r))
+	assert.Equal(t, []string{"busy.bin"}, r.Skipped)
+	assert.Equal(t, int64(20), r.Bytes())
+	assert.FileExists(t, "old.bin.000001.tmp")
+
+	r, err = Clean("old.bin", CleanOptions{TmpOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old.bin.000001.tmp", "old.bin.000001.tmp.tail"}, cleaned(r))
+	assert.NoFileExists(t, "old.bin.000001.tmp")
+	assert.FileExists(t, ".old.bin-progress.json")
+
+	r, err = Clean("", CleanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".old.bin-args.json.1", ".old.bin-progress.json", ".old.bin-transfers.jsonl"}, cleaned(r))
+	assert.FileExists(t, "old.bin.000000.part")
+	assert.FileExists(t, "live.bin.000001.tmp")
+
+	r, err = Clean("", CleanOptions{All: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".live.bin-args.json", ".old.bin-post-part-failed.json",
+		"live.bin.000000.part", "live.bin.000001.tmp", "old.bin.000000.part", "old.bin.manifest.json"}, cleaned(r))
+	assert.Equal(t, 2, r.Downloads())
+	assert.FileExists(t, "busy.bin.000000.tmp")
+	assert.FileExists(t, "notes.manifest.json")
+	assert.FileExists(t, ".other-upload.lock")
+	assert.NoFileExists(t, registry.LockPath("live.bin"))
+
+	_, err = Clean("missing.bin", CleanOptions{})
+	assert.EqualError(t, err, "no download files found for missing.bin")
+}
+
+func TestCleanRegisteredState(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	entry := &registry.Entry{ID: registry.ID("hash", filepath.Join(cwd, "reg.bin")), URLHash: "hash", Dir: cwd, Prefix: "reg.bin"}
+	require.NoError(t, registry.Save(entry))
+	require.NoError(t, registry.CreateStateDir(entry.ID))
+	stateDir := registry.StateDir("reg.bin")
+	require.NotEqual(t, ".", stateDir)
+
+	// Given up on: its args are gone, the rest of its state is in the registry
+	for _, name := range []string{ProgressStatePath("reg.bin"), TransferLogPath("reg.bin"), "reg.bin.000000.tmp"} {
+		require.NoError(t, os.WriteFile(name, []byte("1234"), 0644))
+	}
+
+	r, err := Clean("", CleanOptions{})
+	require.NoError(t, err)
+	var paths []string
+	for _, f := range r.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		filepath.Join(stateDir, ".reg.bin-progress.json"), filepath.Join(stateDir, ".reg.bin-transfers.jsonl"), "reg.bin.000000.tmp",
+	}, paths)
+	assert.NoDirExists(t, stateDir)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package downloader
+
+import "os/exec"
+
+// rawCommandLine only matters on Windows, where a program parses its own
+// command line; elsewhere cmd's arguments are passed as they are.
+func rawCommandLine(cmd *exec.Cmd, line string) {}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package downloader
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// rawCommandLine makes cmd start with line as its command line, unquoted.
+func rawCommandLine(cmd *exec.Cmd, line string) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: line}
+}
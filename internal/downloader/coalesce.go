@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// coalesceGroup returns the chunks, starting at first, to fetch with a
+// single request. Adjacent pending chunks smaller than --coalesce are
+// grouped until the request would exceed it; a chunk with partial data
+// other than the first ends the group, since a request can only resume
+// at its start.
+func (d *Downloader) coalesceGroup(first int) []int {
+	group := []int{first}
+
+	limit := d.config.Coalesce
+	if limit <= 0 || d.config.HasPipePartCmd() {
+		return group
+	}
+
+	total := d.args.ChunkSizeAt(first)
+	for j := first + 1; j < d.args.NumChunks(); j++ {
+		size := d.args.ChunkSizeAt(j)
+		if total+size > limit || !d.selected(j) || d.progress.IsChunkComplete(j) || d.progress.Bytes(j) > 0 {
+			break
+		}
+		group = append(group, j)
+		total += size
+	}
+	return group
+}
+
+// fetchGroup downloads adjacent chunks with one request, writing each
+// chunk's bytes to its own file. It makes a single attempt: chunks it
+// didn't finish are left to downloadChunk, which resumes them from their
+// .tmp files with the usual retries.
+func (d *Downloader) fetchGroup(ctx context.Context, group []int) error {
+	first := group[0]
+	file, size, err := d.openChunk(first)
+	if err != nil {
+		return nil
+	}
+
+	gw := &groupWriter{ctx: ctx, d: d}
+	defer gw.close()
+	gw.chunks = append(gw.chunks, groupChunk{index: first, file: file, remaining: d.args.ChunkSizeAt(first) - size, digest: d.digestFor(first, size)})
+
+	// Later chunks are opened as the response reaches them
+	for _, index := range group[1:] {
+		gw.chunks = append(gw.chunks, groupChunk{index: index, remaining: d.args.ChunkSizeAt(index)})
+	}
+	if len(gw.chunks) < 2 {
+		return nil
+	}
+
+	for _, c := range gw.chunks {
+		d.progress.SetActive(c.index, true)
+		defer d.progress.SetActive(c.index, false)
+	}
+
+	start, _ := d.args.ChunkRange(first)
+	_, end := d.args.ChunkRange(gw.chunks[len(gw.chunks)-1].index)
+	err = d.fetchRange(ctx, first, start+size, end, gw)
+	if errors.Is(err, failure.ErrChecksum) {
+		// Which chunk got the bad bytes is unknown: every one it reached,
+		// finalized or not, starts over
+		gw.close()
+		for _, c := range gw.chunks[:min(gw.cur+1, len(gw.chunks))] {
+			d.restartChunk(c.index)
+		}
+	}
+	return err
+}
+
+// groupChunk is one chunk file being filled by a coalesced request.
+type groupChunk struct {
+	index     int
+	file      storage.Chunk // nil until the response reaches it and once finalized
+	remaining int64
+	digest    *chunkDigest // nil unless chunks are hashed as they download
+}
+
+// groupWriter splits a coalesced response across consecutive chunk files,
+// finalizing each as soon as it is full.
+type groupWriter struct {
+	ctx    context.Context
+	d      *Downloader
+	chunks []groupChunk
+	cur    int
+}
+
+func (g *groupWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if g.cur >= len(g.chunks) {
+			return written, fmt.Errorf("server sent more data than requested")
+		}
+		c := &g.chunks[g.cur]
+		if c.file == nil {
+			// Only the first chunk of a group may resume: a later one that
+			// gained data since the group was formed can't take these bytes
+			file, size, err := g.d.storage.OpenChunk(c.index)
+			if err != nil {
+				return written, err
+			}
+			if size > 0 {
+				file.Close()
+				return written, fmt.Errorf("chunk %d already has data", c.index)
+			}
+			c.file = file
+			c.digest = g.d.digestFor(c.index, 0)
+		}
+
+		n := len(p)
+		if int64(n) > c.remaining {
+			n = int(c.remaining)
+		}
+
+		pw := &progressWriter{
+			ctx:         g.ctx,
+			writer:      c.file,
+			tracker:     g.d.progress,
+			limiter:     g.d.limiter,
+			diskLimiter: g.d.diskLimiter,
+			digest:      c.digest,
+			chunkIdx:    c.index,
+		}
+		m, err := pw.Write(p[:n])
+		written += m
+		c.remaining -= int64(m)
+		p = p[m:]
+		if err != nil {
+			return written, err
+		}
+
+		if c.remaining == 0 {
+			err := g.d.storage.FinalizeChunk(g.ctx, c.index, c.file)
+			c.file = nil
+			if err != nil {
+				return written, fmt.Errorf("failed to finalize chunk: %w", err)
+			}
+			g.cur++
+		}
+	}
+	return written, nil
+}
+
+// close closes the files of chunks left unfinished.
+func (g *groupWriter) close() {
+	for i := range g.chunks {
+		if c := &g.chunks[i]; c.file != nil {
+			c.file.Close()
+			c.file = nil
+		}
+	}
+}
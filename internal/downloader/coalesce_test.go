@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"os"
+	"testing"
+
+	"github.com/redraw/rapel/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesceGroup(t *testing.T) {
+	args := NewDownloadArguments("http://example.com/f", 1000, 100, "f")
+	d := &Downloader{config: Config{Coalesce: 350}, args: args, progress: NewProgressTracker(args)}
+
+	assert.Equal(t, []int{0, 1, 2}, d.coalesceGroup(0))
+
+	// A complete chunk or one with partial data ends the group
+	d.progress.MarkComplete(5)
+	d.progress.SeedChunk(8, 10)
+	assert.Equal(t, []int{3, 4}, d.coalesceGroup(3))
+	assert.Equal(t, []int{6, 7}, d.coalesceGroup(6))
+	assert.Equal(t, []int{8, 9}, d.coalesceGroup(8))
+
+	// Chunks at least as large as the limit are fetched alone
+	d.config.Coalesce = 100
+	assert.Equal(t, []int{0}, d.coalesceGroup(0))
+}
+
+func TestPack(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	args := NewDownloadArguments("http://example.com/f", 10, 2, "f")
+	d := &Downloader{config: Config{PackParts: 3}, args: args, progress: NewProgressTracker(args), storage: storage.NewLocal("", "f")}
+
+	for i, data := range []string{"ab", "cd", "ef", "gh"} {
+		require.NoError(t, os.WriteFile(args.PartPath(i), []byte(data), 0644))
+		d.progress.MarkComplete(i)
+	}
+
+	d.maybePack(3) // block 3-4 isn't complete
+	d.maybePack(1)
+
+	data, err := os.ReadFile(args.PackedPath(0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(data))
+	assert.NoFileExists(t, args.PartPath(0))
+	assert.FileExists(t, args.PartPath(3))
+
+	done, err := args.CompletedChunks()
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true, true, false}, done)
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// Collision policies: what to do when the filename prefix a URL resolves
+// to is already used by an unfinished download of another URL, as when a
+// batch fetches several files all called latest.tar.gz.
+const (
+	CollisionError     = "error"     // refuse, as without a policy
+	CollisionHost      = "host"      // name the file after its host too: latest-example.com.tar.gz
+	CollisionHash      = "hash"      // name the file after its URL hash too: latest-1a2b3c4d.tar.gz
+	CollisionOverwrite = "overwrite" // replace the other download, as --force does
+)
+
+// CollisionPolicies lists the valid values of Config.OnCollision.
+var CollisionPolicies = []string{CollisionError, CollisionHost, CollisionHash, CollisionOverwrite}
+
+// avoidCollision returns the prefix to download under. A name rapel picks
+// itself carries the URL's hash (see HashedPrefix), so two URLs of one name
+// never share chunk or state files. The policy is for names given to it,
+// and for --storage and --follow, whose names are fixed up front. A renamed
+// download is found again on the next run by its saved state, even once
+// the other download is gone, so resuming picks up the same chunk files.
+func (d *Downloader) avoidCollision(prefix string) (string, error) {
+	if d.config.Prefix == "" && d.config.Storage == nil && !d.config.Follow {
+		hashed := HashedPrefix(d.config.URL, prefix)
+		if hashed != prefix {
+			d.urlTag = urlTag(d.config.URL)
+		}
+		return hashed, nil
+	}
+
+	policy := d.config.OnCollision
+	if policy == "" || policy == CollisionError || d.config.Force {
+		return prefix, nil
+	}
+
+	candidates := d.collisionCandidates(prefix)
+	for _, p := range append([]string{prefix}, candidates...) {
+		if args, _ := LoadDownloadArguments(p); args != nil && args.Matches(d.config.URL) {
+			return p, nil
+		}
+	}
+
+	other := d.prefixOwner(prefix)
+	if other == "" {
+		return prefix, nil
+	}
+
+	if policy == CollisionOverwrite {
+		slog.Warn(fmt.Sprintf("%s is used by an unfinished download of %s, replacing it", prefix, other), "file", prefix)
+		d.config.Force = true
+		return prefix, nil
+	}
+	for _, p := range candidates {
+		if d.prefixOwner(p) == "" {
+			slog.Info(fmt.Sprintf("%s is used by an unfinished download of %s, downloading as %s", prefix, other, p), "file", p)
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("prefix collision: %s is used by an unfinished download of %s, and so are the renamed prefixes", prefix, other)
+}
+
+// HashedPrefix returns the prefix of the chunk and state files of url's
+// download, whose merged file is named prefix: latest.tar.gz becomes
+// latest-1a2b3c4d.tar.gz whether or not another URL's download uses the
+// plain name, so the names don't depend on which download started first.
+// A download of url saved under the plain name, as before the hash was
+// put in, keeps it.
+func HashedPrefix(url, prefix string) string {
+	if args, _ := LoadDownloadArguments(prefix); args != nil && args.Matches(url) {
+		return prefix
+	}
+	return renamedPrefix(prefix, urlTag(url))
+}
+
+// urlTag returns the URL's hash as put in names: the first 8 hex digits of
+// its SHA-256.
+func urlTag(url string) string {
+	return redact.Fingerprint(url)[:8]
+}
+
+// collisionCandidates returns the prefixes the policy renames prefix to,
+// in order of preference. The hash is the fallback for two files of the
+// same name on one host.
+func (d *Downloader) collisionCandidates(prefix string) []string {
+	hash := renamedPrefix(prefix, urlTag(d.config.URL))
+	if d.config.OnCollision != CollisionHost {
+		return []string{hash}
+	}
+	u, err := url.Parse(d.config.URL)
+	if err != nil || u.Hostname() == "" {
+		return []string{hash}
+	}
+	host := strings.ReplaceAll(u.Hostname(), ":", "_") // IPv6
+	return []string{renamedPrefix(prefix, host), hash}
+}
+
+// renamedPrefix inserts tag before the extensions of prefix, so the
+// merged file keeps them: latest.tar.gz becomes latest-tag.tar.gz.
+func renamedPrefix(prefix, tag string) string {
+	if i := strings.Index(prefix, "."); i > 0 {
+		return prefix[:i] + "-" + tag + prefix[i:]
+	}
+	return prefix + "-" + tag
+}
+
+// prefixOwner returns the URL of another unfinished download using prefix
+// in the current directory, or "" if there is none. Its saved state says
+// so, or else the registry.
+func (d *Downloader) prefixOwner(prefix string) string {
+	if args, err := LoadDownloadArguments(prefix); err != nil {
+		return "an unknown URL (its saved state is unreadable)"
+	} else if args != nil && !args.Matches(d.config.URL) {
+		return redact.URL(args.URL)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	urlHash := redact.Fingerprint(d.config.URL)
+	path := filepath.Join(dir, prefix)
+	other, err := registry.FindCollision(&registry.Entry{
+		ID:      registry.ID(urlHash, path),
+		URLHash: urlHash,
+		Dir:     dir,
+		Prefix:  prefix,
+	})
+	if err != nil || other == nil {
+		return ""
+	}
+	return redact.URL(other.URL)
+}
@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPrefix(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://example.com/pub/file.bin":                 "file.bin",
+		"https://example.com/pub/file.bin?sig=a/b#frag":    "file.bin",
+		"https://example.com/pub/dir/":                     "dir",
+		"https://example.com/My%20Movie%3A%20Part%201.mkv": "My Movie_ Part 1.mkv",
+		"https://example.com/a%2F..%2F..%2Fetc%2Fpasswd":   "passwd",
+		"https://example.com/what%3F%2A.iso":               "what__.iso",
+		"https://example.com/con.txt":                      "_con.txt",
+		"https://example.com/LPT1":                         "_LPT1",
+		"https://example.com/..":                           "example.com",
+		"https://example.com/?id=5":                        "example.com",
+		"https://[::1]:8080/":                              "__1",
+		"https://example.com/%zz.bin":                      "%zz.bin",
+		"://bad\\x/y.iso?z":                                "y.iso",
+		"":                                                 "download",
+	} {
+		assert.Equal(t, want, DefaultPrefix(url), url)
+	}
+}
+
+func TestRenamedPrefix(t *testing.T) {
+	assert.Equal(t, "latest-example.com.tar.gz", renamedPrefix("latest.tar.gz", "example.com"))
+	assert.Equal(t, "latest-1a2b3c4d", renamedPrefix("latest", "1a2b3c4d"))
+}
+
+func TestCollisionPolicies(t *testing.T) {
+	content := map[string][]byte{
+		"/a/latest.tar.gz": []byte(strings.Repeat("a", 250)),
+		"/b/latest.tar.gz": []byte(strings.Repeat("b", 250)),
+		"/c/latest.tar.gz": []byte(strings.Repeat("c", 250)),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	// The policy is for names given, as by a --plan
+	download := func(path, policy string) (*Downloader, error) {
+		d, err := NewDownloader(Config{
+			URL:         srv.URL + path,
+			Prefix:      "latest.tar.gz",
+			ChunkSize:   100,
+			OnCollision: policy,
+			HTTPConfig:  httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d, d.Download(context.Background())
+	}
+	// An unfinished download of the first URL holds latest.tar.gz
+	setup := func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, NewDownloadArguments(srv.URL+"/a/latest.tar.gz", 250, 100, "latest.tar.gz").Save())
+	}
+
+	t.Run("error", func(t *testing.T) {
+		setup(t)
+		_, err := download("/b/latest.tar.gz", CollisionError)
+		assert.ErrorContains(t, err, "don't match")
+	})
+
+	t.Run("host", func(t *testing.T) {
+		setup(t)
+		d, err := download("/b/latest.tar.gz", CollisionHost)
+		require.NoError(t, err)
+		assert.Equal(t, "latest-127.0.0.1.tar.gz", d.GetArguments().FilenamePrefix)
+		assert.FileExists(t, "latest-127.0.0.1.tar.gz.000000.part")
+
+		// Another file of that name on the same host falls back to the hash
+		require.NoError(t, NewDownloadArguments(srv.URL+"/b/latest.tar.gz", 250, 100, "latest-127.0.0.1.tar.gz").Save())
+		d, err = download("/c/latest.tar.gz", CollisionHost)
+		require.NoError(t, err)
+		hash := redact.Fingerprint(srv.URL + "/c/latest.tar.gz")[:8]
+		assert.Equal(t, "latest-"+hash+".tar.gz", d.GetArguments().FilenamePrefix)
+	})
+
+	t.Run("hash resumes under the same name", func(t *testing.T) {
+		setup(t)
+		hash := redact.Fingerprint(srv.URL + "/b/latest.tar.gz")[:8]
+		renamed := "latest-" + hash + ".tar.gz"
+		require.NoError(t, NewDownloadArguments(srv.URL+"/b/latest.tar.gz", 250, 100, renamed).Save())
+		// Marked so it shows whether the chunk was kept or fetched again
+		kept := []byte(strings.Repeat("x", 100))
+		require.NoError(t, os.WriteFile(renamed+".000000.part", kept, 0644))
+
+		// The first download is gone, but the renamed one is picked up again
+		require.NoError(t, os.Remove(".latest.tar.gz-args.json"))
+		d, err := download("/b/latest.tar.gz", CollisionHash)
+		require.NoError(t, err)
+		assert.Equal(t, renamed, d.GetArguments().FilenamePrefix)
+		part, err := os.ReadFile(renamed + ".000000.part")
+		require.NoError(t, err)
+		assert.Equal(t, kept, part)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		setup(t)
+		d, err := download("/b/latest.tar.gz", CollisionOverwrite)
+		require.NoError(t, err)
+		assert.Equal(t, "latest.tar.gz", d.GetArguments().FilenamePrefix)
+		part, err := os.ReadFile("latest.tar.gz.000000.part")
+		require.NoError(t, err)
+		assert.Equal(t, content["/b/latest.tar.gz"][:100], part)
+	})
+}
+
+func TestHashedPrefix(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	u := "https://example.com/a/latest.tar.gz"
+	d := &Downloader{config: Config{URL: u}}
+	hashed := "latest-" + redact.Fingerprint(u)[:8] + ".tar.gz"
+	p, err := d.avoidCollision("latest.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, hashed, p)
+	assert.Equal(t, "latest.tar.gz", d.outputName(p))
+
+	// Another URL's state under the plain name changes nothing
+	require.NoError(t, NewDownloadArguments("https://example.com/b/latest.tar.gz", 250, 100, "latest.tar.gz").Save())
+	assert.Equal(t, hashed, HashedPrefix(u, "latest.tar.gz"))
+
+	// This URL's own state under the plain name, saved before the hash was
+	// put in, is resumed there
+	require.NoError(t, NewDownloadArguments(u, 250, 100, "latest.tar.gz").Save())
+	assert.Equal(t, "latest.tar.gz", HashedPrefix(u, "latest.tar.gz"))
+
+	// A name from a plan is kept
+	d = &Downloader{config: Config{URL: u, Prefix: "planned.bin"}}
+	p, err = d.avoidCollision("planned.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "planned.bin", p)
+	assert.Equal(t, "planned.bin", d.outputName(p))
+}
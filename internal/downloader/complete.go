@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// ErrAlreadyComplete is returned by Download when Config.SkipComplete
+// found the output file already matching the remote. It is an ErrSkipped.
+var ErrAlreadyComplete = fmt.Errorf("%w and is complete", ErrSkipped)
+
+// outputPath returns where the file merged from prefix's chunks goes.
+func (d *Downloader) outputPath(prefix string) string {
+	prefix = d.outputName(prefix)
+	if d.config.OutputName != "" {
+		prefix = d.config.OutputName
+	}
+	if d.config.OutputDir != "" {
+		return filepath.Join(d.config.OutputDir, prefix)
+	}
+	return prefix
+}
+
+// outputName returns the name of the file merged from prefix's chunks:
+// prefix without the URL's hash, which is only there to keep the chunk
+// and state files of two URLs of one name apart.
+func (d *Downloader) outputName(prefix string) string {
+	if d.urlTag == "" {
+		return prefix
+	}
+	return strings.Replace(prefix, "-"+d.urlTag, "", 1)
+}
+
+// checkComplete returns ErrAlreadyComplete when Config.SkipComplete or
+// ContinueFile is set and the output file is there, with no download of
+// it under way, holding the remote's size and, when the server sent one,
+// its MD5.
+func (d *Downloader) checkComplete(prefix string, totalSize int64) error {
+	if (!d.config.SkipComplete && !d.config.ContinueFile) || d.config.Force {
+		return nil
+	}
+	if args, _ := LoadDownloadArguments(prefix); args != nil {
+		return nil
+	}
+	output := d.outputPath(prefix)
+	info, err := os.Stat(output)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != totalSize {
+		return nil
+	}
+
+	if d.remoteMD5 != "" {
+		_, _, md5Hex, err := manifest.HashFile(output)
+		if err != nil {
+			return err
+		}
+		if md5Hex != d.remoteMD5 {
+			slog.Warn(fmt.Sprintf("%s has the remote's size but not its MD5, downloading it again", output),
+				"file", output, "md5", md5Hex, "remote_md5", d.remoteMD5)
+			return nil
+		}
+	}
+
+	slog.Info(fmt.Sprintf("%s is already complete, skipping", output), "file", output, "md5_checked", d.remoteMD5 != "")
+	return ErrAlreadyComplete
+}
+
+// continueFile takes the output file a plain download left behind, as
+// curl or wget do, as the start of this one. Its bytes are moved into
+// chunk files from the end back, cutting the file short after each, so
+// the move never takes more than a chunk of extra space; the file is gone
+// once they all are. Interrupted, the download resumes with the chunks
+// moved so far and fetches the rest.
+func (d *Downloader) continueFile() error {
+	prefix := d.args.FilenamePrefix
+	output := d.outputPath(prefix)
+	info, err := os.Stat(output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	switch {
+	case !info.Mode().IsRegular() || size == 0:
+		return nil
+	case size == d.args.TotalSize:
+		// Complete but for its MD5 (checkComplete said so): fetch it anew
+		return nil
+	case size > d.args.TotalSize:
+		return failure.Errorf(failure.ErrSizeMismatch, "%s holds %d bytes, more than the remote's %d: it is another file, use --force to replace it",
+			output, size, d.args.TotalSize)
+	}
+	for _, name := range []string{storage.PartName(prefix, 0), storage.TmpName(prefix, 0)} {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s exists already, so %s can't be continued from", name, output)
+		}
+	}
+
+	f, err := os.OpenFile(output, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", output, err)
+	}
+	defer f.Close()
+
+	last := d.args.ChunkAt(size - 1)
+	for i := last; i >= 0; i-- {
+		start, end := d.args.ChunkRange(i)
+		n := end + 1 - start
+		if size < end+1 {
+			n = size - start
+		}
+		if err := copyRange(f, start, n, storage.TmpName(prefix, i)); err != nil {
+			return err
+		}
+		if size > end {
+			if err := os.Rename(storage.TmpName(prefix, i), storage.PartName(prefix, i)); err != nil {
+				return fmt.Errorf("failed to finish chunk %d: %w", i, err)
+			}
+		}
+		if err := f.Truncate(start); err != nil {
+			return fmt.Errorf("failed to shorten %s: %w", output, err)
+		}
+	}
+	f.Close()
+	if err := os.Remove(output); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", output, err)
+	}
+
+	slog.Info(fmt.Sprintf("Continuing %s: %s of %s already there", output, formatBytes(size), formatBytes(d.args.TotalSize)),
+		"file", output, "bytes", size, "chunks", last+1)
+	return nil
+}
+
+// copyRange writes n bytes of f from offset start to a new file at path,
+// synced so f can be cut short after it.
+func copyRange(f *os.File, start, n int64, path string) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(f, start, n)); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return out.Close()
+}
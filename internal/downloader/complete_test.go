@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// completeServer serves content with its MD5 as ETag, counting GETs.
+func completeServer(t *testing.T, content []byte, gets *atomic.Int32) *httptest.Server {
+	sum := md5.Sum(content)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSkipComplete(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	var gets atomic.Int32
+	srv := completeServer(t, content, &gets)
+	download := func() error {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/f",
+			ChunkSize:      100,
+			MaxConcurrency: 2,
+			SkipComplete:   true,
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+
+	require.NoError(t, os.WriteFile("f", content, 0644))
+	err := download()
+	assert.ErrorIs(t, err, ErrAlreadyComplete)
+	assert.ErrorIs(t, err, ErrSkipped)
+	assert.Zero(t, gets.Load())
+
+	// Same size, other bytes: the MD5 gives it away
+	other := bytes.Clone(content)
+	other[7] = 'x'
+	require.NoError(t, os.WriteFile("f", other, 0644))
+	require.NoError(t, download())
+	assert.Equal(t, int32(4), gets.Load()) // a Range probe and 3 chunks
+}
+
+func TestContinueFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	var gets atomic.Int32
+	srv := completeServer(t, content, &gets)
+
+	// What a plain download left after 130 bytes
+	require.NoError(t, os.WriteFile("f", content[:130], 0644))
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		ContinueFile:   true,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	assert.NoFileExists(t, "f")
+	var got []byte
+	for i := range 3 {
+		data, err := os.ReadFile(storage.PartName(d.GetArguments().FilenamePrefix, i))
+		require.NoError(t, err)
+		got = append(got, data...)
+	}
+	assert.Equal(t, content, got)
+	// Chunk 0 was there, chunk 1 resumed from byte 130 (after a Range probe)
+	assert.Equal(t, int32(3), gets.Load())
+	assert.Equal(t, int64(250-130), d.client.Traffic().Bytes)
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/redraw/rapel/internal/control"
+	"github.com/redraw/rapel/internal/events"
+)
+
+// startControl serves `rapel ctl` requests for this download until the
+// returned function is called. A socket that can't be created is only
+// logged: the download works the same without it.
+func (d *Downloader) startControl() func() {
+	srv, err := control.Listen(control.SocketPath(d.args.FilenamePrefix), d.handleControl)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("runtime control unavailable: %v", err))
+		return func() {}
+	}
+	return func() { srv.Close() }
+}
+
+// handleControl executes a control request:
+//
+//	get                   report current settings
+//	set jobs=N limit=B/s  change settings (limit=0 removes the rate limit)
+func (d *Downloader) handleControl(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty request")
+	}
+
+	switch fields[0] {
+	case "get":
+		return d.controlStatus(), nil
+
+	case "set":
+		if len(fields) == 1 {
+			return "", fmt.Errorf("set needs key=value arguments")
+		}
+
+		// Validate everything before applying anything
+		jobs, limit := -1, int64(-1)
+		for _, kv := range fields[1:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return "", fmt.Errorf("invalid setting %q, want key=value", kv)
+			}
+			switch key {
+			case "jobs":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return "", fmt.Errorf("invalid jobs %q, want a number >= 1", value)
+				}
+				jobs = n
+			case "limit":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || n < 0 {
+					return "", fmt.Errorf("invalid limit %q, want bytes per second >= 0", value)
+				}
+				limit = n
+			default:
+				return "", fmt.Errorf("unknown setting %q (known: jobs, limit)", key)
+			}
+		}
+
+		if jobs > 0 {
+			d.SetConcurrency(jobs)
+		}
+		if limit >= 0 {
+			d.SetRateLimit(limit)
+		}
+
+		status := d.controlStatus()
+		d.printSettings()
+		return status, nil
+	}
+
+	return "", fmt.Errorf("unknown command %q (known: get, set)", fields[0])
+}
+
+// controlStatus formats the current runtime settings.
+func (d *Downloader) controlStatus() string {
+	return fmt.Sprintf("jobs=%d limit=%d", d.Concurrency(), d.RateLimit())
+}
+
+// emitSettings reports the runtime settings on the event stream.
+func (d *Downloader) emitSettings() {
+	d.config.Events.Emit(events.Settings, "jobs", d.Concurrency(), "limit", d.RateLimit())
+}
+
+// printSettings logs the runtime settings after a change.
+func (d *Downloader) printSettings() {
+	limit := "unlimited"
+	if l := d.RateLimit(); l > 0 {
+		limit = formatBytes(l) + "/s"
+	}
+	d.progress.PrintMessage("Settings changed: jobs %d, rate limit %s", d.Concurrency(), limit)
+}
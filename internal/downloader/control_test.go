@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleControl(t *testing.T) {
+	args := NewDownloadArguments("https://example.com/f", 100, 10, "f")
+	d := &Downloader{
+		args:     args,
+		jobs:     newJobGate(2),
+		limiter:  NewRateLimiter(0),
+		progress: NewProgressTracker(args),
+	}
+
+	reply, err := d.handleControl("get")
+	require.NoError(t, err)
+	assert.Equal(t, "jobs=2 limit=0", reply)
+
+	reply, err = d.handleControl("set jobs=8 limit=2000000")
+	require.NoError(t, err)
+	assert.Equal(t, "jobs=8 limit=2000000", reply)
+
+	// Invalid requests change nothing
+	_, err = d.handleControl("set jobs=4 limit=-1")
+	assert.Error(t, err)
+	_, err = d.handleControl("set speed=1")
+	assert.Error(t, err)
+	_, err = d.handleControl("pause")
+	assert.Error(t, err)
+	assert.Equal(t, 8, d.Concurrency())
+	assert.Equal(t, int64(2000000), d.RateLimit())
+}
@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// DeadLetter is a chunk whose post-part command kept failing after every
+// retry.
+type DeadLetter struct {
+	Chunk    int       `json:"chunk"`
+	Part     string    `json:"part"`    // the chunk's file, as {part} names it
+	Command  string    `json:"command"` // as run, placeholders filled in
+	Error    string    `json:"error"`
+	ExitCode int       `json:"exit_code,omitempty"` // 0 when it didn't exit on its own (timeout, not found)
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetters lists the chunks whose post-part command failed for good,
+// so an upload that never happened isn't forgotten once the download
+// moves on. It is kept in .{prefix}-post-part-failed.json, which outlives
+// the download, until the command succeeds for each of them on a later
+// run or rapel status --clear drops it. rapel status shows it.
+type DeadLetters struct {
+	Failed []DeadLetter `json:"failed"`
+
+	mu       sync.Mutex
+	filePath string
+}
+
+// DeadLetterPath returns the dead-letter file of prefix.
+func DeadLetterPath(prefix string) string {
+	return registry.StatePath(prefix, "post-part-failed.json")
+}
+
+// LoadDeadLetters loads prefix's dead letters, returning an empty list if
+// there are none.
+func LoadDeadLetters(prefix string) (*DeadLetters, error) {
+	l := &DeadLetters{filePath: DeadLetterPath(prefix)}
+
+	data, err := os.ReadFile(l.filePath)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post-part failures: %w", err)
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse post-part failures: %w", err)
+	}
+	return l, nil
+}
+
+// Add records a chunk's failure, replacing an earlier one of the same
+// chunk, and persists the list.
+func (l *DeadLetters) Add(dl DeadLetter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(dl.Chunk)
+	l.Failed = append(l.Failed, dl)
+	sort.Slice(l.Failed, func(i, j int) bool { return l.Failed[i].Chunk < l.Failed[j].Chunk })
+	return l.save()
+}
+
+// Remove drops chunk's failure, if any, and persists the list.
+func (l *DeadLetters) Remove(chunk int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.remove(chunk) {
+		return nil
+	}
+	return l.save()
+}
+
+// Len returns the number of chunks listed.
+func (l *DeadLetters) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.Failed)
+}
+
+// Delete removes the dead-letter file.
+func (l *DeadLetters) Delete() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Failed = nil
+	err := os.Remove(l.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *DeadLetters) remove(chunk int) bool {
+	for i, dl := range l.Failed {
+		if dl.Chunk == chunk {
+			l.Failed = append(l.Failed[:i], l.Failed[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the list atomically, or removes the file once it is empty.
+func (l *DeadLetters) save() error {
+	if len(l.Failed) == 0 {
+		err := os.Remove(l.filePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal post-part failures: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(l.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write post-part failures: %w", err)
+	}
+	return nil
+}
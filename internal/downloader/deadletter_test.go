@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	l, err := LoadDeadLetters("f.bin")
+	require.NoError(t, err)
+	require.NoError(t, l.Add(DeadLetter{Chunk: 4, Error: "exit status 1", ExitCode: 1, Attempts: 3}))
+	require.NoError(t, l.Add(DeadLetter{Chunk: 1, Error: "timed out after 1m0s", Attempts: 3}))
+	require.NoError(t, l.Add(DeadLetter{Chunk: 4, Error: "exit status 2", ExitCode: 2, Attempts: 1}))
+
+	l, err = LoadDeadLetters("f.bin")
+	require.NoError(t, err)
+	require.Len(t, l.Failed, 2)
+	assert.Equal(t, 1, l.Failed[0].Chunk)
+	assert.Equal(t, 2, l.Failed[1].ExitCode)
+
+	require.NoError(t, l.Remove(1))
+	require.NoError(t, l.Remove(7))
+	assert.Equal(t, 1, l.Len())
+	require.NoError(t, l.Remove(4))
+	assert.NoFileExists(t, DeadLetterPath("f.bin"))
+}
+
+func TestPostPartMaxFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 50)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		ChunkSize:      100,
+		MaxConcurrency: 1,
+		// Status 3 isn't worth a retry, so each chunk's command runs once
+		PostPartCmd:         "exit 3",
+		PostPartRetries:     2,
+		PostPartRetryOn:     []int{75},
+		PostPartConcurrency: 1,
+		PostPartMaxFailures: 2,
+		HTTPConfig:          httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	err = d.Download(context.Background())
+	require.ErrorIs(t, err, errPostPartLimit)
+
+	l, err := LoadDeadLetters(d.GetArguments().FilenamePrefix)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, l.Len(), 2)
+	dl := l.Failed[0]
+	assert.Equal(t, 3, dl.ExitCode)
+	assert.Equal(t, 1, dl.Attempts)
+	assert.Equal(t, "exit 3", dl.Command)
+	// The download stopped short of completing, its state kept for a rerun
+	args, err := LoadDownloadArguments(d.GetArguments().FilenamePrefix)
+	require.NoError(t, err)
+	assert.NotNil(t, args)
+}
+
+func TestPostPartRetryClearsDeadLetter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+	args := NewDownloadArguments("https://example.com/f.bin", 100, 100, "f.bin")
+	l, err := LoadDeadLetters("f.bin")
+	require.NoError(t, err)
+	require.NoError(t, l.Add(DeadLetter{Chunk: 0, Error: "exit status 75", ExitCode: 75, Attempts: 1}))
+
+	// Fails with a retried status the first time only
+	d := &Downloader{
+		args:        args,
+		progress:    NewProgressTracker(args),
+		deadLetters: l,
+		config: Config{
+			PostPartCmd:     "test -f ran || { touch ran; exit 75; }",
+			PostPartRetries: 1,
+			PostPartRetryOn: []int{75},
+			PostPartBackoff: Backoff{Base: time.Millisecond},
+		},
+	}
+	d.postPartCh = make(chan int, 1)
+	d.postPartCh <- 0
+	close(d.postPartCh)
+	d.postPartWg.Add(1)
+	d.postPartWorker(context.Background())
+	assert.Zero(t, l.Len())
+	assert.NoFileExists(t, DeadLetterPath("f.bin"))
+}
+
+func TestPostPartRetryStopsOnCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+	args := NewDownloadArguments("https://example.com/f.bin", 100, 100, "f.bin")
+	l, err := LoadDeadLetters("f.bin")
+	require.NoError(t, err)
+
+	d := &Downloader{
+		args:        args,
+		progress:    NewProgressTracker(args),
+		deadLetters: l,
+		config: Config{
+			PostPartCmd:     "exit 1",
+			PostPartRetries: 3,
+			PostPartBackoff: Backoff{Base: time.Minute, Max: time.Minute},
+		},
+	}
+	d.postPartCh = make(chan int, 1)
+	d.postPartCh <- 0
+	close(d.postPartCh)
+	d.postPartWg.Add(1)
+
+	// Interrupted during the backoff: no wait, and no dead letter
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	d.postPartWorker(ctx)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Zero(t, l.Len())
+}
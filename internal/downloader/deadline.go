@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"context"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+)
+
+// deadlineCheckInterval is how often progress is compared against the deadline.
+const deadlineCheckInterval = 10 * time.Second
+
+// deadlineWarmup is how long to measure before judging the speed; the
+// first seconds are dominated by connection setup.
+const deadlineWarmup = 30 * time.Second
+
+// deadlineHeadroom is added to the required rate when lifting --limit-rate,
+// so the download doesn't finish exactly at the deadline at best.
+const deadlineHeadroom = 1.1
+
+// runDeadline checks every deadlineCheckInterval whether the download can
+// still finish by Config.Deadline, until ctx is done or the deadline passes.
+func (d *Downloader) runDeadline(ctx context.Context) {
+	w := &deadlineWatch{d: d, lastBytes: d.progress.TotalBytes(), lastCheck: time.Now()}
+	w.check(time.Now())
+
+	ticker := time.NewTicker(deadlineCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !w.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// deadlineWatch tracks the speed between checks.
+type deadlineWatch struct {
+	d         *Downloader
+	lastBytes int64
+	lastCheck time.Time
+	warned    bool
+}
+
+// check compares the rate needed to finish in time with the rate limit and
+// the recent speed. --limit-rate is lifted when it alone would make the
+// deadline impossible; a slow network is reported once per slow spell.
+// It returns false once the deadline has passed.
+func (w *deadlineWatch) check(now time.Time) bool {
+	d := w.d
+	p := d.progress
+
+	bytes := p.TotalBytes()
+	var speed float64
+	if interval := now.Sub(w.lastCheck).Seconds(); interval > 0 {
+		speed = float64(bytes-w.lastBytes) / interval
+	}
+	w.lastBytes, w.lastCheck = bytes, now
+
+	remaining := p.TotalSize() - p.DownloadedBytes()
+	if remaining <= 0 {
+		return true
+	}
+
+	left := d.config.Deadline.Sub(now)
+	if left <= 0 {
+		p.PrintMessage("Deadline passed with %s still to download", formatBytes(remaining))
+		d.config.Events.Emit(events.DeadlineMissed, "remaining", remaining)
+		return false
+	}
+	required := float64(remaining) / left.Seconds()
+
+	if limit := d.RateLimit(); limit > 0 && float64(limit) < required {
+		raised := int64(required * deadlineHeadroom)
+		p.PrintMessage("Raising rate limit from %s/s to %s/s to meet the deadline", formatBytes(limit), formatBytes(raised))
+		d.SetRateLimit(raised)
+		return true
+	}
+
+	if p.Elapsed() < deadlineWarmup || d.pause.isPaused() {
+		return true
+	}
+	if speed >= required {
+		w.warned = false
+		return true
+	}
+	if !w.warned {
+		w.warned = true
+		p.PrintMessage("Deadline at risk: %s left needs %s/s, currently %s/s",
+			formatDuration(left), formatBytes(int64(required)), formatBytes(int64(speed)))
+		d.config.Events.Emit(events.DeadlineAtRisk,
+			"required", int64(required),
+			"speed", int64(speed),
+			"remaining", remaining,
+			"deadline", d.config.Deadline.Format(time.RFC3339),
+		)
+	}
+	return true
+}
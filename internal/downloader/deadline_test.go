@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlineWatch(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Now()
+
+	// 1000 bytes due in 100s: 10 B/s needed
+	args := NewDownloadArguments("http://example.com/f", 1000, 100, "f")
+	d := &Downloader{
+		config:   Config{Deadline: now.Add(100 * time.Second), Events: events.NewWriter(&out)},
+		args:     args,
+		progress: NewProgressTracker(args),
+		jobs:     newJobGate(1),
+		limiter:  NewRateLimiter(5),
+		pause:    newPauseGate(),
+	}
+	d.progress.startTime = now.Add(-time.Minute)
+	w := &deadlineWatch{d: d, lastCheck: now.Add(-10 * time.Second)}
+
+	// A rate limit below what's needed is lifted
+	assert.True(t, w.check(now))
+	assert.Equal(t, int64(11), d.RateLimit())
+
+	// 5 B/s over the last 10s can't make it: warned once
+	d.progress.AddBytes(0, 50)
+	assert.True(t, w.check(now.Add(10*time.Second)))
+	assert.True(t, w.warned)
+
+	// Past the deadline the watch stops
+	assert.False(t, w.check(now.Add(101*time.Second)))
+
+	var types []string
+	for {
+		e, err := events.Read(&out)
+		if err != nil {
+			break
+		}
+		types = append(types, e["type"].(string))
+	}
+	require.Len(t, types, 3)
+	assert.Equal(t, []string{events.Settings, events.DeadlineAtRisk, events.DeadlineMissed}, types)
+}
@@ -0,0 +1,232 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// chunkDigest hashes a chunk's bytes as they are written to its file, so
+// the manifest gets the chunk's SHA-256 and MD5 without reading it back.
+type chunkDigest struct {
+	sha  hash.Hash
+	md5  hash.Hash
+	size int64
+}
+
+func newChunkDigest() *chunkDigest {
+	return &chunkDigest{sha: sha256.New(), md5: md5.New()}
+}
+
+func (h *chunkDigest) Write(p []byte) (int, error) {
+	h.sha.Write(p)
+	h.md5.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// seed starts the digest over with the first size bytes of the .tmp file
+// at path: only the part an earlier attempt or run already wrote is read.
+func (h *chunkDigest) seed(path string, size int64) error {
+	h.sha.Reset()
+	h.md5.Reset()
+	h.size = 0
+	if size == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.CopyN(h, f, size); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// digestFor returns the digest to feed chunk index's bytes to, seeded with
+// the currentSize bytes its .tmp file already holds, or nil when chunks
+// aren't hashed as they download: without --write-manifest, and for
+// encrypted chunks, whose manifest entries hash what is on disk.
+func (d *Downloader) digestFor(index int, currentSize int64) *chunkDigest {
+	if d.manifest == nil || d.config.Encrypt != nil {
+		return nil
+	}
+	var h *chunkDigest
+	if v, ok := d.digests.Load(index); ok {
+		h = v.(*chunkDigest)
+	} else {
+		h = newChunkDigest()
+		d.digests.Store(index, h)
+	}
+	if h.size != currentSize {
+		if err := h.seed(d.args.TmpPath(index), currentSize); err != nil {
+			d.digests.Delete(index)
+			return nil
+		}
+	}
+	return h
+}
+
+// takeDigest returns and forgets the digest of a finished chunk of size
+// bytes, or nil if its bytes weren't all hashed on the way in.
+func (d *Downloader) takeDigest(index int, size int64) *chunkDigest {
+	v, ok := d.digests.LoadAndDelete(index)
+	if !ok || v.(*chunkDigest).size != size {
+		return nil
+	}
+	return v.(*chunkDigest)
+}
+
+// fileDigest is the whole file's SHA-256, fed chunk by chunk in order as
+// they finish, while they are still in the page cache (and before
+// --post-part may move them), instead of in one pass over every chunk at
+// the end. Its state is saved in .{prefix}-hash.json after each chunk, so
+// a resumed download carries on where it was.
+type fileDigest struct {
+	mu     sync.Mutex
+	path   string
+	sha    hash.Hash
+	next   int  // first chunk not yet hashed
+	broken bool // a chunk was gone before it could be hashed
+}
+
+// fileDigestState is what .{prefix}-hash.json holds.
+type fileDigestState struct {
+	Next  int    `json:"next"`
+	State []byte `json:"sha256_state"`
+}
+
+// fileDigestPath returns where the whole-file hash state of prefix is kept.
+func fileDigestPath(prefix string) string {
+	return registry.StatePath(prefix, "hash.json")
+}
+
+// openFileDigest loads the whole-file hash state of an earlier run of this
+// download, or starts over.
+func (d *Downloader) openFileDigest(resuming bool) {
+	path := fileDigestPath(d.args.FilenamePrefix)
+	fd := &fileDigest{path: path, sha: sha256.New()}
+	d.fileDigest = fd
+	if !resuming {
+		os.Remove(path)
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var state fileDigestState
+	if json.Unmarshal(data, &state) != nil || state.Next < 0 || state.Next > d.args.NumChunks() {
+		return
+	}
+	if err := fd.sha.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.State); err != nil {
+		fd.sha.Reset()
+		return
+	}
+	fd.next = state.Next
+}
+
+// advanceFileDigest hashes the finished chunks that follow those already
+// hashed, and saves the state.
+func (d *Downloader) advanceFileDigest() {
+	fd := d.fileDigest
+	if fd == nil {
+		return
+	}
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	start := fd.next
+	for !fd.broken && fd.next < d.args.NumChunks() && d.progress.IsChunkComplete(fd.next) {
+		if err := d.hashPart(fd.sha, fd.next); err != nil {
+			fd.broken = true
+			break
+		}
+		fd.next++
+	}
+	if fd.next == start {
+		return
+	}
+
+	state, err := fd.sha.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(fileDigestState{Next: fd.next, State: state})
+	if err == nil {
+		err = os.WriteFile(fd.path, data, 0644)
+	}
+	if err != nil {
+		d.progress.PrintMessage("cannot save the whole-file hash state: %v", err)
+	}
+}
+
+// fileSHA256 returns the whole file's SHA-256, hashing whatever chunks are
+// left, or an error if a chunk was gone before it could be hashed.
+func (d *Downloader) fileSHA256() (string, error) {
+	fd := d.fileDigest
+	d.advanceFileDigest()
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.next < d.args.NumChunks() {
+		return "", fmt.Errorf("%s is no longer on disk", d.args.PartPath(fd.next))
+	}
+	return hex.EncodeToString(fd.sha.Sum(nil)), nil
+}
+
+// resetFileDigest starts the whole-file hash over, for chunks downloaded
+// again after it went past them.
+func (d *Downloader) resetFileDigest() {
+	fd := d.fileDigest
+	if fd == nil {
+		return
+	}
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.sha.Reset()
+	fd.next = 0
+	fd.broken = false
+	os.Remove(fd.path)
+}
+
+// forgetFileDigest removes the whole-file hash state once it has served.
+func (d *Downloader) forgetFileDigest() {
+	if d.fileDigest != nil {
+		os.Remove(d.fileDigest.path)
+	}
+}
+
+// hashPart feeds chunk index's data into h, decrypting it if needed.
+func (d *Downloader) hashPart(h io.Writer, index int) error {
+	partPath := d.args.PartPath(index)
+	f, err := os.Open(partPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s is no longer on disk", partPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if crypt.IsEncrypted(partPath) && d.config.Encrypt != nil {
+		src = d.config.Encrypt.NewReader(f)
+	}
+	if _, err := io.Copy(h, src); err != nil {
+		return fmt.Errorf("failed to read %s: %w", partPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkDigestResumesFromTmp(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// Half of chunk 0 came in on an earlier run
+	args := NewDownloadArguments(srv.URL+"/f.bin", 250, 100, "f.bin")
+	require.NoError(t, args.Save())
+	require.NoError(t, os.WriteFile(args.TmpPath(0), content[:50], 0644))
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 1,
+		WriteManifest:  true,
+		// Moves each chunk away once recorded: the manifest can't read them at the end
+		PostPartCmd: "mv {part} {part}.moved",
+		HTTPConfig:  httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+	assert.Contains(t, ranges, "bytes=50-99")
+
+	m, err := manifest.Load("f.bin.manifest.json")
+	require.NoError(t, err)
+	require.Len(t, m.Parts, 3)
+	sha, md := sha256.Sum256(content[:100]), md5.Sum(content[:100])
+	assert.Equal(t, hex.EncodeToString(sha[:]), m.Parts[0].SHA256)
+	assert.Equal(t, hex.EncodeToString(md[:]), m.Parts[0].MD5)
+
+	// In order, so every chunk was in the whole-file hash before it moved
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), m.SHA256)
+	assert.NoFileExists(t, fileDigestPath("f.bin"))
+}
+
+func TestFileDigestState(t *testing.T) {
+	t.Chdir(t.TempDir())
+	content := bytes.Repeat([]byte("abcdefghij"), 25)
+	args := NewDownloadArguments("https://example.com/f.bin", 250, 100, "f.bin")
+	for i := 0; i < 3; i++ {
+		start, end := args.ChunkRange(i)
+		require.NoError(t, os.WriteFile(args.PartPath(i), content[start:end+1], 0644))
+	}
+
+	// The first run hashes chunk 0; chunk 2 can't be hashed before chunk 1
+	d := &Downloader{args: args, progress: NewProgressTracker(args)}
+	d.openFileDigest(false)
+	d.progress.MarkComplete(0)
+	d.progress.MarkComplete(2)
+	d.advanceFileDigest()
+	assert.Equal(t, 1, d.fileDigest.next)
+
+	// The next run picks up at chunk 1 without reading chunk 0 again
+	require.NoError(t, os.Remove(args.PartPath(0)))
+	d = &Downloader{args: args, progress: NewProgressTracker(args)}
+	d.openFileDigest(true)
+	assert.Equal(t, 1, d.fileDigest.next)
+	for i := 0; i < 3; i++ {
+		d.progress.MarkComplete(i)
+	}
+	got, err := d.fileSHA256()
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), got)
+}
@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+)
+
+// diskCheckInterval is how often the time spent writing chunk files is
+// looked at.
+const diskCheckInterval = 5 * time.Second
+
+// diskBoundShare is the share of the chunk transfers' time spent waiting
+// for writes above which the disk, not the network, limits the download.
+const diskBoundShare = 0.5
+
+// diskHealthyShare is the share below which the disk keeps up, and
+// diskRecoverChecks how many checks in a row it must do so before a job
+// taken away is given back.
+const (
+	diskHealthyShare  = 0.1
+	diskRecoverChecks = 3
+)
+
+// diskMonitor notices when writing the chunk files is what holds the
+// download back, as when an SMR drive stalls to reshuffle its zones or a
+// USB disk's cache fills up. Transfers then sit blocked in writes while
+// their connections idle towards the read timeout, and more jobs only make
+// it worse, so a job is taken away at each check the disk stays behind
+// and given back once it has kept up for a while.
+type diskMonitor struct {
+	writing atomic.Int64 // nanoseconds spent in chunk file writes since the last check
+
+	mu      sync.Mutex
+	bound   bool
+	taken   int // jobs taken away while disk-bound
+	healthy int // checks in a row the disk kept up
+}
+
+// observe records a chunk file write that took took.
+func (m *diskMonitor) observe(took time.Duration) {
+	if m != nil {
+		m.writing.Add(int64(took))
+	}
+}
+
+// forget drops the jobs taken away, after the user set --jobs themselves.
+func (m *diskMonitor) forget() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.taken = 0
+}
+
+// runDiskMonitor checks every diskCheckInterval whether the disk keeps up,
+// until ctx is done.
+func (d *Downloader) runDiskMonitor(ctx context.Context) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.checkDisk(now.Sub(last))
+			last = now
+		}
+	}
+}
+
+// checkDisk compares the time the active transfers spent writing over the
+// last interval with the time they ran, and lowers or restores the jobs.
+func (d *Downloader) checkDisk(interval time.Duration) {
+	m := d.disk
+	writing := time.Duration(m.writing.Swap(0))
+	active := 0
+	for i := 0; i < d.progress.NumChunks(); i++ {
+		if d.progress.IsActive(i) {
+			active++
+		}
+	}
+	if active == 0 || interval <= 0 {
+		return
+	}
+	share := float64(writing) / (float64(interval) * float64(active))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := d.jobs.getLimit()
+	switch {
+	case share >= diskBoundShare:
+		m.healthy = 0
+		if jobs > 1 {
+			jobs--
+			m.taken++
+			d.jobs.setLimit(jobs)
+			d.emitSettings()
+			d.progress.PrintMessage("disk-bound: writes took %.0f%% of the transfer time, jobs lowered to %d", share*100, jobs)
+		} else if !m.bound {
+			d.progress.PrintMessage("disk-bound: writes took %.0f%% of the transfer time", share*100)
+		}
+		if !m.bound {
+			m.bound = true
+			d.progress.SetDiskBound(true)
+			d.config.Events.Emit(events.DiskBound, "share", share, "jobs", jobs)
+		}
+
+	case share < diskHealthyShare && m.bound:
+		m.healthy++
+		if m.healthy < diskRecoverChecks {
+			return
+		}
+		m.healthy = 0
+		if m.taken > 0 {
+			m.taken--
+			jobs++
+			d.jobs.setLimit(jobs)
+			d.emitSettings()
+		}
+		if m.taken == 0 {
+			m.bound = false
+			d.progress.SetDiskBound(false)
+			d.config.Events.Emit(events.DiskRecovered, "jobs", jobs)
+			d.progress.PrintMessage("disk keeping up again, jobs back to %d", jobs)
+		}
+
+	default:
+		m.healthy = 0
+	}
+}
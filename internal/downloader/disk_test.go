@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDisk(t *testing.T) {
+	var buf bytes.Buffer
+	d := &Downloader{
+		config:   Config{Events: events.NewWriter(&buf)},
+		jobs:     newJobGate(3),
+		limiter:  NewRateLimiter(0),
+		disk:     &diskMonitor{},
+		progress: NewProgressTracker(NewDownloadArguments("https://example.com/f", 400, 100, "f")),
+	}
+	d.progress.SetActive(0, true)
+	d.progress.SetActive(1, true)
+
+	// Two transfers, each blocked in writes for most of the interval
+	slow := func() {
+		d.disk.observe(8 * time.Second)
+		d.checkDisk(5 * time.Second)
+	}
+	fast := func() {
+		d.disk.observe(100 * time.Millisecond)
+		d.checkDisk(5 * time.Second)
+	}
+
+	slow()
+	assert.Equal(t, 2, d.Concurrency())
+	assert.True(t, d.progress.DiskBound())
+	slow()
+	slow()
+	assert.Equal(t, 1, d.Concurrency(), "never below one job")
+
+	// A job comes back after three healthy checks in a row
+	fast()
+	fast()
+	assert.Equal(t, 1, d.Concurrency())
+	fast()
+	assert.Equal(t, 2, d.Concurrency())
+	assert.True(t, d.progress.DiskBound())
+	fast()
+	fast()
+	fast()
+	assert.Equal(t, 3, d.Concurrency())
+	assert.False(t, d.progress.DiskBound())
+
+	var types []string
+	for {
+		ev, err := events.Read(&buf)
+		if err != nil {
+			break
+		}
+		types = append(types, ev["type"].(string))
+	}
+	require.Contains(t, types, events.DiskBound)
+	assert.Equal(t, events.DiskRecovered, types[len(types)-1])
+}
+
+func TestCheckDiskKeepsUserJobs(t *testing.T) {
+	d := &Downloader{
+		jobs:     newJobGate(4),
+		limiter:  NewRateLimiter(0),
+		disk:     &diskMonitor{},
+		progress: NewProgressTracker(NewDownloadArguments("https://example.com/f", 400, 100, "f")),
+	}
+	d.progress.SetActive(0, true)
+
+	d.disk.observe(4 * time.Second)
+	d.checkDisk(5 * time.Second)
+	assert.Equal(t, 3, d.Concurrency())
+
+	// Jobs set by hand aren't raised past what was asked for
+	d.SetConcurrency(2)
+	for i := 0; i < diskRecoverChecks*2; i++ {
+		d.checkDisk(5 * time.Second)
+	}
+	assert.Equal(t, 2, d.Concurrency())
+	assert.False(t, d.progress.DiskBound())
+}
+
+func TestDiskLimit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("rapel"), 600)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		ChunkSize:      1000,
+		MaxConcurrency: 3,
+		DiskLimit:      2000,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: 5 * time.Second},
+	})
+	require.NoError(t, err)
+	started := time.Now()
+	require.NoError(t, d.Download(context.Background()))
+
+	// A second's worth goes at once, the other 1000 bytes take half a second
+	assert.GreaterOrEqual(t, time.Since(started), 400*time.Millisecond)
+	var got []byte
+	for i := range 3 {
+		data, err := os.ReadFile(storage.PartName(d.GetArguments().FilenamePrefix, i))
+		require.NoError(t, err)
+		got = append(got, data...)
+	}
+	assert.Equal(t, content, got)
+}
@@ -3,63 +3,324 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/fsutil"
 	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/logging"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/schedule"
+	"github.com/redraw/rapel/internal/storage"
 )
 
 // Config holds downloader configuration
 type Config struct {
 	URL                 string
 	ChunkSize           int64
+	ExplicitChunkSize   bool // ChunkSize was asked for, not a default: refuse one larger than the file
+	AutoChunkSize       bool // Optional: pick ChunkSize from the file size and the HEAD round trip (-c auto)
+	MaxChunks           int  // Optional: refuse to cut the file into more chunks than this (0 = no cap)
 	MaxConcurrency      int
+	Pool                *JobPool // Optional: chunk slots shared with other Downloaders instead of MaxConcurrency of its own
 	Force               bool
+	OnCollision         string // Optional: what to do when another URL's unfinished download uses the same prefix (see CollisionPolicies)
+	IfExists            string // Optional: what to do when the output file or conflicting state already exists (see ExistsPolicies)
+	IfLocked            string // Optional: what to do when another process is downloading to the same prefix (see LockedPolicies; default fail)
+	HoldLock            bool   // Optional: keep the prefix's lock once Download returns, until ReleaseLock, so the merge that follows is covered too
+	OutputDir           string // Optional: where the merged file goes, for IfExists to look for it (default: current directory)
+	OutputName          string // Optional: the merged file's name in OutputDir, when not the prefix
+	SkipComplete        bool   // Optional: skip the download when the output file already matches the remote
+	ContinueFile        bool   // Optional: take an output file without saved state, as curl or wget leave one, as the start of the download
 	HTTPConfig          httpclient.Config
-	TotalSize           int64  // Optional: if 0, will perform HEAD request
-	PostPartCmd         string // Optional: command to run after each part completes
-	PostPartConcurrency int    // Optional: max concurrent post-part commands (0 = unlimited)
+	TotalSize           int64             // Optional: if 0, will perform HEAD request
+	ProbeTimeout        time.Duration     // Optional: limit on each attempt of the request that sizes the file (0 = dial and read timeouts only)
+	ProbeRetries        int               // Optional: more attempts of that request after a timeout or network error
+	ContentDisposition  bool              // Optional: name the file after the HEAD response's Content-Disposition
+	Recover             bool              // Optional: rebuild unreadable or missing args from the chunk files on disk
+	MaxTime             time.Duration     // Optional: give up once the whole download has taken this long (0 = no limit)
+	RetryOnStatus       []int             // Optional: statuses to retry on top of the default policy, which gives up on most 4xx
+	RetryBudget         int               // Optional: max retries across all chunks before giving up (0 = unlimited)
+	Backoff             Backoff           // Optional: wait between retries (zero value = 2s doubling up to 1m)
+	StateBackups        int               // Optional: previous generations of each state file to keep (.1 = newest)
+	Pace                time.Duration     // Optional: average gap between request starts, jittered 50-150% (0 = none)
+	PostPartCmd         string            // Optional: command to run after each part completes
+	PostPartExec        []string          // Optional: program and arguments to run after each part completes, without a shell
+	PostPartConcurrency int               // Optional: max concurrent post-part commands (0 = unlimited)
+	PostPartTimeout     time.Duration     // Optional: kill a post-part command running longer than this (0 = no limit)
+	PostPartRetries     int               // Optional: times to run a failed post-part command again
+	PostPartRetryOn     []int             // Optional: exit statuses worth a retry (empty = any); a timeout always is
+	PostPartBackoff     Backoff           // Optional: wait between post-part retries (zero value = 1s doubling up to 30s)
+	PostPartMaxFailures int               // Optional: stop the download once this many post-part commands failed for good (0 = never)
+	PipePartCmd         string            // Optional: stream each chunk into this command's stdin instead of writing .part files
+	FetchCmd            string            // Optional: command whose stdout supplies each byte range instead of an HTTP GET
+	URLCmd              string            // Optional: command printing a freshly signed URL for each request
+	RateLimit           int64             // Optional: max bytes per second across all chunks (0 = unlimited)
+	TUI                 bool              // Optional: render the interactive dashboard instead of line output
+	ChunkMap            bool              // Optional: add a map of the chunks to the progress line
+	NoEndgame           bool              // Optional: don't split straggler chunks near the end
+	MinSpeed            int64             // Optional: reconnect when a transfer stays below this many bytes/s (0 = off)
+	StallTimeout        time.Duration     // How long a transfer may stay below MinSpeed
+	MetricsListen       string            // Optional: address to serve Prometheus metrics on
+	MetricsFile         string            // Optional: file to write Prometheus metrics to periodically
+	Schedule            schedule.Schedule // Optional: daily windows to download in; paused outside them
+	Events              *events.Writer    // Optional: structured lifecycle events for wrapper programs
+	Order               string            // Optional: which pending chunks are dispatched first (see Orders; default: sequential)
+	Sequential          bool              // Optional: one request at a time in file order over one connection, for servers that refuse out-of-order ranges
+	Coalesce            int64             // Optional: fetch adjacent chunks smaller than this in one request of up to this many bytes (0 = off)
+	PackParts           int               // Optional: concatenate each run of this many completed chunks into one file (0 = off)
+	Storage             storage.Storage   // Optional: where chunks are written (default: .tmp/.part files in the current directory)
+	Encrypt             *crypt.Key        // Optional: encrypt the local .tmp/.part files with this key
+	BanCooldown         time.Duration     // Optional: pause all requests this long when the server starts refusing every worker (0 = off)
+	BanCmd              string            // Optional: command to run when a ban cool-down starts (supports {status})
+	Growing             time.Duration     // Optional: the file is still being written; finish once its size stays put this long (0 = off)
+	GrowInterval        time.Duration     // How often to re-check the size of a growing file
+	Follow              bool              // Optional: append new bytes to FollowOutput as they appear, like tail -f
+	FollowOutput        string            // File --follow appends to (default: the filename prefix)
+	FollowInterval      time.Duration     // How often to poll for new bytes when caught up
+	FollowIdle          time.Duration     // Stop following once the file hasn't grown for this long (0 = never)
+	OnlyChunks          []Span            // Optional: re-fetch only these chunk indexes, leaving the others untouched
+	ByteRanges          []Span            // Optional: re-fetch only the chunks overlapping these byte ranges
+	Subset              []Span            // Optional: fetch only these chunk indexes, resuming them; the others are left to other machines (see SubPlan)
+	Prefix              string            // Optional: filename prefix to use instead of one named after the URL
+	DiskThrottle        bool              // Optional: lower the jobs while writing the chunk files holds the download back
+	DiskLimit           int64             // Optional: max bytes per second written to the chunk files (0 = unlimited)
+	WriteBuffer         int               // Optional: bytes of a chunk held in memory before they are written to its file (0 = written as received)
+	Fsync               string            // Optional: when the chunk files are flushed to the disk (see storage.SyncPolicies; default storage.SyncChunk)
+	FsyncInterval       time.Duration     // Optional: with storage.SyncInterval, how often (0 = 5s)
+	MergeRate           float64           // Optional: bytes/s the merge after the download is expected to run at, counted in the ETA (0 = no merge)
+	WriteManifest       bool              // Optional: keep <prefix>.manifest.json with each chunk's range and hashes
+	PartMeta            string            // Optional: also describe each part next to it (see PartMetaModes); needs WriteManifest
+	TransferLog         bool              // Optional: record every chunk request in the transfer log (see TransferLogPath)
+	Deadline            time.Time         // Optional: warn when the download can't finish by then, lifting RateLimit if needed
+	ChecksumRepairs     int               // Optional: after the file fails the server's checksum, download the chunks to blame again and check again, up to this many times (0 = fail at once)
+	Hooks               HookSandbox
 }
 
 // HasPostPartCmd returns whether post-part command is configured
 func (c *Config) HasPostPartCmd() bool {
-	return c.PostPartCmd != ""
+	return c.PostPartCmd != "" || len(c.PostPartExec) > 0
+}
+
+// HasPipePartCmd returns whether chunks are piped to a command instead of written to disk
+func (c *Config) HasPipePartCmd() bool {
+	return c.PipePartCmd != ""
 }
 
 // Downloader manages the chunked download process
 type Downloader struct {
-	config     Config
-	args       *DownloadArguments
-	client     *httpclient.Client
-	progress   *ProgressTracker
-	postPartWg sync.WaitGroup
-	postPartCh chan int
+	config         Config
+	args           *DownloadArguments
+	client         *httpclient.Client
+	progress       *ProgressTracker
+	live           atomic.Pointer[liveDownload] // the download under way, for the --rpc-socket
+	finished       atomic.Bool                  // Download returned
+	jobs           *jobGate
+	limiter        *RateLimiter
+	diskLimiter    *RateLimiter // DiskLimit on writes, apart from the network's
+	pause          *pauseGate
+	ban            banGuard
+	storage        storage.Storage
+	pipeState      *PipeState
+	lock           *registry.Lock
+	entry          *registry.Entry
+	runs           sync.Map     // chunk index -> *chunkRun for chunks in flight
+	packed         sync.Map     // first chunk index -> true for --pack-parts blocks claimed
+	only           map[int]bool // chunks selected by OnlyChunks/ByteRanges (nil = all)
+	postPartWg     sync.WaitGroup
+	postPartCh     chan int
+	postPartActive atomic.Int32
+	postPartFailed atomic.Int32            // post-part commands failed for good this session
+	postPartStop   atomic.Bool             // PostPartMaxFailures reached: skip the queued commands
+	stopDownload   context.CancelCauseFunc // stops the chunk transfers with a cause
+	deadLetters    *DeadLetters
+	fetched        atomic.Int64        // bytes produced by --fetch-cmd
+	discarded      atomic.Int64        // bytes downloaded this session, then thrown away
+	earlierRounds  int64               // bytes downloaded by earlier --growing rounds
+	acceptRanges   string              // Accept-Ranges from the HEAD response
+	remoteMD5      string              // the content's MD5 from the HEAD response, if given
+	remoteDigests  []httpclient.Digest // the whole content's checksums from the HEAD response
+	headRTT        time.Duration       // how long the HEAD request took, for -c auto
+	urlTag         string              // the URL's hash in the chunk and state names, if avoidCollision put it there
+	progressState  *ProgressState
+	progressMu     sync.Mutex // guards progressState
+	retries        retryBudget
+	sizeRecheck    sizeRecheck // after a range answer gave another size
+	pacer          pacer
+	disk           *diskMonitor       // nil unless DiskThrottle
+	manifest       *manifest.Manifest // nil unless WriteManifest
+	manifestMu     sync.Mutex         // guards manifest
+	digests        sync.Map           // chunk index -> *chunkDigest, with WriteManifest
+	fileDigest     *fileDigest        // nil unless WriteManifest
+	transfers      *transferLog       // nil unless TransferLog
+	requests       sync.Map           // chunk index -> *atomic.Int32 counting its requests this run, for the transfer log
 }
 
 // NewDownloader creates a new Downloader
 func NewDownloader(config Config) (*Downloader, error) {
+	if err := config.Hooks.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Endgame tails are plain files appended to the chunk at the end, so
+	// they would leave unencrypted data on disk
+	if config.Encrypt != nil {
+		if config.Storage != nil || config.HasPipePartCmd() {
+			return nil, fmt.Errorf("encrypted chunks are only supported with local chunk files")
+		}
+		config.NoEndgame = true
+	}
+	// Picky servers only accept ranges that move forward within a
+	// connection: one connection, chunks in order, no endgame helpers
+	// fetching tails ahead of their chunk
+	if config.Sequential {
+		config.Order = OrderSequential
+		config.NoEndgame = true
+		config.HTTPConfig.MaxConnsPerHost = 1
+	}
+	if config.WriteManifest && (config.Storage != nil || config.HasPipePartCmd()) {
+		return nil, fmt.Errorf("a manifest can only be written for local chunk files")
+	}
+	if config.PartMeta != "" && !config.WriteManifest {
+		return nil, fmt.Errorf("part metadata is written with the manifest, which is off")
+	}
+	if config.PartMeta == PartMetaXattr && !xattrSupported {
+		return nil, fmt.Errorf("extended attributes are only supported on Linux")
+	}
+
 	client, err := httpclient.NewClient(config.HTTPConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
 	}
 
+	jobs := newJobGate(config.MaxConcurrency)
+	if config.Pool != nil {
+		jobs = config.Pool.gate
+	}
+
+	var disk *diskMonitor
+	if config.DiskThrottle {
+		disk = &diskMonitor{}
+	}
+
 	return &Downloader{
-		config: config,
-		client: client,
+		config:      config,
+		client:      client,
+		disk:        disk,
+		jobs:        jobs,
+		limiter:     NewRateLimiter(config.RateLimit),
+		diskLimiter: NewRateLimiter(config.DiskLimit),
+		pause:       newPauseGate(),
+		retries:     retryBudget{limit: config.RetryBudget},
+		pacer:       pacer{interval: config.Pace},
+		ban:         newBanGuard(config.BanCooldown, config.MaxConcurrency),
 	}, nil
 }
 
+// SetConcurrency changes the number of chunks downloaded concurrently.
+// Running chunks are never interrupted; a lower value takes effect as they finish.
+func (d *Downloader) SetConcurrency(n int) {
+	before := d.Concurrency()
+	d.jobs.setLimit(n)
+	d.disk.forget()
+	if d.Concurrency() != before {
+		d.emitSettings()
+	}
+}
+
+// Concurrency returns the current number of concurrent chunk downloads allowed.
+func (d *Downloader) Concurrency() int {
+	return d.jobs.getLimit()
+}
+
+// SetRateLimit changes the aggregate download rate in bytes per second (0 = unlimited).
+func (d *Downloader) SetRateLimit(bytesPerSec int64) {
+	before := d.RateLimit()
+	d.limiter.SetLimit(bytesPerSec)
+	if d.RateLimit() != before {
+		d.emitSettings()
+	}
+}
+
+// RateLimit returns the aggregate download rate limit in bytes per second (0 = unlimited).
+func (d *Downloader) RateLimit() int64 {
+	return d.limiter.Limit()
+}
+
+// MergeEstimate returns how long the merge after the download is expected
+// to take, or 0 if there is none.
+func (d *Downloader) MergeEstimate() time.Duration {
+	if d.config.MergeRate <= 0 || d.args == nil {
+		return 0
+	}
+	return time.Duration(float64(d.args.TotalSize) / d.config.MergeRate * float64(time.Second))
+}
+
+// PostPartQueueDepth returns the number of post-part commands queued or running.
+func (d *Downloader) PostPartQueueDepth() int {
+	return len(d.postPartCh) + int(d.postPartActive.Load())
+}
+
 // Download performs the chunked download
-func (d *Downloader) Download(ctx context.Context) error {
-	prefix := extractFilenameFromURL(d.config.URL)
-	if prefix == "" {
-		prefix = "download"
+func (d *Downloader) Download(ctx context.Context) (err error) {
+	defer d.finished.Store(true)
+	if d.config.MaxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, d.config.MaxTime, errMaxTime)
+		defer cancel()
+	}
+
+	switch {
+	case d.config.Follow:
+		return d.follow(ctx)
+	case d.config.Growing > 0:
+		return d.downloadGrowing(ctx)
+	}
+	return d.download(ctx)
+}
+
+// download fetches every chunk of the file at its current size.
+func (d *Downloader) download(ctx context.Context) (err error) {
+	// The HEAD request comes first: it may name the file
+	prefix, totalSize, fromHeader, err := d.sizeFile(ctx)
+	if err != nil {
+		return err
+	}
+	if prefix, err = d.avoidCollision(prefix); err != nil {
+		return err
+	}
+	if err := d.checkComplete(prefix, totalSize); err != nil {
+		return err
+	}
+	if prefix, err = d.resolveExisting(prefix, totalSize, true); err != nil {
+		return err
+	}
+
+	// Only one process may work on a prefix's chunk files at a time. A
+	// growing download keeps the lock between rounds.
+	if d.lock == nil {
+		if d.lock, err = registry.Acquire(registry.LockPath(prefix)); err != nil {
+			return d.locked(ctx, prefix, err)
+		}
+	}
+	if d.config.Growing == 0 && !d.config.HoldLock {
+		defer d.releaseLock()
 	}
 
 	// Load existing args if not forcing a fresh start
@@ -67,144 +328,466 @@ func (d *Downloader) Download(ctx context.Context) error {
 	if !d.config.Force {
 		var err error
 		existingArgs, err = LoadDownloadArguments(prefix)
+		if d.config.Recover && (err != nil || existingArgs == nil) {
+			if existingArgs, err = d.recoverState(prefix, totalSize, err); err != nil {
+				return err
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("failed to load args: %w", err)
+			return fmt.Errorf("failed to load args: %w (--recover rebuilds it from the chunk files)", err)
+		}
+		if existingArgs != nil {
+			if err := existingArgs.finishReslice(); err != nil {
+				return err
+			}
 		}
 	}
 
-	// Get content length if not provided
-	totalSize := d.config.TotalSize
-	if totalSize == 0 {
-		var err error
-		totalSize, err = d.client.GetContentLength(ctx, d.config.URL)
-		if err != nil {
-			return fmt.Errorf("failed to get content length: %w", err)
+	// A growing file may have been appended to since the last round
+	grown := false
+	if existingArgs != nil && d.config.Growing > 0 && existingArgs.Matches(d.config.URL) && totalSize > existingArgs.TotalSize {
+		if err := existingArgs.grow(totalSize); err != nil {
+			return err
 		}
+		grown = true
 	}
 
 	// Validate loaded args or create fresh ones
-	if existingArgs != nil && (existingArgs.URL != d.config.URL || existingArgs.TotalSize != totalSize) {
+	if existingArgs != nil && (!existingArgs.Matches(d.config.URL) || existingArgs.TotalSize != totalSize) {
 		if !d.config.Force {
-			return fmt.Errorf("existing args don't match URL/size, use --force to restart")
+			return failure.Errorf(failure.ErrSizeMismatch, "existing args don't match URL/size, use --force to restart")
 		}
 		existingArgs = nil
 	}
 
+	// Resaving unchanged args still rotates them, so a backup of the
+	// current generation exists from the second run on
 	if existingArgs != nil {
 		d.args = existingArgs
+		if err := d.maybeReslice(); err != nil {
+			return err
+		}
 	} else {
+		d.applyAutoChunkSize(totalSize)
+		if err := d.checkChunkSize(totalSize); err != nil {
+			return err
+		}
 		d.args = NewDownloadArguments(d.config.URL, totalSize, d.config.ChunkSize, prefix)
+		if name := d.outputName(prefix); name != prefix {
+			d.args.OutputName = name
+		}
+		// A re-slice of the download being started over no longer applies
+		if err := os.Remove(ReslicePath(prefix)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove re-slice: %w", err)
+		}
+	}
+	d.args.KeepBackups(d.config.StateBackups)
+	// A growing file's answers are expected to give other sizes
+	if d.config.Growing == 0 {
+		d.client.ExpectSize(d.args.TotalSize)
+	}
+
+	// Registering a new download decides where its state is kept, so it
+	// comes before the first save
+	if err := d.register(); err != nil {
+		return err
+	}
+	defer func() {
+		d.finishRegistration(err)
+		d.recordStats(err)
+	}()
+
+	if existingArgs == nil || grown || d.config.StateBackups > 0 {
 		if err := d.args.Save(); err != nil {
 			return fmt.Errorf("failed to save args: %w", err)
 		}
 	}
+	if existingArgs == nil && d.config.ContinueFile {
+		if err := d.continueFile(); err != nil {
+			return err
+		}
+	}
 
 	// Build progress tracker
 	d.progress = NewProgressTracker(d.args)
+	d.progress.SetChunkMap(d.config.ChunkMap)
+	d.live.Store(&liveDownload{file: prefix, progress: d.progress})
+	d.resumeProgress(prefix, existingArgs != nil)
+	defer d.logTraffic()
+	d.openTransferLog(prefix, existingArgs == nil)
+	defer d.closeTransferLog()
+	if d.config.WriteManifest {
+		d.openManifest(existingArgs != nil)
+	}
 
-	// Seed progress from on-disk chunk files (resume detection)
+	if d.config.HasPipePartCmd() {
+		var err error
+		d.pipeState, err = LoadPipeState(prefix)
+		if err != nil && d.config.Recover && existingArgs != nil {
+			d.pipeState, err = restorePipeState(prefix, err)
+		}
+		if err != nil {
+			return err
+		}
+		if existingArgs == nil {
+			// Fresh start: piped chunks from an earlier download no longer apply
+			if err := d.pipeState.Delete(); err != nil {
+				return fmt.Errorf("failed to reset pipe state: %w", err)
+			}
+			d.pipeState, _ = LoadPipeState(prefix)
+		}
+		d.pipeState.KeepBackups(d.config.StateBackups)
+	}
+
+	// Failures from earlier runs stay listed until their chunk's command
+	// succeeds, which a resumed or fresh run tries again
+	if d.config.HasPostPartCmd() {
+		if d.deadLetters, err = LoadDeadLetters(prefix); err != nil {
+			return err
+		}
+	}
+
+	d.storage = d.config.Storage
+	if d.storage == nil {
+		local := storage.NewLocal("", prefix)
+		local.Key = d.config.Encrypt
+		local.WriteBuffer = d.config.WriteBuffer
+		local.Sync = d.config.Fsync
+		local.SyncEvery = d.config.FsyncInterval
+		d.storage = local
+	}
+
+	// Selected chunks start over, except for a sub-plan's; the rest are
+	// left alone
+	if d.only, err = d.resolveSelection(); err != nil {
+		return err
+	}
+	if d.only != nil {
+		if d.pipeState != nil {
+			return fmt.Errorf("selecting chunks is not supported with --pipe-part")
+		}
+		if d.refetching() {
+			if err := d.resetSelected(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Seed progress from stored chunks (resume detection)
+	var stored []storage.ChunkState
+	if d.pipeState == nil {
+		if _, local := d.storage.(*storage.Local); local {
+			if err := d.removePackedLeftovers(); err != nil {
+				return err
+			}
+		}
+		if stored, err = d.storage.ListChunks(d.args.NumChunks()); err != nil {
+			return err
+		}
+	}
 	for i := 0; i < d.args.NumChunks(); i++ {
-		if _, err := os.Stat(d.args.PartPath(i)); err == nil {
-			// .part exists: chunk is complete
+		if d.pipeState != nil {
+			// Piped chunks never touch disk; only the pipe state knows about them
+			if d.pipeState.IsPiped(i) {
+				d.progress.MarkComplete(i)
+			}
+			continue
+		}
+
+		if stored[i].Complete {
+			// .part (or packed file) exists: chunk is complete
 			d.progress.MarkComplete(i)
-		} else if info, err := os.Stat(d.args.TmpPath(i)); err == nil {
-			// .tmp exists: partially downloaded; seed for display but don't mark complete
-			size := info.Size()
-			if size > d.args.ChunkSizeAt(i) {
-				size = d.args.ChunkSizeAt(i)
+		} else if recorded := d.progressState.Chunks[i]; stored[i].Bytes < recorded {
+			// Writes the system hadn't flushed when it went down
+			slog.Warn(fmt.Sprintf("chunk %d has %s, but %s were recorded: the rest is fetched again",
+				i, formatBytes(stored[i].Bytes), formatBytes(recorded)), "chunk", i, "bytes", stored[i].Bytes, "recorded", recorded)
+		}
+		if !stored[i].Complete && stored[i].Bytes > d.args.ChunkSizeAt(i) {
+			if err := d.discardOversized(i, stored[i].Bytes); err != nil {
+				return err
 			}
-			d.progress.SeedChunk(i, size)
+			stored[i].Bytes = 0
+		}
+		if !stored[i].Complete && stored[i].Bytes > 0 {
+			// .tmp exists: partially downloaded; seed for display but don't mark complete
+			d.progress.SeedChunk(i, stored[i].Bytes)
+		}
+	}
+
+	safeURL := redact.URL(d.config.URL)
+	slog.Info("URL        : "+safeURL, "url", safeURL)
+	if fromHeader {
+		slog.Info("File       : "+prefix+" (from Content-Disposition)", "file", prefix)
+	} else {
+		slog.Info("File       : "+prefix, "file", prefix)
+	}
+	slog.Info("Size       : "+formatBytes(totalSize), "bytes", totalSize)
+	slog.Info("Chunk size : "+formatBytes(d.args.ChunkSize), "chunk_size", d.args.ChunkSize)
+	slog.Info(fmt.Sprintf("Chunks     : %d", d.args.NumChunks()), "chunks", d.args.NumChunks())
+	slog.Info(fmt.Sprintf("Jobs       : %d", d.config.MaxConcurrency), "jobs", d.config.MaxConcurrency)
+	if d.config.RateLimit > 0 {
+		slog.Info("Rate limit : "+formatBytes(d.config.RateLimit)+"/s", "rate_limit", d.config.RateLimit)
+	}
+	if d.config.DiskLimit > 0 {
+		slog.Info("Disk limit : "+formatBytes(d.config.DiskLimit)+"/s", "disk_limit", d.config.DiskLimit)
+	}
+	logging.Blank()
+
+	d.config.Events.Emit(events.Start,
+		"url", safeURL,
+		"file", prefix,
+		"size", totalSize,
+		"chunk_size", d.args.ChunkSize,
+		"chunks", d.args.NumChunks(),
+		"completed", d.progress.CompletedCount(),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if d.config.TUI {
+		if d.progress.IsTTY() {
+			dash := newDashboard(d, cancel)
+			dash.start(ctx)
+			defer dash.stop()
+		} else {
+			slog.Warn("--tui requires a terminal, using plain output")
 		}
 	}
 
-	fmt.Printf("URL        : %s\n", d.config.URL)
-	fmt.Printf("File       : %s\n", prefix)
-	fmt.Printf("Size       : %s\n", formatBytes(totalSize))
-	fmt.Printf("Chunk size : %s\n", formatBytes(d.config.ChunkSize))
-	fmt.Printf("Chunks     : %d\n", d.args.NumChunks())
-	fmt.Printf("Jobs       : %d\n", d.config.MaxConcurrency)
-	fmt.Println()
+	// Runtime tuning: `rapel ctl` and SIGUSR1/SIGUSR2
+	stopControl := d.startControl()
+	defer stopControl()
+	d.watchSignals(ctx)
 
-	if err := d.downloadAllChunks(ctx); err != nil {
+	if d.config.Schedule != nil {
+		next := d.applySchedule(time.Now())
+		go d.runSchedule(ctx, next)
+	}
+
+	if !d.config.Deadline.IsZero() {
+		go d.runDeadline(ctx)
+	}
+	if d.disk != nil {
+		go d.runDiskMonitor(ctx)
+	}
+
+	stopMetrics, err := d.startMetrics(ctx)
+	if err != nil {
 		return err
 	}
+	defer stopMetrics()
 
+	stopSaving := d.saveProgressEvery(progressSaveInterval)
+	defer stopSaving()
+
+	fetch := d.downloadAllChunks
+	if !d.rangesSupported(ctx) {
+		fetch = d.downloadStream
+	}
+	err = fetch(ctx)
+	// Some servers only honor Range now and then, or only near the start
+	if errors.Is(err, httpclient.ErrRangeIgnored) && ctx.Err() == nil {
+		slog.Warn("The server answered a range request with the whole file: downloading the rest in a single stream, --jobs has no effect")
+		d.config.Events.Emit(events.SingleStream, "status", 200)
+		err = d.downloadStream(ctx)
+	}
+	if err != nil {
+		if context.Cause(ctx) == errMaxTime {
+			return fmt.Errorf("%w (%s); finished chunks are kept, rerun to resume", errMaxTime, d.config.MaxTime)
+		}
+		return err
+	}
+
+	// Keep the state for the chunks this run wasn't asked to fetch
+	if missing := d.MissingChunks(); missing > 0 {
+		slog.Info(fmt.Sprintf("Fetched %d selected chunks, %d chunks still missing", len(d.only), missing),
+			"selected", len(d.only), "missing", missing)
+		return nil
+	}
+
+	// A growing file is complete only once it stops growing
+	if d.config.Growing > 0 {
+		return nil
+	}
+	return d.complete(ctx)
+}
+
+// complete reports the finished download and removes its state.
+func (d *Downloader) complete(ctx context.Context) error {
+	if err := d.verifyRemoteDigests(ctx); err != nil {
+		return err
+	}
 	d.progress.PrintComplete()
+	d.config.Events.Emit(events.DownloadComplete, "bytes", d.args.TotalSize)
 
-	if err := d.args.Delete(); err != nil {
+	if err := d.completeManifest(); err != nil {
+		return err
+	}
+	if d.deadLetters != nil {
+		if n := d.deadLetters.Len(); n > 0 {
+			slog.Warn(fmt.Sprintf("The post-part command failed for %d chunks, listed by rapel status %s", n, d.args.FilenamePrefix),
+				"failed", n, "file", DeadLetterPath(d.args.FilenamePrefix))
+		}
+	}
+	// A re-sliced layout is the only record of each chunk's size, which
+	// merge checks the chunks against; merge --delete removes it
+	if len(d.args.Layout) > 0 {
+		fsutil.RemoveBackups(d.args.filePath)
+	} else if err := d.args.Delete(); err != nil {
 		return fmt.Errorf("failed to delete args file: %w", err)
 	}
+	if err := d.forgetProgress(); err != nil {
+		return fmt.Errorf("failed to delete progress file: %w", err)
+	}
+
+	if d.pipeState != nil {
+		if err := d.pipeState.Delete(); err != nil {
+			return fmt.Errorf("failed to delete pipe state: %w", err)
+		}
+	}
 
 	return nil
 }
 
-// downloadAllChunks downloads all chunks using a worker pool
+// sizeFile returns the file's prefix and size: Config.TotalSize, or what
+// a HEAD request reports. fromHeader is set when the prefix comes from the
+// response's Content-Disposition.
+func (d *Downloader) sizeFile(ctx context.Context) (prefix string, size int64, fromHeader bool, err error) {
+	prefix = DefaultPrefix(d.config.URL)
+	if d.config.Prefix != "" {
+		prefix = d.config.Prefix
+	}
+	if d.config.TotalSize > 0 {
+		return prefix, d.config.TotalSize, false, nil
+	}
+
+	if d.config.URLCmd != "" {
+		size, err := d.sizeSigned(ctx)
+		return prefix, size, false, err
+	}
+
+	var remote httpclient.RemoteFile
+	err = d.preflight(ctx, func(ctx context.Context) error {
+		start := time.Now()
+		remote, err = d.client.Head(ctx, d.config.URL)
+		d.headRTT = time.Since(start)
+		return err
+	})
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to get content length: %w", err)
+	}
+	d.acceptRanges = remote.AcceptRanges
+	d.remoteMD5 = remote.MD5
+	d.remoteDigests = remote.Digests
+	if d.config.ContentDisposition && remote.Filename != "" {
+		prefix = remote.Filename
+		fromHeader = prefix != DefaultPrefix(d.config.URL)
+	}
+	return prefix, remote.Size, fromHeader, nil
+}
+
+// downloadAllChunks downloads all chunks, running at most d.jobs chunks at once
 func (d *Downloader) downloadAllChunks(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	workChan := make(chan int)
 	errChan := make(chan error, 1)
 
 	if d.config.HasPostPartCmd() {
+		d.stopDownload = cancel
 		d.postPartCh = make(chan int, d.args.NumChunks())
-		d.startPostPartWorkers()
+		d.startPostPartWorkers(ctx)
 	}
 
 	var wg sync.WaitGroup
-	for i := 0; i < d.config.MaxConcurrency; i++ {
+
+	// Dispatch chunks in --order: skip complete ones, start incomplete ones
+	// as slots free up. A chunk coalesced into an earlier group is skipped.
+	dispatched := make([]bool, d.args.NumChunks())
+	for _, i := range d.dispatchOrder() {
+		if !d.selected(i) || dispatched[i] {
+			continue
+		}
+		if d.progress.IsChunkComplete(i) {
+			d.recordManifestOnce(i)
+			// Already done — enqueue post-part (at-least-once on resume)
+			if d.config.HasPostPartCmd() {
+				select {
+				case d.postPartCh <- i:
+				case <-ctx.Done():
+				}
+			}
+			d.maybePack(i)
+			continue
+		}
+
+		group := d.coalesceGroup(i)
+		for k, j := range group {
+			if dispatched[j] {
+				group = group[:k]
+				break
+			}
+			dispatched[j] = true
+		}
+
+		if err := d.jobs.acquire(ctx); err != nil {
+			break
+		}
+
 		wg.Add(1)
-		go func() {
+		go func(group []int) {
 			defer wg.Done()
-			for index := range workChan {
-				if err := d.downloadChunk(ctx, index); err != nil {
-					select {
-					case errChan <- fmt.Errorf("chunk %d: %w", index, err):
-						cancel()
-					default:
-					}
-					return
-				}
+			defer d.jobs.release()
 
-				d.progress.MarkComplete(index)
-				d.progress.PrintChunkComplete(index)
+			fetch := d.downloadChunk
+			if d.config.HasPipePartCmd() {
+				fetch = d.pipeChunk
+			}
 
-				if d.config.HasPostPartCmd() {
-					select {
-					case d.postPartCh <- index:
-					case <-ctx.Done():
-						return
-					}
+			for _, index := range group {
+				start, end := d.args.ChunkRange(index)
+				d.config.Events.Emit(events.ChunkStart, "chunk", index, "start", start, "end", end)
+			}
+
+			// Whatever the coalesced request didn't finish is fetched chunk by chunk
+			if len(group) > 1 {
+				if err := d.fetchGroup(ctx, group); err != nil && ctx.Err() == nil {
+					d.progress.PrintMessage("chunks %d-%d: %v, fetching separately", group[0], group[len(group)-1], err)
 				}
 			}
-		}()
-	}
 
-	// Dispatch chunks: skip complete ones, send incomplete to workers
-	go func() {
-		defer close(workChan)
-		for i := 0; i < d.args.NumChunks(); i++ {
-			if d.progress.IsChunkComplete(i) {
-				// Already done — enqueue post-part (at-least-once on resume)
-				if d.config.HasPostPartCmd() {
+			for _, index := range group {
+				if err := fetch(ctx, index); err != nil {
+					if ctx.Err() == nil {
+						d.config.Events.Emit(events.ChunkFailed, "chunk", index, "error", err)
+					}
 					select {
-					case d.postPartCh <- i:
-					case <-ctx.Done():
-						return
+					case errChan <- fmt.Errorf("chunk %d: %w", index, err):
+						cancel(nil)
+					default:
 					}
+					return
 				}
-				continue
-			}
 
-			select {
-			case workChan <- i:
-			case <-ctx.Done():
-				return
+				d.finishChunk(ctx, index)
 			}
+		}(group)
+
+		// The next request waits until this one is done, whatever the jobs
+		if d.config.Sequential {
+			wg.Wait()
 		}
-	}()
+	}
+
+	// Every chunk is dispatched: speed up the stragglers
+	endgameDone := make(chan struct{})
+	if !d.config.NoEndgame && !d.config.HasPipePartCmd() {
+		go d.runEndgame(ctx, endgameDone)
+	}
 
 	wg.Wait()
+	close(endgameDone)
 	close(errChan)
 
 	if d.config.HasPostPartCmd() {
@@ -213,63 +796,127 @@ func (d *Downloader) downloadAllChunks(ctx context.Context) error {
 		d.postPartWg.Wait()
 	}
 
+	// Chunks interrupted by the post-part limit failed because of it
+	if err := context.Cause(ctx); errors.Is(err, errPostPartLimit) {
+		return err
+	}
 	if err := <-errChan; err != nil {
 		return err
 	}
 
-	return nil
+	return ctx.Err()
+}
+
+// finishChunk records a downloaded chunk and hands it to --post-part and
+// --pack-parts.
+func (d *Downloader) finishChunk(ctx context.Context, index int) {
+	d.progress.MarkComplete(index)
+	d.progress.PrintChunkComplete(index)
+	d.config.Events.Emit(events.ChunkComplete, "chunk", index, "bytes", d.args.ChunkSizeAt(index))
+	d.recordManifest(index)
+
+	if d.config.HasPostPartCmd() {
+		select {
+		case d.postPartCh <- index:
+		case <-ctx.Done():
+		}
+	}
+	d.maybePack(index)
 }
 
 // downloadChunk downloads a single chunk with resume support and retry logic
 func (d *Downloader) downloadChunk(ctx context.Context, index int) error {
 	start, end := d.args.ChunkRange(index)
-	tmpPath := d.args.TmpPath(index)
-	partPath := d.args.PartPath(index)
+
+	d.progress.SetActive(index, true)
+	defer d.progress.SetActive(index, false)
+
+	// Stale tails from an interrupted endgame cover an unknown range
+	run := newChunkRun(start, end, d.args.TmpPath(index)+".tail")
+	os.Remove(run.tailPath)
+	if !d.config.NoEndgame {
+		d.runs.Store(index, run)
+		defer d.runs.Delete(index)
+	}
 
 	var lastErr error
 	maxRetries := d.config.HTTPConfig.MaxRetries
+	var reached int64 // chunk size after the last failed attempt
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			backoffSecs := min(pow2(attempt), 60.0)
-			backoff := time.Duration(backoffSecs * float64(time.Second))
-
+		if attempt > 0 && errors.Is(lastErr, errBanCooldown) {
+			// Waiting out a ban isn't this chunk's failure
+			attempt--
+		} else if attempt > 0 {
+			if err := d.recheckSize(ctx, index, lastErr); err != nil {
+				d.progress.PrintError(index, lastErr)
+				return err
+			}
+			if err := d.checkRetry(lastErr); err != nil {
+				d.progress.PrintError(index, lastErr)
+				return err
+			}
+			if err := d.retries.spend(index, lastErr); err != nil {
+				return err
+			}
+			d.progress.AddRetry(index)
+			d.config.Events.Emit(events.ChunkRetry, "chunk", index, "attempt", attempt, "error", lastErr)
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(backoff):
+			case <-time.After(d.config.Backoff.Delay(attempt)):
 			}
 		}
 
-		chunkFile, currentSize, err := OpenChunkFile(tmpPath, partPath)
+		chunkFile, currentSize, err := d.openChunk(index)
 		if err != nil {
-			if err.Error() == "chunk already complete" {
+			if errors.Is(err, storage.ErrChunkComplete) {
 				return nil
 			}
 			return err
 		}
 
+		// Storage that can't resume starts the chunk over
+		if reached > currentSize {
+			d.discarded.Add(reached - currentSize)
+		}
+
 		// Seed the progress display from the resume offset
 		if currentSize > 0 {
-			d.progress.SeedChunk(index, currentSize)
+			d.progress.SeedChunk(index, currentSize+run.tailBytes.Load())
 		}
 
 		resumeStart := start + currentSize
 		if resumeStart > end {
 			resumeStart = end + 1
 		}
+		requestEnd := run.resume(resumeStart)
 
-		if resumeStart <= end {
+		digest := d.digestFor(index, currentSize)
+		if resumeStart <= requestEnd {
 			progressWriter := &progressWriter{
-				writer:   chunkFile,
-				tracker:  d.progress,
-				chunkIdx: index,
+				ctx:         ctx,
+				writer:      chunkFile,
+				tracker:     d.progress,
+				limiter:     d.limiter,
+				diskLimiter: d.diskLimiter,
+				run:         run,
+				disk:        d.disk,
+				digest:      digest,
+				chunkIdx:    index,
 			}
 
-			err = d.client.DownloadRange(ctx, d.args.URL, resumeStart, end, progressWriter)
-			if err != nil {
+			err = d.fetchRange(ctx, index, resumeStart, requestEnd, progressWriter)
+			if err != nil && !errors.Is(err, errRangeShrunk) {
 				chunkFile.Close()
 				lastErr = err
+				reached = currentSize + progressWriter.written
+				if errors.Is(err, failure.ErrChecksum) {
+					// The bytes on disk can't be trusted, whichever were bad
+					d.progress.PrintMessage("chunk %d: %v, starting it over", index, err)
+					d.restartChunk(index)
+					reached = 0
+				}
 
 				if ctx.Err() != nil {
 					return ctx.Err()
@@ -279,7 +926,31 @@ func (d *Downloader) downloadChunk(ctx context.Context, index int) error {
 			}
 		}
 
-		if err := chunkFile.Finalize(); err != nil {
+		// An endgame helper owns the rest of the range: wait for it and append
+		if run.isSplit() {
+			if err := <-run.tail; err != nil {
+				// Take the tail back and fetch it ourselves
+				run.unsplit()
+				d.discardFile(run.tailPath)
+				chunkFile.Close()
+				lastErr = err
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				continue
+			}
+
+			var w io.Writer = chunkFile
+			if digest != nil {
+				w = io.MultiWriter(chunkFile, digest)
+			}
+			if err := appendTail(w, run.tailPath); err != nil {
+				chunkFile.Close()
+				return err
+			}
+		}
+
+		if err := d.storage.FinalizeChunk(ctx, index, chunkFile); err != nil {
 			return fmt.Errorf("failed to finalize chunk: %w", err)
 		}
 
@@ -290,63 +961,102 @@ func (d *Downloader) downloadChunk(ctx context.Context, index int) error {
 	return fmt.Errorf("download failed after %d retries: %w", maxRetries, lastErr)
 }
 
-// pow2 returns 2^n as a float64
-func pow2(n int) float64 {
-	result := 1.0
-	for i := 0; i < n; i++ {
-		result *= 2.0
-	}
-	return result
-}
-
-// min returns the minimum of two float64 values
-func min(a, b float64) float64 {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // GetArguments returns the current download arguments.
 func (d *Downloader) GetArguments() *DownloadArguments {
 	return d.args
 }
 
-// extractFilenameFromURL extracts a filename from a URL
-func extractFilenameFromURL(url string) string {
-	base := url
-	for _, sep := range []string{"?", "#"} {
-		if idx := len(base) - 1; idx >= 0 {
-			for i := len(base) - 1; i >= 0; i-- {
-				if base[i] == sep[0] {
-					base = base[:i]
-					break
-				}
-			}
+// DefaultPrefix returns the chunk filename prefix used for url: the
+// last segment of its path, or its host when that leaves nothing usable.
+// Two URLs may still resolve to the same name (a_b from a?b and a*b); the
+// prefix collision check tells their downloads apart.
+func DefaultPrefix(url string) string {
+	if prefix := extractFilenameFromURL(url); prefix != "" {
+		return prefix
+	}
+	return "download"
+}
+
+// extractFilenameFromURL returns the last segment of rawURL's path,
+// percent-decoded and made safe to create on any OS, falling back to the
+// host. It returns "" if neither gives a usable name.
+func extractFilenameFromURL(rawURL string) string {
+	var escaped, host string
+	if u, err := neturl.Parse(rawURL); err == nil {
+		escaped, host = u.EscapedPath(), u.Hostname()
+	} else {
+		// Not a URL we can parse: drop the query and fragment by hand
+		escaped = rawURL
+		if i := strings.IndexAny(escaped, "?#"); i >= 0 {
+			escaped = escaped[:i]
 		}
 	}
 
-	return filepath.Base(base)
+	// %2F inside a segment decodes to a slash, which SanitizeFilename
+	// drops along with what comes before it
+	escaped = strings.TrimRight(escaped, "/")
+	name := escaped[strings.LastIndex(escaped, "/")+1:]
+	if decoded, err := neturl.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	if name = httpclient.SanitizeFilename(name); name != "" {
+		return name
+	}
+	return httpclient.SanitizeFilename(host)
 }
 
-// progressWriter wraps a writer to track progress
+// progressWriter wraps a writer to track progress and apply the rate limit
 type progressWriter struct {
-	writer   *ChunkFile
-	tracker  *ProgressTracker
-	chunkIdx int
+	ctx         context.Context
+	writer      io.Writer
+	tracker     *ProgressTracker
+	limiter     *RateLimiter
+	diskLimiter *RateLimiter // optional: paces the writes to disk
+	run         *chunkRun    // optional: bounds writes when an endgame helper took the tail
+	disk        *diskMonitor // optional: times the writes
+	digest      *chunkDigest // optional: hashes what is written
+	chunkIdx    int
+	written     int64
 }
 
 func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	if err := pw.limiter.WaitN(pw.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	if pw.diskLimiter != nil {
+		if err := pw.diskLimiter.WaitN(pw.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+
+	var shrunk bool
+	if pw.run != nil {
+		if allowed := pw.run.reserve(len(p)); allowed < len(p) {
+			p = p[:allowed]
+			shrunk = true
+		}
+	}
+
+	started := time.Now()
 	n, err = pw.writer.Write(p)
+	pw.disk.observe(time.Since(started))
 	if n > 0 {
+		if pw.digest != nil {
+			pw.digest.Write(p[:n])
+		}
+		pw.written += int64(n)
 		pw.tracker.AddBytes(pw.chunkIdx, int64(n))
 		pw.tracker.PrintProgress(pw.chunkIdx)
 	}
+	if err == nil && shrunk {
+		err = errRangeShrunk
+	}
 	return
 }
 
-// startPostPartWorkers launches worker pool for post-part commands
-func (d *Downloader) startPostPartWorkers() {
+// startPostPartWorkers launches worker pool for post-part commands. Once
+// ctx is done, retries stop waiting out their backoff.
+func (d *Downloader) startPostPartWorkers(ctx context.Context) {
 	numWorkers := d.config.PostPartConcurrency
 	if numWorkers == 0 {
 		numWorkers = 10
@@ -354,36 +1064,161 @@ func (d *Downloader) startPostPartWorkers() {
 
 	for i := 0; i < numWorkers; i++ {
 		d.postPartWg.Add(1)
-		go d.postPartWorker()
+		go d.postPartWorker(ctx)
 	}
 }
 
-// postPartWorker processes post-part commands from the channel
-func (d *Downloader) postPartWorker() {
-	defer d.postPartWg.Done()
+// expandPlaceholders substitutes chunk placeholders in a hook command:
+// {part} (part filename), {idx} (chunk index), {base} (filename prefix),
+// {start} and {end} (inclusive byte range).
+func (d *Downloader) expandPlaceholders(cmd string, index int) string {
+	start, end := d.args.ChunkRange(index)
 
-	for index := range d.postPartCh {
-		partPath := d.args.PartPath(index)
+	cmd = strings.ReplaceAll(cmd, "{part}", d.hookPartPath(index))
+	cmd = strings.ReplaceAll(cmd, "{idx}", strconv.Itoa(index))
+	cmd = strings.ReplaceAll(cmd, "{base}", d.args.FilenamePrefix)
+	cmd = strings.ReplaceAll(cmd, "{start}", strconv.FormatInt(start, 10))
+	cmd = strings.ReplaceAll(cmd, "{end}", strconv.FormatInt(end, 10))
+	return cmd
+}
+
+// Default wait between post-part retries: 1s, 2s, 4s, ... up to 30s.
+const (
+	defaultPostPartBackoffBase = 500 * time.Millisecond
+	defaultPostPartBackoffMax  = 30 * time.Second
+)
 
-		cmd := d.config.PostPartCmd
-		cmd = strings.ReplaceAll(cmd, "{part}", partPath)
-		cmd = strings.ReplaceAll(cmd, "{idx}", strconv.Itoa(index))
-		cmd = strings.ReplaceAll(cmd, "{base}", d.args.FilenamePrefix)
+// errPostPartTimeout is a post-part command killed by PostPartTimeout.
+var errPostPartTimeout = errors.New("timed out")
 
-		d.progress.PrintCmdMessage("[post-part chunk %d] Running: %s", index, cmd)
+// errPostPartLimit stops the download once PostPartMaxFailures commands
+// have failed for good.
+var errPostPartLimit = errors.New("too many post-part commands failed")
 
-		execCmd := exec.Command("sh", "-c", cmd)
-		output, err := execCmd.CombinedOutput()
+// postPartWorker processes post-part commands from the channel
+func (d *Downloader) postPartWorker(ctx context.Context) {
+	defer d.postPartWg.Done()
+
+	backoff := d.config.PostPartBackoff
+	if backoff.Base <= 0 {
+		backoff.Base = defaultPostPartBackoffBase
+	}
+	if backoff.Max <= 0 {
+		backoff.Max = defaultPostPartBackoffMax
+	}
 
-		if len(output) > 0 {
-			indented := "  " + strings.ReplaceAll(strings.TrimSpace(string(output)), "\n", "\n  ")
-			d.progress.PrintCmdMessage("[post-part chunk %d] Output:\n%s", index, indented)
+	for index := range d.postPartCh {
+		// The download is stopping: what's queued runs again on resume
+		if d.postPartStop.Load() {
+			continue
+		}
+		d.postPartActive.Add(1)
+		var err error
+		attempt := 0
+		interrupted := false
+		for ; ; attempt++ {
+			if err = d.runPostPart(index); err == nil || attempt >= d.config.PostPartRetries || !d.retryPostPart(err) {
+				break
+			}
+			wait := backoff.Delay(attempt + 1)
+			d.progress.PrintCmdMessage("[post-part chunk %d] Failed: %v; retrying in %s (%d/%d)",
+				index, err, wait, attempt+1, d.config.PostPartRetries)
+			select {
+			case <-ctx.Done():
+				interrupted = true
+			case <-time.After(wait):
+			}
+			if interrupted {
+				break
+			}
 		}
 
-		if err != nil {
+		if interrupted {
+			// Not a failure for good: the command runs again on resume
+			d.progress.PrintCmdMessage("[post-part chunk %d] Stopped before retrying: %v", index, err)
+		} else if err != nil {
 			d.progress.PrintCmdMessage("[post-part chunk %d] Failed: %v", index, err)
+			d.config.Events.Emit(events.PostPartFailed, "chunk", index, "error", err)
+			d.deadLetter(index, err, attempt+1)
 		} else {
 			d.progress.PrintCmdMessage("[post-part chunk %d] Completed", index)
+			d.config.Events.Emit(events.PostPartComplete, "chunk", index)
+			if d.deadLetters != nil {
+				if err := d.deadLetters.Remove(index); err != nil {
+					d.progress.PrintMessage("Warning: %v", err)
+				}
+			}
 		}
+		d.postPartActive.Add(-1)
+	}
+}
+
+// retryPostPart returns whether a failed post-part command is run again:
+// after a timeout always, otherwise when PostPartRetryOn is empty or
+// holds its exit status.
+func (d *Downloader) retryPostPart(err error) bool {
+	if errors.Is(err, errPostPartTimeout) || len(d.config.PostPartRetryOn) == 0 {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && slices.Contains(d.config.PostPartRetryOn, exitErr.ExitCode())
+}
+
+// deadLetter records a chunk whose post-part command failed for good, and
+// stops the download once PostPartMaxFailures have.
+func (d *Downloader) deadLetter(index int, err error, attempts int) {
+	if d.deadLetters != nil {
+		_, display := d.postPartCommand(context.Background(), index)
+		dl := DeadLetter{
+			Chunk:    index,
+			Part:     d.hookPartPath(index),
+			Command:  display,
+			Error:    err.Error(),
+			Attempts: attempts,
+			FailedAt: time.Now().UTC(),
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			dl.ExitCode = exitErr.ExitCode()
+		}
+		if err := d.deadLetters.Add(dl); err != nil {
+			d.progress.PrintMessage("Warning: %v", err)
+		}
+	}
+
+	failures := int(d.postPartFailed.Add(1))
+	if limit := d.config.PostPartMaxFailures; limit > 0 && failures >= limit && d.postPartStop.CompareAndSwap(false, true) {
+		d.progress.PrintMessage("%d post-part commands failed, stopping the download", failures)
+		if d.stopDownload != nil {
+			d.stopDownload(fmt.Errorf("%w: %d of them (--post-part-max-failures %d), see rapel status %s",
+				errPostPartLimit, failures, limit, d.args.FilenamePrefix))
+		}
+	}
+}
+
+// runPostPart runs the post-part command once for a chunk, printing its
+// output.
+func (d *Downloader) runPostPart(index int) error {
+	ctx := context.Background()
+	if d.config.PostPartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.PostPartTimeout)
+		defer cancel()
+	}
+
+	execCmd, display := d.postPartCommand(ctx, index)
+	// Don't wait on a killed command's children still holding the output
+	execCmd.WaitDelay = time.Second
+
+	d.progress.PrintCmdMessage("[post-part chunk %d] Running: %s", index, display)
+	output, err := execCmd.CombinedOutput()
+
+	if len(output) > 0 {
+		indented := "  " + strings.ReplaceAll(strings.TrimSpace(string(output)), "\n", "\n  ")
+		d.progress.PrintCmdMessage("[post-part chunk %d] Output:\n%s", index, indented)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w after %s", errPostPartTimeout, d.config.PostPartTimeout)
 	}
+	return err
 }
@@ -0,0 +1,269 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// endgameThreshold is the completed fraction after which stragglers are split.
+	endgameThreshold = 0.95
+	// endgameMinSplit is the smallest remaining range worth splitting.
+	endgameMinSplit = 1_000_000
+	// endgameInterval is how often the endgame monitor looks for stragglers.
+	endgameInterval = 500 * time.Millisecond
+)
+
+// errRangeShrunk stops a primary worker whose range tail was handed to a helper.
+var errRangeShrunk = errors.New("range shrunk by endgame split")
+
+// chunkRun coordinates a chunk's primary worker with an endgame helper that
+// takes over the second half of its remaining range. The primary reserves
+// bytes under mu before writing them, so a split can never land behind data
+// the primary has already committed to write.
+type chunkRun struct {
+	mu    sync.Mutex
+	pos   int64 // next absolute offset the primary will write
+	limit int64 // primary writes stop before this offset (exclusive)
+	end   int64 // inclusive end of the whole chunk
+
+	tailPath  string
+	tail      chan error // non-nil once split; receives the helper's result
+	tailBytes atomic.Int64
+}
+
+// newChunkRun creates a run for a chunk whose primary resumes at pos.
+func newChunkRun(pos, end int64, tailPath string) *chunkRun {
+	return &chunkRun{pos: pos, limit: end + 1, end: end, tailPath: tailPath}
+}
+
+// resume resets the primary's position at the start of an attempt and
+// returns the inclusive end it should request.
+func (r *chunkRun) resume(pos int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pos = pos
+	return r.limit - 1
+}
+
+// reserve claims up to n bytes for the primary and returns how many it may write.
+func (r *chunkRun) reserve(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	allowed := r.limit - r.pos
+	if allowed < int64(n) {
+		n = int(max(allowed, 0))
+	}
+	r.pos += int64(n)
+	return n
+}
+
+// split hands the upper half of the unreserved range to a helper and returns
+// the helper's inclusive range, or ok=false if the run is already split or
+// too small to be worth it.
+func (r *chunkRun) split() (start, end int64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := r.limit - r.pos
+	if r.tail != nil || remaining < endgameMinSplit {
+		return 0, 0, false
+	}
+
+	mid := r.pos + remaining/2
+	r.limit = mid
+	r.tail = make(chan error, 1)
+	return mid, r.end, true
+}
+
+// isSplit reports whether a helper owns the tail of this run.
+func (r *chunkRun) isSplit() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tail != nil
+}
+
+// unsplit returns the tail to the primary after the helper failed.
+func (r *chunkRun) unsplit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tail = nil
+	r.limit = r.end + 1
+	r.tailBytes.Store(0)
+}
+
+// remaining returns the bytes the primary has yet to reserve.
+func (r *chunkRun) remaining() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limit - r.pos
+}
+
+// runEndgame watches for the final stretch of the download and splits the
+// slowest in-flight chunks so the tail doesn't wait on a single connection.
+// Helpers run outside the jobs gate, so concurrency rises by up to the
+// current --jobs value for the endgame.
+func (d *Downloader) runEndgame(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(endgameInterval)
+	defer ticker.Stop()
+
+	helpers := 0
+	announced := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		total := d.progress.TotalSize()
+		if total == 0 || float64(d.progress.DownloadedBytes()) < endgameThreshold*float64(total) {
+			continue
+		}
+
+		if helpers >= d.Concurrency() {
+			continue
+		}
+
+		// Split the straggler with the most bytes left
+		var target *chunkRun
+		targetIdx := -1
+		d.runs.Range(func(key, value any) bool {
+			run := value.(*chunkRun)
+			if run.isSplit() {
+				return true
+			}
+			if target == nil || run.remaining() > target.remaining() {
+				target, targetIdx = run, key.(int)
+			}
+			return true
+		})
+		if target == nil {
+			continue
+		}
+
+		start, end, ok := target.split()
+		if !ok {
+			continue
+		}
+
+		if !announced {
+			d.progress.PrintMessage("Endgame: splitting remaining chunks across extra connections")
+			announced = true
+		}
+
+		helpers++
+		go func(index int, run *chunkRun) {
+			run.tail <- d.downloadTail(ctx, index, run, start, end)
+		}(targetIdx, target)
+	}
+}
+
+// downloadTail fetches [start, end] of a split chunk into the run's tail file.
+func (d *Downloader) downloadTail(ctx context.Context, index int, run *chunkRun, start, end int64) error {
+	// A tail left by an earlier split covers a different range
+	os.Remove(run.tailPath)
+
+	var lastErr error
+	maxRetries := d.config.HTTPConfig.MaxRetries
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 && errors.Is(lastErr, errBanCooldown) {
+			// Waiting out a ban isn't this chunk's failure
+			attempt--
+		} else if attempt > 0 {
+			if err := d.checkRetry(lastErr); err != nil {
+				return err
+			}
+			if err := d.retries.spend(index, lastErr); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d.config.Backoff.Delay(attempt)):
+			}
+		}
+
+		f, err := os.OpenFile(run.tailPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open tail file: %w", err)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to stat tail file: %w", err)
+		}
+
+		resumeStart := start + info.Size()
+		if resumeStart > end {
+			return f.Close()
+		}
+
+		w := &tailWriter{ctx: ctx, file: f, run: run, tracker: d.progress, limiter: d.limiter, diskLimiter: d.diskLimiter, chunkIdx: index}
+		lastErr = d.fetchRange(ctx, index, resumeStart, end, w)
+		closeErr := f.Close()
+
+		if lastErr == nil {
+			return closeErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return fmt.Errorf("endgame helper failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// appendTail copies a finished tail file onto the chunk and removes it.
+func appendTail(chunkFile io.Writer, tailPath string) error {
+	tail, err := os.Open(tailPath)
+	if err != nil {
+		return fmt.Errorf("failed to open tail file: %w", err)
+	}
+	defer tail.Close()
+
+	if _, err := io.Copy(chunkFile, tail); err != nil {
+		return fmt.Errorf("failed to append tail file: %w", err)
+	}
+
+	return os.Remove(tailPath)
+}
+
+// tailWriter writes helper bytes, counting them toward the chunk's progress.
+type tailWriter struct {
+	ctx         context.Context
+	file        *os.File
+	run         *chunkRun
+	tracker     *ProgressTracker
+	limiter     *RateLimiter
+	diskLimiter *RateLimiter
+	chunkIdx    int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	if err := w.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	if err := w.diskLimiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+
+	n, err := w.file.Write(p)
+	if n > 0 {
+		w.run.tailBytes.Add(int64(n))
+		w.tracker.AddBytes(w.chunkIdx, int64(n))
+	}
+	return n, err
+}
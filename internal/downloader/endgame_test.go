@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkRunSplit(t *testing.T) {
+	run := newChunkRun(0, 9_999_999, "tail")
+	assert.Equal(t, int64(9_999_999), run.resume(0))
+
+	// Primary has reserved 2MB; the remaining 8MB is split in half
+	assert.Equal(t, 2_000_000, run.reserve(2_000_000))
+
+	start, end, ok := run.split()
+	assert.True(t, ok)
+	assert.Equal(t, int64(6_000_000), start)
+	assert.Equal(t, int64(9_999_999), end)
+	assert.True(t, run.isSplit())
+
+	// Cannot split twice
+	_, _, ok = run.split()
+	assert.False(t, ok)
+
+	// Primary may only write up to the split point
+	assert.Equal(t, 3_999_999, run.reserve(3_999_999))
+	assert.Equal(t, 1, run.reserve(10))
+	assert.Equal(t, 0, run.reserve(10))
+
+	// After a failed helper the primary owns the whole range again
+	run.unsplit()
+	assert.False(t, run.isSplit())
+	assert.Equal(t, int64(4_000_000), run.remaining())
+}
+
+func TestChunkRunSplitTooSmall(t *testing.T) {
+	run := newChunkRun(0, endgameMinSplit-2, "tail")
+	_, _, ok := run.split()
+	assert.False(t, ok)
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Existing-file policies: what to do when the output file is already there,
+// or saved state that isn't this download's. A matching unfinished download
+// is resumed under every policy but overwrite.
+const (
+	ExistsResume    = "resume"    // download again over the output file; refuse another download's state, as without a policy
+	ExistsSkip      = "skip"      // leave what is there alone and download nothing
+	ExistsOverwrite = "overwrite" // start over, replacing both, as --force does
+	ExistsRename    = "rename"    // download as "file (1).bin", the first name that is free
+	ExistsAsk       = "ask"       // ask on the terminal which of the above to do
+)
+
+// ExistsPolicies lists the valid values of Config.IfExists.
+var ExistsPolicies = []string{ExistsResume, ExistsSkip, ExistsOverwrite, ExistsRename, ExistsAsk}
+
+// ErrSkipped is returned by Download when Config.IfExists left the
+// existing file alone.
+var ErrSkipped = errors.New("skipped, the file already exists")
+
+// maxRenames bounds the "file (n).bin" names tried.
+const maxRenames = 1000
+
+// resolveExisting returns the prefix to download under according to
+// Config.IfExists, or ErrSkipped. interactive is false for a dry run,
+// which must not prompt: ask then reports what is there, as resume does.
+func (d *Downloader) resolveExisting(prefix string, totalSize int64, interactive bool) (string, error) {
+	policy := d.config.IfExists
+	if policy == ExistsOverwrite {
+		d.config.Force = true
+	}
+	if policy == "" || policy == ExistsResume || d.config.Force {
+		return prefix, nil
+	}
+
+	what := d.existing(prefix, totalSize)
+	if what == "" {
+		return prefix, nil
+	}
+
+	if policy == ExistsAsk {
+		if !interactive {
+			return prefix, nil
+		}
+		var err error
+		if policy, err = askExisting(what); err != nil {
+			return "", err
+		}
+	}
+
+	switch policy {
+	case ExistsSkip:
+		slog.Info(fmt.Sprintf("%s, skipping", what), "file", prefix)
+		return "", ErrSkipped
+	case ExistsOverwrite:
+		slog.Warn(fmt.Sprintf("%s, replacing it", what), "file", prefix)
+		d.config.Force = true
+		return prefix, nil
+	case ExistsRename:
+		for n := 1; n <= maxRenames; n++ {
+			p := numberedPrefix(prefix, n)
+			if args, _ := LoadDownloadArguments(p); args != nil && args.Matches(d.config.URL) && args.TotalSize == totalSize {
+				// Renamed on an earlier run: resume it
+				return p, nil
+			}
+			if d.existing(p, totalSize) == "" && d.prefixOwner(p) == "" {
+				slog.Info(fmt.Sprintf("%s, downloading as %s", what, p), "file", p)
+				return p, nil
+			}
+		}
+		return "", fmt.Errorf("%s, and so does every name from %s to %s", what, numberedPrefix(prefix, 1), numberedPrefix(prefix, maxRenames))
+	}
+	return prefix, nil
+}
+
+// existing describes what stands in the way of downloading the file under
+// prefix: the output file, or saved state for another URL or size. It
+// returns "" if nothing does, including when the saved state is this
+// download's, to be resumed.
+func (d *Downloader) existing(prefix string, totalSize int64) string {
+	args, err := LoadDownloadArguments(prefix)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s has unreadable saved state", prefix)
+	case args == nil:
+	case !args.Matches(d.config.URL):
+		return fmt.Sprintf("%s has an unfinished download of another URL", prefix)
+	case args.TotalSize == totalSize, d.config.Growing > 0 && args.TotalSize < totalSize:
+		return ""
+	default:
+		return fmt.Sprintf("%s has an unfinished download of %d bytes, not %d", prefix, args.TotalSize, totalSize)
+	}
+
+	output := d.outputPath(prefix)
+	if info, err := os.Stat(output); err == nil && !info.IsDir() {
+		return output + " already exists"
+	}
+	return ""
+}
+
+// numberedPrefix numbers prefix before its extensions, the way file
+// managers name copies: latest.tar.gz becomes "latest (1).tar.gz".
+func numberedPrefix(prefix string, n int) string {
+	tag := fmt.Sprintf(" (%d)", n)
+	if i := strings.Index(prefix, "."); i > 0 {
+		return prefix[:i] + tag + prefix[i:]
+	}
+	return prefix + tag
+}
+
+// askExisting asks on the terminal what to do about what, and returns the
+// policy chosen.
+func askExisting(what string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("%s, and --if-exists ask needs a terminal to ask on", what)
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "%s: [s]kip, [o]verwrite, [r]ename or [R]esume? ", what)
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("%s, and no answer was given", what)
+		}
+		switch strings.TrimSpace(line) {
+		case "s", "skip":
+			return ExistsSkip, nil
+		case "o", "overwrite":
+			return ExistsOverwrite, nil
+		case "r", "rename":
+			return ExistsRename, nil
+		case "R", "resume":
+			return ExistsResume, nil
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberedPrefix(t *testing.T) {
+	assert.Equal(t, "latest (1).tar.gz", numberedPrefix("latest.tar.gz", 1))
+	assert.Equal(t, "latest (12)", numberedPrefix("latest", 12))
+}
+
+func TestIfExistsPolicies(t *testing.T) {
+	body := []byte(strings.Repeat("x", 250))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	download := func(policy string) (*Downloader, error) {
+		d, err := NewDownloader(Config{
+			URL:        srv.URL + "/f.bin",
+			ChunkSize:  100,
+			IfExists:   policy,
+			HTTPConfig: httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d, d.Download(context.Background())
+	}
+	// Chunks and state carry the URL's hash, the merged file doesn't
+	tag := urlTag(srv.URL + "/f.bin")
+	// A merged f.bin from an earlier run
+	setup := func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, os.WriteFile("f.bin", []byte("old"), 0o644))
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		setup(t)
+		d, err := download(ExistsResume)
+		require.NoError(t, err)
+		assert.Equal(t, "f-"+tag+".bin", d.GetArguments().FilenamePrefix)
+		assert.Equal(t, "f.bin", d.GetArguments().OutputName)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		setup(t)
+		_, err := download(ExistsSkip)
+		assert.ErrorIs(t, err, ErrSkipped)
+		assert.NoFileExists(t, "f-"+tag+".bin.000000.part")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		setup(t)
+		require.NoError(t, os.WriteFile("f (1).bin", []byte("old"), 0o644))
+		d, err := download(ExistsRename)
+		require.NoError(t, err)
+		assert.Equal(t, "f-"+tag+" (2).bin", d.GetArguments().FilenamePrefix)
+		assert.Equal(t, "f (2).bin", d.GetArguments().OutputName)
+		assert.FileExists(t, "f-"+tag+" (2).bin.000002.part")
+	})
+
+	t.Run("rename resumes", func(t *testing.T) {
+		setup(t)
+		require.NoError(t, NewDownloadArguments(srv.URL+"/f.bin", 250, 100, "f-"+tag+" (1).bin").Save())
+		d, err := download(ExistsRename)
+		require.NoError(t, err)
+		assert.Equal(t, "f-"+tag+" (1).bin", d.GetArguments().FilenamePrefix)
+	})
+
+	t.Run("state of another download", func(t *testing.T) {
+		setup(t)
+		require.NoError(t, os.Remove("f.bin"))
+		// Saved under the plain name, as before the hash was put in
+		require.NoError(t, NewDownloadArguments(srv.URL+"/f.bin", 999, 100, "f.bin").Save())
+
+		_, err := download(ExistsResume)
+		assert.ErrorContains(t, err, "don't match")
+		_, err = download(ExistsSkip)
+		assert.ErrorIs(t, err, ErrSkipped)
+		d, err := download(ExistsOverwrite)
+		require.NoError(t, err)
+		assert.Equal(t, int64(250), d.GetArguments().TotalSize)
+	})
+
+	t.Run("ask needs a terminal", func(t *testing.T) {
+		setup(t)
+		stdin := os.Stdin
+		t.Cleanup(func() { os.Stdin = stdin })
+		f, err := os.CreateTemp(t.TempDir(), "stdin")
+		require.NoError(t, err)
+		defer f.Close()
+		os.Stdin = f
+		_, err = download(ExistsAsk)
+		assert.ErrorContains(t, err, "needs a terminal")
+	})
+}
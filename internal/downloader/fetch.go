@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fetchOutputTail is how much of a failed fetch command's stderr is kept
+// for the error message.
+const fetchOutputTail = 512
+
+// fetchCommand transfers [start, end] by running Config.FetchCmd and copying
+// its stdout to w. The command must write exactly the requested bytes;
+// a short write followed by a clean exit is an error, but the bytes already
+// written are kept so the retry resumes after them.
+func (d *Downloader) fetchCommand(ctx context.Context, index int, start, end int64, w io.Writer) error {
+	cmdStr := d.expandFetchPlaceholders(index, start, end)
+
+	// Same environment rules as hooks, but never network-isolated: fetching
+	// is the command's whole job
+	cmd := d.config.Hooks.command(ctx, cmdStr)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open fetch command output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start fetch command: %w", err)
+	}
+
+	expected := end - start + 1
+	n, copyErr := io.Copy(w, io.LimitReader(stdout, expected))
+	d.fetched.Add(n)
+	if copyErr != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("write failed: %w", copyErr)
+	}
+
+	if n == expected {
+		// Anything beyond the range means the command ignored {start}/{end}
+		var extra [1]byte
+		if m, _ := stdout.Read(extra[:]); m > 0 {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return fmt.Errorf("fetch command wrote more than the %d bytes requested", expected)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if out := tail(strings.TrimSpace(stderr.String()), fetchOutputTail); out != "" {
+			return fmt.Errorf("fetch command failed: %w: %s", err, out)
+		}
+		return fmt.Errorf("fetch command failed: %w", err)
+	}
+
+	if n < expected {
+		return fmt.Errorf("fetch command wrote %d of %d bytes", n, expected)
+	}
+
+	return nil
+}
+
+// expandFetchPlaceholders fills in --fetch-cmd placeholders. {start} and
+// {end} are the byte range to fetch now, which differs from the chunk's
+// range when resuming. {url} is quoted for the shell since URLs contain & and ?.
+func (d *Downloader) expandFetchPlaceholders(index int, start, end int64) string {
+	cmd := d.config.FetchCmd
+	cmd = strings.ReplaceAll(cmd, "{url}", d.config.Hooks.quote(d.config.URL))
+	cmd = strings.ReplaceAll(cmd, "{start}", strconv.FormatInt(start, 10))
+	cmd = strings.ReplaceAll(cmd, "{end}", strconv.FormatInt(end, 10))
+	cmd = strings.ReplaceAll(cmd, "{idx}", strconv.Itoa(index))
+	cmd = strings.ReplaceAll(cmd, "{base}", d.args.FilenamePrefix)
+	return cmd
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// tail returns the last n bytes of s.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'https://x/a?b=1&c=2'`, shellQuote("https://x/a?b=1&c=2"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
+
+func TestFetchCommand(t *testing.T) {
+	newDownloader := func(cmd string) *Downloader {
+		return &Downloader{
+			config: Config{URL: "https://example.com/f?a=1&b=2", FetchCmd: cmd},
+			args:   NewDownloadArguments("https://example.com/f", 100, 10, "f"),
+		}
+	}
+
+	var out bytes.Buffer
+	d := newDownloader("printf '%s-%s' {start} {end}")
+	require.NoError(t, d.fetchCommand(context.Background(), 0, 10, 14, &out))
+	assert.Equal(t, "10-14", out.String())
+
+	out.Reset()
+	d = newDownloader("test {url} = 'https://example.com/f?a=1&b=2' && printf ok")
+	require.NoError(t, d.fetchCommand(context.Background(), 0, 0, 1, &out))
+
+	out.Reset()
+	d = newDownloader("printf abc")
+	assert.ErrorContains(t, d.fetchCommand(context.Background(), 0, 0, 9, &out), "wrote 3 of 10 bytes")
+	assert.Equal(t, "abc", out.String())
+
+	out.Reset()
+	d = newDownloader("printf 0123456789")
+	require.NoError(t, d.fetchCommand(context.Background(), 0, 0, 9, &out))
+	assert.Equal(t, "0123456789", out.String())
+
+	out.Reset()
+	d = newDownloader("printf 0123456789abc")
+	assert.ErrorContains(t, d.fetchCommand(context.Background(), 0, 0, 9, &out), "more than")
+
+	d = newDownloader("echo boom >&2; exit 2")
+	assert.ErrorContains(t, d.fetchCommand(context.Background(), 0, 0, 9, &out), "boom")
+}
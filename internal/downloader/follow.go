@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/fsutil"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// followState records where a --follow capture stopped. The output file
+// itself holds the bytes; the state ties it to the URL so a restart
+// appends to the right file.
+type followState struct {
+	URL     string `json:"url"`
+	URLHash string `json:"url_sha256"`
+	Output  string `json:"output"`
+	Offset  int64  `json:"offset"`
+
+	filePath string
+}
+
+// followStatePath returns the state file for following prefix.
+func followStatePath(prefix string) string {
+	return registry.StatePath(prefix, "follow.json")
+}
+
+// loadFollowState loads the state for prefix, or nil if there is none.
+func loadFollowState(prefix string) (*followState, error) {
+	path := followStatePath(prefix)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read follow state: %w", err)
+	}
+	s := &followState{filePath: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse follow state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// save rewrites the state file atomically.
+func (s *followState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal follow state: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write follow state: %w", err)
+	}
+	return nil
+}
+
+// follow appends the file's new bytes to the output as they appear, like
+// tail -f: each poll requests everything past what the output already
+// holds. It runs until interrupted, until --max-time, or until the file
+// has not grown for FollowIdle. The output's size is the resume point, so
+// a restart carries on where the last run stopped.
+func (d *Downloader) follow(ctx context.Context) error {
+	prefix := DefaultPrefix(d.config.URL)
+	lock, err := registry.Acquire(registry.LockPath(prefix))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	output := d.config.FollowOutput
+	if output == "" {
+		output = prefix
+	}
+	if output, err = filepath.Abs(output); err != nil {
+		return err
+	}
+
+	state, offset, err := d.followStart(prefix, output)
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(output, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer f.Close()
+
+	safeURL := redact.URL(d.config.URL)
+	slog.Info("URL        : "+safeURL, "url", safeURL)
+	slog.Info("Output     : "+output, "output", output)
+	if offset > 0 {
+		slog.Info("Resuming   : "+formatBytes(offset)+" already captured", "bytes", offset)
+	}
+	slog.Info(fmt.Sprintf("Following  : polling every %s", d.config.FollowInterval), "interval", d.config.FollowInterval.String())
+
+	w := &followWriter{ctx: ctx, file: f, limiter: d.limiter, diskLimiter: d.diskLimiter}
+	lastGrowth := time.Now()
+	failures := 0
+	for {
+		previous := offset
+		n, size, err := d.client.Tail(ctx, d.config.URL, offset, w)
+		offset += n
+		if n > 0 {
+			state.Offset = offset
+			if err := state.save(); err != nil {
+				return err
+			}
+			slog.Info(fmt.Sprintf("%s: +%s, %s captured", prefix, formatBytes(n), formatBytes(offset)),
+				"bytes", offset, "added", n)
+			d.config.Events.Emit(events.Grew, "size", offset, "previous", previous)
+			lastGrowth = time.Now()
+			failures = 0
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			if errors.Is(context.Cause(ctx), errMaxTime) {
+				slog.Info(fmt.Sprintf("Stopped following after --max-time, %s captured", formatBytes(offset)), "bytes", offset)
+				return nil
+			}
+			return context.Cause(ctx)
+		case errors.Is(err, httpclient.ErrRangeIgnored):
+			return fmt.Errorf("cannot follow %s: %w", prefix, err)
+		case err != nil:
+			failures++
+			if failures > d.config.HTTPConfig.MaxRetries {
+				return fmt.Errorf("failed after %d retries: %w", d.config.HTTPConfig.MaxRetries, err)
+			}
+			slog.Warn(fmt.Sprintf("%s: %v, retrying (%d/%d)", prefix, err, failures, d.config.HTTPConfig.MaxRetries))
+		case size > 0 && size < offset:
+			return failure.Errorf(failure.ErrSizeMismatch, "%s shrank from %d to %d bytes: it was truncated or replaced, use --force to start over",
+				prefix, offset, size)
+		case n > 0:
+			// More may already be there
+			continue
+		}
+
+		if d.config.FollowIdle > 0 && time.Since(lastGrowth) >= d.config.FollowIdle {
+			slog.Info(fmt.Sprintf("%s has not grown for %s, %s captured", prefix, d.config.FollowIdle, formatBytes(offset)), "bytes", offset)
+			return os.Remove(state.filePath)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(d.config.FollowInterval):
+		}
+	}
+}
+
+// followStart checks the saved state against the output file and returns
+// the state to keep updating and the offset to continue from.
+func (d *Downloader) followStart(prefix, output string) (*followState, int64, error) {
+	fresh := &followState{
+		URL:      redact.URL(d.config.URL),
+		URLHash:  redact.Fingerprint(d.config.URL),
+		Output:   output,
+		filePath: followStatePath(prefix),
+	}
+	if d.config.Force {
+		return fresh, 0, nil
+	}
+
+	state, err := loadFollowState(prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, statErr := os.Stat(output)
+	switch {
+	case state == nil && statErr == nil:
+		return nil, 0, fmt.Errorf("%s already exists, use --force to overwrite it", output)
+	case state == nil:
+		return fresh, 0, nil
+	case state.URLHash != fresh.URLHash:
+		return nil, 0, fmt.Errorf("%s is for another URL, use --force to start over", state.filePath)
+	case state.Output != output:
+		return nil, 0, fmt.Errorf("%s was capturing to %s, use --force to start over", state.filePath, state.Output)
+	case statErr != nil:
+		return nil, 0, fmt.Errorf("%s is gone (%v), use --force to start over", output, statErr)
+	case info.Size() < state.Offset:
+		return nil, 0, fmt.Errorf("%s is shorter than the %d bytes captured, use --force to start over", output, state.Offset)
+	}
+	// Bytes written after the last save are kept: they came in order
+	state.Offset = info.Size()
+	return state, info.Size(), nil
+}
+
+// followWriter appends to the output under the rate and disk limits.
+type followWriter struct {
+	ctx         context.Context
+	file        io.Writer
+	limiter     *RateLimiter
+	diskLimiter *RateLimiter
+}
+
+func (w *followWriter) Write(p []byte) (int, error) {
+	if err := w.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	if err := w.diskLimiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.file.Write(p)
+}
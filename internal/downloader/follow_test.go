@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollow(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	var mu sync.Mutex
+	content := []byte(strings.Repeat("a", 100))
+	appendContent := func(s string) {
+		mu.Lock()
+		content = append(content, s...)
+		mu.Unlock()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		data := bytes.Clone(content)
+		mu.Unlock()
+		http.ServeContent(w, r, "log", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	follow := func(url string, force bool) error {
+		d, err := NewDownloader(Config{
+			URL:            url,
+			Force:          force,
+			Follow:         true,
+			FollowInterval: 10 * time.Millisecond,
+			FollowIdle:     200 * time.Millisecond,
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		appendContent(strings.Repeat("b", 30))
+	}()
+	require.NoError(t, follow(srv.URL+"/log", false))
+	got, err := os.ReadFile("log")
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.NoFileExists(t, followStatePath("log"))
+
+	// Without state, an existing output isn't appended to
+	assert.ErrorContains(t, follow(srv.URL+"/log", false), "--force")
+
+	// An interrupted capture resumes from the output's size
+	require.NoError(t, os.WriteFile("log", content[:60], 0644))
+	output, err := filepath.Abs("log")
+	require.NoError(t, err)
+	state := &followState{URLHash: redact.Fingerprint(srv.URL + "/log"), Output: output, Offset: 50, filePath: followStatePath("log")}
+	require.NoError(t, state.save())
+	appendContent("ccc")
+	require.NoError(t, follow(srv.URL+"/log", false))
+	got, err = os.ReadFile("log")
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	// A file that shrank below the capture can't be continued
+	require.NoError(t, os.WriteFile("log", bytes.Repeat([]byte("x"), 200), 0644))
+	state.Offset = 200
+	require.NoError(t, state.save())
+	assert.ErrorContains(t, follow(srv.URL+"/log", false), "shrank")
+}
+
+func TestFollowStatePublished(t *testing.T) {
+	data, err := json.Marshal(&followState{URL: "https://x/log", URLHash: "ab", Output: "log", Offset: 50})
+	require.NoError(t, err)
+
+	var published schema.Follow
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	require.NoError(t, dec.Decode(&published))
+	assert.Equal(t, schema.Follow{URL: "https://x/log", URLHash: "ab", Output: "log", Offset: 50}, published)
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+// jobGate is a counting semaphore whose capacity can change at runtime.
+// Lowering the limit never interrupts running holders; it only delays new
+// acquisitions until enough slots have been released.
+type jobGate struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	wake   chan struct{} // closed and replaced whenever a slot may be free
+}
+
+// JobPool is a limit on chunks in flight shared by several Downloaders
+// in one process (see Config.Pool).
+type JobPool struct {
+	gate *jobGate
+}
+
+// NewJobPool returns a pool of limit chunk slots.
+func NewJobPool(limit int) *JobPool {
+	return &JobPool{gate: newJobGate(limit)}
+}
+
+// newJobGate creates a gate allowing limit concurrent holders.
+func newJobGate(limit int) *jobGate {
+	if limit < 1 {
+		limit = 1
+	}
+	return &jobGate{
+		limit: limit,
+		wake:  make(chan struct{}),
+	}
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (g *jobGate) acquire(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if g.active < g.limit {
+			g.active++
+			g.mu.Unlock()
+			return nil
+		}
+		wake := g.wake
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// release frees a slot taken by acquire.
+func (g *jobGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	g.broadcast()
+}
+
+// setLimit changes the number of concurrent holders (minimum 1).
+func (g *jobGate) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.limit = limit
+	g.broadcast()
+}
+
+// getLimit returns the current capacity.
+func (g *jobGate) getLimit() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// broadcast wakes all waiters. Caller must hold mu.
+func (g *jobGate) broadcast() {
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobGateLimit(t *testing.T) {
+	g := newJobGate(2)
+	ctx := context.Background()
+
+	assert.NoError(t, g.acquire(ctx))
+	assert.NoError(t, g.acquire(ctx))
+
+	// Third acquire must block until the context expires
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, g.acquire(timeout), context.DeadlineExceeded)
+
+	g.release()
+	assert.NoError(t, g.acquire(ctx))
+}
+
+func TestJobGateSetLimitWakesWaiters(t *testing.T) {
+	g := newJobGate(1)
+	ctx := context.Background()
+	assert.NoError(t, g.acquire(ctx))
+
+	acquired := make(chan struct{})
+	go func() {
+		if g.acquire(ctx) == nil {
+			close(acquired)
+		}
+	}()
+
+	g.setLimit(2)
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after raising limit")
+	}
+	assert.Equal(t, 2, g.getLimit())
+}
+
+func TestJobGateMinimumLimit(t *testing.T) {
+	g := newJobGate(0)
+	assert.Equal(t, 1, g.getLimit())
+
+	g.setLimit(-3)
+	assert.Equal(t, 1, g.getLimit())
+}
+
+func TestJobPoolShared(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("x"), 400)
+	var active, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Chunks only, not the one-byte Range checks
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	pool := NewJobPool(2)
+	var wg sync.WaitGroup
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/" + name,
+			Prefix:         name,
+			ChunkSize:      100,
+			MaxConcurrency: 2,
+			Pool:           pool,
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, d.Download(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), peak.Load())
+	assert.FileExists(t, "c.bin.000003.part")
+}
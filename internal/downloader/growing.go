@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/failure"
+)
+
+// downloadGrowing downloads a file the server is still writing, such as a
+// log or a recording. Each round fetches everything up to the size HEAD
+// reports; then HEAD is repeated every GrowInterval, and a larger size
+// starts another round for the new tail. The download is complete once the
+// size has stayed the same for Config.Growing.
+func (d *Downloader) downloadGrowing(ctx context.Context) error {
+	defer d.releaseLock()
+
+	for {
+		if err := d.download(ctx); err != nil {
+			return err
+		}
+		d.earlierRounds += d.progress.TotalBytes()
+
+		// Later rounds resume what the first one started
+		d.config.Force = false
+
+		grew, err := d.waitForGrowth(ctx, d.args.TotalSize)
+		if err != nil {
+			return err
+		}
+		if !grew {
+			break
+		}
+	}
+
+	return d.complete(ctx)
+}
+
+// waitForGrowth re-checks the file's size every GrowInterval. It returns
+// true as soon as the file is larger than size, and false once the size
+// has been unchanged for Config.Growing.
+func (d *Downloader) waitForGrowth(ctx context.Context, size int64) (bool, error) {
+	slog.Info(fmt.Sprintf("Waiting for %s to grow past %s (done after %s without change)",
+		d.args.FilenamePrefix, formatBytes(size), d.config.Growing),
+		"bytes", size, "settle", d.config.Growing.String())
+
+	ticker := time.NewTicker(d.config.GrowInterval)
+	defer ticker.Stop()
+
+	unchangedSince := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, context.Cause(ctx)
+		case <-ticker.C:
+		}
+
+		remote, err := d.client.Head(ctx, d.config.URL)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return false, context.Cause(ctx)
+			}
+			slog.Warn(fmt.Sprintf("cannot check the size of %s: %v", d.args.FilenamePrefix, err))
+		case remote.Size > size:
+			slog.Info(fmt.Sprintf("%s grew to %s (+%s)", d.args.FilenamePrefix, formatBytes(remote.Size), formatBytes(remote.Size-size)),
+				"bytes", remote.Size, "previous", size)
+			d.config.Events.Emit(events.Grew, "size", remote.Size, "previous", size)
+			return true, nil
+		case remote.Size < size:
+			return false, failure.Errorf(failure.ErrSizeMismatch, "%s shrank from %d to %d bytes: it was truncated or replaced, use --force to start over",
+				d.args.FilenamePrefix, size, remote.Size)
+		}
+
+		if time.Since(unchangedSince) >= d.config.Growing {
+			return false, nil
+		}
+	}
+}
+
+// grow extends the download to size bytes. A short last chunk was
+// finalized at the old size, so its .part goes back to .tmp and the next
+// round resumes it up to the full chunk size. A re-sliced layout grows
+// its last segment.
+func (a *DownloadArguments) grow(size int64) error {
+	full := a.ChunkSize
+	if n := len(a.Layout); n > 0 {
+		full = a.Layout[n-1].ChunkSize
+	}
+	last := a.NumChunks() - 1
+	if last >= 0 && a.ChunkSizeAt(last) < full {
+		if err := os.Rename(a.PartPath(last), a.TmpPath(last)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to reopen chunk %d: %w", last, err)
+		}
+	}
+	if n := len(a.Layout); n > 0 {
+		layout := append([]Segment(nil), a.Layout...)
+		layout[n-1].Size += size - a.TotalSize
+		a.setLayout(layout)
+	}
+	a.TotalSize = size
+	return nil
+}
+
+// ReleaseLock releases the prefix lock Config.HoldLock kept, if any.
+func (d *Downloader) ReleaseLock() {
+	d.releaseLock()
+}
+
+// releaseLock releases the prefix lock, if held.
+func (d *Downloader) releaseLock() {
+	if d.lock != nil {
+		d.lock.Release()
+		d.lock = nil
+	}
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrowReopensShortLastChunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	args := NewDownloadArguments("http://example.com/f", 250, 100, "f")
+	require.NoError(t, os.WriteFile("f.000002.part", []byte(strings.Repeat("x", 50)), 0644))
+	require.NoError(t, args.grow(420))
+	assert.Equal(t, int64(420), args.TotalSize)
+	assert.NoFileExists(t, "f.000002.part")
+	assert.FileExists(t, "f.000002.tmp")
+
+	// A full last chunk stays finished
+	args = NewDownloadArguments("http://example.com/f", 300, 100, "f")
+	require.NoError(t, os.WriteFile("f.000002.part", []byte(strings.Repeat("x", 100)), 0644))
+	require.NoError(t, args.grow(420))
+	assert.FileExists(t, "f.000002.part")
+}
+
+func TestDownloadGrowing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	var mu sync.Mutex
+	content := []byte(strings.Repeat("a", 250))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		data := bytes.Clone(content)
+		mu.Unlock()
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		content = append(content, strings.Repeat("b", 170)...)
+		mu.Unlock()
+	}()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		NoEndgame:      true,
+		Growing:        300 * time.Millisecond,
+		GrowInterval:   20 * time.Millisecond,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	prefix := d.GetArguments().FilenamePrefix
+	var got []byte
+	for i := range 5 {
+		data, err := os.ReadFile(storage.PartName(prefix, i))
+		require.NoError(t, err)
+		got = append(got, data...)
+	}
+	assert.Equal(t, content, got)
+	assert.NoFileExists(t, ArgsPath(prefix))
+}
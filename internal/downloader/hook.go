@@ -0,0 +1,270 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// HookSandbox controls the environment --post-part and --pipe-part
+// commands run in. By default hooks get a minimal environment (see
+// baseHookEnv) instead of inheriting rapel's, which may hold credentials.
+type HookSandbox struct {
+	InheritEnv bool     // pass rapel's full environment (pre-sandbox behaviour)
+	Env        []string // KEY passes the variable through, KEY=VALUE sets it
+	Dir        string   // working directory; {part} becomes an absolute path
+	NoNetwork  bool     // run in an empty network namespace (Linux only)
+	Shell      string   // runs command strings: sh, bash, cmd, powershell, ... ("" = sh, cmd on Windows)
+}
+
+// baseHookEnv lists variables hooks always receive: enough to find
+// programs, config files and temp space, but nothing credential-bearing.
+var baseHookEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TZ", "LANG", "LC_ALL", "TERM",
+	// Windows essentials
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// Validate checks the sandbox options are usable on this platform.
+func (s *HookSandbox) Validate() error {
+	for _, kv := range s.Env {
+		key, _, _ := strings.Cut(kv, "=")
+		if key == "" {
+			return fmt.Errorf("invalid --hook-env %q: expected KEY or KEY=VALUE", kv)
+		}
+	}
+
+	if s.Dir != "" {
+		info, err := os.Stat(s.Dir)
+		if err != nil {
+			return fmt.Errorf("invalid --hook-dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid --hook-dir: %s is not a directory", s.Dir)
+		}
+	}
+
+	if s.Shell != "" {
+		if _, err := exec.LookPath(s.Shell); err != nil {
+			return fmt.Errorf("invalid --shell: %w", err)
+		}
+	}
+
+	if s.NoNetwork && runtime.GOOS != "linux" {
+		return fmt.Errorf("--hook-no-network is only supported on Linux")
+	}
+
+	return nil
+}
+
+// environ builds the hook environment from the parent environment.
+func (s *HookSandbox) environ(parent []string) []string {
+	if s.InheritEnv && len(s.Env) == 0 {
+		return parent
+	}
+
+	lookup := make(map[string]string, len(parent))
+	var order []string
+	for _, kv := range parent {
+		key, value, _ := strings.Cut(kv, "=")
+		if _, seen := lookup[key]; !seen {
+			order = append(order, key)
+		}
+		lookup[key] = value
+	}
+
+	env := make(map[string]string)
+	if s.InheritEnv {
+		for k, v := range lookup {
+			env[k] = v
+		}
+	} else {
+		for _, key := range baseHookEnv {
+			if v, ok := lookup[key]; ok {
+				env[key] = v
+			}
+		}
+	}
+
+	for _, kv := range s.Env {
+		key, value, hasValue := strings.Cut(kv, "=")
+		if hasValue {
+			env[key] = value
+			order = append(order, key)
+		} else if v, ok := lookup[key]; ok {
+			env[key] = v
+		}
+	}
+
+	// Keep the parent's ordering for stable, diffable environments
+	out := make([]string, 0, len(env))
+	seen := make(map[string]bool, len(env))
+	for _, key := range order {
+		if v, ok := env[key]; ok && !seen[key] {
+			out = append(out, key+"="+v)
+			seen[key] = true
+		}
+	}
+	return out
+}
+
+// Shell flavours, which differ in how a command string is passed and how
+// a word is quoted.
+const (
+	shellPOSIX      = "posix"
+	shellCmd        = "cmd"
+	shellPowerShell = "powershell"
+)
+
+// shell returns the program command strings are run with.
+func (s *HookSandbox) shell() string {
+	if s.Shell != "" {
+		return s.Shell
+	}
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+	return "sh"
+}
+
+// shellFlavour tells cmd.exe and PowerShell apart from POSIX-style shells
+// by the program's name, whichever separator its path uses.
+func shellFlavour(shell string) string {
+	name := shell[strings.LastIndexAny(shell, `/\`)+1:]
+	switch strings.TrimSuffix(strings.ToLower(name), ".exe") {
+	case "cmd":
+		return shellCmd
+	case "powershell", "pwsh":
+		return shellPowerShell
+	}
+	return shellPOSIX
+}
+
+// command builds a command running cmdStr through the shell, with the
+// sandbox's environment and working directory.
+func (s *HookSandbox) command(ctx context.Context, cmdStr string) *exec.Cmd {
+	shell := s.shell()
+	var cmd *exec.Cmd
+	switch shellFlavour(shell) {
+	case shellCmd:
+		cmd = exec.CommandContext(ctx, shell, "/S", "/C", cmdStr)
+		// cmd.exe doesn't split its command line the way Go quotes
+		// arguments, so hand it the command as written
+		rawCommandLine(cmd, shell+` /S /C "`+cmdStr+`"`)
+	case shellPowerShell:
+		cmd = exec.CommandContext(ctx, shell, "-NoProfile", "-NonInteractive", "-Command", cmdStr)
+	default:
+		cmd = exec.CommandContext(ctx, shell, "-c", cmdStr)
+	}
+	cmd.Env = s.environ(os.Environ())
+	cmd.Dir = s.Dir
+	return cmd
+}
+
+// quote quotes str as a single word for the shell. cmd.exe has no escape
+// for a double quote inside quotes, so str must not contain one there.
+func (s *HookSandbox) quote(str string) string {
+	switch shellFlavour(s.shell()) {
+	case shellCmd:
+		return `"` + str + `"`
+	case shellPowerShell:
+		return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+	}
+	return shellQuote(str)
+}
+
+// hookCommand builds a shell command for a hook with the sandbox applied.
+func (d *Downloader) hookCommand(ctx context.Context, cmdStr string) *exec.Cmd {
+	cmd := d.config.Hooks.command(ctx, cmdStr)
+	if d.config.Hooks.NoNetwork {
+		isolateNetwork(cmd)
+	}
+	return cmd
+}
+
+// hookExec builds a hook command run directly from argv, without a shell,
+// with the sandbox applied.
+func (d *Downloader) hookExec(ctx context.Context, argv []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = d.config.Hooks.environ(os.Environ())
+	cmd.Dir = d.config.Hooks.Dir
+	if d.config.Hooks.NoNetwork {
+		isolateNetwork(cmd)
+	}
+	return cmd
+}
+
+// postPartCommand builds the post-part command for a chunk, returning it
+// with the command line to show. Placeholders in --post-part-exec are
+// filled into each argument, so a {part} with spaces or quotes stays one
+// argument and never reaches a shell.
+func (d *Downloader) postPartCommand(ctx context.Context, index int) (*exec.Cmd, string) {
+	if len(d.config.PostPartExec) == 0 {
+		cmdStr := d.expandPlaceholders(d.config.PostPartCmd, index)
+		return d.hookCommand(ctx, cmdStr), cmdStr
+	}
+	argv := make([]string, len(d.config.PostPartExec))
+	for i, arg := range d.config.PostPartExec {
+		argv[i] = d.expandPlaceholders(arg, index)
+	}
+	return d.hookExec(ctx, argv), strings.Join(argv, " ")
+}
+
+// SplitArgs splits a command template such as --post-part-exec into an
+// argument vector. Words are separated by spaces and tabs; single or
+// double quotes keep spaces inside a word. Nothing else is special, so
+// Windows paths keep their backslashes.
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// hookPartPath returns the {part} value: absolute when hooks run elsewhere.
+func (d *Downloader) hookPartPath(index int) string {
+	path := d.args.PartPath(index)
+	if d.config.Hooks.Dir == "" {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package downloader
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateNetwork runs cmd in new user and network namespaces, leaving it
+// with only an unconfigured loopback interface. The caller's uid/gid are
+// mapped through so files the hook creates keep their ownership.
+func isolateNetwork(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		},
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package downloader
+
+import "os/exec"
+
+// isolateNetwork is unavailable outside Linux; HookSandbox.Validate rejects
+// NoNetwork before any hook runs.
+func isolateNetwork(cmd *exec.Cmd) {}
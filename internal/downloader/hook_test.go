@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookSandboxEnviron(t *testing.T) {
+	parent := []string{"PATH=/bin", "HOME=/root", "AWS_SECRET_ACCESS_KEY=s3cr3t", "RCLONE_CONFIG=/etc/rclone.conf"}
+
+	tests := []struct {
+		name     string
+		sandbox  HookSandbox
+		expected []string
+	}{
+		{
+			name:     "clean by default",
+			sandbox:  HookSandbox{},
+			expected: []string{"PATH=/bin", "HOME=/root"},
+		},
+		{
+			name:     "pass through and set",
+			sandbox:  HookSandbox{Env: []string{"RCLONE_CONFIG", "EXTRA=1", "MISSING"}},
+			expected: []string{"PATH=/bin", "HOME=/root", "RCLONE_CONFIG=/etc/rclone.conf", "EXTRA=1"},
+		},
+		{
+			name:     "inherit",
+			sandbox:  HookSandbox{InheritEnv: true},
+			expected: parent,
+		},
+		{
+			name:     "inherit with override",
+			sandbox:  HookSandbox{InheritEnv: true, Env: []string{"HOME=/tmp"}},
+			expected: []string{"PATH=/bin", "HOME=/tmp", "AWS_SECRET_ACCESS_KEY=s3cr3t", "RCLONE_CONFIG=/etc/rclone.conf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.sandbox.environ(parent))
+		})
+	}
+}
+
+func TestHookSandboxValidate(t *testing.T) {
+	assert.NoError(t, (&HookSandbox{Env: []string{"A", "B=1"}}).Validate())
+	assert.Error(t, (&HookSandbox{Env: []string{"=1"}}).Validate())
+	assert.Error(t, (&HookSandbox{Dir: "/nonexistent/dir"}).Validate())
+	assert.Error(t, (&HookSandbox{Shell: "no-such-shell"}).Validate())
+}
+
+func TestHookSandboxQuote(t *testing.T) {
+	url := "https://x/a?b=1&c='2'"
+	assert.Equal(t, `'https://x/a?b=1&c='\''2'\'''`, (&HookSandbox{Shell: "/bin/bash"}).quote(url))
+	assert.Equal(t, `'https://x/a?b=1&c=''2'''`, (&HookSandbox{Shell: "pwsh"}).quote(url))
+	assert.Equal(t, `"https://x/a?b=1&c='2'"`, (&HookSandbox{Shell: `C:\Windows\System32\CMD.EXE`}).quote(url))
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`rclone  move {part} 'remote:my bucket/' "--header=X: 1" C:\tmp\x ''`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"rclone", "move", "{part}", "remote:my bucket/", "--header=X: 1", `C:\tmp\x`, ""}, args)
+
+	_, err = SplitArgs(`rclone 'move`)
+	assert.Error(t, err)
+	_, err = SplitArgs("  ")
+	assert.Error(t, err)
+}
+
+func TestPostPartExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+
+	// A name that would break out of a shell command stays one argument
+	args := NewDownloadArguments("https://example.com/f.bin", 100, 100, `it's $(false); a file`)
+	d := &Downloader{
+		args:     args,
+		progress: NewProgressTracker(args),
+		config: Config{
+			PostPartExec: []string{"sh", "-c", `printf %s "$1" > out`, "sh", "{part}"},
+		},
+	}
+	require.NoError(t, d.runPostPart(0))
+	out, err := os.ReadFile("out")
+	require.NoError(t, err)
+	assert.Equal(t, args.PartPath(0), string(out))
+}
+
+func TestPostPartTimeoutAndRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Chdir(t.TempDir())
+	args := NewDownloadArguments("https://example.com/f.bin", 100, 100, "f.bin")
+
+	d := &Downloader{
+		args:     args,
+		progress: NewProgressTracker(args),
+		config:   Config{PostPartCmd: "sleep 5", PostPartTimeout: 100 * time.Millisecond},
+	}
+	start := time.Now()
+	assert.ErrorContains(t, d.runPostPart(0), "timed out")
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	// Fails the first time only
+	d.config = Config{PostPartCmd: "test -f ran || { touch ran; exit 1; }; touch done", PostPartRetries: 1}
+	d.postPartCh = make(chan int, 1)
+	d.postPartCh <- 0
+	close(d.postPartCh)
+	d.postPartWg.Add(1)
+	d.postPartWorker(context.Background())
+	assert.FileExists(t, "done")
+}
@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// What to do when another process is downloading to the same prefix
+// (Config.IfLocked).
+const (
+	LockedFail   = "fail"   // stop with registry.ErrLocked, as without a policy
+	LockedWait   = "wait"   // wait until it is done, then run as if started then
+	LockedStatus = "status" // show its progress from its files, changing nothing
+)
+
+// LockedPolicies lists the valid values of Config.IfLocked.
+var LockedPolicies = []string{LockedFail, LockedWait, LockedStatus}
+
+// ErrAttached is returned by Download with IfLocked status once the
+// process it followed completed the download. Merging is that process's
+// job. It is an ErrSkipped.
+var ErrAttached = fmt.Errorf("%w: another rapel process downloaded it", ErrSkipped)
+
+// lockPollInterval is how often a waiting or attached run looks at the
+// other process's lock and files.
+var lockPollInterval = time.Second
+
+// locked handles err from taking the lock of prefix as Config.IfLocked
+// says. With LockedWait it runs the download once the lock is free.
+func (d *Downloader) locked(ctx context.Context, prefix string, err error) error {
+	if !errors.Is(err, registry.ErrLocked) {
+		return err
+	}
+	switch d.config.IfLocked {
+	case LockedWait:
+		slog.Info(fmt.Sprintf("%v, waiting for it to finish", err))
+		if err := waitUnlocked(ctx, registry.LockPath(prefix)); err != nil {
+			return err
+		}
+		// What it left behind decides what this run does
+		return d.download(ctx)
+	case LockedStatus:
+		return d.attach(ctx, prefix, err)
+	}
+	return fmt.Errorf("%w; --if-locked wait runs after it, --if-locked status shows its progress", err)
+}
+
+// waitUnlocked returns once no process holds the lock at path.
+func waitUnlocked(ctx context.Context, path string) error {
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for registry.Locked(path) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// attach follows the download of prefix another process holds the lock of
+// (held), drawing the progress line from its state and chunk files without
+// changing them, until that process lets go. It returns ErrAttached if the
+// download was completed, or an error if it stopped short.
+func (d *Downloader) attach(ctx context.Context, prefix string, held error) error {
+	path := registry.LockPath(prefix)
+	slog.Info(fmt.Sprintf("%v, showing its progress", held))
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	var args *DownloadArguments
+	var progress *ProgressTracker
+	completed := -1
+	for {
+		// It may not have saved its state yet, or may have just removed it
+		first := false
+		if args == nil {
+			if a, err := LoadDownloadArguments(prefix); err == nil && a != nil {
+				args = a
+				progress = NewProgressTracker(args)
+				progress.SetChunkMap(d.config.ChunkMap)
+				first = true
+			}
+		}
+		if progress != nil {
+			if states, err := storage.NewLocal("", prefix).ListChunks(args.NumChunks()); err == nil {
+				followChunks(progress, states, first)
+			}
+			if progress.IsTTY() {
+				progress.PrintProgress(0)
+			} else if n := progress.CompletedCount(); n != completed {
+				completed = n
+				slog.Info(progress.statusLine(time.Now()))
+			}
+		}
+
+		if !registry.Locked(path) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if progress != nil && progress.IsTTY() {
+		// Leave the progress line
+		fmt.Fprintln(progress.writer)
+	}
+	state, err := LoadDownloadArguments(prefix)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		done := 0
+		if progress != nil {
+			done = progress.CompletedCount()
+		}
+		return fmt.Errorf("the other process stopped with %d of %d chunks complete; run again to resume", done, state.NumChunks())
+	}
+	slog.Info(fmt.Sprintf("%s was downloaded by the other process", prefix))
+	return ErrAttached
+}
+
+// followChunks brings progress up to what the chunk files on disk hold.
+// Growth since the last call counts as bytes received, so the speed and
+// ETA are the other process's; on the first, the chunks are only seeded.
+func followChunks(progress *ProgressTracker, states []storage.ChunkState, first bool) {
+	for i, st := range states {
+		bytes := st.Bytes
+		if st.Complete {
+			bytes = progress.ExpectedSize(i)
+		}
+		switch delta := bytes - progress.Bytes(i); {
+		case first:
+			progress.SeedChunk(i, bytes)
+		case delta > 0:
+			progress.AddBytes(i, delta)
+		case delta < 0:
+			progress.SeedChunk(i, bytes)
+		}
+		if st.Complete {
+			progress.MarkComplete(i)
+		}
+		progress.SetActive(i, !st.Complete && st.Bytes > 0)
+	}
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfLocked(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	defer func(d time.Duration) { lockPollInterval = d }(lockPollInterval)
+	lockPollInterval = 10 * time.Millisecond
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	var gets atomic.Int32
+	srv := completeServer(t, content, &gets)
+	download := func(policy string) error {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/f",
+			ChunkSize:      100,
+			MaxConcurrency: 2,
+			IfLocked:       policy,
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+
+	// Another process is halfway through
+	other, err := registry.Acquire(registry.LockPath("f"))
+	require.NoError(t, err)
+	args := NewDownloadArguments(srv.URL+"/f", int64(len(content)), 100, "f")
+	require.NoError(t, args.Save())
+	require.NoError(t, os.WriteFile(args.PartPath(0), content[:100], 0644))
+	require.NoError(t, os.WriteFile(args.TmpPath(1), content[100:150], 0644))
+
+	err = download(LockedFail)
+	assert.ErrorIs(t, err, registry.ErrLocked)
+	assert.ErrorContains(t, err, "--if-locked wait")
+	assert.ErrorIs(t, download(""), registry.ErrLocked)
+
+	// Attached, it sees the other one finish and leaves the files alone
+	done := make(chan error)
+	go func() { done <- download(LockedStatus) }()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(args.PartPath(1), content[100:200], 0644))
+	require.NoError(t, os.WriteFile(args.PartPath(2), content[200:], 0644))
+	os.Remove(args.TmpPath(1))
+	require.NoError(t, args.Delete())
+	require.NoError(t, other.Release())
+	err = <-done
+	assert.ErrorIs(t, err, ErrAttached)
+	assert.ErrorIs(t, err, ErrSkipped)
+	assert.Zero(t, gets.Load())
+
+	// Waiting, it runs once the other one stopped, resuming what it left
+	other, err = registry.Acquire(registry.LockPath("f"))
+	require.NoError(t, err)
+	require.NoError(t, args.Save())
+	os.Remove(args.PartPath(2))
+	go func() { done <- download(LockedWait) }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, gets.Load())
+	require.NoError(t, other.Release())
+	require.NoError(t, <-done)
+	data, err := os.ReadFile(args.PartPath(2))
+	require.NoError(t, err)
+	assert.Equal(t, content[200:], data)
+	assert.Equal(t, int32(2), gets.Load()) // a Range probe and chunk 2
+}
@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+
+	"github.com/redraw/rapel/internal/manifest"
+)
+
+// openManifest loads the manifest of an earlier run of this download, or
+// starts a new one.
+func (d *Downloader) openManifest(resuming bool) {
+	path := manifest.PathFor(d.args.FilenamePrefix)
+	if resuming {
+		m, err := manifest.Load(path)
+		if err == nil && m.File == d.args.FilenamePrefix && m.Size == d.args.TotalSize && m.ChunkSize == d.args.ChunkSize {
+			m.SHA256 = ""
+			d.manifest = m
+			d.openFileDigest(true)
+			return
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("%v, starting a new manifest", err))
+		}
+	}
+	d.manifest = &manifest.Manifest{
+		File:      d.args.FilenamePrefix,
+		Size:      d.args.TotalSize,
+		ChunkSize: d.args.ChunkSize,
+		Parts:     []manifest.Part{},
+	}
+	d.openFileDigest(false)
+}
+
+// recordManifest hashes chunk index's file into the manifest. It runs
+// before --post-part gets the chunk, which may move it away.
+func (d *Downloader) recordManifest(index int) {
+	if d.manifest == nil {
+		return
+	}
+	defer d.advanceFileDigest()
+
+	// Hashed on the way in, or else read back
+	partPath := d.args.PartPath(index)
+	var size int64
+	var sha, md string
+	var err error
+	if h := d.takeDigest(index, d.args.ChunkSizeAt(index)); h != nil {
+		size, sha, md = h.size, hex.EncodeToString(h.sha.Sum(nil)), hex.EncodeToString(h.md5.Sum(nil))
+	} else {
+		size, sha, md, err = manifest.HashFile(partPath)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// Moved by --post-part in an earlier run
+		return
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("chunk %d is left out of the manifest: %v", index, err), "chunk", index)
+		return
+	}
+	start, end := d.args.ChunkRange(index)
+	part := manifest.Part{
+		Index:  index,
+		Name:   filepath.Base(partPath),
+		Start:  start,
+		End:    end,
+		Size:   size,
+		SHA256: sha,
+		MD5:    md,
+	}
+	d.writePartMeta(partPath, part)
+
+	d.manifestMu.Lock()
+	defer d.manifestMu.Unlock()
+	m := d.manifest
+	i := sort.Search(len(m.Parts), func(i int) bool { return m.Parts[i].Index >= index })
+	if i < len(m.Parts) && m.Parts[i].Index == index {
+		m.Parts[i] = part
+	} else {
+		m.Parts = append(m.Parts, manifest.Part{})
+		copy(m.Parts[i+1:], m.Parts[i:])
+		m.Parts[i] = part
+	}
+	if err := m.Save(manifest.PathFor(m.File)); err != nil {
+		slog.Warn(err.Error())
+	}
+}
+
+// recordManifestOnce hashes a chunk finished by an earlier run, unless
+// the manifest already lists it.
+func (d *Downloader) recordManifestOnce(index int) {
+	if d.manifest == nil {
+		return
+	}
+	d.manifestMu.Lock()
+	m := d.manifest
+	i := sort.Search(len(m.Parts), func(i int) bool { return m.Parts[i].Index >= index })
+	listed := i < len(m.Parts) && m.Parts[i].Index == index
+	d.manifestMu.Unlock()
+	if !listed {
+		d.recordManifest(index)
+	}
+}
+
+// completeManifest adds the chunks the manifest still misses, if they are
+// on disk, and the whole file's SHA-256 when every chunk is.
+func (d *Downloader) completeManifest() error {
+	if d.manifest == nil {
+		return nil
+	}
+	for i := 0; i < d.args.NumChunks(); i++ {
+		d.recordManifestOnce(i)
+	}
+
+	m := d.manifest
+	if len(m.Parts) < d.args.NumChunks() {
+		slog.Warn(fmt.Sprintf("%s lists %d of %d chunks: the others were moved before they could be hashed",
+			manifest.PathFor(m.File), len(m.Parts), d.args.NumChunks()))
+	} else if sum, err := d.fileSHA256(); err != nil {
+		slog.Info(fmt.Sprintf("Not hashing the whole file: %v", err))
+	} else {
+		m.SHA256 = sum
+	}
+	if err := m.Save(manifest.PathFor(m.File)); err != nil {
+		return err
+	}
+	d.forgetFileDigest()
+	slog.Info("Manifest   : "+manifest.PathFor(m.File), "manifest", manifest.PathFor(m.File), "sha256", m.SHA256)
+	return nil
+}
@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteManifest(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin",
+		Prefix:         "f.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		WriteManifest:  true,
+		// Moves each chunk away once hashed, as offloading would
+		PostPartCmd: "mv {part} {part}.moved",
+		HTTPConfig:  httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	m, err := manifest.Load("f.bin.manifest.json")
+	require.NoError(t, err)
+	assert.Equal(t, "f.bin", m.File)
+	assert.Equal(t, int64(250), m.Size)
+	require.Len(t, m.Parts, 3)
+	for i, part := range m.Parts {
+		start, end := d.GetArguments().ChunkRange(i)
+		sum := sha256.Sum256(content[start : end+1])
+		assert.Equal(t, i, part.Index)
+		assert.Equal(t, d.GetArguments().PartPath(i), part.Name)
+		assert.Equal(t, int64(i)*100, start)
+		assert.Equal(t, hex.EncodeToString(sum[:]), part.SHA256)
+	}
+	// The whole file is hashed as chunks finish in order; a chunk moved
+	// away before the ones ahead of it finished leaves it out
+	if m.SHA256 != "" {
+		sum := sha256.Sum256(content)
+		assert.Equal(t, hex.EncodeToString(sum[:]), m.SHA256)
+	}
+}
+
+func TestWriteManifestWholeFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("abcdefghij"), 25)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// A chunk from a run without --write-manifest is picked up at the end
+	args := NewDownloadArguments(srv.URL+"/f.bin", 250, 100, "f.bin")
+	require.NoError(t, args.Save())
+	require.NoError(t, os.WriteFile(args.PartPath(1), content[100:200], 0644))
+
+	d, err := NewDownloader(Config{
+		URL:           srv.URL + "/f.bin",
+		ChunkSize:     100,
+		WriteManifest: true,
+		HTTPConfig:    httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	m, err := manifest.Load("f.bin.manifest.json")
+	require.NoError(t, err)
+	assert.Len(t, m.Parts, 3)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), m.SHA256)
+}
+
+func TestPartMetaSidecar(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	_, err := NewDownloader(Config{URL: srv.URL + "/f.bin", PartMeta: PartMetaSidecar})
+	assert.ErrorContains(t, err, "manifest")
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin?token=secret",
+		Prefix:         "f.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		WriteManifest:  true,
+		PartMeta:       PartMetaSidecar,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	for i := range 3 {
+		data, err := os.ReadFile(manifest.SidecarPath(d.GetArguments().PartPath(i)))
+		require.NoError(t, err)
+		var meta manifest.PartMeta
+		require.NoError(t, json.Unmarshal(data, &meta))
+
+		start, end := d.GetArguments().ChunkRange(i)
+		sum := sha256.Sum256(content[start : end+1])
+		assert.Equal(t, "f.bin", meta.File)
+		assert.Equal(t, int64(250), meta.FileSize)
+		assert.NotContains(t, meta.URL, "secret")
+		assert.Equal(t, i, meta.Index)
+		assert.Equal(t, start, meta.Start)
+		assert.Equal(t, end, meta.End)
+		assert.Equal(t, hex.EncodeToString(sum[:]), meta.SHA256)
+	}
+}
@@ -0,0 +1,207 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// Chunk speeds are sampled every second; the textfile is rewritten every
+// metricsFileEvery samples.
+const (
+	metricsSampleInterval = time.Second
+	metricsFileEvery      = 5
+)
+
+// metricsSampler derives per-chunk speeds from byte counters between samples.
+type metricsSampler struct {
+	mu     sync.Mutex
+	last   []int64
+	speeds []float64
+	lastAt time.Time
+}
+
+// sample updates speeds from the tracker's current byte counts.
+func (s *metricsSampler) sample(p *ProgressTracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.last == nil {
+		s.last = make([]int64, p.NumChunks())
+		s.speeds = make([]float64, p.NumChunks())
+		for i := range s.last {
+			s.last[i] = p.Bytes(i)
+		}
+		s.lastAt = now
+		return
+	}
+
+	elapsed := now.Sub(s.lastAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	for i := range s.last {
+		b := p.Bytes(i)
+		s.speeds[i] = float64(b-s.last[i]) / elapsed
+		s.last[i] = b
+	}
+	s.lastAt = now
+}
+
+// speed returns the last sampled speed of chunk i in bytes per second.
+func (s *metricsSampler) speed(i int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.speeds == nil {
+		return 0
+	}
+	return s.speeds[i]
+}
+
+// startMetrics serves /metrics on Config.MetricsListen and rewrites
+// Config.MetricsFile periodically, until the returned function is called.
+func (d *Downloader) startMetrics(ctx context.Context) (func(), error) {
+	if d.config.MetricsListen == "" && d.config.MetricsFile == "" {
+		return func() {}, nil
+	}
+
+	sampler := &metricsSampler{}
+	sampler.sample(d.progress)
+
+	var srv *http.Server
+	if d.config.MetricsListen != "" {
+		ln, err := net.Listen("tcp", d.config.MetricsListen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			d.writeMetrics(w, sampler)
+		})
+		srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn(fmt.Sprintf("metrics server stopped: %v", err))
+			}
+		}()
+		slog.Debug("Serving metrics on http://"+ln.Addr().String()+"/metrics", "addr", ln.Addr().String())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(metricsSampleInterval)
+		defer ticker.Stop()
+
+		for n := 1; ; n++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sampler.sample(d.progress)
+				if n%metricsFileEvery == 0 {
+					d.writeMetricsFile(sampler)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		// Final values, so the textfile reflects the finished download
+		sampler.sample(d.progress)
+		d.writeMetricsFile(sampler)
+		if srv != nil {
+			srv.Close()
+		}
+	}, nil
+}
+
+// writeMetricsFile atomically replaces Config.MetricsFile, as the
+// node_exporter textfile collector requires.
+func (d *Downloader) writeMetricsFile(sampler *metricsSampler) {
+	if d.config.MetricsFile == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	d.writeMetrics(&buf, sampler)
+
+	if err := fsutil.WriteFileAtomic(d.config.MetricsFile, buf.Bytes(), 0644); err != nil {
+		slog.Warn(fmt.Sprintf("cannot write metrics file: %v", err))
+	}
+}
+
+// writeMetrics renders the current state in the Prometheus text format.
+func (d *Downloader) writeMetrics(w io.Writer, sampler *metricsSampler) {
+	p := d.progress
+	prefix := strconv.Quote(d.args.FilenamePrefix)
+
+	metric := func(name, typ, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{prefix=%s} %s\n",
+			name, help, name, typ, name, prefix, formatMetric(value))
+	}
+
+	active := 0
+	for i := 0; i < p.NumChunks(); i++ {
+		if p.IsActive(i) {
+			active++
+		}
+	}
+
+	metric("rapel_downloaded_bytes_total", "counter", "Bytes downloaded by this process.", float64(p.TotalBytes()))
+	metric("rapel_progress_bytes", "gauge", "Bytes on hand, including chunks downloaded by earlier runs.", float64(p.DownloadedBytes()))
+	metric("rapel_size_bytes", "gauge", "Total size of the download.", float64(p.TotalSize()))
+	metric("rapel_chunks", "gauge", "Number of chunks.", float64(p.NumChunks()))
+	metric("rapel_chunks_completed", "gauge", "Number of completed chunks.", float64(p.CompletedCount()))
+	metric("rapel_active_connections", "gauge", "Chunks currently downloading.", float64(active))
+	metric("rapel_jobs", "gauge", "Maximum concurrent chunk downloads.", float64(d.Concurrency()))
+	metric("rapel_rate_limit_bytes_per_second", "gauge", "Aggregate rate limit (0 = unlimited).", float64(d.RateLimit()))
+	diskBound := 0.0
+	if p.DiskBound() {
+		diskBound = 1
+	}
+	metric("rapel_disk_bound", "gauge", "1 while writing the chunk files holds the download back.", diskBound)
+	metric("rapel_post_part_queue_length", "gauge", "Post-part commands queued or running.", float64(d.PostPartQueueDepth()))
+
+	fmt.Fprintf(w, "# HELP rapel_host_connections Open connections per host (or proxy), busy or idle.\n# TYPE rapel_host_connections gauge\n")
+	for _, h := range d.client.Connections() {
+		fmt.Fprintf(w, "rapel_host_connections{prefix=%s,host=%s} %d\n", prefix, strconv.Quote(h.Host), h.Open)
+	}
+
+	fmt.Fprintf(w, "# HELP rapel_chunk_speed_bytes_per_second Download speed of each active chunk.\n# TYPE rapel_chunk_speed_bytes_per_second gauge\n")
+	for i := 0; i < p.NumChunks(); i++ {
+		if p.IsActive(i) {
+			fmt.Fprintf(w, "rapel_chunk_speed_bytes_per_second{prefix=%s,chunk=\"%d\"} %s\n", prefix, i, formatMetric(sampler.speed(i)))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP rapel_chunk_retries_total Retries of each chunk that needed any.\n# TYPE rapel_chunk_retries_total counter\n")
+	for i := 0; i < p.NumChunks(); i++ {
+		if r := p.Retries(i); r > 0 {
+			fmt.Fprintf(w, "rapel_chunk_retries_total{prefix=%s,chunk=\"%d\"} %d\n", prefix, i, r)
+		}
+	}
+}
+
+// formatMetric formats a sample value without exponent noise for integers.
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
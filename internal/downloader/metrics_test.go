@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"bytes"
+	"testing"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	args := NewDownloadArguments("https://example.com/f.bin", 100, 10, "f.bin")
+	client, err := httpclient.NewClient(httpclient.Config{})
+	require.NoError(t, err)
+	d := &Downloader{
+		args:     args,
+		client:   client,
+		jobs:     newJobGate(3),
+		limiter:  NewRateLimiter(500),
+		progress: NewProgressTracker(args),
+	}
+	d.progress.MarkComplete(0)
+	d.progress.SetActive(1, true)
+	d.progress.AddBytes(1, 4)
+	d.progress.AddRetry(1)
+
+	var buf bytes.Buffer
+	d.writeMetrics(&buf, &metricsSampler{})
+	out := buf.String()
+
+	assert.Contains(t, out, `rapel_downloaded_bytes_total{prefix="f.bin"} 4`)
+	assert.Contains(t, out, `rapel_progress_bytes{prefix="f.bin"} 14`)
+	assert.Contains(t, out, `rapel_chunks_completed{prefix="f.bin"} 1`)
+	assert.Contains(t, out, `rapel_active_connections{prefix="f.bin"} 1`)
+	assert.Contains(t, out, `rapel_jobs{prefix="f.bin"} 3`)
+	assert.Contains(t, out, `rapel_rate_limit_bytes_per_second{prefix="f.bin"} 500`)
+	assert.Contains(t, out, `rapel_chunk_speed_bytes_per_second{prefix="f.bin",chunk="1"} 0`)
+	assert.Contains(t, out, `rapel_chunk_retries_total{prefix="f.bin",chunk="1"} 1`)
+	assert.NotContains(t, out, `chunk="2"`)
+}
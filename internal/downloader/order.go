@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+)
+
+// Dispatch orders: which pending chunks get a free job first.
+const (
+	OrderSequential = "sequential"  // from the start of the file, as without an order
+	OrderRandom     = "random"      // shuffled, so no region of the server is hit by every job at once
+	OrderRarestLast = "rarest-last" // chunks with partial data on disk first, untouched chunks last
+	OrderTailFirst  = "tail-first"  // the last chunk, then the first, then the rest from the start
+)
+
+// Orders lists the valid values of Config.Order.
+var Orders = []string{OrderSequential, OrderRandom, OrderRarestLast, OrderTailFirst}
+
+// dispatchOrder returns every chunk index in the order Config.Order
+// dispatches them. tail-first lets a media player probe the container's
+// header and index (an MP4 moov atom is often at the end) from a partial
+// merge early on; rarest-last finishes what earlier runs started before
+// opening new chunk files.
+func (d *Downloader) dispatchOrder() []int {
+	n := d.args.NumChunks()
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+
+	switch d.config.Order {
+	case OrderRandom:
+		rand.Shuffle(n, func(i, j int) { order[i], order[j] = order[j], order[i] })
+	case OrderRarestLast:
+		slices.SortStableFunc(order, func(a, b int) int {
+			return cmp.Compare(d.progress.Bytes(b), d.progress.Bytes(a))
+		})
+	case OrderTailFirst:
+		if n > 1 {
+			order = append([]int{n - 1}, order[:n-1]...)
+		}
+	}
+	return order
+}
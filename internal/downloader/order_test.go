@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	args := NewDownloadArguments("http://example.com/f", 500, 100, "f")
+	d := &Downloader{args: args, progress: NewProgressTracker(args)}
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, d.dispatchOrder())
+
+	d.config.Order = OrderTailFirst
+	assert.Equal(t, []int{4, 0, 1, 2, 3}, d.dispatchOrder())
+
+	// Most bytes on disk first, untouched chunks keep their order
+	d.config.Order = OrderRarestLast
+	d.progress.SeedChunk(3, 10)
+	d.progress.SeedChunk(1, 50)
+	assert.Equal(t, []int{1, 3, 0, 2, 4}, d.dispatchOrder())
+
+	d.config.Order = OrderRandom
+	order := d.dispatchOrder()
+	slices.Sort(order)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+
+	// A single chunk has nothing to reorder
+	one := NewDownloadArguments("http://example.com/f", 50, 100, "f")
+	d = &Downloader{args: one, progress: NewProgressTracker(one), config: Config{Order: OrderTailFirst}}
+	assert.Equal(t, []int{0}, d.dispatchOrder())
+}
+
+func TestTailFirstDownload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	body := make([]byte, 1000)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Not the HEAD request or the one-byte Range probe
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 1,
+		Order:          OrderTailFirst,
+		Coalesce:       1000,
+		NoEndgame:      true,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	// The last chunk comes first, and the coalesced group after it stops
+	// short of the chunk already fetched
+	assert.Equal(t, []string{"bytes=900-999", "bytes=0-899"}, ranges)
+	for i := range 10 {
+		part, err := os.ReadFile(d.args.PartPath(i))
+		require.NoError(t, err)
+		assert.Equal(t, body[i*100:(i+1)*100], part)
+	}
+}
+
+func TestSequentialDownload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	body := make([]byte, 1000)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	// Refuses a range that starts before the last one on its connection
+	var (
+		mu    sync.Mutex
+		last  = map[string]int64{}
+		conns = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start int64
+		if r.Method == http.MethodGet {
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+			mu.Lock()
+			prev, seen := last[r.RemoteAddr]
+			last[r.RemoteAddr] = start
+			conns[r.RemoteAddr] = true
+			mu.Unlock()
+			if seen && start < prev {
+				http.Error(w, "out of order", http.StatusForbidden)
+				return
+			}
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 4,
+		Sequential:     true,
+		HTTPConfig:     httpclient.Config{MaxRetries: 0, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	assert.Len(t, conns, 1)
+	for i := range 10 {
+		part, err := os.ReadFile(d.args.PartPath(i))
+		require.NoError(t, err)
+		assert.Equal(t, body[i*100:(i+1)*100], part)
+	}
+}
@@ -0,0 +1,49 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// openChunk opens chunk index for appending, like Storage.OpenChunk, but
+// starts the chunk over when its .tmp file holds more than the chunk's
+// byte range. Such a file can't be resumed: a server that ignored Range
+// sent the file from its start, or the file was written with another chunk
+// size, and finalizing it would pass garbage off as a valid .part.
+func (d *Downloader) openChunk(index int) (storage.Chunk, int64, error) {
+	chunk, size, err := d.storage.OpenChunk(index)
+	if err != nil || size <= d.args.ChunkSizeAt(index) {
+		return chunk, size, err
+	}
+	chunk.Close()
+	if err := d.discardOversized(index, size); err != nil {
+		return nil, 0, err
+	}
+	return d.storage.OpenChunk(index)
+}
+
+// discardOversized removes chunk index's .tmp file of size bytes, more
+// than the chunk holds.
+func (d *Downloader) discardOversized(index int, size int64) error {
+	local, ok := d.storage.(*storage.Local)
+	if !ok {
+		return fmt.Errorf("chunk %d holds %s, more than its %s", index, formatBytes(size), formatBytes(d.args.ChunkSizeAt(index)))
+	}
+	msg := fmt.Sprintf("chunk %d: %s holds %s, more than the chunk's %s (a server that ignored Range, or another chunk size); starting the chunk over",
+		index, local.TmpPath(index), formatBytes(size), formatBytes(d.args.ChunkSizeAt(index)))
+	if d.progress != nil {
+		d.progress.PrintMessage("%s", msg)
+	} else {
+		slog.Warn(msg, "chunk", index, "bytes", size)
+	}
+	if err := os.Remove(local.TmpPath(index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to discard chunk %d: %w", index, err)
+	}
+	if d.progress != nil {
+		d.progress.SeedChunk(index, 0)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOversizedTmpStartsOver(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	body := make([]byte, 250)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/f.bin"
+	require.NoError(t, NewDownloadArguments(url, 250, 100, "f.bin").Save())
+	// A server that ignored Range wrote the whole file into chunk 1
+	require.NoError(t, os.WriteFile("f.bin.000001.tmp", body, 0o644))
+
+	d, err := NewDownloader(Config{
+		URL:        url,
+		ChunkSize:  100,
+		HTTPConfig: httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	part, err := os.ReadFile("f.bin.000001.part")
+	require.NoError(t, err)
+	assert.Equal(t, body[100:200], part)
+	assert.NoFileExists(t, "f.bin.000001.tmp")
+}
@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// pacer spaces out request starts by a jittered interval, so N chunks
+// don't hit the server as one burst of N simultaneous range requests.
+type pacer struct {
+	interval time.Duration // 0 = no pacing
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until this request's turn.
+func (p *pacer) wait(ctx context.Context) error {
+	if p.interval <= 0 {
+		return nil
+	}
+
+	delay := time.Until(p.reserve(time.Now(), rand.Float64()))
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reserve returns the start time of the next request and pushes the one
+// after it back by the interval scaled to 50-150% by jitter (0 to 1).
+func (p *pacer) reserve(now time.Time, jitter float64) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(time.Duration(float64(p.interval) * (0.5 + jitter)))
+	return slot
+}
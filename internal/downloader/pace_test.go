@@ -0,0 +1,26 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacerReserve(t *testing.T) {
+	p := &pacer{interval: time.Second}
+	now := time.Unix(1000, 0)
+
+	// A burst is spread out, each gap jittered
+	assert.Equal(t, now, p.reserve(now, 0.5))
+	assert.Equal(t, now.Add(time.Second), p.reserve(now, 0))
+	assert.Equal(t, now.Add(1500*time.Millisecond), p.reserve(now, 1))
+	assert.Equal(t, now.Add(3*time.Second), p.reserve(now, 0.5))
+
+	// After a quiet spell the next request goes right away
+	later := now.Add(time.Minute)
+	assert.Equal(t, later, p.reserve(later, 0.5))
+
+	assert.NoError(t, (&pacer{}).wait(context.Background()))
+}
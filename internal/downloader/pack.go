@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// PackedPath returns the file holding chunks first..last once packed
+// together by --pack-parts.
+func (a *DownloadArguments) PackedPath(first, last int) string {
+	return storage.PackedName(a.FilenamePrefix, first, last)
+}
+
+// CompletedChunks reports which chunks are complete on disk, either as
+// their own .part file or inside a packed file.
+func (a *DownloadArguments) CompletedChunks() ([]bool, error) {
+	states, err := storage.NewLocal("", a.FilenamePrefix).ListChunks(a.NumChunks())
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]bool, len(states))
+	for i, st := range states {
+		done[i] = st.Complete
+	}
+	return done, nil
+}
+
+// removePackedLeftovers deletes .part files already inside a packed file,
+// left behind when rapel stopped between packing and cleaning up.
+func (d *Downloader) removePackedLeftovers() error {
+	ranges, err := storage.NewLocal("", d.args.FilenamePrefix).PackedRanges()
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		for i := r[0]; i <= r[1]; i++ {
+			if err := os.Remove(d.args.PartPath(i)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove packed chunk: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// packBlock returns the block of --pack-parts chunks containing index, or
+// ok=false if the block isn't complete yet or is already packed. A block
+// is claimed by the first caller that sees it complete.
+func (d *Downloader) packBlock(index int) (first, last int, ok bool) {
+	size := d.config.PackParts
+	first = index / size * size
+	last = first + size - 1
+	if n := d.args.NumChunks(); last >= n {
+		last = n - 1
+	}
+	if last == first {
+		return 0, 0, false
+	}
+
+	for i := first; i <= last; i++ {
+		if !d.progress.IsChunkComplete(i) {
+			return 0, 0, false
+		}
+	}
+
+	if _, loaded := d.packed.LoadOrStore(first, true); loaded {
+		return 0, 0, false
+	}
+	if _, err := os.Stat(d.args.PackedPath(first, last)); err == nil {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// maybePack packs the block containing index into one file once all its
+// chunks are complete. Failing to pack is only logged: the .part files
+// are left as they were.
+func (d *Downloader) maybePack(index int) {
+	if _, local := d.storage.(*storage.Local); d.config.PackParts < 2 || !local {
+		return
+	}
+	first, last, ok := d.packBlock(index)
+	if !ok {
+		return
+	}
+
+	if err := d.pack(first, last); err != nil {
+		d.progress.PrintMessage("Failed to pack chunks %d-%d: %v", first, last, err)
+	}
+}
+
+// pack concatenates the .part files of chunks first..last into their
+// packed file, then removes them.
+func (d *Downloader) pack(first, last int) error {
+	path := d.args.PackedPath(first, last)
+	tmpPath := fmt.Sprintf("%s.%06d-%06d.packing", d.args.FilenamePrefix, first, last)
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	for i := first; i <= last; i++ {
+		if err := appendFile(out, d.args.PartPath(i)); err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for i := first; i <= last; i++ {
+		os.Remove(d.args.PartPath(i))
+	}
+	return nil
+}
+
+// appendFile copies the file at path to w.
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
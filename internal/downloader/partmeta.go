@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// Where Config.PartMeta puts each part's metadata.
+const (
+	PartMetaSidecar = "sidecar" // <part>.json next to the part
+	PartMetaXattr   = "xattr"   // the user.rapel.part extended attribute (Linux only)
+)
+
+// PartMetaModes lists the valid values of Config.PartMeta.
+var PartMetaModes = []string{PartMetaSidecar, PartMetaXattr}
+
+// writePartMeta records part, just hashed into the manifest, next to its
+// file as Config.PartMeta says. A failure only costs the metadata.
+func (d *Downloader) writePartMeta(partPath string, part manifest.Part) {
+	if d.config.PartMeta == "" {
+		return
+	}
+	meta := manifest.PartMeta{
+		File:     d.args.FilenamePrefix,
+		FileSize: d.args.TotalSize,
+		URL:      redact.URL(d.config.URL),
+		Part:     part,
+	}
+
+	var err error
+	switch d.config.PartMeta {
+	case PartMetaSidecar:
+		err = manifest.WriteSidecar(partPath, meta)
+	case PartMetaXattr:
+		var data []byte
+		if data, err = json.Marshal(meta); err == nil {
+			err = setXattr(partPath, manifest.XattrName, data)
+		}
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("chunk %d: failed to write its metadata: %v", part.Index, err), "chunk", part.Index)
+	}
+}
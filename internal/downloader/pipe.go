@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// PipeState records which chunks were successfully streamed into a
+// --pipe-part command. Piped chunks leave nothing on disk, so this file
+// (.{prefix}-piped.json) is the only record of their completion. It is
+// rewritten atomically after every chunk and removed when the download
+// finishes.
+type PipeState struct {
+	Piped []int `json:"piped"`
+
+	mu       sync.Mutex
+	filePath string
+	set      map[int]bool
+	backups  int // previous generations kept on each save
+}
+
+// pipeStatePath returns the pipe state file of prefix.
+func pipeStatePath(prefix string) string {
+	return registry.StatePath(prefix, "piped.json")
+}
+
+// LoadPipeState loads the pipe state for prefix, returning an empty state if none exists.
+func LoadPipeState(prefix string) (*PipeState, error) {
+	return loadPipeStateFile(prefix, pipeStatePath(prefix))
+}
+
+// loadPipeStateFile loads prefix's pipe state from path.
+func loadPipeStateFile(prefix, path string) (*PipeState, error) {
+	s := &PipeState{
+		filePath: pipeStatePath(prefix),
+		set:      make(map[int]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipe state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse pipe state: %w", err)
+	}
+	for _, i := range s.Piped {
+		s.set[i] = true
+	}
+
+	return s, nil
+}
+
+// restorePipeState loads the newest readable backup of prefix's pipe
+// state. Chunks piped after that backup was taken are piped again.
+func restorePipeState(prefix string, loadErr error) (*PipeState, error) {
+	path := pipeStatePath(prefix)
+	for _, backup := range fsutil.Backups(path) {
+		s, err := loadPipeStateFile(prefix, backup)
+		if err != nil {
+			continue
+		}
+		slog.Warn(fmt.Sprintf("%v; restored %d piped chunks from %s", loadErr, len(s.Piped), backup), "error", loadErr, "backup", backup)
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w, and no readable backup of it", loadErr)
+}
+
+// KeepBackups makes each save keep n previous generations of the state file.
+func (s *PipeState) KeepBackups(n int) {
+	s.backups = n
+}
+
+// IsPiped returns whether chunk i was already piped successfully.
+func (s *PipeState) IsPiped(i int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set[i]
+}
+
+// MarkPiped records chunk i as piped and persists the state.
+func (s *PipeState) MarkPiped(i int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.set[i] {
+		return nil
+	}
+	s.set[i] = true
+	s.Piped = append(s.Piped, i)
+	sort.Ints(s.Piped)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal pipe state: %w", err)
+	}
+
+	if err := fsutil.RotateBackups(s.filePath, s.backups); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pipe state: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the pipe state file and its backups.
+func (s *PipeState) Delete() error {
+	fsutil.RemoveBackups(s.filePath)
+	err := os.Remove(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// pipeChunk streams a chunk straight into the stdin of the --pipe-part
+// command without touching local disk. A failed attempt cannot be resumed
+// (the consumer already saw partial data), so each retry restarts the chunk
+// and the command from scratch.
+func (d *Downloader) pipeChunk(ctx context.Context, index int) error {
+	start, end := d.args.ChunkRange(index)
+
+	d.progress.SetActive(index, true)
+	defer d.progress.SetActive(index, false)
+
+	var lastErr error
+	maxRetries := d.config.HTTPConfig.MaxRetries
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 && errors.Is(lastErr, errBanCooldown) {
+			// Waiting out a ban isn't this chunk's failure
+			attempt--
+		} else if attempt > 0 {
+			if err := d.recheckSize(ctx, index, lastErr); err != nil {
+				d.progress.PrintError(index, lastErr)
+				return err
+			}
+			if err := d.checkRetry(lastErr); err != nil {
+				d.progress.PrintError(index, lastErr)
+				return err
+			}
+			if err := d.retries.spend(index, lastErr); err != nil {
+				return err
+			}
+			d.progress.AddRetry(index)
+			d.config.Events.Emit(events.ChunkRetry, "chunk", index, "attempt", attempt, "error", lastErr)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d.config.Backoff.Delay(attempt)):
+			}
+		}
+
+		d.progress.SeedChunk(index, 0)
+
+		lastErr = d.pipeChunkOnce(ctx, index, start, end)
+		if lastErr == nil {
+			if err := d.pipeState.MarkPiped(index); err != nil {
+				return fmt.Errorf("failed to save pipe state: %w", err)
+			}
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	d.progress.PrintError(index, lastErr)
+	return fmt.Errorf("pipe failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// pipeChunkOnce runs one attempt of the pipe command for a chunk.
+func (d *Downloader) pipeChunkOnce(ctx context.Context, index int, start, end int64) error {
+	cmdStr := d.expandPlaceholders(d.config.PipePartCmd, index)
+
+	var output bytes.Buffer
+	cmd := d.hookCommand(ctx, cmdStr)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start pipe command: %w", err)
+	}
+
+	pw := &progressWriter{
+		ctx:      ctx,
+		writer:   stdin,
+		tracker:  d.progress,
+		limiter:  d.limiter,
+		chunkIdx: index,
+	}
+
+	if err := d.fetchRange(ctx, index, start, end, pw); err != nil {
+		stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+
+	if err := stdin.Close(); err != nil {
+		_ = cmd.Wait()
+		return fmt.Errorf("failed to close pipe: %w", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		out := strings.TrimSpace(output.String())
+		if out != "" {
+			return fmt.Errorf("pipe command failed: %w: %s", err, out)
+		}
+		return fmt.Errorf("pipe command failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeStatePersistence(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	s, err := LoadPipeState("file")
+	require.NoError(t, err)
+	assert.False(t, s.IsPiped(0))
+
+	require.NoError(t, s.MarkPiped(2))
+	require.NoError(t, s.MarkPiped(0))
+	require.NoError(t, s.MarkPiped(2)) // idempotent
+
+	loaded, err := LoadPipeState("file")
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, loaded.Piped)
+	assert.True(t, loaded.IsPiped(0))
+	assert.False(t, loaded.IsPiped(1))
+
+	require.NoError(t, loaded.Delete())
+	require.NoError(t, loaded.Delete()) // missing file is not an error
+
+	empty, err := LoadPipeState("file")
+	require.NoError(t, err)
+	assert.Empty(t, empty.Piped)
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	d := &Downloader{args: NewDownloadArguments("http://example.com/file", 2500, 1000, "file")}
+
+	assert.Equal(t,
+		"upload file.000002.part 2 file 2000-2499",
+		d.expandPlaceholders("upload {part} {idx} {base} {start}-{end}", 2))
+}
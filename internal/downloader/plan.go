@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// planSample is how much Plan downloads (and discards) to probe the server.
+const planSample = 1 << 20
+
+// ChunkStatus is what a dry run found on disk for a chunk.
+type ChunkStatus int
+
+const (
+	ChunkPending  ChunkStatus = iota // nothing downloaded yet, or selected for re-fetching
+	ChunkPartial                     // a .tmp file to resume
+	ChunkComplete                    // finished
+)
+
+// Plan is what a download would do, worked out without writing anything.
+type Plan struct {
+	URL        string // redacted
+	File       string // filename prefix
+	FromHeader bool   // File was named by Content-Disposition
+	Size       int64
+	ChunkSize  int64
+	Status     []ChunkStatus
+	Remaining  int64 // bytes left to download
+	Resume     bool  // saved state would be resumed
+	Conflict   error // why the saved state stops the download (without --force)
+	Listed     bool  // chunk files were checked; not for remote storage or a conflict
+	Skipped    bool  // Config.IfExists would leave the existing file alone; nothing else is filled in
+
+	// Probe is a range request for the first planSample bytes, showing
+	// whether the server honors Range and how fast one connection is.
+	// Nil with --fetch-cmd.
+	Probe *httpclient.ProbeResult
+}
+
+// Chunks returns the number of chunks.
+func (p *Plan) Chunks() int {
+	return len(p.Status)
+}
+
+// Count returns how many chunks have status s.
+func (p *Plan) Count(s ChunkStatus) int {
+	n := 0
+	for _, status := range p.Status {
+		if status == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan sizes the file, checks Range support and reads the saved state and
+// chunk files the way Download would, to report what it would fetch. It
+// doesn't take the lock or write anything.
+func (d *Downloader) Plan(ctx context.Context) (*Plan, error) {
+	prefix, totalSize, fromHeader, err := d.sizeFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prefix, err = d.avoidCollision(prefix); err != nil {
+		return nil, err
+	}
+	resolved, err := d.resolveExisting(prefix, totalSize, false)
+	if err != nil && !errors.Is(err, ErrSkipped) {
+		return nil, err
+	}
+
+	plan := &Plan{
+		URL:        redact.URL(d.config.URL),
+		File:       prefix,
+		FromHeader: fromHeader,
+		Size:       totalSize,
+		Skipped:    err != nil,
+	}
+	if plan.Skipped {
+		return plan, nil
+	}
+	prefix = resolved
+	plan.File = prefix
+
+	if d.config.FetchCmd == "" {
+		sample := int64(planSample)
+		if sample > totalSize {
+			sample = totalSize
+		}
+		u, err := d.requestURL(ctx, 0, 0, sample-1)
+		if err != nil {
+			return nil, err
+		}
+		probe := d.client.Probe(ctx, u, sample)
+		probe.URL = plan.URL
+		plan.Probe = &probe
+	}
+
+	// Same rules as Download for reusing the saved state
+	d.args = NewDownloadArguments(d.config.URL, totalSize, d.config.ChunkSize, prefix)
+	if !d.config.Force {
+		existing, err := LoadDownloadArguments(prefix)
+		switch {
+		case err != nil:
+			plan.Conflict = fmt.Errorf("%w (--recover rebuilds it from the chunk files)", err)
+		case existing == nil:
+		case !existing.Matches(d.config.URL):
+			plan.Conflict = fmt.Errorf("saved state is for another URL, use --force to restart")
+		case existing.TotalSize == totalSize,
+			d.config.Growing > 0 && existing.TotalSize < totalSize:
+			existing.TotalSize = totalSize
+			d.args = existing
+			plan.Resume = true
+		default:
+			plan.Conflict = fmt.Errorf("saved state is for %d bytes, use --force to restart", existing.TotalSize)
+		}
+	}
+	if !plan.Resume {
+		d.applyAutoChunkSize(totalSize)
+		d.args.ChunkSize = d.config.ChunkSize
+		if err := d.checkChunkSize(totalSize); err != nil {
+			return nil, err
+		}
+	}
+	plan.ChunkSize = d.args.ChunkSize
+
+	if d.only, err = d.resolveSelection(); err != nil {
+		return nil, err
+	}
+
+	n := d.args.NumChunks()
+	plan.Status = make([]ChunkStatus, n)
+	stored := make([]storage.ChunkState, n)
+	switch {
+	case plan.Conflict != nil:
+	case d.config.HasPipePartCmd():
+		// A fresh start forgets what was piped before
+		if plan.Resume {
+			state, err := LoadPipeState(prefix)
+			if err != nil {
+				return nil, err
+			}
+			for i := range stored {
+				stored[i].Complete = state.IsPiped(i)
+			}
+		}
+		plan.Listed = true
+	case d.config.Storage == nil:
+		local := storage.NewLocal("", prefix)
+		local.Key = d.config.Encrypt
+		if stored, err = local.ListChunks(n); err != nil {
+			return nil, err
+		}
+		plan.Listed = true
+	}
+
+	for i := range n {
+		size := d.args.ChunkSizeAt(i)
+		switch {
+		case d.only != nil && !d.only[i]:
+			// Left alone
+			if stored[i].Complete {
+				plan.Status[i] = ChunkComplete
+			} else if stored[i].Bytes > 0 {
+				plan.Status[i] = ChunkPartial
+			}
+		case d.only != nil && d.refetching():
+			plan.Remaining += size
+		case stored[i].Complete:
+			plan.Status[i] = ChunkComplete
+		case stored[i].Bytes > 0:
+			plan.Status[i] = ChunkPartial
+			if stored[i].Bytes < size {
+				plan.Remaining += size - stored[i].Bytes
+			}
+		default:
+			plan.Remaining += size
+		}
+	}
+	return plan, nil
+}
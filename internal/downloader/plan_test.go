@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := []byte(strings.Repeat("a", 250))
+	ranges := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	plan := func() *Plan {
+		t.Helper()
+		d, err := NewDownloader(Config{
+			URL:        srv.URL + "/f",
+			Prefix:     "f",
+			ChunkSize:  100,
+			HTTPConfig: httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		p, err := d.Plan(context.Background())
+		require.NoError(t, err)
+		return p
+	}
+
+	// One chunk done, one half way, one not started
+	require.NoError(t, os.WriteFile(storage.PartName("f", 0), content[:100], 0644))
+	require.NoError(t, os.WriteFile(storage.TmpName("f", 1), content[:40], 0644))
+
+	p := plan()
+	assert.Equal(t, int64(250), p.Size)
+	assert.Equal(t, []ChunkStatus{ChunkComplete, ChunkPartial, ChunkPending}, p.Status)
+	assert.Equal(t, int64(60+50), p.Remaining)
+	assert.False(t, p.Resume)
+	require.NotNil(t, p.Probe)
+	assert.True(t, p.Probe.Ranges)
+
+	ranges = false
+	assert.False(t, plan().Probe.Ranges)
+
+	// Nothing was written
+	entries, err := os.ReadDir(".")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+)
+
+// preflightNotice is how long the preflight may go unanswered before
+// rapel says what it is waiting for.
+const preflightNotice = 3 * time.Second
+
+// preflight runs fn, the request that sizes the file before anything is
+// downloaded, with Config.ProbeTimeout per attempt and up to
+// Config.ProbeRetries more attempts after a timeout or network error. A
+// dead host then fails in seconds rather than after the dial and read
+// timeouts, which are sized for chunk transfers.
+func (d *Downloader) preflight(ctx context.Context, fn func(ctx context.Context) error) error {
+	host := d.config.URL
+	if u, err := url.Parse(d.config.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if d.config.ProbeTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, d.config.ProbeTimeout)
+		}
+		notice := time.AfterFunc(preflightNotice, func() {
+			msg := fmt.Sprintf("Waiting for %s to answer...", host)
+			if d.config.ProbeTimeout > 0 {
+				msg = fmt.Sprintf("Waiting for %s to answer (giving up after %s)...", host, d.config.ProbeTimeout)
+			}
+			slog.Info(msg, "host", host)
+		})
+		err := fn(attemptCtx)
+		notice.Stop()
+		timedOut := attemptCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if timedOut {
+			err = fmt.Errorf("%s did not answer within %s (--probe-timeout): %w", host, d.config.ProbeTimeout, err)
+		}
+		var netErr net.Error
+		if attempt >= d.config.ProbeRetries || !(timedOut || errors.As(err, &netErr)) {
+			return err
+		}
+
+		wait := time.Duration(attempt+1) * time.Second
+		slog.Warn(fmt.Sprintf("%v, retrying in %s (%d/%d)", err, wait, attempt+1, d.config.ProbeRetries),
+			"attempt", attempt+1, "retries", d.config.ProbeRetries)
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(wait):
+		}
+	}
+}
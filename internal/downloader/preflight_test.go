@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflightTimeout(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	body := bytes.Repeat([]byte("x"), 100)
+	var heads atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first HEAD hangs, as a dead host would
+		if r.Method == http.MethodHead && heads.Add(1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	newDownloader := func(retries int) *Downloader {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/f.bin",
+			ChunkSize:      100,
+			MaxConcurrency: 1,
+			ProbeTimeout:   100 * time.Millisecond,
+			ProbeRetries:   retries,
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Minute},
+		})
+		require.NoError(t, err)
+		return d
+	}
+
+	start := time.Now()
+	_, _, _, err := newDownloader(0).sizeFile(context.Background())
+	assert.ErrorContains(t, err, "did not answer within 100ms")
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// A retry gets through once the host answers
+	heads.Store(0)
+	_, size, _, err := newDownloader(1).sizeFile(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+	assert.Equal(t, int32(2), heads.Load())
+}
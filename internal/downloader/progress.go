@@ -3,10 +3,14 @@ package downloader
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/redraw/rapel/internal/logging"
 )
 
 // ProgressTracker tracks download progress across all chunks.
@@ -21,18 +25,54 @@ type ProgressTracker struct {
 	startTime  time.Time
 	isTTY      bool
 	writer     io.Writer
+	action     string // "Download" or "Upload", for the completion message
 
 	// per-chunk progress (atomic; single-writer-per-chunk invariant)
 	chunkProgress []atomic.Int64 // bytes for this chunk (seeded offset + bytes added this session)
 	totalBytes    atomic.Int64   // only bytes added via AddBytes (for speed calculation)
 	chunkDone     []atomic.Bool  // set only by MarkComplete; IsChunkComplete reads this
 	chunkOnce     []sync.Once    // ensures completed count increments exactly once per chunk
+	chunkActive   []atomic.Bool  // true while a worker is downloading the chunk
+	chunkRetries  []atomic.Int32 // retry attempts made for the chunk this session
 	completed     atomic.Int32
+	diskBound     atomic.Bool // writing the chunk files holds the download back
+
+	// earlier runs of the download, set before it starts
+	earlierElapsed time.Duration
+	earlierBytes   int64
 
 	// stdout serialization and throttle only
 	printMu       sync.Mutex
 	lastPrint     time.Time
 	completedOnce sync.Once
+
+	// dashboard mode: line output is suppressed and messages are buffered
+	// for the TUI to render (guarded by printMu)
+	dashboard bool
+	messages  []string
+	chunkMap  bool // show the chunk map on the progress line (guarded by printMu)
+
+	// recent totalBytes, for RecentSpeed
+	speedMu      sync.Mutex
+	speedSamples []speedSample
+}
+
+// maxBufferedMessages caps the message backlog kept for the dashboard.
+const maxBufferedMessages = 50
+
+// RecentSpeed looks speedWindow back, sampling totalBytes at most every
+// speedSampleEvery.
+const (
+	speedWindow      = 10 * time.Second
+	speedSampleEvery = 250 * time.Millisecond
+)
+
+// chunkMapWidth is the most cells the chunk map on the progress line takes.
+const chunkMapWidth = 24
+
+type speedSample struct {
+	at    time.Time
+	bytes int64
 }
 
 // NewProgressTracker creates a tracker from download arguments.
@@ -48,12 +88,15 @@ func NewProgressTracker(args *DownloadArguments) *ProgressTracker {
 		chunkSizes:    sizes,
 		totalSize:     args.TotalSize,
 		startTime:     time.Now(),
-		isTTY:         isTerminal(os.Stdout),
+		isTTY:         isTerminal(os.Stdout) && logging.ProgressEnabled(),
 		lastPrint:     time.Now(),
-		writer:        os.Stdout,
+		writer:        logging.Console(),
+		action:        "Download",
 		chunkProgress: make([]atomic.Int64, n),
 		chunkDone:     make([]atomic.Bool, n),
 		chunkOnce:     make([]sync.Once, n),
+		chunkActive:   make([]atomic.Bool, n),
+		chunkRetries:  make([]atomic.Int32, n),
 	}
 }
 
@@ -66,6 +109,12 @@ func isTerminal(f *os.File) bool {
 	return (fileInfo.Mode() & os.ModeCharDevice) != 0
 }
 
+// SetAction names what the tracked transfer is in the completion message.
+// Call it before the transfer starts.
+func (p *ProgressTracker) SetAction(action string) {
+	p.action = action
+}
+
 // SeedChunk sets the initial chunkProgress for a resumed chunk (e.g., from .tmp file size).
 // Does NOT mark the chunk complete; the download worker must still finalize it.
 // Called by the download goroutine before writing begins. Does not update totalBytes.
@@ -83,6 +132,16 @@ func (p *ProgressTracker) MarkComplete(chunkIdx int) {
 	})
 }
 
+// Reopen undoes MarkComplete for a chunk downloaded again from its start.
+// No worker may be on the chunk.
+func (p *ProgressTracker) Reopen(chunkIdx int) {
+	if p.chunkDone[chunkIdx].Swap(false) {
+		p.chunkOnce[chunkIdx] = sync.Once{}
+		p.completed.Add(-1)
+	}
+	p.chunkProgress[chunkIdx].Store(0)
+}
+
 // AddBytes records n newly-downloaded bytes for chunkIdx.
 // Must be called from a single goroutine per chunk.
 func (p *ProgressTracker) AddBytes(chunkIdx int, n int64) {
@@ -90,6 +149,37 @@ func (p *ProgressTracker) AddBytes(chunkIdx int, n int64) {
 	p.totalBytes.Add(n)
 }
 
+// SetActive records whether a worker is currently downloading chunkIdx.
+func (p *ProgressTracker) SetActive(chunkIdx int, active bool) {
+	p.chunkActive[chunkIdx].Store(active)
+}
+
+// SetDiskBound marks whether the disk is what limits the download, which
+// progress output shows.
+func (p *ProgressTracker) SetDiskBound(bound bool) {
+	p.diskBound.Store(bound)
+}
+
+// DiskBound reports whether the disk is what limits the download.
+func (p *ProgressTracker) DiskBound() bool {
+	return p.diskBound.Load()
+}
+
+// IsActive returns true while a worker is downloading chunkIdx.
+func (p *ProgressTracker) IsActive(chunkIdx int) bool {
+	return p.chunkActive[chunkIdx].Load()
+}
+
+// AddRetry records a retry attempt for chunkIdx.
+func (p *ProgressTracker) AddRetry(chunkIdx int) {
+	p.chunkRetries[chunkIdx].Add(1)
+}
+
+// Retries returns the number of retry attempts made for chunkIdx this session.
+func (p *ProgressTracker) Retries(chunkIdx int) int {
+	return int(p.chunkRetries[chunkIdx].Load())
+}
+
 // IsChunkComplete returns true when MarkComplete has been called for this chunk.
 func (p *ProgressTracker) IsChunkComplete(chunkIdx int) bool {
 	return p.chunkDone[chunkIdx].Load()
@@ -120,37 +210,216 @@ func (p *ProgressTracker) TotalBytes() int64 {
 	return p.totalBytes.Load()
 }
 
-// PrintProgress prints current progress for the given chunk.
+// DownloadedBytes returns the bytes recorded across all chunks, including
+// seeded resume bytes.
+func (p *ProgressTracker) DownloadedBytes() int64 {
+	var total int64
+	for i := range p.chunkProgress {
+		total += p.chunkProgress[i].Load()
+	}
+	return total
+}
+
+// TotalSize returns the expected size of the whole download.
+func (p *ProgressTracker) TotalSize() int64 {
+	return p.totalSize
+}
+
+// Elapsed returns the time since the tracker was created.
+func (p *ProgressTracker) Elapsed() time.Duration {
+	return time.Since(p.startTime)
+}
+
+// ResumeFrom adds the time and bytes of earlier runs to the totals. Call
+// it before the transfer starts.
+func (p *ProgressTracker) ResumeFrom(elapsed time.Duration, bytes int64) {
+	p.earlierElapsed = elapsed
+	p.earlierBytes = bytes
+}
+
+// TotalElapsed returns the time spent over all runs, this one included.
+func (p *ProgressTracker) TotalElapsed() time.Duration {
+	return p.earlierElapsed + p.Elapsed()
+}
+
+// AllBytes returns the bytes downloaded over all runs, this one included.
+func (p *ProgressTracker) AllBytes() int64 {
+	return p.earlierBytes + p.TotalBytes()
+}
+
+// AverageSpeed returns the bytes per second over all runs, so a resumed
+// download has an estimate before its first bytes arrive.
+func (p *ProgressTracker) AverageSpeed() float64 {
+	elapsed := p.TotalElapsed().Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.AllBytes()) / elapsed
+}
+
+// SetDashboard switches dashboard mode on or off. While on, line-oriented
+// progress output is suppressed and messages are buffered for the TUI.
+func (p *ProgressTracker) SetDashboard(on bool) {
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+	p.dashboard = on
+}
+
+// RecentMessages returns up to n of the most recently buffered messages.
+func (p *ProgressTracker) RecentMessages(n int) []string {
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+
+	if n > len(p.messages) {
+		n = len(p.messages)
+	}
+	out := make([]string, n)
+	copy(out, p.messages[len(p.messages)-n:])
+	return out
+}
+
+// bufferMessage appends msg to the dashboard backlog. Caller must hold printMu.
+func (p *ProgressTracker) bufferMessage(msg string) {
+	p.messages = append(p.messages, strings.TrimRight(msg, "\n"))
+	if len(p.messages) > maxBufferedMessages {
+		p.messages = p.messages[len(p.messages)-maxBufferedMessages:]
+	}
+}
+
+// SetChunkMap adds a map of the chunks to the progress line, or takes it
+// off.
+func (p *ProgressTracker) SetChunkMap(on bool) {
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+	p.chunkMap = on
+}
+
+// RecentSpeed returns the bytes per second over the last 10 seconds or so,
+// which follows a change of pace far sooner than AverageSpeed. Until this
+// run has a second of samples it is AverageSpeed.
+func (p *ProgressTracker) RecentSpeed() float64 {
+	return p.recentSpeed(time.Now())
+}
+
+func (p *ProgressTracker) recentSpeed(now time.Time) float64 {
+	p.speedMu.Lock()
+	defer p.speedMu.Unlock()
+
+	samples := p.speedSamples
+	if len(samples) == 0 || now.Sub(samples[len(samples)-1].at) >= speedSampleEvery {
+		samples = append(samples, speedSample{at: now, bytes: p.totalBytes.Load()})
+	}
+	// Keep one sample from before the window, so it is covered in full
+	for len(samples) > 2 && now.Sub(samples[1].at) >= speedWindow {
+		samples = samples[1:]
+	}
+	p.speedSamples = samples
+
+	first, last := samples[0], samples[len(samples)-1]
+	if span := last.at.Sub(first.at); span >= time.Second {
+		return float64(last.bytes-first.bytes) / span.Seconds()
+	}
+	return p.AverageSpeed()
+}
+
+// ETA returns how long the rest of the download should take at
+// RecentSpeed, or false when nothing is coming in.
+func (p *ProgressTracker) ETA() (time.Duration, bool) {
+	return p.eta(time.Now())
+}
+
+func (p *ProgressTracker) eta(now time.Time) (time.Duration, bool) {
+	left := p.totalSize - p.DownloadedBytes()
+	if left <= 0 {
+		return 0, true
+	}
+	speed := p.recentSpeed(now)
+	if speed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(left) / speed * float64(time.Second)), true
+}
+
+// ChunkMap draws the chunks as at most width cells: # for done, - for in
+// progress, . for not started. Where a cell stands for several chunks, it
+// is done once all of them are, and in progress while any of them is or
+// has some bytes.
+func (p *ProgressTracker) ChunkMap(width int) string {
+	if width > p.numChunks {
+		width = p.numChunks
+	}
+	var b strings.Builder
+	b.Grow(width + 2)
+	b.WriteByte('[')
+	for cell := range width {
+		first, last := cell*p.numChunks/width, (cell+1)*p.numChunks/width
+		done, started := true, false
+		for i := first; i < last; i++ {
+			if !p.chunkDone[i].Load() {
+				done = false
+				started = started || p.chunkActive[i].Load() || p.chunkProgress[i].Load() > 0
+			}
+		}
+		switch {
+		case done:
+			b.WriteByte('#')
+		case started:
+			b.WriteByte('-')
+		default:
+			b.WriteByte('.')
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// statusLine is the progress line: chunks and bytes done, speed and ETA,
+// and the chunk map if asked for.
+func (p *ProgressTracker) statusLine(now time.Time) string {
+	downloaded := p.DownloadedBytes()
+	line := fmt.Sprintf("[%d/%d] %s/%s %.1f%% @ %s/s",
+		p.completed.Load(), p.numChunks,
+		formatBytes(downloaded), formatBytes(p.totalSize), percent(downloaded, p.totalSize),
+		formatBytes(int64(p.recentSpeed(now))))
+	if eta, ok := p.eta(now); ok {
+		line += " ETA " + formatDuration(eta)
+	} else {
+		line += " ETA --"
+	}
+	if p.chunkMap {
+		line += " " + p.ChunkMap(chunkMapWidth)
+	}
+	if p.diskBound.Load() {
+		line += " [disk-bound]"
+	}
+	return line
+}
+
+// PrintProgress prints the progress line, as a write to the given chunk
+// moves it on.
 func (p *ProgressTracker) PrintProgress(chunkIdx int) {
 	p.printMu.Lock()
 	defer p.printMu.Unlock()
 
+	if p.dashboard {
+		return
+	}
+
 	now := time.Now()
-	if p.isTTY && now.Sub(p.lastPrint) < 500*time.Millisecond {
+	if now.Sub(p.lastPrint) < 500*time.Millisecond {
 		return
 	}
 	p.lastPrint = now
 
 	completed := int(p.completed.Load())
-	totalDownloaded := p.totalBytes.Load()
-	elapsed := time.Since(p.startTime).Seconds()
-
-	var speed float64
-	if elapsed > 0 {
-		speed = float64(totalDownloaded) / elapsed
-	}
-
 	chunkBytes := p.chunkProgress[chunkIdx].Load()
 
 	if p.isTTY {
-		fmt.Fprintf(p.writer, "\r\033[K[%d/%d] chunk %d: %s/%s @ %s/s",
-			completed, p.numChunks,
-			chunkIdx,
-			formatBytes(chunkBytes),
-			formatBytes(p.chunkSizes[chunkIdx]),
-			formatBytes(int64(speed)))
+		fmt.Fprint(p.writer, "\r\033[K"+p.statusLine(now))
 	} else {
-		fmt.Fprintf(p.writer, "[%d/%d] chunks completed\n", completed, p.numChunks)
+		// Without a terminal, periodic progress is only useful when debugging
+		slog.Debug(fmt.Sprintf("[%d/%d] chunks completed", completed, p.numChunks),
+			"chunk", chunkIdx, "chunk_bytes", chunkBytes, "completed", completed, "total", p.numChunks)
 	}
 }
 
@@ -161,32 +430,27 @@ func (p *ProgressTracker) PrintChunkComplete(chunkIdx int) {
 
 	completed := int(p.completed.Load())
 
-	if p.isTTY {
-		fmt.Fprintf(p.writer, "\r\033[K[%d/%d] chunk %d completed\n",
-			completed, p.numChunks, chunkIdx)
-	} else {
-		fmt.Fprintf(p.writer, "[%d/%d] chunk %d completed\n",
-			completed, p.numChunks, chunkIdx)
+	if p.dashboard {
+		p.bufferMessage(fmt.Sprintf("[%d/%d] chunk %d completed", completed, p.numChunks, chunkIdx))
+		return
 	}
+
+	slog.Info(fmt.Sprintf("[%d/%d] chunk %d completed", completed, p.numChunks, chunkIdx),
+		"chunk", chunkIdx, "completed", completed, "total", p.numChunks)
 }
 
 // PrintComplete prints the final completion message.
 func (p *ProgressTracker) PrintComplete() {
 	p.completedOnce.Do(func() {
-		p.printMu.Lock()
-		defer p.printMu.Unlock()
-
-		elapsed := time.Since(p.startTime)
+		elapsed := p.TotalElapsed()
 		avgSpeed := float64(p.totalSize) / elapsed.Seconds()
 
-		if p.isTTY {
-			fmt.Fprintf(p.writer, "\r\033[K")
-		}
-
-		fmt.Fprintf(p.writer, "Download complete: %s in %s (avg %s/s)\n",
+		slog.Info(fmt.Sprintf("%s complete: %s in %s (avg %s/s)",
+			p.action,
 			formatBytes(p.totalSize),
 			formatDuration(elapsed),
-			formatBytes(int64(avgSpeed)))
+			formatBytes(int64(avgSpeed))),
+			"bytes", p.totalSize, "elapsed", elapsed, "avg_bytes_per_sec", int64(avgSpeed))
 	})
 }
 
@@ -195,37 +459,35 @@ func (p *ProgressTracker) PrintError(chunkIdx int, err error) {
 	p.printMu.Lock()
 	defer p.printMu.Unlock()
 
-	if p.isTTY {
-		fmt.Fprintf(p.writer, "\r\033[K")
+	if p.dashboard {
+		p.bufferMessage(fmt.Sprintf("chunk %d error: %v", chunkIdx, err))
+		return
 	}
-	fmt.Fprintf(p.writer, "chunk %d error: %v\n", chunkIdx, err)
+
+	slog.Error(fmt.Sprintf("chunk %d error: %v", chunkIdx, err), "chunk", chunkIdx, "error", err)
 }
 
-// PrintMessage prints a message, properly handling TTY line clearing.
+// PrintMessage logs an informational message, or buffers it in dashboard mode.
 func (p *ProgressTracker) PrintMessage(format string, args ...interface{}) {
-	p.printMu.Lock()
-	defer p.printMu.Unlock()
-
-	if p.isTTY {
-		fmt.Fprintf(p.writer, "\r\033[K")
-	}
-	fmt.Fprintf(p.writer, format, args...)
-	if len(format) == 0 || format[len(format)-1] != '\n' {
-		fmt.Fprintln(p.writer)
-	}
+	p.logMessage(fmt.Sprintf(format, args...))
 }
 
 // PrintCmdMessage prints command-related messages with visual distinction.
 func (p *ProgressTracker) PrintCmdMessage(format string, args ...interface{}) {
-	dimStart := ""
-	dimEnd := ""
-	if p.isTTY {
-		dimStart = "\033[2m"
-		dimEnd = "\033[0m"
+	p.logMessage(fmt.Sprintf(format, args...), logging.KindKey, logging.KindCmd)
+}
+
+// logMessage routes msg to the dashboard buffer or the logger.
+func (p *ProgressTracker) logMessage(msg string, attrs ...any) {
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+
+	if p.dashboard {
+		p.bufferMessage(msg)
+		return
 	}
 
-	msg := fmt.Sprintf(format, args...)
-	p.PrintMessage("%s→ %s%s", dimStart, msg, dimEnd)
+	slog.Info(strings.TrimRight(msg, "\n"), attrs...)
 }
 
 // IsTTY returns whether output is to a TTY.
@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// progressSaveInterval is how often a running download records its
+// progress.
+const progressSaveInterval = 5 * time.Second
+
+// ProgressState is what earlier runs of a download did: the time they took,
+// the bytes they downloaded and how far unfinished chunks got. It is
+// rewritten every few seconds while downloading, so a resumed run (even
+// after a crash) can report speed, ETA and total time for the whole
+// download instead of starting from zero. It lives in
+// .{prefix}-progress.json and is removed once the download completes.
+type ProgressState struct {
+	Elapsed time.Duration `json:"elapsed_ns"`       // time spent downloading
+	Bytes   int64         `json:"bytes"`            // bytes downloaded
+	Chunks  map[int]int64 `json:"chunks,omitempty"` // bytes of unfinished chunks, by index
+
+	filePath string
+}
+
+// ProgressStatePath returns the progress file for prefix.
+func ProgressStatePath(prefix string) string {
+	return registry.StatePath(prefix, "progress.json")
+}
+
+// LoadProgressState loads the progress recorded for prefix, or returns
+// nil if there is none.
+func LoadProgressState(prefix string) (*ProgressState, error) {
+	path := ProgressStatePath(prefix)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress: %w", err)
+	}
+	s := &ProgressState{filePath: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse progress %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save rewrites the progress file atomically. It keeps no backups: the
+// file is rewritten every few seconds, and losing it only loses history.
+func (s *ProgressState) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write progress: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the progress file.
+func (s *ProgressState) Delete() error {
+	err := os.Remove(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// resumeProgress carries the progress of earlier runs over into the
+// tracker. A fresh start forgets it.
+func (d *Downloader) resumeProgress(prefix string, resuming bool) {
+	state, err := LoadProgressState(prefix)
+	if err != nil {
+		// Only history is lost
+		slog.Warn(fmt.Sprintf("%v, starting the time and speed totals over", err))
+		state = nil
+	}
+	if state == nil || !resuming {
+		state = &ProgressState{filePath: ProgressStatePath(prefix)}
+	}
+	d.progressState = state
+	d.progress.ResumeFrom(state.Elapsed, state.Bytes)
+}
+
+// saveProgress records the progress so far, unless the download completed.
+func (d *Downloader) saveProgress() {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	s := d.progressState
+	if s == nil {
+		return
+	}
+
+	p := d.progress
+	s.Elapsed = p.TotalElapsed()
+	s.Bytes = p.AllBytes()
+	s.Chunks = make(map[int]int64)
+	for i := 0; i < p.NumChunks(); i++ {
+		if b := p.Bytes(i); b > 0 && !p.IsChunkComplete(i) {
+			s.Chunks[i] = b
+		}
+	}
+	if err := s.Save(); err != nil {
+		slog.Debug(err.Error())
+	}
+}
+
+// saveProgressEvery saves the progress every interval until the returned
+// function is called, which saves it a last time.
+func (d *Downloader) saveProgressEvery(interval time.Duration) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d.saveProgress()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		d.saveProgress()
+	}
+}
+
+// forgetProgress removes the progress file of a completed download.
+func (d *Downloader) forgetProgress() error {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	if d.progressState == nil {
+		return nil
+	}
+	err := d.progressState.Delete()
+	d.progressState = nil
+	return err
+}
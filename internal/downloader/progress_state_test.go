@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressStateCarriesOver(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	earlier := &ProgressState{Elapsed: time.Hour, Bytes: 500, filePath: ProgressStatePath("test")}
+	require.NoError(t, earlier.Save())
+
+	d := &Downloader{progress: newTestTracker(3000, 1000)}
+	d.resumeProgress("test", true)
+	assert.GreaterOrEqual(t, d.progress.TotalElapsed(), time.Hour)
+	assert.InDelta(t, 500.0/3600, d.progress.AverageSpeed(), 0.01)
+
+	d.progress.AddBytes(1, 200)
+	d.saveProgress()
+	saved, err := LoadProgressState("test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), saved.Bytes)
+	assert.Equal(t, map[int]int64{1: 200}, saved.Chunks)
+	assert.GreaterOrEqual(t, saved.Elapsed, time.Hour)
+
+	// A fresh start forgets the history
+	d = &Downloader{progress: newTestTracker(3000, 1000)}
+	d.resumeProgress("test", false)
+	assert.Zero(t, d.progress.AllBytes())
+	assert.Less(t, d.progress.TotalElapsed(), time.Minute)
+
+	require.NoError(t, d.forgetProgress())
+	assert.NoFileExists(t, ProgressStatePath("test"))
+	d.saveProgress()
+	assert.NoFileExists(t, ProgressStatePath("test"))
+}
+
+func TestProgressStateRemovedOnCompletion(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := []byte(strings.Repeat("a", 250))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// An earlier run got through part of the file
+	args := NewDownloadArguments(srv.URL+"/f", 250, 100, "f")
+	require.NoError(t, args.Save())
+	earlier := &ProgressState{Elapsed: time.Minute, Bytes: 100, filePath: ProgressStatePath("f")}
+	require.NoError(t, earlier.Save())
+
+	d, err := NewDownloader(Config{
+		URL:        srv.URL + "/f",
+		ChunkSize:  100,
+		HTTPConfig: httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+	assert.GreaterOrEqual(t, d.progress.TotalElapsed(), time.Minute)
+	assert.NoFileExists(t, ProgressStatePath("f"))
+}
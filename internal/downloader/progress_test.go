@@ -3,6 +3,7 @@ package downloader
 import (
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -119,3 +120,38 @@ func TestConcurrentAddBytes(t *testing.T) {
 		assert.True(t, p.IsChunkComplete(i), "chunk %d should be complete", i)
 	}
 }
+
+func TestChunkMap(t *testing.T) {
+	p := newTestTracker(8000, 1000)
+	p.MarkComplete(0)
+	p.MarkComplete(1)
+	p.SetActive(3, true)
+	p.SeedChunk(6, 10)
+	assert.Equal(t, "[##.-..-.]", p.ChunkMap(24))
+
+	// Two chunks a cell: done only once both are
+	p.MarkComplete(2)
+	assert.Equal(t, "[#-.-]", p.ChunkMap(4))
+	p.MarkComplete(3)
+	assert.Equal(t, "[##.-]", p.ChunkMap(4))
+}
+
+func TestRecentSpeed(t *testing.T) {
+	p := newTestTracker(100000, 1000)
+	start := time.Now()
+
+	// A fast start doesn't hold up the estimate once the pace drops
+	p.recentSpeed(start)
+	p.AddBytes(0, 50000)
+	for s := 1; s <= 20; s++ {
+		p.AddBytes(1, 100)
+		p.recentSpeed(start.Add(time.Duration(s) * time.Second))
+	}
+	now := start.Add(20 * time.Second)
+	assert.InDelta(t, 100, p.recentSpeed(now), 1)
+
+	eta, ok := p.eta(now)
+	assert.True(t, ok)
+	left := p.TotalSize() - p.DownloadedBytes()
+	assert.InDelta(t, float64(left)/100, eta.Seconds(), 1)
+}
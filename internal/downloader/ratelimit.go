@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by all chunk workers. A limit of 0
+// disables throttling. The limit can be changed while downloads are running.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int64   // bytes per second, 0 = unlimited
+	tokens float64 // may go negative: callers reserve before they sleep
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing limit bytes per second.
+func NewRateLimiter(limit int64) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		tokens: float64(limit),
+		last:   time.Now(),
+	}
+}
+
+// SetLimit changes the allowed rate in bytes per second (0 = unlimited).
+func (r *RateLimiter) SetLimit(limit int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+	r.tokens = 0
+	r.last = time.Now()
+}
+
+// Limit returns the current rate in bytes per second (0 = unlimited).
+func (r *RateLimiter) Limit() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limit
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done.
+func (r *RateLimiter) WaitN(ctx context.Context, n int) error {
+	r.mu.Lock()
+	if r.limit <= 0 {
+		r.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * float64(r.limit)
+	r.last = now
+	// Allow at most one second worth of burst
+	if r.tokens > float64(r.limit) {
+		r.tokens = float64(r.limit)
+	}
+
+	r.tokens -= float64(n)
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / float64(r.limit) * float64(time.Second))
+	}
+	r.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// chunkOnDisk is a chunk file found while recovering state: a .part or
+// .tmp file for one chunk, or a packed .part file for several.
+type chunkOnDisk struct {
+	name        string
+	first, last int
+	complete    bool
+	size        int64
+}
+
+// scanChunkFiles lists prefix's chunk files in the current directory.
+func scanChunkFiles(prefix string) ([]chunkOnDisk, error) {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `\.(\d+)(?:-(\d+))?\.(part|tmp)$`)
+
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunk files: %w", err)
+	}
+
+	var files []chunkOnDisk
+	for _, e := range entries {
+		m := pattern.FindStringSubmatch(e.Name())
+		if m == nil || (m[2] != "" && m[3] == "tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		c := chunkOnDisk{name: e.Name(), complete: m[3] == "part", size: info.Size()}
+		if crypt.IsEncrypted(e.Name()) {
+			if c.size, err = crypt.PlainSize(e.Name()); err != nil {
+				return nil, err
+			}
+		}
+		c.first, _ = strconv.Atoi(m[1])
+		c.last = c.first
+		if m[2] != "" {
+			c.last, _ = strconv.Atoi(m[2])
+		}
+		files = append(files, c)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].first < files[j].first })
+	return files, nil
+}
+
+// recoverArguments rebuilds the args of a download whose args file is
+// unreadable or gone, from the chunk files on disk and the size the server
+// reports now. The chunk size is taken from complete chunks; fallback
+// (-c) is used when they don't tell, e.g. when only .tmp files exist.
+// Every file must fit the resulting layout, or recovery fails rather than
+// resuming onto chunks of a different size.
+func recoverArguments(url string, totalSize, fallback int64, prefix string) (*DownloadArguments, int, error) {
+	files, err := scanChunkFiles(prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(files) == 0 {
+		return nil, 0, fmt.Errorf("cannot recover %s: no chunk files found", prefix)
+	}
+
+	var measured int64
+	for _, f := range files {
+		if f.complete {
+			measured = max(measured, f.size/int64(f.last-f.first+1))
+		}
+	}
+
+	// Report the mismatch for the measured size, the likelier layout
+	var firstErr error
+	for _, chunkSize := range []int64{measured, fallback} {
+		if chunkSize <= 0 {
+			continue
+		}
+		args := NewDownloadArguments(url, totalSize, chunkSize, prefix)
+		err := fitsLayout(args, files)
+		if err == nil {
+			return args, len(files), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, 0, fmt.Errorf("cannot recover %s: %w", prefix, firstErr)
+}
+
+// fitsLayout checks that every chunk file has the size args gives it.
+func fitsLayout(args *DownloadArguments, files []chunkOnDisk) error {
+	for _, f := range files {
+		if f.last >= args.NumChunks() {
+			return fmt.Errorf("%s is beyond the last chunk with %s chunks", f.name, formatBytes(args.ChunkSize))
+		}
+
+		var want int64
+		for i := f.first; i <= f.last; i++ {
+			want += args.ChunkSizeAt(i)
+		}
+		switch {
+		case f.complete && f.size != want:
+			return fmt.Errorf("%s is %d bytes, expected %d with %s chunks", f.name, f.size, want, formatBytes(args.ChunkSize))
+		case !f.complete && f.size > want:
+			return fmt.Errorf("%s is %d bytes, more than the %d of a chunk with %s chunks", f.name, f.size, want, formatBytes(args.ChunkSize))
+		}
+	}
+	return nil
+}
+
+// recoverState replaces an unreadable or missing args file with one
+// rebuilt by recoverArguments. A corrupt file is kept as .corrupt.
+func (d *Downloader) recoverState(prefix string, totalSize int64, loadErr error) (*DownloadArguments, error) {
+	args, source, err := d.restoreArguments(prefix, totalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if loadErr != nil {
+		path := args.filePath
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return nil, fmt.Errorf("failed to set aside corrupt args file: %w", err)
+		}
+		slog.Warn(fmt.Sprintf("%v; moved it to %s", loadErr, path+".corrupt"), "error", loadErr)
+	}
+	if err := args.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save args: %w", err)
+	}
+
+	slog.Info(fmt.Sprintf("Recovered state from %s: %d chunks of %s", source, args.NumChunks(), formatBytes(args.ChunkSize)),
+		"source", source, "chunks", args.NumChunks(), "chunk_size", args.ChunkSize)
+	return args, nil
+}
+
+// restoreArguments returns the newest backup of the args file that matches
+// the URL and size, or else args rebuilt by recoverArguments, along with
+// where they came from.
+func (d *Downloader) restoreArguments(prefix string, totalSize int64) (*DownloadArguments, string, error) {
+	path := ArgsPath(prefix)
+	for _, backup := range fsutil.Backups(path) {
+		args, err := loadArgsFile(backup)
+		if err != nil || args == nil || !args.Matches(d.config.URL) || args.TotalSize != totalSize || args.ChunkSize <= 0 {
+			continue
+		}
+		args.filePath = path
+		return args, backup, nil
+	}
+
+	args, found, err := recoverArguments(d.config.URL, totalSize, d.config.ChunkSize, prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	return args, fmt.Sprintf("%d chunk files", found), nil
+}
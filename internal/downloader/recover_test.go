@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverArguments(t *testing.T) {
+	t.Chdir(t.TempDir())
+	write := func(name string, size int) {
+		require.NoError(t, os.WriteFile(name, []byte(strings.Repeat("x", size)), 0644))
+	}
+
+	_, _, err := recoverArguments("http://example.com/f", 1050, 500, "f")
+	assert.ErrorContains(t, err, "no chunk files")
+
+	// Chunk size measured from complete chunks, not the fallback
+	write("f.000000-000001.part", 200)
+	write("f.000003.part", 100)
+	write("f.000004.tmp", 40)
+	write("f.000010.part", 50)
+	write("other.000000.part", 7)
+	args, found, err := recoverArguments("http://example.com/f", 1050, 500, "f")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), args.ChunkSize)
+	assert.Equal(t, 11, args.NumChunks())
+	assert.Equal(t, 4, found)
+
+	// A short chunk in the middle fits no layout
+	write("f.000005.part", 60)
+	_, _, err = recoverArguments("http://example.com/f", 1050, 500, "f")
+	assert.ErrorContains(t, err, "f.000005.part is 60 bytes")
+
+	// Only partial chunks: the fallback chunk size is used
+	t.Chdir(t.TempDir())
+	write("f.000001.tmp", 30)
+	args, _, err = recoverArguments("http://example.com/f", 1050, 500, "f")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), args.ChunkSize)
+	_, _, err = recoverArguments("http://example.com/f", 1050, 20, "f")
+	assert.ErrorContains(t, err, "more than the 20")
+}
+
+func TestRestoreFromBackup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := "http://example.com/f"
+	d := &Downloader{config: Config{URL: url, ChunkSize: 500}}
+
+	// A backup for another size doesn't apply; the chunk files decide
+	require.NoError(t, NewDownloadArguments(url, 999, 100, "f").Save())
+	require.NoError(t, os.Rename(".f-args.json", ".f-args.json.2"))
+	require.NoError(t, os.WriteFile("f.000000.part", []byte(strings.Repeat("x", 300)), 0644))
+	args, source, err := d.restoreArguments("f", 1050)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), args.ChunkSize)
+	assert.Equal(t, "1 chunk files", source)
+
+	require.NoError(t, NewDownloadArguments(url, 1050, 250, "f").Save())
+	require.NoError(t, os.Rename(".f-args.json", ".f-args.json.1"))
+	args, source, err = d.restoreArguments("f", 1050)
+	require.NoError(t, err)
+	assert.Equal(t, int64(250), args.ChunkSize)
+	assert.Equal(t, ".f-args.json.1", source)
+
+	// Saving the restored args writes the main file
+	require.NoError(t, args.Save())
+	assert.FileExists(t, ".f-args.json")
+}
+
+func TestRestorePipeState(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	s, err := LoadPipeState("f")
+	require.NoError(t, err)
+	s.KeepBackups(2)
+	for _, i := range []int{0, 1, 2} {
+		require.NoError(t, s.MarkPiped(i))
+	}
+	require.NoError(t, os.WriteFile(".f-piped.json", []byte("{"), 0644))
+
+	_, err = LoadPipeState("f")
+	require.Error(t, err)
+	s, err = restorePipeState("f", err)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, s.Piped)
+
+	require.NoError(t, s.Delete())
+	_, err = restorePipeState("f", assert.AnError)
+	assert.ErrorContains(t, err, "no readable backup")
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// register records the download in the central registry. It fails only on a
+// prefix collision with another unfinished download; other registry problems
+// (e.g. an unwritable home directory) are logged and ignored, and the state
+// files are then kept next to the chunks.
+func (d *Downloader) register() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("not registering download: %v", err))
+		return nil
+	}
+
+	entry := &registry.Entry{
+		ID:        registry.ID(d.args.URLHash, filepath.Join(dir, d.args.FilenamePrefix)),
+		URL:       d.args.URL,
+		URLHash:   d.args.URLHash,
+		Dir:       dir,
+		Prefix:    d.args.FilenamePrefix,
+		TotalSize: d.args.TotalSize,
+		ChunkSize: d.args.ChunkSize,
+		Status:    registry.StatusDownloading,
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+	}
+
+	if !d.config.Force {
+		other, err := registry.FindCollision(entry)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("cannot check state registry: %v", err))
+		} else if other != nil {
+			return fmt.Errorf("prefix collision: %s in %s is used by an unfinished download of %s, use --force to replace it",
+				entry.Prefix, entry.Dir, other.URL)
+		}
+	} else {
+		// --force replaces whatever was registered for this output path
+		if entries, err := registry.List(); err == nil {
+			for _, other := range entries {
+				if other.OutputPath() == entry.OutputPath() && other.ID != entry.ID {
+					registry.Remove(other.ID)
+				}
+			}
+		}
+	}
+
+	if err := registry.Save(entry); err != nil {
+		slog.Warn(fmt.Sprintf("cannot update state registry: %v", err))
+		return nil
+	}
+	d.entry = entry
+
+	// A new download keeps its state files in the registry. One with
+	// state next to its chunks already carries on there, as does a
+	// plan's share, whose directory is copied back for the merge
+	if _, err := os.Stat(ArgsPath(entry.Prefix)); os.IsNotExist(err) && d.config.Subset == nil {
+		if err := registry.CreateStateDir(entry.ID); err != nil {
+			slog.Warn(fmt.Sprintf("keeping state next to the chunks: %v", err))
+		}
+	}
+	return nil
+}
+
+// finishRegistration records the outcome of the download in the registry.
+func (d *Downloader) finishRegistration(err error) {
+	if d.entry == nil {
+		return
+	}
+
+	switch {
+	case err == nil && d.MissingChunks() > 0:
+		d.entry.Status = registry.StatusIncomplete
+	case err == nil:
+		d.entry.Status = registry.StatusComplete
+	case errors.Is(err, context.Canceled):
+		d.entry.Status = registry.StatusCancelled
+	default:
+		d.entry.Status = registry.StatusFailed
+		d.entry.Error = err.Error()
+	}
+
+	if err := registry.Save(d.entry); err != nil {
+		slog.Warn(fmt.Sprintf("cannot update state registry: %v", err))
+	}
+	if d.entry.Status == registry.StatusComplete {
+		registry.PruneStateDir(d.entry.Prefix)
+	}
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateKeptInRegistry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 30)
+	var failLast atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failLast.Load() && r.Header.Get("Range") == "bytes=200-299" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// A new download's chunks and state carry the URL's hash
+	name := "file-" + urlTag(srv.URL+"/file.bin") + ".bin"
+	download := func() error {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/file.bin",
+			ChunkSize:      100,
+			MaxConcurrency: 1,
+			TransferLog:    true,
+			Backoff:        Backoff{Base: time.Millisecond},
+			HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+
+	t.Run("new download", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		failLast.Store(true)
+		require.Error(t, download())
+
+		entries, err := registry.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		stateDir, err := registry.Dir()
+		require.NoError(t, err)
+		stateDir = filepath.Join(stateDir, entries[0].ID)
+		assert.Equal(t, filepath.Join(stateDir, "."+name+"-args.json"), ArgsPath(name))
+		assert.FileExists(t, ArgsPath(name))
+		assert.FileExists(t, ProgressStatePath(name))
+		assert.NoFileExists(t, "."+name+"-args.json")
+		assert.FileExists(t, name+".000000.part")
+
+		// The resume finds its state there, and what is left of it stays
+		failLast.Store(false)
+		require.NoError(t, download())
+		assert.NoFileExists(t, ArgsPath(name))
+		assert.Equal(t, filepath.Join(stateDir, "."+name+"-transfers.jsonl"), TransferLogPath(name))
+		assert.FileExists(t, TransferLogPath(name))
+	})
+
+	t.Run("started with state next to the chunks", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, NewDownloadArguments(srv.URL+"/file.bin", int64(len(content)), 100, "file.bin").Save())
+		failLast.Store(true)
+		require.Error(t, download())
+
+		assert.Equal(t, ".file.bin-args.json", ArgsPath("file.bin"))
+		assert.FileExists(t, ".file.bin-args.json")
+		assert.FileExists(t, ".file.bin-progress.json")
+
+		failLast.Store(false)
+		require.NoError(t, download())
+		assert.NoFileExists(t, ".file.bin-args.json")
+		assert.FileExists(t, ".file.bin-transfers.jsonl")
+	})
+
+	t.Run("registry unavailable", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		blocked := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(blocked, nil, 0644))
+		t.Setenv("RAPEL_DATA_DIR", blocked)
+		failLast.Store(true)
+		require.Error(t, download())
+		assert.FileExists(t, "."+name+"-args.json")
+	})
+}
@@ -0,0 +1,211 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+)
+
+// repairChunks finds the chunks to blame for mismatch, the file failing
+// the server's checksum, and downloads them again. Chunks whose files no
+// longer match the manifest's SHA-256 changed on disk; failing that, every
+// chunk is downloaded again without being written, and those the server
+// now sends other bytes for were received wrong. It returns false if no
+// chunk is to blame, so downloading again wouldn't help.
+func (d *Downloader) repairChunks(ctx context.Context, mismatch error, round int) (bool, error) {
+	if d.config.HasPostPartCmd() {
+		slog.Warn(fmt.Sprintf("%v; not downloading any chunk again, --post-part already had them", mismatch))
+		return false, nil
+	}
+	slog.Warn(fmt.Sprintf("%v; finding the chunks to blame (repair %d of %d)", mismatch, round, d.config.ChecksumRepairs))
+
+	bad, err := d.changedChunks()
+	if err != nil {
+		return false, err
+	}
+	if len(bad) == 0 {
+		slog.Info("Comparing every chunk with the server's bytes, which takes as long as the download...")
+		if bad, err = d.differingChunks(ctx); err != nil {
+			return false, err
+		}
+	}
+	if len(bad) == 0 {
+		slog.Warn("The server sends the same bytes again: its checksum doesn't describe them, or the file changed without changing size")
+		return false, nil
+	}
+
+	names := make([]string, len(bad))
+	for i, index := range bad {
+		names[i] = strconv.Itoa(index)
+	}
+	slog.Info(fmt.Sprintf("Downloading %d chunk(s) again: %s", len(bad), strings.Join(names, ", ")), "chunks", bad)
+	return true, d.refetchChunks(ctx, bad)
+}
+
+// changedChunks returns the chunks whose files no longer hash to the
+// SHA-256 the manifest took of them as they were downloaded. Without
+// --write-manifest there is nothing to compare them with.
+func (d *Downloader) changedChunks() ([]int, error) {
+	if d.manifest == nil {
+		return nil, nil
+	}
+	d.manifestMu.Lock()
+	parts := slices.Clone(d.manifest.Parts)
+	d.manifestMu.Unlock()
+
+	var changed []int
+	for _, part := range parts {
+		h := sha256.New()
+		if err := d.hashPart(h, part.Index); err != nil {
+			return nil, err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != part.SHA256 {
+			changed = append(changed, part.Index)
+		}
+	}
+	return changed, nil
+}
+
+// differingChunks downloads every chunk again, hashing it instead of
+// writing it, and returns those the server sends other bytes for.
+func (d *Downloader) differingChunks(ctx context.Context) ([]int, error) {
+	all := make([]int, d.args.NumChunks())
+	differs := make([]bool, len(all))
+	for i := range all {
+		all[i] = i
+	}
+	err := d.eachChunk(ctx, all, func(ctx context.Context, index int) error {
+		same, err := d.sameAsRemote(ctx, index)
+		differs[index] = !same
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bad []int
+	for i, differ := range differs {
+		if differ {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
+
+// sameAsRemote reports whether chunk index's file holds what the server
+// sends for its range now, retrying the request as a chunk's.
+func (d *Downloader) sameAsRemote(ctx context.Context, index int) (bool, error) {
+	local := sha256.New()
+	if err := d.hashPart(local, index); err != nil {
+		return false, err
+	}
+
+	start, end := d.args.ChunkRange(index)
+	var lastErr error
+	for attempt := 0; attempt <= d.config.HTTPConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := d.checkRetry(lastErr); err != nil {
+				return false, err
+			}
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(d.config.Backoff.Delay(attempt)):
+			}
+		}
+		remote := sha256.New()
+		lastErr = d.fetchRange(ctx, index, start, end, &limitedWriter{ctx: ctx, w: remote, limiter: d.limiter})
+		if lastErr == nil {
+			return bytes.Equal(local.Sum(nil), remote.Sum(nil)), nil
+		}
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+	}
+	return false, lastErr
+}
+
+// refetchChunks downloads chunks again from their start.
+func (d *Downloader) refetchChunks(ctx context.Context, chunks []int) error {
+	// The whole-file hash went past them
+	d.resetFileDigest()
+	for _, index := range chunks {
+		d.restartChunk(index)
+		d.progress.Reopen(index)
+	}
+
+	return d.eachChunk(ctx, chunks, func(ctx context.Context, index int) error {
+		start, end := d.args.ChunkRange(index)
+		d.config.Events.Emit(events.ChunkStart, "chunk", index, "start", start, "end", end)
+		if err := d.downloadChunk(ctx, index); err != nil {
+			if ctx.Err() == nil {
+				d.config.Events.Emit(events.ChunkFailed, "chunk", index, "error", err)
+			}
+			return err
+		}
+		d.progress.MarkComplete(index)
+		d.progress.PrintChunkComplete(index)
+		d.config.Events.Emit(events.ChunkComplete, "chunk", index, "bytes", d.args.ChunkSizeAt(index))
+		d.recordManifest(index)
+		return nil
+	})
+}
+
+// eachChunk runs fn for chunks, as many at once as the jobs allow. The
+// first error stops the others and is returned.
+func (d *Downloader) eachChunk(ctx context.Context, chunks []int, fn func(ctx context.Context, index int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	var wg sync.WaitGroup
+	for _, index := range chunks {
+		if err := d.jobs.acquire(ctx); err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer d.jobs.release()
+			if err := fn(ctx, index); err != nil {
+				select {
+				case errChan <- fmt.Errorf("chunk %d: %w", index, err):
+					cancel()
+				default:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errChan)
+
+	if err := <-errChan; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// limitedWriter paces writes to w with the download's rate limit.
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *RateLimiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if err := l.limiter.WaitN(l.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/failure"
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// sizeRecheckTTL is how long a check of the file's size answers for the
+// other chunks that hit the same change.
+const sizeRecheckTTL = 5 * time.Second
+
+// sizeRecheck is the last check of the file's size made after a range
+// answer put it at another size.
+type sizeRecheck struct {
+	mu  sync.Mutex
+	at  time.Time
+	err error
+}
+
+// recheckSize handles err from a request of chunk index if it is a range
+// answer that put the file at another size (a *httpclient.SizeError), as
+// an expired URL or a replaced file may give: the size is asked for
+// again, as at the start. If it is still what the download was planned
+// for, the chunk goes on retrying, as one cache or mirror answered wrong.
+// If it changed, or the URL no longer answers, every retry would fail the
+// same way or mix two files' bytes, so it returns an error that stops the
+// download, keeping the chunks.
+func (d *Downloader) recheckSize(ctx context.Context, index int, err error) error {
+	var sizeErr *httpclient.SizeError
+	if !errors.As(err, &sizeErr) {
+		return nil
+	}
+	c := &d.sizeRecheck
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.at) < sizeRecheckTTL {
+		return c.err
+	}
+
+	d.progress.PrintMessage("chunk %d: %v, checking the file's size again", index, sizeErr)
+	size, err := d.remoteSize(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	c.at = time.Now()
+	switch {
+	case err != nil && sizeErr.Size > 0 && sizeErr.Size != d.args.TotalSize:
+		size = sizeErr.Size
+	case err != nil:
+		c.err = fmt.Errorf("%v, and the URL no longer answers (%w); the chunks are kept, run again with a working URL to resume", sizeErr, err)
+		return c.err
+	}
+	if size != d.args.TotalSize {
+		c.err = failure.Errorf(failure.ErrSizeMismatch, "the file changed on the server: it is %d bytes now, not %d; the chunks are kept, --force starts over",
+			size, d.args.TotalSize)
+		return c.err
+	}
+	d.progress.PrintMessage("the server still gives the file as %d bytes, continuing", size)
+	c.err = nil
+	return nil
+}
+
+// remoteSize asks the server for the file's size, as sizeFile does before
+// the download.
+func (d *Downloader) remoteSize(ctx context.Context) (int64, error) {
+	if d.config.URLCmd != "" {
+		return d.sizeSigned(ctx)
+	}
+	var remote httpclient.RemoteFile
+	err := d.preflight(ctx, func(ctx context.Context) error {
+		var err error
+		remote, err = d.client.Head(ctx, d.config.URL)
+		return err
+	})
+	return remote.Size, err
+}
@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/failure"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecheckSize(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 30)
+
+	// resizeServer serves content, giving its size as headSize to HEAD
+	// requests from the second on (0 = refused with 403), and as the total
+	// of the first answer for chunk 1, which is a 416 if rangeSize is 0.
+	resizeServer := func(headSize, rangeSize int) *httptest.Server {
+		var heads atomic.Int32
+		var resized atomic.Bool
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				size := len(content)
+				if heads.Add(1) > 1 {
+					size = headSize
+				}
+				if size == 0 {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(size))
+				return
+			}
+			var start, end int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			size := len(content)
+			if start == 100 && resized.CompareAndSwap(false, true) {
+				if rangeSize == 0 {
+					w.Header().Set("Content-Range", "bytes */"+strconv.Itoa(size))
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				size = rangeSize
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[start : end+1])
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	download := func(srv *httptest.Server) error {
+		d, err := NewDownloader(Config{
+			URL:            srv.URL + "/f",
+			Prefix:         "f",
+			ChunkSize:      100,
+			MaxConcurrency: 1,
+			NoEndgame:      true,
+			Backoff:        Backoff{Base: time.Millisecond},
+			HTTPConfig:     httpclient.Config{MaxRetries: 3, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+		})
+		require.NoError(t, err)
+		return d.Download(context.Background())
+	}
+
+	// The server still gives the planned size: one wrong answer is retried
+	t.Run("same", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		require.NoError(t, download(resizeServer(len(content), 500)))
+		data, err := os.ReadFile("f.000001.part")
+		require.NoError(t, err)
+		assert.Equal(t, content[100:200], data)
+	})
+
+	// The file was replaced: the download stops, keeping the chunks
+	t.Run("changed", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		err := download(resizeServer(500, 500))
+		assert.ErrorIs(t, err, failure.ErrSizeMismatch)
+		assert.ErrorContains(t, err, "the file changed on the server: it is 500 bytes now, not 300")
+		assert.FileExists(t, "f.000000.part")
+		args, err := LoadDownloadArguments("f")
+		require.NoError(t, err)
+		assert.Equal(t, int64(300), args.TotalSize)
+	})
+
+	// An expired URL: a 416, and HEAD refused
+	t.Run("expired", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+		err := download(resizeServer(0, 0))
+		assert.ErrorIs(t, err, failure.ErrAuth)
+		assert.ErrorContains(t, err, "server refused the range as past the end of the 300-byte file (status 416), and the URL no longer answers")
+	})
+}
@@ -0,0 +1,345 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// resliceSuffix marks a chunk file a re-slice moved out of the way, on
+// its way to its new name.
+const resliceSuffix = ".reslice"
+
+// ReslicePath returns the journal of a re-slice of prefix that hasn't
+// finished yet.
+func ReslicePath(prefix string) string {
+	return registry.StatePath(prefix, "reslice.json")
+}
+
+// resliceJournal is a re-slice being applied. Kept chunks get new
+// indexes, so their files are renamed; the journal is written before the
+// first rename, and a run that finds it finishes the renames before
+// anything else looks at the chunks.
+type resliceJournal struct {
+	ChunkSize int64       `json:"chunk_size"`
+	Layout    []Segment   `json:"layout,omitempty"`
+	Chunks    map[int]int `json:"chunks"`  // new index of each kept chunk, by old index
+	Renames   [][2]string `json:"renames"` // chunk files to move, from and to
+	Moved     bool        `json:"moved"`   // every file is at its new name plus resliceSuffix
+
+	filePath string
+}
+
+// save writes the journal atomically.
+func (j *resliceJournal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal re-slice: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(j.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write re-slice: %w", err)
+	}
+	return nil
+}
+
+// maybeReslice re-slices the chunks no run has started into -c sized
+// ones when -c differs from the chunk size the download started with.
+// Started chunks, complete or not, keep their bytes and their size. When
+// renumbering the chunks would leave other state pointing at the wrong
+// ones, the download keeps its layout and says so.
+func (d *Downloader) maybeReslice() error {
+	if !d.config.ExplicitChunkSize || d.config.ChunkSize == d.args.ChunkSize {
+		return nil
+	}
+	keeping := fmt.Sprintf("Keeping the chunk size of %s", formatBytes(d.args.ChunkSize))
+	reason, err := d.resliceBlocked()
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		slog.Warn(fmt.Sprintf("%s: -c %s doesn't re-slice a download %s", keeping, formatBytes(d.config.ChunkSize), reason))
+		return nil
+	}
+	if err := d.checkChunkSize(d.args.TotalSize); err != nil {
+		return err
+	}
+
+	started, err := d.args.startedChunks()
+	if err != nil {
+		return err
+	}
+	layout, chunks, ok := d.args.resliceLayout(started, d.config.ChunkSize)
+	if !ok {
+		slog.Info(keeping + ": every chunk has started")
+		return nil
+	}
+	before := d.args.NumChunks()
+	j := d.args.newResliceJournal(layout, chunks, d.config.ChunkSize)
+	if err := j.save(); err != nil {
+		return err
+	}
+	if err := d.args.applyReslice(j); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Re-sliced the rest into %s chunks: %d chunks instead of %d, %d kept as they were",
+		formatBytes(d.args.ChunkSize), d.args.NumChunks(), before, len(chunks)),
+		"chunk_size", d.args.ChunkSize, "chunks", d.args.NumChunks(), "before", before, "kept", len(chunks))
+	return nil
+}
+
+// resliceBlocked returns why the download's chunks can't be renumbered,
+// or "" if they can.
+func (d *Downloader) resliceBlocked() (string, error) {
+	switch {
+	case d.config.Storage != nil:
+		return "stored with --storage", nil
+	case d.config.HasPipePartCmd():
+		return "with --pipe-part", nil
+	case d.config.Growing > 0 || d.config.Follow:
+		return "that is still growing", nil
+	case len(d.config.OnlyChunks) > 0 || len(d.config.Subset) > 0:
+		return "with chunks selected by number", nil
+	}
+	if _, err := os.Stat(DeadLetterPath(d.args.FilenamePrefix)); err == nil {
+		return "with failed --post-part commands on record", nil
+	}
+	packed, err := storage.NewLocal("", d.args.FilenamePrefix).PackedRanges()
+	if err != nil {
+		return "", err
+	}
+	if len(packed) > 0 {
+		return "with packed parts", nil
+	}
+	return "", nil
+}
+
+// startedChunks reports which chunks have a file: a .part, a .tmp, or the
+// .tail of a .tmp.
+func (a *DownloadArguments) startedChunks() ([]bool, error) {
+	started := make([]bool, a.NumChunks())
+	for i := range started {
+		for _, path := range []string{a.PartPath(i), a.TmpPath(i), a.TmpPath(i) + ".tail"} {
+			_, err := os.Stat(path)
+			if err == nil {
+				started[i] = true
+				break
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to check chunk %d: %w", i, err)
+			}
+		}
+	}
+	return started, nil
+}
+
+// resliceLayout cuts each run of chunks that haven't started into
+// chunkSize chunks, leaving started ones as they are. It returns the new
+// layout (nil when that is chunkSize throughout) and the new index of
+// every started chunk, by old index. ok is false if there is no run to
+// cut.
+func (a *DownloadArguments) resliceLayout(started []bool, chunkSize int64) (layout []Segment, chunks map[int]int, ok bool) {
+	add := func(seg Segment) {
+		// Joined to the segment before if it continues its chunks
+		if n := len(layout); n > 0 {
+			last := &layout[n-1]
+			if last.Size%last.ChunkSize == 0 && (seg.ChunkSize == last.ChunkSize || seg.Size <= min(seg.ChunkSize, last.ChunkSize)) {
+				last.Size += seg.Size
+				return
+			}
+		}
+		layout = append(layout, seg)
+	}
+
+	var kept []int
+	gap := int64(-1) // where the current run of chunks not started began
+	for i, s := range started {
+		start, end := a.ChunkRange(i)
+		if !s {
+			if gap < 0 {
+				gap = start
+			}
+			continue
+		}
+		if gap >= 0 {
+			add(Segment{Offset: gap, Size: start - gap, ChunkSize: chunkSize})
+			gap, ok = -1, true
+		}
+		add(Segment{Offset: start, Size: end + 1 - start, ChunkSize: end + 1 - start})
+		kept = append(kept, i)
+	}
+	if gap >= 0 {
+		add(Segment{Offset: gap, Size: a.TotalSize - gap, ChunkSize: chunkSize})
+		ok = true
+	}
+	if !ok {
+		return nil, nil, false
+	}
+	if len(layout) == 1 && layout[0].ChunkSize == chunkSize {
+		layout = nil
+	}
+
+	sliced := DownloadArguments{TotalSize: a.TotalSize, ChunkSize: chunkSize}
+	sliced.setLayout(layout)
+	chunks = make(map[int]int, len(kept))
+	for _, i := range kept {
+		start, _ := a.ChunkRange(i)
+		chunks[i] = sliced.ChunkAt(start)
+	}
+	return layout, chunks, true
+}
+
+// newResliceJournal returns the journal of moving to layout, listing the
+// files of the kept chunks that change index.
+func (a *DownloadArguments) newResliceJournal(layout []Segment, chunks map[int]int, chunkSize int64) *resliceJournal {
+	j := &resliceJournal{
+		ChunkSize: chunkSize,
+		Layout:    layout,
+		Chunks:    chunks,
+		Renames:   [][2]string{},
+		filePath:  ReslicePath(a.FilenamePrefix),
+	}
+	for old := 0; old < a.NumChunks(); old++ {
+		i, kept := chunks[old]
+		if !kept || i == old {
+			continue
+		}
+		for _, name := range []func(int) string{
+			a.PartPath,
+			func(i int) string { return manifest.SidecarPath(a.PartPath(i)) },
+			a.TmpPath,
+			func(i int) string { return a.TmpPath(i) + ".tail" },
+		} {
+			if _, err := os.Stat(name(old)); err == nil {
+				j.Renames = append(j.Renames, [2]string{name(old), name(i)})
+			}
+		}
+	}
+	return j
+}
+
+// finishReslice completes a re-slice an earlier run was stopped in the
+// middle of, if there is one.
+func (a *DownloadArguments) finishReslice() error {
+	path := ReslicePath(a.FilenamePrefix)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read re-slice: %w", err)
+	}
+	j := &resliceJournal{filePath: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return fmt.Errorf("failed to parse re-slice %s: %w", path, err)
+	}
+	slog.Info("Finishing the re-slice into "+formatBytes(j.ChunkSize)+" chunks an earlier run started", "chunk_size", j.ChunkSize)
+	return a.applyReslice(j)
+}
+
+// applyReslice moves the chunk files as j says, in two steps so a file
+// never lands on one not moved yet, then makes j's layout a's and
+// renumbers the state that goes by chunk index. Every step can be run
+// again after a crash.
+func (a *DownloadArguments) applyReslice(j *resliceJournal) error {
+	if !j.Moved {
+		for _, r := range j.Renames {
+			if err := os.Rename(r[0], r[1]+resliceSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to move %s: %w", r[0], err)
+			}
+		}
+		j.Moved = true
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+	for _, r := range j.Renames {
+		if err := os.Rename(r[1]+resliceSuffix, r[1]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to move %s: %w", r[1], err)
+		}
+	}
+
+	prefix := a.FilenamePrefix
+	for _, i := range j.Chunks {
+		renumberSidecar(a.PartPath(i), i)
+	}
+	a.ChunkSize = j.ChunkSize
+	a.setLayout(j.Layout)
+	if err := a.Save(); err != nil {
+		return fmt.Errorf("failed to save args: %w", err)
+	}
+	// Backups describe the chunks as they were numbered before
+	fsutil.RemoveBackups(a.filePath)
+
+	if err := j.renumberManifest(prefix); err != nil {
+		return err
+	}
+	// The bytes recorded per chunk and the whole-file hash go by index;
+	// both only save work, so they are dropped rather than renumbered
+	if progress, err := LoadProgressState(prefix); err == nil && progress != nil && progress.Chunks != nil {
+		progress.Chunks = nil
+		if err := progress.Save(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(fileDigestPath(prefix)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove the file hash state: %w", err)
+	}
+	if err := os.Remove(j.filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove re-slice: %w", err)
+	}
+	return nil
+}
+
+// renumberManifest renumbers the parts listed in prefix's manifest, if it
+// has one, dropping the parts of chunks that weren't kept. A manifest
+// already at the new chunk size is done.
+func (j *resliceJournal) renumberManifest(prefix string) error {
+	path := manifest.PathFor(prefix)
+	m, err := manifest.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if m.ChunkSize == j.ChunkSize {
+		return nil
+	}
+	parts := m.Parts[:0]
+	for _, p := range m.Parts {
+		if i, kept := j.Chunks[p.Index]; kept {
+			p.Index, p.Name = i, storage.PartName(prefix, i)
+			parts = append(parts, p)
+		}
+	}
+	m.Parts, m.ChunkSize = parts, j.ChunkSize
+	return m.Save(path)
+}
+
+// renumberSidecar points the --part-meta sidecar of the part at partPath,
+// if there is one, at the part's new index. Its offsets, what places the
+// part, are unchanged. A failure only costs the metadata.
+func renumberSidecar(partPath string, index int) {
+	path := manifest.SidecarPath(partPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var meta manifest.PartMeta
+	if json.Unmarshal(data, &meta) != nil || meta.Index == index {
+		return
+	}
+	meta.Index, meta.Name = index, filepath.Base(partPath)
+	if err := manifest.WriteSidecar(partPath, meta); err != nil {
+		slog.Warn(fmt.Sprintf("chunk %d: failed to renumber its metadata: %v", index, err), "chunk", index)
+	}
+}
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResliceLayout(t *testing.T) {
+	a := NewDownloadArguments("https://x/f", 950, 100, "f")
+	started := []bool{true, true, false, false, false, true, false, true, false, false}
+
+	layout, chunks, ok := a.resliceLayout(started, 250)
+	require.True(t, ok)
+	// Chunk 6 is too short a run to cut, so it stays with 5 and 7
+	assert.Equal(t, []Segment{
+		{Offset: 0, Size: 200, ChunkSize: 100},
+		{Offset: 200, Size: 300, ChunkSize: 250},
+		{Offset: 500, Size: 300, ChunkSize: 100},
+		{Offset: 800, Size: 150, ChunkSize: 250},
+	}, layout)
+	assert.Equal(t, map[int]int{0: 0, 1: 1, 5: 4, 7: 6}, chunks)
+
+	b := DownloadArguments{TotalSize: 950, ChunkSize: 250}
+	b.setLayout(layout)
+	require.NoError(t, b.checkLayout())
+	assert.Equal(t, 8, b.NumChunks())
+	var ranges [][2]int64
+	for i := 0; i < b.NumChunks(); i++ {
+		start, end := b.ChunkRange(i)
+		ranges = append(ranges, [2]int64{start, end})
+		assert.Equal(t, i, b.ChunkAt(start))
+		assert.Equal(t, i, b.ChunkAt(end))
+	}
+	assert.Equal(t, [][2]int64{{0, 99}, {100, 199}, {200, 449}, {450, 499}, {500, 599}, {600, 699}, {700, 799}, {800, 949}}, ranges)
+
+	// Nothing started: the new size throughout
+	layout, chunks, ok = a.resliceLayout(make([]bool, 10), 250)
+	assert.True(t, ok)
+	assert.Nil(t, layout)
+	assert.Empty(t, chunks)
+
+	// Everything started: nothing to cut
+	all := []bool{true, true, true, true, true, true, true, true, true, true}
+	_, _, ok = a.resliceLayout(all, 250)
+	assert.False(t, ok)
+}
+
+func TestReslice(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789abcdefghi"), 50)
+	var gets atomic.Int32
+	srv := completeServer(t, content, &gets)
+	config := Config{
+		URL:            srv.URL + "/f",
+		Prefix:         "f",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		WriteManifest:  true,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	}
+
+	// An earlier run got chunks 0, 1 and 5, and part of 7
+	first := config
+	first.OnlyChunks = []Span{{Start: 0, End: 1}, {Start: 5, End: 5}}
+	d, err := NewDownloader(first)
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+	require.NoError(t, os.WriteFile("f.000007.tmp", content[700:730], 0644))
+
+	config.ChunkSize = 250
+	config.ExplicitChunkSize = true
+	d, err = NewDownloader(config)
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	// Chunk 7's 30 bytes weren't fetched again
+	assert.Equal(t, int64(950-300-30), d.client.Traffic().Bytes)
+	args := d.GetArguments()
+	assert.Equal(t, int64(250), args.ChunkSize)
+	assert.Equal(t, 8, args.NumChunks())
+	var merged []byte
+	for i := 0; i < args.NumChunks(); i++ {
+		data, err := os.ReadFile(args.PartPath(i))
+		require.NoError(t, err)
+		assert.Equal(t, args.ChunkSizeAt(i), int64(len(data)), "chunk %d", i)
+		merged = append(merged, data...)
+	}
+	assert.Equal(t, content, merged)
+	assert.NoFileExists(t, ReslicePath("f"))
+	// Kept for the merge to check the chunks against
+	stored, err := LoadDownloadArguments("f")
+	require.NoError(t, err)
+	assert.Equal(t, args.Layout, stored.Layout)
+	assert.Empty(t, fsutil.Backups(".f-args.json"))
+
+	m, err := manifest.Load(manifest.PathFor("f"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(250), m.ChunkSize)
+	require.Len(t, m.Parts, 8)
+	assert.Equal(t, manifest.Part{Index: 4, Name: "f.000004.part", Start: 500, End: 599, Size: 100,
+		SHA256: m.Parts[4].SHA256, MD5: m.Parts[4].MD5}, m.Parts[4])
+}
+
+func TestFinishReslice(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a := NewDownloadArguments("https://x/f", 400, 100, "f")
+	require.NoError(t, a.Save())
+	require.NoError(t, os.WriteFile(a.PartPath(1), []byte("one"), 0644))
+	require.NoError(t, os.WriteFile(a.PartPath(2), []byte("two"), 0644))
+
+	// Chunk 0 cut in two pushes 1 and 2 onto each other's names
+	layout, chunks, ok := a.resliceLayout([]bool{false, true, true, false}, 50)
+	require.True(t, ok)
+	assert.Equal(t, map[int]int{1: 2, 2: 3}, chunks)
+	j := a.newResliceJournal(layout, chunks, 50)
+	require.NoError(t, j.save())
+	// Stopped after the first file was moved aside
+	require.NoError(t, os.Rename(a.PartPath(1), a.PartPath(2)+resliceSuffix))
+
+	loaded, err := LoadDownloadArguments("f")
+	require.NoError(t, err)
+	require.NoError(t, loaded.finishReslice())
+	assert.NoFileExists(t, ReslicePath("f"))
+	for i, want := range map[int]string{2: "one", 3: "two"} {
+		data, err := os.ReadFile(loaded.PartPath(i))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+	assert.NoFileExists(t, a.PartPath(1))
+
+	loaded, err = LoadDownloadArguments("f")
+	require.NoError(t, err)
+	assert.Equal(t, 6, loaded.NumChunks())
+	start, end := loaded.ChunkRange(2)
+	assert.Equal(t, []int64{100, 199}, []int64{start, end})
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/redraw/rapel/internal/rpc"
+	"github.com/redraw/rapel/schema"
+)
+
+// liveDownload is what the --rpc-socket reports on while downloading.
+type liveDownload struct {
+	file     string
+	progress *ProgressTracker
+}
+
+// Pause stops the transfers until Resume, as a closed --schedule window
+// does: a transfer cut off continues where it stopped once resumed. Its
+// chunks stay counted as active meanwhile.
+func (d *Downloader) Pause() {
+	if !d.pause.pausedFor(pauseRequest) {
+		d.printMessage("Paused on request")
+	}
+	d.pause.set(pauseRequest, true)
+}
+
+// Resume undoes Pause. Outside the --schedule windows the download stays
+// paused until the next one opens.
+func (d *Downloader) Resume() {
+	if d.pause.pausedFor(pauseRequest) {
+		d.printMessage("Resuming on request")
+	}
+	d.pause.set(pauseRequest, false)
+}
+
+// printMessage prints through the progress tracker once there is one.
+func (d *Downloader) printMessage(format string, args ...any) {
+	if live := d.live.Load(); live != nil {
+		live.progress.PrintMessage(format, args...)
+	}
+}
+
+// RPCState returns the download's state as the --rpc-socket reports it.
+// It is safe to call while Download runs.
+func (d *Downloader) RPCState() schema.RPCState {
+	state := schema.RPCState{
+		State: schema.RPCStarting,
+		Jobs:  d.Concurrency(),
+		Limit: d.RateLimit(),
+	}
+	live := d.live.Load()
+	switch {
+	case d.finished.Load():
+		state.State = schema.RPCFinished
+	case live == nil:
+		return state
+	case d.pause.isPaused():
+		state.State = schema.RPCPaused
+	default:
+		state.State = schema.RPCDownloading
+	}
+	if live == nil {
+		return state
+	}
+
+	p := live.progress
+	state.File = live.file
+	state.Size = p.TotalSize()
+	state.Bytes = p.DownloadedBytes()
+	state.Chunks = p.NumChunks()
+	state.Completed = p.CompletedCount()
+	for i := range state.Chunks {
+		if p.IsActive(i) {
+			state.Active++
+		}
+	}
+	if state.State == schema.RPCDownloading {
+		state.Speed = p.RecentSpeed()
+		if eta, ok := p.ETA(); ok {
+			state.ETA = eta.Seconds()
+		}
+	}
+	return state
+}
+
+// HandleRPC executes an --rpc-socket request about the download: status,
+// set, pause or resume. Cancelling is up to the caller, which owns the
+// download's context.
+func (d *Downloader) HandleRPC(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case schema.RPCStatus:
+	case schema.RPCSet:
+		var set schema.RPCSetParams
+		if err := json.Unmarshal(params, &set); err != nil {
+			return nil, fmt.Errorf("%w: %v", rpc.ErrInvalidParams, err)
+		}
+		// Validate everything before applying anything
+		if set.Jobs != nil && *set.Jobs < 1 {
+			return nil, fmt.Errorf("%w: invalid jobs %d, want a number >= 1", rpc.ErrInvalidParams, *set.Jobs)
+		}
+		if set.Limit != nil && *set.Limit < 0 {
+			return nil, fmt.Errorf("%w: invalid limit %d, want bytes per second >= 0", rpc.ErrInvalidParams, *set.Limit)
+		}
+		if set.Jobs != nil {
+			d.SetConcurrency(*set.Jobs)
+		}
+		if set.Limit != nil {
+			d.SetRateLimit(*set.Limit)
+		}
+		if d.live.Load() != nil {
+			d.printSettings()
+		}
+	case schema.RPCPause:
+		d.Pause()
+	case schema.RPCResume:
+		d.Resume()
+	default:
+		return nil, fmt.Errorf("%w: %s", rpc.ErrMethodNotFound, method)
+	}
+	return d.RPCState(), nil
+}
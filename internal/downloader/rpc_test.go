@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/redraw/rapel/internal/rpc"
+	"github.com/redraw/rapel/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleRPC(t *testing.T) {
+	d := &Downloader{
+		jobs:    newJobGate(2),
+		limiter: NewRateLimiter(0),
+		pause:   newPauseGate(),
+	}
+	state := func(method, params string) schema.RPCState {
+		t.Helper()
+		result, err := d.HandleRPC(method, json.RawMessage(params))
+		require.NoError(t, err)
+		return result.(schema.RPCState)
+	}
+
+	assert.Equal(t, schema.RPCState{State: schema.RPCStarting, Jobs: 2}, state(schema.RPCStatus, ""))
+
+	args := NewDownloadArguments("https://example.com/f", 100, 10, "f")
+	d.progress = NewProgressTracker(args)
+	d.live.Store(&liveDownload{file: "f", progress: d.progress})
+	d.progress.SeedChunk(0, 10)
+	d.progress.MarkComplete(0)
+	d.progress.SetActive(1, true)
+	s := state(schema.RPCSet, `{"jobs":4}`)
+	assert.Equal(t, schema.RPCDownloading, s.State)
+	assert.Equal(t, "f", s.File)
+	assert.Equal(t, int64(100), s.Size)
+	assert.Equal(t, int64(10), s.Bytes)
+	assert.Equal(t, 10, s.Chunks)
+	assert.Equal(t, 1, s.Completed)
+	assert.Equal(t, 1, s.Active)
+	assert.Equal(t, 4, s.Jobs)
+
+	// Invalid params change nothing
+	_, err := d.HandleRPC(schema.RPCSet, json.RawMessage(`{"jobs":8,"limit":-1}`))
+	assert.ErrorIs(t, err, rpc.ErrInvalidParams)
+	_, err = d.HandleRPC(schema.RPCSet, json.RawMessage(`[]`))
+	assert.ErrorIs(t, err, rpc.ErrInvalidParams)
+	assert.Equal(t, 4, d.Concurrency())
+	_, err = d.HandleRPC("restart", nil)
+	assert.ErrorIs(t, err, rpc.ErrMethodNotFound)
+
+	// A resume doesn't open a closed schedule window
+	assert.Equal(t, schema.RPCPaused, state(schema.RPCPause, "").State)
+	d.pause.set(pauseSchedule, true)
+	assert.Equal(t, schema.RPCPaused, state(schema.RPCResume, "").State)
+	d.pause.set(pauseSchedule, false)
+	assert.Equal(t, schema.RPCDownloading, state(schema.RPCStatus, "").State)
+
+	d.finished.Store(true)
+	assert.Equal(t, schema.RPCFinished, state(schema.RPCStatus, "").State)
+}
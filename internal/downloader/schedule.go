@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+)
+
+// errPaused aborts an in-flight transfer when the download pauses, as the
+// schedule closes or a pause is asked for. It is not a failure: the chunk
+// waits to be resumed and continues from its .tmp file without using up a
+// retry.
+var errPaused = errors.New("paused")
+
+// Reasons a pauseGate is closed for. It opens once none is left, so a
+// resume asked for doesn't open a closed schedule window.
+const (
+	pauseSchedule = 1 << iota // outside the --schedule windows
+	pauseRequest              // asked for over the --rpc-socket
+)
+
+// pauseGate blocks transfers while paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	reasons int
+	resume  chan struct{} // closed when unpaused
+}
+
+func newPauseGate() *pauseGate {
+	ch := make(chan struct{})
+	close(ch)
+	return &pauseGate{resume: ch}
+}
+
+// set pauses or resumes for reason.
+func (g *pauseGate) set(reason int, paused bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	reasons := g.reasons &^ reason
+	if paused {
+		reasons |= reason
+	}
+	switch {
+	case g.reasons == 0 && reasons != 0:
+		g.resume = make(chan struct{})
+	case g.reasons != 0 && reasons == 0:
+		close(g.resume)
+	}
+	g.reasons = reasons
+}
+
+// isPaused reports whether transfers should stop.
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reasons != 0
+}
+
+// pausedFor reports whether the gate is closed for reason.
+func (g *pauseGate) pausedFor(reason int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reasons&reason != 0
+}
+
+// wait blocks until unpaused or ctx is done.
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pausingWriter fails writes with errPaused once the gate closes.
+type pausingWriter struct {
+	w       io.Writer
+	pause   *pauseGate
+	written int64
+}
+
+func (p *pausingWriter) Write(b []byte) (int, error) {
+	if p.pause.isPaused() {
+		return 0, errPaused
+	}
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	return n, err
+}
+
+// runSchedule re-applies the schedule at each window boundary until ctx
+// is done. next is the boundary returned by the initial applySchedule.
+func (d *Downloader) runSchedule(ctx context.Context, next time.Time) {
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		next = d.applySchedule(time.Now())
+	}
+}
+
+// applySchedule pauses, resumes or re-rates the download for the window
+// active at now, returning when the schedule next needs checking.
+func (d *Downloader) applySchedule(now time.Time) time.Time {
+	window, next := d.config.Schedule.At(now)
+
+	if window == nil {
+		if !d.pause.pausedFor(pauseSchedule) {
+			d.progress.PrintMessage("Outside download schedule, pausing until %s", next.Format("15:04"))
+			d.config.Events.Emit(events.Paused, "until", next.Format(time.RFC3339))
+		}
+		d.pause.set(pauseSchedule, true)
+	} else {
+		rate := d.config.RateLimit
+		if window.Rate >= 0 {
+			rate = window.Rate
+		}
+		d.SetRateLimit(rate)
+
+		if d.pause.pausedFor(pauseSchedule) {
+			d.progress.PrintMessage("Download window %s open, resuming", window)
+			d.config.Events.Emit(events.Resumed, "window", window.String())
+		}
+		d.pause.set(pauseSchedule, false)
+	}
+
+	return next
+}
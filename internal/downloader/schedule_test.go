@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseGate(t *testing.T) {
+	g := newPauseGate()
+	require.NoError(t, g.wait(context.Background()))
+
+	g.set(pauseSchedule, true)
+	g.set(pauseRequest, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, g.wait(ctx), context.DeadlineExceeded)
+
+	done := make(chan error, 1)
+	go func() { done <- g.wait(context.Background()) }()
+	// Still closed for the other reason
+	g.set(pauseRequest, false)
+	assert.True(t, g.isPaused())
+	assert.True(t, g.pausedFor(pauseSchedule))
+	assert.False(t, g.pausedFor(pauseRequest))
+	g.set(pauseSchedule, false)
+	assert.NoError(t, <-done)
+}
+
+func TestPausingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	g := newPauseGate()
+	w := &pausingWriter{w: &buf, pause: g}
+
+	_, err := w.Write([]byte("abc"))
+	require.NoError(t, err)
+
+	g.set(pauseSchedule, true)
+	_, err = w.Write([]byte("def"))
+	assert.ErrorIs(t, err, errPaused)
+	assert.Equal(t, int64(3), w.written)
+	assert.Equal(t, "abc", buf.String())
+}
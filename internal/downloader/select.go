@@ -0,0 +1,168 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// Span is an inclusive range of chunk indexes or byte offsets. End -1
+// means "to the end".
+type Span struct {
+	Start int64
+	End   int64
+}
+
+// ParseChunkSpans parses a comma-separated list of chunk indexes and
+// inclusive index ranges, e.g. "5,17,200-230".
+func ParseChunkSpans(s string) ([]Span, error) {
+	return parseSpans(s, func(v string) (int64, error) {
+		return strconv.ParseInt(v, 10, 64)
+	}, false)
+}
+
+// ParseByteSpans parses a comma-separated list of byte ranges START-END,
+// where END is exclusive so "1G-2G" is exactly one gigabyte, and an empty
+// END means the end of the file. parseSize converts offsets to bytes.
+func ParseByteSpans(s string, parseSize func(string) (int64, error)) ([]Span, error) {
+	return parseSpans(s, parseSize, true)
+}
+
+func parseSpans(s string, parseNum func(string) (int64, error), bytes bool) ([]Span, error) {
+	var spans []Span
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		startStr, endStr, isRange := strings.Cut(spec, "-")
+		if bytes && !isRange {
+			return nil, fmt.Errorf("invalid byte range %q, want START-END", spec)
+		}
+
+		start, err := parseNum(strings.TrimSpace(startStr))
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid range %q", spec)
+		}
+		span := Span{Start: start, End: start}
+
+		if isRange {
+			if endStr = strings.TrimSpace(endStr); endStr == "" {
+				span.End = -1
+			} else {
+				end, err := parseNum(endStr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid range %q", spec)
+				}
+				if bytes {
+					end-- // exclusive
+				}
+				if end < start {
+					return nil, fmt.Errorf("invalid range %q: end before start", spec)
+				}
+				span.End = end
+			}
+		}
+		spans = append(spans, span)
+	}
+
+	if len(spans) == 0 {
+		return nil, fmt.Errorf("empty range list")
+	}
+	return spans, nil
+}
+
+// resolveSelection turns --only-chunks, --byte-range and a sub-plan's
+// chunks into the set of chunk indexes to fetch, or nil when the whole
+// file is wanted.
+func (d *Downloader) resolveSelection() (map[int]bool, error) {
+	if len(d.config.OnlyChunks) == 0 && len(d.config.ByteRanges) == 0 && len(d.config.Subset) == 0 {
+		return nil, nil
+	}
+
+	n := int64(d.args.NumChunks())
+	selected := make(map[int]bool)
+	for _, span := range slices.Concat(d.config.OnlyChunks, d.config.Subset) {
+		end := span.End
+		if end < 0 || end >= n {
+			end = n - 1
+		}
+		if span.Start >= n {
+			return nil, fmt.Errorf("chunk %d out of range, the file has %d chunks", span.Start, n)
+		}
+		for i := span.Start; i <= end; i++ {
+			selected[int(i)] = true
+		}
+	}
+	for _, span := range d.config.ByteRanges {
+		end := span.End
+		if end < 0 || end >= d.args.TotalSize {
+			end = d.args.TotalSize - 1
+		}
+		if span.Start >= d.args.TotalSize {
+			return nil, fmt.Errorf("byte offset %d out of range, the file is %d bytes", span.Start, d.args.TotalSize)
+		}
+		for i := d.args.ChunkAt(span.Start); i <= d.args.ChunkAt(end); i++ {
+			selected[i] = true
+		}
+	}
+	return selected, nil
+}
+
+// refetching reports whether the selected chunks are fetched again from
+// scratch: those of --only-chunks and --byte-range are, while a sub-plan's
+// resume like any download.
+func (d *Downloader) refetching() bool {
+	return len(d.config.OnlyChunks) > 0 || len(d.config.ByteRanges) > 0
+}
+
+// resetSelected discards whatever is stored for the selected chunks so
+// they are fetched again from scratch.
+func (d *Downloader) resetSelected() error {
+	local, ok := d.storage.(*storage.Local)
+	if !ok {
+		return fmt.Errorf("re-fetching selected chunks requires local chunk files")
+	}
+
+	// A packed file holds its neighbours too, so it can't be reset piecemeal
+	ranges, err := local.PackedRanges()
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		for i := r[0]; i <= r[1]; i++ {
+			if d.only[i] {
+				return fmt.Errorf("chunk %d is in packed file %s, delete it to re-fetch", i, storage.PackedName(local.Prefix, r[0], r[1]))
+			}
+		}
+	}
+
+	for i := range d.only {
+		for _, path := range []string{local.PartPath(i), local.TmpPath(i), local.TmpPath(i) + ".tail"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to reset chunk %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// selected reports whether chunk i is part of this run.
+func (d *Downloader) selected(i int) bool {
+	return d.only == nil || d.only[i]
+}
+
+// MissingChunks returns how many chunks are not complete. After a
+// download restricted with --only-chunks or --byte-range, other chunks
+// may still be missing.
+func (d *Downloader) MissingChunks() int {
+	if d.progress == nil {
+		return 0
+	}
+	return d.progress.NumChunks() - d.progress.CompletedCount()
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/redraw/rapel/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpans(t *testing.T) {
+	spans, err := ParseChunkSpans("5, 17,200-230,40-")
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{5, 5}, {17, 17}, {200, 230}, {40, -1}}, spans)
+
+	parseNum := func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
+	spans, err = ParseByteSpans("100-200,300-", parseNum)
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{100, 199}, {300, -1}}, spans)
+
+	for _, bad := range []string{"", "x", "9-3", "-4"} {
+		_, err := ParseChunkSpans(bad)
+		assert.Error(t, err, bad)
+	}
+	_, err = ParseByteSpans("100", parseNum)
+	assert.Error(t, err)
+}
+
+func TestResolveSelection(t *testing.T) {
+	args := NewDownloadArguments("http://example.com/f", 1000, 100, "f")
+	d := &Downloader{args: args}
+
+	only, err := d.resolveSelection()
+	require.NoError(t, err)
+	assert.Nil(t, only)
+	assert.True(t, d.selected(3))
+
+	// Byte ranges select every chunk they overlap; END is exclusive
+	d.config = Config{
+		OnlyChunks: []Span{{1, 1}, {8, -1}},
+		ByteRanges: []Span{{250, 399}},
+	}
+	only, err = d.resolveSelection()
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true, 8: true, 9: true}, only)
+
+	d.config = Config{OnlyChunks: []Span{{10, 10}}}
+	_, err = d.resolveSelection()
+	assert.ErrorContains(t, err, "out of range")
+}
+
+func TestResetSelected(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	args := NewDownloadArguments("http://example.com/f", 10, 2, "f")
+	d := &Downloader{args: args, storage: storage.NewLocal("", "f"), only: map[int]bool{1: true, 2: true}}
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, os.WriteFile(args.PartPath(i), []byte("ab"), 0644))
+	}
+	require.NoError(t, os.WriteFile(args.TmpPath(2), []byte("a"), 0644))
+
+	require.NoError(t, d.resetSelected())
+	assert.FileExists(t, args.PartPath(0))
+	assert.NoFileExists(t, args.PartPath(1))
+	assert.NoFileExists(t, args.PartPath(2))
+	assert.NoFileExists(t, args.TmpPath(2))
+
+	// Chunks inside a packed file can't be reset on their own
+	require.NoError(t, os.WriteFile(args.PackedPath(2, 4), []byte("abcdef"), 0644))
+	assert.ErrorContains(t, d.resetSelected(), "packed")
+}
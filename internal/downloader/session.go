@@ -0,0 +1,391 @@
+package downloader
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// sessionFile is the name of the Session description inside an export.
+const sessionFile = "rapel-session.json"
+
+// Session describes a download exported by Export: enough to continue it
+// on another machine or in another directory.
+type Session struct {
+	Version   int           `json:"version"`
+	Prefix    string        `json:"prefix"`
+	Dir       string        `json:"dir"` // absolute directory it was exported from
+	Exported  time.Time     `json:"exported"`
+	Chunks    int           `json:"chunks"`
+	Completed []int         `json:"completed"`         // chunks complete when exported
+	Partial   map[int]int64 `json:"partial,omitempty"` // bytes of unfinished chunks
+	Parts     bool          `json:"parts"`             // chunk files included
+	Secrets   bool          `json:"secrets"`           // unredacted URL included
+}
+
+// ExportOptions selects what Export puts in the archive besides the state
+// files.
+type ExportOptions struct {
+	Parts   bool // the .part and .tmp chunk files, and a --follow output
+	Secrets bool // the unredacted URL (.{prefix}-secrets.json)
+}
+
+// sessionStateFiles returns the state files of prefix, in the directory
+// the download runs in. Missing ones are skipped by export.
+func sessionStateFiles(prefix string) []string {
+	return []string{
+		ArgsPath(prefix),
+		ProgressStatePath(prefix),
+		fileDigestPath(prefix),
+		pipeStatePath(prefix),
+		followStatePath(prefix),
+		storage.S3StatePath(prefix),
+		manifest.PathFor(prefix),
+		TransferLogPath(prefix),
+	}
+}
+
+// Export writes a gzipped tar of the download in the current directory
+// with prefix to w: its state files and a Session description, plus the
+// chunk files with Parts. The download is locked while it is read.
+func Export(w io.Writer, prefix string, opts ExportOptions) (*Session, error) {
+	args, err := LoadDownloadArguments(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if args == nil {
+		return nil, fmt.Errorf("no download state found for %s", prefix)
+	}
+	lock, err := registry.Acquire(registry.LockPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	states, err := storage.NewLocal("", prefix).ListChunks(args.NumChunks())
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		Version:   1,
+		Prefix:    prefix,
+		Dir:       dir,
+		Exported:  time.Now().UTC(),
+		Chunks:    args.NumChunks(),
+		Completed: []int{},
+		Partial:   make(map[int]int64),
+		Parts:     opts.Parts,
+		Secrets:   opts.Secrets,
+	}
+	for i, st := range states {
+		if st.Complete {
+			s.Completed = append(s.Completed, i)
+		} else if st.Bytes > 0 {
+			s.Partial[i] = st.Bytes
+		}
+	}
+
+	files := sessionStateFiles(prefix)
+	if opts.Secrets {
+		files = append(files, SecretsPath(prefix))
+	}
+	if opts.Parts {
+		names, err := chunkFiles(prefix)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, names...)
+		if follow, err := loadFollowState(prefix); err == nil && follow != nil {
+			files = append(files, follow.Output)
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if err := writeTarFile(tw, sessionFile, data); err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		if err := addTarFile(tw, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return s, nil
+}
+
+// chunkFiles lists the .part, packed .part and .tmp files of prefix in
+// the current directory.
+func chunkFiles(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunk files: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && isChunkFile(prefix, e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// isChunkFile reports whether name is a chunk file of prefix:
+// prefix.NNNNNN.part, prefix.NNNNNN-MMMMMM.part or prefix.NNNNNN.tmp.
+func isChunkFile(prefix, name string) bool {
+	rest, ok := strings.CutPrefix(name, prefix+".")
+	if !ok {
+		return false
+	}
+	num, ok := strings.CutSuffix(rest, ".part")
+	if !ok {
+		num, ok = strings.CutSuffix(rest, ".tmp")
+	}
+	if !ok || num == "" {
+		return false
+	}
+	for _, c := range num {
+		if (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// addTarFile adds the file at path under its base name, skipping it if it
+// doesn't exist.
+func addTarFile(tw *tar.Writer, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	hdr := &tar.Header{Name: filepath.Base(path), Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTarFile adds data as a file called name.
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// ImportOptions says where Import puts a download.
+type ImportOptions struct {
+	Dir   string // directory to continue the download in (default: current directory)
+	Force bool   // replace state or chunk files already there
+}
+
+// ImportResult reports what Import found.
+type ImportResult struct {
+	Session *Session
+	Dir     string // absolute directory the download was imported into
+	Missing []int  // chunks complete in the session whose files aren't in Dir
+}
+
+// Import unpacks an archive written by Export into opts.Dir, so that
+// running the download there continues it. Paths the state files hold
+// that pointed into the exported directory are rewritten to point into
+// the new one. Chunk files not in the archive may be copied in
+// separately; the chunks still missing are reported and downloaded again.
+func Import(r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a rapel export: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	// The description comes first and says which names to expect
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != sessionFile {
+		return nil, fmt.Errorf("not a rapel export: %s missing", sessionFile)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, 1<<24))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sessionFile, err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", sessionFile, err)
+	}
+	if s.Version != 1 {
+		return nil, fmt.Errorf("unsupported export version %d", s.Version)
+	}
+	if s.Prefix == "" || filepath.Base(s.Prefix) != s.Prefix || strings.ContainsAny(s.Prefix, `/\`) {
+		return nil, fmt.Errorf("invalid prefix %q in export", s.Prefix)
+	}
+
+	lock, err := registry.Acquire(filepath.Join(dir, registry.LockPath(s.Prefix)))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	allowed := make(map[string]bool)
+	for _, path := range append(sessionStateFiles(s.Prefix), SecretsPath(s.Prefix)) {
+		allowed[filepath.Base(path)] = true
+	}
+	// The imported state is kept next to the chunks, replacing what the
+	// registry kept for a download there
+	outputPath := filepath.Join(dir, s.Prefix)
+	if !opts.Force {
+		if _, err := os.Stat(registry.StatePath(outputPath, "args.json")); err == nil {
+			return nil, fmt.Errorf("%s already has state for %s (use --force to replace it)", dir, s.Prefix)
+		}
+	} else if err := registry.Forget(outputPath); err != nil {
+		return nil, err
+	}
+
+	var follow string // the --follow output in the archive, if any
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) || name == ".." {
+			return nil, fmt.Errorf("unexpected entry %q in export", name)
+		}
+		if !allowed[name] && !isChunkFile(s.Prefix, name) {
+			if !s.Parts || follow != "" {
+				return nil, fmt.Errorf("unexpected entry %q in export", name)
+			}
+			follow = name
+		}
+		if err := extractFile(tr, filepath.Join(dir, name), opts.Force); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rewriteFollowState(dir, s.Dir, follow, s.Prefix); err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{Session: &s, Dir: dir}
+	states, err := storage.NewLocal(dir, s.Prefix).ListChunks(s.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range s.Completed {
+		if i < len(states) && !states[i].Complete {
+			res.Missing = append(res.Missing, i)
+		}
+	}
+	return res, nil
+}
+
+// extractFile writes the current archive entry to path through a
+// temporary file, refusing to replace an existing file unless force.
+func extractFile(r io.Reader, path string, force bool) error {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists (use --force to replace it)", path)
+		}
+	}
+	tmpPath := path + ".import"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// rewriteFollowState points the --follow output of an imported download
+// at dir: at the copy in the archive if there was one, otherwise at the
+// same place relative to the download if it was under oldDir. A relative
+// path is left alone, since it is relative to wherever rapel runs.
+func rewriteFollowState(dir, oldDir, archived, prefix string) error {
+	path := registry.StatePath(filepath.Join(dir, prefix), "follow.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read follow state: %w", err)
+	}
+	s := &followState{filePath: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("failed to parse follow state %s: %w", path, err)
+	}
+
+	switch {
+	case archived != "":
+		s.Output = filepath.Join(dir, archived)
+	case !filepath.IsAbs(s.Output):
+		return nil
+	default:
+		rel, err := filepath.Rel(oldDir, s.Output)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+		s.Output = filepath.Join(dir, rel)
+	}
+	return s.save()
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	src := t.TempDir()
+	t.Chdir(src)
+
+	args := NewDownloadArguments("https://example.com/f.bin?sig=secret", 300, 100, "f.bin")
+	require.NoError(t, args.Save())
+	require.NoError(t, os.WriteFile(args.PartPath(0), []byte("chunk zero"), 0644))
+	require.NoError(t, os.WriteFile(args.TmpPath(1), []byte("half"), 0644))
+	follow := &followState{URL: "https://example.com/f.bin", Output: filepath.Join(src, "out", "f.bin"), filePath: followStatePath("f.bin")}
+	require.NoError(t, follow.save())
+
+	var state bytes.Buffer
+	s, err := Export(&state, "f.bin", ExportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, s.Completed)
+	assert.Equal(t, map[int]int64{1: 4}, s.Partial)
+	assert.NoFileExists(t, ".f.bin.lock")
+
+	// Without the parts, the completed chunk is reported missing
+	dst := filepath.Join(t.TempDir(), "moved")
+	res, err := Import(bytes.NewReader(state.Bytes()), ImportOptions{Dir: dst})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, res.Missing)
+	assert.FileExists(t, filepath.Join(dst, ".f.bin-args.json"))
+	assert.NoFileExists(t, filepath.Join(dst, SecretsPath("f.bin")))
+	assert.NoFileExists(t, filepath.Join(dst, args.TmpPath(1)))
+
+	data, err := os.ReadFile(filepath.Join(dst, followStatePath("f.bin")))
+	require.NoError(t, err)
+	var moved followState
+	require.NoError(t, json.Unmarshal(data, &moved))
+	assert.Equal(t, filepath.Join(dst, "out", "f.bin"), moved.Output)
+
+	// Importing over existing state needs --force
+	_, err = Import(bytes.NewReader(state.Bytes()), ImportOptions{Dir: dst})
+	assert.ErrorContains(t, err, "already has state")
+
+	var full bytes.Buffer
+	_, err = Export(&full, "f.bin", ExportOptions{Parts: true, Secrets: true})
+	require.NoError(t, err)
+	res, err = Import(&full, ImportOptions{Dir: dst, Force: true})
+	require.NoError(t, err)
+	assert.Empty(t, res.Missing)
+	for _, name := range []string{args.PartPath(0), args.TmpPath(1), SecretsPath("f.bin")} {
+		want, err := os.ReadFile(name)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestImportRejectsForeignEntries(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, name := range []string{"../escape", ".bashrc", "other.000000.part"} {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		require.NoError(t, writeTarFile(tw, sessionFile, []byte(`{"version":1,"prefix":"f.bin","chunks":1}`)))
+		require.NoError(t, writeTarFile(tw, name, []byte("x")))
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+
+		_, err := Import(&buf, ImportOptions{Dir: "dst"})
+		assert.ErrorContains(t, err, "unexpected entry", name)
+	}
+	assert.NoFileExists(t, "escape")
+	assert.NoFileExists(t, filepath.Join("dst", ".bashrc"))
+}
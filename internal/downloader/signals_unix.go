@@ -0,0 +1,36 @@
+//go:build unix
+
+package downloader
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals adjusts concurrency on SIGUSR1 (one more job) and SIGUSR2
+// (one fewer) until ctx is done.
+func (d *Downloader) watchSignals(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigCh:
+				jobs := d.Concurrency()
+				if sig == syscall.SIGUSR1 {
+					jobs++
+				} else if jobs > 1 {
+					jobs--
+				}
+				d.SetConcurrency(jobs)
+				d.printSettings()
+			}
+		}
+	}()
+}
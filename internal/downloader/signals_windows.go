@@ -0,0 +1,8 @@
+//go:build windows
+
+package downloader
+
+import "context"
+
+// watchSignals is a no-op: Windows has no SIGUSR1/SIGUSR2. Use `rapel ctl`.
+func (d *Downloader) watchSignals(ctx context.Context) {}
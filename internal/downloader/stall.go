@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errStalled is the cancellation cause when a transfer stays below
+// Config.MinSpeed for Config.StallTimeout.
+var errStalled = errors.New("transfer stalled")
+
+// stallCheckInterval is how often throughput is sampled.
+const stallCheckInterval = time.Second
+
+// countingWriter counts bytes passed through to the wrapped writer.
+type countingWriter struct {
+	w     io.Writer
+	bytes atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.bytes.Add(int64(n))
+	return n, err
+}
+
+// fetchRange downloads [start, end] into w. It waits out a ban cool-down
+// first, and a refusal that starts or falls into one comes back wrapped
+// in errBanCooldown, so the caller retries without counting it.
+func (d *Downloader) fetchRange(ctx context.Context, index int, start, end int64, w io.Writer) error {
+	if d.ban.cooldown <= 0 {
+		return d.fetchScheduled(ctx, index, start, end, w)
+	}
+	if err := d.ban.wait(ctx); err != nil {
+		return err
+	}
+
+	cw := &countingWriter{w: w}
+	err := d.fetchScheduled(ctx, index, start, end, cw)
+	if d.noteFetch(ctx, err == nil || cw.bytes.Load() > 0, err) {
+		return fmt.Errorf("%w: %w", errBanCooldown, err)
+	}
+	return err
+}
+
+// fetchScheduled downloads [start, end] into w. While the download is
+// paused, by its schedule or on request, it waits; a transfer cut off by
+// a pause continues after the bytes it already wrote once resumed.
+func (d *Downloader) fetchScheduled(ctx context.Context, index int, start, end int64, w io.Writer) error {
+	for {
+		if err := d.pause.wait(ctx); err != nil {
+			return err
+		}
+
+		pw := &pausingWriter{w: w, pause: d.pause}
+		err := d.fetchRangeOnce(ctx, index, start, end, pw)
+		if !errors.Is(err, errPaused) {
+			return err
+		}
+		start += pw.written
+	}
+}
+
+// fetchRangeOnce downloads [start, end] into w. With --min-speed set, the
+// connection is aborted once throughput stays below the minimum for
+// StallTimeout, instead of waiting for the read timeout on a connection
+// that still trickles bytes. The caller's retry loop then reconnects.
+func (d *Downloader) fetchRangeOnce(ctx context.Context, index int, start, end int64, w io.Writer) error {
+	// Waiting for a --pace slot isn't a stall
+	if err := d.pacer.wait(ctx); err != nil {
+		return err
+	}
+
+	if d.config.MinSpeed <= 0 || d.config.StallTimeout <= 0 {
+		return d.transfer(ctx, index, start, end, w)
+	}
+
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	cw := &countingWriter{w: w}
+	done := make(chan struct{})
+	defer close(done)
+
+	go d.watchStall(attemptCtx, cancel, cw, done)
+
+	err := d.transfer(attemptCtx, index, start, end, cw)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(attemptCtx), errStalled) {
+		d.progress.PrintMessage("chunk %d stalled below %s/s for %s, reconnecting",
+			index, formatBytes(d.config.MinSpeed), d.config.StallTimeout)
+		return fmt.Errorf("%w: below %s/s for %s", errStalled, formatBytes(d.config.MinSpeed), d.config.StallTimeout)
+	}
+	return err
+}
+
+// transfer fetches [start, end] over HTTP, or with --fetch-cmd, and
+// records the request in the transfer log.
+func (d *Downloader) transfer(ctx context.Context, index int, start, end int64, w io.Writer) error {
+	if d.transfers == nil {
+		_, err := d.request(ctx, index, start, end, w)
+		return err
+	}
+	started := time.Now()
+	cw := &countingWriter{w: w}
+	source, err := d.request(ctx, index, start, end, cw)
+	d.logTransfer(ctx, index, started, start, end, cw.bytes.Load(), source, err)
+	return err
+}
+
+// request makes transfer's request, returning where it went: the host,
+// or sourceFetchCmd.
+func (d *Downloader) request(ctx context.Context, index int, start, end int64, w io.Writer) (string, error) {
+	if d.config.FetchCmd != "" {
+		return sourceFetchCmd, d.fetchCommand(ctx, index, start, end, w)
+	}
+	u, err := d.requestURL(ctx, index, start, end)
+	if err != nil {
+		return "", err
+	}
+	return requestHost(u), d.client.DownloadRange(ctx, u, start, end, w)
+}
+
+// watchStall samples cw until done and cancels the attempt with errStalled
+// once throughput has been below MinSpeed for StallTimeout.
+func (d *Downloader) watchStall(ctx context.Context, cancel context.CancelCauseFunc, cw *countingWriter, done <-chan struct{}) {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+
+	lastBytes := int64(0)
+	lastTick := time.Now()
+	var slowSince time.Time
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bytes := cw.bytes.Load()
+			if stalled(bytes-lastBytes, now.Sub(lastTick), d.config.MinSpeed) {
+				if slowSince.IsZero() {
+					slowSince = lastTick
+				}
+				if now.Sub(slowSince) >= d.config.StallTimeout {
+					cancel(errStalled)
+					return
+				}
+			} else {
+				slowSince = time.Time{}
+			}
+			lastBytes, lastTick = bytes, now
+		}
+	}
+}
+
+// stalled reports whether n bytes over elapsed is below minSpeed bytes/s.
+func stalled(n int64, elapsed time.Duration, minSpeed int64) bool {
+	if elapsed <= 0 {
+		return false
+	}
+	return float64(n)/elapsed.Seconds() < float64(minSpeed)
+}
@@ -0,0 +1,16 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStalled(t *testing.T) {
+	assert.True(t, stalled(0, time.Second, 100_000))
+	assert.True(t, stalled(99_999, time.Second, 100_000))
+	assert.False(t, stalled(100_000, time.Second, 100_000))
+	assert.False(t, stalled(150_000, 1500*time.Millisecond, 100_000))
+	assert.False(t, stalled(0, 0, 100_000))
+}
@@ -0,0 +1,38 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/redraw/rapel/internal/stats"
+)
+
+// recordStats adds this session to the lifetime stats. Failures are only
+// logged: stats are informational and must never fail a download.
+func (d *Downloader) recordStats(err error) {
+	var bytes int64
+	if d.progress != nil {
+		bytes = d.progress.TotalBytes()
+	}
+
+	var host string
+	if u, perr := url.Parse(d.config.URL); perr == nil {
+		host = u.Hostname()
+	}
+
+	outcome := stats.OutcomeSucceeded
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		outcome = stats.OutcomeCancelled
+	default:
+		outcome = stats.OutcomeFailed
+	}
+
+	if err := stats.Record(host, bytes, outcome); err != nil {
+		slog.Warn(fmt.Sprintf("cannot update stats: %v", err))
+	}
+}
@@ -0,0 +1,228 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// rangesSupported reports whether the chunks left can be fetched with
+// range requests. A server that ignores Range answers every chunk with
+// the whole file, so a one-byte request is sent first to see whether it
+// comes back as 206. When only the start of the file is missing, a 200 is
+// as good as a 206 and nothing is checked.
+func (d *Downloader) rangesSupported(ctx context.Context) bool {
+	if d.config.FetchCmd != "" {
+		return true
+	}
+	needed := false
+	for i := 0; i < d.args.NumChunks() && !needed; i++ {
+		if d.selected(i) && !d.progress.IsChunkComplete(i) {
+			start, _ := d.args.ChunkRange(i)
+			needed = start+d.progress.Bytes(i) > 0
+		}
+	}
+	if !needed {
+		return true
+	}
+
+	// Other failures are left to the chunk requests and their retries
+	u, err := d.requestURL(ctx, 0, 0, 0)
+	if err != nil {
+		return true
+	}
+	probe := d.client.Probe(ctx, u, 1)
+	if probe.Ranges || probe.Status != 200 {
+		return true
+	}
+
+	answer := "answered 200"
+	if d.acceptRanges != "" {
+		answer += ", Accept-Ranges: " + d.acceptRanges
+	}
+	slog.Warn(fmt.Sprintf("The server ignores Range requests (%s): downloading in a single stream, --jobs has no effect", answer),
+		"status", probe.Status, "accept_ranges", d.acceptRanges)
+	d.config.Events.Emit(events.SingleStream, "status", probe.Status)
+	return false
+}
+
+// downloadStream fetches the file with one request from its start,
+// writing each chunk's bytes into its chunk file as they go by. It is the
+// fallback for servers that ignore Range: a restart can't skip ahead, so
+// bytes the chunk files already hold are read again and thrown away.
+func (d *Downloader) downloadStream(ctx context.Context) (err error) {
+	if d.pipeState != nil || d.only != nil {
+		return fmt.Errorf("the server ignores Range requests, which --pipe-part, --only-chunks and --byte-range need")
+	}
+
+	if d.config.HasPostPartCmd() {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		d.stopDownload = cancel
+		d.postPartCh = make(chan int, d.args.NumChunks())
+		d.startPostPartWorkers(ctx)
+		defer func() {
+			close(d.postPartCh)
+			d.progress.PrintMessage("Waiting for post-part commands to complete...")
+			d.postPartWg.Wait()
+			if cause := context.Cause(ctx); errors.Is(cause, errPostPartLimit) {
+				err = cause
+			}
+		}()
+		// At-least-once on resume, as with range requests
+		for i := 0; i < d.args.NumChunks(); i++ {
+			if d.progress.IsChunkComplete(i) {
+				d.postPartCh <- i
+			}
+		}
+	}
+
+	var lastErr error
+	maxRetries := d.config.HTTPConfig.MaxRetries
+	w := &streamWriter{ctx: ctx, d: d, index: -1}
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := d.checkRetry(lastErr); err != nil {
+				return err
+			}
+			if err := d.retries.spend(w.index, lastErr); err != nil {
+				return err
+			}
+			if w.index >= 0 {
+				d.progress.AddRetry(w.index)
+			}
+			d.progress.PrintMessage("single stream: %v, restarting (%d/%d)", lastErr, attempt, maxRetries)
+			d.config.Events.Emit(events.ChunkRetry, "chunk", w.index, "attempt", attempt, "error", lastErr)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d.config.Backoff.Delay(attempt)):
+			}
+		}
+
+		w.pos = 0
+		u, err := d.requestURL(ctx, 0, 0, d.args.TotalSize-1)
+		if err == nil {
+			_, _, err = d.client.Tail(ctx, u, 0, w)
+		}
+		if err == nil && w.pos < d.args.TotalSize {
+			err = fmt.Errorf("incomplete download: expected %d bytes, got %d", d.args.TotalSize, w.pos)
+		}
+		if err == nil {
+			return nil
+		}
+		w.closeChunk()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("download failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// streamWriter splits the stream of the whole file into chunk files,
+// skipping the bytes they already hold.
+type streamWriter struct {
+	ctx   context.Context
+	d     *Downloader
+	pos   int64 // offset in the file of the next byte
+	index int   // chunk being written, -1 before the first
+	chunk storage.Chunk
+	have  int64 // bytes the open chunk held before this stream
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	d := w.d
+	if err := d.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	if err := d.diskLimiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for written < len(p) {
+		if w.pos >= d.args.TotalSize {
+			return written, fmt.Errorf("the server sent more than %d bytes", d.args.TotalSize)
+		}
+		index := d.args.ChunkAt(w.pos)
+		start, end := d.args.ChunkRange(index)
+		n := int64(len(p) - written)
+		if n > end+1-w.pos {
+			n = end + 1 - w.pos
+		}
+
+		if w.chunk == nil && !d.progress.IsChunkComplete(index) {
+			if err := w.openChunk(index); err != nil {
+				return written, err
+			}
+		}
+
+		switch {
+		case w.chunk == nil:
+			// Already complete
+			d.discarded.Add(n)
+		case w.pos-start < w.have:
+			if skip := w.have - (w.pos - start); n > skip {
+				n = skip
+			}
+			d.discarded.Add(n)
+		default:
+			if _, err := w.chunk.Write(p[written : written+int(n)]); err != nil {
+				return written, fmt.Errorf("write failed: %w", err)
+			}
+			d.progress.AddBytes(index, n)
+			d.progress.PrintProgress(index)
+		}
+		w.pos += n
+		written += int(n)
+
+		if w.pos == end+1 && w.chunk != nil {
+			chunk := w.chunk
+			w.chunk = nil
+			d.progress.SetActive(index, false)
+			if err := d.storage.FinalizeChunk(w.ctx, index, chunk); err != nil {
+				return written, fmt.Errorf("failed to finalize chunk %d: %w", index, err)
+			}
+			d.finishChunk(w.ctx, index)
+		}
+	}
+	return written, nil
+}
+
+// openChunk opens chunk index for appending; a chunk found complete is
+// marked so and skipped.
+func (w *streamWriter) openChunk(index int) error {
+	d := w.d
+	chunk, have, err := d.openChunk(index)
+	if errors.Is(err, storage.ErrChunkComplete) {
+		d.progress.MarkComplete(index)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w.index, w.chunk, w.have = index, chunk, have
+	d.progress.SeedChunk(index, w.have)
+	d.progress.SetActive(index, true)
+	start, end := d.args.ChunkRange(index)
+	d.config.Events.Emit(events.ChunkStart, "chunk", index, "start", start, "end", end)
+	return nil
+}
+
+// closeChunk closes the chunk left open by a failed stream, keeping what
+// it holds for the next attempt.
+func (w *streamWriter) closeChunk() {
+	if w.chunk != nil {
+		w.chunk.Close()
+		w.d.progress.SetActive(w.index, false)
+		w.chunk = nil
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadStreamWithoutRanges(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := make([]byte, 250)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "none")
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		r.Header.Del("Range")
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// Chunk 1 is done and chunk 2 half way: both are skipped in the stream
+	require.NoError(t, os.WriteFile(storage.PartName("f", 1), content[100:200], 0644))
+	require.NoError(t, os.WriteFile(storage.TmpName("f", 2), content[200:220], 0644))
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		Prefix:         "f",
+		ChunkSize:      100,
+		MaxConcurrency: 3,
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	var got []byte
+	for i := range 3 {
+		data, err := os.ReadFile(storage.PartName("f", i))
+		require.NoError(t, err)
+		got = append(got, data...)
+	}
+	assert.Equal(t, content, got)
+	// The one-byte probe, then a single stream
+	assert.Equal(t, int32(2), gets.Load())
+}
+
+func TestWeakRangesFallBackToStream(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	// Ranges are honored near the start only, as by a cache that has
+	// fetched just the head of the file
+	var full atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start int64
+		if rng := r.Header.Get("Range"); rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+		}
+		if start >= 500 {
+			full.Add(1)
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		Prefix:         "f",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		Coalesce:       1,
+		NoEndgame:      true,
+		HTTPConfig:     httpclient.Config{MaxRetries: 3, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	for i := range 10 {
+		data, err := os.ReadFile(storage.PartName("f", i))
+		require.NoError(t, err)
+		assert.Equal(t, content[i*100:(i+1)*100], data, "chunk %d", i)
+	}
+	// The 200 isn't retried: the stream takes over
+	assert.LessOrEqual(t, full.Load(), int32(3))
+}
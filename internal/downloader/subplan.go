@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// SubPlan is one share of a download split across machines: each machine
+// downloads its share of the chunks from the same URL, with the same size
+// and chunk size, and the chunk files they produce are merged together.
+// Hosts that throttle each IP then serve every machine at full speed.
+type SubPlan struct {
+	URL       string `json:"url"` // redacted
+	URLHash   string `json:"url_sha256"`
+	TotalSize int64  `json:"total_size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Prefix    string `json:"filename_prefix"`
+	Part      int    `json:"part"` // 1-based
+	Parts     int    `json:"parts"`
+	Chunks    string `json:"chunks"` // chunk indexes, as for --only-chunks
+}
+
+// SubPlanName returns the file name of the part-th of parts sub-plans of
+// prefix.
+func SubPlanName(prefix string, part, parts int) string {
+	return fmt.Sprintf("%s.plan-%dof%d.json", prefix, part, parts)
+}
+
+// Split sizes the file and cuts its chunks into n sub-plans of contiguous
+// chunk ranges, as even as the chunk count allows.
+func (d *Downloader) Split(ctx context.Context, n int) ([]*SubPlan, error) {
+	prefix, totalSize, _, err := d.sizeFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.applyAutoChunkSize(totalSize)
+	if err := d.checkChunkSize(totalSize); err != nil {
+		return nil, err
+	}
+
+	chunks := int((totalSize + d.config.ChunkSize - 1) / d.config.ChunkSize)
+	if n < 1 || n > chunks {
+		return nil, fmt.Errorf("can't split %d chunks into %d plans; use a smaller -c or fewer plans", chunks, n)
+	}
+
+	plans := make([]*SubPlan, n)
+	first := 0
+	for i := range plans {
+		count := chunks / n
+		if i < chunks%n {
+			count++
+		}
+		plans[i] = &SubPlan{
+			URL:       redact.URL(d.config.URL),
+			URLHash:   redact.Fingerprint(d.config.URL),
+			TotalSize: totalSize,
+			ChunkSize: d.config.ChunkSize,
+			Prefix:    prefix,
+			Part:      i + 1,
+			Parts:     n,
+			Chunks:    formatChunkRange(first, first+count-1),
+		}
+		first += count
+	}
+	return plans, nil
+}
+
+// formatChunkRange writes chunks first to last as --only-chunks takes them.
+func formatChunkRange(first, last int) string {
+	if first == last {
+		return strconv.Itoa(first)
+	}
+	return fmt.Sprintf("%d-%d", first, last)
+}
+
+// Save writes the sub-plan to dir under SubPlanName.
+func (p *SubPlan) Save(dir string) (string, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	path := filepath.Join(dir, SubPlanName(p.Prefix, p.Part, p.Parts))
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write plan: %w", err)
+	}
+	return path, nil
+}
+
+// LoadSubPlan reads a sub-plan written by Save.
+func LoadSubPlan(path string) (*SubPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	var p SubPlan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if p.TotalSize <= 0 || p.ChunkSize <= 0 || p.Prefix == "" || strings.ContainsRune(p.Prefix, '/') {
+		return nil, fmt.Errorf("invalid plan %s", path)
+	}
+	if _, err := ParseChunkSpans(p.Chunks); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Apply sets config up to download the sub-plan's chunks of url. The
+// chunks are resumed like any download, unlike OnlyChunks, which fetches
+// its chunks again.
+func (p *SubPlan) Apply(config *Config, url string) error {
+	if p.URLHash != "" && p.URLHash != redact.Fingerprint(url) {
+		return fmt.Errorf("plan %d of %d is for %s, not this URL", p.Part, p.Parts, p.URL)
+	}
+	spans, err := ParseChunkSpans(p.Chunks)
+	if err != nil {
+		return err
+	}
+	config.TotalSize = p.TotalSize
+	config.ChunkSize = p.ChunkSize
+	config.Prefix = p.Prefix
+	config.Subset = spans
+	return nil
+}
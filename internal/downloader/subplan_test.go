@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	d := &Downloader{config: Config{URL: "https://example.com/f.bin", ChunkSize: 100, TotalSize: 1050}}
+	plans, err := d.Split(context.Background(), 3)
+	require.NoError(t, err)
+
+	var chunks []string
+	for _, p := range plans {
+		chunks = append(chunks, p.Chunks)
+		assert.Equal(t, "f.bin", p.Prefix)
+		assert.Equal(t, int64(1050), p.TotalSize)
+		assert.Equal(t, 3, p.Parts)
+	}
+	// 11 chunks: the first two plans take the spare ones
+	assert.Equal(t, []string{"0-3", "4-7", "8-10"}, chunks)
+
+	_, err = d.Split(context.Background(), 12)
+	assert.ErrorContains(t, err, "can't split 11 chunks into 12 plans")
+}
+
+func TestSubPlanSaveLoadApply(t *testing.T) {
+	dir := t.TempDir()
+	d := &Downloader{config: Config{URL: "https://example.com/f.bin?sig=secret", ChunkSize: 100, TotalSize: 250}}
+	plans, err := d.Split(context.Background(), 2)
+	require.NoError(t, err)
+
+	path, err := plans[1].Save(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "f.bin.plan-2of2.json"), path)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	p, err := LoadSubPlan(path)
+	require.NoError(t, err)
+	var config Config
+	require.NoError(t, p.Apply(&config, "https://example.com/f.bin?sig=secret"))
+	assert.Equal(t, int64(250), config.TotalSize)
+	assert.Equal(t, int64(100), config.ChunkSize)
+	assert.Equal(t, "f.bin", config.Prefix)
+	assert.Equal(t, []Span{{Start: 2, End: 2}}, config.Subset)
+
+	assert.ErrorContains(t, p.Apply(&config, "https://example.com/other.bin"), "not this URL")
+}
+
+func TestSubsetResumes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	body := []byte(strings.Repeat("x", 300))
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	// Chunk 1 finished on an earlier run, chunk 2 is half done
+	require.NoError(t, os.WriteFile("f.bin.000001.part", body[100:200], 0o644))
+	require.NoError(t, os.WriteFile("f.bin.000002.tmp", body[200:250], 0o644))
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f.bin",
+		Prefix:         "f.bin",
+		TotalSize:      300,
+		ChunkSize:      100,
+		MaxConcurrency: 1,
+		Subset:         []Span{{Start: 1, End: 2}},
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	assert.NoFileExists(t, "f.bin.000000.part", "another plan's chunk")
+	assert.FileExists(t, "f.bin.000002.part")
+	assert.Contains(t, requests, "bytes=250-299")
+	assert.NotContains(t, requests, "bytes=100-199")
+	assert.Equal(t, 1, d.MissingChunks())
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// makeRaw puts the terminal on f into character-at-a-time mode without echo
+// and returns a function restoring the previous settings.
+func makeRaw(f *os.File) (func(), error) {
+	if !isTerminal(f) {
+		return nil, os.ErrInvalid
+	}
+
+	get := exec.Command("stty", "-g")
+	get.Stdin = f
+	state, err := get.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	set := exec.Command("stty", "-icanon", "-echo", "min", "1")
+	set.Stdin = f
+	if err := set.Run(); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		restore := exec.Command("stty", strings.TrimSpace(string(state)))
+		restore.Stdin = f
+		_ = restore.Run()
+	}, nil
+}
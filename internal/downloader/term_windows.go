@@ -0,0 +1,13 @@
+//go:build windows
+
+package downloader
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is not supported on Windows; the dashboard renders without keybindings.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported on windows")
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// discardFile removes a file holding bytes downloaded this session and
+// counts them as discarded.
+func (d *Downloader) discardFile(path string) {
+	if info, err := os.Stat(path); err == nil {
+		d.discarded.Add(info.Size())
+	}
+	os.Remove(path)
+}
+
+// logTraffic reports how the server answered this session's requests and
+// how much of what came over the wire ended up in chunks.
+func (d *Downloader) logTraffic() {
+	t := d.client.Traffic()
+	wire := t.Bytes + d.fetched.Load()
+	if wire == 0 && t.Requests() == 0 {
+		return
+	}
+
+	kept := d.earlierRounds + d.progress.TotalBytes() - d.discarded.Load()
+	if kept > wire {
+		kept = wire
+	}
+	if kept < 0 {
+		kept = 0
+	}
+
+	slog.Info("Traffic    : "+trafficSummary(t, wire, kept),
+		"responses_206", t.Partial, "responses_200", t.Full, "responses_other", t.Other,
+		"wire_bytes", wire, "goodput_bytes", kept, "wasted_bytes", wire-kept)
+	if t.Full > 0 {
+		slog.Warn(fmt.Sprintf("%d of %d range requests were answered with 200 instead of 206: the server ignored the Range header", t.Full, t.Requests()),
+			"responses_200", t.Full, "requests", t.Requests())
+	}
+	for _, h := range d.client.ProxyHealth() {
+		slog.Info("Proxy      : "+proxySummary(h),
+			"proxy", h.Proxy, "requests", h.Requests, "failures", h.Failures, "resets", h.Resets,
+			"timeouts", h.Timeouts, "short_reads", h.ShortReads, "trips", h.Trips, "out", h.Out)
+	}
+}
+
+// proxySummary formats how the requests through a proxy of a rotation went.
+func proxySummary(h httpclient.ProxyHealth) string {
+	s := fmt.Sprintf("%s, %d requests", h.Proxy, h.Requests)
+	if h.Failures > 0 {
+		var kinds []string
+		for _, k := range []struct {
+			n    int64
+			name string
+		}{{h.Resets, "reset"}, {h.Timeouts, "timed out"}, {h.ShortReads, "cut short"}} {
+			if k.n > 0 {
+				kinds = append(kinds, fmt.Sprintf("%d %s", k.n, k.name))
+			}
+		}
+		s += fmt.Sprintf(", %d failed", h.Failures)
+		if len(kinds) > 0 {
+			s += " (" + strings.Join(kinds, ", ") + ")"
+		}
+	}
+	switch {
+	case h.Trips == 1:
+		s += ", taken out of rotation once"
+	case h.Trips > 1:
+		s += fmt.Sprintf(", taken out of rotation %d times", h.Trips)
+	}
+	if h.Out {
+		s += ", out now"
+	}
+	return s
+}
+
+// trafficSummary formats response counts and goodput versus wire bytes.
+func trafficSummary(t httpclient.Traffic, wire, kept int64) string {
+	var parts []string
+	if n := t.Requests(); n > 0 {
+		counts := fmt.Sprintf("%d requests (206: %d, 200: %d", n, t.Partial, t.Full)
+		if t.Other > 0 {
+			counts += fmt.Sprintf(", %d other", t.Other)
+		}
+		parts = append(parts, counts+")")
+	}
+
+	pct := 100.0
+	if wire > 0 {
+		pct = float64(kept) / float64(wire) * 100
+	}
+	parts = append(parts, fmt.Sprintf("%s received, %s kept (%.1f%%)", formatBytes(wire), formatBytes(kept), pct))
+	if wasted := wire - kept; wasted > 0 {
+		parts = append(parts, formatBytes(wasted)+" discarded or re-downloaded")
+	}
+	return strings.Join(parts, ", ")
+}
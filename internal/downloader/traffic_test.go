@@ -0,0 +1,25 @@
+package downloader
+
+import (
+	"testing"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficSummary(t *testing.T) {
+	assert.Equal(t, "10 requests (206: 10, 200: 0), 1.0 KB received, 1.0 KB kept (100.0%)",
+		trafficSummary(httpclient.Traffic{Partial: 10}, 1000, 1000))
+	assert.Equal(t, "4 requests (206: 2, 200: 1, 1 other), 2.0 KB received, 1.5 KB kept (75.0%), 500 B discarded or re-downloaded",
+		trafficSummary(httpclient.Traffic{Partial: 2, Full: 1, Other: 1}, 2000, 1500))
+
+	// --fetch-cmd makes no HTTP requests
+	assert.Equal(t, "0 B received, 0 B kept (100.0%)", trafficSummary(httpclient.Traffic{}, 0, 0))
+}
+
+func TestProxySummary(t *testing.T) {
+	assert.Equal(t, "socks5h://127.0.0.1:9050, 40 requests", proxySummary(httpclient.ProxyHealth{Proxy: "socks5h://127.0.0.1:9050", Requests: 40}))
+	assert.Equal(t, "socks5h://127.0.0.1:9052, 40 requests, 12 failed (3 reset, 9 cut short), taken out of rotation 2 times, out now",
+		proxySummary(httpclient.ProxyHealth{Proxy: "socks5h://127.0.0.1:9052", Requests: 40, Failures: 12, Resets: 3, ShortReads: 9, Trips: 2, Out: true}))
+}
@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// Transfer is one request for a chunk's bytes, as the transfer log
+// records it.
+type Transfer struct {
+	Chunk       int       `json:"chunk"`   // a coalesced request's first chunk
+	Attempt     int       `json:"attempt"` // the chunk's requests before this one in the run: retries, an endgame helper's
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	From        int64     `json:"from"`   // first byte requested, past what was already on disk
+	To          int64     `json:"to"`     // last byte requested
+	Bytes       int64     `json:"bytes"`  // received, whether the request succeeded or not
+	Source      string    `json:"source"` // the host, or sourceFetchCmd
+	Error       string    `json:"error,omitempty"`
+	Interrupted bool      `json:"interrupted,omitempty"` // cut off by the download stopping or pausing, not a failure
+}
+
+// Duration returns how long the request took.
+func (t Transfer) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// Failed reports whether the request failed, and the chunk was retried
+// or given up on.
+func (t Transfer) Failed() bool {
+	return t.Error != "" && !t.Interrupted
+}
+
+// sourceFetchCmd is the Source of the ranges --fetch-cmd produces.
+const sourceFetchCmd = "fetch-cmd"
+
+// TransferLogPath returns the transfer log of the download with prefix:
+// one JSON Transfer per line, appended to by every run that resumes it.
+func TransferLogPath(prefix string) string {
+	return registry.StatePath(prefix, "transfers.jsonl")
+}
+
+// transferLog appends Transfers to the transfer log. A failure to write
+// costs the log, not the download.
+type transferLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	failed bool
+}
+
+// openTransferLog opens the download's transfer log, emptied for a fresh
+// start, when Config.TransferLog asks for one.
+func (d *Downloader) openTransferLog(prefix string, fresh bool) {
+	if !d.config.TransferLog {
+		return
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(TransferLogPath(prefix), flags, 0644)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to open the transfer log: %v", err), "file", TransferLogPath(prefix), "error", err)
+		return
+	}
+	d.transfers = &transferLog{f: f}
+}
+
+// closeTransferLog closes the transfer log, if open.
+func (d *Downloader) closeTransferLog() {
+	if d.transfers != nil {
+		d.transfers.f.Close()
+		d.transfers = nil
+	}
+}
+
+// requestHost returns the host a request for rawURL goes to.
+func requestHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// logTransfer records a request for [from, to] of chunk index that went
+// to source, received bytes and ended with err.
+func (d *Downloader) logTransfer(ctx context.Context, index int, started time.Time, from, to, bytes int64, source string, err error) {
+	counter, _ := d.requests.LoadOrStore(index, new(atomic.Int32))
+	t := Transfer{
+		Chunk:   index,
+		Attempt: int(counter.(*atomic.Int32).Add(1)) - 1,
+		Start:   started.UTC(),
+		End:     time.Now().UTC(),
+		From:    from,
+		To:      to,
+		Bytes:   bytes,
+		Source:  source,
+	}
+	if err != nil && !errors.Is(err, errRangeShrunk) {
+		t.Error = err.Error()
+		// A stall cancels the request's context as well, but fails it
+		if cause := context.Cause(ctx); errors.Is(cause, errStalled) {
+			t.Error = cause.Error()
+		} else {
+			t.Interrupted = ctx.Err() != nil || errors.Is(err, errPaused)
+		}
+	}
+	d.transfers.record(t)
+}
+
+// record appends t to the log.
+func (l *transferLog) record(t Transfer) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return
+	}
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		l.failed = true
+		slog.Warn(fmt.Sprintf("failed to write the transfer log, no longer recording transfers: %v", err), "error", err)
+	}
+}
+
+// ReadTransferLog reads the transfer log of the download with prefix. A
+// line cut short by a crash is skipped.
+func ReadTransferLog(prefix string) ([]Transfer, error) {
+	f, err := os.Open(TransferLogPath(prefix))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no transfer log for %s (%s)", prefix, TransferLogPath(prefix))
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var transfers []Transfer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var t Transfer
+		if json.Unmarshal(scanner.Bytes(), &t) == nil {
+			transfers = append(transfers, t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TransferLogPath(prefix), err)
+	}
+	return transfers, nil
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferLog(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	sha := sha256.Sum256(content)
+	srv := digestServer(t, content, sha[:], 100, true)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	d, err := NewDownloader(Config{
+		URL:            srv.URL + "/f",
+		Prefix:         "f",
+		ChunkSize:      100,
+		MaxConcurrency: 1,
+		TransferLog:    true,
+		Backoff:        Backoff{Base: time.Millisecond},
+		HTTPConfig:     httpclient.Config{MaxRetries: 2, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	// Chunk 1's first answer failed its Content-MD5
+	transfers, err := ReadTransferLog("f")
+	require.NoError(t, err)
+	require.Len(t, transfers, 4)
+	var chunks, attempts []int
+	for _, tr := range transfers {
+		chunks = append(chunks, tr.Chunk)
+		attempts = append(attempts, tr.Attempt)
+		assert.Equal(t, u.Host, tr.Source)
+		assert.False(t, tr.End.Before(tr.Start))
+	}
+	assert.Equal(t, []int{0, 1, 1, 2}, chunks)
+	assert.Equal(t, []int{0, 0, 1, 0}, attempts)
+	assert.True(t, transfers[1].Failed())
+	assert.Contains(t, transfers[1].Error, "Content-MD5")
+	last := transfers[3]
+	assert.Equal(t, []int64{200, 249, 50}, []int64{last.From, last.To, last.Bytes})
+	assert.Empty(t, last.Error)
+
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(TransferLogPath("f"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"chunk":3,"atte`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	transfers, err = ReadTransferLog("f")
+	require.NoError(t, err)
+	assert.Len(t, transfers, 4)
+
+	_, err = ReadTransferLog("g")
+	assert.ErrorContains(t, err, "no transfer log for g")
+}
+
+func TestSummarizeTransfers(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	transfer := func(chunk, attempt int, start, seconds int, bytes int64, source, err string) Transfer {
+		return Transfer{
+			Chunk: chunk, Attempt: attempt,
+			Start: at.Add(time.Duration(start) * time.Second), End: at.Add(time.Duration(start+seconds) * time.Second),
+			From: int64(chunk) * 1000, To: int64(chunk)*1000 + 999, Bytes: bytes, Source: source, Error: err,
+		}
+	}
+	transfers := []Transfer{
+		transfer(0, 0, 0, 10, 1000, "a", ""),
+		transfer(1, 0, 0, 5, 200, "b", "connection reset"),
+		transfer(1, 1, 6, 4, 800, "a", ""),
+		transfer(2, 0, 10, 20, 1000, "a", ""),
+		// A second run, an hour later
+		transfer(3, 0, 3600, 2, 500, "a", "context canceled"),
+	}
+	transfers[4].Interrupted = true
+
+	r := SummarizeTransfers(transfers, 0)
+	assert.Equal(t, 5, r.Transfers)
+	assert.Equal(t, 1, r.Failed)
+	assert.Equal(t, 1, r.Interrupted)
+	assert.Equal(t, int64(3500), r.Bytes)
+	assert.Equal(t, at, r.First)
+	assert.Equal(t, at.Add(3602*time.Second), r.Last)
+	assert.Equal(t, 32*time.Second, r.Busy)
+	assert.InDelta(t, 3500.0/32, r.Speed(), 0.001)
+
+	require.Len(t, r.Chunks, 4)
+	assert.Equal(t, ChunkTransfers{Chunk: 1, From: 1000, To: 1999, Attempts: 2, Failed: 1, Bytes: 1000, Time: 9 * time.Second, LastError: "connection reset"}, r.Chunks[1])
+	assert.Equal(t, []ChunkTransfers{r.Chunks[1]}, r.RetryHotspots(10))
+	slowest := r.Slowest(2)
+	require.Len(t, slowest, 2)
+	assert.Equal(t, 2, slowest[0].Chunk) // 50 B/s
+	assert.Equal(t, 0, slowest[1].Chunk) // 100 B/s
+
+	require.Len(t, r.Sources, 2)
+	assert.Equal(t, SourceTransfers{Source: "a", Transfers: 4, Bytes: 3300, Time: 36 * time.Second}, r.Sources[0])
+	assert.Equal(t, SourceTransfers{Source: "b", Transfers: 1, Failed: 1, Bytes: 200, Time: 5 * time.Second}, r.Sources[1])
+
+	// 32s busy fits in 30 buckets of 5s; the hour between runs has none
+	assert.Equal(t, 5*time.Second, r.Interval)
+	require.Len(t, r.Timeline, 7)
+	assert.Equal(t, TimelineBucket{Start: at, Bytes: 700, Transfers: 2}, r.Timeline[0])
+	assert.Equal(t, TimelineBucket{Start: at.Add(5 * time.Second), Bytes: 500 + 800, Transfers: 2, Failed: 1}, r.Timeline[1])
+	assert.Equal(t, at.Add(3600*time.Second), r.Timeline[6].Start)
+	var sum int64
+	for _, b := range r.Timeline {
+		sum += b.Bytes
+	}
+	assert.Equal(t, r.Bytes, sum)
+
+	assert.Equal(t, time.Minute, SummarizeTransfers(transfers, time.Minute).Interval)
+	assert.Zero(t, SummarizeTransfers(nil, 0).Transfers)
+}
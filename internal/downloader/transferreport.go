@@ -0,0 +1,272 @@
+package downloader
+
+import (
+	"sort"
+	"time"
+)
+
+// TransferReport summarizes a transfer log, for rapel report.
+type TransferReport struct {
+	Transfers   int
+	Failed      int // requests that failed, see Transfer.Failed
+	Interrupted int // requests cut off by a download stopping
+	Bytes       int64
+	First       time.Time     // the first request's start
+	Last        time.Time     // the last request's end
+	Busy        time.Duration // time with at least one request in flight
+	Interval    time.Duration // of the Timeline buckets
+	Timeline    []TimelineBucket
+	Chunks      []ChunkTransfers  // by chunk index
+	Sources     []SourceTransfers // most bytes first
+}
+
+// Speed returns the bytes per second received while requests were in
+// flight, leaving out the time between runs.
+func (r TransferReport) Speed() float64 {
+	return perSecond(r.Bytes, r.Busy)
+}
+
+// TimelineBucket is an Interval of the download's time with requests in
+// flight. A request's bytes are spread evenly over its duration.
+type TimelineBucket struct {
+	Start     time.Time
+	Bytes     int64
+	Transfers int // requests in flight during the bucket
+	Failed    int // requests that failed in it
+}
+
+// ChunkTransfers sums up the requests for one chunk.
+type ChunkTransfers struct {
+	Chunk     int
+	From      int64 // first byte requested by any request
+	To        int64 // last byte requested
+	Attempts  int
+	Failed    int
+	Bytes     int64
+	Time      time.Duration // spent in requests, failed ones included
+	LastError string
+}
+
+// Speed returns the chunk's bytes per second over all its requests.
+func (c ChunkTransfers) Speed() float64 {
+	return perSecond(c.Bytes, c.Time)
+}
+
+// SourceTransfers sums up the requests that went to one host, or to
+// --fetch-cmd.
+type SourceTransfers struct {
+	Source    string
+	Transfers int
+	Failed    int
+	Bytes     int64
+	Time      time.Duration // spent in requests, side by side ones each counted
+}
+
+// Speed returns the bytes per second of one request to the source.
+func (s SourceTransfers) Speed() float64 {
+	return perSecond(s.Bytes, s.Time)
+}
+
+// maxTimelineBuckets is how many buckets of busy time the automatic
+// interval aims at, at most.
+const maxTimelineBuckets = 30
+
+// timelineIntervals are the intervals the automatic one is picked from.
+var timelineIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// SummarizeTransfers summarizes a transfer log. The timeline has buckets
+// of interval, 0 to pick one that cuts the busy time into at most 30;
+// buckets without requests are left out.
+func SummarizeTransfers(transfers []Transfer, interval time.Duration) TransferReport {
+	var r TransferReport
+	chunks := make(map[int]*ChunkTransfers)
+	sources := make(map[string]*SourceTransfers)
+	for _, t := range transfers {
+		r.Transfers++
+		r.Bytes += t.Bytes
+		if t.Failed() {
+			r.Failed++
+		}
+		if t.Interrupted {
+			r.Interrupted++
+		}
+		if r.First.IsZero() || t.Start.Before(r.First) {
+			r.First = t.Start
+		}
+		if t.End.After(r.Last) {
+			r.Last = t.End
+		}
+
+		c := chunks[t.Chunk]
+		if c == nil {
+			c = &ChunkTransfers{Chunk: t.Chunk, From: t.From, To: t.To}
+			chunks[t.Chunk] = c
+		}
+		c.From, c.To = min(c.From, t.From), max(c.To, t.To)
+		c.Attempts++
+		c.Bytes += t.Bytes
+		c.Time += t.Duration()
+		if t.Failed() {
+			c.Failed++
+			c.LastError = t.Error
+		}
+
+		s := sources[t.Source]
+		if s == nil {
+			s = &SourceTransfers{Source: t.Source}
+			sources[t.Source] = s
+		}
+		s.Transfers++
+		s.Bytes += t.Bytes
+		s.Time += t.Duration()
+		if t.Failed() {
+			s.Failed++
+		}
+	}
+
+	for _, c := range chunks {
+		r.Chunks = append(r.Chunks, *c)
+	}
+	sort.Slice(r.Chunks, func(i, j int) bool { return r.Chunks[i].Chunk < r.Chunks[j].Chunk })
+	for _, s := range sources {
+		r.Sources = append(r.Sources, *s)
+	}
+	sort.Slice(r.Sources, func(i, j int) bool {
+		if r.Sources[i].Bytes != r.Sources[j].Bytes {
+			return r.Sources[i].Bytes > r.Sources[j].Bytes
+		}
+		return r.Sources[i].Source < r.Sources[j].Source
+	})
+
+	r.Busy = busyTime(transfers)
+	r.Interval = interval
+	if r.Interval <= 0 {
+		r.Interval = timelineIntervals[len(timelineIntervals)-1]
+		for _, d := range timelineIntervals {
+			if r.Busy <= d*maxTimelineBuckets {
+				r.Interval = d
+				break
+			}
+		}
+	}
+	r.Timeline = timeline(transfers, r.Interval)
+	return r
+}
+
+// RetryHotspots returns up to n chunks with failed requests, the most
+// failures first.
+func (r TransferReport) RetryHotspots(n int) []ChunkTransfers {
+	var hot []ChunkTransfers
+	for _, c := range r.Chunks {
+		if c.Failed > 0 {
+			hot = append(hot, c)
+		}
+	}
+	sort.SliceStable(hot, func(i, j int) bool { return hot[i].Failed > hot[j].Failed })
+	return hot[:min(n, len(hot))]
+}
+
+// Slowest returns up to n chunks that took time, the slowest first.
+func (r TransferReport) Slowest(n int) []ChunkTransfers {
+	var slow []ChunkTransfers
+	for _, c := range r.Chunks {
+		if c.Time > 0 {
+			slow = append(slow, c)
+		}
+	}
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].Speed() < slow[j].Speed() })
+	return slow[:min(n, len(slow))]
+}
+
+// busyTime returns the time covered by at least one of the transfers.
+func busyTime(transfers []Transfer) time.Duration {
+	sorted := make([]Transfer, len(transfers))
+	copy(sorted, transfers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	var busy time.Duration
+	var start, end time.Time
+	for _, t := range sorted {
+		if t.Start.After(end) {
+			busy += end.Sub(start)
+			start, end = t.Start, t.End
+		} else if t.End.After(end) {
+			end = t.End
+		}
+	}
+	return busy + end.Sub(start)
+}
+
+// timeline spreads the transfers over buckets of interval.
+func timeline(transfers []Transfer, interval time.Duration) []TimelineBucket {
+	buckets := make(map[int64]*TimelineBucket)
+	bucket := func(at time.Time) *TimelineBucket {
+		start := at.Truncate(interval)
+		b := buckets[start.UnixNano()]
+		if b == nil {
+			b = &TimelineBucket{Start: start}
+			buckets[start.UnixNano()] = b
+		}
+		return b
+	}
+
+	for _, t := range transfers {
+		if t.Failed() {
+			bucket(t.End).Failed++
+		}
+		d := t.Duration()
+		if d <= 0 {
+			b := bucket(t.Start)
+			b.Transfers++
+			b.Bytes += t.Bytes
+			continue
+		}
+		// Each bucket gets the bytes of its share of the duration; the
+		// last one what rounding left
+		spread := int64(0)
+		for at := t.Start.Truncate(interval); at.Before(t.End); at = at.Add(interval) {
+			from, to := maxTime(at, t.Start), minTime(at.Add(interval), t.End)
+			b := bucket(at)
+			b.Transfers++
+			share := int64(float64(t.Bytes) * float64(to.Sub(from)) / float64(d))
+			if !to.Before(t.End) {
+				share = t.Bytes - spread
+			}
+			b.Bytes += share
+			spread += share
+		}
+	}
+
+	out := make([]TimelineBucket, 0, len(buckets))
+	for _, b := range buckets {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// perSecond returns bytes over d as bytes per second, 0 for no time.
+func perSecond(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / d.Seconds()
+}
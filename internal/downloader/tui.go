@@ -0,0 +1,263 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboardInterval is how often the dashboard is redrawn.
+const dashboardInterval = 500 * time.Millisecond
+
+// rateSteps are the rate limits cycled through with the [ and ] keys (0 = unlimited).
+var rateSteps = []int64{
+	100_000, 250_000, 500_000,
+	1_000_000, 2_000_000, 5_000_000,
+	10_000_000, 25_000_000, 50_000_000, 100_000_000,
+}
+
+// dashboard renders a full-screen view of a running download: one bar per
+// in-flight chunk with its speed and retries, overall progress and ETA, and
+// the post-part queue depth. Keys adjust concurrency and rate limit live.
+type dashboard struct {
+	d      *Downloader
+	cancel context.CancelFunc
+	out    io.Writer
+
+	prevBytes []int64
+	prevAt    time.Time
+
+	restore func()
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newDashboard creates a dashboard for d. cancel aborts the download on 'q'.
+func newDashboard(d *Downloader, cancel context.CancelFunc) *dashboard {
+	return &dashboard{
+		d:         d,
+		cancel:    cancel,
+		out:       os.Stdout,
+		prevBytes: make([]int64, d.progress.NumChunks()),
+		prevAt:    time.Now(),
+		done:      make(chan struct{}),
+	}
+}
+
+// start switches the tracker into dashboard mode and begins rendering.
+func (t *dashboard) start(ctx context.Context) {
+	t.d.progress.SetDashboard(true)
+
+	for i := range t.prevBytes {
+		t.prevBytes[i] = t.d.progress.Bytes(i)
+	}
+
+	// Hide the cursor and clear the screen
+	fmt.Fprint(t.out, "\033[?25l\033[2J")
+
+	if restore, err := makeRaw(os.Stdin); err == nil {
+		t.restore = restore
+		go t.readKeys(ctx)
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+
+		for {
+			t.render()
+			select {
+			case <-t.done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop renders a final frame, restores the terminal and returns to line output.
+func (t *dashboard) stop() {
+	close(t.done)
+	t.wg.Wait()
+	t.render()
+
+	if t.restore != nil {
+		t.restore()
+	}
+	fmt.Fprint(t.out, "\033[?25h\n")
+	t.d.progress.SetDashboard(false)
+}
+
+// readKeys handles single-key commands until ctx is done.
+// The reader goroutine may outlive the dashboard while blocked on stdin;
+// that is harmless since the process exits shortly after.
+func (t *dashboard) readKeys(ctx context.Context) {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		b, err := r.ReadByte()
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+
+		switch b {
+		case '+', '=':
+			t.d.SetConcurrency(t.d.Concurrency() + 1)
+		case '-', '_':
+			t.d.SetConcurrency(t.d.Concurrency() - 1)
+		case ']':
+			t.d.SetRateLimit(nextRateStep(t.d.RateLimit(), true))
+		case '[':
+			t.d.SetRateLimit(nextRateStep(t.d.RateLimit(), false))
+		case '0':
+			t.d.SetRateLimit(0)
+		case 'q':
+			t.d.progress.PrintMessage("Quit requested, shutting down...")
+			t.cancel()
+			return
+		}
+	}
+}
+
+// nextRateStep returns the next slower or faster rate limit step.
+// Going faster from the top step, or slower from unlimited, wraps through 0.
+func nextRateStep(current int64, faster bool) int64 {
+	if faster {
+		if current == 0 {
+			return 0
+		}
+		for _, step := range rateSteps {
+			if step > current {
+				return step
+			}
+		}
+		return 0
+	}
+
+	if current == 0 {
+		return rateSteps[len(rateSteps)-1]
+	}
+	for i := len(rateSteps) - 1; i >= 0; i-- {
+		if rateSteps[i] < current {
+			return rateSteps[i]
+		}
+	}
+	return rateSteps[0]
+}
+
+// render draws one frame of the dashboard.
+func (t *dashboard) render() {
+	p := t.d.progress
+	now := time.Now()
+	interval := now.Sub(t.prevAt).Seconds()
+	t.prevAt = now
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\033[K\n")
+	}
+
+	downloaded := p.DownloadedBytes()
+	total := p.TotalSize()
+	elapsed := p.TotalElapsed()
+	speed := p.RecentSpeed()
+
+	eta := "--"
+	merge := t.d.MergeEstimate()
+	left, moving := p.ETA()
+	switch {
+	case moving && downloaded < total:
+		eta = formatDuration(left + merge)
+		if merge > 0 {
+			eta += fmt.Sprintf(" (%s merging)", formatDuration(merge))
+		}
+	case downloaded >= total && merge > 0:
+		eta = formatDuration(merge) + " (merging)"
+	}
+
+	limit := "unlimited"
+	if l := t.d.RateLimit(); l > 0 {
+		limit = formatBytes(l) + "/s"
+	}
+
+	line("rapel  %s", t.d.args.FilenamePrefix)
+	line("%s %5.1f%%  %s/%s", progressBar(downloaded, total, 40), percent(downloaded, total),
+		formatBytes(downloaded), formatBytes(total))
+	line("%s", p.ChunkMap(64))
+	line("Chunks %d/%d  Speed %s/s  ETA %s  Elapsed %s",
+		p.CompletedCount(), p.NumChunks(), formatBytes(int64(speed)), eta, formatDuration(elapsed))
+	diskBound := ""
+	if p.DiskBound() {
+		diskBound = "  DISK-BOUND"
+	}
+	line("Jobs %d  Limit %s  Post-part queue %d%s", t.d.Concurrency(), limit, t.d.PostPartQueueDepth(), diskBound)
+	line("")
+
+	for i := 0; i < p.NumChunks(); i++ {
+		bytes := p.Bytes(i)
+		delta := bytes - t.prevBytes[i]
+		t.prevBytes[i] = bytes
+
+		if !p.IsActive(i) {
+			continue
+		}
+
+		var chunkSpeed float64
+		if interval > 0 && delta > 0 {
+			chunkSpeed = float64(delta) / interval
+		}
+
+		line("chunk %6d %s %5.1f%%  %s/s  retries %d",
+			i, progressBar(bytes, p.ExpectedSize(i), 30), percent(bytes, p.ExpectedSize(i)),
+			formatBytes(int64(chunkSpeed)), p.Retries(i))
+	}
+
+	line("")
+	for _, msg := range p.RecentMessages(5) {
+		line("%s", msg)
+	}
+	line("")
+	line("\033[2m[+/-] jobs  [ [/] ] rate limit  [0] unlimited  [q] quit\033[0m")
+
+	// Clear anything left over from a taller previous frame
+	b.WriteString("\033[J")
+
+	fmt.Fprint(t.out, b.String())
+}
+
+// progressBar renders a bar of the given width for done out of total.
+func progressBar(done, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(float64(width) * float64(done) / float64(total))
+	}
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// percent returns done as a percentage of total.
+func percent(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(done) * 100 / float64(total)
+}
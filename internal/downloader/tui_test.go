@@ -0,0 +1,29 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRateStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  int64
+		faster   bool
+		expected int64
+	}{
+		{name: "unlimited stays unlimited when faster", current: 0, faster: true, expected: 0},
+		{name: "unlimited to top step when slower", current: 0, faster: false, expected: 100_000_000},
+		{name: "top step to unlimited when faster", current: 100_000_000, faster: true, expected: 0},
+		{name: "bottom step stays when slower", current: 100_000, faster: false, expected: 100_000},
+		{name: "off-step value rounds up", current: 3_000_000, faster: true, expected: 5_000_000},
+		{name: "off-step value rounds down", current: 3_000_000, faster: false, expected: 2_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, nextRateStep(tt.current, tt.faster))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+)
+
+// requestURL returns the URL to send a request for [start, end] of chunk
+// index to: Config.URL, or a fresh one printed by Config.URLCmd. The
+// command runs for every attempt, so a URL signed for a few minutes is
+// never reused after it may have expired. The URL it prints is never
+// logged, as signed URLs carry credentials.
+func (d *Downloader) requestURL(ctx context.Context, index int, start, end int64) (string, error) {
+	if d.config.URLCmd == "" {
+		return d.config.URL, nil
+	}
+
+	base := DefaultPrefix(d.config.URL)
+	if d.args != nil {
+		base = d.args.FilenamePrefix
+	}
+	cmdStr := d.config.URLCmd
+	cmdStr = strings.ReplaceAll(cmdStr, "{url}", d.config.Hooks.quote(d.config.URL))
+	cmdStr = strings.ReplaceAll(cmdStr, "{start}", strconv.FormatInt(start, 10))
+	cmdStr = strings.ReplaceAll(cmdStr, "{end}", strconv.FormatInt(end, 10))
+	cmdStr = strings.ReplaceAll(cmdStr, "{idx}", strconv.Itoa(index))
+	cmdStr = strings.ReplaceAll(cmdStr, "{base}", base)
+
+	// Same environment rules as --fetch-cmd: signing usually needs the
+	// network (or at least credentials), never isolation
+	cmd := d.config.Hooks.command(ctx, cmdStr)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if out := tail(strings.TrimSpace(stderr.String()), fetchOutputTail); out != "" {
+			return "", fmt.Errorf("URL command failed: %w: %s", err, out)
+		}
+		return "", fmt.Errorf("URL command failed: %w", err)
+	}
+
+	// The last non-empty line, so commands that chatter first still work
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	signed := strings.TrimSpace(lines[len(lines)-1])
+	u, err := url.Parse(signed)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("URL command printed no http(s) URL")
+	}
+	return signed, nil
+}
+
+// sizeSigned sizes the file with a one-byte GET to a URL from
+// Config.URLCmd. URLs are usually signed for GET only, so a HEAD would be
+// refused.
+func (d *Downloader) sizeSigned(ctx context.Context) (int64, error) {
+	u, err := d.requestURL(ctx, 0, 0, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get content length: %w", err)
+	}
+	var probe httpclient.ProbeResult
+	err = d.preflight(ctx, func(ctx context.Context) error {
+		if probe = d.client.Probe(ctx, u, 1); probe.Error != "" {
+			return errors.New(probe.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get content length: %w", err)
+	}
+	if probe.Size <= 0 {
+		return 0, fmt.Errorf("failed to get content length: the server reported no size, use --size")
+	}
+	d.acceptRanges = "bytes"
+	if !probe.Ranges {
+		d.acceptRanges = ""
+	}
+	return probe.Size, nil
+}
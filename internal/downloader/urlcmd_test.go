@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLCmd(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Signed for GET only, like a presigned S3 URL
+		if r.Method != http.MethodGet || r.URL.Query().Get("sig") != "ok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	d, err := NewDownloader(Config{
+		URL:            "s3://bucket/data.bin",
+		ChunkSize:      100,
+		MaxConcurrency: 2,
+		URLCmd:         "echo {idx}:{start}-{end} >> calls; echo signing {url} >&2; echo '" + srv.URL + "/data.bin?sig=ok'",
+		HTTPConfig:     httpclient.Config{MaxRetries: 1, ConnectTimeout: time.Second, ReadTimeout: time.Second},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Download(context.Background()))
+
+	assert.Equal(t, "data.bin", d.GetArguments().OutputName)
+	for i := 0; i < 3; i++ {
+		start, end := d.GetArguments().ChunkRange(i)
+		part, err := os.ReadFile(d.GetArguments().PartPath(i))
+		require.NoError(t, err)
+		assert.Equal(t, content[start:end+1], part)
+	}
+
+	// Sizing, the Range check, then one per chunk
+	calls, err := os.ReadFile("calls")
+	require.NoError(t, err)
+	lines := strings.Fields(string(calls))
+	assert.Equal(t, []string{"0:0-0", "0:0-0"}, lines[:2])
+	assert.ElementsMatch(t, []string{"0:0-99", "1:100-199", "2:200-249"}, lines[2:])
+}
+
+func TestURLCmdErrors(t *testing.T) {
+	d := &Downloader{config: Config{URL: "s3://bucket/f", URLCmd: "echo not a url"}}
+	_, err := d.requestURL(context.Background(), 0, 0, 0)
+	assert.ErrorContains(t, err, "no http(s) URL")
+
+	d.config.URLCmd = "echo expired credentials >&2; exit 3"
+	_, err = d.requestURL(context.Background(), 0, 0, 0)
+	assert.ErrorContains(t, err, "expired credentials")
+}
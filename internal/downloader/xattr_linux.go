@@ -0,0 +1,11 @@
+package downloader
+
+import "syscall"
+
+// setXattr sets the extended attribute name of the file at path.
+func setXattr(path, name string, data []byte) error {
+	return syscall.Setxattr(path, name, data, 0)
+}
+
+// xattrSupported reports whether --part-meta xattr works on this platform.
+const xattrSupported = true
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package downloader
+
+import "errors"
+
+// setXattr fails: extended attributes are only wired up on Linux.
+func setXattr(path, name string, data []byte) error {
+	return errors.New("extended attributes are only supported on Linux")
+}
+
+// xattrSupported reports whether --part-meta xattr works on this platform.
+const xattrSupported = false
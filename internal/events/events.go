@@ -0,0 +1,187 @@
+// Package events writes a machine-readable stream of download lifecycle
+// events for wrapper programs (GUIs, orchestration agents), separate from
+// the human-oriented log output.
+//
+// Each event is framed as a 4-byte big-endian length followed by that many
+// bytes of a JSON object. Every object has "type" and "time" fields; the
+// remaining fields depend on the type. Package schema publishes them.
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/schema"
+)
+
+// Event types.
+const (
+	Start            = schema.EventStart            // url, file, size, chunk_size, chunks, completed
+	ChunkStart       = schema.EventChunkStart       // chunk, start, end
+	ChunkRetry       = schema.EventChunkRetry       // chunk, attempt, error
+	ChunkComplete    = schema.EventChunkComplete    // chunk, bytes
+	ChunkFailed      = schema.EventChunkFailed      // chunk, error
+	PostPartComplete = schema.EventPostPartComplete // chunk
+	PostPartFailed   = schema.EventPostPartFailed   // chunk, error
+	Paused           = schema.EventPaused           // until
+	Resumed          = schema.EventResumed          // window
+	Settings         = schema.EventSettings         // jobs, limit
+	DeadlineAtRisk   = schema.EventDeadlineAtRisk   // required, speed, remaining, deadline
+	DeadlineMissed   = schema.EventDeadlineMissed   // remaining
+	BanCooldown      = schema.EventBanCooldown      // status, seconds, until
+	Grew             = schema.EventGrew             // size, previous
+	SingleStream     = schema.EventSingleStream     // status
+	DiskBound        = schema.EventDiskBound        // share, jobs
+	DiskRecovered    = schema.EventDiskRecovered    // jobs
+	DownloadComplete = schema.EventDownloadComplete // bytes
+	MergeStart       = schema.EventMergeStart       // file
+	MergeComplete    = schema.EventMergeComplete    // outputs
+	Done             = schema.EventDone             // status (complete, skipped, error, cancelled), error
+)
+
+// Writer emits framed events. A nil *Writer discards everything, so
+// callers don't need to check whether an event stream was requested.
+type Writer struct {
+	out    *stream
+	fields []any // added to every event, see With
+}
+
+// stream is the destination Writers derived with With share.
+type stream struct {
+	mu     sync.Mutex
+	w      io.Writer // nil if the events only go to tees
+	closer io.Closer
+	failed bool
+	tees   []func(json.RawMessage)
+}
+
+// NewWriter returns a Writer emitting to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{out: &stream{w: w}}
+}
+
+// With returns a Writer adding fields, alternating keys and values, to
+// every event it emits to the same stream. It lets several downloads in
+// one process tell their events apart.
+func (w *Writer) With(fields ...any) *Writer {
+	if w == nil {
+		return nil
+	}
+	return &Writer{out: w.out, fields: append(slices.Clip(w.fields), fields...)}
+}
+
+// Tee returns a Writer that also hands the JSON of every event emitted to
+// its stream, through it or the Writers derived from it, to fn. fn is
+// called with the stream locked, in the order the events are emitted. On a
+// nil *Writer, Tee returns a Writer emitting to fn alone.
+func (w *Writer) Tee(fn func(json.RawMessage)) *Writer {
+	if w == nil {
+		return &Writer{out: &stream{tees: []func(json.RawMessage){fn}}}
+	}
+	w.out.mu.Lock()
+	defer w.out.mu.Unlock()
+	w.out.tees = append(w.out.tees, fn)
+	return w
+}
+
+// Open returns a Writer for an inherited file descriptor (fd > 0) or a
+// file or named pipe at path. It returns nil, nil if neither is set.
+// Opening a named pipe blocks until a reader opens the other end.
+func Open(fd int, path string) (*Writer, error) {
+	switch {
+	case fd > 0 && path != "":
+		return nil, fmt.Errorf("--events-fd and --events-file are mutually exclusive")
+	case fd > 0:
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("events-fd-%d", fd))
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("events fd %d is not open: %w", fd, err)
+		}
+		return &Writer{out: &stream{w: f, closer: f}}, nil
+	case path != "":
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open events file: %w", err)
+		}
+		return &Writer{out: &stream{w: f, closer: f}}, nil
+	}
+	return nil, nil
+}
+
+// Emit writes an event of type typ with fields given as alternating
+// key/value pairs, as with slog. Write failures are logged once and the
+// stream is then abandoned: a wrapper going away must not fail the
+// download.
+func (w *Writer) Emit(typ string, fields ...any) {
+	if w == nil {
+		return
+	}
+
+	fields = append(slices.Clip(w.fields), fields...)
+	ev := make(map[string]any, len(fields)/2+2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if key, ok := fields[i].(string); ok {
+			if err, ok := fields[i+1].(error); ok {
+				ev[key] = err.Error()
+			} else {
+				ev[key] = fields[i+1]
+			}
+		}
+	}
+	ev["type"] = typ
+	ev["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("failed to encode %s event: %v", typ, err), "event", typ)
+		return
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	out := w.out
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	for _, fn := range out.tees {
+		fn(data)
+	}
+	if out.w == nil || out.failed {
+		return
+	}
+	if _, err := out.w.Write(frame); err != nil {
+		out.failed = true
+		slog.Warn(fmt.Sprintf("event stream closed, no more events will be sent: %v", err), "error", err)
+	}
+}
+
+// Close closes the underlying file, if Writer opened one.
+func (w *Writer) Close() error {
+	if w == nil || w.out.closer == nil {
+		return nil
+	}
+	return w.out.closer.Close()
+}
+
+// Read reads the next event from r. It returns io.EOF at the end of the
+// stream.
+func Read(r io.Reader) (map[string]any, error) {
+	data, err := schema.ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ev map[string]any
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	return ev, nil
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitFramesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	w.Emit(ChunkComplete, "chunk", 3, "bytes", int64(1000))
+	w.Emit(ChunkRetry, "chunk", 4, "error", errors.New("connection reset"))
+
+	ev, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, ChunkComplete, ev["type"])
+	assert.Equal(t, float64(3), ev["chunk"])
+	assert.Equal(t, float64(1000), ev["bytes"])
+	assert.NotEmpty(t, ev["time"])
+
+	ev, err = Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, ChunkRetry, ev["type"])
+	assert.Equal(t, "connection reset", ev["error"])
+
+	_, err = Read(&buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWithAddsFields(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	a := w.With("url", "https://example.com/a")
+
+	a.Emit(Start)
+	w.Emit(Done, "status", "complete")
+
+	ev, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", ev["url"])
+	ev, err = Read(&buf)
+	require.NoError(t, err)
+	assert.NotContains(t, ev, "url")
+}
+
+type failingWriter struct{ writes int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestEmitStopsAfterWriteFailure(t *testing.T) {
+	fw := &failingWriter{}
+	w := NewWriter(fw)
+
+	w.Emit(Start)
+	w.Emit(Done, "status", "complete")
+	assert.Equal(t, 1, fw.writes)
+}
+
+func TestNilWriterDiscards(t *testing.T) {
+	var w *Writer
+	w.Emit(Start)
+	assert.NoError(t, w.Close())
+}
+
+func TestTee(t *testing.T) {
+	var teed []string
+	tee := func(data json.RawMessage) { teed = append(teed, string(data)) }
+
+	// A nil Writer emits to the tee alone
+	var w *Writer
+	w = w.Tee(tee)
+	w.With("url", "https://example.com/a").Emit(ChunkComplete, "chunk", 1)
+	require.Len(t, teed, 1)
+	assert.Contains(t, teed[0], `"url":"https://example.com/a"`)
+	assert.Contains(t, teed[0], `"type":"chunk_complete"`)
+
+	var buf bytes.Buffer
+	teed = nil
+	w = NewWriter(&buf).Tee(tee)
+	w.Emit(Done, "status", "complete")
+	ev, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, Done, ev["type"])
+	require.Len(t, teed, 1)
+	assert.Contains(t, teed[0], `"status":"complete"`)
+}
+
+func TestReadTruncated(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte{0, 0, 0, 10, '{'}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,108 @@
+// Package failure sorts the errors rapel stops on into a few kinds that
+// scripts can tell apart without parsing messages: each kind has a name,
+// reported in JSON output, and a process exit status.
+//
+// The kinds are sentinels matched with errors.Is. The code where a failure
+// starts marks its error with one (Mark, Errorf) or wraps the sentinel, and
+// Classify finds it again however far up the error travels.
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Failure kinds.
+var (
+	ErrUsage             = errors.New("usage error")
+	ErrRangeNotSupported = errors.New("the server ignores Range requests")
+	ErrSizeMismatch      = errors.New("size mismatch")
+	ErrAuth              = errors.New("authentication failed")
+	ErrDiskFull          = errors.New("no space left on device")
+	ErrChecksum          = errors.New("checksum mismatch")
+	ErrCancelled         = errors.New("cancelled")
+)
+
+// Kind describes one kind of failure.
+type Kind struct {
+	Name string // as reported in JSON, e.g. "size_mismatch"
+	Code int    // process exit status
+	Err  error  // the sentinel, nil for Other
+}
+
+// Other is the kind of errors that fit none of Kinds.
+var Other = Kind{Name: "error", Code: 1}
+
+// Kinds lists the failure kinds, in the order Classify tries them.
+var Kinds = []Kind{
+	{Name: "usage", Code: 2, Err: ErrUsage},
+	{Name: "range_not_supported", Code: 3, Err: ErrRangeNotSupported},
+	{Name: "size_mismatch", Code: 4, Err: ErrSizeMismatch},
+	{Name: "auth", Code: 5, Err: ErrAuth},
+	{Name: "disk_full", Code: 6, Err: ErrDiskFull},
+	{Name: "checksum", Code: 7, Err: ErrChecksum},
+	{Name: "cancelled", Code: 130, Err: ErrCancelled},
+}
+
+// Classify returns the kind of err, Other if it has none. A context
+// cancellation counts as cancelled and ENOSPC from the system as a full
+// disk, without being marked.
+func Classify(err error) Kind {
+	for _, k := range Kinds {
+		if errors.Is(err, k.Err) {
+			return k
+		}
+	}
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return kind(ErrDiskFull)
+	case errors.Is(err, context.Canceled):
+		return kind(ErrCancelled)
+	}
+	return Other
+}
+
+// ForCode returns the kind a rapel process exiting with code failed with,
+// Other for a code of no kind.
+func ForCode(code int) Kind {
+	for _, k := range Kinds {
+		if k.Code == code {
+			return k
+		}
+	}
+	return Other
+}
+
+func kind(sentinel error) Kind {
+	for _, k := range Kinds {
+		if k.Err == sentinel {
+			return k
+		}
+	}
+	return Other
+}
+
+// Mark returns err marked as being of kind, one of the sentinels. Its
+// message is err's, unchanged, and errors.Is and errors.As still see
+// through it to err.
+func Mark(err, kind error) error {
+	if err == nil {
+		return nil
+	}
+	return &marked{err: err, kind: kind}
+}
+
+// Errorf is fmt.Errorf marked as being of kind.
+func Errorf(kind error, format string, args ...any) error {
+	return Mark(fmt.Errorf(format, args...), kind)
+}
+
+type marked struct {
+	err  error
+	kind error
+}
+
+func (m *marked) Error() string   { return m.err.Error() }
+func (m *marked) Unwrap() []error { return []error{m.err, m.kind} }
@@ -0,0 +1,53 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		name string
+		code int
+	}{
+		{errors.New("boom"), "error", 1},
+		{fmt.Errorf("merging: %w", Errorf(ErrChecksum, "f has SHA-256 a, expected b")), "checksum", 7},
+		{fmt.Errorf("chunk 3: %w", ErrRangeNotSupported), "range_not_supported", 3},
+		{&fs.PathError{Op: "write", Path: "f.part0", Err: syscall.ENOSPC}, "disk_full", 6},
+		{fmt.Errorf("download: %w", context.Canceled), "cancelled", 130},
+		{Mark(errors.New("HEAD request returned status 401"), ErrAuth), "auth", 5},
+	} {
+		k := Classify(tc.err)
+		assert.Equal(t, tc.name, k.Name, tc.err.Error())
+		assert.Equal(t, tc.code, k.Code, tc.err.Error())
+	}
+}
+
+func TestMark(t *testing.T) {
+	assert.NoError(t, Mark(nil, ErrAuth))
+
+	cause := &fs.PathError{Op: "open", Path: "f", Err: fs.ErrNotExist}
+	err := Mark(cause, ErrSizeMismatch)
+	assert.Equal(t, cause.Error(), err.Error())
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	var pathErr *fs.PathError
+	assert.ErrorAs(t, err, &pathErr)
+	assert.NotErrorIs(t, err, ErrChecksum)
+}
+
+func TestKindsDistinct(t *testing.T) {
+	names, codes := map[string]bool{Other.Name: true}, map[int]bool{Other.Code: true}
+	for _, k := range Kinds {
+		assert.False(t, names[k.Name], k.Name)
+		assert.False(t, codes[k.Code], k.Name)
+		names[k.Name], codes[k.Code] = true, true
+	}
+}
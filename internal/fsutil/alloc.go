@@ -0,0 +1,18 @@
+package fsutil
+
+import "os"
+
+// Preallocate grows f to size bytes, reserving the disk blocks up front
+// where the filesystem allows it, so a file written out of order isn't
+// fragmented and running out of space fails at once rather than midway.
+// Elsewhere the file is only extended (sparse).
+func Preallocate(f *os.File, size int64) error {
+	return preallocate(f, size)
+}
+
+// PunchHole deallocates n bytes of f from off, which then read as zeros,
+// keeping its size. It fails with errors.ErrUnsupported where the OS or
+// filesystem can't.
+func PunchHole(f *os.File, off, n int64) error {
+	return punchHole(f, off, n)
+}
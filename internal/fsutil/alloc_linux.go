@@ -0,0 +1,29 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		// e.g. tmpfs before 3.5, or some FUSE and network filesystems
+		return f.Truncate(size)
+	}
+	return err
+}
+
+// FALLOC_FL_KEEP_SIZE | FALLOC_FL_PUNCH_HOLE
+const fallocPunchHole = 0x01 | 0x02
+
+func punchHole(f *os.File, off, n int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocPunchHole, off, n)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
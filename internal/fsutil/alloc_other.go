@@ -0,0 +1,16 @@
+//go:build !linux
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+func punchHole(f *os.File, off, n int64) error {
+	return errors.ErrUnsupported
+}
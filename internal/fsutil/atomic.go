@@ -0,0 +1,20 @@
+package fsutil
+
+import "os"
+
+// WriteFileAtomic replaces path with data by writing it to path.tmp first
+// and renaming that over path, so a reader or a crash midway sees the old
+// contents or the new ones, never part of either. A failed write leaves
+// path as it was and removes the temporary file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
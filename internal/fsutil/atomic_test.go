@@ -0,0 +1,31 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	require.NoError(t, WriteFileAtomic(path, []byte("old"), 0600))
+	require.NoError(t, WriteFileAtomic(path, []byte("new"), 0600))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoFileExists(t, path+".tmp")
+
+	// A rename that fails leaves the old file and no temporary one
+	dir := filepath.Join(t.TempDir(), "dir")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.Error(t, WriteFileAtomic(dir, []byte("x"), 0644))
+	assert.DirExists(t, filepath.Join(dir, "sub"))
+	assert.NoFileExists(t, dir+".tmp")
+}
@@ -0,0 +1,78 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RotateBackups keeps the current contents of path as path.1 before it is
+// replaced, shifting older generations up to path.keep and dropping the
+// rest. It does nothing when keep is 0 or path doesn't exist yet.
+func RotateBackups(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	for _, b := range Backups(path) {
+		if n, _ := backupGeneration(path, b); n >= keep {
+			os.Remove(b)
+		}
+	}
+	for n := keep - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(path, n), backupPath(path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate backups of %s: %w", path, err)
+		}
+	}
+
+	if err := os.WriteFile(backupPath(path, 1), data, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return nil
+}
+
+// Backups returns the existing backups of path, newest first.
+func Backups(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	var backups []string
+	for _, m := range matches {
+		if _, ok := backupGeneration(path, m); ok {
+			backups = append(backups, m)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		a, _ := backupGeneration(path, backups[i])
+		b, _ := backupGeneration(path, backups[j])
+		return a < b
+	})
+	return backups
+}
+
+// RemoveBackups deletes every backup of path.
+func RemoveBackups(path string) {
+	for _, b := range Backups(path) {
+		os.Remove(b)
+	}
+}
+
+// backupPath returns the name of generation n of path's backups.
+func backupPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// backupGeneration parses the generation of a backup name.
+func backupGeneration(path, backup string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(backup, path+"."))
+	return n, err == nil && n > 0 && backup == backupPath(path, n)
+}
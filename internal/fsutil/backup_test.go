@@ -0,0 +1,42 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	read := func(p string) string {
+		data, err := os.ReadFile(p)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.NoError(t, RotateBackups(path, 2))
+	assert.Empty(t, Backups(path))
+
+	for _, gen := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, RotateBackups(path, 2))
+		require.NoError(t, os.WriteFile(path, []byte(gen), 0644))
+	}
+	assert.Equal(t, "d", read(path))
+	assert.Equal(t, []string{path + ".1", path + ".2"}, Backups(path))
+	assert.Equal(t, "c", read(path+".1"))
+	assert.Equal(t, "b", read(path+".2"))
+
+	// Keeping fewer generations drops the older ones
+	require.NoError(t, RotateBackups(path, 1))
+	assert.Equal(t, []string{path + ".1"}, Backups(path))
+	assert.Equal(t, "d", read(path+".1"))
+
+	require.NoError(t, os.WriteFile(path+".tmp", nil, 0644))
+	RemoveBackups(path)
+	assert.Empty(t, Backups(path))
+	assert.FileExists(t, path)
+	assert.FileExists(t, path+".tmp")
+}
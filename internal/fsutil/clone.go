@@ -0,0 +1,19 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrCloneUnsupported is returned by CloneRange when the blocks can't be
+// shared and the data has to be copied instead.
+var ErrCloneUnsupported = errors.New("reflink not supported")
+
+// CloneRange makes n bytes of dst from dstOff share the disk blocks of src
+// from srcOff (a reflink), without copying data. It needs both files on
+// one filesystem that supports it (Btrfs, XFS, bcachefs, ...) and offsets
+// aligned to the filesystem's block size, except at the end of src;
+// otherwise it fails with ErrCloneUnsupported.
+func CloneRange(dst *os.File, dstOff int64, src *os.File, srcOff, n int64) error {
+	return cloneRange(dst, dstOff, src, srcOff, n)
+}
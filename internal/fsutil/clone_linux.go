@@ -0,0 +1,43 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ficloneRange is FICLONERANGE, _IOW(0x94, 13, struct file_clone_range),
+// on architectures using the generic ioctl encoding.
+const ficloneRange = 0x4020940d
+
+// fileCloneRange is struct file_clone_range.
+type fileCloneRange struct {
+	srcFd      int64
+	srcOffset  uint64
+	srcLength  uint64
+	destOffset uint64
+}
+
+func cloneRange(dst *os.File, dstOff int64, src *os.File, srcOff, n int64) error {
+	arg := fileCloneRange{
+		srcFd:      int64(src.Fd()),
+		srcOffset:  uint64(srcOff),
+		srcLength:  uint64(n),
+		destOffset: uint64(dstOff),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficloneRange, uintptr(unsafe.Pointer(&arg)))
+	switch {
+	case errno == 0:
+		return nil
+	case errors.Is(errno, syscall.EOPNOTSUPP), errors.Is(errno, syscall.EXDEV), errors.Is(errno, syscall.EINVAL),
+		errors.Is(errno, syscall.ENOTTY), errors.Is(errno, syscall.EBADF), errors.Is(errno, syscall.EPERM):
+		// Not this filesystem, not one filesystem, or not block aligned
+		return fmt.Errorf("%w: %v", ErrCloneUnsupported, errno)
+	default:
+		return errno
+	}
+}
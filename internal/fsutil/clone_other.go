@@ -0,0 +1,9 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package fsutil
+
+import "os"
+
+func cloneRange(dst *os.File, dstOff int64, src *os.File, srcOff, n int64) error {
+	return ErrCloneUnsupported
+}
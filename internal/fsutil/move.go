@@ -0,0 +1,103 @@
+// Package fsutil holds file operations shared by the downloader, merger
+// and splitter.
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyBufferSize is the buffer used for cross-filesystem copies.
+const copyBufferSize = 1 << 20
+
+// Move renames src to dst. When they are on different filesystems, where
+// rename fails, it copies src to a temporary file next to dst, syncs it,
+// renames it into place and removes src. The copy needs free space for the
+// whole file on dst's filesystem while src still exists. progress, if not
+// nil, is called as bytes are copied.
+func Move(src, dst string, progress func(copied, total int64)) error {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	if err := copyFile(src, dst, progress); err != nil {
+		return err
+	}
+
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("copied to %s but failed to remove %s: %w", dst, src, err)
+	}
+	return nil
+}
+
+// IsCrossDevice reports whether err is a rename failure caused by src and
+// dst being on different filesystems.
+func IsCrossDevice(err error) bool {
+	return isCrossDevice(err)
+}
+
+// copyFile copies src to dst via dst's directory, so dst appears atomically.
+func copyFile(src, dst string, progress func(copied, total int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".moving")
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	buf := make([]byte, copyBufferSize)
+	var copied int64
+	for {
+		n, rerr := in.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return fail(fmt.Errorf("failed to write %s: %w", tmpPath, werr))
+			}
+			copied += int64(n)
+			if progress != nil {
+				progress(copied, info.Size())
+			}
+		}
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return fail(fmt.Errorf("failed to read %s: %w", src, rerr))
+		}
+	}
+
+	// The source is deleted next, so the copy must be durable first
+	if err := out.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync %s: %w", tmpPath, err))
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+	return nil
+}
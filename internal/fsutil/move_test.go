@@ -0,0 +1,48 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveSameFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	require.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+
+	require.NoError(t, Move(src, dst, nil))
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(got))
+	assert.NoFileExists(t, src)
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	data := make([]byte, 3*copyBufferSize+5)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, os.WriteFile(src, data, 0640))
+
+	var calls int
+	var last int64
+	require.NoError(t, copyFile(src, dst, func(copied, total int64) {
+		calls++
+		last = copied
+		assert.Equal(t, int64(len(data)), total)
+	}))
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Equal(t, int64(len(data)), last)
+	assert.GreaterOrEqual(t, calls, 4)
+	assert.NoFileExists(t, filepath.Join(dir, ".b.moving"))
+}
@@ -0,0 +1,7 @@
+package fsutil
+
+// FreeSpace returns the bytes available to an unprivileged user on the
+// filesystem holding dir.
+func FreeSpace(dir string) (int64, error) {
+	return freeSpace(dir)
+}
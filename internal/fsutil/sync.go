@@ -0,0 +1,16 @@
+package fsutil
+
+import "os"
+
+// SyncData flushes f's data to the disk, and of its metadata only what
+// reading the data back needs, such as its size (fdatasync where the OS
+// has it, fsync elsewhere).
+func SyncData(f *os.File) error {
+	return syncData(f)
+}
+
+// SyncDir flushes the directory at path, so files created or renamed in
+// it survive a crash. Windows can't, and needn't: it is a no-op there.
+func SyncDir(path string) error {
+	return syncDir(path)
+}
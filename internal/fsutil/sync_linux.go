@@ -0,0 +1,12 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"os"
+	"syscall"
+)
+
+func syncData(f *os.File) error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fsutil
+
+import "os"
+
+func syncData(f *os.File) error {
+	return f.Sync()
+}
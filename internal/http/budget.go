@@ -0,0 +1,149 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// budgetPoll is how often a request waiting for a slot tries again.
+const budgetPoll = 200 * time.Millisecond
+
+// Budget caps the requests in flight across every rapel process that uses
+// the same budget directory: per host, so several downloads from one
+// origin don't add up to more connections than it tolerates, and in
+// total. Each slot is a lock file held for the duration of a request, so
+// the slots of a process that dies are freed with it.
+type Budget struct {
+	Dir     string // where the slot lock files live
+	PerHost int    // max requests in flight to one host (0 = unlimited)
+	Total   int    // max requests in flight to all hosts (0 = unlimited)
+
+	waited atomic.Bool
+}
+
+// Enabled reports whether the budget limits anything.
+func (b *Budget) Enabled() bool {
+	return b != nil && (b.PerHost > 0 || b.Total > 0)
+}
+
+// acquire waits for a slot for host (and one of the total slots) and
+// returns the function that frees them.
+func (b *Budget) acquire(ctx context.Context, host string) (func(), error) {
+	if err := os.MkdirAll(b.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create connection budget directory: %w", err)
+	}
+	for {
+		release, err := b.tryAcquire(host)
+		if err != nil || release != nil {
+			return release, err
+		}
+		if !b.waited.Swap(true) {
+			slog.Info(fmt.Sprintf("Waiting for other rapel downloads to free a connection to %s (--max-per-host %d, --max-total-connections %d)",
+				host, b.PerHost, b.Total), "host", host)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(budgetPoll):
+		}
+	}
+}
+
+// tryAcquire takes the slots without waiting, or returns nil if they are
+// all taken.
+func (b *Budget) tryAcquire(host string) (func(), error) {
+	hostSlot, err := b.trySlot("host-"+slotName(host), b.PerHost)
+	if hostSlot == nil || err != nil {
+		return nil, err
+	}
+	totalSlot, err := b.trySlot("total", b.Total)
+	if totalSlot == nil || err != nil {
+		hostSlot()
+		return nil, err
+	}
+	return func() {
+		totalSlot()
+		hostSlot()
+	}, nil
+}
+
+// trySlot takes one of n slots named prefix. With no limit (n = 0) it
+// takes nothing and succeeds.
+func (b *Budget) trySlot(prefix string, n int) (func(), error) {
+	if n <= 0 {
+		return func() {}, nil
+	}
+	for i := 0; i < n; i++ {
+		lock, err := registry.Acquire(filepath.Join(b.Dir, fmt.Sprintf("%s-%d.lock", prefix, i)))
+		if err == nil {
+			return func() { lock.Release() }, nil
+		}
+		if !errors.Is(err, registry.ErrLocked) {
+			return nil, fmt.Errorf("connection budget: %w", err)
+		}
+	}
+	return nil, nil
+}
+
+// slotName makes host safe to use in a file name.
+func slotName(host string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, host)
+}
+
+// budgetTransport holds a budget slot from the start of each request
+// until its response body is closed.
+type budgetTransport struct {
+	base   http.RoundTripper
+	budget *Budget
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.budget.acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: sync.OnceFunc(release)}
+	return resp, nil
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// underlying transport.
+func (t *budgetTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// releasingBody frees the request's budget slot when closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetSlots(t *testing.T) {
+	dir := t.TempDir()
+	// Two processes sharing the directory
+	a := &Budget{Dir: dir, PerHost: 1, Total: 2}
+	b := &Budget{Dir: dir, PerHost: 1, Total: 2}
+
+	release, err := a.tryAcquire("example.com")
+	require.NoError(t, err)
+	require.NotNil(t, release)
+
+	none, err := b.tryAcquire("example.com")
+	require.NoError(t, err)
+	assert.Nil(t, none, "the host's only slot is taken")
+
+	other, err := b.tryAcquire("other.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	none, err = b.tryAcquire("third.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, none, "both total slots are taken")
+
+	release()
+	again, err := b.tryAcquire("example.com")
+	require.NoError(t, err)
+	assert.NotNil(t, again)
+}
+
+func TestBudgetTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Budget: &Budget{Dir: t.TempDir(), PerHost: 1}})
+	require.NoError(t, err)
+
+	first, err := c.HTTPClient().Get(srv.URL)
+	require.NoError(t, err)
+
+	// The slot is held until the first body is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 3*budgetPoll)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.HTTPClient().Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	io.Copy(io.Discard, first.Body)
+	first.Body.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	second, err := c.HTTPClient().Do(req)
+	require.NoError(t, err)
+	second.Body.Close()
+}
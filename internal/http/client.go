@@ -1,80 +1,354 @@
-// Package http provides an HTTP client with retry logic and support for byte-range requests.
+// Package http provides an HTTP client for byte-range requests, with
+// proxies, connection limits and TLS options. It makes each request once:
+// a failed one is returned with its StatusError or network error, for the
+// caller (the downloader, per chunk) to retry.
 package http
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"sync/atomic"
 	"time"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/redact"
 )
 
 // Config holds HTTP client configuration
 type Config struct {
-	ProxyURL       string
-	MaxRetries     int
-	ConnectTimeout time.Duration
-	ReadTimeout    time.Duration
+	ProxyURL        string        // Optional: proxy, or several separated by commas to spread requests over (see Breaker)
+	ProxyRules      []ProxyRule   // Optional: per-host overrides evaluated before ProxyURL
+	Breaker         Breaker       // Optional: when to take a failing proxy out of the rotation
+	MaxRetries      int           // Retries the caller makes per chunk; the client itself doesn't retry
+	ConnectTimeout  time.Duration // TCP connect timeout (0 = none)
+	ReadTimeout     time.Duration // Time to wait for response headers
+	MaxConnsPerHost int           // Optional: cap on connections per host, busy or idle (0 = unlimited)
+	KeepAlive       time.Duration // Optional: TCP keep-alive probe interval (0 = 30s, negative = off)
+	TCPFastOpen     bool          // Optional: use TCP Fast Open (Linux only)
+	Resolve         []Resolve     // Optional: fixed addresses for hosts, bypassing DNS
+	PinIP           bool          // Optional: keep connecting to the address a host was first reached at
+	SourceIP        string        // Optional: local address to connect from
+	Interface       string        // Optional: network interface to connect from (ignored with SourceIP)
+	ReadBufferSize  int           // Optional: socket read buffer and copy size in bytes (0 = 32KB)
+	MaxSkip         int64         // Optional: on a 200 to a range starting at most this far in, discard the bytes before it (0 = never)
+	TLS             TLSConfig     // Optional: CA bundle, client certificate, verification
+	Budget          *Budget       // Optional: requests in flight shared with other rapel processes
+	NoVerifyDigest  bool          // Optional: don't check 206 responses against their Content-MD5 or Content-Digest
+	Header          http.Header   // Optional: sent with every request, Authorization not past a redirect to another host
+	Fingerprint     Fingerprint   // Optional: User-Agent, Referer and Accept headers of every request
 }
 
-// Client wraps http.Client with retry logic
+// Client wraps http.Client for range requests
 type Client struct {
-	client *http.Client
-	config Config
+	client   *http.Client
+	config   Config
+	conns    *connTracker
+	traffic  trafficCounters
+	rotation *rotation    // nil unless ProxyURL lists several proxies
+	size     atomic.Int64 // see ExpectSize
+}
+
+// Traffic summarizes the range requests a Client made: how the server
+// answered them and how many body bytes came over the wire.
+type Traffic struct {
+	Partial int64 // 206 responses
+	Full    int64 // 200 responses: the server ignored the Range header
+	Other   int64 // any other status
+	Bytes   int64 // response body bytes read, used or not
+}
+
+// Requests returns the number of responses received.
+func (t Traffic) Requests() int64 {
+	return t.Partial + t.Full + t.Other
+}
+
+type trafficCounters struct {
+	partial, full, other, bytes atomic.Int64
+}
+
+// Traffic returns the counters for range requests made so far.
+func (c *Client) Traffic() Traffic {
+	return Traffic{
+		Partial: c.traffic.partial.Load(),
+		Full:    c.traffic.full.Load(),
+		Other:   c.traffic.other.Load(),
+		Bytes:   c.traffic.bytes.Load(),
+	}
 }
 
 // NewClient creates a new HTTP client with the given configuration
+//
+// All chunk workers share the client's single Transport, so connections
+// are reused across chunks. ConnectTimeout only bounds connecting: a
+// chunk transfer may take as long as it needs.
 func NewClient(config Config) (*Client, error) {
+	if config.TCPFastOpen && !fastOpenSupported {
+		return nil, fmt.Errorf("TCP Fast Open is only supported on Linux")
+	}
+	if config.KeepAlive == 0 {
+		config.KeepAlive = defaultKeepAlive
+	}
+	if config.ReadBufferSize <= 0 {
+		config.ReadBufferSize = defaultReadBuffer
+	}
+	tlsConfig, err := config.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+	if config.TCPFastOpen {
+		dialer.Control = fastOpenControl
+	}
+	local, err := localAddr(config.SourceIP, config.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil {
+		dialer.LocalAddr = local
+	}
+
+	conns := newConnTracker()
 	transport := &http.Transport{
+		DialContext:           conns.dialer(newPinningDialer(config.Resolve, config.PinIP, dialer.DialContext)),
+		TLSHandshakeTimeout:   config.ConnectTimeout,
 		ResponseHeaderTimeout: config.ReadTimeout,
 		IdleConnTimeout:       90 * time.Second,
 		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   10,
+		MaxIdleConnsPerHost:   100,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		ReadBufferSize:        config.ReadBufferSize,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
 	}
 
-	// Configure proxy if provided
-	if config.ProxyURL != "" {
-		proxyURL, err := url.Parse(config.ProxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid proxy URL: %w", err)
-		}
-		transport.Proxy = http.ProxyURL(proxyURL)
+	// Configure proxy: per-host rules, then NO_PROXY, then -x, then environment
+	proxies, err := splitProxies(config.ProxyURL)
+	if err != nil {
+		return nil, err
 	}
+	var proxyURL *url.URL
+	if len(proxies) > 0 {
+		proxyURL = proxies[0]
+	}
+	transport.Proxy = proxyFunc(config.ProxyRules, proxyURL)
 
+	var base http.RoundTripper = transport
+	var rot *rotation
+	if len(proxies) > 1 {
+		rot = newRotation(transport, config.ProxyRules, proxies, config.Breaker)
+		base = rot
+	}
 	client := &http.Client{
-		Transport: transport,
-		Timeout:   config.ConnectTimeout,
+		Transport: base,
+	}
+	if config.Budget.Enabled() {
+		client.Transport = &budgetTransport{base: base, budget: config.Budget}
+	}
+	if config.Fingerprint.enabled() {
+		client.Transport = &fingerprintTransport{base: client.Transport, fp: config.Fingerprint}
 	}
 
 	return &Client{
-		client: client,
-		config: config,
+		client:   client,
+		config:   config,
+		conns:    conns,
+		rotation: rot,
 	}, nil
 }
 
-// GetContentLength performs a HEAD request to get the content length
-func (c *Client) GetContentLength(ctx context.Context, url string) (int64, error) {
-	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+// ProxyHealth returns how the requests through each proxy went, when
+// ProxyURL lists several, or nil.
+func (c *Client) ProxyHealth() []ProxyHealth {
+	if c.rotation == nil {
+		return nil
+	}
+	return c.rotation.health()
+}
+
+// HTTPClient returns the underlying http.Client, for requests other than
+// ranged GETs that should share its proxy, TLS settings and connections.
+func (c *Client) HTTPClient() *http.Client {
+	return c.client
+}
+
+// StatusError is returned for a range request the server answered with an
+// error status.
+type StatusError struct {
+	Code       int
+	RetryAfter string // the Retry-After header, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+// Is reports 401, 403 and 407 answers as failure.ErrAuth.
+func (e *StatusError) Is(target error) bool {
+	return target == failure.ErrAuth && (e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden || e.Code == http.StatusProxyAuthRequired)
+}
+
+// SizeError is returned for a range request whose answer puts the file
+// at another size than ExpectSize was given: a 206 with another total in
+// its Content-Range, or a 416, which says the range is past the end.
+type SizeError struct {
+	Code     int   // 206 or 416
+	Size     int64 // the size the server gives, 0 if it doesn't
+	Expected int64
+}
+
+func (e *SizeError) Error() string {
+	if e.Size > 0 && e.Size != e.Expected {
+		return fmt.Sprintf("server gives the file's size as %d bytes, not %d (status %d)", e.Size, e.Expected, e.Code)
+	}
+	return fmt.Sprintf("server refused the range as past the end of the %d-byte file (status %d)", e.Expected, e.Code)
+}
+
+// ExpectSize has range requests check the file's size the answers give
+// against size, failing with a *SizeError when it differs (0 = no check).
+func (c *Client) ExpectSize(size int64) {
+	c.size.Store(size)
+}
+
+// requestStatusError is the error for a whole-file request answered with
+// status code, marked failure.ErrAuth like a StatusError would be.
+func requestStatusError(method string, code int) error {
+	err := fmt.Errorf("%s request returned status %d", method, code)
+	if (&StatusError{Code: code}).Is(failure.ErrAuth) {
+		return failure.Mark(err, failure.ErrAuth)
+	}
+	return err
+}
+
+// ErrRangeIgnored is returned when the server answered a request for a
+// later part of the file with the whole file. It is
+// failure.ErrRangeNotSupported.
+var ErrRangeIgnored = failure.ErrRangeNotSupported
+
+// CloseIdleConnections closes connections not in use, so the next requests
+// connect afresh (through a new proxy circuit, for instance).
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// RemoteFile is what a HEAD request tells about a download.
+type RemoteFile struct {
+	Size         int64
+	Filename     string   // sanitized Content-Disposition filename, "" if none
+	AcceptRanges string   // the Accept-Ranges header: "bytes", "none" or "" if not sent
+	MD5          string   // hex MD5 of the content, "" if the server doesn't tell
+	Digests      []Digest // checksums of the whole content the server sent (see WholeDigests)
+}
+
+// newRequest creates a request for url carrying Config.Header.
+func (c *Client) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create HEAD request: %w", err)
+		return nil, err
+	}
+	for key, values := range c.config.Header {
+		req.Header[key] = slices.Clone(values)
+	}
+	return req, nil
+}
+
+// Head performs a HEAD request to get the content length and the
+// server-suggested filename.
+func (c *Client) Head(ctx context.Context, url string) (RemoteFile, error) {
+	req, err := c.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("failed to create HEAD request: %w", err)
+	}
+	// S3 only sends an object's checksums when asked, and refuses a
+	// presigned URL with x-amz-* headers its signature doesn't cover
+	if req.URL.Query().Get("X-Amz-Signature") == "" {
+		req.Header.Set("x-amz-checksum-mode", "ENABLED")
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("HEAD request failed: %w", err)
+		return RemoteFile{}, fmt.Errorf("HEAD request failed: %w", redact.Error(err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("HEAD request returned status %d", resp.StatusCode)
+		return RemoteFile{}, requestStatusError("HEAD", resp.StatusCode)
 	}
 
 	if resp.ContentLength <= 0 {
-		return 0, fmt.Errorf("server did not provide content length")
+		return RemoteFile{}, fmt.Errorf("server did not provide content length")
+	}
+
+	return RemoteFile{
+		Size:         resp.ContentLength,
+		Filename:     dispositionFilename(resp.Header.Get("Content-Disposition")),
+		AcceptRanges: resp.Header.Get("Accept-Ranges"),
+		MD5:          contentMD5(resp.Header),
+		Digests:      WholeDigests(resp.Header),
+	}, nil
+}
+
+// contentMD5 returns the content's MD5 as the headers give it: from
+// Content-MD5, or from a strong ETag of 32 hex digits, which is what S3 and
+// servers like it send for a file uploaded in one piece.
+func contentMD5(h http.Header) string {
+	if sum, err := base64.StdEncoding.DecodeString(h.Get("Content-MD5")); err == nil && len(sum) == md5.Size {
+		return hex.EncodeToString(sum)
+	}
+	etag := h.Get("ETag")
+	if len(etag) == 2+2*md5.Size && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		if sum, err := hex.DecodeString(etag[1 : len(etag)-1]); err == nil {
+			return hex.EncodeToString(sum)
+		}
+	}
+	return ""
+}
+
+// checkSize returns a *SizeError if resp, the answer to a range request,
+// puts the file at another size than want.
+func checkSize(resp *http.Response, want int64) error {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if size := contentRangeTotal(resp.Header.Get("Content-Range")); size > 0 && size != want {
+			return &SizeError{Code: resp.StatusCode, Size: size, Expected: want}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return &SizeError{Code: resp.StatusCode, Size: contentRangeTotal(resp.Header.Get("Content-Range")), Expected: want}
+	}
+	return nil
+}
+
+// Get downloads a whole resource, such as a small control file, into w.
+func (c *Client) Get(ctx context.Context, url string, w io.Writer) error {
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	return resp.ContentLength, nil
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", redact.Error(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return requestStatusError("GET", resp.StatusCode)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("read failed: %w", redact.Error(err))
+	}
+	return nil
 }
 
 // DownloadRange downloads a byte range (no retry, caller handles retries)
@@ -84,7 +358,7 @@ func (c *Client) DownloadRange(ctx context.Context, url string, start, end int64
 
 // downloadRangeOnce attempts to download a byte range once
 func (c *Client) downloadRangeOnce(ctx context.Context, url string, start, end int64, writer io.Writer) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := c.newRequest(ctx, "GET", url)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -94,20 +368,55 @@ func (c *Client) downloadRangeOnce(ctx context.Context, url string, start, end i
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return fmt.Errorf("request failed: %w", redact.Error(err))
 	}
 	defer resp.Body.Close()
 
-	// Accept both 206 (Partial Content) and 200 (OK)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		c.traffic.partial.Add(1)
+	case http.StatusOK:
+		c.traffic.full.Add(1)
+	default:
+		c.traffic.other.Add(1)
+	}
+
+	if want := c.size.Load(); want > 0 {
+		if err := checkSize(resp, want); err != nil {
+			return err
+		}
+	}
 	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After")}
+	}
+	// A 200 is the whole file: its start is only the range asked for if
+	// that starts at 0, anything else would be written as the wrong bytes.
+	// A range close enough to the start is reached by reading past what
+	// comes before it.
+	if resp.StatusCode == http.StatusOK && start > 0 {
+		if start > c.config.MaxSkip {
+			return ErrRangeIgnored
+		}
+		skipped, err := io.CopyN(io.Discard, resp.Body, start)
+		c.traffic.bytes.Add(skipped)
+		if err != nil {
+			return fmt.Errorf("read failed skipping to byte %d of a 200 response: %w", start, err)
+		}
 	}
 
 	// Calculate expected bytes to enforce download limit
 	expectedBytes := end - start + 1
 
+	// A 206 with a checksum of its own bytes is checked once read in full
+	var verifier *Verifier
+	if resp.StatusCode == http.StatusPartialContent && !c.config.NoVerifyDigest {
+		if verifier = NewVerifier(RangeDigests(resp.Header)); verifier != nil {
+			writer = io.MultiWriter(writer, verifier)
+		}
+	}
+
 	// Copy with context cancellation check and byte limit enforcement
-	buf := make([]byte, 32*1024)
+	buf := make([]byte, c.config.ReadBufferSize)
 	var totalRead int64
 	for totalRead < expectedBytes {
 		select {
@@ -125,6 +434,7 @@ func (c *Client) downloadRangeOnce(ctx context.Context, url string, start, end i
 
 		n, err := resp.Body.Read(buf[:readSize])
 		if n > 0 {
+			c.traffic.bytes.Add(int64(n))
 			totalRead += int64(n)
 			if _, writeErr := writer.Write(buf[:n]); writeErr != nil {
 				return fmt.Errorf("write failed: %w", writeErr)
@@ -143,6 +453,9 @@ func (c *Client) downloadRangeOnce(ctx context.Context, url string, start, end i
 	if totalRead != expectedBytes {
 		return fmt.Errorf("incomplete download: expected %d bytes, got %d", expectedBytes, totalRead)
 	}
+	if verifier != nil {
+		return verifier.Check(fmt.Sprintf("bytes %d-%d", start, end))
+	}
 
 	return nil
 }
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraffic(t *testing.T) {
+	data := strings.Repeat("x", 1000)
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer ranged.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer plain.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.DownloadRange(ctx, ranged.URL, 0, 99, &buf))
+	require.NoError(t, c.DownloadRange(ctx, ranged.URL, 100, 199, &buf))
+	require.NoError(t, c.DownloadRange(ctx, plain.URL, 0, 49, &buf))
+	assert.Error(t, c.DownloadRange(ctx, missing.URL, 0, 49, &buf))
+
+	tr := c.Traffic()
+	assert.Equal(t, Traffic{Partial: 2, Full: 1, Other: 1, Bytes: 250}, tr)
+	assert.Equal(t, int64(4), tr.Requests())
+
+	// The whole file instead of a later range is refused, not written
+	buf.Reset()
+	assert.ErrorIs(t, c.DownloadRange(ctx, plain.URL, 50, 99, &buf), ErrRangeIgnored)
+	assert.Zero(t, buf.Len())
+}
+
+func TestMaxSkip(t *testing.T) {
+	data := "0123456789abcdefghij"
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer plain.Close()
+
+	c, err := NewClient(Config{MaxSkip: 10})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Reached by reading past the start of the whole file
+	var buf bytes.Buffer
+	require.NoError(t, c.DownloadRange(ctx, plain.URL, 10, 14, &buf))
+	assert.Equal(t, "abcde", buf.String())
+	assert.Equal(t, int64(15), c.Traffic().Bytes)
+
+	// Too far in
+	buf.Reset()
+	assert.ErrorIs(t, c.DownloadRange(ctx, plain.URL, 11, 14, &buf), ErrRangeIgnored)
+	assert.Zero(t, buf.Len())
+}
+
+func TestExpectSize(t *testing.T) {
+	data := strings.Repeat("x", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+	var buf bytes.Buffer
+	c.ExpectSize(1000)
+	require.NoError(t, c.DownloadRange(ctx, srv.URL, 0, 99, &buf))
+
+	// A 206 that gives another total
+	c.ExpectSize(2000)
+	var sizeErr *SizeError
+	require.ErrorAs(t, c.DownloadRange(ctx, srv.URL, 0, 99, &buf), &sizeErr)
+	assert.Equal(t, SizeError{Code: http.StatusPartialContent, Size: 1000, Expected: 2000}, *sizeErr)
+	assert.EqualError(t, sizeErr, "server gives the file's size as 1000 bytes, not 2000 (status 206)")
+
+	// A 416 for a range past the end
+	require.ErrorAs(t, c.DownloadRange(ctx, srv.URL, 1500, 1599, &buf), &sizeErr)
+	assert.Equal(t, SizeError{Code: http.StatusRequestedRangeNotSatisfiable, Size: 1000, Expected: 2000}, *sizeErr)
+
+	c.ExpectSize(0)
+	var statusErr *StatusError
+	assert.ErrorAs(t, c.DownloadRange(ctx, srv.URL, 1500, 1599, &buf), &statusErr)
+}
+
+func TestContentMD5(t *testing.T) {
+	const sum = "9e107d9d372bb6826bd81d3542a419d6"
+	for _, tc := range []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Content-Md5": {"nhB9nTcrtoJr2B01QqQZ1g=="}}, sum},
+		{http.Header{"Etag": {`"` + sum + `"`}}, sum},
+		{http.Header{"Etag": {`W/"` + sum + `"`}}, ""},
+		{http.Header{"Etag": {`"` + sum + `-3"`}}, ""},
+		{http.Header{"Etag": {`"5f1b2c3d-1a2b"`}}, ""},
+		{http.Header{}, ""},
+	} {
+		assert.Equal(t, tc.want, contentMD5(tc.header), tc.header)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	data := strings.Repeat("x", 100)
+	var seen []http.Header
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer cdn.Close()
+	// The same server under another host name
+	elsewhere := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		http.Redirect(w, r, elsewhere+"/f", http.StatusFound)
+	}))
+	defer hub.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}, "X-Client": {"rapel"}}
+	c, err := NewClient(Config{Header: header})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.DownloadRange(ctx, cdn.URL, 0, 9, &buf))
+	require.Len(t, seen, 1)
+	assert.Equal(t, "Bearer secret", seen[0].Get("Authorization"))
+	assert.Equal(t, "rapel", seen[0].Get("X-Client"))
+
+	// The token isn't handed on to another host
+	seen = nil
+	_, err = c.Head(ctx, hub.URL)
+	require.NoError(t, err)
+	require.Len(t, seen, 2)
+	assert.Equal(t, "Bearer secret", seen[0].Get("Authorization"))
+	assert.Empty(t, seen[1].Get("Authorization"))
+	assert.Equal(t, "rapel", seen[1].Get("X-Client"))
+	assert.Equal(t, []string{"Bearer secret"}, header["Authorization"])
+}
@@ -0,0 +1,269 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/redraw/rapel/internal/failure"
+)
+
+// Digest is a checksum of content that a server sent in a response header.
+type Digest struct {
+	Algorithm string // md5, sha1, sha256, sha512, crc32, crc32c or crc64nvme
+	Sum       []byte
+	Header    string // the header it came from, for reports
+}
+
+func (d Digest) String() string {
+	return fmt.Sprintf("%s %s (%s)", d.Algorithm, hex.EncodeToString(d.Sum), d.Header)
+}
+
+// crc64NVME is the CRC-64/NVME table S3 computes crc64nvme checksums with.
+var crc64NVME = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// New returns a hash that computes the digest's algorithm.
+func (d Digest) New() hash.Hash {
+	switch d.Algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	case "crc32":
+		return crc32.NewIEEE()
+	case "crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "crc64nvme":
+		return crc64.New(crc64NVME)
+	}
+	return nil
+}
+
+// digestSizes are the sums' sizes in bytes, by algorithm.
+var digestSizes = map[string]int{
+	"md5":       md5.Size,
+	"sha1":      sha1.Size,
+	"sha256":    sha256.Size,
+	"sha512":    sha512.Size,
+	"crc32":     crc32.Size,
+	"crc32c":    crc32.Size,
+	"crc64nvme": crc64.Size,
+}
+
+// fieldAlgorithms maps the algorithm names of Digest (RFC 3230) and
+// Repr-Digest and Content-Digest (RFC 9530) to Digest's.
+var fieldAlgorithms = map[string]string{
+	"md5":     "md5",
+	"sha":     "sha1",
+	"sha-256": "sha256",
+	"sha-512": "sha512",
+	"crc32c":  "crc32c",
+}
+
+// WholeDigests returns the digests of the whole file that the headers of a
+// HEAD or 200 response give: Digest, Repr-Digest, Content-Digest,
+// Content-MD5, x-amz-checksum-* and x-goog-hash. Content that was encoded
+// for the response has none that apply to the bytes a range request gets.
+func WholeDigests(h http.Header) []Digest {
+	if encoded(h) {
+		return nil
+	}
+	var digests []Digest
+	digests = append(digests, fieldDigests(h, "Digest", false)...)
+	digests = append(digests, fieldDigests(h, "Repr-Digest", true)...)
+	digests = append(digests, fieldDigests(h, "Content-Digest", true)...)
+	digests = append(digests, md5Digest(h)...)
+	digests = append(digests, amzDigests(h)...)
+	digests = append(digests, googDigests(h)...)
+	return digests
+}
+
+// RangeDigests returns the digests of a 206 response's own bytes: those of
+// Content-MD5 and Content-Digest. The others a server may send along
+// describe the whole file.
+func RangeDigests(h http.Header) []Digest {
+	if encoded(h) {
+		return nil
+	}
+	return append(fieldDigests(h, "Content-Digest", true), md5Digest(h)...)
+}
+
+func encoded(h http.Header) bool {
+	ce := strings.TrimSpace(h.Get("Content-Encoding"))
+	return ce != "" && !strings.EqualFold(ce, "identity")
+}
+
+// fieldDigests parses a list of algorithm=value pairs, with the values
+// base64 as in RFC 3230, or as RFC 9530's byte sequences (:base64:) when
+// structured is set.
+func fieldDigests(h http.Header, name string, structured bool) []Digest {
+	var digests []Digest
+	for _, v := range h.Values(name) {
+		for _, member := range strings.Split(v, ",") {
+			alg, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				continue
+			}
+			value, _, _ = strings.Cut(value, ";")
+			value = strings.TrimSpace(value)
+			if structured {
+				if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+					continue
+				}
+				value = value[1 : len(value)-1]
+			}
+			if d, ok := newDigest(fieldAlgorithms[strings.ToLower(strings.TrimSpace(alg))], value, name); ok {
+				digests = append(digests, d)
+			}
+		}
+	}
+	return digests
+}
+
+func md5Digest(h http.Header) []Digest {
+	if d, ok := newDigest("md5", h.Get("Content-MD5"), "Content-MD5"); ok {
+		return []Digest{d}
+	}
+	return nil
+}
+
+// amzDigests parses S3's x-amz-checksum-* headers. A checksum of checksums
+// of a multipart upload's parts (COMPOSITE, its value ending in -parts)
+// says nothing that can be checked without the part sizes.
+func amzDigests(h http.Header) []Digest {
+	if strings.EqualFold(h.Get("x-amz-checksum-type"), "COMPOSITE") {
+		return nil
+	}
+	var digests []Digest
+	for _, alg := range []string{"crc32", "crc32c", "crc64nvme", "sha1", "sha256"} {
+		name := "x-amz-checksum-" + alg
+		value := h.Get(name)
+		if strings.Contains(value, "-") {
+			continue
+		}
+		if d, ok := newDigest(alg, value, name); ok {
+			digests = append(digests, d)
+		}
+	}
+	return digests
+}
+
+// googDigests parses Google Cloud Storage's x-goog-hash headers:
+// crc32c=...,md5=..., or one header for each.
+func googDigests(h http.Header) []Digest {
+	var digests []Digest
+	for _, v := range h.Values("x-goog-hash") {
+		for _, member := range strings.Split(v, ",") {
+			alg, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				continue
+			}
+			alg = strings.ToLower(alg)
+			if alg != "md5" && alg != "crc32c" {
+				continue
+			}
+			if d, ok := newDigest(alg, value, "x-goog-hash"); ok {
+				digests = append(digests, d)
+			}
+		}
+	}
+	return digests
+}
+
+// newDigest decodes a base64 sum of alg, which must have alg's size.
+func newDigest(alg, value, header string) (Digest, bool) {
+	size, known := digestSizes[alg]
+	if !known || value == "" {
+		return Digest{}, false
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(sum) != size {
+		return Digest{}, false
+	}
+	return Digest{Algorithm: alg, Sum: sum, Header: header}, true
+}
+
+// DigestError reports content that doesn't match a digest the server sent
+// for it. It is failure.ErrChecksum.
+type DigestError struct {
+	What   string // what was hashed: "bytes 0-1023", a file name
+	Digest Digest
+	Got    []byte
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("%s: content doesn't match the server's %s checksum: expected %s, got %s (from the %s header)",
+		e.What, e.Digest.Algorithm, hex.EncodeToString(e.Digest.Sum), hex.EncodeToString(e.Got), e.Digest.Header)
+}
+
+func (e *DigestError) Is(target error) bool {
+	return target == failure.ErrChecksum
+}
+
+// Verifier hashes content as it is written, for every digest given, each
+// algorithm once.
+type Verifier struct {
+	digests []Digest
+	hashes  map[string]hash.Hash
+	w       io.Writer
+}
+
+// NewVerifier returns a Verifier for digests, or nil if there are none.
+func NewVerifier(digests []Digest) *Verifier {
+	if len(digests) == 0 {
+		return nil
+	}
+	v := &Verifier{digests: digests, hashes: make(map[string]hash.Hash)}
+	var writers []io.Writer
+	for _, d := range digests {
+		if _, ok := v.hashes[d.Algorithm]; !ok {
+			h := d.New()
+			v.hashes[d.Algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	v.w = io.MultiWriter(writers...)
+	return v
+}
+
+func (v *Verifier) Write(p []byte) (int, error) {
+	return v.w.Write(p)
+}
+
+// Check returns a *DigestError for the first digest the content written
+// doesn't match, naming it what.
+func (v *Verifier) Check(what string) error {
+	for _, d := range v.digests {
+		if got := v.hashes[d.Algorithm].Sum(nil); !bytes.Equal(got, d.Sum) {
+			return &DigestError{What: what, Digest: d, Got: got}
+		}
+	}
+	return nil
+}
+
+// Algorithms returns the algorithms the digests use, each once, in order.
+func (v *Verifier) Algorithms() []string {
+	var algs []string
+	for _, d := range v.digests {
+		if !slices.Contains(algs, d.Algorithm) {
+			algs = append(algs, d.Algorithm)
+		}
+	}
+	return algs
+}
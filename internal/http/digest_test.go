@@ -0,0 +1,124 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWholeDigests(t *testing.T) {
+	content := []byte("123456789")
+	sha := sha256.Sum256(content)
+	sum := md5.Sum(content)
+	b64 := base64.StdEncoding.EncodeToString
+
+	h := http.Header{}
+	h.Set("Digest", "SHA-256="+b64(sha[:])+", unixsum=30637")
+	h.Set("Repr-Digest", "sha-512=:AAAA:, sha-256=:"+b64(sha[:])+":")
+	h.Set("Content-MD5", b64(sum[:]))
+	h.Set("x-amz-checksum-crc32c", "4waSgw==")
+	h.Set("x-amz-checksum-crc64nvme", b64([]byte{0xae, 0x8b, 0x14, 0x86, 0x0a, 0x79, 0x98, 0x88}))
+	h.Add("x-goog-hash", "crc32c=4waSgw==")
+	h.Add("x-goog-hash", "md5="+b64(sum[:]))
+
+	digests := WholeDigests(h)
+	var found []string
+	for _, d := range digests {
+		found = append(found, d.Algorithm+" "+d.Header)
+	}
+	// unixsum, and a sha-512 of the wrong size, are left out
+	assert.Equal(t, []string{
+		"sha256 Digest", "sha256 Repr-Digest", "md5 Content-MD5",
+		"crc32c x-amz-checksum-crc32c", "crc64nvme x-amz-checksum-crc64nvme",
+		"crc32c x-goog-hash", "md5 x-goog-hash",
+	}, found)
+
+	v := NewVerifier(digests)
+	v.Write(content)
+	require.NoError(t, v.Check("f"))
+	assert.Equal(t, []string{"sha256", "md5", "crc32c", "crc64nvme"}, v.Algorithms())
+
+	v = NewVerifier(digests)
+	v.Write([]byte("12345678x"))
+	got := sha256.Sum256([]byte("12345678x"))
+	err := v.Check("f")
+	assert.ErrorIs(t, err, failure.ErrChecksum)
+	assert.EqualError(t, err, fmt.Sprintf("f: content doesn't match the server's sha256 checksum: expected %x, got %x (from the Digest header)", sha, got))
+
+	// Checksums of a multipart upload's parts, or of encoded content, don't apply
+	h = http.Header{}
+	h.Set("x-amz-checksum-crc32", "AAAAAA==-3")
+	assert.Empty(t, WholeDigests(h))
+	h.Set("x-amz-checksum-crc32", "AAAAAA==")
+	h.Set("x-amz-checksum-type", "COMPOSITE")
+	assert.Empty(t, WholeDigests(h))
+	h = http.Header{}
+	h.Set("Content-MD5", b64(sum[:]))
+	h.Set("Content-Encoding", "gzip")
+	assert.Empty(t, WholeDigests(h))
+	assert.Nil(t, NewVerifier(nil))
+}
+
+func TestDownloadRangeDigest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	var corrupt atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		body := bytes.Clone(content[start : end+1])
+		sum := md5.Sum(body)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		// The whole file's, which a range can't be checked against
+		w.Header().Set("x-goog-hash", "md5=AAAAAAAAAAAAAAAAAAAAAA==")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		if corrupt.Load() {
+			body[0] ^= 1
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, c.DownloadRange(context.Background(), srv.URL, 10, 29, &buf))
+	assert.Equal(t, content[10:30], buf.Bytes())
+
+	corrupt.Store(true)
+	err = c.DownloadRange(context.Background(), srv.URL, 10, 29, &buf)
+	assert.ErrorIs(t, err, failure.ErrChecksum)
+	var digestErr *DigestError
+	require.ErrorAs(t, err, &digestErr)
+	assert.Equal(t, "bytes 10-29", digestErr.What)
+	assert.Equal(t, "Content-MD5", digestErr.Digest.Header)
+
+	c, err = NewClient(Config{NoVerifyDigest: true})
+	require.NoError(t, err)
+	assert.NoError(t, c.DownloadRange(context.Background(), srv.URL, 10, 29, &buf))
+}
+
+func TestDigestAlgorithms(t *testing.T) {
+	// The check values of each algorithm's catalogue entry
+	for alg, want := range map[string]string{
+		"crc32":     "cbf43926",
+		"crc32c":    "e3069283",
+		"crc64nvme": "ae8b14860a799888",
+	} {
+		h := Digest{Algorithm: alg}.New()
+		h.Write([]byte("123456789"))
+		assert.Equal(t, want, hex.EncodeToString(h.Sum(nil)), alg)
+	}
+}
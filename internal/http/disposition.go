@@ -0,0 +1,72 @@
+package http
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxFilenameBytes caps names taken from Content-Disposition, leaving room
+// for the chunk and state file suffixes within common 255-byte limits.
+const maxFilenameBytes = 200
+
+// dispositionFilename returns the filename suggested by a
+// Content-Disposition header, sanitized for use as a local file name, or ""
+// if there is none. filename* (RFC 6266) takes precedence over filename.
+func dispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return SanitizeFilename(params["filename"])
+}
+
+// reservedNames are the device names Windows won't create a file under,
+// whatever the extension: NUL.txt is NUL.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename makes a server-supplied name safe to create in the
+// current directory on any OS: directories are stripped, control
+// characters dropped, characters Windows or macOS reserve replaced,
+// leading dots and trailing dots and spaces removed, Windows device names
+// (CON, NUL, COM1, ...) prefixed with an underscore and the length
+// capped. It returns "" if nothing usable is left.
+func SanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFilenameBytes {
+		cut := maxFilenameBytes
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	return name
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispositionFilename(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                                  "",
+		"inline":                            "",
+		`attachment; filename="report.pdf"`: "report.pdf",
+		`attachment; filename=report.pdf`:   "report.pdf",
+		`attachment; filename="fallback.txt"; filename*=UTF-8''na%C3%AFve%20file.txt`: "naïve file.txt",
+		`attachment; filename="../../etc/passwd"`:                                     "passwd",
+		`attachment; filename="C:\\temp\\a.bin"`:                                      "a.bin",
+		`attachment; filename="..."`:                                                  "",
+		`attachment; filename=".bashrc"`:                                              "bashrc",
+		`attachment; filename="a<b>:c?.iso "`:                                         "a_b__c_.iso",
+		`attachment; filename`:                                                        "",
+		`attachment; filename="NUL"`:                                                  "_NUL",
+		`attachment; filename="com3.tar.gz"`:                                          "_com3.tar.gz",
+		`attachment; filename="console.log"`:                                          "console.log",
+	} {
+		assert.Equal(t, want, dispositionFilename(header), header)
+	}
+
+	long := SanitizeFilename(strings.Repeat("é", 150))
+	assert.Len(t, long, maxFilenameBytes)
+}
+
+func TestHead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="dump.sql.gz"`)
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	remote, err := c.Head(context.Background(), srv.URL+"/download?id=123")
+	require.NoError(t, err)
+	assert.Equal(t, RemoteFile{Size: 1234, Filename: "dump.sql.gz"}, remote)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package http
+
+import "syscall"
+
+// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT from linux/tcp.h (Linux 4.11+).
+const tcpFastOpenConnect = 30
+
+// fastOpenControl enables TCP Fast Open on outgoing sockets, so repeat
+// connections to a server can carry the request in the SYN.
+func fastOpenControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// fastOpenSupported reports whether --tcp-fastopen works on this platform.
+const fastOpenSupported = true
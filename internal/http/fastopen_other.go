@@ -0,0 +1,13 @@
+//go:build !linux
+
+package http
+
+import "syscall"
+
+// fastOpenControl is a no-op: TCP Fast Open for clients is only wired up on Linux.
+func fastOpenControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// fastOpenSupported reports whether --tcp-fastopen works on this platform.
+const fastOpenSupported = false
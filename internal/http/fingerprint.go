@@ -0,0 +1,79 @@
+package http
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+// Fingerprint is how requests present themselves, for mirrors that
+// throttle or block Go's default client.
+type Fingerprint struct {
+	UserAgents []string // sent in turn, one per request (nil = Go's default)
+	Referer    string   // Optional: the Referer header
+	Randomize  bool     // vary Accept and Accept-Language from request to request
+}
+
+func (f Fingerprint) enabled() bool {
+	return len(f.UserAgents) > 0 || f.Referer != "" || f.Randomize
+}
+
+// acceptValues and acceptLanguages are what Fingerprint.Randomize picks
+// from: values browsers and download tools send.
+var (
+	acceptValues = []string{
+		"*/*",
+		"*/*;q=0.8",
+		"application/octet-stream,*/*;q=0.8",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+	}
+	acceptLanguages = []string{
+		"en-US,en;q=0.9",
+		"en-US,en;q=0.5",
+		"en-GB,en;q=0.9",
+		"en-GB,en-US;q=0.9,en;q=0.8",
+		"de-DE,de;q=0.9,en;q=0.8",
+		"fr-FR,fr;q=0.9,en;q=0.8",
+		"es-ES,es;q=0.9,en;q=0.8",
+	}
+)
+
+// fingerprintTransport sets the Fingerprint's headers on every request,
+// redirects included, unless the request sets them itself.
+type fingerprintTransport struct {
+	base http.RoundTripper
+	fp   Fingerprint
+	next atomic.Uint64 // the next of fp.UserAgents
+}
+
+func (t *fingerprintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must leave the request alone
+	r := req.Clone(req.Context())
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	setDefault := func(key, value string) {
+		if value != "" && r.Header.Get(key) == "" {
+			r.Header.Set(key, value)
+		}
+	}
+
+	if n := uint64(len(t.fp.UserAgents)); n > 0 {
+		setDefault("User-Agent", t.fp.UserAgents[(t.next.Add(1)-1)%n])
+	}
+	setDefault("Referer", t.fp.Referer)
+	if t.fp.Randomize {
+		setDefault("Accept", acceptValues[rand.IntN(len(acceptValues))])
+		setDefault("Accept-Language", acceptLanguages[rand.IntN(len(acceptLanguages))])
+	}
+	return t.base.RoundTrip(r)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// underlying transport.
+func (t *fingerprintTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
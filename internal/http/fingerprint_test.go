@@ -0,0 +1,61 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	var seen []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c, err := NewClient(Config{Fingerprint: Fingerprint{
+		UserAgents: []string{"agent/1", "agent/2"},
+		Referer:    "https://example.com/downloads",
+		Randomize:  true,
+	}})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	for range 3 {
+		require.NoError(t, c.DownloadRange(ctx, srv.URL, 0, 4, &buf))
+	}
+
+	require.Len(t, seen, 3)
+	var agents []string
+	for _, h := range seen {
+		agents = append(agents, h.Get("User-Agent"))
+		assert.Equal(t, "https://example.com/downloads", h.Get("Referer"))
+		assert.Contains(t, acceptValues, h.Get("Accept"))
+		assert.Contains(t, acceptLanguages, h.Get("Accept-Language"))
+	}
+	assert.Equal(t, []string{"agent/1", "agent/2", "agent/1"}, agents)
+
+	// A header the request sets itself is kept
+	seen = nil
+	c, err = NewClient(Config{Header: http.Header{"User-Agent": {"hub-client"}}, Fingerprint: Fingerprint{UserAgents: []string{"agent/1"}}})
+	require.NoError(t, err)
+	require.NoError(t, c.DownloadRange(ctx, srv.URL, 0, 4, &buf))
+	assert.Equal(t, "hub-client", seen[0].Get("User-Agent"))
+
+	// Without a fingerprint, Go's defaults
+	seen = nil
+	c, err = NewClient(Config{})
+	require.NoError(t, err)
+	require.NoError(t, c.DownloadRange(ctx, srv.URL, 0, 4, &buf))
+	assert.True(t, strings.HasPrefix(seen[0].Get("User-Agent"), "Go-http-client/"))
+	assert.False(t, slices.ContainsFunc([]string{"Referer", "Accept", "Accept-Language"}, func(k string) bool { return seen[0].Get(k) != "" }))
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// ProbeResult describes how a mirror answered a sample range request.
+type ProbeResult struct {
+	URL        string        `json:"url"`
+	Status     int           `json:"status,omitempty"`
+	Size       int64         `json:"size,omitempty"`       // Full file size, if reported
+	Ranges     bool          `json:"ranges"`               // Answered the Range request with 206
+	Latency    time.Duration `json:"latency_ns,omitempty"` // Time until response headers
+	Bytes      int64         `json:"bytes,omitempty"`      // Sample bytes received
+	Throughput float64       `json:"throughput,omitempty"` // Body bytes per second
+	Error      string        `json:"error,omitempty"`
+}
+
+// Usable reports whether the mirror can serve chunked downloads.
+func (r ProbeResult) Usable() bool {
+	return r.Error == "" && r.Ranges
+}
+
+// Probe requests the first sample bytes of url and measures the response.
+// Failures are recorded in the result rather than returned, so a dead
+// mirror still shows up in the ranking.
+func (c *Client) Probe(ctx context.Context, url string, sample int64) ProbeResult {
+	result := ProbeResult{URL: url}
+
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create request: %v", err)
+		return result
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sample-1))
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		result.Error = fmt.Sprintf("request failed: %v", redact.Error(err))
+		return result
+	}
+	defer resp.Body.Close()
+	result.Latency = time.Since(start)
+	result.Status = resp.StatusCode
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		result.Ranges = true
+		result.Size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		if resp.ContentLength > 0 {
+			result.Size = resp.ContentLength
+		}
+	default:
+		result.Error = fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
+		return result
+	}
+
+	// A server ignoring Range sends the whole file; stop after the sample.
+	bodyStart := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, sample))
+	elapsed := time.Since(bodyStart)
+	result.Bytes = n
+	if err != nil {
+		result.Error = fmt.Sprintf("read failed: %v", err)
+		return result
+	}
+	if elapsed > 0 {
+		result.Throughput = float64(n) / elapsed.Seconds()
+	}
+	return result
+}
+
+// contentRangeTotal returns the total size from a "bytes a-b/total"
+// header, or 0 if it is missing or unknown.
+func contentRangeTotal(header string) int64 {
+	i := strings.LastIndexByte(header, '/')
+	if i < 0 {
+		return 0
+	}
+	total, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil || total < 0 {
+		return 0
+	}
+	return total
+}
+
+// RankProbes orders results best first: usable mirrors before the rest,
+// then by throughput, then by latency.
+func RankProbes(results []ProbeResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Usable() != b.Usable() {
+			return a.Usable()
+		}
+		if a.Throughput != b.Throughput {
+			return a.Throughput > b.Throughput
+		}
+		return a.Latency < b.Latency
+	})
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	data := strings.Repeat("x", 1000)
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer ranged.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer plain.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	r := c.Probe(ctx, ranged.URL, 100)
+	assert.Empty(t, r.Error)
+	assert.True(t, r.Ranges)
+	assert.Equal(t, int64(1000), r.Size)
+	assert.Equal(t, int64(100), r.Bytes)
+	assert.True(t, r.Usable())
+
+	// Range ignored: only the sample is read, but the mirror isn't usable
+	r = c.Probe(ctx, plain.URL, 100)
+	assert.Empty(t, r.Error)
+	assert.False(t, r.Ranges)
+	assert.Equal(t, int64(100), r.Bytes)
+	assert.False(t, r.Usable())
+
+	r = c.Probe(ctx, missing.URL, 100)
+	assert.Equal(t, 404, r.Status)
+	assert.Contains(t, r.Error, "404")
+}
+
+func TestRankProbes(t *testing.T) {
+	results := []ProbeResult{
+		{URL: "dead", Error: "request failed"},
+		{URL: "norange", Throughput: 900},
+		{URL: "slow", Ranges: true, Throughput: 100},
+		{URL: "fast-far", Ranges: true, Throughput: 500, Latency: 200 * time.Millisecond},
+		{URL: "fast-near", Ranges: true, Throughput: 500, Latency: 20 * time.Millisecond},
+	}
+	RankProbes(results)
+
+	var order []string
+	for _, r := range results {
+		order = append(order, r.URL)
+	}
+	assert.Equal(t, []string{"fast-near", "fast-far", "slow", "norange", "dead"}, order)
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	assert.Equal(t, int64(1000), contentRangeTotal("bytes 0-99/1000"))
+	assert.Equal(t, int64(0), contentRangeTotal("bytes 0-99/*"))
+	assert.Equal(t, int64(0), contentRangeTotal(""))
+}
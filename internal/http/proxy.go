@@ -0,0 +1,128 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// ProxyDirect is the ProxyRule value that bypasses any proxy.
+const ProxyDirect = "direct"
+
+// ProxyRule routes requests whose host matches a glob pattern
+// (e.g. "*.internal", "example.com") through a specific proxy, or directly
+// when Proxy is "direct".
+type ProxyRule struct {
+	Host  string `json:"host"`
+	Proxy string `json:"proxy"`
+}
+
+// Validate checks that the rule has a usable pattern and proxy.
+func (r ProxyRule) Validate() error {
+	if r.Host == "" {
+		return fmt.Errorf("proxy rule is missing a host pattern")
+	}
+	if _, err := path.Match(r.Host, ""); err != nil {
+		return fmt.Errorf("bad host pattern %q: %w", r.Host, err)
+	}
+	if r.Proxy == "" {
+		return fmt.Errorf("proxy rule for %q is missing a proxy (use %q to bypass)", r.Host, ProxyDirect)
+	}
+	if r.Proxy != ProxyDirect {
+		if _, err := url.Parse(r.Proxy); err != nil {
+			return fmt.Errorf("bad proxy URL for %q: %w", r.Host, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether host matches the rule's pattern (case-insensitive).
+func (r ProxyRule) matches(host string) bool {
+	ok, _ := path.Match(strings.ToLower(r.Host), strings.ToLower(host))
+	return ok
+}
+
+// proxyFunc builds the transport's proxy selector. Precedence per request:
+// the first matching ProxyRule, then NO_PROXY, then the explicit proxy URL,
+// then the standard HTTP(S)_PROXY environment variables.
+func proxyFunc(rules []ProxyRule, explicit *url.URL) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+
+		for _, rule := range rules {
+			if !rule.matches(host) {
+				continue
+			}
+			if rule.Proxy == ProxyDirect {
+				return nil, nil
+			}
+			return url.Parse(rule.Proxy)
+		}
+
+		if explicit == nil {
+			return http.ProxyFromEnvironment(req)
+		}
+
+		if bypassProxy(host, noProxyEnv()) {
+			return nil, nil
+		}
+
+		return explicit, nil
+	}
+}
+
+// noProxyEnv returns the NO_PROXY value, preferring the upper-case variable.
+func noProxyEnv() string {
+	if v := os.Getenv("NO_PROXY"); v != "" {
+		return v
+	}
+	return os.Getenv("no_proxy")
+}
+
+// bypassProxy reports whether host is excluded by a NO_PROXY-style list:
+// comma-separated hostnames (matching the host and its subdomains), IPs,
+// CIDR ranges, or "*" for everything.
+func bypassProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// Drop a port if present; NO_PROXY entries are matched by host only
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+
+		if ip != nil {
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		noProxy  string
+		expected bool
+	}{
+		{name: "empty list", host: "example.com", noProxy: "", expected: false},
+		{name: "wildcard", host: "example.com", noProxy: "*", expected: true},
+		{name: "exact host", host: "example.com", noProxy: "example.com", expected: true},
+		{name: "subdomain", host: "cdn.example.com", noProxy: "example.com", expected: true},
+		{name: "leading dot", host: "cdn.example.com", noProxy: ".example.com", expected: true},
+		{name: "suffix is not a subdomain", host: "notexample.com", noProxy: "example.com", expected: false},
+		{name: "entry with port", host: "example.com", noProxy: "example.com:8080", expected: true},
+		{name: "ip match", host: "10.0.0.5", noProxy: "10.0.0.5", expected: true},
+		{name: "cidr match", host: "10.1.2.3", noProxy: "localhost, 10.0.0.0/8", expected: true},
+		{name: "cidr miss", host: "192.168.1.1", noProxy: "10.0.0.0/8", expected: false},
+		{name: "case insensitive", host: "Example.COM", noProxy: "example.com", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, bypassProxy(tt.host, tt.noProxy))
+		})
+	}
+}
+
+func TestProxyFuncRules(t *testing.T) {
+	t.Setenv("NO_PROXY", "skip.example.com")
+	explicit, _ := url.Parse("http://proxy:3128")
+
+	rules := []ProxyRule{
+		{Host: "*.internal", Proxy: ProxyDirect},
+		{Host: "*.onion", Proxy: "socks5h://127.0.0.1:9050"},
+	}
+	fn := proxyFunc(rules, explicit)
+
+	proxyFor := func(rawURL string) string {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		require.NoError(t, err)
+		u, err := fn(req)
+		require.NoError(t, err)
+		if u == nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	assert.Equal(t, "", proxyFor("http://files.internal/a.bin"))
+	assert.Equal(t, "socks5h://127.0.0.1:9050", proxyFor("http://abc.onion/a.bin"))
+	assert.Equal(t, "", proxyFor("http://skip.example.com/a.bin"))
+	assert.Equal(t, "http://proxy:3128", proxyFor("http://example.com/a.bin"))
+}
+
+func TestProxyRuleValidate(t *testing.T) {
+	assert.NoError(t, ProxyRule{Host: "*.internal", Proxy: ProxyDirect}.Validate())
+	assert.Error(t, ProxyRule{Host: "", Proxy: ProxyDirect}.Validate())
+	assert.Error(t, ProxyRule{Host: "[", Proxy: ProxyDirect}.Validate())
+	assert.Error(t, ProxyRule{Host: "*.onion"}.Validate())
+}
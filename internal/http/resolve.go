@@ -0,0 +1,147 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Resolve sends connections to Host:Port to Addrs instead of the
+// addresses DNS returns, as curl's --resolve does. The request still
+// names Host, so TLS verification and virtual hosting are unaffected.
+type Resolve struct {
+	Host  string
+	Port  string   // "*" for any port
+	Addrs []string // tried in order
+}
+
+// ParseResolve parses a curl-style HOST:PORT:ADDR[,ADDR]... entry. IPv6
+// addresses may be written in brackets.
+func ParseResolve(s string) (Resolve, error) {
+	host, rest, ok := strings.Cut(s, ":")
+	port, addrs, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || host == "" || port == "" || addrs == "" {
+		return Resolve{}, fmt.Errorf("invalid --resolve %q, want HOST:PORT:ADDR[,ADDR]", s)
+	}
+	r := Resolve{Host: strings.ToLower(host), Port: port}
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(a, "["), "]")
+		if net.ParseIP(a) == nil {
+			return Resolve{}, fmt.Errorf("invalid --resolve %q: %q is not an IP address", s, a)
+		}
+		r.Addrs = append(r.Addrs, a)
+	}
+	return r, nil
+}
+
+// dialFunc is the signature of net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// pinningDialer dials through Resolve entries first. With pin set, the
+// first address a host is reached at is reused for every later
+// connection to it, so a DNS answer that rotates mid-download doesn't
+// move chunks to another CDN edge that may hold another copy of the file.
+type pinningDialer struct {
+	rules []Resolve
+	pin   bool
+	dial  dialFunc
+
+	mu     sync.Mutex
+	pinned map[string]string // host:port -> ip:port
+}
+
+// newPinningDialer returns dial itself when there is nothing to do.
+func newPinningDialer(rules []Resolve, pin bool, dial dialFunc) dialFunc {
+	if len(rules) == 0 && !pin {
+		return dial
+	}
+	p := &pinningDialer{rules: rules, pin: pin, dial: dial, pinned: make(map[string]string)}
+	return p.dialContext
+}
+
+func (p *pinningDialer) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return p.dial(ctx, network, addr)
+	}
+	for _, r := range p.rules {
+		if r.Host != strings.ToLower(host) || (r.Port != "*" && r.Port != port) {
+			continue
+		}
+		var lastErr error
+		for _, ip := range r.Addrs {
+			conn, err := p.dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+	if !p.pin {
+		return p.dial(ctx, network, addr)
+	}
+
+	p.mu.Lock()
+	target, ok := p.pinned[addr]
+	p.mu.Unlock()
+	if ok {
+		return p.dial(ctx, network, target)
+	}
+	conn, err := p.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		p.mu.Lock()
+		if _, ok := p.pinned[addr]; !ok {
+			p.pinned[addr] = tcp.String()
+		}
+		p.mu.Unlock()
+	}
+	return conn, nil
+}
+
+// localAddr returns the address to connect from: sourceIP, or an address
+// of the network interface iface (IPv4 if it has one). Connections then
+// only go to addresses of the same family.
+func localAddr(sourceIP, iface string) (*net.TCPAddr, error) {
+	if sourceIP != "" {
+		ip := net.ParseIP(sourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source IP %q", sourceIP)
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	if iface == "" {
+		return nil, nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", iface, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", iface, err)
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipnet.IP}, nil
+		}
+		if v6 == nil {
+			v6 = ipnet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", iface)
+	}
+	return &net.TCPAddr{IP: v6}, nil
+}
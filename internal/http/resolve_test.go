@@ -0,0 +1,109 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolve(t *testing.T) {
+	r, err := ParseResolve("CDN.example.com:443:192.0.2.1,[2001:db8::1]")
+	require.NoError(t, err)
+	assert.Equal(t, Resolve{Host: "cdn.example.com", Port: "443", Addrs: []string{"192.0.2.1", "2001:db8::1"}}, r)
+
+	r, err = ParseResolve("example.com:*:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.Port)
+
+	for _, s := range []string{"", "example.com", "example.com:443", "example.com:443:", ":443:192.0.2.1", "example.com:443:edge"} {
+		_, err := ParseResolve(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestResolveDialsPinnedAddress(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+
+	rule, err := ParseResolve("files.invalid:" + port + ":127.0.0.1")
+	require.NoError(t, err)
+	c, err := NewClient(Config{Resolve: []Resolve{rule}, SourceIP: "127.0.0.1"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.DownloadRange(context.Background(), "http://files.invalid:"+port+"/f", 2, 5, &buf))
+	assert.Equal(t, "2345", buf.String())
+	assert.Equal(t, "files.invalid:"+port, host)
+}
+
+func TestPinIP(t *testing.T) {
+	var dialed []string
+	answer := "192.0.2.1:80"
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		client, server := net.Pipe()
+		server.Close()
+		return &remoteConn{Conn: client, remote: answer}, nil
+	}
+
+	d := newPinningDialer(nil, true, dial)
+	_, err := d(context.Background(), "tcp", "cdn.example.com:80")
+	require.NoError(t, err)
+
+	// DNS now answers with another edge, but the first one is kept
+	answer = "192.0.2.2:80"
+	_, err = d(context.Background(), "tcp", "cdn.example.com:80")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cdn.example.com:80", "192.0.2.1:80"}, dialed)
+
+	// A failed dial pins nothing
+	failing := newPinningDialer(nil, true, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, errors.New("refused")
+	})
+	dialed = nil
+	_, err = failing(context.Background(), "tcp", "cdn.example.com:80")
+	assert.Error(t, err)
+	_, err = failing(context.Background(), "tcp", "cdn.example.com:80")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"cdn.example.com:80", "cdn.example.com:80"}, dialed)
+}
+
+type remoteConn struct {
+	net.Conn
+	remote string
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.remote)
+	return addr
+}
+
+func TestLocalAddr(t *testing.T) {
+	addr, err := localAddr("", "")
+	require.NoError(t, err)
+	assert.Nil(t, addr)
+
+	addr, err = localAddr("127.0.0.1", "ignored0")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", addr.IP.String())
+
+	_, err = localAddr("not-an-ip", "")
+	assert.Error(t, err)
+	_, err = localAddr("", "no-such-interface0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,298 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// Breaker defaults, and the longest a proxy whose probes keep failing
+// stays out of rotation.
+const (
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = 30 * time.Second
+	maxBreakerCooldown     = 10 * time.Minute
+)
+
+// Breaker decides when a proxy of a rotation (Config.ProxyURL listing
+// several) is taken out of it: after MaxFailures requests in a row failed
+// on the connection (a reset, a timeout, a body cut short, a refused
+// dial), for Cooldown. Then the next request goes through it as a probe:
+// if that succeeds it is back in rotation, if not it stays out twice as
+// long. Error statuses are the server's doing and don't count.
+type Breaker struct {
+	MaxFailures int           // Optional: consecutive failures that take a proxy out (0 = 3)
+	Cooldown    time.Duration // Optional: how long before it is probed again (0 = 30s)
+}
+
+// ProxyHealth is how the requests through one proxy of a rotation went.
+type ProxyHealth struct {
+	Proxy      string // redacted
+	Requests   int64
+	Failures   int64
+	Resets     int64
+	Timeouts   int64
+	ShortReads int64   // bodies that ended before their Content-Length
+	Score      float64 // recent success rate, 0-1, weighted towards the latest requests
+	Trips      int     // times it was taken out of rotation
+	Out        bool    // out of rotation right now
+}
+
+// splitProxies returns the proxies a ProxyURL lists, separated by commas.
+func splitProxies(s string) ([]*url.URL, error) {
+	var proxies []*url.URL
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", redact.Error(err))
+		}
+		proxies = append(proxies, u)
+	}
+	return proxies, nil
+}
+
+// rotation spreads requests over several proxies, each with a transport
+// and connections of its own, keeping the ones that fail out of it for a
+// while.
+type rotation struct {
+	proxies []*proxyEndpoint
+	routed  func(*http.Request) bool // whether the request goes through the rotation's proxies at all
+	breaker Breaker
+	now     func() time.Time
+
+	mu   sync.Mutex
+	next int // where the round robin starts looking
+}
+
+// proxyEndpoint is a proxy of a rotation. Apart from transport, its fields
+// are guarded by rotation.mu.
+type proxyEndpoint struct {
+	transport *http.Transport
+	health    ProxyHealth
+	inflight  int
+	failures  int           // in a row
+	cooldown  time.Duration // how long it stays out once taken out
+	outUntil  time.Time     // zero while in rotation
+	probing   bool          // a probe request is on its way
+}
+
+// newRotation returns a rotation over proxies, cloning base for each. A
+// request that ProxyRules or NO_PROXY route elsewhere goes through the
+// first clone as it would without a rotation, and isn't scored.
+func newRotation(base *http.Transport, rules []ProxyRule, proxies []*url.URL, breaker Breaker) *rotation {
+	if breaker.MaxFailures <= 0 {
+		breaker.MaxFailures = defaultBreakerFailures
+	}
+	if breaker.Cooldown <= 0 {
+		breaker.Cooldown = defaultBreakerCooldown
+	}
+	r := &rotation{breaker: breaker, now: time.Now}
+	for _, p := range proxies {
+		t := base.Clone()
+		t.Proxy = proxyFunc(rules, p)
+		r.proxies = append(r.proxies, &proxyEndpoint{
+			transport: t,
+			health:    ProxyHealth{Proxy: redact.URL(p.String()), Score: 1},
+		})
+	}
+	sentinel := proxies[0]
+	route := proxyFunc(rules, sentinel)
+	r.routed = func(req *http.Request) bool {
+		u, err := route(req)
+		return err == nil && u == sentinel
+	}
+	return r
+}
+
+func (r *rotation) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !r.routed(req) {
+		return r.proxies[0].transport.RoundTrip(req)
+	}
+	p, probe := r.pick()
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		r.done(p, probe, req, err)
+		return nil, err
+	}
+	resp.Body = &scoredBody{ReadCloser: resp.Body, finish: func(err error) { r.done(p, probe, req, err) }}
+	return resp, nil
+}
+
+// pick returns the proxy for the next request: of those in rotation, the
+// one with the fewest requests in flight, taking turns between equals.
+// One whose cooldown is over takes a probe first. With every proxy out,
+// the one due back soonest is probed early rather than stalling the
+// download.
+func (r *rotation) pick() (*proxyEndpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+
+	var best *proxyEndpoint
+	for i := range r.proxies {
+		p := r.proxies[(r.next+i)%len(r.proxies)]
+		if p.outUntil.IsZero() {
+			if best == nil || p.inflight < best.inflight {
+				best = p
+			}
+			continue
+		}
+		if !p.probing && !now.Before(p.outUntil) {
+			return r.startProbe(p), true
+		}
+	}
+	r.next++
+	if best != nil {
+		best.inflight++
+		return best, false
+	}
+
+	for _, p := range r.proxies {
+		if !p.probing && (best == nil || p.outUntil.Before(best.outUntil)) {
+			best = p
+		}
+	}
+	if best == nil {
+		// Every proxy is being probed already
+		best = r.proxies[0]
+		best.inflight++
+		return best, false
+	}
+	return r.startProbe(best), true
+}
+
+func (r *rotation) startProbe(p *proxyEndpoint) *proxyEndpoint {
+	p.probing = true
+	p.inflight++
+	slog.Debug(fmt.Sprintf("Probing proxy %s", p.health.Proxy), "proxy", p.health.Proxy)
+	return p
+}
+
+// done records how a request through p went: err is nil for a success.
+// A request given up by its caller says nothing about the proxy.
+func (r *rotation) done(p *proxyEndpoint, probe bool, req *http.Request, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p.inflight--
+	if probe {
+		p.probing = false
+	}
+	if err != nil && req.Context().Err() != nil {
+		return
+	}
+
+	h := &p.health
+	h.Requests++
+	if err == nil {
+		h.Score = h.Score*0.9 + 0.1
+		p.failures = 0
+		if probe {
+			p.outUntil = time.Time{}
+			p.cooldown = 0
+			h.Out = false
+			slog.Info(fmt.Sprintf("Proxy %s answered again, back in rotation", h.Proxy), "proxy", h.Proxy)
+		}
+		return
+	}
+
+	h.Score *= 0.9
+	h.Failures++
+	kind := "failed request"
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		h.ShortReads++
+		kind = "short read"
+	case isTimeout(err):
+		h.Timeouts++
+		kind = "timeout"
+	case isReset(err):
+		h.Resets++
+		kind = "connection reset"
+	}
+	p.failures++
+
+	switch {
+	case probe:
+		p.cooldown = min(2*p.cooldown, maxBreakerCooldown)
+	case p.outUntil.IsZero() && p.failures >= r.breaker.MaxFailures:
+		p.cooldown = r.breaker.Cooldown
+		h.Trips++
+	default:
+		return
+	}
+	p.outUntil = r.now().Add(p.cooldown)
+	h.Out = true
+	// Its connections are suspect, and a new one may take a new route
+	p.transport.CloseIdleConnections()
+	slog.Warn(fmt.Sprintf("Proxy %s failing (%d in a row, the last a %s: %v), out of rotation for %s",
+		h.Proxy, p.failures, kind, redact.Error(err), p.cooldown),
+		"proxy", h.Proxy, "failures", p.failures, "cooldown_seconds", p.cooldown.Seconds())
+}
+
+// health returns every proxy's record, in the order they were given.
+func (r *rotation) health() []ProxyHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]ProxyHealth, len(r.proxies))
+	for i, p := range r.proxies {
+		out[i] = p.health
+	}
+	return out
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach every
+// proxy's transport.
+func (r *rotation) CloseIdleConnections() {
+	for _, p := range r.proxies {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		strings.Contains(err.Error(), "connection reset")
+}
+
+// scoredBody reports how reading a response body went: its end, or the
+// first read error. A body closed before its end was read in full as far
+// as the caller wanted it, which counts as a success.
+type scoredBody struct {
+	io.ReadCloser
+	finish func(error)
+	once   sync.Once
+}
+
+func (b *scoredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.finish(nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.finish(err) })
+	}
+	return n, err
+}
+
+func (b *scoredBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.finish(nil) })
+	return err
+}
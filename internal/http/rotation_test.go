@@ -0,0 +1,97 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyRotation(t *testing.T) {
+	data := strings.Repeat("x", 1000)
+	// Plain HTTP proxies get the absolute URL and answer it themselves
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer good.Close()
+	var failing atomic.Bool
+	failing.Store(true)
+	var badRequests atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		badRequests.Add(1)
+		if failing.Load() {
+			// Headers, then the connection drops halfway through the body
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(data[:10]))
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer bad.Close()
+
+	c, err := NewClient(Config{ProxyURL: bad.URL + ", " + good.URL, Breaker: Breaker{MaxFailures: 2, Cooldown: time.Minute}})
+	require.NoError(t, err)
+	now := time.Now()
+	c.rotation.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	failures := 0
+	for range 10 {
+		var buf bytes.Buffer
+		if err := c.DownloadRange(ctx, "http://origin.invalid/f", 0, 99, &buf); err != nil {
+			failures++
+		}
+	}
+	assert.Equal(t, 2, failures)
+	assert.EqualValues(t, 2, badRequests.Load())
+
+	health := c.ProxyHealth()
+	require.Len(t, health, 2)
+	assert.Equal(t, bad.URL, health[0].Proxy)
+	assert.True(t, health[0].Out)
+	assert.Equal(t, 1, health[0].Trips)
+	assert.EqualValues(t, 2, health[0].ShortReads)
+	assert.False(t, health[1].Out)
+	assert.EqualValues(t, 8, health[1].Requests)
+
+	// A failed probe keeps it out twice as long
+	now = now.Add(time.Minute)
+	var buf bytes.Buffer
+	assert.Error(t, c.DownloadRange(ctx, "http://origin.invalid/f", 0, 99, &buf))
+	assert.EqualValues(t, 3, badRequests.Load())
+	now = now.Add(time.Minute)
+	require.NoError(t, c.DownloadRange(ctx, "http://origin.invalid/f", 0, 99, &buf))
+	assert.EqualValues(t, 3, badRequests.Load())
+
+	// Probed once it recovered, it is back in rotation
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	require.NoError(t, c.DownloadRange(ctx, "http://origin.invalid/f", 0, 99, &buf))
+	assert.EqualValues(t, 4, badRequests.Load())
+	assert.False(t, c.ProxyHealth()[0].Out)
+	for range 4 {
+		require.NoError(t, c.DownloadRange(ctx, "http://origin.invalid/f", 0, 99, &buf))
+	}
+	assert.Greater(t, badRequests.Load(), int32(4))
+}
+
+func TestSplitProxies(t *testing.T) {
+	proxies, err := splitProxies("socks5h://a@127.0.0.1:9050, socks5h://b@127.0.0.1:9050,")
+	require.NoError(t, err)
+	require.Len(t, proxies, 2)
+	assert.Equal(t, "b", proxies[1].User.Username())
+
+	proxies, err = splitProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, proxies)
+}
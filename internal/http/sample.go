@@ -0,0 +1,279 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// Sample faults: how a sample range request went wrong.
+const (
+	FaultReset   = "reset"   // the connection was reset or closed early
+	FaultTimeout = "timeout" // connecting or the response headers timed out
+	FaultShort   = "short"   // the body ended before the range did
+	FaultStatus  = "status"  // an error status
+	FaultRequest = "request" // any other failure
+)
+
+// RangeSample describes how the server answered one throwaway range
+// request, whose body is read and dropped.
+type RangeSample struct {
+	Status   int
+	Latency  time.Duration // time until response headers
+	Bytes    int64         // body bytes read
+	Elapsed  time.Duration // time until the body was read
+	Ignored  bool          // 200 with the whole file instead of the range
+	Mismatch bool          // 206 whose Content-Range isn't the range asked for
+	Fault    string        // one of the Fault constants, "" if none
+	Error    string
+	Complete bool // the whole range was read
+}
+
+// SampleRange requests bytes start to end of url, writing the body to w as
+// it arrives. Like Probe, failures are recorded in the result. A request
+// cut short by ctx has no fault: the caller ended it.
+func (c *Client) SampleRange(ctx context.Context, url string, start, end int64, w io.Writer) RangeSample {
+	var s RangeSample
+	fail := func(fault string, err error) RangeSample {
+		if ctx.Err() == nil {
+			s.Fault = fault
+			s.Error = redact.Error(err).Error()
+		}
+		return s
+	}
+
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		return fail(FaultRequest, fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	begin := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fail(sampleFault(err), fmt.Errorf("request failed: %w", err))
+	}
+	defer resp.Body.Close()
+	s.Latency = time.Since(begin)
+	s.Status = resp.StatusCode
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		first, last, ok := contentRange(resp.Header.Get("Content-Range"))
+		s.Mismatch = !ok || first != start || last != end
+	case http.StatusOK:
+		s.Ignored = true
+	default:
+		return fail(FaultStatus, &StatusError{Code: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After")})
+	}
+
+	// A server ignoring Range sends the whole file; stop after the range
+	want := end - start + 1
+	s.Bytes, err = io.Copy(w, io.LimitReader(resp.Body, want))
+	s.Elapsed = time.Since(begin)
+	switch {
+	case err != nil:
+		return fail(sampleFault(err), fmt.Errorf("read failed: %w", err))
+	case s.Bytes < want:
+		return fail(FaultShort, fmt.Errorf("incomplete range: expected %d bytes, got %d", want, s.Bytes))
+	}
+	s.Complete = true
+	return s
+}
+
+// sampleFault sorts a transport error into a fault.
+func sampleFault(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED):
+		return FaultReset
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return FaultShort
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return FaultTimeout
+	}
+	return FaultRequest
+}
+
+// contentRange parses the first and last byte of a "bytes a-b/total"
+// header.
+func contentRange(header string) (first, last int64, ok bool) {
+	var total string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &first, &last, &total); err != nil {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// SampleSummary aggregates the range samples of a sampling run.
+type SampleSummary struct {
+	Jobs       int            `json:"jobs"`
+	Requests   int            `json:"requests"`
+	Completed  int            `json:"completed"` // requests that read their whole range
+	Bytes      int64          `json:"bytes"`
+	Duration   time.Duration  `json:"duration_ns"`
+	Throughput float64        `json:"throughput"`     // bytes/s over all connections
+	PerConn    float64        `json:"per_connection"` // median bytes/s of one request's body
+	LatencyP50 time.Duration  `json:"latency_p50_ns"`
+	LatencyP95 time.Duration  `json:"latency_p95_ns"`
+	Faults     map[string]int `json:"faults,omitempty"`
+	Statuses   map[int]int    `json:"statuses,omitempty"` // error statuses by code
+	Ignored    int            `json:"range_ignored,omitempty"`
+	Mismatch   int            `json:"range_mismatch,omitempty"`
+}
+
+// SummarizeSamples aggregates the samples of a run that used jobs
+// connections and moved bytes in total over d, counting the bytes of
+// requests still in flight when the run ended.
+func SummarizeSamples(samples []RangeSample, jobs int, bytes int64, d time.Duration) SampleSummary {
+	s := SampleSummary{Jobs: jobs, Requests: len(samples), Bytes: bytes, Duration: d}
+	if d > 0 {
+		s.Throughput = float64(bytes) / d.Seconds()
+	}
+
+	var latencies []time.Duration
+	var speeds []float64
+	for _, r := range samples {
+		if r.Latency > 0 {
+			latencies = append(latencies, r.Latency)
+		}
+		if body := r.Elapsed - r.Latency; r.Bytes > 0 && body > 0 {
+			speeds = append(speeds, float64(r.Bytes)/body.Seconds())
+		}
+		if r.Ignored {
+			s.Ignored++
+		}
+		if r.Mismatch {
+			s.Mismatch++
+		}
+		if r.Complete {
+			s.Completed++
+		}
+		if r.Fault == "" {
+			continue
+		}
+		if s.Faults == nil {
+			s.Faults = make(map[string]int)
+		}
+		s.Faults[r.Fault]++
+		if r.Fault == FaultStatus {
+			if s.Statuses == nil {
+				s.Statuses = make(map[int]int)
+			}
+			s.Statuses[r.Status]++
+		}
+	}
+
+	slices.Sort(latencies)
+	slices.Sort(speeds)
+	if n := len(latencies); n > 0 {
+		s.LatencyP50 = latencies[n/2]
+		s.LatencyP95 = latencies[min(n-1, n*95/100)]
+	}
+	if n := len(speeds); n > 0 {
+		s.PerConn = speeds[n/2]
+	}
+	return s
+}
+
+// FaultRate is the share of requests that failed.
+func (s SampleSummary) FaultRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	var n int
+	for _, c := range s.Faults {
+		n += c
+	}
+	return float64(n) / float64(s.Requests)
+}
+
+// SampleAdvice is a suggested download setup.
+type SampleAdvice struct {
+	Jobs      int      `json:"jobs"`
+	ChunkSize int64    `json:"chunk_size"`
+	Notes     []string `json:"notes,omitempty"`
+}
+
+// Recommend suggests --jobs and -c for downloading a size byte file from
+// the sampled server, with notes on what the samples showed.
+//
+// When the connections together moved close to jobs times what one does,
+// the link wasn't the limit and more connections may help; otherwise the
+// link is shared and about Throughput/PerConn connections fill it. Failing
+// requests halve the jobs. Chunks are large enough that the request
+// latency costs under 5% of each one, but keep every connection busy and
+// are smaller on a lossy connection, where a failed chunk costs a retry.
+func (s SampleSummary) Recommend(size int64) SampleAdvice {
+	a := SampleAdvice{Jobs: max(s.Jobs, 1)}
+	if s.Ignored > 0 {
+		a.Jobs = 1
+		a.ChunkSize = size
+		a.Notes = append(a.Notes, "the server answers Range requests with the whole file, so the download can't be split")
+		return a
+	}
+	if s.Mismatch > 0 {
+		a.Notes = append(a.Notes, fmt.Sprintf("%d responses covered other bytes than the range asked for", s.Mismatch))
+	}
+
+	if s.PerConn > 0 && s.Throughput > 0 {
+		if scaling := s.Throughput / (s.PerConn * float64(a.Jobs)); scaling >= 0.8 {
+			a.Jobs *= 2
+			a.Notes = append(a.Notes, fmt.Sprintf("throughput grew with every connection; sample again with --jobs %d to see if more help", a.Jobs))
+		} else {
+			a.Jobs = max(1, int(math.Ceil(s.Throughput/s.PerConn)))
+		}
+	}
+
+	lossy := s.FaultRate() > 0.05
+	throttled := s.Statuses[http.StatusTooManyRequests] + s.Statuses[http.StatusServiceUnavailable]
+	if lossy || throttled > 0 {
+		a.Jobs = max(1, min(a.Jobs, s.Jobs)/2)
+	}
+	if throttled > 0 {
+		a.Notes = append(a.Notes, fmt.Sprintf("the server refused %d requests with 429/503; use fewer connections or --pace", throttled))
+	}
+	if n := s.Faults[FaultReset] + s.Faults[FaultShort]; n > 0 {
+		a.Notes = append(a.Notes, fmt.Sprintf("%d connections were reset or cut short; keep -r retries high", n))
+	}
+	if n := s.Faults[FaultTimeout]; n > 0 {
+		a.Notes = append(a.Notes, fmt.Sprintf("%d requests timed out", n))
+	}
+
+	chunk := int64(1e6)
+	if s.PerConn > 0 {
+		chunk = max(chunk, int64(s.PerConn*s.LatencyP50.Seconds()*20))
+		if lossy {
+			chunk = min(chunk, int64(s.PerConn*30))
+		}
+	}
+	if size > 0 {
+		chunk = min(chunk, max(1, size/int64(a.Jobs)))
+	}
+	a.ChunkSize = RoundChunkSize(chunk)
+	if size > 0 {
+		a.ChunkSize = min(a.ChunkSize, size)
+	}
+	return a
+}
+
+// RoundChunkSize rounds n up to 1, 2 or 5 times a power of ten megabytes,
+// at least 1 MB.
+func RoundChunkSize(n int64) int64 {
+	for step := int64(1e6); ; step *= 10 {
+		for _, m := range []int64{1, 2, 5} {
+			if n <= m*step {
+				return m * step
+			}
+		}
+	}
+}
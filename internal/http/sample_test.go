@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleRange(t *testing.T) {
+	data := strings.Repeat("0123456789", 100)
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer ranged.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer plain.Close()
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 10-19/1000")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("01234"))
+	}))
+	defer short.Close()
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	s := c.SampleRange(ctx, ranged.URL, 10, 19, &buf)
+	assert.Equal(t, http.StatusPartialContent, s.Status)
+	assert.True(t, s.Complete)
+	assert.Empty(t, s.Fault)
+	assert.False(t, s.Mismatch)
+	assert.Equal(t, "0123456789", buf.String())
+
+	// The whole file comes back: only the range's worth is read
+	buf.Reset()
+	s = c.SampleRange(ctx, plain.URL, 10, 19, &buf)
+	assert.True(t, s.Ignored)
+	assert.Equal(t, int64(10), s.Bytes)
+
+	s = c.SampleRange(ctx, short.URL, 10, 19, &buf)
+	assert.Equal(t, FaultShort, s.Fault)
+	assert.False(t, s.Complete)
+
+	s = c.SampleRange(ctx, busy.URL, 10, 19, &buf)
+	assert.Equal(t, FaultStatus, s.Fault)
+	assert.Equal(t, http.StatusServiceUnavailable, s.Status)
+
+	// Cut short by the caller: no fault
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	s = c.SampleRange(cancelled, ranged.URL, 10, 19, &buf)
+	assert.Empty(t, s.Fault)
+	assert.False(t, s.Complete)
+}
+
+func TestSummarizeSamples(t *testing.T) {
+	ms := time.Millisecond
+	samples := []RangeSample{
+		{Status: 206, Latency: 10 * ms, Bytes: 1e6, Elapsed: 110 * ms, Complete: true},
+		{Status: 206, Latency: 30 * ms, Bytes: 1e6, Elapsed: 230 * ms, Complete: true},
+		{Status: 206, Latency: 20 * ms, Bytes: 5e5, Elapsed: 120 * ms, Fault: FaultReset},
+		{Status: 503, Latency: 5 * ms, Fault: FaultStatus},
+	}
+	s := SummarizeSamples(samples, 2, 3e6, time.Second)
+	assert.Equal(t, 4, s.Requests)
+	assert.Equal(t, 2, s.Completed)
+	assert.Equal(t, 3e6, s.Throughput)
+	assert.InDelta(t, 5e6, s.PerConn, 1)
+	assert.Equal(t, 20*ms, s.LatencyP50)
+	assert.Equal(t, 30*ms, s.LatencyP95)
+	assert.Equal(t, map[string]int{FaultReset: 1, FaultStatus: 1}, s.Faults)
+	assert.Equal(t, map[int]int{503: 1}, s.Statuses)
+	assert.Equal(t, 0.5, s.FaultRate())
+}
+
+func TestRecommend(t *testing.T) {
+	// Four connections moving four times what one does: try more
+	s := SampleSummary{Jobs: 4, Requests: 40, Throughput: 4e6, PerConn: 1e6, LatencyP50: 100 * time.Millisecond}
+	a := s.Recommend(10e9)
+	assert.Equal(t, 8, a.Jobs)
+	assert.Equal(t, int64(2e6), a.ChunkSize)
+	assert.Len(t, a.Notes, 1)
+
+	// The link is full with three
+	s = SampleSummary{Jobs: 8, Requests: 40, Throughput: 30e6, PerConn: 10e6, LatencyP50: 200 * time.Millisecond}
+	a = s.Recommend(10e9)
+	assert.Equal(t, 3, a.Jobs)
+	assert.Equal(t, int64(50e6), a.ChunkSize)
+	assert.Empty(t, a.Notes)
+
+	// Throttled: halve the jobs
+	s.Faults = map[string]int{FaultStatus: 4}
+	s.Statuses = map[int]int{http.StatusTooManyRequests: 4}
+	a = s.Recommend(10e9)
+	assert.Equal(t, 1, a.Jobs)
+	assert.Contains(t, a.Notes[0], "429")
+
+	// Small files aren't cut into more chunks than jobs
+	s = SampleSummary{Jobs: 2, Requests: 10, Throughput: 100e6, PerConn: 100e6, LatencyP50: time.Second}
+	a = s.Recommend(3e6)
+	assert.Equal(t, int64(3e6), a.ChunkSize)
+
+	s = SampleSummary{Jobs: 4, Requests: 4, Ignored: 4}
+	a = s.Recommend(5e9)
+	assert.Equal(t, 1, a.Jobs)
+	assert.Equal(t, int64(5e9), a.ChunkSize)
+}
+
+func TestRoundChunkSize(t *testing.T) {
+	assert.Equal(t, int64(1e6), RoundChunkSize(1))
+	assert.Equal(t, int64(2e6), RoundChunkSize(1e6+1))
+	assert.Equal(t, int64(5e6), RoundChunkSize(2.5e6))
+	assert.Equal(t, int64(100e6), RoundChunkSize(51e6))
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// Tail requests everything from byte start to the end of the file and
+// writes it to w as it arrives. It returns the number of bytes written and
+// the file's size as reported by the server (0 if unknown). A file no
+// longer than start is not an error: nothing is written and the size says
+// whether it is unchanged or shrank.
+func (c *Client) Tail(ctx context.Context, url string, start int64, w io.Writer) (int64, int64, error) {
+	req, err := c.newRequest(ctx, "GET", url)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("request failed: %w", redact.Error(err))
+	}
+	defer resp.Body.Close()
+
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		c.traffic.partial.Add(1)
+		size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		c.traffic.full.Add(1)
+		if start > 0 {
+			return 0, 0, ErrRangeIgnored
+		}
+		if resp.ContentLength > 0 {
+			size = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing past start yet; "bytes */size" tells how long the file is
+		c.traffic.other.Add(1)
+		return 0, contentRangeTotal(resp.Header.Get("Content-Range")), nil
+	default:
+		c.traffic.other.Add(1)
+		return 0, 0, &StatusError{Code: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After")}
+	}
+
+	// A live stream may keep the response open: write what arrives
+	// without waiting for the body to end
+	buf := make([]byte, c.config.ReadBufferSize)
+	var written int64
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			c.traffic.bytes.Add(int64(n))
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return written, size, fmt.Errorf("write failed: %w", writeErr)
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, size, nil
+		}
+		if err != nil {
+			return written, size, fmt.Errorf("read failed: %w", redact.Error(err))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTail(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	defer ranged.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(data))
+	}))
+	defer plain.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	n, size, err := c.Tail(ctx, ranged.URL, 95, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, int64(100), size)
+	assert.Equal(t, "56789", buf.String())
+
+	// Caught up: nothing new, but the size is known
+	buf.Reset()
+	n, size, err = c.Tail(ctx, ranged.URL, 100, &buf)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, int64(100), size)
+
+	// Past the end: the file shrank
+	_, size, err = c.Tail(ctx, ranged.URL, 150, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+
+	// The whole file is fine from the start, but not from later on
+	buf.Reset()
+	n, _, err = c.Tail(ctx, plain.URL, 0, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), n)
+	_, _, err = c.Tail(ctx, plain.URL, 10, &buf)
+	assert.ErrorIs(t, err, ErrRangeIgnored)
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeServerCA saves the test server's certificate as a PEM CA bundle.
+func writeServerCA(t *testing.T, srv *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// writeClientCert generates a self-signed client certificate and key.
+func writeClientCert(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rapel-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	data := strings.Repeat("x", 100)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(data))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert, MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := writeServerCA(t, srv)
+	certFile, keyFile := writeClientCert(t)
+	ctx := context.Background()
+
+	fetch := func(cfg TLSConfig) error {
+		c, err := NewClient(Config{TLS: cfg})
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		return c.DownloadRange(ctx, srv.URL, 0, 9, &buf)
+	}
+
+	assert.ErrorContains(t, fetch(TLSConfig{}), "certificate")
+	assert.ErrorContains(t, fetch(TLSConfig{CACertFile: caFile}), "status code: 403")
+	assert.NoError(t, fetch(TLSConfig{CACertFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	assert.NoError(t, fetch(TLSConfig{Insecure: true, CertFile: certFile, KeyFile: keyFile}))
+
+	// The server stops at TLS 1.2
+	assert.Error(t, fetch(TLSConfig{Insecure: true, MinVersion: "1.3"}))
+
+	_, err := NewClient(Config{TLS: TLSConfig{CertFile: certFile}})
+	assert.ErrorContains(t, err, "both a certificate and a key")
+	_, err = NewClient(Config{TLS: TLSConfig{CACertFile: keyFile}})
+	assert.ErrorContains(t, err, "no PEM certificates")
+	_, err = NewClient(Config{TLS: TLSConfig{MinVersion: "1.4"}})
+	assert.ErrorContains(t, err, "unknown TLS version")
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultKeepAlive is the TCP keep-alive probe interval when none is set.
+const defaultKeepAlive = 30 * time.Second
+
+// defaultReadBuffer is the read buffer size when none is set.
+const defaultReadBuffer = 32 * 1024
+
+// connTracker counts open connections per host:port.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[string]int)}
+}
+
+// dialer wraps dial so every connection it opens is counted until closed.
+func (t *connTracker) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		t.mu.Lock()
+		t.conns[addr]++
+		t.mu.Unlock()
+		return &trackedConn{Conn: conn, tracker: t, addr: addr}, nil
+	}
+}
+
+// snapshot returns the open connection count of every host with any.
+func (t *connTracker) snapshot() []HostConnections {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hosts := make([]HostConnections, 0, len(t.conns))
+	for addr, n := range t.conns {
+		hosts = append(hosts, HostConnections{Host: addr, Open: n})
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+// trackedConn decrements its host's count once closed.
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	addr    string
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.mu.Lock()
+		if c.tracker.conns[c.addr]--; c.tracker.conns[c.addr] <= 0 {
+			delete(c.tracker.conns, c.addr)
+		}
+		c.tracker.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// HostConnections is the number of open connections to one host
+// (or proxy) address.
+type HostConnections struct {
+	Host string
+	Open int
+}
+
+// Connections returns the open connections per host, busy or idle.
+func (c *Client) Connections() []HostConnections {
+	return c.conns.snapshot()
+}
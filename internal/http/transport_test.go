@@ -0,0 +1,38 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionsAreReusedAndCounted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{ReadBufferSize: 4})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		require.NoError(t, c.DownloadRange(context.Background(), srv.URL, 2, 7, &buf))
+		assert.Equal(t, "234567", buf.String())
+	}
+
+	conns := c.Connections()
+	require.Len(t, conns, 1)
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), conns[0].Host)
+	assert.Equal(t, 1, conns[0].Open)
+
+	c.client.CloseIdleConnections()
+	assert.Empty(t, c.Connections())
+}
@@ -0,0 +1,178 @@
+// Package hub turns identifiers of files on data hubs, such as
+// hf://org/model/file, into the URLs to download them from in ranges,
+// along with the headers the hub wants for them.
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Resolved is where to download an identified file from.
+type Resolved struct {
+	URL    string
+	Header http.Header // nil if the hub wants none; holds tokens, so never persisted
+}
+
+// Resolver resolves the identifiers of one scheme.
+type Resolver interface {
+	// Scheme is the scheme of the identifiers resolved, "hf" for hf://.
+	Scheme() string
+	// Resolve resolves an identifier given without its scheme://.
+	Resolve(ref string) (Resolved, error)
+}
+
+// Builtin returns the built-in resolvers, taking their settings from the
+// environment: HF_TOKEN and HF_ENDPOINT for hf://, ZENODO_TOKEN for
+// zenodo://. ia:// needs none.
+func Builtin() []Resolver {
+	return []Resolver{
+		HuggingFace{Endpoint: os.Getenv("HF_ENDPOINT"), Token: os.Getenv("HF_TOKEN")},
+		Zenodo{Token: os.Getenv("ZENODO_TOKEN")},
+		InternetArchive{},
+	}
+}
+
+// Resolve resolves rawURL with the resolver for its scheme among
+// resolvers. ok is false if none is for it, as for an http:// URL, which
+// is then downloaded as it is.
+func Resolve(rawURL string, resolvers []Resolver) (r Resolved, ok bool, err error) {
+	scheme, ref, found := strings.Cut(rawURL, "://")
+	if !found {
+		return Resolved{}, false, nil
+	}
+	for _, resolver := range resolvers {
+		if strings.EqualFold(resolver.Scheme(), scheme) {
+			r, err := resolver.Resolve(ref)
+			if err != nil {
+				return Resolved{}, true, fmt.Errorf("invalid %s:// identifier %q: %w", resolver.Scheme(), rawURL, err)
+			}
+			return r, true, nil
+		}
+	}
+	return Resolved{}, false, nil
+}
+
+// HuggingFace resolves hf://org/repo/path to the file at path in a model
+// repository, hf://datasets/org/repo/path and hf://spaces/org/repo/path to
+// one in a dataset or a Space. The file is taken from the main branch
+// unless the repository is followed by @revision: a branch, a tag or a
+// commit, with any slash in it written %2F.
+type HuggingFace struct {
+	Endpoint string // "" = https://huggingface.co
+	Token    string // Optional: for gated and private repositories
+}
+
+func (HuggingFace) Scheme() string { return "hf" }
+
+func (h HuggingFace) Resolve(ref string) (Resolved, error) {
+	segments, err := splitPath(ref)
+	if err != nil {
+		return Resolved{}, err
+	}
+	kind := ""
+	if segments[0] == "datasets" || segments[0] == "spaces" {
+		kind, segments = segments[0]+"/", segments[1:]
+	}
+	if len(segments) < 3 {
+		return Resolved{}, fmt.Errorf("want hf://[datasets/|spaces/]org/repo[@revision]/path")
+	}
+
+	repo, revision, _ := strings.Cut(segments[1], "@")
+	if revision == "" {
+		revision = "main"
+	}
+	endpoint := h.Endpoint
+	if endpoint == "" {
+		endpoint = "https://huggingface.co"
+	}
+	r := Resolved{URL: fmt.Sprintf("%s/%s%s/%s/resolve/%s/%s", strings.TrimSuffix(endpoint, "/"), kind,
+		url.PathEscape(segments[0]), url.PathEscape(repo), url.PathEscape(revision), joinPath(segments[2:]))}
+	if h.Token != "" {
+		r.Header = http.Header{"Authorization": {"Bearer " + h.Token}}
+	}
+	return r, nil
+}
+
+// Zenodo resolves zenodo://record/file to the file of a Zenodo record.
+type Zenodo struct {
+	Endpoint string // "" = https://zenodo.org
+	Token    string // Optional: for restricted records
+}
+
+func (Zenodo) Scheme() string { return "zenodo" }
+
+func (z Zenodo) Resolve(ref string) (Resolved, error) {
+	segments, err := splitPath(ref)
+	if err != nil {
+		return Resolved{}, err
+	}
+	if len(segments) < 2 {
+		return Resolved{}, fmt.Errorf("want zenodo://record/file")
+	}
+	endpoint := z.Endpoint
+	if endpoint == "" {
+		endpoint = "https://zenodo.org"
+	}
+	r := Resolved{URL: fmt.Sprintf("%s/records/%s/files/%s?download=1", strings.TrimSuffix(endpoint, "/"),
+		url.PathEscape(segments[0]), joinPath(segments[1:]))}
+	if z.Token != "" {
+		r.Header = http.Header{"Authorization": {"Bearer " + z.Token}}
+	}
+	return r, nil
+}
+
+// InternetArchive resolves ia://item/path to the file at path in an
+// archive.org item.
+type InternetArchive struct {
+	Endpoint string // "" = https://archive.org
+}
+
+func (InternetArchive) Scheme() string { return "ia" }
+
+func (a InternetArchive) Resolve(ref string) (Resolved, error) {
+	segments, err := splitPath(ref)
+	if err != nil {
+		return Resolved{}, err
+	}
+	if len(segments) < 2 {
+		return Resolved{}, fmt.Errorf("want ia://item/path")
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://archive.org"
+	}
+	return Resolved{URL: fmt.Sprintf("%s/download/%s", strings.TrimSuffix(endpoint, "/"), joinPath(segments))}, nil
+}
+
+// splitPath splits ref at its slashes into percent-decoded segments,
+// refusing empty ones and a query or fragment, which no hub takes.
+func splitPath(ref string) ([]string, error) {
+	if strings.ContainsAny(ref, "?#") {
+		return nil, fmt.Errorf("unexpected query or fragment")
+	}
+	segments := strings.Split(strings.TrimSuffix(ref, "/"), "/")
+	for i, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, err
+		}
+		if unescaped == "" || unescaped == "." || unescaped == ".." {
+			return nil, fmt.Errorf("invalid path segment %q", s)
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+// joinPath escapes segments and joins them into a URL path.
+func joinPath(segments []string) string {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	return strings.Join(escaped, "/")
+}
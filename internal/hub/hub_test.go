@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	resolvers := []Resolver{
+		HuggingFace{Token: "hf_secret"},
+		Zenodo{},
+		InternetArchive{},
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hf://org/model/model.safetensors", "https://huggingface.co/org/model/resolve/main/model.safetensors"},
+		{"hf://org/model@v1.0/onnx/model.onnx", "https://huggingface.co/org/model/resolve/v1.0/onnx/model.onnx"},
+		{"hf://org/model@refs%2Fpr%2F3/f.bin", "https://huggingface.co/org/model/resolve/refs%2Fpr%2F3/f.bin"},
+		{"hf://datasets/org/data/train/part 1.parquet", "https://huggingface.co/datasets/org/data/resolve/main/train/part%201.parquet"},
+		{"hf://spaces/org/demo/app.py", "https://huggingface.co/spaces/org/demo/resolve/main/app.py"},
+		{"zenodo://123456/data.tar.gz", "https://zenodo.org/records/123456/files/data.tar.gz?download=1"},
+		{"ZENODO://123456/data.tar.gz", "https://zenodo.org/records/123456/files/data.tar.gz?download=1"},
+		{"ia://some_item/dir/file.iso", "https://archive.org/download/some_item/dir/file.iso"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, ok, err := Resolve(tt.in, resolvers)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, r.URL)
+		})
+	}
+
+	// Only the hub that was given a token sends one
+	r, _, err := Resolve("hf://org/model/f.bin", resolvers)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"Authorization": {"Bearer hf_secret"}}, r.Header)
+	r, _, err = Resolve("ia://item/f.bin", resolvers)
+	require.NoError(t, err)
+	assert.Nil(t, r.Header)
+
+	r, _, err = Resolve("hf://org/model/f.bin", []Resolver{HuggingFace{Endpoint: "https://hf-mirror.example/"}})
+	require.NoError(t, err)
+	assert.Equal(t, "https://hf-mirror.example/org/model/resolve/main/f.bin", r.URL)
+
+	for _, in := range []string{"https://example.com/f", "s3://bucket/f", "f.bin"} {
+		_, ok, err := Resolve(in, resolvers)
+		assert.NoError(t, err, in)
+		assert.False(t, ok, in)
+	}
+
+	for _, in := range []string{"hf://org/model", "hf://datasets/org/data", "hf://org//f", "hf://org/model/../f", "zenodo://123456", "ia://item", "ia://item/f?x=1"} {
+		_, ok, err := Resolve(in, resolvers)
+		assert.Error(t, err, in)
+		assert.True(t, ok, in)
+	}
+}
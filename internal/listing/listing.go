@@ -0,0 +1,300 @@
+// Package listing finds the files under a remote directory, for rapel
+// download --recursive: with WebDAV PROPFIND where the server speaks it,
+// or else by following the links of its HTML index pages, as Apache,
+// nginx and most file servers generate them.
+package listing
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	httpclient "github.com/redraw/rapel/internal/http"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// maxIndexBytes bounds an index page or PROPFIND answer read into memory.
+const maxIndexBytes = 32 << 20
+
+// File is a file found under the root.
+type File struct {
+	URL  string
+	Path string // relative to the root, slash-separated, each name sanitized for the local filesystem
+	Size int64  // -1 when the listing doesn't tell
+}
+
+// Options narrows down a listing.
+type Options struct {
+	Accept []string // name globs (path.Match) a file must match one of (empty = any)
+	Reject []string // name globs that leave a file out, even if accepted
+}
+
+// Match returns whether a file called name is listed.
+func (o Options) Match(name string) bool {
+	for _, glob := range o.Reject {
+		if ok, _ := path.Match(glob, name); ok {
+			return false
+		}
+	}
+	if len(o.Accept) == 0 {
+		return true
+	}
+	for _, glob := range o.Accept {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the files under the directory at root, depth first in the
+// order the server lists them. Only links below root are followed, each
+// directory once.
+func List(ctx context.Context, client *http.Client, root string, opts Options) ([]File, error) {
+	for _, glob := range append(append([]string{}, opts.Accept...), opts.Reject...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", glob, err)
+		}
+	}
+	base, err := url.Parse(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+
+	l := &lister{ctx: ctx, client: client, root: base, opts: opts, seen: make(map[string]bool)}
+	l.webdav, err = l.probeWebDAV()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.walk(base); err != nil {
+		return nil, err
+	}
+	return l.files, nil
+}
+
+type lister struct {
+	ctx    context.Context
+	client *http.Client
+	root   *url.URL
+	opts   Options
+	webdav bool
+	seen   map[string]bool // directory URLs walked
+	files  []File
+}
+
+// entry is a link out of a directory listing.
+type entry struct {
+	url  *url.URL
+	dir  bool
+	size int64
+}
+
+func (l *lister) walk(dir *url.URL) error {
+	if l.seen[dir.String()] {
+		return nil
+	}
+	l.seen[dir.String()] = true
+
+	var entries []entry
+	var err error
+	if l.webdav {
+		entries, err = l.propfind(dir)
+	} else {
+		entries, err = l.index(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", redact.URL(dir.String()), err)
+	}
+
+	for _, e := range entries {
+		rel, ok := l.relative(e.url)
+		if !ok {
+			continue
+		}
+		if e.dir {
+			if err := l.walk(e.url); err != nil {
+				return err
+			}
+			continue
+		}
+		if !l.opts.Match(path.Base(rel)) {
+			continue
+		}
+		l.files = append(l.files, File{URL: e.url.String(), Path: rel, Size: e.size})
+	}
+	return nil
+}
+
+// relative returns where u lies below the root as a local path, or false
+// for a link out of it (a parent directory, another host, a sort order).
+func (l *lister) relative(u *url.URL) (string, bool) {
+	if u.Scheme != l.root.Scheme || u.Host != l.root.Host || u.RawQuery != l.root.RawQuery {
+		return "", false
+	}
+	escaped := u.EscapedPath()
+	rootPath := l.root.EscapedPath()
+	if !strings.HasPrefix(escaped, rootPath) || len(escaped) <= len(rootPath) {
+		return "", false
+	}
+
+	var names []string
+	for _, seg := range strings.Split(strings.Trim(escaped[len(rootPath):], "/"), "/") {
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			seg = decoded
+		}
+		name := httpclient.SanitizeFilename(seg)
+		if name == "" {
+			return "", false
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, "/"), true
+}
+
+// probeWebDAV returns whether the root answers PROPFIND.
+func (l *lister) probeWebDAV() (bool, error) {
+	resp, err := l.do("PROPFIND", l.root)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusMultiStatus {
+		slog.Debug("Listing with WebDAV", "url", redact.URL(l.root.String()))
+		return true, nil
+	}
+	slog.Debug("Listing from HTML index pages", "url", redact.URL(l.root.String()), "propfind_status", resp.StatusCode)
+	return false, nil
+}
+
+func (l *lister) do(method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(l.ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if method == "PROPFIND" {
+		req.Header.Set("Depth", "1")
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", method, redact.Error(err))
+	}
+	return resp, nil
+}
+
+// multistatus is the part of a PROPFIND answer a listing needs.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind lists dir with a Depth: 1 PROPFIND.
+func (l *lister) propfind(dir *url.URL) ([]entry, error) {
+	resp, err := l.do("PROPFIND", dir)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND returned status %d", resp.StatusCode)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxIndexBytes)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND answer: %w", err)
+	}
+	var entries []entry
+	for _, r := range ms.Responses {
+		u, err := dir.Parse(strings.TrimSpace(r.Href))
+		if err != nil || strings.TrimSuffix(u.EscapedPath(), "/") == strings.TrimSuffix(dir.EscapedPath(), "/") {
+			continue
+		}
+		u.RawQuery = dir.RawQuery
+		e := entry{url: u, size: -1}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") && ps.Status != "" {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				e.dir = true
+			}
+			if n, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+				e.size = n
+			}
+		}
+		if e.dir && !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+			u.RawPath = ""
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// hrefPattern finds the links of an HTML page.
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// index lists dir from the links of its HTML index page. A link ending in
+// a slash is a directory.
+func (l *lister) index(dir *url.URL) ([]entry, error) {
+	resp, err := l.do("GET", dir)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET returned status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, fmt.Errorf("not a directory listing (%s), neither WebDAV nor HTML", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIndexBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read failed: %w", redact.Error(err))
+	}
+
+	var entries []entry
+	for _, m := range hrefPattern.FindAllStringSubmatch(string(body), -1) {
+		href := strings.TrimSpace(htmlUnescape(m[1] + m[2] + m[3]))
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "?") {
+			continue
+		}
+		u, err := dir.Parse(href)
+		if err != nil {
+			continue
+		}
+		u.Fragment = ""
+		if u.RawQuery == "" {
+			u.RawQuery = dir.RawQuery
+		}
+		entries = append(entries, entry{url: u, dir: strings.HasSuffix(u.Path, "/"), size: -1})
+	}
+	return entries, nil
+}
+
+// htmlUnescape decodes the entities that turn up in index page links.
+func htmlUnescape(s string) string {
+	return strings.NewReplacer("&amp;", "&", "&quot;", `"`, "&#39;", "'", "&lt;", "<", "&gt;", ">").Replace(s)
+}
@@ -0,0 +1,98 @@
+package listing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListHTMLIndex(t *testing.T) {
+	pages := map[string]string{
+		"/pub/": `<html><body><h1>Index of /pub</h1>
+<a href="?C=N;O=D">Name</a> <a href="../">Parent Directory</a>
+<a href="a.iso">a.iso</a> <a href='notes.txt'>notes.txt</a>
+<a href="sub/">sub/</a> <a href="/pub/sub/">again</a> <a href="https://elsewhere.example/x.iso">mirror</a>
+<a href="caf%C3%A9.iso#top">café.iso</a>`,
+		"/pub/sub/": `<a href="../">..</a> <A HREF="b.iso">b.iso</A> <a href="/other/c.iso">c.iso</a>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	files, err := List(context.Background(), srv.Client(), srv.URL+"/pub", Options{})
+	require.NoError(t, err)
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+		assert.Equal(t, int64(-1), f.Size)
+	}
+	assert.Equal(t, []string{"a.iso", "notes.txt", "sub/b.iso", "café.iso"}, paths)
+	assert.Equal(t, srv.URL+"/pub/sub/b.iso", files[2].URL)
+
+	files, err = List(context.Background(), srv.Client(), srv.URL+"/pub/", Options{Accept: []string{"*.iso"}, Reject: []string{"b.*"}})
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "a.iso", files[0].Path)
+
+	_, err = List(context.Background(), srv.Client(), srv.URL+"/pub/", Options{Accept: []string{"["}})
+	assert.ErrorContains(t, err, "invalid pattern")
+}
+
+func TestListWebDAV(t *testing.T) {
+	response := func(href string, dir bool, size int) string {
+		prop := fmt.Sprintf("<D:getcontentlength>%d</D:getcontentlength><D:resourcetype/>", size)
+		if dir {
+			prop = "<D:resourcetype><D:collection/></D:resourcetype>"
+		}
+		return fmt.Sprintf(`<D:response><D:href>%s</D:href><D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`, href, prop)
+	}
+	dirs := map[string][]string{
+		"/dav/":     {response("/dav/", true, 0), response("/dav/x.bin", false, 1000), response("/dav/d", true, 0)},
+		"/dav/d/":   {response("/dav/d/", true, 0), response("/dav/d/y%20z.bin", false, 20), response("/dav/d/e/", true, 0)},
+		"/dav/d/e/": {response("/dav/d/e/", true, 0)},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses, ok := dirs[r.URL.Path]
+		if r.Method != "PROPFIND" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "1", r.Header.Get("Depth"))
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><D:multistatus xmlns:D="DAV:">%s</D:multistatus>`, strings.Join(responses, ""))
+	}))
+	defer srv.Close()
+
+	files, err := List(context.Background(), srv.Client(), srv.URL+"/dav/", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []File{
+		{URL: srv.URL + "/dav/x.bin", Path: "x.bin", Size: 1000},
+		{URL: srv.URL + "/dav/d/y%20z.bin", Path: "d/y z.bin", Size: 20},
+	}, files)
+}
+
+func TestOptionsMatch(t *testing.T) {
+	assert.True(t, Options{}.Match("a.iso"))
+	o := Options{Accept: []string{"*.iso", "*.img"}, Reject: []string{"*-beta*"}}
+	assert.True(t, o.Match("a.img"))
+	assert.False(t, o.Match("a.txt"))
+	assert.False(t, o.Match("a-beta1.iso"))
+}
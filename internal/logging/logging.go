@@ -0,0 +1,251 @@
+// Package logging configures rapel's leveled logger (log/slog) and the shared
+// console writer used for live progress output.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LevelTrace is the most verbose level, enabled with -vv.
+const LevelTrace = slog.LevelDebug - 4
+
+// KindKey is an attribute key carrying a presentation hint for human output.
+const KindKey = "kind"
+
+// KindCmd marks output relayed from hook commands; the human handler renders
+// it dimmed with an arrow so it stands apart from rapel's own messages.
+const KindCmd = "cmd"
+
+// Options selects verbosity, format and destination.
+type Options struct {
+	Quiet   bool   // -q: warnings and errors only, no live progress
+	Verbose int    // 1 = -v (debug), 2 = -vv (trace)
+	Format  string // "text" (default) or "json"
+	File    string // write log records here instead of stdout
+	Stderr  bool   // log to stderr, leaving stdout for data
+}
+
+// Level returns the minimum level enabled by the options.
+func (o Options) Level() slog.Level {
+	switch {
+	case o.Quiet:
+		return slog.LevelWarn
+	case o.Verbose >= 2:
+		return LevelTrace
+	case o.Verbose == 1:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+var (
+	stdout = &lockedWriter{w: os.Stdout}
+	stderr = &lockedWriter{w: os.Stderr}
+
+	mu             sync.Mutex
+	console                  = stdout
+	progressOut    io.Writer = stdout
+	humanOnConsole           = true
+	jsonFormat     bool
+)
+
+// Setup installs the default slog logger according to opts and returns a
+// function that closes the log file, if any.
+func Setup(opts Options) (func() error, error) {
+	term, termFile := stdout, os.Stdout
+	if opts.Stderr {
+		term, termFile = stderr, os.Stderr
+	}
+
+	var out io.Writer = term
+	closeFn := func() error { return nil }
+	tty := isTerminal(termFile)
+
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = &lockedWriter{w: f}
+		closeFn = f.Close
+		tty = false
+	}
+
+	level := opts.Level()
+
+	var handler slog.Handler
+	switch opts.Format {
+	case "", "text":
+		handler = &humanHandler{out: out, tty: tty, level: level}
+	case "json":
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{
+			Level:       level,
+			ReplaceAttr: replaceLevelName,
+		})
+	default:
+		closeFn()
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", opts.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Live progress lines only make sense on a human console; JSON on
+	// stdout must stay machine-parseable and -q means quiet.
+	console = term
+	humanOnConsole = opts.File == "" && (opts.Format == "" || opts.Format == "text")
+	jsonFormat = opts.Format == "json"
+	if opts.Quiet || (opts.File == "" && opts.Format == "json") {
+		progressOut = io.Discard
+	} else {
+		progressOut = term
+	}
+
+	return closeFn, nil
+}
+
+// Console returns the writer for live progress output. It shares a lock with
+// the console log handler so progress lines and log records never interleave.
+// It discards output when progress display is disabled.
+func Console() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	return progressOut
+}
+
+// ProgressEnabled reports whether live progress output is shown.
+func ProgressEnabled() bool {
+	return Console() != io.Discard
+}
+
+// JSON reports whether logs are written as JSON, for output that should
+// follow suit.
+func JSON() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return jsonFormat
+}
+
+// Blank writes an empty separator line when logging to a human console at
+// normal verbosity; it is a no-op for JSON, log files and -q.
+func Blank() {
+	mu.Lock()
+	human := humanOnConsole && progressOut != io.Discard
+	out := console
+	mu.Unlock()
+
+	if human {
+		out.Write([]byte("\n"))
+	}
+}
+
+// replaceLevelName gives LevelTrace a readable name in JSON output.
+func replaceLevelName(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if lvl, ok := a.Value.Any().(slog.Level); ok && lvl <= LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// lockedWriter serializes writes from log handlers and progress output.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// humanHandler writes records as plain lines, matching rapel's traditional
+// console output. Attributes are appended as key=value only at -v or above.
+type humanHandler struct {
+	out   io.Writer
+	tty   bool
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *humanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *humanHandler) Handle(_ context.Context, r slog.Record) error {
+	var attrs []slog.Attr
+	kind := ""
+	collect := func(a slog.Attr) bool {
+		if a.Key == KindKey {
+			kind = a.Value.String()
+			return true
+		}
+		attrs = append(attrs, a)
+		return true
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	r.Attrs(collect)
+
+	var b strings.Builder
+	if h.tty {
+		// Clear any in-place progress line before printing
+		b.WriteString("\r\033[K")
+	}
+
+	msg := r.Message
+	if r.Level >= slog.LevelWarn && r.Level < slog.LevelError {
+		msg = "Warning: " + msg
+	}
+
+	if h.level <= slog.LevelDebug {
+		for _, a := range attrs {
+			msg += " " + a.Key + "=" + a.Value.String()
+		}
+	}
+
+	if kind == KindCmd {
+		if h.tty {
+			msg = "\033[2m→ " + msg + "\033[0m"
+		} else {
+			msg = "→ " + msg
+		}
+	}
+
+	b.WriteString(msg)
+	b.WriteString("\n")
+
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *humanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *humanHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// isTerminal checks if the file is a terminal
+func isTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return (fileInfo.Mode() & os.ModeCharDevice) != 0
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelInfo, Options{}.Level())
+	assert.Equal(t, slog.LevelWarn, Options{Quiet: true}.Level())
+	assert.Equal(t, slog.LevelDebug, Options{Verbose: 1}.Level())
+	assert.Equal(t, LevelTrace, Options{Verbose: 2}.Level())
+}
+
+func TestHumanHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(&humanHandler{out: &buf, level: slog.LevelInfo})
+
+	logger.Info("Merge complete", "bytes", 10)
+	logger.Warn("failed to delete x")
+	logger.Info("Running: echo", KindKey, KindCmd)
+	logger.Debug("hidden")
+
+	assert.Equal(t, "Merge complete\nWarning: failed to delete x\n→ Running: echo\n", buf.String())
+}
+
+func TestHumanHandlerVerboseAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(&humanHandler{out: &buf, level: slog.LevelDebug})
+
+	logger.With("file", "a.bin").Debug("detail", "n", 2)
+
+	assert.Equal(t, "detail file=a.bin n=2\n", buf.String())
+}
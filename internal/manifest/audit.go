@@ -0,0 +1,137 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// Remote is a store holding offloaded parts. Stat returns an error
+// wrapping fs.ErrNotExist for a missing part.
+type Remote interface {
+	Stat(ctx context.Context, name string) (storage.ObjectInfo, error)
+}
+
+// Problem is a part the remote doesn't hold intact.
+type Problem struct {
+	Part   Part
+	Reason string
+}
+
+// Report summarizes an audit.
+type Report struct {
+	Checked  int // Parts found with the right size
+	Verified int // Of those, parts whose hash the remote confirmed
+	Problems []Problem
+}
+
+// OK reports whether every part is present with the right size and hash.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Audit checks that the remote holds every part in m. Sizes are always
+// compared; hashes whenever the remote reports one the manifest has.
+func Audit(ctx context.Context, m *Manifest, remote Remote) (*Report, error) {
+	report := &Report{}
+	for _, part := range m.Parts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		info, err := remote.Stat(ctx, part.Name)
+		if errors.Is(err, fs.ErrNotExist) {
+			report.Problems = append(report.Problems, Problem{Part: part, Reason: "missing"})
+			continue
+		}
+		if err != nil {
+			report.Problems = append(report.Problems, Problem{Part: part, Reason: err.Error()})
+			continue
+		}
+
+		if info.Size != part.Size {
+			report.Problems = append(report.Problems, Problem{
+				Part:   part,
+				Reason: fmt.Sprintf("size %d, expected %d", info.Size, part.Size),
+			})
+			continue
+		}
+		report.Checked++
+
+		switch {
+		case info.SHA256 != "" && part.SHA256 != "":
+			if info.SHA256 != part.SHA256 {
+				report.Problems = append(report.Problems, Problem{Part: part, Reason: "sha256 mismatch"})
+				continue
+			}
+		case info.MD5 != "" && part.MD5 != "":
+			if info.MD5 != part.MD5 {
+				report.Problems = append(report.Problems, Problem{Part: part, Reason: "md5 mismatch"})
+				continue
+			}
+		default:
+			continue
+		}
+		report.Verified++
+	}
+	return report, nil
+}
+
+// rcloneRemote matches rclone-style "name:path" specs.
+var rcloneRemote = regexp.MustCompile(`^[A-Za-z0-9_.-]{2,}:`)
+
+// OpenRemote returns the remote for spec: an s3://bucket/prefix URL
+// (AWS_ENDPOINT_URL selects R2 or another S3-compatible store) or a
+// directory, such as a mounted remote.
+func OpenRemote(spec string) (Remote, error) {
+	if strings.HasPrefix(spec, "s3://") {
+		s3, err := storage.NewS3Bucket(spec)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Remote{s3: s3}, nil
+	}
+	if strings.Contains(spec, "://") {
+		return nil, fmt.Errorf("unsupported remote %q, want s3://bucket/prefix or a directory", spec)
+	}
+	if rcloneRemote.MatchString(spec) {
+		return nil, fmt.Errorf("rclone remote %q is not supported: use s3://bucket/prefix with AWS_ENDPOINT_URL for S3-compatible stores such as R2, or audit a mounted directory", spec)
+	}
+
+	st, err := os.Stat(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote: %w", err)
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("remote %s is not a directory", spec)
+	}
+	return dirRemote(spec), nil
+}
+
+// s3Remote looks parts up under the bucket prefix.
+type s3Remote struct {
+	s3 *storage.S3
+}
+
+func (r *s3Remote) Stat(ctx context.Context, name string) (storage.ObjectInfo, error) {
+	return r.s3.Stat(ctx, path.Join(r.s3.Key, name))
+}
+
+// dirRemote reads parts from a directory and hashes them in full.
+type dirRemote string
+
+func (r dirRemote) Stat(ctx context.Context, name string) (storage.ObjectInfo, error) {
+	size, sha, md, err := HashFile(filepath.Join(string(r), name))
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	return storage.ObjectInfo{Size: size, SHA256: sha, MD5: md}, nil
+}
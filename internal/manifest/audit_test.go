@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditDirRemote(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+
+	m := &Manifest{File: "f", Size: 10, ChunkSize: 4}
+	for i, data := range []string{"0123", "4567", "89"} {
+		name := filepath.Join(local, "f."+string(rune('a'+i)))
+		require.NoError(t, os.WriteFile(name, []byte(data), 0644))
+		size, sha, md, err := HashFile(name)
+		require.NoError(t, err)
+		m.Parts = append(m.Parts, Part{Index: i, Name: filepath.Base(name), Size: size, SHA256: sha, MD5: md})
+	}
+
+	path := filepath.Join(local, "m.json")
+	require.NoError(t, m.Save(path))
+	m, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, m.Parts, 3)
+
+	// a intact, b corrupted, c missing
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "f.a"), []byte("0123"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "f.b"), []byte("4568"), 0644))
+
+	r, err := OpenRemote(remote)
+	require.NoError(t, err)
+	report, err := Audit(context.Background(), m, r)
+	require.NoError(t, err)
+
+	assert.False(t, report.OK())
+	assert.Equal(t, 2, report.Checked)
+	assert.Equal(t, 1, report.Verified)
+	require.Len(t, report.Problems, 2)
+	assert.Equal(t, "sha256 mismatch", report.Problems[0].Reason)
+	assert.Equal(t, "f.c", report.Problems[1].Part.Name)
+	assert.Equal(t, "missing", report.Problems[1].Reason)
+}
+
+func TestOpenRemoteRejectsRclone(t *testing.T) {
+	_, err := OpenRemote("r2:bucket/prefix")
+	assert.ErrorContains(t, err, "rclone remote")
+
+	_, err = OpenRemote("ftp://host/dir")
+	assert.Error(t, err)
+}
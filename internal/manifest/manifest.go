@@ -0,0 +1,84 @@
+// Package manifest describes a download's chunk files and their hashes, so
+// parts moved elsewhere (by --post-part, say) can still be verified.
+package manifest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// Manifest lists the parts of a download.
+type Manifest struct {
+	File      string `json:"file"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	SHA256    string `json:"sha256,omitempty"` // Whole file, once known
+	Parts     []Part `json:"parts"`
+}
+
+// Part is one chunk file. Start and End are inclusive byte offsets.
+type Part struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"` // Matches single-request S3 ETags
+}
+
+// PathFor returns where --write-manifest keeps the manifest of download
+// prefix.
+func PathFor(prefix string) string {
+	return prefix + ".manifest.json"
+}
+
+// Load reads a manifest file.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest atomically.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// HashFile returns the size, SHA-256 and MD5 of the file at path.
+func HashFile(path string) (size int64, sha256Hex, md5Hex string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer f.Close()
+
+	sh, mh := sha256.New(), md5.New()
+	size, err = io.Copy(io.MultiWriter(sh, mh), f)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return size, hex.EncodeToString(sh.Sum(nil)), hex.EncodeToString(mh.Sum(nil)), nil
+}
@@ -0,0 +1,42 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// XattrName is the extended attribute --part-meta xattr stores a part's
+// PartMeta in.
+const XattrName = "user.rapel.part"
+
+// PartMeta describes one part on its own: where its bytes sit in which
+// file, and their hashes. --part-meta writes it next to each part, so a
+// part consumed out of band (offloaded by --post-part, say) can be placed
+// without the state files or the manifest.
+type PartMeta struct {
+	File     string `json:"file"`      // the whole file the part belongs to
+	FileSize int64  `json:"file_size"` // size of the whole file
+	URL      string `json:"url"`       // redacted: signatures, tokens and passwords replaced
+	Part
+}
+
+// SidecarPath returns where --part-meta sidecar writes the PartMeta of the
+// part at partPath.
+func SidecarPath(partPath string) string {
+	return partPath + ".json"
+}
+
+// WriteSidecar writes meta to the sidecar of the part at partPath.
+func WriteSidecar(partPath string, meta PartMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal part metadata: %w", err)
+	}
+	path := SidecarPath(partPath)
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write part metadata: %w", err)
+	}
+	return nil
+}
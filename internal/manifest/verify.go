@@ -0,0 +1,94 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/redraw/rapel/internal/failure"
+)
+
+// Verifier checks chunk files, and the file merged from them, against the
+// manifest --write-manifest left next to them. It is what rapel merge
+// --verify hands the merger.
+type Verifier struct {
+	manifests map[string]*Manifest
+}
+
+// manifest loads the manifest of download name.
+func (v *Verifier) manifest(name string) (*Manifest, error) {
+	if m, ok := v.manifests[name]; ok {
+		return m, nil
+	}
+	m, err := Load(PathFor(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no manifest to verify %s against (%s); download with --write-manifest", name, PathFor(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.manifests == nil {
+		v.manifests = make(map[string]*Manifest)
+	}
+	v.manifests[name] = m
+	return m, nil
+}
+
+// VerifyParts checks that every file is a part of download name with the
+// size and SHA-256 the manifest lists.
+func (v *Verifier) VerifyParts(name string, files []string) error {
+	m, err := v.manifest(name)
+	if err != nil {
+		return err
+	}
+	parts := make(map[string]Part, len(m.Parts))
+	for _, p := range m.Parts {
+		parts[p.Name] = p
+	}
+
+	var problems []string
+	for _, file := range files {
+		part, ok := parts[filepath.Base(file)]
+		if !ok {
+			problems = append(problems, file+": not in the manifest")
+			continue
+		}
+		size, sha, _, err := HashFile(file)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+		case size != part.Size:
+			problems = append(problems, fmt.Sprintf("%s: %d bytes, expected %d", file, size, part.Size))
+		case sha != part.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: SHA-256 %s, expected %s", file, sha, part.SHA256))
+		}
+	}
+	if len(problems) > 0 {
+		return failure.Errorf(failure.ErrChecksum, "%d of %d chunk files don't match %s:\n  %s",
+			len(problems), len(files), PathFor(name), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// VerifyOutput checks the file merged from download name against the
+// whole-file SHA-256 in its manifest. It is skipped, returning false,
+// when the manifest has none.
+func (v *Verifier) VerifyOutput(name, path string) (bool, error) {
+	m, err := v.manifest(name)
+	if err != nil {
+		return false, err
+	}
+	if m.SHA256 == "" {
+		return false, nil
+	}
+	_, sha, _, err := HashFile(path)
+	if err != nil {
+		return false, err
+	}
+	if sha != m.SHA256 {
+		return false, failure.Errorf(failure.ErrChecksum, "%s has SHA-256 %s, expected %s from %s", path, sha, m.SHA256, PathFor(name))
+	}
+	return true, nil
+}
@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile("f.000001.part", []byte("bb"), 0644))
+	require.NoError(t, os.WriteFile("f", []byte("aaaabb"), 0644))
+	m := &Manifest{File: "f", Size: 6, ChunkSize: 4}
+	for i, name := range []string{"f.000000.part", "f.000001.part"} {
+		size, sha, md, err := HashFile(name)
+		require.NoError(t, err)
+		m.Parts = append(m.Parts, Part{Index: i, Name: name, Size: size, SHA256: sha, MD5: md})
+	}
+	_, m.SHA256, _, _ = HashFile("f")
+	require.NoError(t, m.Save(PathFor("f")))
+
+	v := &Verifier{}
+	assert.NoError(t, v.VerifyParts("f", []string{"f.000000.part", "f.000001.part"}))
+	checked, err := v.VerifyOutput("f", "f")
+	assert.NoError(t, err)
+	assert.True(t, checked)
+
+	// Same size, different bytes
+	require.NoError(t, os.WriteFile("f.000001.part", []byte("bc"), 0644))
+	err = v.VerifyParts("f", []string{"f.000000.part", "f.000001.part"})
+	assert.ErrorContains(t, err, "1 of 2 chunk files don't match")
+	assert.ErrorContains(t, err, "f.000001.part: SHA-256")
+
+	err = v.VerifyParts("f", []string{"f.000002.part"})
+	assert.ErrorContains(t, err, "not in the manifest")
+
+	require.NoError(t, os.WriteFile("f", []byte("aaaabc"), 0644))
+	_, err = v.VerifyOutput("f", "f")
+	assert.ErrorContains(t, err, "expected")
+
+	_, err = (&Verifier{}).VerifyOutput("g", "g")
+	assert.ErrorContains(t, err, "--write-manifest")
+}
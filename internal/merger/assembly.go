@@ -0,0 +1,179 @@
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// assembly is an in-progress merge: the .assembling output file plus a
+// small journal of the chunk files already copied into it, so an
+// interrupted merge continues where it stopped instead of starting over.
+// The journal lists file names rather than a count because --delete
+// removes chunks as they are merged.
+type assembly struct {
+	file      *os.File
+	path      string
+	statePath string
+	state     assemblyState
+}
+
+// assemblyState is the journal persisted next to the .assembling file.
+type assemblyState struct {
+	Merged []assembledPart `json:"merged"`
+	Bytes  int64           `json:"bytes"`
+}
+
+// assembledPart identifies a chunk file copied into the output. Size and
+// modification time catch chunks that were re-downloaded since.
+type assembledPart struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// openAssembly opens tmpPath for appending. If a journal from an earlier
+// run exists, the file is truncated to the journaled length (dropping any
+// partially copied chunk) and the journal is kept; otherwise the merge
+// starts from an empty file.
+func openAssembly(tmpPath string) (*assembly, error) {
+	a := &assembly{path: tmpPath, statePath: tmpPath + ".json"}
+
+	if ok := a.loadState(); ok {
+		file, err := os.OpenFile(tmpPath, os.O_WRONLY, 0644)
+		if err == nil {
+			if err := file.Truncate(a.state.Bytes); err == nil {
+				if _, err := file.Seek(a.state.Bytes, 0); err == nil {
+					a.file = file
+					return a, nil
+				}
+			}
+			file.Close()
+		}
+	}
+
+	// Nothing usable to resume
+	a.state = assemblyState{}
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	a.file = file
+
+	if err := a.save(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// adoptChunk starts the assembly of tmpPath by renaming partPath to it,
+// for a merge that deletes its chunks anyway: the first chunk needn't be
+// copied. The journal is written first, so a crash in between leaves the
+// chunk where it was and the next merge starts over.
+func adoptChunk(tmpPath, partPath string) error {
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return err
+	}
+	a := &assembly{path: tmpPath, statePath: tmpPath + ".json"}
+	a.state.Merged = []assembledPart{{Path: partPath, Size: info.Size(), ModTime: info.ModTime()}}
+	a.state.Bytes = info.Size()
+	if err := a.save(); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, tmpPath); err != nil {
+		os.Remove(a.statePath)
+		return err
+	}
+	return nil
+}
+
+// loadState reads the journal and checks it against the .assembling file.
+func (a *assembly) loadState() bool {
+	data, err := os.ReadFile(a.statePath)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		return false
+	}
+
+	info, err := os.Stat(a.path)
+	if err != nil || info.Size() < a.state.Bytes {
+		return false
+	}
+
+	// Chunks still on disk must be the ones that were merged
+	for _, part := range a.state.Merged {
+		info, err := os.Stat(part.Path)
+		if os.IsNotExist(err) {
+			continue // deleted by --delete after merging
+		}
+		if err != nil || !info.ModTime().Equal(part.ModTime) {
+			return false
+		}
+		if size, err := chunkSize(part.Path); err != nil || size != part.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// journaledParts returns the chunks recorded in the journal of tmpPath,
+// without validating it against the files.
+func journaledParts(tmpPath string) []assembledPart {
+	a := &assembly{path: tmpPath, statePath: tmpPath + ".json"}
+	data, err := os.ReadFile(a.statePath)
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		return nil
+	}
+	return a.state.Merged
+}
+
+// isMerged reports whether partPath was already copied into the output.
+func (a *assembly) isMerged(partPath string) bool {
+	for _, part := range a.state.Merged {
+		if part.Path == partPath {
+			return true
+		}
+	}
+	return false
+}
+
+// record journals that partPath (n bytes) has been copied.
+func (a *assembly) record(partPath string, n int64) error {
+	part := assembledPart{Path: partPath, Size: n}
+	if info, err := os.Stat(partPath); err == nil {
+		part.ModTime = info.ModTime()
+	}
+	a.state.Merged = append(a.state.Merged, part)
+	a.state.Bytes += n
+	return a.save()
+}
+
+// save writes the journal atomically.
+func (a *assembly) save() error {
+	data, err := json.Marshal(a.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal merge state: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(a.statePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write merge state: %w", err)
+	}
+	return nil
+}
+
+// discard removes the output file and journal.
+func (a *assembly) discard() {
+	a.file.Close()
+	os.Remove(a.path)
+	os.Remove(a.statePath)
+}
@@ -0,0 +1,62 @@
+package merger
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeResumesAssembly(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile("f.000001.part", []byte("bbbb"), 0644))
+	require.NoError(t, os.WriteFile("f.000002.part", []byte("cccc"), 0644))
+
+	// Simulate a merge killed while copying the second chunk: the first is
+	// journaled and deleted, and half of the second made it to disk
+	asm, err := openAssembly("f.assembling")
+	require.NoError(t, err)
+	_, err = asm.file.WriteString("aaaa")
+	require.NoError(t, err)
+	require.NoError(t, asm.record("f.000000.part", 4))
+	_, err = asm.file.WriteString("bb")
+	require.NoError(t, err)
+	require.NoError(t, asm.file.Close())
+	require.NoError(t, os.Remove("f.000000.part"))
+
+	m := NewMerger(Config{Pattern: "f.*.part", Delete: true})
+	require.NoError(t, m.Merge())
+
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabbbbcccc", string(out))
+
+	_, err = os.Stat("f.assembling.json")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMergeRestartsStaleAssembly(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile("f.000001.part", []byte("bbbb"), 0644))
+
+	asm, err := openAssembly("f.assembling")
+	require.NoError(t, err)
+	_, err = asm.file.WriteString("xxxx")
+	require.NoError(t, err)
+	require.NoError(t, asm.record("f.000000.part", 4))
+	require.NoError(t, asm.file.Close())
+
+	// The chunk was re-downloaded since, so the journal no longer applies
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("AAAAA"), 0644))
+
+	require.NoError(t, NewMerger(Config{Pattern: "f.*.part"}).Merge())
+
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "AAAAAbbbb", string(out))
+}
@@ -0,0 +1,134 @@
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Compression formats recognized by --decompress.
+const (
+	FormatGzip   = "gzip"
+	FormatBzip2  = "bzip2"
+	FormatZstd   = "zstd"
+	FormatXz     = "xz"
+	FormatBrotli = "br"
+)
+
+// formatExts maps file extensions to compression formats.
+var formatExts = map[string]string{
+	".gz":   FormatGzip,
+	".tgz":  FormatGzip,
+	".bz2":  FormatBzip2,
+	".zst":  FormatZstd,
+	".zstd": FormatZstd,
+	".xz":   FormatXz,
+	".br":   FormatBrotli,
+}
+
+// externalDecoders are the commands used for formats the standard library
+// can't decode. They must be on PATH.
+var externalDecoders = map[string][]string{
+	FormatZstd:   {"zstd", "-dc"},
+	FormatXz:     {"xz", "-dc"},
+	FormatBrotli: {"brotli", "-dc"},
+}
+
+// DetectFormat identifies the compression format from the first bytes of
+// the stream, falling back to the extension of name (brotli has no magic
+// number). Returns "" if the data doesn't look compressed.
+func DetectFormat(header []byte, name string) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return FormatBzip2
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return FormatZstd
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return FormatXz
+	}
+	return formatExts[compressionExt(name)]
+}
+
+// DecompressedName strips a compression extension from name:
+// "file.tar.gz" -> "file.tar", "file.tgz" -> "file.tar".
+func DecompressedName(name string) string {
+	ext := compressionExt(name)
+	if ext == "" {
+		return name
+	}
+	base := strings.TrimSuffix(name, name[len(name)-len(ext):])
+	if strings.EqualFold(ext, ".tgz") {
+		return base + ".tar"
+	}
+	return base
+}
+
+// compressionExt returns name's extension if it is a known compression
+// extension, or "".
+func compressionExt(name string) string {
+	for i := len(name) - 1; i >= 0 && name[i] != '/'; i-- {
+		if name[i] == '.' {
+			if _, ok := formatExts[strings.ToLower(name[i:])]; ok {
+				return name[i:]
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// decompress copies the decoded form of r to w.
+func decompress(w io.Writer, r io.Reader, format string) error {
+	switch format {
+	case FormatGzip:
+		// gzip.Reader handles concatenated members, as produced by pigz or
+		// by appending .gz files
+		zr, err := gzip.NewReader(bufio.NewReader(r))
+		if err != nil {
+			return fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		defer zr.Close()
+		if _, err := io.Copy(w, zr); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		return nil
+
+	case FormatBzip2:
+		if _, err := io.Copy(w, bzip2.NewReader(bufio.NewReader(r))); err != nil {
+			return fmt.Errorf("bzip2: %w", err)
+		}
+		return nil
+	}
+
+	argv, ok := externalDecoders[format]
+	if !ok {
+		return fmt.Errorf("unsupported compression format: %s", format)
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return fmt.Errorf("decompressing %s needs the %s command: %w", format, argv[0], err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, argv[1:]...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return fmt.Errorf("%s: %w: %s", argv[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("%s: %w", argv[0], err)
+	}
+	return nil
+}
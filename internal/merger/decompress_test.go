@@ -0,0 +1,72 @@
+package merger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   []byte
+		file     string
+		expected string
+	}{
+		{name: "gzip magic", header: []byte{0x1f, 0x8b, 0x08}, file: "file.bin", expected: FormatGzip},
+		{name: "bzip2 magic", header: []byte("BZh91AY"), file: "file", expected: FormatBzip2},
+		{name: "zstd magic", header: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, file: "file", expected: FormatZstd},
+		{name: "xz magic", header: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, file: "file", expected: FormatXz},
+		{name: "brotli by extension", header: []byte{0x0b, 0x02}, file: "page.html.br", expected: FormatBrotli},
+		{name: "uncompressed", header: []byte("hello"), file: "file.txt", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DetectFormat(tt.header, tt.file))
+		})
+	}
+}
+
+func TestDecompressedName(t *testing.T) {
+	assert.Equal(t, "file.tar", DecompressedName("file.tar.gz"))
+	assert.Equal(t, "file.tar", DecompressedName("file.tgz"))
+	assert.Equal(t, "dump.sql", DecompressedName("dump.sql.ZST"))
+	assert.Equal(t, "file.bin", DecompressedName("file.bin"))
+	assert.Equal(t, "v1.2/file", DecompressedName("v1.2/file"))
+}
+
+func TestMergeDecompress(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	payload := bytes.Repeat([]byte("rapel chunked payload\n"), 5000)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	// Split the gzip stream at arbitrary offsets, as chunks would be
+	data := compressed.Bytes()
+	cut := len(data) / 3
+	for i, part := range [][]byte{data[:cut], data[cut : 2*cut], data[2*cut:]} {
+		name := filepath.Join(dir, "data.txt.gz.00000"+string(rune('0'+i))+".part")
+		require.NoError(t, os.WriteFile(name, part, 0644))
+	}
+
+	m := NewMerger(Config{Pattern: "data.txt.gz.*.part", Decompress: true, Delete: true})
+	require.NoError(t, m.Merge())
+
+	out, err := os.ReadFile("data.txt")
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	parts, _ := filepath.Glob("*.part")
+	assert.Empty(t, parts)
+}
@@ -4,10 +4,17 @@ package merger
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"sync/atomic"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/logging"
+	"github.com/redraw/rapel/internal/registry"
 )
 
 // Config holds merger configuration
@@ -15,11 +22,66 @@ type Config struct {
 	Output  string
 	Pattern string
 	Delete  bool
+
+	// OutputDir, if set, receives the merged file instead of the current directory.
+	OutputDir string
+
+	// OutputName, if set, names the merged file instead of the group, as
+	// when a mirrored tree's chunks have names of their own. Decompress
+	// strips its compression extension all the same.
+	OutputName string
+
+	// Dirs, if set, are searched for chunk files instead of the current
+	// directory, as when the chunks of a split download come from
+	// several machines.
+	Dirs []string
+
+	// Decompress decodes gzip, bzip2, zstd, xz or brotli data while
+	// merging and strips the compression extension from the output name.
+	Decompress bool
+
+	// Writer, if set, receives the merged data instead of a file. Only a
+	// single group can be streamed.
+	Writer io.Writer
+
+	// AllowGaps merges groups with missing, duplicate or truncated chunks,
+	// or of an unfinished download, with a warning instead of failing.
+	AllowGaps bool
+
+	// Key decrypts chunk files written with --encrypt-parts.
+	Key *crypt.Key
+
+	// Jobs, if more than 1, preallocates the output and copies that many
+	// chunk files into it at once, each at its offset.
+	Jobs int
+
+	// Reflink shares the chunk files' disk blocks with the output instead
+	// of copying them, where the filesystem allows it; other chunks are
+	// copied.
+	Reflink bool
+
+	// Sparse leaves runs of zeros in the output as holes, which take no
+	// disk space.
+	Sparse bool
+
+	// Verify, if set, checks each group's chunk files before they are
+	// merged, and the merged file after.
+	Verify Verifier
+}
+
+// Verifier checks chunk files, and the file merged from them, against
+// what was downloaded. name is the group's output name. VerifyOutput
+// returns false if it had nothing to check the output against.
+type Verifier interface {
+	VerifyParts(name string, files []string) error
+	VerifyOutput(name, path string) (bool, error)
 }
 
 // Merger handles merging chunk files
 type Merger struct {
-	config Config
+	config  Config
+	outputs []string
+	holes   atomic.Int64 // bytes of zeros left as holes with Sparse
 }
 
 // NewMerger creates a new Merger
@@ -33,9 +95,9 @@ func NewMerger(config Config) *Merger {
 // Merge merges all matching chunk files into the output file
 func (m *Merger) Merge() error {
 	// Find all matching files
-	matches, err := filepath.Glob(m.config.Pattern)
+	matches, err := m.findFiles()
 	if err != nil {
-		return fmt.Errorf("failed to find matching files: %w", err)
+		return err
 	}
 
 	if len(matches) == 0 {
@@ -45,6 +107,10 @@ func (m *Merger) Merge() error {
 	// Group files by basename
 	basenameGroups := groupFilesByBasename(matches)
 
+	if m.config.Writer != nil {
+		return m.stream(basenameGroups)
+	}
+
 	// Determine which files to merge
 	if m.config.Output != "" {
 		// Output specified: merge single group
@@ -59,7 +125,7 @@ func (m *Merger) Merge() error {
 	if len(basenameGroups) == 1 {
 		// Single basename group: use it
 		for basename := range basenameGroups {
-			fmt.Printf("Auto-detected output name: %s\n", basename)
+			slog.Info("Auto-detected output name: "+basename, "output", basename)
 			return m.mergeGroup(basename, basenameGroups)
 		}
 	}
@@ -71,23 +137,50 @@ func (m *Merger) Merge() error {
 	}
 	sort.Strings(basenames)
 
-	fmt.Printf("Found %d download sessions to merge:\n", len(basenames))
+	slog.Info(fmt.Sprintf("Found %d download sessions to merge:", len(basenames)), "sessions", len(basenames))
 	for _, basename := range basenames {
-		fmt.Printf("  - %s (%d files)\n", basename, len(basenameGroups[basename]))
+		slog.Info(fmt.Sprintf("  - %s (%d files)", basename, len(basenameGroups[basename])), "session", basename, "files", len(basenameGroups[basename]))
 	}
-	fmt.Println()
+	logging.Blank()
 
 	// Merge each group
 	for _, basename := range basenames {
 		if err := m.mergeGroup(basename, basenameGroups); err != nil {
 			return fmt.Errorf("failed to merge %s: %w", basename, err)
 		}
-		fmt.Println()
+		logging.Blank()
 	}
 
 	return nil
 }
 
+// findFiles returns the files matching Pattern in the current directory
+// or in each of Dirs.
+func (m *Merger) findFiles() ([]string, error) {
+	if len(m.config.Dirs) == 0 {
+		matches, err := filepath.Glob(m.config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find matching files: %w", err)
+		}
+		return matches, nil
+	}
+
+	var matches []string
+	for _, dir := range m.config.Dirs {
+		found, err := filepath.Glob(filepath.Join(dir, m.config.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find matching files: %w", err)
+		}
+		matches = append(matches, found...)
+	}
+	return matches, nil
+}
+
+// Outputs returns the files written by Merge.
+func (m *Merger) Outputs() []string {
+	return m.outputs
+}
+
 // mergeGroup merges a single basename group
 func (m *Merger) mergeGroup(outputName string, basenameGroups map[string][]string) error {
 	// Find files for this basename
@@ -101,73 +194,287 @@ func (m *Merger) mergeGroup(outputName string, basenameGroups map[string][]strin
 		filesToMerge = matches
 	}
 
-	// Sort files lexicographically (assumes zero-padded indexes)
-	sort.Strings(filesToMerge)
+	sortChunkFiles(filesToMerge)
+	filesToMerge = dropPacked(filesToMerge)
 
-	fmt.Printf("Merging %d chunk files into: %s\n", len(filesToMerge), outputName)
+	// Chunks an interrupted merge copied (and --delete removed) still count
+	tmpName := outputName
+	if m.config.Decompress {
+		tmpName = DecompressedName(outputName)
+	}
+	if err := m.checkGroup(outputName, filesToMerge, journaledParts(tmpName+".assembling")); err != nil {
+		return err
+	}
+	if err := m.checkEncryption(filesToMerge); err != nil {
+		return err
+	}
+	if m.config.Verify != nil {
+		if err := m.verifyParts(outputName, tmpName+".assembling", filesToMerge); err != nil {
+			return err
+		}
+	}
 
-	// Create temporary output file
-	tmpPath := outputName + ".assembling"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+	format := ""
+	if m.config.Decompress {
+		format = m.detectGroupFormat(filesToMerge, outputName)
+		if format == "" {
+			slog.Warn(fmt.Sprintf("%s doesn't look compressed, merging as is", outputName), "output", outputName)
+		}
 	}
 
+	name := outputName
+	if format != "" {
+		name = DecompressedName(outputName)
+	}
+	target := name
+	rename := m.config.OutputName
+	if rename == "" {
+		rename = savedOutputName(outputName)
+	}
+	if rename != "" {
+		target = rename
+		if format != "" {
+			target = DecompressedName(target)
+		}
+	}
+	if m.config.OutputDir != "" {
+		target = filepath.Join(m.config.OutputDir, target)
+	}
+
+	if format != "" {
+		slog.Info(fmt.Sprintf("Merging %d chunk files into: %s (decompressing %s)", len(filesToMerge), target, format),
+			"files", len(filesToMerge), "output", target, "format", format)
+	} else {
+		slog.Info(fmt.Sprintf("Merging %d chunk files into: %s", len(filesToMerge), target), "files", len(filesToMerge), "output", target)
+	}
+
+	// Assemble next to the chunks, so an interrupted merge leaves nothing
+	// in the output directory
+	tmpPath := name + ".assembling"
 	var totalBytes int64
 
-	// Merge all chunks
-	for i, partPath := range filesToMerge {
-		if err := m.mergeChunk(tmpFile, partPath, i+1, len(filesToMerge), &totalBytes); err != nil {
+	if format != "" {
+		// Decoder state can't be checkpointed, so decompressing always starts over
+		os.Remove(tmpPath + ".json")
+		tmpFile, err := os.Create(tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		if err := m.mergeDecompressed(tmpFile, filesToMerge, format, &totalBytes); err != nil {
 			tmpFile.Close()
 			os.Remove(tmpPath)
 			return err
 		}
+		if err := tmpFile.Close(); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+	} else {
+		// With --delete the first chunk can become the output itself
+		adopted := int64(0)
+		if m.config.Delete && len(filesToMerge) > 0 && journaledParts(tmpPath) == nil && !crypt.IsEncrypted(filesToMerge[0]) {
+			first := filesToMerge[0]
+			if err := adoptChunk(tmpPath, first); err != nil {
+				slog.Debug(fmt.Sprintf("Copying %s: can't rename it into place: %v", first, err), "part", first)
+			} else {
+				slog.Info(fmt.Sprintf("[1/%d] Renamed %s into place", len(filesToMerge), first), "part", first)
+				adopted, _ = chunkSize(tmpPath)
+			}
+		}
+
+		asm, err := openAssembly(tmpPath)
+		if err != nil {
+			return err
+		}
+		if adopted > 0 && m.config.Sparse {
+			m.punchZeros(asm.file, 0, adopted)
+		}
+
+		var remaining []string
+		for _, partPath := range filesToMerge {
+			if !asm.isMerged(partPath) {
+				remaining = append(remaining, partPath)
+			}
+		}
+		if done := len(asm.state.Merged); done > 0 {
+			slog.Info(fmt.Sprintf("Resuming merge: %d chunk files already assembled (%s)", done, formatBytes(asm.state.Bytes)),
+				"merged", done, "bytes", asm.state.Bytes)
+		}
+		totalBytes = asm.state.Bytes
+
+		// On failure the .assembling file and its journal are kept so the
+		// next run resumes
+		merged := func(partPath string, n int64) error {
+			if err := asm.record(partPath, n); err != nil {
+				return err
+			}
+			if m.config.Delete {
+				deleteChunks([]string{partPath})
+			}
+			return nil
+		}
+		// A lone chunk is worth trying to clone whatever the flags say
+		if m.config.Jobs > 1 || m.config.Reflink || m.config.Sparse || len(remaining) == 1 {
+			err = m.mergeAt(asm, remaining, &totalBytes, merged)
+		} else {
+			err = m.mergeChunks(asm.file, remaining, &totalBytes, merged)
+		}
+		if err != nil {
+			asm.file.Close()
+			return err
+		}
+
+		if err := asm.file.Close(); err != nil {
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+		defer os.Remove(asm.statePath)
+	}
+
+	if err := placeOutput(tmpPath, target); err != nil {
+		return err
+	}
+	m.outputs = append(m.outputs, target)
 
-		// Delete chunk if requested
+	if format != "" {
+		// Compressed chunks are only deleted once the whole stream decoded
 		if m.config.Delete {
-			if err := os.Remove(partPath); err != nil {
-				fmt.Printf("Warning: failed to delete %s: %v\n", partPath, err)
+			deleteChunks(filesToMerge)
+		}
+		if info, err := os.Stat(target); err == nil {
+			slog.Info(fmt.Sprintf("Merge complete: %s (%s, %s compressed)", target, formatBytes(info.Size()), formatBytes(totalBytes)),
+				"output", target, "bytes", info.Size(), "compressed_bytes", totalBytes)
+		}
+	} else {
+		slog.Info(fmt.Sprintf("Merge complete: %s (%s)", target, formatBytes(totalBytes)), "output", target, "bytes", totalBytes)
+		if holes := m.holes.Swap(0); holes > 0 {
+			slog.Info(fmt.Sprintf("Sparse     : %s of zeros left as holes", formatBytes(holes)), "output", target, "hole_bytes", holes)
+		}
+		if m.config.Verify != nil {
+			checked, err := m.config.Verify.VerifyOutput(outputName, target)
+			if err != nil {
+				return err
+			}
+			if checked {
+				slog.Info("Verified   : "+target, "output", target)
+			} else {
+				slog.Warn(fmt.Sprintf("%s not verified: the manifest has no whole-file hash", target), "output", target)
+			}
+		}
+	}
+
+	// Delete state files if requested
+	if m.config.Delete {
+		for _, stateFile := range []string{
+			registry.StatePath(outputName, "args.json"),
+			registry.StatePath(outputName, "secrets.json"),
+		} {
+			if err := os.Remove(stateFile); err != nil {
+				if !os.IsNotExist(err) {
+					slog.Warn(fmt.Sprintf("failed to delete state file %s: %v", stateFile, err), "file", stateFile, "error", err)
+				}
 			}
 		}
+		registry.PruneStateDir(outputName)
 	}
 
-	if err := tmpFile.Close(); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to close output file: %w", err)
+	return nil
+}
+
+// verifyParts checks the chunk files of a group that an interrupted merge
+// hasn't already copied into tmpPath.
+func (m *Merger) verifyParts(outputName, tmpPath string, files []string) error {
+	journaled := make(map[string]bool)
+	for _, part := range journaledParts(tmpPath) {
+		journaled[part.Path] = true
+	}
+	var pending []string
+	for _, partPath := range files {
+		if !journaled[partPath] {
+			pending = append(pending, partPath)
+		}
 	}
+	if err := m.config.Verify.VerifyParts(outputName, pending); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Verified %d chunk files against the manifest", len(pending)), "files", len(pending))
+	return nil
+}
 
-	// Atomic rename
-	if err := os.Rename(tmpPath, outputName); err != nil {
-		os.Remove(tmpPath)
+// placeOutput moves the assembled file to target: an atomic rename, or a
+// copy when target is on another filesystem.
+func placeOutput(tmpPath, target string) error {
+	err := os.Rename(tmpPath, target)
+	if err == nil {
+		return nil
+	}
+	if !fsutil.IsCrossDevice(err) {
 		return fmt.Errorf("failed to rename output file: %w", err)
 	}
 
-	fmt.Printf("Merge complete: %s (%s)\n", outputName, formatBytes(totalBytes))
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+	slog.Info(fmt.Sprintf("%s is on another filesystem, copying %s (needs that much free space there)", target, formatBytes(info.Size())),
+		"output", target, "bytes", info.Size())
+
+	nextReport := int64(0)
+	err = fsutil.Move(tmpPath, target, func(copied, total int64) {
+		if copied >= nextReport || copied == total {
+			slog.Info(fmt.Sprintf("Copying output: %s/%s", formatBytes(copied), formatBytes(total)), "copied", copied, "total", total)
+			nextReport = copied + total/10
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move output file: %w", err)
+	}
+	return nil
+}
 
-	// Delete state file if requested
-	if m.config.Delete {
-		stateFile := fmt.Sprintf(".%s-args.json", outputName)
-		if err := os.Remove(stateFile); err != nil {
-			if !os.IsNotExist(err) {
-				fmt.Printf("Warning: failed to delete state file %s: %v\n", stateFile, err)
+// mergeChunks copies files in order to output, calling merged (if not nil)
+// after each one.
+func (m *Merger) mergeChunks(output io.Writer, files []string, totalBytes *int64, merged func(partPath string, n int64) error) error {
+	for i, partPath := range files {
+		before := *totalBytes
+		if err := m.mergeChunk(output, partPath, i+1, len(files), totalBytes); err != nil {
+			return err
+		}
+
+		if merged != nil {
+			if err := merged(partPath, *totalBytes-before); err != nil {
+				return err
 			}
 		}
 	}
-
 	return nil
 }
 
+// mergeDecompressed decodes the concatenation of files into output.
+// totalBytes counts the compressed bytes read.
+func (m *Merger) mergeDecompressed(output io.Writer, files []string, format string, totalBytes *int64) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(m.mergeChunks(pw, files, totalBytes, nil))
+	}()
+
+	err := decompress(output, pr, format)
+	// Unblock the writer if decoding stopped early
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
 // mergeChunk copies a single chunk file to the output
-func (m *Merger) mergeChunk(output *os.File, partPath string, current, total int, totalBytes *int64) error {
-	fmt.Printf("[%d/%d] Merging %s\n", current, total, partPath)
+func (m *Merger) mergeChunk(output io.Writer, partPath string, current, total int, totalBytes *int64) error {
+	slog.Info(fmt.Sprintf("[%d/%d] Merging %s", current, total, partPath), "part", partPath)
 
-	partFile, err := os.Open(partPath)
+	partFile, src, err := m.openChunk(partPath)
 	if err != nil {
-		return fmt.Errorf("failed to open %s: %w", partPath, err)
+		return err
 	}
 	defer partFile.Close()
 
-	n, err := io.Copy(output, partFile)
+	n, err := io.Copy(output, src)
 	if err != nil {
 		return fmt.Errorf("failed to copy %s: %w", partPath, err)
 	}
@@ -177,6 +484,111 @@ func (m *Merger) mergeChunk(output *os.File, partPath string, current, total int
 	return nil
 }
 
+// openChunk opens a chunk file and returns it with a reader of its data,
+// which decrypts an encrypted chunk.
+func (m *Merger) openChunk(partPath string) (*os.File, io.Reader, error) {
+	partFile, err := os.Open(partPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", partPath, err)
+	}
+	if !crypt.IsEncrypted(partPath) {
+		return partFile, partFile, nil
+	}
+	if m.config.Key == nil {
+		partFile.Close()
+		return nil, nil, fmt.Errorf("%s is encrypted, the passphrase is needed to merge it", partPath)
+	}
+	return partFile, m.config.Key.NewReader(partFile), nil
+}
+
+// dropPacked removes chunk files whose chunk is also inside a packed file,
+// as left behind when a download stopped while packing.
+func dropPacked(files []string) []string {
+	type span struct{ first, last int }
+	var packed []span
+	for _, f := range files {
+		if first, last, ok := chunkIndexes(f); ok && last > first {
+			packed = append(packed, span{first, last})
+		}
+	}
+	if len(packed) == 0 {
+		return files
+	}
+
+	kept := files[:0:0]
+	for _, f := range files {
+		if idx, last, ok := chunkIndexes(f); ok && last == idx {
+			covered := false
+			for _, p := range packed {
+				if idx >= p.first && idx <= p.last {
+					covered = true
+					break
+				}
+			}
+			if covered {
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// deleteChunks removes merged chunk files and their --part-meta
+// sidecars, warning on failure.
+func deleteChunks(files []string) {
+	for _, partPath := range files {
+		if err := os.Remove(partPath); err != nil {
+			slog.Warn(fmt.Sprintf("failed to delete %s: %v", partPath, err), "file", partPath, "error", err)
+		}
+		os.Remove(partPath + ".json")
+	}
+}
+
+// detectGroupFormat sniffs the compression format from the first chunk.
+func (m *Merger) detectGroupFormat(files []string, outputName string) string {
+	header := make([]byte, 8)
+	if m.config.Key != nil && crypt.IsEncrypted(files[0]) {
+		header, _ = m.config.Key.SniffHeader(files[0], len(header))
+	} else if f, err := os.Open(files[0]); err == nil {
+		n, _ := io.ReadFull(f, header)
+		header = header[:n]
+		f.Close()
+	}
+	return DetectFormat(header, outputName)
+}
+
+// checkEncryption fails early, before anything is written, when files
+// are encrypted and there's no key or the key doesn't fit.
+func (m *Merger) checkEncryption(files []string) error {
+	for _, f := range files {
+		if !crypt.IsEncrypted(f) {
+			continue
+		}
+		if m.config.Key == nil {
+			return fmt.Errorf("%s is encrypted, the passphrase is needed to merge it", f)
+		}
+		if _, err := m.config.Key.SniffHeader(f, 1); err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// chunkSize returns the size of the data in a chunk file, which for an
+// encrypted file is less than its size on disk.
+func chunkSize(path string) (int64, error) {
+	if crypt.IsEncrypted(path) {
+		return crypt.PlainSize(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 // formatBytes formats bytes in human-readable format
 func formatBytes(bytes int64) string {
 	const unit = 1000
@@ -210,8 +622,9 @@ func groupFilesByBasename(partFiles []string) map[string][]string {
 	return groups
 }
 
-// partFilePattern matches chunk files like "prefix.000000.part"
-var partFilePattern = regexp.MustCompile(`^(.+?)\.(\d+)\.part$`)
+// partFilePattern matches chunk files like "prefix.000000.part", and
+// packed chunk files like "prefix.000000-000099.part"
+var partFilePattern = regexp.MustCompile(`^(.+?)\.(\d+)(?:-(\d+))?\.part$`)
 
 // extractBasename extracts the basename from a .part file by stripping .part extension and numeric index
 // Example: "file.000000.part" -> "file"
@@ -220,7 +633,7 @@ func extractBasename(partFile string) string {
 	name := filepath.Base(partFile)
 
 	matches := partFilePattern.FindStringSubmatch(name)
-	if len(matches) == 3 {
+	if len(matches) == 4 {
 		return matches[1] // Return the captured basename
 	}
 
@@ -1,9 +1,12 @@
 package merger
 
 import (
+	"os"
 	"testing"
 
+	"github.com/redraw/rapel/internal/crypt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractBasename(t *testing.T) {
@@ -16,6 +19,7 @@ func TestExtractBasename(t *testing.T) {
 		{name: "basename with dots", input: "my.file.name.000001.part", expected: "my.file.name"},
 		{name: "large index", input: "download.123456.part", expected: "download"},
 		{name: "with path", input: "/path/to/file.000000.part", expected: "file"},
+		{name: "packed chunks", input: "file.000000-000099.part", expected: "file"},
 		{name: "invalid format - no index", input: "file.part", expected: ""},
 		{name: "invalid format - no extension", input: "file.000000", expected: ""},
 		{name: "invalid format - wrong extension", input: "file.000000.tmp", expected: ""},
@@ -94,3 +98,111 @@ func TestFormatBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestDropPacked(t *testing.T) {
+	files := []string{
+		"file.000000-000003.part",
+		"file.000002.part", // leftover from an interrupted pack
+		"file.000004.part",
+		"file.000005.part",
+	}
+	assert.Equal(t, []string{"file.000000-000003.part", "file.000004.part", "file.000005.part"}, dropPacked(files))
+
+	plain := []string{"file.000000.part", "file.000001.part"}
+	assert.Equal(t, plain, dropPacked(plain))
+}
+
+func TestMergeEncrypted(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	key, err := crypt.NewKey("secret")
+	require.NoError(t, err)
+	for name, data := range map[string]string{"f.000000.part": "aaaa", "f.000001.part": "bb"} {
+		out, err := os.Create(name)
+		require.NoError(t, err)
+		w, err := key.NewWriter(out)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		require.NoError(t, out.Close())
+	}
+	require.NoError(t, os.WriteFile(".f-args.json", []byte(`{"total_size":6,"chunk_size":4}`), 0644))
+
+	assert.ErrorContains(t, NewMerger(Config{Pattern: "f.*.part"}).Merge(), "is encrypted")
+	other, err := crypt.NewKey("other")
+	require.NoError(t, err)
+	assert.ErrorIs(t, NewMerger(Config{Pattern: "f.*.part", Key: other}).Merge(), crypt.ErrWrongKey)
+	assert.NoFileExists(t, "f.assembling")
+
+	require.NoError(t, NewMerger(Config{Pattern: "f.*.part", Key: key}).Merge())
+	data, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabb", string(data))
+}
+
+func TestMergeSavedOutputName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	require.NoError(t, os.WriteFile("f-1a2b3c4d.bin.000000.part", []byte("aaaa"), 0o644))
+	require.NoError(t, os.WriteFile("f-1a2b3c4d.bin.000001.part", []byte("bb"), 0o644))
+	require.NoError(t, os.WriteFile(".f-1a2b3c4d.bin-args.json", []byte(`{"total_size":6,"chunk_size":4,"output_name":"f.bin"}`), 0o644))
+
+	m := NewMerger(Config{Pattern: "f-1a2b3c4d.bin.*.part"})
+	require.NoError(t, m.Merge())
+	data, err := os.ReadFile("f.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabb", string(data))
+	assert.Equal(t, []string{"f.bin"}, m.Outputs())
+}
+
+func TestMergeFromDirs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for dir, parts := range map[string][]string{"a": {"000000", "000001"}, "b": {"000002"}} {
+		require.NoError(t, os.Mkdir(dir, 0o755))
+		for _, idx := range parts {
+			require.NoError(t, os.WriteFile(dir+"/f."+idx+".part", []byte("chunk"+idx[5:]), 0o644))
+		}
+	}
+	// Machine a's state, which knows the whole file is 18 bytes
+	require.NoError(t, os.WriteFile("a/.f-args.json", []byte(`{"total_size": 18, "chunk_size": 6}`), 0o644))
+
+	require.NoError(t, NewMerger(Config{Output: "f", Pattern: "f.*.part", Dirs: []string{"a", "b"}}).Merge())
+	data, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "chunk0chunk1chunk2", string(data))
+
+	// Without b's chunk the layout in a's state shows the end is missing
+	err = NewMerger(Config{Output: "f", Pattern: "f.*.part", Dirs: []string{"a"}}).Merge()
+	assert.ErrorContains(t, err, "chunk 2 of 3 is missing")
+}
+
+func TestMergeResliced(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, data := range map[string]string{"f.000000.part": "aaaa", "f.000001.part": "bb", "f.000002.part": "cc", "f.000003.part": "dddd"} {
+		require.NoError(t, os.WriteFile(name, []byte(data), 0o644))
+	}
+	// Chunks of 4, re-sliced into 2 from byte 4, and a chunk of 4 kept
+	require.NoError(t, os.WriteFile(".f-args.json", []byte(`{"total_size": 12, "chunk_size": 2, "layout": [
+		{"offset": 0, "size": 4, "chunk_size": 4}, {"offset": 4, "size": 4, "chunk_size": 2}, {"offset": 8, "size": 4, "chunk_size": 4}]}`), 0o644))
+
+	require.NoError(t, NewMerger(Config{Pattern: "f.*.part"}).Merge())
+	data, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabbccdddd", string(data))
+}
+
+func TestMergeOutputName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("sub_f.iso.000000.part", []byte("abc"), 0o644))
+	require.NoError(t, os.WriteFile("sub_f.iso.000001.part", []byte("de"), 0o644))
+	require.NoError(t, os.Mkdir("sub", 0o755))
+
+	m := NewMerger(Config{Pattern: "sub_f.iso.*.part", OutputDir: "sub", OutputName: "f.iso"})
+	require.NoError(t, m.Merge())
+	assert.Equal(t, []string{"sub/f.iso"}, m.Outputs())
+	data, err := os.ReadFile("sub/f.iso")
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(data))
+	assert.NoFileExists(t, "sub/sub_f.iso")
+}
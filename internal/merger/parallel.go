@@ -0,0 +1,164 @@
+package merger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// placedPart is a chunk file and where its data goes in the output.
+type placedPart struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// mergeAt preallocates the output (or, with Sparse, only sets its size)
+// and copies files into it at their offsets, Jobs at a time. Copies between plain files go through
+// copy_file_range where the OS has it, so the data needn't pass through
+// rapel (and some filesystems share the blocks); with Reflink the blocks
+// are cloned explicitly first. Chunks can finish out of order, but merged
+// is called in file order, so the journal only ever lists a complete
+// prefix of the output and --delete never removes an unjournaled chunk.
+func (m *Merger) mergeAt(asm *assembly, files []string, totalBytes *int64, merged func(partPath string, n int64) error) error {
+	parts := make([]placedPart, len(files))
+	offset := asm.state.Bytes
+	for i, partPath := range files {
+		size, err := chunkSize(partPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", partPath, err)
+		}
+		parts[i] = placedPart{path: partPath, offset: offset, size: size}
+		offset += size
+	}
+	if m.config.Sparse {
+		// Unwritten ranges stay holes
+		if err := asm.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to extend output file: %w", err)
+		}
+	} else if err := fsutil.Preallocate(asm.file, offset); err != nil {
+		return fmt.Errorf("failed to allocate %s for the output: %w", formatBytes(offset), err)
+	}
+
+	jobs := m.config.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(parts) {
+		jobs = len(parts)
+	}
+	slog.Debug(fmt.Sprintf("Copying %d chunk files with %d jobs", len(parts), jobs), "files", len(parts), "jobs", jobs)
+
+	type result struct {
+		index int
+		err   error
+	}
+	next := make(chan int)
+	results := make(chan result)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := os.OpenFile(asm.path, os.O_WRONLY, 0)
+			if err != nil {
+				err = fmt.Errorf("failed to open output file: %w", err)
+			}
+			defer func() {
+				if output != nil {
+					output.Close()
+				}
+			}()
+			for i := range next {
+				if err == nil {
+					slog.Info(fmt.Sprintf("[%d/%d] Merging %s", i+1, len(parts), parts[i].path), "part", parts[i].path)
+					err = m.copyAt(output, parts[i])
+				}
+				results <- result{i, err}
+			}
+		}()
+	}
+	go func() {
+		defer close(next)
+		for i := range parts {
+			select {
+			case next <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Journal finished chunks in order; the first failure stops the
+	// workers taking more
+	done := make([]bool, len(parts))
+	journaled := 0
+	var firstErr error
+	for r := range results {
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+				close(stop)
+			}
+			continue
+		}
+		done[r.index] = true
+		for firstErr == nil && journaled < len(parts) && done[journaled] {
+			*totalBytes += parts[journaled].size
+			if err := merged(parts[journaled].path, parts[journaled].size); err != nil {
+				firstErr = err
+				close(stop)
+				break
+			}
+			journaled++
+		}
+	}
+	return firstErr
+}
+
+// copyAt writes part's data into output at its offset: cloned if asked
+// and possible, else copied.
+func (m *Merger) copyAt(output *os.File, part placedPart) error {
+	partFile, src, err := m.openChunk(part.path)
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+
+	if m.config.Reflink && !crypt.IsEncrypted(part.path) {
+		err := fsutil.CloneRange(output, part.offset, partFile, 0, part.size)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fsutil.ErrCloneUnsupported) {
+			return fmt.Errorf("failed to clone %s: %w", part.path, err)
+		}
+		slog.Debug(fmt.Sprintf("Copying %s: %v", part.path, err), "part", part.path)
+	}
+
+	if m.config.Sparse {
+		return m.copySparse(output, part, src)
+	}
+	if _, err := output.Seek(part.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek output file: %w", err)
+	}
+	n, err := io.Copy(output, src)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", part.path, err)
+	}
+	if n != part.size {
+		return fmt.Errorf("failed to copy %s: expected %d bytes, got %d (changed while merging?)", part.path, part.size, n)
+	}
+	return nil
+}
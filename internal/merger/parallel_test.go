@@ -0,0 +1,80 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeParallel(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	var want strings.Builder
+	for i := 0; i < 20; i++ {
+		data := strings.Repeat(string(rune('a'+i)), 5000)
+		if i == 19 {
+			data = data[:123]
+		}
+		want.WriteString(data)
+		require.NoError(t, os.WriteFile(fmt.Sprintf("f.%06d.part", i), []byte(data), 0644))
+	}
+
+	m := NewMerger(Config{Pattern: "f.*.part", Jobs: 4, Reflink: true, Delete: true})
+	require.NoError(t, m.Merge())
+
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, want.String(), string(out))
+	assert.NoFileExists(t, "f.000000.part")
+	assert.NoFileExists(t, "f.assembling.json")
+}
+
+func TestMergeParallelResumes(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile("f.000001.part", []byte("bbbb"), 0644))
+	require.NoError(t, os.WriteFile("f.000002.part", []byte("cc"), 0644))
+
+	// A parallel merge killed with the first chunk journaled and later
+	// chunks partly written past it
+	asm, err := openAssembly("f.assembling")
+	require.NoError(t, err)
+	_, err = asm.file.WriteString("aaaa????cc")
+	require.NoError(t, err)
+	require.NoError(t, asm.record("f.000000.part", 4))
+	require.NoError(t, asm.file.Close())
+
+	require.NoError(t, NewMerger(Config{Pattern: "f.*.part", Jobs: 3}).Merge())
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabbbbcc", string(out))
+}
+
+func TestMergeParallelEncrypted(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	key, err := crypt.NewKey("secret")
+	require.NoError(t, err)
+	for name, data := range map[string]string{"f.000000.part": "aaaa", "f.000001.part": "bbbb", "f.000002.part": "c"} {
+		out, err := os.Create(name)
+		require.NoError(t, err)
+		w, err := key.NewWriter(out)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		require.NoError(t, out.Close())
+	}
+
+	// Offsets come from the plain sizes, and encrypted chunks are never cloned
+	require.NoError(t, NewMerger(Config{Pattern: "f.*.part", Key: key, Jobs: 2, Reflink: true}).Merge())
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaabbbbc", string(out))
+}
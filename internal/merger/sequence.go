@@ -0,0 +1,242 @@
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// chunkFile is a chunk file and its size.
+type chunkFile struct {
+	path string
+	size int64
+}
+
+// checkGroup refuses to merge a group that would produce a corrupt file:
+// chunk files failing checkSequence, or fewer bytes than the download's
+// args file says the file has. merged lists chunks an interrupted merge
+// already copied, which --delete may have removed. With AllowGaps the
+// problem is only logged.
+func (m *Merger) checkGroup(outputName string, files []string, merged []assembledPart) error {
+	// Chunks gathered from several machines each come with their
+	// machine's args file; any one of them describes the whole file
+	argsFile := registry.StatePath(outputName, "args.json")
+	seen := make(map[string]bool)
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		path := registry.StatePath(filepath.Join(dir, outputName), "args.json")
+		if _, err := os.Stat(path); err == nil {
+			argsFile = path
+			break
+		}
+	}
+
+	err := checkFiles(files, merged, argsFile)
+	if err == nil {
+		return nil
+	}
+	if m.config.AllowGaps {
+		slog.Warn(fmt.Sprintf("%s: %v, merging anyway (--allow-gaps)", outputName, err), "output", outputName, "error", err)
+		return nil
+	}
+	return fmt.Errorf("refusing to merge %s: %w (use --allow-gaps to merge anyway)", outputName, err)
+}
+
+// checkFiles runs checkSequence over files plus already merged chunks
+// that no longer exist, then compares them with the layout in argsFile
+// if there is one. The args file alone doesn't mean the download is
+// unfinished: it is kept by split, and by a download killed after its
+// last chunk.
+func checkFiles(files []string, merged []assembledPart, argsFile string) error {
+	var chunks []chunkFile
+	present := make(map[string]bool, len(files))
+	for _, f := range files {
+		size, err := chunkSize(f)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, chunkFile{path: f, size: size})
+		present[f] = true
+	}
+	for _, part := range merged {
+		if !present[part.Path] {
+			chunks = append(chunks, chunkFile{path: part.Path, size: part.Size})
+		}
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunkLess(chunks[i].path, chunks[j].path)
+	})
+
+	var layout argsLayout
+	data, err := os.ReadFile(argsFile)
+	if err != nil || json.Unmarshal(data, &layout) != nil || layout.TotalSize <= 0 || layout.ChunkSize <= 0 {
+		return checkSequence(chunks, nil)
+	}
+	sizes := layout.chunkSizes()
+	if err := checkSequence(chunks, sizes); err != nil {
+		return err
+	}
+
+	numChunks := int((layout.TotalSize + layout.ChunkSize - 1) / layout.ChunkSize)
+	if sizes != nil {
+		numChunks = len(sizes)
+	}
+	next := 0
+	var total int64
+	for _, c := range chunks {
+		_, last, _ := chunkIndexes(c.path)
+		next = last + 1
+		total += c.size
+	}
+	if next < numChunks {
+		return fmt.Errorf("download is not finished: chunk %d of %d is missing (%s)", next, numChunks, argsFile)
+	}
+	if total != layout.TotalSize {
+		return failure.Errorf(failure.ErrSizeMismatch, "chunks hold %d bytes, %s expects %d", total, argsFile, layout.TotalSize)
+	}
+	return nil
+}
+
+// argsLayout is what checkFiles reads of a download's args file.
+type argsLayout struct {
+	TotalSize int64 `json:"total_size"`
+	ChunkSize int64 `json:"chunk_size"`
+	Layout    []struct {
+		Offset    int64 `json:"offset"`
+		Size      int64 `json:"size"`
+		ChunkSize int64 `json:"chunk_size"`
+	} `json:"layout"`
+}
+
+// savedOutputName returns the merged file's name saved in the args file
+// of the download whose chunks are named outputName, or "" when it is
+// outputName itself: downloads name their chunks and state after the URL's
+// hash too, latest-1a2b3c4d.tar.gz for latest.tar.gz.
+func savedOutputName(outputName string) string {
+	var args struct {
+		OutputName string `json:"output_name"`
+	}
+	data, err := os.ReadFile(registry.StatePath(outputName, "args.json"))
+	if err != nil || json.Unmarshal(data, &args) != nil {
+		return ""
+	}
+	return args.OutputName
+}
+
+// chunkSizes returns the size of every chunk of a layout re-sliced by a
+// resume with another -c, or nil if the chunks all have ChunkSize bytes.
+func (l argsLayout) chunkSizes() []int64 {
+	var sizes []int64
+	for _, seg := range l.Layout {
+		if seg.ChunkSize <= 0 {
+			return nil
+		}
+		for left := seg.Size; left > 0; left -= seg.ChunkSize {
+			sizes = append(sizes, min(left, seg.ChunkSize))
+		}
+	}
+	return sizes
+}
+
+// checkSequence verifies that numerically sorted chunk files (individual
+// or packed) cover chunk indexes 0..N exactly once, and that every file
+// but the last holds whole chunks: a short chunk in the middle means a
+// truncated part. sizes, when known, gives each chunk's size; else the
+// chunks are taken to have the first file's.
+func checkSequence(chunks []chunkFile, sizes []int64) error {
+	next := 0
+	var chunkSize int64
+	for i, c := range chunks {
+		first, last, ok := chunkIndexes(c.path)
+		if !ok {
+			return fmt.Errorf("%s is not a chunk file", c.path)
+		}
+
+		switch {
+		case first > next:
+			return fmt.Errorf("chunk %d is missing", next)
+		case first < next:
+			return fmt.Errorf("chunk %d appears more than once", first)
+		}
+		next = last + 1
+
+		if last < len(sizes) {
+			var want int64
+			for _, size := range sizes[first : last+1] {
+				want += size
+			}
+			if c.size != want {
+				return fmt.Errorf("%s is %d bytes, expected %d", c.path, c.size, want)
+			}
+			continue
+		}
+		if i == len(chunks)-1 {
+			break
+		}
+		n := int64(last - first + 1)
+		if chunkSize == 0 {
+			chunkSize = c.size / n
+		}
+		if c.size != chunkSize*n {
+			return fmt.Errorf("%s is %d bytes, expected %d", c.path, c.size, chunkSize*n)
+		}
+	}
+	return nil
+}
+
+// chunkIndexes returns the first and last chunk index held by a chunk
+// file: equal for an individual part, a range for a packed one.
+func chunkIndexes(file string) (first, last int, ok bool) {
+	match := partFilePattern.FindStringSubmatch(filepath.Base(file))
+	if match == nil {
+		return 0, 0, false
+	}
+	first, err := strconv.Atoi(match[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	last = first
+	if match[3] != "" {
+		if last, err = strconv.Atoi(match[3]); err != nil || last < first {
+			return 0, 0, false
+		}
+	}
+	return first, last, true
+}
+
+// sortChunkFiles sorts chunk files by index rather than by name, so
+// "f.10.part" follows "f.9.part" whatever the zero padding. Files that
+// aren't chunk files go last, by name.
+func sortChunkFiles(files []string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return chunkLess(files[i], files[j])
+	})
+}
+
+// chunkLess orders chunk files by first index, then last index, then name.
+func chunkLess(a, b string) bool {
+	fa, la, okA := chunkIndexes(a)
+	fb, lb, okB := chunkIndexes(b)
+	switch {
+	case okA != okB:
+		return okA
+	case !okA || fa == fb && la == lb:
+		return a < b
+	case fa != fb:
+		return fa < fb
+	default:
+		return la < lb
+	}
+}
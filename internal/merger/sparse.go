@@ -0,0 +1,122 @@
+package merger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/redraw/rapel/internal/fsutil"
+)
+
+// sparseBlock is the unit in which zeros are left as holes. Filesystems
+// allocate whole blocks, so only aligned blocks of zeros can be skipped.
+const sparseBlock = 4096
+
+// sparseBuffer is how much is read at a time when looking for zeros.
+const sparseBuffer = 1 << 20
+
+var zeroBlock = make([]byte, sparseBlock)
+
+// zeroRuns splits buf, which belongs at offset base of the output, into
+// alternating runs of data and of whole aligned blocks of zeros.
+func zeroRuns(buf []byte, base int64, fn func(off, n int64, zero bool) error) error {
+	runStart, runZero := int64(0), false
+	for pos := int64(0); pos < int64(len(buf)); {
+		end := (base+pos)/sparseBlock*sparseBlock + sparseBlock - base
+		if end > int64(len(buf)) {
+			end = int64(len(buf))
+		}
+		block := buf[pos:end]
+		zero := len(block) == sparseBlock && bytes.Equal(block, zeroBlock)
+		if pos > 0 && zero != runZero {
+			if err := fn(base+runStart, pos-runStart, runZero); err != nil {
+				return err
+			}
+			runStart = pos
+		}
+		runZero = zero
+		pos = end
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	return fn(base+runStart, int64(len(buf))-runStart, runZero)
+}
+
+// copySparse copies part into output at its offset, skipping aligned
+// blocks of zeros so they stay holes in the freshly extended output.
+func (m *Merger) copySparse(output *os.File, part placedPart, src io.Reader) error {
+	buf := make([]byte, sparseBuffer)
+	pos := part.offset
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if pos+int64(n) > part.offset+part.size {
+				return fmt.Errorf("failed to copy %s: more than the expected %d bytes (changed while merging?)", part.path, part.size)
+			}
+			werr := zeroRuns(buf[:n], pos, func(off, n int64, zero bool) error {
+				if zero {
+					m.holes.Add(n)
+					return nil
+				}
+				_, err := output.WriteAt(buf[off-pos:off-pos+n], off)
+				return err
+			})
+			if werr != nil {
+				return fmt.Errorf("failed to copy %s: %w", part.path, werr)
+			}
+			pos += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", part.path, err)
+		}
+	}
+	if got := pos - part.offset; got != part.size {
+		return fmt.Errorf("failed to copy %s: expected %d bytes, got %d (changed while merging?)", part.path, part.size, got)
+	}
+	return nil
+}
+
+// punchZeros turns aligned blocks of zeros in n bytes of f from off into
+// holes, for data that was moved into the output rather than copied.
+func (m *Merger) punchZeros(f *os.File, off, n int64) {
+	buf := make([]byte, sparseBuffer)
+	for pos := off; pos < off+n; {
+		want := int64(len(buf))
+		if want > off+n-pos {
+			want = off + n - pos
+		}
+		read, err := f.ReadAt(buf[:want], pos)
+		if read == 0 {
+			if err != nil {
+				slog.Debug(fmt.Sprintf("Not punching holes: %v", err))
+			}
+			return
+		}
+		err = zeroRuns(buf[:read], pos, func(off, n int64, zero bool) error {
+			if !zero {
+				return nil
+			}
+			if err := fsutil.PunchHole(f, off, n); err != nil {
+				return err
+			}
+			m.holes.Add(n)
+			return nil
+		})
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Debug("Not punching holes: the filesystem doesn't support it")
+			return
+		}
+		if err != nil {
+			slog.Warn(fmt.Sprintf("failed to punch holes: %v", err))
+			return
+		}
+		pos += int64(read)
+	}
+}
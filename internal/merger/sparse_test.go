@@ -0,0 +1,64 @@
+package merger
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroRuns(t *testing.T) {
+	buf := make([]byte, 3*sparseBlock)
+	buf[10] = 1 // the first block has data
+
+	type run struct {
+		off, n int64
+		zero   bool
+	}
+	var runs []run
+	collect := func(off, n int64, zero bool) error {
+		runs = append(runs, run{off, n, zero})
+		return nil
+	}
+
+	require.NoError(t, zeroRuns(buf, 0, collect))
+	assert.Equal(t, []run{{0, sparseBlock, false}, {sparseBlock, 2 * sparseBlock, true}}, runs)
+
+	// Blocks are aligned to the output, so partial blocks at either end
+	// count as data even when they are zeros
+	runs = nil
+	require.NoError(t, zeroRuns(buf[20:], 100, collect))
+	assert.Equal(t, []run{
+		{100, sparseBlock - 100, false},
+		{sparseBlock, 2 * sparseBlock, true},
+		{3 * sparseBlock, 80, false},
+	}, runs)
+}
+
+func TestMergeSparse(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	data := make([]byte, 4*sparseBlock)
+	copy(data, "header")
+	copy(data[3*sparseBlock:], "trailer")
+	chunks := [][]byte{data[:2*sparseBlock], data[2*sparseBlock:]}
+	require.NoError(t, os.WriteFile("f.000000.part", chunks[0], 0644))
+	require.NoError(t, os.WriteFile("f.000001.part", chunks[1], 0644))
+	first, err := os.Stat("f.000000.part")
+	require.NoError(t, err)
+
+	m := NewMerger(Config{Pattern: "f.*.part", Sparse: true, Delete: true})
+	require.NoError(t, m.Merge())
+
+	out, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, out))
+	assert.NoFileExists(t, "f.000000.part")
+
+	// The first chunk was renamed into place, not copied
+	info, err := os.Stat("f")
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(first, info))
+}
@@ -0,0 +1,69 @@
+package merger
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// stream writes a single group to m.config.Writer. Bytes handed to a pipe
+// can't be taken back, so the group is checked before the first one is
+// written: the download must be finished, chunk indexes must run from 0
+// with no gaps or duplicates, and every chunk but the last must be full.
+func (m *Merger) stream(basenameGroups map[string][]string) error {
+	outputName := m.config.Output
+	if outputName == "" {
+		if len(basenameGroups) != 1 {
+			names := make([]string, 0, len(basenameGroups))
+			for name := range basenameGroups {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("found %d download sessions %v, choose one with -o or --pattern", len(names), names)
+		}
+		for name := range basenameGroups {
+			outputName = name
+		}
+	}
+
+	files, ok := basenameGroups[outputName]
+	if !ok {
+		return fmt.Errorf("no chunk files for %s", outputName)
+	}
+	sortChunkFiles(files)
+	files = dropPacked(files)
+
+	if err := m.checkGroup(outputName, files, nil); err != nil {
+		return err
+	}
+	if err := m.checkEncryption(files); err != nil {
+		return err
+	}
+
+	format := ""
+	if m.config.Decompress {
+		format = m.detectGroupFormat(files, outputName)
+		if format == "" {
+			slog.Warn(fmt.Sprintf("%s doesn't look compressed, streaming as is", outputName), "output", outputName)
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Streaming %d chunk files of %s", len(files), outputName), "files", len(files), "output", outputName)
+
+	var totalBytes int64
+	var err error
+	if format != "" {
+		err = m.mergeDecompressed(m.config.Writer, files, format, &totalBytes)
+	} else {
+		err = m.mergeChunks(m.config.Writer, files, &totalBytes, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.config.Delete {
+		deleteChunks(files)
+	}
+	slog.Info(fmt.Sprintf("Stream complete: %s (%s)", outputName, formatBytes(totalBytes)), "output", outputName, "bytes", totalBytes)
+	return nil
+}
@@ -0,0 +1,87 @@
+package merger
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000-000001.part", []byte("aaaabbbb"), 0644))
+	require.NoError(t, os.WriteFile("f.000002.part", []byte("cccc"), 0644))
+	require.NoError(t, os.WriteFile("f.000003.part", []byte("dd"), 0644))
+
+	var out bytes.Buffer
+	m := NewMerger(Config{Pattern: "f.*.part", Writer: &out})
+	require.NoError(t, m.Merge())
+	assert.Equal(t, "aaaabbbbccccdd", out.String())
+	assert.Empty(t, m.Outputs())
+	assert.NoFileExists(t, "f.assembling")
+
+	// An unfinished download streams nothing
+	require.NoError(t, os.WriteFile(".f-args.json", []byte(`{"total_size":20,"chunk_size":4}`), 0644))
+	out.Reset()
+	assert.ErrorContains(t, m.Merge(), "chunk 4 of 5 is missing")
+	assert.Zero(t, out.Len())
+
+	// A complete layout, as left by split, streams
+	require.NoError(t, os.WriteFile(".f-args.json", []byte(`{"total_size":14,"chunk_size":4}`), 0644))
+	require.NoError(t, m.Merge())
+	assert.Equal(t, "aaaabbbbccccdd", out.String())
+}
+
+func TestCheckSequence(t *testing.T) {
+	chunks := func(pairs ...any) []chunkFile {
+		var out []chunkFile
+		for i := 0; i < len(pairs); i += 2 {
+			out = append(out, chunkFile{path: pairs[i].(string), size: int64(pairs[i+1].(int))})
+		}
+		return out
+	}
+
+	assert.NoError(t, checkSequence(chunks("f.000000.part", 4, "f.000001.part", 2), nil))
+	assert.NoError(t, checkSequence(chunks("f.000000-000001.part", 8, "f.000002.part", 4, "f.000003.part", 1), nil))
+	assert.ErrorContains(t, checkSequence(chunks("f.000000.part", 4, "f.000001.part", 2, "f.000002-000003.part", 8), nil), "f.000001.part is 2 bytes")
+	assert.ErrorContains(t, checkSequence(chunks("f.000000.part", 4, "f.000002-000003.part", 8), nil), "chunk 1 is missing")
+	assert.ErrorContains(t, checkSequence(chunks("f.000004.part", 4, "f.000005.part", 2), nil), "chunk 0 is missing")
+	assert.ErrorContains(t, checkSequence(chunks("f.000000.part", 4, "f.000000.part", 4), nil), "more than once")
+	// The same index with different zero padding
+	assert.ErrorContains(t, checkSequence(chunks("f.0.part", 4, "f.000000.part", 4), nil), "chunk 0 appears more than once")
+	assert.ErrorContains(t, checkSequence(chunks("f.000000.part", 4, "f.part", 4), nil), "not a chunk file")
+
+	// A re-sliced layout: chunks of 4, then of 2
+	sizes := []int64{4, 4, 2, 2, 1}
+	resliced := chunks("f.000000-000001.part", 8, "f.000002.part", 2, "f.000003.part", 2, "f.000004.part", 1)
+	assert.NoError(t, checkSequence(resliced, sizes))
+	assert.ErrorContains(t, checkSequence(resliced, nil), "f.000002.part is 2 bytes, expected 4")
+	assert.ErrorContains(t, checkSequence(chunks("f.000000.part", 4, "f.000001.part", 2), sizes), "f.000001.part is 2 bytes, expected 4")
+}
+
+func TestSortChunkFiles(t *testing.T) {
+	files := []string{"f.10.part", "f.000002-000003.part", "f.9.part", "f.1.part", "f.0.part", "f.000004.part", "f.5.part", "f.6.part", "f.7.part", "f.8.part"}
+	sortChunkFiles(files)
+	assert.Equal(t, []string{"f.0.part", "f.1.part", "f.000002-000003.part", "f.000004.part", "f.5.part", "f.6.part", "f.7.part", "f.8.part", "f.9.part", "f.10.part"}, files)
+}
+
+func TestMergeRefusesGaps(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	require.NoError(t, os.WriteFile("f.000000.part", []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile("f.000002.part", []byte("cc"), 0644))
+
+	m := NewMerger(Config{Pattern: "f.*.part"})
+	assert.ErrorContains(t, m.Merge(), "chunk 1 is missing")
+	assert.NoFileExists(t, "f")
+	assert.NoFileExists(t, "f.assembling")
+
+	m = NewMerger(Config{Pattern: "f.*.part", AllowGaps: true})
+	require.NoError(t, m.Merge())
+	data, err := os.ReadFile("f")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaacc", string(data))
+}
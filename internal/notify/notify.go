@@ -0,0 +1,129 @@
+// Package notify reports download outcomes to a webhook (JSON POST) and to
+// the desktop, for unattended downloads.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// Event names.
+const (
+	EventStart     = "start"
+	EventComplete  = "complete"
+	EventError     = "error"
+	EventCancelled = "cancelled"
+)
+
+// postTimeout bounds a webhook delivery.
+const postTimeout = 10 * time.Second
+
+// Event is the JSON body POSTed to --notify-url.
+type Event struct {
+	Event           string    `json:"event"`
+	URL             string    `json:"url"` // redacted
+	File            string    `json:"file"`
+	Output          string    `json:"output,omitempty"` // merged file, with --merge
+	Size            int64     `json:"size,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	DownloadSeconds float64   `json:"download_seconds,omitempty"` // of which downloading
+	MergeSeconds    float64   `json:"merge_seconds,omitempty"`    // of which merging, with --merge
+	SHA256          string    `json:"sha256,omitempty"`           // of the merged file
+	Error           string    `json:"error,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// Notifier delivers events. The zero value delivers nothing.
+type Notifier struct {
+	URL     string // webhook URL; "" disables
+	Desktop bool   // desktop notification on complete and error
+}
+
+// Enabled reports whether any delivery is configured.
+func (n *Notifier) Enabled() bool {
+	return n.URL != "" || n.Desktop
+}
+
+// Notify delivers ev. Failures are logged, never returned: a broken
+// webhook must not fail a finished download.
+func (n *Notifier) Notify(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	if n.URL != "" {
+		if err := post(n.URL, ev); err != nil {
+			slog.Warn(fmt.Sprintf("notification failed: %v", err), "event", ev.Event)
+		}
+	}
+
+	if n.Desktop && ev.Event != EventStart {
+		desktop(ev)
+	}
+}
+
+// post sends ev as JSON to url.
+func post(url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid notify URL: %w", redact.Error(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return redact.Error(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// desktop shows a desktop notification, falling back to the terminal bell
+// when no notifier is available.
+func desktop(ev Event) {
+	title := "rapel: download " + ev.Event
+	msg := ev.File
+	if ev.Error != "" {
+		msg += ": " + ev.Error
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(msg), strconv.Quote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+	default:
+		if path, err := exec.LookPath("notify-send"); err == nil {
+			cmd = exec.Command(path, title, msg)
+		}
+	}
+
+	if cmd != nil && cmd.Run() == nil {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\a")
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyPostsJSON(t *testing.T) {
+	got := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var ev Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		got <- ev
+	}))
+	defer srv.Close()
+
+	n := &Notifier{URL: srv.URL}
+	n.Notify(Event{Event: EventComplete, File: "file.bin", Size: 42, SHA256: "abc"})
+
+	ev := <-got
+	assert.Equal(t, EventComplete, ev.Event)
+	assert.Equal(t, "file.bin", ev.File)
+	assert.Equal(t, int64(42), ev.Size)
+	assert.Equal(t, "abc", ev.SHA256)
+	assert.False(t, ev.Time.IsZero())
+}
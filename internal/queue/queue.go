@@ -0,0 +1,256 @@
+// Package queue keeps a persistent list of downloads waiting to run, for
+// rapel queue. Jobs are rapel download command lines, taken in order of
+// priority, then of deadline, and then by weighted fairness, and run by a
+// Runner a few at a time. A job's weight is its share of the time spent running and of the
+// rate limit, next to the other jobs.
+//
+// The queue is one JSON file in <data dir>/queue, rewritten atomically
+// under a lock, so jobs can be added or removed while it is being run.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// Job statuses.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Job is a download waiting in the queue, running or finished.
+type Job struct {
+	ID         int       `json:"id"`
+	Priority   int       `json:"priority"`          // higher runs first
+	Weight     int       `json:"weight,omitempty"`  // share next to other jobs; 0 counts as 1
+	Deadline   time.Time `json:"deadline,omitzero"` // the download's --deadline, if any
+	Args       []string  `json:"args"`              // rapel download arguments, the URL last
+	Dir        string    `json:"dir"`               // where it runs
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"` // as in rapel's JSON errors, when failed
+	AddedAt    time.Time `json:"added_at"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	RanSeconds float64   `json:"ran_seconds,omitempty"` // over every run, interrupted ones included
+}
+
+// URL returns the job's URL, its last argument.
+func (j *Job) URL() string {
+	return j.Args[len(j.Args)-1]
+}
+
+// Host returns the host of the job's URL, or "" if it has none.
+func (j *Job) Host() string {
+	u, err := url.Parse(j.URL())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// weight returns the job's weight, at least 1.
+func (j *Job) weight() int {
+	return max(j.Weight, 1)
+}
+
+// service is the running time the job has had for its weight. The
+// pending job with the least runs first, so a heavier job gets
+// proportionally more of the runner's time.
+func (j *Job) service() float64 {
+	return j.RanSeconds / float64(j.weight())
+}
+
+// Queue is the content of the queue file.
+type Queue struct {
+	NextID int    `json:"next_id"`
+	Jobs   []*Job `json:"jobs"`
+}
+
+// Dir returns the queue's directory, DataDir()/queue.
+func Dir() (string, error) {
+	dataDir, err := registry.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "queue"), nil
+}
+
+// LogPath returns the file job id's output goes to.
+func LogPath(dir string, id int) string {
+	return filepath.Join(dir, "logs", fmt.Sprintf("%d.log", id))
+}
+
+func queuePath(dir string) string {
+	return filepath.Join(dir, "queue.json")
+}
+
+// lockWait bounds how long Update waits for another process to finish
+// changing the queue.
+const lockWait = 10 * time.Second
+
+// Load reads the queue in dir. A queue never written is empty.
+func Load(dir string) (*Queue, error) {
+	q := &Queue{NextID: 1}
+	data, err := os.ReadFile(queuePath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+	if err := json.Unmarshal(data, q); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", queuePath(dir), err)
+	}
+	return q, nil
+}
+
+// Update loads the queue in dir, lets fn change it and saves it, holding
+// the queue's lock throughout. Nothing is saved if fn fails.
+func Update(dir string, fn func(q *Queue) error) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	var lock *registry.Lock
+	deadline := time.Now().Add(lockWait)
+	for {
+		var err error
+		lock, err = registry.Acquire(filepath.Join(dir, ".queue.lock"))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, registry.ErrLocked) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to lock the queue: %w", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer lock.Release()
+
+	q, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	if err := fn(q); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(queuePath(dir), data, 0600); err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	return nil
+}
+
+// Add appends a pending job running args in dir, and returns it.
+func (q *Queue) Add(args []string, dir string, priority int) *Job {
+	if q.NextID < 1 {
+		q.NextID = 1
+	}
+	job := &Job{
+		ID:       q.NextID,
+		Priority: priority,
+		Args:     args,
+		Dir:      dir,
+		Status:   StatusPending,
+		AddedAt:  time.Now(),
+	}
+	q.NextID++
+	q.Jobs = append(q.Jobs, job)
+	return job
+}
+
+// Get returns the job with the given id, or nil.
+func (q *Queue) Get(id int) *Job {
+	i := slices.IndexFunc(q.Jobs, func(j *Job) bool { return j.ID == id })
+	if i < 0 {
+		return nil
+	}
+	return q.Jobs[i]
+}
+
+// Remove drops the job with the given id. A running job can't be removed.
+func (q *Queue) Remove(id int) error {
+	job := q.Get(id)
+	switch {
+	case job == nil:
+		return fmt.Errorf("no job %d in the queue", id)
+	case job.Status == StatusRunning:
+		return fmt.Errorf("job %d is running; stop rapel queue start first", id)
+	}
+	q.Jobs = slices.DeleteFunc(q.Jobs, func(j *Job) bool { return j.ID == id })
+	return nil
+}
+
+// Next returns the pending job to run next: the highest priority, of
+// those the one with the earliest deadline (jobs without one last), then
+// the one that has run least for its weight, and then the first added. It
+// returns nil when no job is pending.
+func (q *Queue) Next() *Job {
+	return q.NextWhere(nil)
+}
+
+// NextWhere is Next among the pending jobs allow accepts; a nil allow
+// accepts them all.
+func (q *Queue) NextWhere(allow func(j *Job) bool) *Job {
+	var next *Job
+	for _, j := range q.Jobs {
+		if j.Status != StatusPending || allow != nil && !allow(j) {
+			continue
+		}
+		if next == nil || runsBefore(j, next) {
+			next = j
+		}
+	}
+	return next
+}
+
+// runsBefore reports whether pending job a is taken before b.
+func runsBefore(a, b *Job) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if !a.Deadline.Equal(b.Deadline) {
+		return !a.Deadline.IsZero() && (b.Deadline.IsZero() || a.Deadline.Before(b.Deadline))
+	}
+	if a.service() != b.service() {
+		return a.service() < b.service()
+	}
+	return a.ID < b.ID
+}
+
+// Sorted returns the jobs in the order they run: running ones first,
+// then pending ones as Next takes them, then finished ones.
+func (q *Queue) Sorted() []*Job {
+	rank := map[string]int{StatusRunning: 0, StatusPending: 1, StatusFailed: 2, StatusDone: 2}
+	jobs := slices.Clone(q.Jobs)
+	slices.SortStableFunc(jobs, func(a, b *Job) int {
+		if rank[a.Status] != rank[b.Status] {
+			return rank[a.Status] - rank[b.Status]
+		}
+		if a.Status == StatusPending && runsBefore(a, b) {
+			return -1
+		}
+		if a.Status == StatusPending {
+			return 1
+		}
+		return a.ID - b.ID
+	})
+	return jobs
+}
@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/control"
+	"github.com/redraw/rapel/internal/registry"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueOrder(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Update(dir, func(q *Queue) error {
+		q.Add([]string{"https://x/a"}, dir, 0)
+		q.Add([]string{"https://x/b"}, dir, 5)
+		q.Add([]string{"-j", "8", "https://x/c"}, dir, 5)
+		q.Add([]string{"https://x/d"}, dir, 0)
+		return nil
+	}))
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, q.Jobs, 4)
+	assert.Equal(t, "https://x/c", q.Get(3).URL())
+
+	var order []int
+	for job := q.Next(); job != nil; job = q.Next() {
+		order = append(order, job.ID)
+		job.Status = StatusDone
+	}
+	assert.Equal(t, []int{2, 3, 1, 4}, order)
+
+	q.Get(1).Status = StatusPending
+	q.Get(4).Status = StatusRunning
+	var sorted []int
+	for _, j := range q.Sorted() {
+		sorted = append(sorted, j.ID)
+	}
+	assert.Equal(t, []int{4, 1, 2, 3}, sorted)
+
+	assert.ErrorContains(t, q.Remove(4), "running")
+	assert.ErrorContains(t, q.Remove(9), "no job 9")
+	require.NoError(t, q.Remove(1))
+	assert.Nil(t, q.Get(1))
+	assert.Equal(t, 5, q.Add([]string{"https://x/e"}, dir, 0).ID)
+}
+
+func TestQueueWeightedOrder(t *testing.T) {
+	q := &Queue{}
+	heavy := q.Add([]string{"https://x/mirror"}, "", 0)
+	heavy.Weight, heavy.RanSeconds = 4, 3600
+	light := q.Add([]string{"https://x/a"}, "", 0)
+	light.RanSeconds = 1200
+	fresh := q.Add([]string{"https://x/b"}, "", 0)
+	urgent := q.Add([]string{"https://x/c"}, "", 1)
+	urgent.RanSeconds = 7200
+
+	var order []int
+	for _, j := range q.Sorted() {
+		order = append(order, j.ID)
+	}
+	// Priority first, then the least running time for the weight: 0s, 900s, 1200s
+	assert.Equal(t, []int{urgent.ID, fresh.ID, heavy.ID, light.ID}, order)
+	assert.Equal(t, urgent, q.Next())
+}
+
+func TestQueueDeadlineOrder(t *testing.T) {
+	now := time.Now()
+	q := &Queue{}
+	none := q.Add([]string{"https://x/a"}, "", 0)
+	late := q.Add([]string{"https://x/b"}, "", 0)
+	late.Deadline = now.Add(6 * time.Hour)
+	soon := q.Add([]string{"https://x/c"}, "", 0)
+	soon.Deadline = now.Add(time.Hour)
+	soon.RanSeconds = 3600
+	urgent := q.Add([]string{"https://x/d"}, "", 1)
+
+	var order []int
+	for job := q.Next(); job != nil; job = q.Next() {
+		order = append(order, job.ID)
+		job.Status = StatusDone
+	}
+	// Priority, then the earliest deadline whatever the running time, then the rest
+	assert.Equal(t, []int{urgent.ID, soon.ID, late.ID, none.ID}, order)
+}
+
+func TestRunnerShares(t *testing.T) {
+	r := &Runner{LimitRate: 1000}
+	active := map[int]*running{
+		1: {job: &Job{ID: 1, Args: []string{"https://x/a"}}},
+		2: {job: &Job{ID: 2, Weight: 3, Args: []string{"https://x/b"}}},
+		3: {job: &Job{ID: 3, Weight: 5, Args: []string{"--limit-rate=1M", "https://x/c"}}},
+	}
+	assert.Equal(t, map[int]int64{1: 250, 2: 750}, r.shares(active))
+
+	delete(active, 2)
+	assert.Equal(t, map[int]int64{1: 1000}, r.shares(active))
+
+	r.LimitRate = 0
+	assert.Empty(t, r.shares(active))
+
+	assert.True(t, ownLimit(&Job{Args: []string{"-j", "4", "--limit-rate", "2M", "https://x/a"}}))
+	assert.False(t, ownLimit(&Job{Args: []string{"--limit-rate-file", "f", "https://x/a"}}))
+	assert.False(t, ownLimit(&Job{Args: []string{"https://x/--limit-rate"}}))
+}
+
+func TestUpdateFailureSavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	err := Update(dir, func(q *Queue) error {
+		q.Add([]string{"https://x/a"}, dir, 0)
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	assert.Empty(t, q.Jobs)
+}
+
+func TestRunner(t *testing.T) {
+	dir := t.TempDir()
+	out := t.TempDir()
+	require.NoError(t, Update(dir, func(q *Queue) error {
+		q.Add([]string{"echo one > one"}, out, 0)
+		q.Add([]string{"echo two; exit 7"}, out, 0)
+		q.Add([]string{"echo three > three"}, out, 0)
+		q.Add([]string{"echo stale > stale"}, out, 0).Status = StatusRunning
+		return nil
+	}))
+
+	r := &Runner{
+		Dir:     dir,
+		Jobs:    2,
+		Program: "sh",
+		Args:    func(job *Job, _ int64) []string { return []string{"-c", job.URL()} },
+	}
+	require.NoError(t, r.Run(context.Background()))
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	for _, id := range []int{1, 3, 4} {
+		assert.Equal(t, StatusDone, q.Get(id).Status, id)
+	}
+	failed := q.Get(2)
+	assert.Equal(t, StatusFailed, failed.Status)
+	assert.Equal(t, 7, failed.ExitCode)
+	assert.Equal(t, "checksum", failed.ErrorKind)
+	assert.False(t, failed.FinishedAt.IsZero())
+
+	for _, name := range []string{"one", "three", "stale"} {
+		assert.FileExists(t, out+"/"+name)
+	}
+	log, err := os.ReadFile(LogPath(dir, 2))
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(log))
+}
+
+func TestRunnerLimitRate(t *testing.T) {
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	dir := t.TempDir()
+	require.NoError(t, Update(dir, func(q *Queue) error {
+		q.Add([]string{"sleep 0.2"}, dir, 0)
+		q.Add([]string{"sleep 0.2"}, dir, 0).Weight = 3
+		q.Add([]string{"true"}, dir, 0)
+		return nil
+	}))
+
+	limits := make(map[int]int64)
+	r := &Runner{
+		Dir:       dir,
+		Jobs:      2,
+		LimitRate: 1000,
+		Program:   "sh",
+		Args: func(job *Job, limitRate int64) []string {
+			limits[job.ID] = limitRate
+			return []string{"-c", job.URL()}
+		},
+	}
+	require.NoError(t, r.Run(context.Background()))
+
+	// The second job starts next to the first, which runs alone until then
+	assert.Equal(t, int64(1000), limits[1])
+	assert.Equal(t, int64(750), limits[2])
+	// The third next to whichever is left: 1 of 4 beside the second, half beside the first
+	assert.Contains(t, []int64{250, 500}, limits[3])
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	assert.Greater(t, q.Get(1).RanSeconds, 0.1)
+}
+
+func TestSetLimit(t *testing.T) {
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+	dir := t.TempDir()
+	assert.ErrorContains(t, setLimit(os.Getpid(), 600), "registered")
+
+	var got []string
+	for _, prefix := range []string{"a.iso", "b.iso"} {
+		require.NoError(t, registry.Save(&registry.Entry{
+			ID: prefix, Dir: dir, Prefix: prefix, Status: registry.StatusDownloading, PID: os.Getpid(),
+		}))
+		srv, err := control.Listen(filepath.Join(dir, control.SocketPath(prefix)), func(line string) (string, error) {
+			got = append(got, line)
+			return "", nil
+		})
+		require.NoError(t, err)
+		defer srv.Close()
+	}
+
+	// A template job's files share its part
+	require.NoError(t, setLimit(os.Getpid(), 600))
+	assert.Equal(t, []string{"set limit=300", "set limit=300"}, got)
+}
+
+func TestRunnerMaxPerHost(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Update(dir, func(q *Queue) error {
+		q.Add([]string{"sleep 0.2", "https://a.example/1.iso"}, dir, 0)
+		q.Add([]string{"sleep 0.2", "https://A.example:8443/2.iso"}, dir, 0)
+		q.Add([]string{"sleep 0.2", "https://b.example/3.iso"}, dir, 0)
+		return nil
+	}))
+
+	r := &Runner{
+		Dir:        dir,
+		Jobs:       3,
+		MaxPerHost: 1,
+		Program:    "sh",
+		Args:       func(job *Job, _ int64) []string { return []string{"-c", job.Args[0]} },
+	}
+	require.NoError(t, r.Run(context.Background()))
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	first, second, other := q.Get(1), q.Get(2), q.Get(3)
+	for _, j := range q.Jobs {
+		assert.Equal(t, StatusDone, j.Status)
+	}
+	// The second job for a.example waits for the first; b.example doesn't
+	assert.False(t, second.StartedAt.Before(first.FinishedAt))
+	assert.True(t, other.StartedAt.Before(first.FinishedAt))
+}
+
+func TestRunnerInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Update(dir, func(q *Queue) error {
+		q.Add([]string{"sleep 30"}, dir, 0)
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := &Runner{
+		Dir:     dir,
+		Jobs:    1,
+		Program: "sh",
+		Args:    func(job *Job, _ int64) []string { return []string{"-c", "exec " + job.URL()} },
+	}
+	assert.ErrorIs(t, r.Run(ctx), context.DeadlineExceeded)
+
+	q, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, q.Get(1).Status)
+}
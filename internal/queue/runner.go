@@ -0,0 +1,321 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/control"
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// pollInterval is how often a Runner looks for jobs added while it runs.
+const pollInterval = 2 * time.Second
+
+// stopWait is how long a job has to stop after being interrupted before
+// it is killed.
+const stopWait = time.Minute
+
+// Runner runs the queue's jobs, up to Jobs at a time, each as a process
+// of its own with its output in LogPath. LimitRate is shared by the
+// running jobs in proportion to their weights, and shared again through
+// their control sockets whenever a job starts or ends.
+type Runner struct {
+	Dir        string
+	Jobs       int    // jobs run at once; 1 runs them one after another
+	MaxPerHost int    // jobs run at once for one host (0 = no cap but Jobs)
+	LimitRate  int64  // bytes/s shared by the running jobs (0 = unlimited)
+	Program    string // run for each job, usually rapel itself
+	// Args returns the program's arguments for job, given its share of
+	// LimitRate in bytes/s (0 = none)
+	Args  func(job *Job, limitRate int64) []string
+	Watch bool // wait for more jobs once the queue is empty
+}
+
+type jobResult struct {
+	job *Job
+	err error
+}
+
+// running is a job the Runner started.
+type running struct {
+	job   *Job
+	pid   int   // 0 until the process started
+	limit int64 // the share of LimitRate it was last given
+}
+
+// Run runs pending jobs until none are left, or with Watch until ctx is
+// done. Cancelling ctx interrupts the running jobs, which go back to
+// pending so the next run resumes them. Only one Runner may run a queue.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Join(r.Dir, "logs"), 0700); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+	lock, err := registry.Acquire(filepath.Join(r.Dir, ".runner.lock"))
+	if errors.Is(err, registry.ErrLocked) {
+		return fmt.Errorf("the queue is already being run: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	// Jobs left running by a runner that died start over
+	err = Update(r.Dir, func(q *Queue) error {
+		for _, j := range q.Jobs {
+			if j.Status == StatusRunning {
+				j.Status = StatusPending
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	results := make(chan jobResult)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	active := make(map[int]*running)
+	done := ctx.Done()
+
+	for {
+		for len(active) < max(r.Jobs, 1) && ctx.Err() == nil {
+			job, err := r.claim(active)
+			if err != nil {
+				slog.Warn("Could not read the queue", "error", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			rj := &running{job: job}
+			active[job.ID] = rj
+			rj.limit = r.shares(active)[job.ID]
+			slog.Info(fmt.Sprintf("Starting job %d: %s", job.ID, redact.URL(job.URL())),
+				"job", job.ID, "url", redact.URL(job.URL()), "log", LogPath(r.Dir, job.ID))
+			wait, err := r.start(ctx, rj)
+			go func() {
+				if err == nil {
+					err = wait()
+				}
+				results <- jobResult{job: job, err: err}
+			}()
+		}
+		r.rebalance(active)
+
+		if len(active) == 0 {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !r.Watch {
+				slog.Info("Queue finished")
+				return nil
+			}
+		}
+
+		select {
+		case res := <-results:
+			delete(active, res.job.ID)
+			if err := r.finish(ctx, res.job, res.err); err != nil {
+				slog.Warn("Could not update the queue", "job", res.job.ID, "error", err)
+			}
+		case <-ticker.C:
+		case <-done:
+			// Running jobs were interrupted; wait for them to report
+			done = nil
+		}
+	}
+}
+
+// claim marks the next pending job running and returns it, passing over
+// jobs whose host already has MaxPerHost of the active ones.
+func (r *Runner) claim(active map[int]*running) (*Job, error) {
+	perHost := make(map[string]int)
+	for _, rj := range active {
+		perHost[rj.job.Host()]++
+	}
+	allow := func(j *Job) bool {
+		return r.MaxPerHost <= 0 || j.Host() == "" || perHost[j.Host()] < r.MaxPerHost
+	}
+
+	var claimed *Job
+	err := Update(r.Dir, func(q *Queue) error {
+		job := q.NextWhere(allow)
+		if job == nil {
+			return nil
+		}
+		job.Status = StatusRunning
+		job.StartedAt = time.Now()
+		job.FinishedAt = time.Time{}
+		job.ExitCode, job.ErrorKind = 0, ""
+		copied := *job
+		claimed = &copied
+		return nil
+	})
+	return claimed, err
+}
+
+// start starts rj's job, interrupting it when ctx is done, and returns
+// the function that waits for it to end.
+func (r *Runner) start(ctx context.Context, rj *running) (func() error, error) {
+	job := rj.job
+	logFile, err := os.OpenFile(LogPath(r.Dir, job.ID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job log: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, r.Program, r.Args(job, rj.limit)...)
+	cmd.Dir = job.Dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = stopWait
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	rj.pid = cmd.Process.Pid
+	return func() error {
+		defer logFile.Close()
+		return cmd.Wait()
+	}, nil
+}
+
+// shares splits LimitRate between the active jobs in proportion to their
+// weights. A job with a --limit-rate of its own keeps it and gets none.
+func (r *Runner) shares(active map[int]*running) map[int]int64 {
+	shares := make(map[int]int64)
+	if r.LimitRate <= 0 {
+		return shares
+	}
+	total := 0
+	for _, rj := range active {
+		if !ownLimit(rj.job) {
+			total += rj.job.weight()
+		}
+	}
+	for id, rj := range active {
+		if !ownLimit(rj.job) {
+			shares[id] = max(r.LimitRate*int64(rj.job.weight())/int64(total), 1)
+		}
+	}
+	return shares
+}
+
+// rebalance gives each running job its current share of LimitRate. A job
+// that can't be reached yet, before its download has registered, is tried
+// again on the next round.
+func (r *Runner) rebalance(active map[int]*running) {
+	for id, share := range r.shares(active) {
+		rj := active[id]
+		if rj.pid == 0 || rj.limit == share {
+			continue
+		}
+		if err := setLimit(rj.pid, share); err != nil {
+			slog.Debug("Could not change the rate limit of a job", "job", id, "error", err)
+			continue
+		}
+		rj.limit = share
+	}
+}
+
+// setLimit shares limit bytes/s between the running downloads of process
+// pid, through their control sockets.
+func setLimit(pid int, limit int64) error {
+	entries, err := registry.List()
+	if err != nil {
+		return err
+	}
+	var sockets []string
+	for _, e := range entries {
+		if e.PID == pid && e.Status == registry.StatusDownloading {
+			sockets = append(sockets, filepath.Join(e.Dir, control.SocketPath(e.Prefix)))
+		}
+	}
+	if len(sockets) == 0 {
+		return fmt.Errorf("no download of process %d registered yet", pid)
+	}
+	each := max(limit/int64(len(sockets)), 1)
+	var errs []error
+	for _, path := range sockets {
+		if _, err := control.Send(path, fmt.Sprintf("set limit=%d", each)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(sockets) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// ownLimit reports whether job sets its own --limit-rate.
+func ownLimit(job *Job) bool {
+	for _, arg := range job.Args[:len(job.Args)-1] {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "limit-rate" {
+			return true
+		}
+		if arg == "--" {
+			break
+		}
+	}
+	return false
+}
+
+// finish records how job ended. A job stopped by an interrupt is pending
+// again.
+func (r *Runner) finish(ctx context.Context, job *Job, runErr error) error {
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(runErr, &exitErr):
+		code = exitErr.ExitCode()
+	case runErr != nil:
+		code = failure.Other.Code
+	}
+	kind := failure.ForCode(code)
+	interrupted := ctx.Err() != nil || kind.Err == failure.ErrCancelled
+
+	switch {
+	case code == 0:
+		slog.Info(fmt.Sprintf("Job %d done", job.ID), "job", job.ID)
+	case interrupted:
+		slog.Info(fmt.Sprintf("Job %d interrupted, back to pending", job.ID), "job", job.ID)
+	default:
+		if exitErr == nil {
+			slog.Error(fmt.Sprintf("Job %d could not run: %v", job.ID, runErr), "job", job.ID, "error", runErr)
+		} else {
+			slog.Error(fmt.Sprintf("Job %d failed with status %d (%s), see %s", job.ID, code, kind.Name, LogPath(r.Dir, job.ID)),
+				"job", job.ID, "exit_code", code, "error_kind", kind.Name)
+		}
+	}
+
+	return Update(r.Dir, func(q *Queue) error {
+		j := q.Get(job.ID)
+		if j == nil {
+			return nil
+		}
+		j.RanSeconds += time.Since(job.StartedAt).Seconds()
+		switch {
+		case code == 0:
+			j.Status = StatusDone
+		case interrupted:
+			j.Status = StatusPending
+			return nil
+		default:
+			j.Status = StatusFailed
+			j.ExitCode, j.ErrorKind = code, kind.Name
+		}
+		j.FinishedAt = time.Now()
+		return nil
+	})
+}
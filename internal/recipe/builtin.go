@@ -0,0 +1,115 @@
+package recipe
+
+// All lists the built-in recipes, in the order rapel recipe list shows them.
+var All = []Recipe{
+	{
+		Name:    "r2",
+		Summary: "Download straight into a Cloudflare R2 bucket, assembled there as a multipart upload",
+		Description: `Each finished chunk is uploaded as one part of an S3 multipart upload
+and the upload is completed once every chunk is in, so the file is put
+together in the bucket and never on local disk. Only the chunks in
+flight take space. Interrupted, the same command resumes the upload.
+Credentials come from an R2 API token's access key ID and secret.`,
+		Params: []Param{
+			{Name: "url", Help: "URL to download"},
+			{Name: "account", Help: "Cloudflare account ID"},
+			{Name: "bucket", Help: "R2 bucket"},
+			{Name: "key", Help: "object key to create"},
+			{Name: "chunk", Help: "chunk size, one multipart part each: at least 5Mi (5M is too small)", Default: "100M"},
+			{Name: "jobs", Help: "concurrent chunks", Default: "4"},
+		},
+		Steps: []Step{
+			{Comment: "R2 speaks S3: point the S3 client at the account's endpoint",
+				Args: []string{"export", "AWS_ENDPOINT_URL=https://{{account}}.r2.cloudflarestorage.com", "AWS_REGION=auto"}},
+			{Comment: "Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to the token's keys, then",
+				Args: []string{"rapel", "download", "-c", "{{chunk}}", "--jobs", "{{jobs}}", "--storage", "s3://{{bucket}}/{{key}}", "{{url}}"}},
+		},
+	},
+	{
+		Name:    "rclone-offload",
+		Summary: "Move each chunk to an rclone remote as it finishes, merge later anywhere",
+		Description: `For a file larger than the local disk: every chunk is hashed into a
+manifest and moved to the remote as soon as it is complete, so only the
+chunks in flight take space. Copying the chunks back and merging with
+--verify checks each one and the whole file against the manifest.`,
+		Params: []Param{
+			{Name: "url", Help: "URL to download"},
+			{Name: "remote", Help: "rclone destination, e.g. r2:bucket/dir"},
+			{Name: "file", Help: "name the download is saved as (the last part of the URL path)"},
+			{Name: "chunk", Help: "chunk size", Default: "1G"},
+			{Name: "jobs", Help: "concurrent chunks", Default: "4"},
+		},
+		Steps: []Step{
+			{Comment: "Download, moving each chunk away once it is hashed",
+				Args: []string{"rapel", "download", "-c", "{{chunk}}", "--jobs", "{{jobs}}", "--write-manifest",
+					"--post-part-exec", "rclone move {part} {{remote}}", "--post-part-retries", "3", "{{url}}"}},
+			{Comment: "Keep the manifest with the chunks",
+				Args: []string{"rclone", "copy", "{{file}}.manifest.json", "{{remote}}"}},
+			{Comment: "Later, wherever there is room for the whole file",
+				Args: []string{"rclone", "copy", "{{remote}}", "."}},
+			{Args: []string{"rapel", "merge", "--verify", "--delete", "-o", "{{file}}"}},
+		},
+	},
+	{
+		Name:    "tor",
+		Summary: "Download over Tor, asking for a new circuit whenever the server bans the exit",
+		Description: `Requests go through Tor's SOCKS port. When the server starts refusing
+every worker with 403 or 429 after it had been serving them, rapel holds
+all requests for the cool-down and asks Tor's control port for a new
+circuit, rather than burning retries against a banned exit. The control
+port must be enabled without a password (ControlPort 9051 and
+CookieAuthentication 0 in torrc).`,
+		Params: []Param{
+			{Name: "url", Help: "URL to download"},
+			{Name: "socks", Help: "Tor SOCKS address", Default: "127.0.0.1:9050"},
+			{Name: "control_host", Help: "Tor control port host", Default: "127.0.0.1"},
+			{Name: "control_port", Help: "Tor control port", Default: "9051"},
+			{Name: "cooldown", Help: "how long to hold requests after a ban", Default: "2m"},
+			{Name: "jobs", Help: "concurrent chunks", Default: "4"},
+		},
+		Steps: []Step{
+			{Args: []string{"rapel", "download", "-x", "socks5h://{{socks}}", "--jobs", "{{jobs}}", "--ban-cooldown", "{{cooldown}}",
+				"--ban-cmd", `printf "AUTHENTICATE \"\"\r\nSIGNAL NEWNYM\r\nQUIT\r\n" | nc {{control_host}} {{control_port}}`, "{{url}}"}},
+		},
+	},
+	{
+		Name:    "multi-machine",
+		Summary: "Split one download across several machines or IPs, then merge the pieces",
+		Description: `For hosts that throttle each client IP: the file's chunks are cut into
+one plan per machine, each machine downloads only its own range and
+resumes it like any download, and the directories are merged together
+at the end. Copy the directories whole, hidden state files included.`,
+		Params: []Param{
+			{Name: "url", Help: "URL to download"},
+			{Name: "file", Help: "name the download is saved as (the last part of the URL path)"},
+			{Name: "machines", Help: "number of machines", Default: "3"},
+			{Name: "chunk", Help: "chunk size", Default: "100M"},
+			{Name: "jobs", Help: "concurrent chunks on each machine", Default: "4"},
+		},
+		Steps: []Step{
+			{Comment: "Write {{file}}.plan-1of{{machines}}.json ... plan-{{machines}}of{{machines}}.json",
+				Args: []string{"rapel", "plan", "split", "-c", "{{chunk}}", "{{machines}}", "{{url}}"}},
+			{Comment: "On machine K, in a directory holding its plan",
+				Args: []string{"rapel", "download", "--jobs", "{{jobs}}", "--plan", "{{file}}.plan-Kof{{machines}}.json", "{{url}}"}},
+			{Comment: "With each machine's directory copied back as m1, m2, ...",
+				Args: []string{"rapel", "merge", "-o", "{{file}}"}, Each: "machines", Repeat: []string{"--from", "m{{n}}"}},
+		},
+	},
+	{
+		Name:    "s3-presigned",
+		Summary: "Download a private S3 object through short-lived presigned URLs",
+		Description: `Every request gets a freshly signed URL from the AWS CLI, so a download
+that outlasts any one signature keeps going, and nothing long-lived is
+handed to rapel. The AWS CLI's usual credentials and profile apply.`,
+		Params: []Param{
+			{Name: "object", Help: "object to download, s3://bucket/key"},
+			{Name: "expires", Help: "seconds each presigned URL is valid for", Default: "300"},
+			{Name: "profile", Help: "AWS CLI profile", Default: "default"},
+			{Name: "jobs", Help: "concurrent chunks", Default: "8"},
+		},
+		Steps: []Step{
+			{Args: []string{"rapel", "download", "--jobs", "{{jobs}}", "--url-cmd", "aws s3 presign {url} --expires-in {{expires}}",
+				"--hook-env", "AWS_PROFILE={{profile}}", "{{object}}"}},
+		},
+	},
+}
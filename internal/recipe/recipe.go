@@ -0,0 +1,142 @@
+// Package recipe holds built-in workflows that take several rapel
+// commands and flags, such as offloading chunks to a bucket or spreading
+// a download over several machines. rapel recipe lists them and expands
+// one into the commands to run, with the user's values filled in.
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Param is a value a recipe's commands are filled in with, written as
+// {{name}} in them. A Param without a Default must be given.
+type Param struct {
+	Name    string
+	Help    string
+	Default string
+}
+
+// Step is one command of a recipe. Args[0] is the program. Placeholders
+// of rapel's own, such as {part}, are left for rapel to fill.
+type Step struct {
+	Comment string // said before the command
+	Args    []string
+	// Each names a parameter holding a count. Repeat is appended to Args
+	// once for every number from 1 to it, with {{n}} standing for the number.
+	Each   string
+	Repeat []string
+}
+
+// Recipe is a built-in workflow.
+type Recipe struct {
+	Name        string
+	Summary     string // one line, for rapel recipe list
+	Description string
+	Params      []Param
+	Steps       []Step
+}
+
+// placeholder matches a {{name}} in a step's arguments.
+var placeholder = regexp.MustCompile(`\{\{([a-z_]+)\}\}`)
+
+// Lookup returns the built-in recipe called name.
+func Lookup(name string) (Recipe, bool) {
+	i := slices.IndexFunc(All, func(r Recipe) bool { return r.Name == name })
+	if i < 0 {
+		return Recipe{}, false
+	}
+	return All[i], true
+}
+
+// Expand returns the recipe's steps with every placeholder replaced by its
+// value in values, or by the parameter's default.
+func (r Recipe) Expand(values map[string]string) ([]Step, error) {
+	resolved := make(map[string]string, len(r.Params))
+	for _, p := range r.Params {
+		resolved[p.Name] = p.Default
+	}
+	for name, v := range values {
+		if _, ok := resolved[name]; !ok {
+			return nil, fmt.Errorf("recipe %s has no parameter %q (parameters: %s)", r.Name, name, strings.Join(r.paramNames(), ", "))
+		}
+		resolved[name] = v
+	}
+	var missing []string
+	for _, p := range r.Params {
+		if resolved[p.Name] == "" {
+			missing = append(missing, p.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("recipe %s needs %s", r.Name, strings.Join(missing, ", "))
+	}
+
+	steps := make([]Step, len(r.Steps))
+	for i, s := range r.Steps {
+		steps[i] = Step{Comment: fill(s.Comment, resolved), Args: make([]string, len(s.Args))}
+		for j, arg := range s.Args {
+			steps[i].Args[j] = fill(arg, resolved)
+		}
+		if s.Each == "" {
+			continue
+		}
+		count, err := strconv.Atoi(resolved[s.Each])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("recipe %s: %s must be a number of 1 or more, not %q", r.Name, s.Each, resolved[s.Each])
+		}
+		for n := 1; n <= count; n++ {
+			resolved["n"] = strconv.Itoa(n)
+			for _, arg := range s.Repeat {
+				steps[i].Args = append(steps[i].Args, fill(arg, resolved))
+			}
+		}
+		delete(resolved, "n")
+	}
+	return steps, nil
+}
+
+func (r Recipe) paramNames() []string {
+	names := make([]string, len(r.Params))
+	for i, p := range r.Params {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// fill replaces the placeholders in s with their values. Placeholders
+// without one are kept as they are.
+func fill(s string, values map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := values[m[2:len(m)-2]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// Shell returns the step as a POSIX shell command line, quoting the
+// arguments that need it.
+func (s Step) Shell() string {
+	words := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		words[i] = shellQuote(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote single-quotes arg unless it is made of characters a shell
+// takes literally. An assignment keeps its name unquoted, so export
+// VAR=value still works.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+%") == "" {
+		return arg
+	}
+	if name, value, ok := strings.Cut(arg, "="); ok && name != "" && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") == "" {
+		return name + "=" + shellQuote(value)
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,91 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinRecipesExpand(t *testing.T) {
+	names := map[string]bool{}
+	for _, r := range All {
+		assert.False(t, names[r.Name], "duplicate recipe %s", r.Name)
+		names[r.Name] = true
+		assert.NotEmpty(t, r.Summary, r.Name)
+		assert.NotEmpty(t, r.Steps, r.Name)
+
+		values := map[string]string{}
+		for _, p := range r.Params {
+			assert.NotEmpty(t, p.Help, "%s %s", r.Name, p.Name)
+			if p.Default == "" {
+				values[p.Name] = "VALUE"
+			}
+		}
+		steps, err := r.Expand(values)
+		require.NoError(t, err, r.Name)
+
+		// Every placeholder is a parameter, and every parameter is used
+		used := map[string]bool{}
+		for _, s := range r.Steps {
+			for _, text := range append([]string{s.Comment}, s.Args...) {
+				for _, m := range placeholder.FindAllStringSubmatch(text, -1) {
+					used[m[1]] = true
+				}
+			}
+			if s.Each != "" {
+				used[s.Each] = true
+			}
+		}
+		for _, p := range r.Params {
+			assert.True(t, used[p.Name], "%s: %s is unused", r.Name, p.Name)
+		}
+		for _, s := range steps {
+			assert.NotContains(t, s.Shell(), "{{", r.Name)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	r, ok := Lookup("tor")
+	require.True(t, ok)
+
+	_, err := r.Expand(nil)
+	assert.ErrorContains(t, err, "needs url")
+	_, err = r.Expand(map[string]string{"url": "https://x/f", "bogus": "1"})
+	assert.ErrorContains(t, err, `no parameter "bogus"`)
+
+	steps, err := r.Expand(map[string]string{"url": "https://x/f?a=1&b=2", "jobs": "8"})
+	require.NoError(t, err)
+	cmd := steps[len(steps)-1].Shell()
+	assert.True(t, strings.HasPrefix(cmd, "rapel download -x socks5h://127.0.0.1:9050 --jobs 8 "), cmd)
+	assert.True(t, strings.HasSuffix(cmd, " 'https://x/f?a=1&b=2'"), cmd)
+
+	// One --from per machine
+	r, ok = Lookup("multi-machine")
+	require.True(t, ok)
+	steps, err = r.Expand(map[string]string{"url": "https://x/f", "file": "f", "machines": "2"})
+	require.NoError(t, err)
+	assert.Equal(t, "rapel merge -o f --from m1 --from m2", steps[len(steps)-1].Shell())
+	_, err = r.Expand(map[string]string{"url": "https://x/f", "file": "f", "machines": "two"})
+	assert.ErrorContains(t, err, "machines must be a number")
+
+	_, ok = Lookup("nope")
+	assert.False(t, ok)
+}
+
+func TestShellQuote(t *testing.T) {
+	for arg, want := range map[string]string{
+		"download":                   "download",
+		"s3://bucket/key":            "s3://bucket/key",
+		"":                           "''",
+		"a b":                        "'a b'",
+		"it's":                       `'it'\''s'`,
+		"rclone move {part} r2:b/":   "'rclone move {part} r2:b/'",
+		"AWS_ENDPOINT_URL=https://x": "AWS_ENDPOINT_URL=https://x",
+		"VAR=a b":                    "VAR='a b'",
+	} {
+		assert.Equal(t, want, shellQuote(arg), arg)
+	}
+}
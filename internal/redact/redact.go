@@ -0,0 +1,118 @@
+// Package redact removes credentials from URLs and errors before they are
+// persisted in state files or written to logs.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "REDACTED"
+
+// sensitiveParams are query parameters (lower-cased) known to carry
+// signatures, tokens or credentials in pre-signed and API URLs.
+var sensitiveParams = map[string]bool{
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+	"signature":            true,
+	"sig":                  true,
+	"token":                true,
+	"access_token":         true,
+	"auth":                 true,
+	"authorization":        true,
+	"api_key":              true,
+	"apikey":               true,
+	"key":                  true,
+	"password":             true,
+	"secret":               true,
+	"policy":               true,
+	"key-pair-id":          true,
+}
+
+// isSensitiveParam reports whether a query parameter name looks secret.
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	if sensitiveParams[name] {
+		return true
+	}
+	return strings.Contains(name, "token") || strings.Contains(name, "signature") || strings.Contains(name, "secret")
+}
+
+// URL returns rawURL with userinfo passwords and sensitive query parameter
+// values replaced by Placeholder. Unparseable input is returned as-is.
+func URL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), Placeholder)
+		}
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		changed := false
+		for name, values := range query {
+			if !isSensitiveParam(name) {
+				continue
+			}
+			for i := range values {
+				values[i] = Placeholder
+			}
+			changed = true
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// HasSecrets reports whether rawURL carries a userinfo password or a
+// sensitive query parameter, the parts URL redacts. A URL that merely
+// re-encodes differently once parsed has none.
+func HasSecrets(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return true
+		}
+	}
+	for name := range u.Query() {
+		if isSensitiveParam(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint returns a stable SHA-256 hex digest identifying s without
+// revealing it.
+func Fingerprint(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Error redacts the URL embedded in a *url.Error, as returned by net/http,
+// so request failures can be logged safely. Other errors are returned unchanged.
+func Error(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		uerr.URL = URL(uerr.URL)
+	}
+	return err
+}
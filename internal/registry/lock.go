@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when another process holds a download's lock.
+var ErrLocked = errors.New("download already in progress")
+
+// Lock is an exclusive advisory lock on a download's chunk files.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// LockPath returns the lock filename for a download prefix.
+func LockPath(prefix string) string {
+	return fmt.Sprintf(".%s.lock", prefix)
+}
+
+// Acquire takes the lock at path without blocking. If another process holds
+// it, the returned error wraps ErrLocked and names the holder's PID.
+func Acquire(path string) (*Lock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := lockFile(f); err != nil {
+			pid := readPID(f)
+			f.Close()
+			if pid > 0 {
+				return nil, fmt.Errorf("%w (pid %d holds %s)", ErrLocked, pid, path)
+			}
+			return nil, fmt.Errorf("%w (%s is locked)", ErrLocked, path)
+		}
+
+		// The previous holder may have removed the file between our open and
+		// lock; if so we locked an orphaned inode and must try again.
+		onDisk, err := os.Stat(path)
+		held, statErr := f.Stat()
+		if err != nil || statErr != nil || !os.SameFile(onDisk, held) {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+
+		f.Truncate(0)
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+		return &Lock{path: path, file: f}, nil
+	}
+}
+
+// Locked reports whether another process holds the lock at path.
+func Locked(path string) bool {
+	l, err := Acquire(path)
+	if err != nil {
+		return errors.Is(err, ErrLocked)
+	}
+	l.Release()
+	return false
+}
+
+// Release removes the lock file and unlocks it.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	os.Remove(l.path)
+	unlockFile(l.file)
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// readPID returns the PID recorded in a lock file, or 0 if unknown.
+func readPID(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	return pid
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package registry
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// lockFile takes a non-blocking exclusive lock on the first byte of f.
+func lockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	const lockfileExclusiveLock = 0x2
+	const lockfileFailImmediately = 0x1
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
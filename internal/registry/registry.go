@@ -0,0 +1,193 @@
+// Package registry keeps a central index of downloads and provides the
+// per-download lock that stops two rapel processes sharing chunk files.
+//
+// Each download is recorded as <dir>/<id>.json, where dir defaults to
+// $XDG_DATA_HOME/rapel/state (~/.local/share/rapel/state) and id is a hash
+// of the URL fingerprint and the absolute output path. Its state files
+// (.{prefix}-args.json and the rest) are kept in <dir>/<id>/, and its
+// chunk files in the directory it runs in; see StateDir.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/schema"
+)
+
+// Download statuses recorded in the registry.
+const (
+	StatusDownloading = schema.StatusDownloading
+	StatusComplete    = schema.StatusComplete
+	StatusFailed      = schema.StatusFailed
+	StatusCancelled   = schema.StatusCancelled
+	StatusIncomplete  = schema.StatusIncomplete // selected chunks done, others still missing
+)
+
+// Entry describes one download known to the registry.
+type Entry struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"` // redacted
+	URLHash   string    `json:"url_sha256"`
+	Dir       string    `json:"dir"`
+	Prefix    string    `json:"prefix"`
+	TotalSize int64     `json:"total_size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OutputPath returns the absolute path prefix of the download's chunk files.
+func (e *Entry) OutputPath() string {
+	return filepath.Join(e.Dir, e.Prefix)
+}
+
+// DataDir returns rapel's data directory: RAPEL_DATA_DIR if set, else
+// $XDG_DATA_HOME/rapel, else ~/.local/share/rapel.
+func DataDir() (string, error) {
+	if dir := os.Getenv("RAPEL_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "rapel"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate data directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "rapel"), nil
+}
+
+// Dir returns the registry directory. RAPEL_STATE_DIR overrides the default
+// of DataDir()/state.
+func Dir() (string, error) {
+	if dir := os.Getenv("RAPEL_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	dataDir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "state"), nil
+}
+
+// ID returns the registry key for a URL fingerprint and absolute output path.
+func ID(urlHash, outputPath string) string {
+	sum := sha256.Sum256([]byte(urlHash + "\x00" + outputPath))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Save writes e to the registry atomically, creating the directory if needed.
+func Save(e *Entry) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	e.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal registry entry: %w", err)
+	}
+
+	path := filepath.Join(dir, e.ID+".json")
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write registry entry: %w", err)
+	}
+
+	return nil
+}
+
+// Remove deletes the entry with the given id and its state files. Missing
+// entries are ignored.
+func Remove(id string) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(dir, id)); err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns all entries, most recently updated first. Unreadable entries are skipped.
+func List() ([]*Entry, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	var entries []*Entry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		entries = append(entries, &e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].UpdatedAt.Equal(entries[j].UpdatedAt) {
+			return entries[i].UpdatedAt.After(entries[j].UpdatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// FindCollision returns an active entry using the same output path as e
+// but a different URL, or nil if there is none.
+func FindCollision(e *Entry) (*Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range entries {
+		if other.ID == e.ID || other.OutputPath() != e.OutputPath() {
+			continue
+		}
+		if other.URLHash != e.URLHash && other.Status != StatusComplete {
+			return other, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveListRemove(t *testing.T) {
+	t.Setenv("RAPEL_STATE_DIR", t.TempDir())
+
+	a := &Entry{ID: ID("hash-a", "/data/file.bin"), URLHash: "hash-a", Dir: "/data", Prefix: "file.bin", Status: StatusDownloading}
+	b := &Entry{ID: ID("hash-b", "/data/other.bin"), URLHash: "hash-b", Dir: "/data", Prefix: "other.bin", Status: StatusComplete}
+	require.NoError(t, Save(a))
+	require.NoError(t, Save(b))
+
+	entries, err := List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// Most recently updated first, ties by ID: back-to-back saves may get
+	// the same time from a coarse clock
+	now := time.Now()
+	a.UpdatedAt, b.UpdatedAt = now, now.Add(-time.Second)
+	writeEntry(t, a)
+	writeEntry(t, b)
+	entries, err = List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{a.ID, b.ID}, []string{entries[0].ID, entries[1].ID})
+	b.UpdatedAt = now
+	writeEntry(t, b)
+	entries, err = List()
+	require.NoError(t, err)
+	assert.Equal(t, min(a.ID, b.ID), entries[0].ID)
+
+	require.NoError(t, Remove(a.ID))
+	require.NoError(t, Remove(a.ID)) // missing entry is not an error
+
+	entries, err = List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// writeEntry stores e as it is, where Save would set UpdatedAt.
+func writeEntry(t *testing.T, e *Entry) {
+	dir, err := Dir()
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, e.ID+".json"), data, 0600))
+}
+
+func TestFindCollision(t *testing.T) {
+	t.Setenv("RAPEL_STATE_DIR", t.TempDir())
+
+	existing := &Entry{ID: ID("hash-a", "/data/latest.tar.gz"), URLHash: "hash-a", Dir: "/data", Prefix: "latest.tar.gz", Status: StatusDownloading}
+	require.NoError(t, Save(existing))
+
+	same := &Entry{ID: existing.ID, URLHash: "hash-a", Dir: "/data", Prefix: "latest.tar.gz"}
+	other, err := FindCollision(same)
+	require.NoError(t, err)
+	assert.Nil(t, other)
+
+	clash := &Entry{ID: ID("hash-b", "/data/latest.tar.gz"), URLHash: "hash-b", Dir: "/data", Prefix: "latest.tar.gz"}
+	other, err = FindCollision(clash)
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	assert.Equal(t, existing.ID, other.ID)
+
+	// Finished downloads don't block reuse of the prefix
+	existing.Status = StatusComplete
+	require.NoError(t, Save(existing))
+	other, err = FindCollision(clash)
+	require.NoError(t, err)
+	assert.Nil(t, other)
+}
+
+func TestAcquireExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".file.bin.lock")
+
+	lock, err := Acquire(path)
+	require.NoError(t, err)
+
+	_, err = Acquire(path)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.True(t, Locked(path))
+
+	require.NoError(t, lock.Release())
+	assert.False(t, Locked(path))
+	assert.NoFileExists(t, path)
+
+	lock, err = Acquire(path)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
+
+func TestStateDir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RAPEL_STATE_DIR", t.TempDir())
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	// Unregistered: next to the chunks
+	assert.Equal(t, ".file.bin-args.json", StatePath("file.bin", "args.json"))
+	assert.Equal(t, filepath.Join("sub", ".file.bin-args.json"), StatePath(filepath.Join("sub", "file.bin"), "args.json"))
+
+	e := &Entry{ID: ID("hash", filepath.Join(cwd, "file.bin")), URLHash: "hash", Dir: cwd, Prefix: "file.bin"}
+	require.NoError(t, Save(e))
+	assert.Equal(t, ".", StateDir("file.bin"), "registered without a state directory")
+
+	require.NoError(t, CreateStateDir(e.ID))
+	dir, err := Dir()
+	require.NoError(t, err)
+	stateDir := filepath.Join(dir, e.ID)
+	assert.Equal(t, stateDir, StateDir("file.bin"))
+	assert.Equal(t, filepath.Join(stateDir, ".file.bin-args.json"), StatePath(filepath.Join(cwd, "file.bin"), "args.json"))
+	assert.Equal(t, ".other.bin-args.json", StatePath("other.bin", "args.json"))
+
+	// Listing skips the state directories
+	entries, err := List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, os.WriteFile(StatePath("file.bin", "args.json"), nil, 0600))
+	PruneStateDir("file.bin")
+	assert.DirExists(t, stateDir)
+	require.NoError(t, os.Remove(filepath.Join(stateDir, ".file.bin-args.json")))
+	PruneStateDir("file.bin")
+	assert.NoDirExists(t, stateDir)
+
+	require.NoError(t, CreateStateDir(e.ID))
+	require.NoError(t, os.WriteFile(StatePath("file.bin", "args.json"), nil, 0600))
+	require.NoError(t, Forget("file.bin"))
+	assert.NoDirExists(t, stateDir)
+	entries, err = List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateDir returns the directory holding the state files of the download
+// whose chunk files are at outputPath (their directory and prefix): the
+// registry's directory for it once the download keeps them there, as one
+// started by rapel download does, else the chunks' own directory. State
+// written by split, by a --plan share, whose directory is copied back for
+// the merge, or by an import stays next to the chunks, as does that of
+// downloads started before the registry kept any.
+func StateDir(outputPath string) string {
+	if dir, ok := registeredStateDir(outputPath); ok {
+		return dir
+	}
+	return filepath.Dir(outputPath)
+}
+
+// StatePath returns the path of the download's state file named
+// .{prefix}-{name} in StateDir(outputPath).
+func StatePath(outputPath, name string) string {
+	return filepath.Join(StateDir(outputPath), fmt.Sprintf(".%s-%s", filepath.Base(outputPath), name))
+}
+
+// CreateStateDir creates the directory that keeps the state files of the
+// download registered as id, readable by the user alone.
+func CreateStateDir(id string) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, id), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return nil
+}
+
+// PruneStateDir removes the registry's directory for the download at
+// outputPath once no state file is left in it.
+func PruneStateDir(outputPath string) {
+	if dir, ok := registeredStateDir(outputPath); ok {
+		os.Remove(dir)
+	}
+}
+
+// Forget removes every entry for the download at outputPath, along with
+// the state files kept for it.
+func Forget(outputPath string) error {
+	abs, err := filepath.Abs(outputPath)
+	if err != nil {
+		return err
+	}
+	entries, err := List()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.OutputPath() == abs {
+			if err := Remove(e.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registeredStateDir returns the state directory of the most recently
+// updated entry for outputPath that has one.
+func registeredStateDir(outputPath string) (string, bool) {
+	abs, err := filepath.Abs(outputPath)
+	if err != nil {
+		return "", false
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", false
+	}
+	entries, err := List()
+	if err != nil {
+		return "", false
+	}
+	for _, e := range entries {
+		if e.OutputPath() != abs {
+			continue
+		}
+		path := filepath.Join(dir, e.ID)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,310 @@
+// Package rpc implements the --rpc-socket: a JSON-RPC 2.0 interface on a
+// Unix socket that lets a GUI or another wrapper follow and control a
+// running download. Requests and answers are JSON objects, one per line,
+// any number of them per connection. A connection that calls subscribe is
+// also sent notifications, as requests without an id.
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/schema"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeFailed         = -32000 // the method was understood, but failed
+)
+
+// A Handler's errors wrapping these are answered with their code.
+var (
+	ErrMethodNotFound = errors.New("method not found")
+	ErrInvalidParams  = errors.New("invalid params")
+)
+
+// maxLine bounds a request.
+const maxLine = 1 << 20
+
+// sendQueue is how many lines a connection may fall behind on before it
+// is dropped: a subscriber that doesn't read must not hold the download
+// back.
+const sendQueue = 256
+
+// closeTimeout bounds how long Close waits for a connection to take the
+// lines queued for it.
+const closeTimeout = time.Second
+
+// Handler executes a request and returns its result, which is encoded
+// as JSON. The server answers the subscribe method itself.
+type Handler func(method string, params json.RawMessage) (any, error)
+
+// Error is the error member of an answer.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// Server serves the socket until closed.
+type Server struct {
+	mu     sync.Mutex
+	ln     net.Listener
+	h      Handler
+	conns  map[*conn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// conn is a client connection. Its lines go through out, so a slow
+// reader never blocks the server.
+type conn struct {
+	c          net.Conn
+	out        chan []byte
+	done       chan struct{} // closed when the connection is dropped
+	once       sync.Once
+	subscribed bool // guarded by Server.mu
+}
+
+// drop closes the connection.
+func (c *conn) drop() {
+	c.once.Do(func() {
+		close(c.done)
+		c.c.Close()
+	})
+}
+
+// NewServer returns a Server to Listen with. Notify may be called before.
+func NewServer() *Server {
+	return &Server{conns: make(map[*conn]bool)}
+}
+
+// Listen creates the socket at path, readable by the user alone, and
+// serves requests with h. A stale socket left at path is replaced; any
+// other file there is an error.
+func (s *Server) Listen(path string, h Handler) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("failed to create RPC socket: %s exists and is not a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return fmt.Errorf("failed to create RPC socket: %s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to create RPC socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to create RPC socket: %w", err)
+	}
+
+	s.mu.Lock()
+	s.ln, s.h = ln, h
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Notify sends a notification to the subscribed connections. params is
+// encoded as JSON.
+func (s *Server) Notify(method string, params any) {
+	data, err := json.Marshal(notification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if !c.subscribed {
+			continue
+		}
+		select {
+		case c.out <- data:
+		default:
+			c.drop()
+		}
+	}
+}
+
+// Close stops serving, removing the socket. The lines already queued for
+// a connection are sent before it is closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	ln := s.ln
+	// Reading nothing more ends each connection once its lines are sent,
+	// or the client stopped taking them for closeTimeout
+	for c := range s.conns {
+		c.c.SetWriteDeadline(time.Now().Add(closeTimeout))
+		if cr, ok := c.c.(interface{ CloseRead() error }); ok {
+			cr.CloseRead()
+		} else {
+			c.drop()
+		}
+	}
+	s.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{c: nc, out: make(chan []byte, sendQueue), done: make(chan struct{})}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(2)
+		go s.read(c)
+		go s.write(c)
+	}
+}
+
+// read answers the connection's requests until it closes, then has write
+// finish.
+func (s *Server) read(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		close(c.out)
+	}()
+
+	scanner := bufio.NewScanner(c.c)
+	scanner.Buffer(make([]byte, 4096), maxLine)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		resp := s.answer(c, scanner.Bytes())
+		if resp == nil {
+			continue
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			continue
+		}
+		select {
+		case c.out <- append(data, '\n'):
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write sends the connection's lines until read is done with it.
+func (s *Server) write(c *conn) {
+	defer s.wg.Done()
+	defer c.drop()
+
+	for line := range c.out {
+		if _, err := c.c.Write(line); err != nil {
+			c.drop()
+			// Let read go on to the end of the connection
+			for range c.out {
+			}
+			return
+		}
+	}
+}
+
+// answer executes a request line, returning nil for a notification, a
+// request without an id.
+func (s *Server) answer(c *conn, line []byte) *response {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(nil, CodeParseError, "parse error")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, CodeInvalidRequest, "invalid request")
+	}
+
+	var result any
+	var err error
+	if req.Method == schema.RPCSubscribe {
+		s.mu.Lock()
+		c.subscribed = true
+		s.mu.Unlock()
+		result = true
+	} else {
+		result, err = s.h(req.Method, req.Params)
+	}
+	if req.ID == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, ErrMethodNotFound):
+		return errorResponse(req.ID, CodeMethodNotFound, err.Error())
+	case errors.Is(err, ErrInvalidParams):
+		return errorResponse(req.ID, CodeInvalidParams, err.Error())
+	case err != nil:
+		return errorResponse(req.ID, CodeFailed, err.Error())
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, CodeFailed, err.Error())
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: data}
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: &Error{Code: code, Message: msg}}
+}
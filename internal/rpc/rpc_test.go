@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+
+	srv := NewServer()
+	srv.Notify("progress", 1) // nobody to send it to yet
+	require.NoError(t, srv.Listen(path, func(method string, params json.RawMessage) (any, error) {
+		switch method {
+		case "echo":
+			return params, nil
+		case "fail":
+			return nil, errors.New("it broke")
+		case "strict":
+			return nil, fmt.Errorf("%w: want an object", ErrInvalidParams)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotFound, method)
+	}))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	c, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer c.Close()
+	lines := bufio.NewScanner(c)
+	call := func(req string) string {
+		_, err := fmt.Fprintln(c, req)
+		require.NoError(t, err)
+		require.True(t, lines.Scan())
+		return lines.Text()
+	}
+
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"a":1}}`, call(`{"jsonrpc":"2.0","id":1,"method":"echo","params":{"a":1}}`))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"x","error":{"code":-32000,"message":"it broke"}}`, call(`{"jsonrpc":"2.0","id":"x","method":"fail"}`))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"invalid params: want an object"}}`, call(`{"jsonrpc":"2.0","id":2,"method":"strict"}`))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"method not found: nope"}}`, call(`{"jsonrpc":"2.0","id":3,"method":"nope"}`))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":4,"error":{"code":-32600,"message":"invalid request"}}`, call(`{"id":4,"method":"echo"}`))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`, call(`{"jsonrpc"`))
+
+	// Notifications go to subscribed connections alone; a request without
+	// an id isn't answered
+	srv.Notify("progress", map[string]int{"bytes": 1})
+	_, err = fmt.Fprintln(c, `{"jsonrpc":"2.0","method":"echo"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":5,"result":true}`, call(`{"jsonrpc":"2.0","id":5,"method":"subscribe"}`))
+	srv.Notify("progress", map[string]int{"bytes": 2})
+	require.True(t, lines.Scan())
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"progress","params":{"bytes":2}}`, lines.Text())
+
+	// A second server can't take the socket over
+	assert.ErrorContains(t, NewServer().Listen(path, nil), "is in use")
+
+	// Close sends what is queued, then closes the connections
+	srv.Notify("event", map[string]string{"type": "done"})
+	require.NoError(t, srv.Close())
+	require.True(t, lines.Scan())
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"event","params":{"type":"done"}}`, lines.Text())
+	assert.False(t, lines.Scan())
+	assert.NoFileExists(t, path)
+}
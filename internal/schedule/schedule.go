@@ -0,0 +1,127 @@
+// Package schedule parses daily time windows like "23:00-07:00" with
+// optional per-window rate limits, and answers which window is active.
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minutesPerDay is the length of the schedule's cycle.
+const minutesPerDay = 24 * 60
+
+// Window is a daily time range [Start, End) in minutes since local
+// midnight. End < Start wraps past midnight. Rate is the rate limit in
+// bytes per second while the window is active; -1 keeps the default.
+type Window struct {
+	Start int
+	End   int
+	Rate  int64
+}
+
+// Schedule is a list of windows; the first one containing a time wins.
+// Outside all windows, downloading is paused.
+type Schedule []Window
+
+// Parse parses a comma-separated list of windows, each "HH:MM-HH:MM"
+// optionally followed by "@RATE" (e.g. "23:00-07:00,12:00-13:00@500K").
+// parseRate converts RATE to bytes per second.
+func Parse(s string, parseRate func(string) (int64, error)) (Schedule, error) {
+	var sched Schedule
+
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		rng, rateStr, hasRate := strings.Cut(spec, "@")
+		startStr, endStr, ok := strings.Cut(rng, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, want HH:MM-HH:MM", spec)
+		}
+
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		end, err := parseClock(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid window %q: empty range", spec)
+		}
+
+		w := Window{Start: start, End: end, Rate: -1}
+		if hasRate {
+			if w.Rate, err = parseRate(rateStr); err != nil {
+				return nil, fmt.Errorf("invalid rate in window %q: %w", spec, err)
+			}
+		}
+		sched = append(sched, w)
+	}
+
+	if len(sched) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return sched, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is
+// accepted as the end of the day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return (h*60 + m) % minutesPerDay, nil
+}
+
+// contains reports whether minute-of-day m falls in w.
+func (w Window) contains(m int) bool {
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// String formats w as "HH:MM-HH:MM".
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// At returns the window active at t (nil when paused) and the next time
+// the answer may change.
+func (s Schedule) At(t time.Time) (*Window, time.Time) {
+	minute := t.Hour()*60 + t.Minute()
+
+	var active *Window
+	for i := range s {
+		if s[i].contains(minute) {
+			active = &s[i]
+			break
+		}
+	}
+
+	// The answer can only change at a window boundary
+	next := 0
+	for _, w := range s {
+		for _, b := range []int{w.Start, w.End} {
+			d := (b - minute + minutesPerDay) % minutesPerDay
+			if d == 0 {
+				d = minutesPerDay
+			}
+			if next == 0 || d < next {
+				next = d
+			}
+		}
+	}
+
+	thisMinute := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	return active, thisMinute.Add(time.Duration(next) * time.Minute)
+}
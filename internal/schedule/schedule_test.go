@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseRate(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func at(h, m int) time.Time {
+	return time.Date(2024, 5, 1, h, m, 30, 0, time.Local)
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse("23:00-07:00, 12:00-13:30@500", parseRate)
+	require.NoError(t, err)
+	assert.Equal(t, Schedule{
+		{Start: 23 * 60, End: 7 * 60, Rate: -1},
+		{Start: 12 * 60, End: 13*60 + 30, Rate: 500},
+	}, s)
+
+	for _, bad := range []string{"", "23:00", "25:00-01:00", "10:00-10:00", "10:00-11:00@x"} {
+		_, err := Parse(bad, parseRate)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestAt(t *testing.T) {
+	s, err := Parse("23:00-07:00,12:00-13:00@500", parseRate)
+	require.NoError(t, err)
+
+	w, next := s.At(at(2, 15))
+	require.NotNil(t, w)
+	assert.Equal(t, "23:00-07:00", w.String())
+	assert.Equal(t, at(7, 0).Truncate(time.Minute), next)
+
+	w, next = s.At(at(9, 0))
+	assert.Nil(t, w)
+	assert.Equal(t, at(12, 0).Truncate(time.Minute), next)
+
+	w, _ = s.At(at(12, 59))
+	require.NotNil(t, w)
+	assert.Equal(t, int64(500), w.Rate)
+
+	w, next = s.At(at(23, 30))
+	require.NotNil(t, w)
+	assert.Equal(t, at(7, 0).Add(24*time.Hour).Truncate(time.Minute), next)
+}
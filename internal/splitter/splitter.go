@@ -0,0 +1,151 @@
+// Package splitter cuts a local file into ordered chunk files (.part) plus
+// the state file a download would have written, the inverse of merger.
+package splitter
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/logging"
+)
+
+// Config holds splitter configuration
+type Config struct {
+	Input     string
+	ChunkSize int64
+	Prefix    string // Optional: defaults to the input's base name
+	URL       string // Optional: origin URL recorded in the state file; defaults to a file:// URL
+	Force     bool
+}
+
+// Splitter handles splitting a file into chunk files
+type Splitter struct {
+	config Config
+}
+
+// NewSplitter creates a new Splitter
+func NewSplitter(config Config) *Splitter {
+	if config.Prefix == "" {
+		config.Prefix = filepath.Base(config.Input)
+	}
+	return &Splitter{config: config}
+}
+
+// Split writes the chunk files and the args file into the current directory.
+func (s *Splitter) Split() error {
+	if s.config.ChunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive")
+	}
+
+	in, err := os.Open(s.config.Input)
+	if err != nil {
+		return fmt.Errorf("failed to open input: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat input: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", s.config.Input)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", s.config.Input)
+	}
+
+	origin := s.config.URL
+	if origin == "" {
+		abs, err := filepath.Abs(s.config.Input)
+		if err != nil {
+			return fmt.Errorf("failed to resolve input path: %w", err)
+		}
+		origin = (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+	}
+
+	args := downloader.NewDownloadArguments(origin, info.Size(), s.config.ChunkSize, s.config.Prefix)
+
+	if !s.config.Force {
+		for i := 0; i < args.NumChunks(); i++ {
+			for _, path := range []string{args.PartPath(i), args.TmpPath(i)} {
+				if _, err := os.Stat(path); err == nil {
+					return fmt.Errorf("%s already exists, use --force to overwrite", path)
+				}
+			}
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Splitting %s (%s) into %d chunks of %s",
+		s.config.Input, formatBytes(info.Size()), args.NumChunks(), formatBytes(s.config.ChunkSize)),
+		"input", s.config.Input, "bytes", info.Size(), "chunks", args.NumChunks(), "chunk_size", s.config.ChunkSize)
+
+	for i := 0; i < args.NumChunks(); i++ {
+		if err := s.writeChunk(in, args, i); err != nil {
+			return err
+		}
+	}
+
+	if err := args.Save(); err != nil {
+		return err
+	}
+
+	logging.Blank()
+	slog.Info(fmt.Sprintf("Split complete: %s.*.part", s.config.Prefix), "prefix", s.config.Prefix, "chunks", args.NumChunks())
+
+	return nil
+}
+
+// writeChunk copies chunk i of in to its .part file via a .tmp file, the
+// same way a download finalizes chunks.
+func (s *Splitter) writeChunk(in io.ReaderAt, args *downloader.DownloadArguments, i int) error {
+	start, _ := args.ChunkRange(i)
+	size := args.ChunkSizeAt(i)
+	tmpPath, partPath := args.TmpPath(i), args.PartPath(i)
+
+	slog.Info(fmt.Sprintf("[%d/%d] Writing %s", i+1, args.NumChunks(), partPath), "part", partPath)
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	if _, err := io.Copy(out, io.NewSectionReader(in, start, size)); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, partPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+
+	return nil
+}
+
+// formatBytes formats bytes in human-readable format
+func formatBytes(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	units := []string{"KB", "MB", "GB", "TB"}
+	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
+}
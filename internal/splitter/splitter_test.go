@@ -0,0 +1,41 @@
+package splitter
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/merger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitThenMerge(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	payload := bytes.Repeat([]byte("0123456789"), 2505)
+	require.NoError(t, os.MkdirAll("src", 0755))
+	require.NoError(t, os.WriteFile("src/data.bin", payload, 0644))
+
+	s := NewSplitter(Config{Input: "src/data.bin", ChunkSize: 10000, URL: "https://example.com/data.bin"})
+	require.NoError(t, s.Split())
+
+	args, err := downloader.LoadDownloadArguments("data.bin")
+	require.NoError(t, err)
+	require.NotNil(t, args)
+	assert.True(t, args.Matches("https://example.com/data.bin"))
+	assert.Equal(t, 3, args.NumChunks())
+
+	last, err := os.ReadFile("data.bin.000002.part")
+	require.NoError(t, err)
+	assert.Len(t, last, 5050)
+
+	// Existing chunks are not overwritten without Force
+	assert.ErrorContains(t, s.Split(), "already exists")
+
+	require.NoError(t, merger.NewMerger(merger.Config{Pattern: "data.bin.*.part"}).Merge())
+	merged, err := os.ReadFile("data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, payload, merged)
+}
@@ -0,0 +1,195 @@
+// Package stats keeps lifetime download counters in a small JSON file
+// (stats.json in rapel's data directory), shown by `rapel stats`.
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// Download outcomes accepted by Record.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// Counts accumulates bytes and outcomes.
+type Counts struct {
+	Bytes     int64 `json:"bytes"`
+	Succeeded int   `json:"succeeded"`
+	Failed    int   `json:"failed"`
+	Cancelled int   `json:"cancelled"`
+}
+
+// add records one session's bytes and outcome.
+func (c *Counts) add(bytes int64, outcome string) {
+	c.Bytes += bytes
+	switch outcome {
+	case OutcomeSucceeded:
+		c.Succeeded++
+	case OutcomeFailed:
+		c.Failed++
+	case OutcomeCancelled:
+		c.Cancelled++
+	}
+}
+
+// Stats holds lifetime totals and per-host breakdowns.
+type Stats struct {
+	Counts
+	Hosts       map[string]*Counts `json:"hosts"`
+	FirstRecord time.Time          `json:"first_record,omitempty"`
+	LastRecord  time.Time          `json:"last_record,omitempty"`
+
+	// Merges after downloads, to estimate how long the next one takes
+	MergeBytes   int64   `json:"merge_bytes,omitempty"`
+	MergeSeconds float64 `json:"merge_seconds,omitempty"`
+}
+
+// MergeRate returns the average speed of recorded merges in bytes per
+// second, or 0 if none were recorded.
+func (s *Stats) MergeRate() float64 {
+	if s.MergeSeconds <= 0 {
+		return 0
+	}
+	return float64(s.MergeBytes) / s.MergeSeconds
+}
+
+// Path returns the stats file location.
+func Path() (string, error) {
+	dir, err := registry.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+// Load reads the stats file, returning empty stats if it doesn't exist.
+func Load() (*Stats, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stats{Hosts: make(map[string]*Counts)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file: %w", err)
+	}
+	if s.Hosts == nil {
+		s.Hosts = make(map[string]*Counts)
+	}
+
+	return s, nil
+}
+
+// Record adds one download session to the lifetime totals. bytes is what
+// was transferred this session, so resumed downloads aren't double counted.
+func Record(host string, bytes int64, outcome string) error {
+	return update(func(s *Stats) {
+		now := time.Now()
+		if s.FirstRecord.IsZero() {
+			s.FirstRecord = now
+		}
+		s.LastRecord = now
+
+		s.add(bytes, outcome)
+		if host != "" {
+			if s.Hosts[host] == nil {
+				s.Hosts[host] = &Counts{}
+			}
+			s.Hosts[host].add(bytes, outcome)
+		}
+	})
+}
+
+// RecordMerge adds a merge of bytes that took took to the totals MergeRate
+// is computed from.
+func RecordMerge(bytes int64, took time.Duration) error {
+	return update(func(s *Stats) {
+		s.MergeBytes += bytes
+		s.MergeSeconds += took.Seconds()
+	})
+}
+
+// update applies fn to the stats file under its lock.
+func update(fn func(*Stats)) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Serialize concurrent rapel processes updating the same file
+	lock, err := acquireWithRetry(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	s, err := Load()
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return save(path, s)
+}
+
+// Reset deletes the stats file.
+func Reset() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// save writes s to path atomically.
+func save(path string, s *Stats) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+
+	return nil
+}
+
+// acquireWithRetry waits briefly for the stats lock held by another process.
+func acquireWithRetry(path string) (*registry.Lock, error) {
+	for attempt := 0; ; attempt++ {
+		lock, err := registry.Acquire(path)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, registry.ErrLocked) || attempt >= 50 {
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndLoad(t *testing.T) {
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	s, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), s.Bytes)
+
+	require.NoError(t, Record("a.example.com", 100, OutcomeSucceeded))
+	require.NoError(t, Record("a.example.com", 50, OutcomeFailed))
+	require.NoError(t, Record("b.example.com", 10, OutcomeCancelled))
+
+	s, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, Counts{Bytes: 160, Succeeded: 1, Failed: 1, Cancelled: 1}, s.Counts)
+	assert.Equal(t, &Counts{Bytes: 150, Succeeded: 1, Failed: 1}, s.Hosts["a.example.com"])
+	assert.Equal(t, &Counts{Bytes: 10, Cancelled: 1}, s.Hosts["b.example.com"])
+	assert.False(t, s.FirstRecord.IsZero())
+
+	require.NoError(t, Reset())
+	s, err = Load()
+	require.NoError(t, err)
+	assert.Empty(t, s.Hosts)
+}
+
+func TestMergeRate(t *testing.T) {
+	t.Setenv("RAPEL_DATA_DIR", t.TempDir())
+
+	s, err := Load()
+	require.NoError(t, err)
+	assert.Zero(t, s.MergeRate())
+
+	require.NoError(t, RecordMerge(300, time.Second))
+	require.NoError(t, RecordMerge(100, time.Second))
+	s, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, s.MergeRate())
+}
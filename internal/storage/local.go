@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/redraw/rapel/internal/crypt"
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/merger"
+)
+
+// PartName returns the file name of finalized chunk i.
+func PartName(prefix string, i int) string {
+	return fmt.Sprintf("%s.%06d.part", prefix, i)
+}
+
+// TmpName returns the file name of chunk i while it is being written.
+func TmpName(prefix string, i int) string {
+	return fmt.Sprintf("%s.%06d.tmp", prefix, i)
+}
+
+// PackedName returns the file name holding chunks first..last packed
+// together. It sorts between the .part files of the chunks around it, so
+// merging in name order still works.
+func PackedName(prefix string, first, last int) string {
+	return fmt.Sprintf("%s.%06d-%06d.part", prefix, first, last)
+}
+
+// packedFilePattern matches packed chunk files like "prefix.000000-000099.part".
+var packedFilePattern = regexp.MustCompile(`^(.+)\.(\d+)-(\d+)\.part$`)
+
+// When Local flushes a chunk's data to the disk.
+const (
+	SyncNever    = "never"    // leave it to the OS: a crash may lose what finished chunks hold
+	SyncChunk    = "chunk"    // before a finished chunk's rename, and the rename after it (the default)
+	SyncInterval = "interval" // as with SyncChunk, and every Local.SyncEvery while a chunk is written
+)
+
+// SyncPolicies lists the valid values of Local.Sync.
+var SyncPolicies = []string{SyncNever, SyncChunk, SyncInterval}
+
+// Local stores chunks as files in Dir (the current directory if empty):
+// <prefix>.NNNNNN.tmp while downloading, renamed to <prefix>.NNNNNN.part
+// when complete. With Key set, the files are encrypted.
+type Local struct {
+	Dir         string
+	Prefix      string
+	Key         *crypt.Key
+	WriteBuffer int           // Optional: bytes gathered in memory before a write to a .tmp file (0 = written as they come)
+	Sync        string        // Optional: one of SyncPolicies (default SyncChunk)
+	SyncEvery   time.Duration // Optional: with SyncInterval, how often (0 = 5s)
+}
+
+// defaultSyncEvery is how often SyncInterval syncs a chunk being written
+// when Local.SyncEvery is 0.
+const defaultSyncEvery = 5 * time.Second
+
+// NewLocal returns local storage for prefix in dir.
+func NewLocal(dir, prefix string) *Local {
+	return &Local{Dir: dir, Prefix: prefix}
+}
+
+// PartPath returns the path of finalized chunk i.
+func (l *Local) PartPath(i int) string {
+	return filepath.Join(l.Dir, PartName(l.Prefix, i))
+}
+
+// TmpPath returns the path of chunk i while it is being written.
+func (l *Local) TmpPath(i int) string {
+	return filepath.Join(l.Dir, TmpName(l.Prefix, i))
+}
+
+// localChunk is a .tmp file open for appending, through a buffer if
+// Local.WriteBuffer asks for one.
+type localChunk struct {
+	file      *os.File
+	buf       *bufio.Writer // nil when unbuffered
+	w         io.Writer     // buf or file
+	syncEvery time.Duration // 0 unless SyncInterval
+	lastSync  time.Time
+}
+
+func (c *localChunk) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil && c.syncEvery > 0 && time.Since(c.lastSync) >= c.syncEvery {
+		err = c.syncData()
+	}
+	return n, err
+}
+
+func (c *localChunk) flush() error {
+	if c.buf == nil {
+		return nil
+	}
+	return c.buf.Flush()
+}
+
+// syncData writes out the buffer and flushes the file's data to the disk.
+func (c *localChunk) syncData() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.lastSync = time.Now()
+	return fsutil.SyncData(c.file)
+}
+
+// Close writes out the buffer, so a resume continues after it.
+func (c *localChunk) Close() error {
+	err := c.flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// encryptedChunk is an encrypted .tmp file open for appending.
+type encryptedChunk struct {
+	file *os.File
+	w    *crypt.Writer
+}
+
+func (c *encryptedChunk) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// syncData flushes the file's data to the disk, once Finish has sealed
+// the last of it.
+func (c *encryptedChunk) syncData() error {
+	return fsutil.SyncData(c.file)
+}
+
+// Close seals what is buffered, so a resume continues after it.
+func (c *encryptedChunk) Close() error {
+	err := c.w.Flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// OpenChunk opens the chunk's .tmp file for appending.
+func (l *Local) OpenChunk(index int) (Chunk, int64, error) {
+	if _, err := os.Stat(l.PartPath(index)); err == nil {
+		return nil, 0, ErrChunkComplete
+	}
+
+	if l.Key != nil {
+		file, w, size, err := l.Key.OpenAppend(l.TmpPath(index))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open chunk file: %w", err)
+		}
+		return &encryptedChunk{file: file, w: w}, size, nil
+	}
+
+	file, err := os.OpenFile(l.TmpPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open chunk file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to stat chunk file: %w", err)
+	}
+
+	c := &localChunk{file: file, w: file, lastSync: time.Now()}
+	if l.WriteBuffer > 0 {
+		c.buf = bufio.NewWriterSize(file, l.WriteBuffer)
+		c.w = c.buf
+	}
+	if l.Sync == SyncInterval {
+		c.syncEvery = l.SyncEvery
+		if c.syncEvery <= 0 {
+			c.syncEvery = defaultSyncEvery
+		}
+	}
+	return c, info.Size(), nil
+}
+
+// FinalizeChunk closes the .tmp file and renames it to .part. Unless
+// Sync is SyncNever, the data is on the disk before the rename, and the
+// rename too before it returns, so a .part file found after a crash holds
+// all of its chunk.
+func (l *Local) FinalizeChunk(ctx context.Context, index int, c Chunk) error {
+	if ec, ok := c.(*encryptedChunk); ok {
+		if err := ec.w.Finish(); err != nil {
+			ec.file.Close()
+			return fmt.Errorf("failed to finish encrypted chunk: %w", err)
+		}
+	}
+	sync := l.Sync != SyncNever
+	if s, ok := c.(interface{ syncData() error }); ok && sync {
+		if err := s.syncData(); err != nil {
+			c.Close()
+			return fmt.Errorf("failed to sync chunk file: %w", err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(l.TmpPath(index), l.PartPath(index)); err != nil {
+		return fmt.Errorf("failed to rename tmp to part: %w", err)
+	}
+	if sync {
+		dir := l.Dir
+		if dir == "" {
+			dir = "."
+		}
+		if err := fsutil.SyncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// ListChunks reports chunks with a .part file, or inside a packed file, as
+// complete, and the size of any .tmp file.
+func (l *Local) ListChunks(n int) ([]ChunkState, error) {
+	states := make([]ChunkState, n)
+
+	ranges, err := l.PackedRanges()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		for i := r[0]; i <= r[1] && i < n; i++ {
+			states[i].Complete = true
+		}
+	}
+
+	for i := range states {
+		if states[i].Complete {
+			continue
+		}
+		if _, err := os.Stat(l.PartPath(i)); err == nil {
+			states[i].Complete = true
+		} else if info, err := os.Stat(l.TmpPath(i)); err == nil {
+			states[i].Bytes = info.Size()
+			if l.Key != nil {
+				if states[i].Bytes, err = crypt.PlainSize(l.TmpPath(i)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return states, nil
+}
+
+// PackedRanges returns the chunk ranges [first, last] of the packed files
+// in Dir.
+func (l *Local) PackedRanges() ([][2]int, error) {
+	dir := l.Dir
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunk files: %w", err)
+	}
+
+	var ranges [][2]int
+	for _, e := range entries {
+		m := packedFilePattern.FindStringSubmatch(e.Name())
+		if m == nil || m[1] != l.Prefix {
+			continue
+		}
+		first, err1 := strconv.Atoi(m[2])
+		last, err2 := strconv.Atoi(m[3])
+		if err1 != nil || err2 != nil || first > last {
+			continue
+		}
+		ranges = append(ranges, [2]int{first, last})
+	}
+	return ranges, nil
+}
+
+// Merge concatenates the chunk files into Dir/<prefix>.
+func (l *Local) Merge(ctx context.Context, n int) error {
+	m := merger.NewMerger(merger.Config{
+		Output:    l.Prefix,
+		Pattern:   filepath.Join(l.Dir, l.Prefix) + ".*.part",
+		OutputDir: l.Dir,
+		Key:       l.Key,
+	})
+	return m.Merge()
+}
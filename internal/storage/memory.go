@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Memory keeps chunks in memory, for tests and for callers that process
+// the data themselves. Nothing survives the process, so there is no resume.
+type Memory struct {
+	mu     sync.Mutex
+	chunks map[int]*memoryChunk
+	merged []byte
+}
+
+// NewMemory returns empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{chunks: make(map[int]*memoryChunk)}
+}
+
+// memoryChunk is a chunk buffer. Writes go through Memory's lock because
+// a later OpenChunk may read the buffer while the previous writer closes.
+type memoryChunk struct {
+	m        *Memory
+	buf      bytes.Buffer
+	complete bool
+}
+
+func (c *memoryChunk) Write(p []byte) (int, error) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *memoryChunk) Close() error {
+	return nil
+}
+
+// OpenChunk returns the chunk's buffer.
+func (m *Memory) OpenChunk(index int) (Chunk, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.chunks[index]
+	if !ok {
+		c = &memoryChunk{m: m}
+		m.chunks[index] = c
+	}
+	if c.complete {
+		return nil, 0, ErrChunkComplete
+	}
+	return c, int64(c.buf.Len()), nil
+}
+
+// FinalizeChunk marks the chunk complete.
+func (m *Memory) FinalizeChunk(ctx context.Context, index int, c Chunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mc, ok := m.chunks[index]
+	if !ok || mc != c {
+		return fmt.Errorf("chunk %d was not opened", index)
+	}
+	mc.complete = true
+	return nil
+}
+
+// ListChunks reports the chunks written so far.
+func (m *Memory) ListChunks(n int) ([]ChunkState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	states := make([]ChunkState, n)
+	for i, c := range m.chunks {
+		if i < n {
+			states[i] = ChunkState{Complete: c.complete, Bytes: int64(c.buf.Len())}
+		}
+	}
+	return states, nil
+}
+
+// Merge concatenates the chunks; Bytes returns the result.
+func (m *Memory) Merge(ctx context.Context, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out bytes.Buffer
+	for i := 0; i < n; i++ {
+		c, ok := m.chunks[i]
+		if !ok || !c.complete {
+			return fmt.Errorf("chunk %d is not complete", i)
+		}
+		out.Write(c.buf.Bytes())
+	}
+	m.merged = out.Bytes()
+	return nil
+}
+
+// Bytes returns the data assembled by Merge.
+func (m *Memory) Bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.merged
+}
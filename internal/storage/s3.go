@@ -0,0 +1,512 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// S3MinPartSize is the smallest part S3 accepts: every chunk but the last
+// must be at least this big.
+const S3MinPartSize = 5 * 1024 * 1024
+
+// S3MaxParts is the most parts a multipart upload can have.
+const S3MaxParts = 10000
+
+// s3DefaultRetries is how many times a failed part upload is retried
+// unless Retries says otherwise.
+const s3DefaultRetries = 2
+
+// S3 uploads each chunk as one part of an S3 multipart upload, so the file
+// ends up in the bucket without ever being assembled locally. A chunk is
+// buffered in its local .tmp file while downloading (so it can resume),
+// uploaded when finalized, then deleted. Merge completes the upload.
+//
+// The upload ID and part ETags are kept in .{prefix}-s3.json so an
+// interrupted download continues the same upload.
+type S3 struct {
+	Bucket   string
+	Key      string
+	Endpoint string // custom endpoint (path-style addressing); "" = AWS
+	Client   *http.Client
+
+	// StateBackups is how many previous generations of the upload state
+	// file to keep (.{prefix}-s3.json.1 and so on).
+	StateBackups int
+
+	// Retries is how many times a failed part upload is tried again, with
+	// Backoff(attempt) between attempts (1 for the first retry). A nil
+	// Backoff waits 2s more at each attempt.
+	Retries int
+	Backoff func(attempt int) time.Duration
+
+	creds  awsCredentials
+	local  *Local
+	prefix string
+
+	mu    sync.Mutex
+	state *s3State
+}
+
+// s3State is the persisted multipart upload.
+type s3State struct {
+	Bucket   string         `json:"bucket"`
+	Key      string         `json:"key"`
+	UploadID string         `json:"upload_id"`
+	Parts    map[int]string `json:"parts"` // chunk index -> ETag
+}
+
+// NewS3 returns S3 storage for an s3://bucket/key URL. Credentials and
+// region come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION; AWS_ENDPOINT_URL selects an
+// S3-compatible service.
+func NewS3(rawURL, prefix string) (*S3, error) {
+	s, err := NewS3Bucket(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if s.Key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, want s3://bucket/key", rawURL)
+	}
+	s.local = NewLocal("", prefix)
+	s.prefix = prefix
+	s.Retries = s3DefaultRetries
+	return s, nil
+}
+
+// S3StatePath returns the upload state file of the download with prefix.
+func S3StatePath(prefix string) string {
+	return registry.StatePath(prefix, "s3.json")
+}
+
+// statePath returns the upload state file, looked up on each use: a new
+// download's state moves to the registry once it is registered.
+func (s *S3) statePath() string {
+	return S3StatePath(s.prefix)
+}
+
+// NewS3Bucket returns a client for reading objects under an
+// s3://bucket[/prefix] URL, with the prefix in Key. It is configured like
+// NewS3 but cannot be used as chunk storage.
+func NewS3Bucket(rawURL string) (*S3, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, want s3://bucket/key", rawURL)
+	}
+
+	creds := awsCredentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Region:       firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("S3 storage needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	return &S3{
+		Bucket:   u.Host,
+		Key:      strings.Trim(u.Path, "/"),
+		Endpoint: strings.TrimSuffix(firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")), "/"),
+		Client:   &http.Client{},
+		creds:    creds,
+	}, nil
+}
+
+// URL returns the s3:// URL of the object.
+func (s *S3) URL() string {
+	return "s3://" + s.Bucket + "/" + s.Key
+}
+
+// OpenChunk opens the chunk's local .tmp buffer.
+func (s *S3) OpenChunk(index int) (Chunk, int64, error) {
+	s.mu.Lock()
+	if s.state == nil {
+		s.mu.Unlock()
+		return nil, 0, fmt.Errorf("no multipart upload in progress")
+	}
+	_, uploaded := s.state.Parts[index]
+	s.mu.Unlock()
+	if uploaded {
+		return nil, 0, ErrChunkComplete
+	}
+	return s.local.OpenChunk(index)
+}
+
+// FinalizeChunk uploads the chunk as part index+1 and deletes the buffer.
+// The upload and the waits between its attempts stop when ctx is done; the
+// buffer is kept, so the part is uploaded when the download resumes.
+func (s *S3) FinalizeChunk(ctx context.Context, index int, c Chunk) error {
+	if err := c.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	var etag string
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.retryDelay(attempt)):
+			}
+		}
+		etag, err = s.uploadPart(ctx, index)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", index+1, err)
+	}
+
+	s.mu.Lock()
+	s.state.Parts[index] = etag
+	err = s.saveState()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(s.local.TmpPath(index))
+}
+
+// retryDelay returns the wait before retry attempt of a part upload.
+func (s *S3) retryDelay(attempt int) time.Duration {
+	if s.Backoff == nil {
+		return time.Duration(attempt) * 2 * time.Second
+	}
+	return s.Backoff(attempt)
+}
+
+// ListChunks starts or continues the multipart upload and reports the
+// uploaded parts as complete.
+func (s *S3) ListChunks(n int) ([]ChunkState, error) {
+	if n > S3MaxParts {
+		return nil, fmt.Errorf("%d chunks exceed the S3 limit of %d parts, use a bigger chunk size", n, S3MaxParts)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadState(); err != nil {
+		return nil, err
+	}
+	if s.state == nil {
+		id, err := s.CreateUpload(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		s.state = &s3State{Bucket: s.Bucket, Key: s.Key, UploadID: id, Parts: make(map[int]string)}
+		if err := s.saveState(); err != nil {
+			return nil, err
+		}
+	}
+
+	states, err := s.local.ListChunks(n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range states {
+		_, uploaded := s.state.Parts[i]
+		states[i].Complete = uploaded
+		if uploaded {
+			states[i].Bytes = 0
+		}
+	}
+	return states, nil
+}
+
+// Merge completes the multipart upload.
+func (s *S3) Merge(ctx context.Context, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return fmt.Errorf("no multipart upload in progress")
+	}
+
+	etags := make([]string, n)
+	for i := range etags {
+		etag, ok := s.state.Parts[i]
+		if !ok {
+			return fmt.Errorf("chunk %d was not uploaded", i)
+		}
+		etags[i] = etag
+	}
+	if err := s.CompleteUpload(ctx, s.state.UploadID, etags); err != nil {
+		return err
+	}
+
+	fsutil.RemoveBackups(s.statePath())
+	if err := os.Remove(s.statePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete S3 state: %w", err)
+	}
+	s.state = nil
+	return nil
+}
+
+// CompleteUpload completes multipart upload uploadID from the ETags of
+// parts 1..len(etags), in order.
+func (s *S3) CompleteUpload(ctx context.Context, uploadID string, etags []string) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		body.Parts = append(body.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode part list: %w", err)
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, url.Values{"uploadId": {uploadID}}, data)
+	if err != nil {
+		return fmt.Errorf("failed to complete upload: %w", err)
+	}
+	// S3 may report a failure with 200 OK once it has started responding
+	if bytes.Contains(resp, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete upload: %s", s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+// CreateUpload starts a multipart upload and returns its ID.
+func (s *S3) CreateUpload(ctx context.Context) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to start upload: unexpected response")
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart uploads the chunk's .tmp file and returns the part's ETag.
+func (s *S3) uploadPart(ctx context.Context, index int) (string, error) {
+	s.mu.Lock()
+	uploadID := s.state.UploadID
+	s.mu.Unlock()
+
+	f, err := os.Open(s.local.TmpPath(index))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Hash first, then rewind and stream the same bytes
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return s.UploadPart(ctx, uploadID, index+1, f, size, hex.EncodeToString(h.Sum(nil)))
+}
+
+// UploadPart uploads size bytes from r as part number of multipart upload
+// uploadID and returns the part's ETag. payloadHash is the hex SHA-256 of
+// those bytes, which the request signature covers.
+func (s *S3) UploadPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64, payloadHash string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodPut, url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}, io.NopCloser(io.LimitReader(r, size)), payloadHash)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return "", fmt.Errorf("%s: %s", resp.Status, s3ErrorMessage(msg))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("no ETag in response")
+	}
+	return etag, nil
+}
+
+// do sends a signed request and returns the response body, failing on
+// non-2xx statuses.
+func (s *S3) do(ctx context.Context, method string, query url.Values, body []byte) ([]byte, error) {
+	sum := sha256.Sum256(body)
+	req, err := s.newRequest(ctx, method, query, bytes.NewReader(body), hex.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, s3ErrorMessage(data))
+	}
+	return data, nil
+}
+
+// Stat returns the size and any hashes S3 reports for key. A missing
+// object yields an error wrapping fs.ErrNotExist.
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	req, err := s.newObjectRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// Ask for the stored checksum, if the object was uploaded with one
+	req.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+	s.creds.sign(req, emptyPayloadHash, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectInfo{}, fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, fs.ErrNotExist)
+	case resp.StatusCode != http.StatusOK:
+		return ObjectInfo{}, fmt.Errorf("HEAD s3://%s/%s: %s", s.Bucket, key, resp.Status)
+	}
+
+	info := ObjectInfo{Size: resp.ContentLength}
+	// The ETag of a single-request upload is the MD5; multipart ETags have a "-N" suffix
+	if etag := strings.Trim(resp.Header.Get("ETag"), `"`); len(etag) == 32 && !strings.Contains(etag, "-") {
+		info.MD5 = strings.ToLower(etag)
+	}
+	if sum, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Amz-Checksum-Sha256")); err == nil && len(sum) == sha256.Size {
+		info.SHA256 = hex.EncodeToString(sum)
+	}
+	return info, nil
+}
+
+// newRequest builds a signed request for the object.
+func (s *S3) newRequest(ctx context.Context, method string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	req, err := s.newObjectRequest(ctx, method, s.Key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	s.creds.sign(req, payloadHash, time.Now())
+	return req, nil
+}
+
+// newObjectRequest builds an unsigned request for key in the bucket.
+func (s *S3) newObjectRequest(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	var endpoint string
+	if s.Endpoint != "" {
+		endpoint = s.Endpoint + "/" + awsEscape(s.Bucket) + "/" + awsEscapePath(key)
+	} else {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.creds.Region, awsEscapePath(key))
+	}
+	if q := canonicalQuery(query); q != "" {
+		endpoint += "?" + q
+	}
+
+	return http.NewRequestWithContext(ctx, method, endpoint, body)
+}
+
+// loadState reads the persisted upload, discarding one for another object.
+// Callers hold s.mu.
+func (s *S3) loadState() error {
+	data, err := os.ReadFile(s.statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read S3 state: %w", err)
+	}
+
+	var state s3State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse S3 state: %w", err)
+	}
+	if state.Bucket != s.Bucket || state.Key != s.Key || state.UploadID == "" {
+		return nil
+	}
+	if state.Parts == nil {
+		state.Parts = make(map[int]string)
+	}
+	s.state = &state
+	return nil
+}
+
+// saveState writes the upload state atomically. Callers hold s.mu.
+func (s *S3) saveState() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal S3 state: %w", err)
+	}
+
+	if err := fsutil.RotateBackups(s.statePath(), s.StateBackups); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.statePath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write S3 state: %w", err)
+	}
+	return nil
+}
+
+// s3ErrorMessage extracts Code and Message from an S3 XML error body.
+func s3ErrorMessage(body []byte) string {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return strings.TrimSpace(string(body))
+	}
+	return e.Code + ": " + e.Message
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
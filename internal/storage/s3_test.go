@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 implements just enough of the multipart upload API.
+type fakeS3 struct {
+	mu     sync.Mutex
+	parts  map[int]string
+	object string
+	fails  int // part uploads to reject before accepting one
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || r.URL.Path != "/bucket/dir/file.bin" {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>up-1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && q.Get("uploadId") == "up-1" && f.fails > 0:
+		f.fails--
+		http.Error(w, "<Error><Code>SlowDown</Code></Error>", http.StatusServiceUnavailable)
+	case r.Method == http.MethodPut && q.Get("uploadId") == "up-1":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		data, _ := io.ReadAll(r.Body)
+		f.parts[n] = string(data)
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == http.MethodPost && q.Get("uploadId") == "up-1":
+		var body struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, p := range body.Parts {
+			f.object += f.parts[p.PartNumber]
+		}
+		fmt.Fprint(w, "<CompleteMultipartUploadResult/>")
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := &fakeS3{parts: make(map[int]string)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+
+	s, err := NewS3("s3://bucket/dir/file.bin", "file.bin")
+	require.NoError(t, err)
+
+	states, err := s.ListChunks(2)
+	require.NoError(t, err)
+	assert.Equal(t, []ChunkState{{}, {}}, states)
+
+	writeChunk(t, s, 0, "ab")
+	assert.NoFileExists(t, "file.bin.000000.tmp")
+
+	// A new process picks up the same upload
+	s, err = NewS3("s3://bucket/dir/file.bin", "file.bin")
+	require.NoError(t, err)
+	states, err = s.ListChunks(2)
+	require.NoError(t, err)
+	assert.Equal(t, []ChunkState{{Complete: true}, {}}, states)
+
+	writeChunk(t, s, 1, "cd")
+	require.NoError(t, s.Merge(context.Background(), 2))
+	assert.Equal(t, "abcd", fake.object)
+	assert.NoFileExists(t, ".file.bin-s3.json")
+}
+
+func TestS3RetriesPart(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := &fakeS3{parts: make(map[int]string), fails: 2}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+
+	s, err := NewS3("s3://bucket/dir/file.bin", "file.bin")
+	require.NoError(t, err)
+	var waits []int
+	s.Backoff = func(attempt int) time.Duration {
+		waits = append(waits, attempt)
+		return time.Millisecond
+	}
+	_, err = s.ListChunks(3)
+	require.NoError(t, err)
+
+	writeChunk(t, s, 0, "ab")
+	assert.Equal(t, []int{1, 2}, waits)
+	assert.Equal(t, "ab", fake.parts[1])
+
+	// Out of retries, the chunk stays for the next run
+	s.Retries = 0
+	fake.fails = 1
+	c, _, err := s.OpenChunk(1)
+	require.NoError(t, err)
+	assert.Error(t, s.FinalizeChunk(context.Background(), 1, c))
+	assert.FileExists(t, "file.bin.000001.tmp")
+
+	// Cancelling stops the wait for the next attempt
+	s.Retries = 5
+	s.Backoff = func(int) time.Duration { return time.Hour }
+	fake.fails = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c, _, err = s.OpenChunk(2)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.FinalizeChunk(ctx, 2, c), context.DeadlineExceeded)
+	assert.FileExists(t, "file.bin.000002.tmp")
+}
+
+func TestNewS3RequiresCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err := NewS3("s3://bucket/key", "key")
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	_, err = NewS3("s3://bucket", "key")
+	assert.Error(t, err)
+}
+
+func TestS3Stat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.Header.Get("X-Amz-Checksum-Mode") != "ENABLED" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/bucket/backups/single":
+			w.Header().Set("ETag", `"5D41402ABC4B2A76B9719D911017C592"`)
+			w.Header().Set("Content-Length", "5")
+		case "/bucket/backups/multi":
+			w.Header().Set("ETag", `"5d41402abc4b2a76b9719d911017c592-2"`)
+			w.Header().Set("X-Amz-Checksum-Sha256", "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=")
+			w.Header().Set("Content-Length", "5")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+
+	s, err := NewS3Bucket("s3://bucket/backups/")
+	require.NoError(t, err)
+	assert.Equal(t, "backups", s.Key)
+	ctx := context.Background()
+
+	info, err := s.Stat(ctx, "backups/single")
+	require.NoError(t, err)
+	assert.Equal(t, ObjectInfo{Size: 5, MD5: "5d41402abc4b2a76b9719d911017c592"}, info)
+
+	info, err = s.Stat(ctx, "backups/multi")
+	require.NoError(t, err)
+	assert.Equal(t, ObjectInfo{Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, info)
+
+	_, err = s.Stat(ctx, "backups/gone")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,58 @@
+// Package storage abstracts where downloaded chunks are written: local
+// .tmp/.part files (the default), memory, an io.WriterAt, or an S3
+// multipart upload.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrChunkComplete is returned by OpenChunk for a chunk that was already
+// finalized.
+var ErrChunkComplete = errors.New("chunk already complete")
+
+// Storage stores the chunks of one download.
+//
+// Chunks are written sequentially: OpenChunk appends to whatever the chunk
+// already holds, so an interrupted chunk resumes where it stopped.
+// Implementations must be safe for concurrent use on different chunks.
+type Storage interface {
+	// OpenChunk opens chunk index for appending and returns the number of
+	// bytes it already holds, or ErrChunkComplete if it was finalized.
+	OpenChunk(index int) (Chunk, int64, error)
+
+	// FinalizeChunk closes c, which must hold all of chunk index, and marks
+	// the chunk complete. A remote store gives up its upload when ctx is
+	// done.
+	FinalizeChunk(ctx context.Context, index int, c Chunk) error
+
+	// ListChunks reports the state of chunks 0..n-1, for resuming.
+	ListChunks(n int) ([]ChunkState, error)
+
+	// Merge assembles the n finalized chunks into the final file or object.
+	Merge(ctx context.Context, n int) error
+}
+
+// Chunk is an open chunk being written.
+type Chunk interface {
+	io.Writer
+
+	// Close stops writing, keeping the data for a later OpenChunk.
+	Close() error
+}
+
+// ChunkState describes a stored chunk.
+type ChunkState struct {
+	Complete bool  // finalized
+	Bytes    int64 // bytes held by an unfinalized chunk
+}
+
+// ObjectInfo is what a remote store reports about a stored object. Hashes
+// are lowercase hex, empty when the store doesn't provide them.
+type ObjectInfo struct {
+	Size   int64
+	MD5    string
+	SHA256 string
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeChunk writes data to chunk index in two opens, as a resumed
+// download would, and finalizes it.
+func writeChunk(t *testing.T, s Storage, index int, data string) {
+	t.Helper()
+
+	c, n, err := s.OpenChunk(index)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	_, err = c.Write([]byte(data[:1]))
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	c, n, err = s.OpenChunk(index)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	_, err = c.Write([]byte(data[1:]))
+	require.NoError(t, err)
+	require.NoError(t, s.FinalizeChunk(context.Background(), index, c))
+
+	_, _, err = s.OpenChunk(index)
+	assert.ErrorIs(t, err, ErrChunkComplete)
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(t.TempDir())
+	s := NewLocal(dir, "f")
+
+	writeChunk(t, s, 0, "ab")
+	writeChunk(t, s, 2, "ef")
+
+	c, _, err := s.OpenChunk(1)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("c"))
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	states, err := s.ListChunks(3)
+	require.NoError(t, err)
+	assert.Equal(t, []ChunkState{{Complete: true}, {Bytes: 1}, {Complete: true}}, states)
+	assert.FileExists(t, filepath.Join(dir, "f.000000.part"))
+
+	c, _, err = s.OpenChunk(1)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("d"))
+	require.NoError(t, err)
+	require.NoError(t, s.FinalizeChunk(context.Background(), 1, c))
+
+	require.NoError(t, s.Merge(context.Background(), 3))
+	data, err := os.ReadFile(filepath.Join(dir, "f"))
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(data))
+}
+
+func TestLocalWriteBuffer(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocal(dir, "f")
+	s.WriteBuffer = 4
+	tmp := filepath.Join(dir, "f.000000.tmp")
+
+	c, _, err := s.OpenChunk(0)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("ab"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, data, "held in the buffer")
+
+	// Closing writes it out, so a resume continues after it
+	require.NoError(t, c.Close())
+	c, n, err := s.OpenChunk(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// Interval syncs write out the buffer as they go
+	s.Sync = SyncInterval
+	s.SyncEvery = time.Nanosecond
+	require.NoError(t, c.Close())
+	c, _, err = s.OpenChunk(0)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("c"))
+	require.NoError(t, err)
+	data, err = os.ReadFile(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+
+	_, err = c.Write([]byte("d"))
+	require.NoError(t, err)
+	require.NoError(t, s.FinalizeChunk(context.Background(), 0, c))
+	data, err = os.ReadFile(filepath.Join(dir, "f.000000.part"))
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+}
+
+func TestLocalPackedChunks(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocal(dir, "f")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, PackedName("f", 0, 1)), []byte("abcd"), 0644))
+
+	states, err := s.ListChunks(3)
+	require.NoError(t, err)
+	assert.Equal(t, []ChunkState{{Complete: true}, {Complete: true}, {}}, states)
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	writeChunk(t, s, 1, "cd")
+	writeChunk(t, s, 0, "ab")
+
+	assert.Error(t, s.Merge(context.Background(), 3))
+	require.NoError(t, s.Merge(context.Background(), 2))
+	assert.Equal(t, "abcd", string(s.Bytes()))
+}
+
+func TestWriterAt(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	s := NewWriterAt(f, 2)
+	writeChunk(t, s, 1, "cd")
+	writeChunk(t, s, 0, "ab")
+
+	states, err := s.ListChunks(2)
+	require.NoError(t, err)
+	assert.Equal(t, []ChunkState{{Complete: true, Bytes: 2}, {Complete: true, Bytes: 2}}, states)
+
+	require.NoError(t, s.Merge(context.Background(), 2))
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+}
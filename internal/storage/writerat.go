@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// WriterAt writes every chunk straight to its offset in an io.WriterAt,
+// such as a preallocated *os.File, so no merge step is needed. Progress is
+// only tracked in memory: after a restart every chunk starts over.
+type WriterAt struct {
+	w         io.WriterAt
+	chunkSize int64
+
+	mu     sync.Mutex
+	states map[int]*ChunkState
+}
+
+// NewWriterAt returns storage writing chunk i at offset i*chunkSize of w.
+func NewWriterAt(w io.WriterAt, chunkSize int64) *WriterAt {
+	return &WriterAt{w: w, chunkSize: chunkSize, states: make(map[int]*ChunkState)}
+}
+
+// writerAtChunk writes sequentially from the chunk's current end.
+type writerAtChunk struct {
+	s     *WriterAt
+	state *ChunkState
+	base  int64
+}
+
+func (c *writerAtChunk) Write(p []byte) (int, error) {
+	c.s.mu.Lock()
+	off := c.base + c.state.Bytes
+	c.s.mu.Unlock()
+
+	n, err := c.s.w.WriteAt(p, off)
+
+	c.s.mu.Lock()
+	c.state.Bytes += int64(n)
+	c.s.mu.Unlock()
+	return n, err
+}
+
+func (c *writerAtChunk) Close() error {
+	return nil
+}
+
+// OpenChunk returns a writer for the chunk's region.
+func (s *WriterAt) OpenChunk(index int) (Chunk, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[index]
+	if !ok {
+		state = &ChunkState{}
+		s.states[index] = state
+	}
+	if state.Complete {
+		return nil, 0, ErrChunkComplete
+	}
+	return &writerAtChunk{s: s, state: state, base: int64(index) * s.chunkSize}, state.Bytes, nil
+}
+
+// FinalizeChunk marks the chunk complete.
+func (s *WriterAt) FinalizeChunk(ctx context.Context, index int, c Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[index]
+	if !ok {
+		return fmt.Errorf("chunk %d was not opened", index)
+	}
+	state.Complete = true
+	return nil
+}
+
+// ListChunks reports the chunks written by this process.
+func (s *WriterAt) ListChunks(n int) ([]ChunkState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]ChunkState, n)
+	for i, state := range s.states {
+		if i < n {
+			states[i] = *state
+		}
+	}
+	return states, nil
+}
+
+// Merge checks that every chunk was written; the data is already in place.
+func (s *WriterAt) Merge(ctx context.Context, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		if state, ok := s.states[i]; !ok || !state.Complete {
+			return fmt.Errorf("chunk %d is not complete", i)
+		}
+	}
+	return nil
+}
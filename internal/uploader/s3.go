@@ -0,0 +1,62 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/redraw/rapel/internal/storage"
+)
+
+// s3Target uploads each part as a part of an S3 multipart upload. The
+// session is the upload ID.
+type s3Target struct {
+	s3 *storage.S3
+}
+
+func newS3Target(rawURL string, client *http.Client) (*s3Target, error) {
+	s, err := storage.NewS3Bucket(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if s.Key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, want s3://bucket/key", rawURL)
+	}
+	s.Client = client
+	return &s3Target{s3: s}, nil
+}
+
+func (t *s3Target) Start(ctx context.Context, f File, session string) (string, error) {
+	parts := (f.Size + f.ChunkSize - 1) / f.ChunkSize
+	if parts > 1 && f.ChunkSize < storage.S3MinPartSize {
+		return "", fmt.Errorf("S3 parts must be at least 5 MiB, use -c %d or more", storage.S3MinPartSize)
+	}
+	if parts > storage.S3MaxParts {
+		return "", fmt.Errorf("%d parts exceed the S3 limit of %d, use a bigger chunk size", parts, storage.S3MaxParts)
+	}
+
+	if session != "" {
+		return session, nil
+	}
+	return t.s3.CreateUpload(ctx)
+}
+
+func (t *s3Target) Sequential(session string) bool {
+	return false
+}
+
+func (t *s3Target) UploadPart(ctx context.Context, session string, p Part, src io.ReaderAt, progress func(int64)) (string, error) {
+	// The signature covers the payload hash, so the part is read twice
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(src, p.Start, p.Size)); err != nil {
+		return "", err
+	}
+	return t.s3.UploadPart(ctx, session, p.Index+1, partReader(src, p.Start, p.Size, progress), p.Size, hex.EncodeToString(h.Sum(nil)))
+}
+
+func (t *s3Target) Complete(ctx context.Context, session string, tokens []string) error {
+	return t.s3.CompleteUpload(ctx, session, tokens)
+}
@@ -0,0 +1,125 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redraw/rapel/internal/fsutil"
+	"github.com/redraw/rapel/internal/redact"
+)
+
+// State is the persisted progress of an upload, kept in
+// .{name}-upload.json next to where rapel runs. It identifies the source
+// file by size and modification time and the target by a fingerprint of
+// its URL, so a later run only resumes the same upload.
+//
+// The file is owner-only: Session may be an upload URL carrying a token.
+type State struct {
+	File       string         `json:"file"`
+	Target     string         `json:"target"`
+	TargetHash string         `json:"target_sha256"`
+	Size       int64          `json:"size"`
+	ModTime    time.Time      `json:"mod_time"`
+	ChunkSize  int64          `json:"chunk_size"`
+	Session    string         `json:"session,omitempty"`
+	Parts      map[int]string `json:"parts"` // part index -> token for Target.Complete
+
+	path    string
+	backups int
+}
+
+// StatePath returns the upload state filename for a file named name.
+func StatePath(name string) string {
+	return fmt.Sprintf(".%s-upload.json", name)
+}
+
+// LoadState loads the upload state for name, or returns (nil, nil) if
+// there is none.
+func LoadState(name string) (*State, error) {
+	path := StatePath(name)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: %w", err)
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse upload state: %w", err)
+	}
+	if s.Parts == nil {
+		s.Parts = make(map[int]string)
+	}
+	s.path = path
+	return &s, nil
+}
+
+// newState returns fresh state for uploading info's file to target.
+func newState(name, file, target string, info os.FileInfo, chunkSize int64) *State {
+	return &State{
+		File:       file,
+		Target:     redact.URL(target),
+		TargetHash: redact.Fingerprint(target),
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UTC(),
+		ChunkSize:  chunkSize,
+		Parts:      make(map[int]string),
+		path:       StatePath(name),
+	}
+}
+
+// Matches reports whether the state is for uploading info's file to target.
+func (s *State) Matches(target string, info os.FileInfo) bool {
+	return s.TargetHash == redact.Fingerprint(target) &&
+		s.Size == info.Size() &&
+		s.ModTime.Equal(info.ModTime().UTC())
+}
+
+// NumParts returns the number of parts the file is uploaded in.
+func (s *State) NumParts() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// PartRange returns the offset and length of part i.
+func (s *State) PartRange(i int) (start, size int64) {
+	start = int64(i) * s.ChunkSize
+	size = s.ChunkSize
+	if start+size > s.Size {
+		size = s.Size - start
+	}
+	return start, size
+}
+
+// KeepBackups makes Save keep n previous generations of the state file.
+func (s *State) KeepBackups(n int) {
+	s.backups = n
+}
+
+// Save writes the state atomically.
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+
+	if err := fsutil.RotateBackups(s.path, s.backups); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the state file and its backups.
+func (s *State) Delete() error {
+	fsutil.RemoveBackups(s.path)
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload state: %w", err)
+	}
+	return nil
+}
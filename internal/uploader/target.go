@@ -0,0 +1,148 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Target is where an upload goes. The file is sent in parts numbered from
+// 0, covering consecutive ranges.
+type Target interface {
+	// Start begins uploading f and returns the upload's session, an opaque
+	// string kept in the upload state (an upload ID or URL). A non-empty
+	// session from an earlier run is resumed instead.
+	Start(ctx context.Context, f File, session string) (string, error)
+
+	// Sequential reports whether the session's parts must be sent one at a
+	// time, in order.
+	Sequential(session string) bool
+
+	// UploadPart sends part p, read from src, and returns a token that
+	// Complete needs (such as an ETag). progress is called with the number
+	// of bytes sent as the body goes out.
+	UploadPart(ctx context.Context, session string, p Part, src io.ReaderAt, progress func(int64)) (string, error)
+
+	// Complete finishes the upload given the tokens of all parts, in order.
+	Complete(ctx context.Context, session string, tokens []string) error
+}
+
+// File describes the file being uploaded.
+type File struct {
+	Name      string // base name, for targets that record one
+	Size      int64
+	ChunkSize int64
+}
+
+// Part is one range of the file.
+type Part struct {
+	Index int
+	Start int64 // offset in the file
+	Size  int64
+	Total int64 // size of the whole file
+}
+
+// End returns the offset of the part's last byte.
+func (p Part) End() int64 {
+	return p.Start + p.Size - 1
+}
+
+// NewTarget returns the target for rawURL:
+//
+//	s3://bucket/key        S3 multipart upload
+//	tus+https://host/path  tus.io resumable upload, created under the endpoint
+//	https://host/path      PUT of each part with a Content-Range header
+func NewTarget(rawURL string, client *http.Client) (Target, error) {
+	switch {
+	case strings.HasPrefix(rawURL, "s3://"):
+		return newS3Target(rawURL, client)
+	case strings.HasPrefix(rawURL, "tus+http://"), strings.HasPrefix(rawURL, "tus+https://"):
+		return &tusTarget{endpoint: strings.TrimPrefix(rawURL, "tus+"), client: client}, nil
+	case strings.HasPrefix(rawURL, "http://"), strings.HasPrefix(rawURL, "https://"):
+		return &putTarget{url: rawURL, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported upload target %q (supported: s3://, tus+https://, https://)", rawURL)
+	}
+}
+
+// putTarget PUTs each part to the same URL with a Content-Range header,
+// as WebDAV servers with partial-update support and many upload APIs
+// accept. Parts may arrive in any order.
+type putTarget struct {
+	url    string
+	client *http.Client
+}
+
+func (t *putTarget) Start(ctx context.Context, f File, session string) (string, error) {
+	return session, nil
+}
+
+func (t *putTarget) Sequential(session string) bool {
+	return false
+}
+
+func (t *putTarget) UploadPart(ctx context.Context, session string, p Part, src io.ReaderAt, progress func(int64)) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.url, partReader(src, p.Start, p.Size, progress))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = p.Size
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", p.Start, p.End(), p.Total))
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// 308 is how resumable upload APIs acknowledge a part before the last
+	if err := checkStatus(resp, http.StatusPermanentRedirect); err != nil {
+		return "", fmt.Errorf("PUT: %w", err)
+	}
+	return "", nil
+}
+
+func (t *putTarget) Complete(ctx context.Context, session string, tokens []string) error {
+	return nil
+}
+
+// partReader returns a reader for size bytes of src at start that
+// reports what is read to progress.
+func partReader(src io.ReaderAt, start, size int64, progress func(int64)) io.Reader {
+	return &progressReader{r: io.NewSectionReader(src, start, size), progress: progress}
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && r.progress != nil {
+		r.progress(int64(n))
+	}
+	return n, err
+}
+
+// checkStatus fails for responses other than 2xx or one of also, quoting
+// the start of the body.
+func checkStatus(resp *http.Response, also ...int) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	for _, code := range also {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
@@ -0,0 +1,231 @@
+package uploader
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// tusVersion is the tus protocol version spoken.
+const tusVersion = "1.0.0"
+
+// tusConcatenation is the session of an upload made of partial uploads
+// joined at the end, for servers with the concatenation extension.
+const tusConcatenation = "concatenation"
+
+// tusTarget uploads with the tus.io resumable upload protocol. If the
+// server supports the concatenation extension, every part is its own
+// partial upload, so parts go up in parallel and are joined by a final
+// upload. Otherwise the core protocol only allows appending, and the parts
+// are sent in order to a single upload.
+type tusTarget struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (t *tusTarget) Start(ctx context.Context, f File, session string) (string, error) {
+	if session == tusConcatenation {
+		return session, nil
+	}
+	if session != "" {
+		if _, err := t.offset(ctx, session); err != nil {
+			return "", fmt.Errorf("cannot resume tus upload %s: %w (use --force to start over)", session, err)
+		}
+		return session, nil
+	}
+
+	extensions, err := t.extensions(ctx)
+	if err != nil {
+		return "", err
+	}
+	if f.Size > f.ChunkSize && extensions["concatenation"] {
+		return tusConcatenation, nil
+	}
+
+	return t.create(ctx, f.Size, map[string]string{"filename": f.Name}, "")
+}
+
+func (t *tusTarget) Sequential(session string) bool {
+	return session != tusConcatenation
+}
+
+func (t *tusTarget) UploadPart(ctx context.Context, session string, p Part, src io.ReaderAt, progress func(int64)) (string, error) {
+	if session == tusConcatenation {
+		// A failed attempt leaves an abandoned partial upload behind,
+		// which the server expires
+		partial, err := t.create(ctx, p.Size, nil, "partial")
+		if err != nil {
+			return "", err
+		}
+		if err := t.patch(ctx, partial, 0, partReader(src, p.Start, p.Size, progress), p.Size); err != nil {
+			return "", err
+		}
+		return partial, nil
+	}
+
+	// Appending: continue after whatever the server already has
+	offset, err := t.offset(ctx, session)
+	if err != nil {
+		return "", err
+	}
+	end := p.Start + p.Size
+	switch {
+	case offset >= end:
+		return "", nil
+	case offset < p.Start:
+		return "", fmt.Errorf("tus upload holds %d bytes, part %d starts at %d", offset, p.Index, p.Start)
+	}
+	return "", t.patch(ctx, session, offset, partReader(src, offset, end-offset, progress), end-offset)
+}
+
+func (t *tusTarget) Complete(ctx context.Context, session string, tokens []string) error {
+	if session != tusConcatenation {
+		return nil
+	}
+
+	req, err := t.newRequest(ctx, http.MethodPost, t.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Upload-Concat", "final;"+strings.Join(tokens, " "))
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("tus concatenation: %w", err)
+	}
+	return nil
+}
+
+// extensions asks the server which protocol extensions it supports.
+func (t *tusTarget) extensions(ctx context.Context) (map[string]bool, error) {
+	req, err := t.newRequest(ctx, http.MethodOptions, t.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, fmt.Errorf("tus OPTIONS: %w", err)
+	}
+
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(resp.Header.Get("Tus-Extension"), ",") {
+		extensions[strings.TrimSpace(ext)] = true
+	}
+	return extensions, nil
+}
+
+// create creates an upload of size bytes and returns its URL. concat is
+// the Upload-Concat value, if any.
+func (t *tusTarget) create(ctx context.Context, size int64, metadata map[string]string, concat string) (string, error) {
+	req, err := t.newRequest(ctx, http.MethodPost, t.endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	if concat != "" {
+		req.Header.Set("Upload-Concat", concat)
+	}
+	var pairs []string
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	if len(pairs) > 0 {
+		req.Header.Set("Upload-Metadata", strings.Join(pairs, ","))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", fmt.Errorf("tus create: %w", err)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("tus create: no upload URL in response")
+	}
+	return location.String(), nil
+}
+
+// offset returns how many bytes of the upload at uploadURL the server has.
+func (t *tusTarget) offset(ctx context.Context, uploadURL string) (int64, error) {
+	req, err := t.newRequest(ctx, http.MethodHead, uploadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	// The offset changes with every PATCH
+	req.Header.Set("Cache-Control", "no-store")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
+
+// patch appends size bytes from body at offset.
+func (t *tusTarget) patch(ctx context.Context, uploadURL string, offset int64, body io.Reader, size int64) error {
+	req, err := t.newRequest(ctx, http.MethodPatch, uploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("tus PATCH: %w", err)
+	}
+
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.FormatInt(offset+size, 10) {
+		return fmt.Errorf("tus PATCH: server is at offset %s, expected %d", got, offset+size)
+	}
+	return nil
+}
+
+// newRequest builds a request carrying the protocol version header.
+// Relative upload URLs are resolved against the endpoint.
+func (t *tusTarget) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	base, err := url.Parse(t.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tus endpoint: %w", err)
+	}
+	ref, err := base.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tus upload URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, ref.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req, nil
+}
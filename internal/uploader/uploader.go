@@ -0,0 +1,275 @@
+// Package uploader sends a local file to a remote target in parts,
+// several at a time, keeping resume state like a download does.
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/logging"
+	"github.com/redraw/rapel/internal/redact"
+	"github.com/redraw/rapel/internal/registry"
+)
+
+// Config holds uploader configuration
+type Config struct {
+	File         string // local file to upload
+	TargetURL    string // where it goes; identifies the upload in the state file
+	Target       Target
+	ChunkSize    int64
+	Jobs         int
+	Retries      int  // retries per part
+	Force        bool // discard existing upload state and start over
+	StateBackups int  // previous generations of the state file to keep
+}
+
+// Uploader uploads one file.
+type Uploader struct {
+	config   Config
+	file     *os.File
+	state    *State
+	stateMu  sync.Mutex
+	progress *downloader.ProgressTracker
+}
+
+// New creates an Uploader.
+func New(config Config) *Uploader {
+	if config.Jobs < 1 {
+		config.Jobs = 1
+	}
+	return &Uploader{config: config}
+}
+
+// Upload uploads the file, resuming an earlier interrupted upload of the
+// same file to the same target.
+func (u *Uploader) Upload(ctx context.Context) error {
+	f, err := os.Open(u.config.File)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	u.file = f
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return fmt.Errorf("%s is not a regular, non-empty file", u.config.File)
+	}
+
+	// The state lives next to download state, but under its own lock
+	name := filepath.Base(u.config.File)
+	lock, err := registry.Acquire(registry.LockPath(name + "-upload"))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := u.loadState(name, info); err != nil {
+		return err
+	}
+
+	session, err := u.config.Target.Start(ctx, File{Name: name, Size: u.state.Size, ChunkSize: u.state.ChunkSize}, u.state.Session)
+	if err != nil {
+		return err
+	}
+	u.state.Session = session
+	if err := u.state.Save(); err != nil {
+		return err
+	}
+
+	n := u.state.NumParts()
+	u.progress = downloader.NewProgressTracker(downloader.NewDownloadArguments(u.config.TargetURL, u.state.Size, u.state.ChunkSize, name))
+	u.progress.SetAction("Upload")
+	for i := range n {
+		if _, done := u.state.Parts[i]; done {
+			u.progress.MarkComplete(i)
+		}
+	}
+
+	jobs := u.config.Jobs
+	if u.config.Target.Sequential(session) {
+		jobs = 1
+	}
+
+	safeTarget := redact.URL(u.config.TargetURL)
+	slog.Info("File       : "+u.config.File, "file", u.config.File)
+	slog.Info("Target     : "+safeTarget, "target", safeTarget)
+	slog.Info("Size       : "+formatSize(u.state.Size), "bytes", u.state.Size)
+	slog.Info("Chunk size : "+formatSize(u.state.ChunkSize), "chunk_size", u.state.ChunkSize)
+	slog.Info(fmt.Sprintf("Chunks     : %d (%d done)", n, u.progress.CompletedCount()), "chunks", n, "completed", u.progress.CompletedCount())
+	slog.Info(fmt.Sprintf("Jobs       : %d", jobs), "jobs", jobs)
+	logging.Blank()
+
+	if err := u.uploadParts(ctx, jobs); err != nil {
+		return err
+	}
+
+	// Parts read from a file that changed don't add up to any version of it
+	if now, err := os.Stat(u.config.File); err != nil || now.Size() != info.Size() || !now.ModTime().Equal(info.ModTime()) {
+		return fmt.Errorf("%s changed during the upload, use --force to upload it again", u.config.File)
+	}
+
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = u.state.Parts[i]
+	}
+	if err := u.config.Target.Complete(ctx, session, tokens); err != nil {
+		return fmt.Errorf("failed to complete upload: %w", err)
+	}
+
+	u.progress.PrintComplete()
+	return u.state.Delete()
+}
+
+// loadState resumes the saved state for name or starts fresh.
+func (u *Uploader) loadState(name string, info os.FileInfo) error {
+	state, err := LoadState(name)
+	if err != nil && !u.config.Force {
+		return err
+	}
+	if state != nil && !u.config.Force && !state.Matches(u.config.TargetURL, info) {
+		return fmt.Errorf("%s is for another file or target, use --force to start over", StatePath(name))
+	}
+	if state == nil || u.config.Force {
+		abs, err := filepath.Abs(u.config.File)
+		if err != nil {
+			return err
+		}
+		state = newState(name, abs, u.config.TargetURL, info, u.config.ChunkSize)
+	}
+	state.KeepBackups(u.config.StateBackups)
+	u.state = state
+	return nil
+}
+
+// uploadParts uploads the missing parts, jobs at a time, in order.
+func (u *Uploader) uploadParts(ctx context.Context, jobs int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := u.uploadPart(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("chunk %d: %w", i, err)
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range u.state.NumParts() {
+		if u.progress.IsChunkComplete(i) {
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// uploadPart uploads part i with retries and records its token.
+func (u *Uploader) uploadPart(ctx context.Context, i int) error {
+	start, size := u.state.PartRange(i)
+	part := Part{Index: i, Start: start, Size: size, Total: u.state.Size}
+
+	u.progress.SetActive(i, true)
+	defer u.progress.SetActive(i, false)
+
+	var lastErr error
+	for attempt := 0; attempt <= u.config.Retries; attempt++ {
+		if attempt > 0 {
+			u.progress.AddRetry(i)
+			u.progress.PrintMessage("chunk %d: %v, retrying (%d/%d)", i, lastErr, attempt, u.config.Retries)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(attempt)):
+			}
+		}
+
+		// A retry sends the part again from the start
+		u.progress.SeedChunk(i, 0)
+		token, err := u.config.Target.UploadPart(ctx, u.state.Session, part, u.file, func(n int64) {
+			u.progress.AddBytes(i, n)
+			u.progress.PrintProgress(i)
+		})
+		if err == nil {
+			return u.finishPart(i, token)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("failed after %d retries: %w", u.config.Retries, lastErr)
+}
+
+// finishPart records part i as uploaded.
+func (u *Uploader) finishPart(i int, token string) error {
+	u.stateMu.Lock()
+	u.state.Parts[i] = token
+	err := u.state.Save()
+	u.stateMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	u.progress.MarkComplete(i)
+	u.progress.PrintChunkComplete(i)
+	return nil
+}
+
+// retryBase is the wait before the first retry of a part.
+var retryBase = 2 * time.Second
+
+// backoff returns the wait before retry attempt: 2s, 4s, 8s, ... up to 60s.
+func backoff(attempt int) time.Duration {
+	d := retryBase << (attempt - 1)
+	if attempt > 5 || d > time.Minute {
+		return time.Minute
+	}
+	return d
+}
+
+// formatSize formats n bytes with a decimal unit.
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
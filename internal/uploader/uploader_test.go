@@ -0,0 +1,227 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putServer accepts Content-Range PUTs into one buffer.
+type putServer struct {
+	mu    sync.Mutex
+	data  []byte
+	puts  int
+	fails func(start int64) bool
+}
+
+func (s *putServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var start, end, total int64
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		http.Error(w, "bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.fails != nil && s.fails(start) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+		return
+	}
+	if s.data == nil {
+		s.data = make([]byte, total)
+	}
+	copy(s.data[start:], body)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusServer implements enough of tus.io for the tests: creation, HEAD,
+// PATCH and optionally concatenation.
+type tusServer struct {
+	concat bool
+
+	mu      sync.Mutex
+	uploads map[string][]byte
+	final   []byte
+}
+
+func (s *tusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uploads == nil {
+		s.uploads = make(map[string][]byte)
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	switch r.Method {
+	case http.MethodOptions:
+		if s.concat {
+			w.Header().Set("Tus-Extension", "creation,concatenation")
+		} else {
+			w.Header().Set("Tus-Extension", "creation")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		if concat := r.Header.Get("Upload-Concat"); strings.HasPrefix(concat, "final;") {
+			for _, u := range strings.Fields(strings.TrimPrefix(concat, "final;")) {
+				path := u[strings.Index(u, "/files/"):]
+				s.final = append(s.final, s.uploads[path]...)
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		id := fmt.Sprintf("/files/%d", len(s.uploads))
+		s.uploads[id] = nil
+		w.Header().Set("Location", id)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead:
+		data, ok := s.uploads[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(data)))
+	case http.MethodPatch:
+		data := s.uploads[r.URL.Path]
+		if r.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.uploads[r.URL.Path] = append(data, body...)
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.uploads[r.URL.Path])))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeFile creates a file of n distinct-ish bytes in the current directory.
+func writeFile(t *testing.T, name string, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	require.NoError(t, os.WriteFile(name, data, 0644))
+	return data
+}
+
+func upload(t *testing.T, targetURL string, retries int) error {
+	t.Helper()
+	target, err := NewTarget(targetURL, http.DefaultClient)
+	require.NoError(t, err)
+	return New(Config{
+		File:      "f.bin",
+		TargetURL: targetURL,
+		Target:    target,
+		ChunkSize: 100,
+		Jobs:      3,
+		Retries:   retries,
+	}).Upload(context.Background())
+}
+
+func TestUploadPut(t *testing.T) {
+	t.Chdir(t.TempDir())
+	retryBase = time.Millisecond
+	data := writeFile(t, "f.bin", 250)
+
+	srv := &putServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	require.NoError(t, upload(t, ts.URL+"/f.bin", 0))
+	assert.Equal(t, data, srv.data)
+	assert.Equal(t, 3, srv.puts)
+	assert.NoFileExists(t, StatePath("f.bin"))
+}
+
+func TestUploadResume(t *testing.T) {
+	t.Chdir(t.TempDir())
+	retryBase = time.Millisecond
+	data := writeFile(t, "f.bin", 250)
+
+	// Part 1 keeps failing: the others are kept in the state
+	srv := &putServer{fails: func(start int64) bool { return start == 100 }}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	err := upload(t, ts.URL+"/f.bin", 1)
+	assert.ErrorContains(t, err, "chunk 1")
+	state, err := LoadState("f.bin")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.NotContains(t, state.Parts, 1)
+
+	// Once the server recovers, only what's missing is sent
+	srv.mu.Lock()
+	srv.fails, srv.puts = nil, 0
+	srv.mu.Unlock()
+	require.NoError(t, upload(t, ts.URL+"/f.bin", 0))
+	assert.Equal(t, 3-len(state.Parts), srv.puts)
+	assert.Equal(t, data, srv.data)
+
+	// State for another target isn't resumed
+	require.NoError(t, state.Save())
+	err = upload(t, ts.URL+"/other.bin", 0)
+	assert.ErrorContains(t, err, "--force")
+}
+
+func TestUploadTus(t *testing.T) {
+	for _, concat := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concat=%v", concat), func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			data := writeFile(t, "f.bin", 250)
+
+			srv := &tusServer{concat: concat}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			require.NoError(t, upload(t, "tus+"+ts.URL+"/files/", 0))
+			if concat {
+				assert.Len(t, srv.uploads, 3)
+				assert.Equal(t, data, srv.final)
+			} else {
+				require.Len(t, srv.uploads, 1)
+				assert.Equal(t, data, srv.uploads["/files/0"])
+			}
+		})
+	}
+}
+
+func TestUploadTusResumesOffset(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := writeFile(t, "f.bin", 250)
+
+	srv := &tusServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// An earlier run created the upload and got 130 bytes through
+	target, err := NewTarget("tus+"+ts.URL+"/files/", http.DefaultClient)
+	require.NoError(t, err)
+	session, err := target.Start(context.Background(), File{Name: "f.bin", Size: 250, ChunkSize: 100}, "")
+	require.NoError(t, err)
+	srv.uploads["/files/0"] = bytes.Clone(data[:130])
+
+	info, err := os.Stat("f.bin")
+	require.NoError(t, err)
+	state := newState("f.bin", "f.bin", "tus+"+ts.URL+"/files/", info, 100)
+	state.Session = session
+	state.Parts[0] = ""
+	require.NoError(t, state.Save())
+
+	require.NoError(t, upload(t, "tus+"+ts.URL+"/files/", 0))
+	assert.Equal(t, data, srv.uploads["/files/0"])
+}
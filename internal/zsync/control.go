@@ -0,0 +1,181 @@
+// Package zsync reads zsync control files and works out which blocks of a
+// remote file a local copy already holds, so only the rest is downloaded.
+package zsync
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Control is a parsed .zsync control file: the target file's size and
+// SHA-1, and a weak and a strong checksum for each block.
+type Control struct {
+	Filename  string
+	URL       string // may be relative to the control file's URL
+	Length    int64
+	BlockSize int
+	SHA1      string // hex, lowercase
+
+	SeqMatches    int // consecutive blocks that must match together
+	RsumBytes     int // bytes of the rolling checksum kept per block
+	ChecksumBytes int // bytes of the MD4 checksum kept per block
+
+	Blocks []Block
+}
+
+// Block holds the checksums of one block of the target file. The last
+// block is checksummed as if zero-padded to the block size.
+type Block struct {
+	Rsum     uint32 // rolling checksum, masked to RsumBytes
+	Checksum []byte // leading ChecksumBytes of the block's MD4
+}
+
+// BlockCount returns the number of blocks in a file of length bytes.
+func BlockCount(length int64, blockSize int) int {
+	return int((length + int64(blockSize) - 1) / int64(blockSize))
+}
+
+// Parse reads a control file as written by zsyncmake.
+func Parse(r io.Reader) (*Control, error) {
+	br := bufio.NewReader(r)
+	c := &Control{SeqMatches: 1, RsumBytes: 4, ChecksumBytes: 16}
+
+	sawVersion := false
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("control file ends before the checksums: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed control file header %q", line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "zsync":
+			sawVersion = true
+		case "Filename":
+			c.Filename = value
+		case "URL":
+			if c.URL == "" {
+				c.URL = value
+			}
+		case "Length":
+			c.Length, err = strconv.ParseInt(value, 10, 64)
+		case "Blocksize":
+			c.BlockSize, err = strconv.Atoi(value)
+		case "SHA-1":
+			c.SHA1 = strings.ToLower(value)
+		case "Hash-Lengths":
+			err = c.parseHashLengths(value)
+		case "Z-URL", "Z-Map2", "Recompress":
+			return nil, fmt.Errorf("compressed zsync targets (%s) are not supported", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header %q: %w", key, value, err)
+		}
+	}
+
+	if !sawVersion {
+		return nil, fmt.Errorf("not a zsync control file")
+	}
+	if c.Length < 0 || c.BlockSize <= 0 {
+		return nil, fmt.Errorf("control file has no valid Length and Blocksize")
+	}
+	if c.SHA1 != "" {
+		if b, err := hex.DecodeString(c.SHA1); err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid SHA-1 header %q", c.SHA1)
+		}
+	}
+
+	c.Blocks = make([]Block, BlockCount(c.Length, c.BlockSize))
+	entry := make([]byte, c.RsumBytes+c.ChecksumBytes)
+	for i := range c.Blocks {
+		if _, err := io.ReadFull(br, entry); err != nil {
+			return nil, fmt.Errorf("control file is truncated at block %d of %d: %w", i, len(c.Blocks), err)
+		}
+		var rsum uint32
+		for _, b := range entry[:c.RsumBytes] {
+			rsum = rsum<<8 | uint32(b)
+		}
+		c.Blocks[i] = Block{
+			Rsum:     rsum,
+			Checksum: append([]byte(nil), entry[c.RsumBytes:]...),
+		}
+	}
+	return c, nil
+}
+
+func (c *Control) parseHashLengths(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("want seq_matches,rsum_bytes,checksum_bytes")
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return err
+		}
+		nums[i] = n
+	}
+	c.SeqMatches, c.RsumBytes, c.ChecksumBytes = nums[0], nums[1], nums[2]
+	if c.SeqMatches < 1 || c.SeqMatches > 2 || c.RsumBytes < 1 || c.RsumBytes > 4 || c.ChecksumBytes < 3 || c.ChecksumBytes > 16 {
+		return fmt.Errorf("out of range")
+	}
+	return nil
+}
+
+// rsumMask keeps the bytes of a rolling checksum the control file stores.
+func (c *Control) rsumMask() uint32 {
+	if c.RsumBytes >= 4 {
+		return 0xffffffff
+	}
+	return 1<<(8*c.RsumBytes) - 1
+}
+
+// BlockRange returns the byte range [start, end) block i covers in the
+// target file.
+func (c *Control) BlockRange(i int) (start, end int64) {
+	start = int64(i) * int64(c.BlockSize)
+	end = start + int64(c.BlockSize)
+	if end > c.Length {
+		end = c.Length
+	}
+	return start, end
+}
+
+// VerifyBlock reports whether data, the content of block i, matches the
+// block's checksums.
+func (c *Control) VerifyBlock(i int, data []byte) bool {
+	if len(data) < c.BlockSize {
+		padded := make([]byte, c.BlockSize)
+		copy(padded, data)
+		data = padded
+	}
+	if rsumOf(data)&c.rsumMask() != c.Blocks[i].Rsum {
+		return false
+	}
+	sum := md4Sum(data)
+	return string(sum[:c.ChecksumBytes]) == string(c.Blocks[i].Checksum)
+}
+
+// rsumOf computes zsync's rolling checksum of a whole block: a is the sum
+// of its bytes and b weights each byte by its distance from the end.
+func rsumOf(data []byte) uint32 {
+	var a, b uint16
+	for i, c := range data {
+		a += uint16(c)
+		b += uint16(len(data)-i) * uint16(c)
+	}
+	return uint32(a)<<16 | uint32(b)
+}
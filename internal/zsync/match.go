@@ -0,0 +1,248 @@
+package zsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Plan says where each block of the target file comes from.
+type Plan struct {
+	Control *Control
+	// Source holds, for each block, the offset of identical data in the
+	// local file, or -1 if the block has to be downloaded.
+	Source []int64
+}
+
+// Span is a run of consecutive blocks, First through Last.
+type Span struct {
+	First, Last int
+}
+
+// readBuffer is how much of the local file Match reads at a time.
+const readBuffer = 4 << 20
+
+// Match scans the local file for blocks of the target. Like rsync, it
+// slides a block-sized window over every byte offset, so data that moved
+// in the new version is found too, not only data that stayed in place.
+func Match(local io.Reader, c *Control) (*Plan, error) {
+	p := &Plan{Control: c, Source: make([]int64, len(c.Blocks))}
+	for i := range p.Source {
+		p.Source[i] = -1
+	}
+	if len(c.Blocks) == 0 {
+		return p, nil
+	}
+
+	idx := newBlockIndex(c)
+	bs := c.BlockSize
+	seq := c.SeqMatches > 1
+	mask := c.rsumMask()
+
+	w := &window{r: local}
+	var a0, b0, a1, b1 uint16 // rolling sums of [pos, pos+bs) and [pos+bs, pos+2bs)
+	stale := true
+	remaining := len(c.Blocks)
+
+	for pos := int64(0); remaining > 0; {
+		if err := w.fill(pos, 2*bs+1); err != nil {
+			return nil, err
+		}
+		if w.eof && pos >= w.size {
+			break
+		}
+		if stale {
+			a0, b0 = sums(w.at(pos, bs))
+			a1, b1 = sums(w.at(pos+int64(bs), bs))
+			stale = false
+		}
+
+		if matched := p.matchAt(w, idx, pos, (uint32(a0)<<16|uint32(b0))&mask, (uint32(a1)<<16|uint32(b1))&mask, seq); matched > 0 {
+			remaining -= matched
+			pos += int64(bs)
+			stale = true
+			continue
+		}
+
+		// Slide both windows one byte
+		out0, in0, in1 := w.at(pos, 1)[0], w.at(pos+int64(bs), 1)[0], w.at(pos+int64(2*bs), 1)[0]
+		a0 += uint16(in0) - uint16(out0)
+		b0 += a0 - uint16(bs)*uint16(out0)
+		a1 += uint16(in1) - uint16(in0)
+		b1 += a1 - uint16(bs)*uint16(in0)
+		pos++
+	}
+	return p, nil
+}
+
+// matchAt checks the windows at pos against the blocks whose weak checksum
+// is rsum0, and records every block the first window holds. With seq set,
+// a block also needs its successor to match the second window, as zsync's
+// short checksums are only trustworthy in pairs. It returns the number of
+// blocks newly found.
+func (p *Plan) matchAt(w *window, idx *blockIndex, pos int64, rsum0, rsum1 uint32, seq bool) int {
+	if !idx.mayHave(rsum0) {
+		return 0
+	}
+	c := p.Control
+	bs := int64(c.BlockSize)
+	n := len(c.Blocks)
+
+	var sum0, sum1 *[16]byte
+	found := 0
+	for _, i := range idx.blocks[rsum0] {
+		next := seq && i+1 < n
+		if next && c.Blocks[i+1].Rsum != rsum1 {
+			continue
+		}
+		if sum0 == nil {
+			s := md4Sum(w.at(pos, c.BlockSize))
+			sum0 = &s
+		}
+		if string(sum0[:c.ChecksumBytes]) != string(c.Blocks[i].Checksum) {
+			continue
+		}
+		if next {
+			if sum1 == nil {
+				s := md4Sum(w.at(pos+bs, c.BlockSize))
+				sum1 = &s
+			}
+			if string(sum1[:c.ChecksumBytes]) != string(c.Blocks[i+1].Checksum) {
+				continue
+			}
+			if p.Source[i+1] < 0 {
+				p.Source[i+1] = pos + bs
+				found++
+			}
+		}
+		if p.Source[i] < 0 {
+			p.Source[i] = pos
+			found++
+		}
+	}
+	return found
+}
+
+// Missing returns the runs of blocks that have to be downloaded, each at
+// most maxBlocks long.
+func (p *Plan) Missing(maxBlocks int) []Span {
+	var spans []Span
+	for i := 0; i < len(p.Source); i++ {
+		if p.Source[i] >= 0 {
+			continue
+		}
+		s := Span{First: i, Last: i}
+		for s.Last+1 < len(p.Source) && p.Source[s.Last+1] < 0 && s.Last-s.First+1 < maxBlocks {
+			s.Last++
+		}
+		spans = append(spans, s)
+		i = s.Last
+	}
+	return spans
+}
+
+// Reused returns how many bytes of the target the local file provides.
+func (p *Plan) Reused() int64 {
+	var n int64
+	for i, src := range p.Source {
+		if src >= 0 {
+			start, end := p.Control.BlockRange(i)
+			n += end - start
+		}
+	}
+	return n
+}
+
+// InPlace reports whether every reused block already sits at its target
+// offset, so the local file can be patched without a second copy.
+func (p *Plan) InPlace() bool {
+	for i, src := range p.Source {
+		if src >= 0 && src != int64(i)*int64(p.Control.BlockSize) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockIndex looks blocks up by weak checksum. A bitmap in front of the
+// map keeps the per-byte lookup cheap when nothing matches.
+type blockIndex struct {
+	blocks map[uint32][]int
+	bitmap []uint64
+}
+
+const bitmapBits = 1 << 20
+
+func newBlockIndex(c *Control) *blockIndex {
+	idx := &blockIndex{
+		blocks: make(map[uint32][]int, len(c.Blocks)),
+		bitmap: make([]uint64, bitmapBits/64),
+	}
+	for i, b := range c.Blocks {
+		idx.blocks[b.Rsum] = append(idx.blocks[b.Rsum], i)
+		h := bitmapSlot(b.Rsum)
+		idx.bitmap[h/64] |= 1 << (h % 64)
+	}
+	return idx
+}
+
+func (idx *blockIndex) mayHave(rsum uint32) bool {
+	h := bitmapSlot(rsum)
+	return idx.bitmap[h/64]&(1<<(h%64)) != 0
+}
+
+func bitmapSlot(rsum uint32) uint32 {
+	return (rsum * 2654435761) >> 12 % bitmapBits
+}
+
+// sums returns the two halves of the rolling checksum of data.
+func sums(data []byte) (a, b uint16) {
+	r := rsumOf(data)
+	return uint16(r >> 16), uint16(r)
+}
+
+// window buffers the part of the local file being scanned. Past the end
+// of the file it reads as zeros, the padding zsync gives the last block.
+type window struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // file offset of buf[0]
+	eof  bool
+	size int64 // file size, known once eof is set
+}
+
+// fill makes [pos, pos+n) available.
+func (w *window) fill(pos int64, n int) error {
+	if need := pos + int64(n); w.base+int64(len(w.buf)) >= need {
+		return nil
+	}
+	// Drop what's behind pos
+	if drop := pos - w.base; drop > 0 {
+		w.buf = append(w.buf[:0], w.buf[drop:]...)
+		w.base = pos
+	}
+	for !w.eof && len(w.buf) < n {
+		old := len(w.buf)
+		grow := max(n, readBuffer)
+		w.buf = slices.Grow(w.buf, grow)[:old+grow]
+		m, err := io.ReadAtLeast(w.r, w.buf[old:], 1)
+		w.buf = w.buf[:old+m]
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			w.eof = true
+			w.size = w.base + int64(len(w.buf))
+		} else if err != nil {
+			return fmt.Errorf("failed to read local file: %w", err)
+		}
+	}
+	if len(w.buf) < n {
+		w.buf = append(w.buf, make([]byte, n-len(w.buf))...)
+	}
+	return nil
+}
+
+// at returns n bytes at pos, which fill must have made available.
+func (w *window) at(pos int64, n int) []byte {
+	off := pos - w.base
+	return w.buf[off : off+int64(n)]
+}
@@ -0,0 +1,60 @@
+package zsync
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// md4Sum returns the MD4 digest (RFC 1320) of data. zsync uses MD4 for
+// its per-block checksums; it is only used to match blocks, never for
+// security.
+func md4Sum(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	n := len(data)
+	msg := make([]byte, (n+8)/64*64+64)
+	copy(msg, data)
+	msg[n] = 0x80
+	binary.LittleEndian.PutUint64(msg[len(msg)-8:], uint64(n)*8)
+
+	var x [16]uint32
+	for off := 0; off < len(msg); off += 64 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[off+4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+
+		// Round 1
+		for _, k := range [4]int{0, 4, 8, 12} {
+			a = bits.RotateLeft32(a+((b&c)|(^b&d))+x[k], 3)
+			d = bits.RotateLeft32(d+((a&b)|(^a&c))+x[k+1], 7)
+			c = bits.RotateLeft32(c+((d&a)|(^d&b))+x[k+2], 11)
+			b = bits.RotateLeft32(b+((c&d)|(^c&a))+x[k+3], 19)
+		}
+
+		// Round 2
+		for _, k := range [4]int{0, 1, 2, 3} {
+			a = bits.RotateLeft32(a+((b&c)|(b&d)|(c&d))+x[k]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+((a&b)|(a&c)|(b&c))+x[k+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+((d&a)|(d&b)|(a&b))+x[k+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+((c&d)|(c&a)|(d&a))+x[k+12]+0x5a827999, 13)
+		}
+
+		// Round 3
+		for _, k := range [4]int{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+(b^c^d)+x[k]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+(a^b^c)+x[k+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+(d^a^b)+x[k+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+(c^d^a)+x[k+12]+0x6ed9eba1, 15)
+		}
+
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
@@ -0,0 +1,215 @@
+package zsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redraw/rapel/internal/failure"
+)
+
+// FetchFunc writes bytes start through end (inclusive) of the target to w.
+type FetchFunc func(ctx context.Context, start, end int64, w io.Writer) error
+
+// ApplyOptions controls how a Plan is carried out.
+type ApplyOptions struct {
+	Fetch    FetchFunc
+	Jobs     int   // concurrent range requests (0 = 1)
+	MaxSpan  int64 // largest range fetched at once in bytes (0 = 8MB)
+	Retries  int   // attempts per range after the first
+	Progress func(n int64)
+}
+
+// errBadBlock means the server sent a block that doesn't match the control
+// file. Retrying won't help: the control file is for another version.
+var errBadBlock = errors.New("doesn't match the control file (is the .zsync file stale?)")
+
+// Apply turns the file at path into the target. When the reused blocks
+// are all in place, the missing ones are written straight into the file;
+// otherwise the target is assembled next to it and renamed over it. Every
+// downloaded block is checked against the control file before it's
+// written, and the result against the SHA-1, if the control file has one.
+func (p *Plan) Apply(ctx context.Context, path string, opts ApplyOptions) error {
+	if opts.Jobs <= 0 {
+		opts.Jobs = 1
+	}
+	if opts.MaxSpan <= 0 {
+		opts.MaxSpan = 8 << 20
+	}
+
+	if p.InPlace() {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return p.finish(ctx, f, opts)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".zsync-part"
+	dst, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	defer func() {
+		dst.Close()
+		os.Remove(tmpPath)
+	}()
+
+	if err := p.copyReused(src, dst); err != nil {
+		return err
+	}
+	if err := p.finish(ctx, dst, opts); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if info, err := src.Stat(); err == nil {
+		os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// copyReused copies the blocks the local file provides into dst.
+func (p *Plan) copyReused(src io.ReaderAt, dst io.WriterAt) error {
+	buf := make([]byte, p.Control.BlockSize)
+	for i, off := range p.Source {
+		if off < 0 {
+			continue
+		}
+		start, end := p.Control.BlockRange(i)
+		data := buf[:end-start]
+		n, err := src.ReadAt(data, off)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read local block at %d: %w", off, err)
+		}
+		clear(data[n:]) // past the end of the local file reads as zeros
+		if _, err := dst.WriteAt(data, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish downloads the missing blocks into f, sizes it and checks it.
+func (p *Plan) finish(ctx context.Context, f *os.File, opts ApplyOptions) error {
+	if err := p.fetchMissing(ctx, f, opts); err != nil {
+		return err
+	}
+	if err := f.Truncate(p.Control.Length); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if p.Control.SHA1 == "" {
+		return nil
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, p.Control.Length)); err != nil {
+		return fmt.Errorf("failed to hash the result: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != p.Control.SHA1 {
+		return failure.Errorf(failure.ErrChecksum, "SHA-1 mismatch: got %s, control file says %s", got, p.Control.SHA1)
+	}
+	return nil
+}
+
+// fetchMissing downloads the missing spans with opts.Jobs workers.
+func (p *Plan) fetchMissing(ctx context.Context, f io.WriterAt, opts ApplyOptions) error {
+	maxBlocks := max(int(opts.MaxSpan/int64(p.Control.BlockSize)), 1)
+	spans := p.Missing(maxBlocks)
+	if len(spans) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	work := make(chan Span)
+	var wg sync.WaitGroup
+	for range min(opts.Jobs, len(spans)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range work {
+				if err := p.fetchSpan(ctx, f, s, opts); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, s := range spans {
+		select {
+		case work <- s:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// fetchSpan downloads one span, checks each of its blocks and writes it.
+func (p *Plan) fetchSpan(ctx context.Context, f io.WriterAt, s Span, opts ApplyOptions) error {
+	start, _ := p.Control.BlockRange(s.First)
+	_, end := p.Control.BlockRange(s.Last)
+
+	var buf bytes.Buffer
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			slog.Debug("Retrying range", "start", start, "end", end-1, "attempt", attempt, "error", err)
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+		}
+		buf.Reset()
+		if err = opts.Fetch(ctx, start, end-1, &buf); err == nil && int64(buf.Len()) != end-start {
+			err = fmt.Errorf("got %d bytes for a %d byte range", buf.Len(), end-start)
+		}
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch bytes %d-%d: %w", start, end-1, err)
+	}
+
+	data := buf.Bytes()
+	for i := s.First; i <= s.Last; i++ {
+		bStart, bEnd := p.Control.BlockRange(i)
+		if !p.Control.VerifyBlock(i, data[bStart-start:bEnd-start]) {
+			return fmt.Errorf("block %d from the server %w", i, errBadBlock)
+		}
+	}
+	if _, err := f.WriteAt(data, start); err != nil {
+		return err
+	}
+	if opts.Progress != nil {
+		opts.Progress(int64(len(data)))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package zsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeControl writes a control file for data the way zsyncmake does.
+func makeControl(data []byte, bs, seq, rsumBytes, checksumBytes int) []byte {
+	var out bytes.Buffer
+	sum := sha1.Sum(data)
+	fmt.Fprintf(&out, "zsync: 0.6.2\nFilename: f.bin\nBlocksize: %d\nLength: %d\nHash-Lengths: %d,%d,%d\nURL: f.bin\nSHA-1: %s\n\n",
+		bs, len(data), seq, rsumBytes, checksumBytes, hex.EncodeToString(sum[:]))
+	for i := 0; i < len(data); i += bs {
+		block := make([]byte, bs)
+		copy(block, data[i:])
+		var r [4]byte
+		rsum := rsumOf(block)
+		r[0], r[1], r[2], r[3] = byte(rsum>>24), byte(rsum>>16), byte(rsum>>8), byte(rsum)
+		out.Write(r[4-rsumBytes:])
+		md := md4Sum(block)
+		out.Write(md[:checksumBytes])
+	}
+	return out.Bytes()
+}
+
+func randomBytes(n int, seed int64) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestMD4(t *testing.T) {
+	for in, want := range map[string]string{
+		"":               "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc":            "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest": "d9130a8164549fe818874806e1c7014b",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		sum := md4Sum([]byte(in))
+		assert.Equal(t, want, hex.EncodeToString(sum[:]), "MD4(%q)", in)
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := randomBytes(5000, 1)
+	c, err := Parse(bytes.NewReader(makeControl(data, 1024, 2, 3, 5)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), c.Length)
+	assert.Equal(t, 1024, c.BlockSize)
+	assert.Equal(t, "f.bin", c.URL)
+	assert.Len(t, c.Blocks, 5)
+	assert.Equal(t, 2, c.SeqMatches)
+	for i := range c.Blocks {
+		start, end := c.BlockRange(i)
+		assert.True(t, c.VerifyBlock(i, data[start:end]), "block %d", i)
+	}
+	assert.False(t, c.VerifyBlock(0, data[1024:2048]))
+
+	_, err = Parse(bytes.NewReader([]byte("Length: 10\n\n")))
+	assert.ErrorContains(t, err, "not a zsync control file")
+	_, err = Parse(bytes.NewReader(makeControl(data, 1024, 1, 4, 16)[:200]))
+	assert.ErrorContains(t, err, "truncated")
+	_, err = Parse(bytes.NewReader([]byte("zsync: 0.6.2\nZ-Map2: 10\n\n")))
+	assert.ErrorContains(t, err, "not supported")
+}
+
+func TestMatch(t *testing.T) {
+	const bs = 512
+	target := randomBytes(20*bs+100, 2)
+
+	// The old version lacks a block-and-a-bit in the middle and has junk
+	// in front, so everything after the gap sits at another offset.
+	old := append(randomBytes(37, 3), target[:5*bs]...)
+	old = append(old, target[6*bs+200:]...)
+
+	for _, seq := range []int{1, 2} {
+		c, err := Parse(bytes.NewReader(makeControl(target, bs, seq, 4, 16)))
+		require.NoError(t, err)
+		plan, err := Match(bytes.NewReader(old), c)
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, int64(37+i*bs), plan.Source[i], "block %d", i)
+		}
+		assert.Equal(t, int64(-1), plan.Source[5])
+		assert.Equal(t, int64(-1), plan.Source[6])
+		for i := 7; i < len(plan.Source); i++ {
+			assert.GreaterOrEqual(t, plan.Source[i], int64(0), "block %d", i)
+		}
+		assert.Equal(t, []Span{{First: 5, Last: 6}}, plan.Missing(100))
+		assert.Equal(t, []Span{{First: 5, Last: 5}, {First: 6, Last: 6}}, plan.Missing(1))
+		assert.False(t, plan.InPlace())
+	}
+}
+
+func applyTest(t *testing.T, target, old []byte, bs int) (*Plan, []byte, int64) {
+	path := filepath.Join(t.TempDir(), "f.bin")
+	require.NoError(t, os.WriteFile(path, old, 0644))
+
+	c, err := Parse(bytes.NewReader(makeControl(target, bs, 2, 4, 8)))
+	require.NoError(t, err)
+	plan, err := Match(bytes.NewReader(old), c)
+	require.NoError(t, err)
+
+	var fetched int64
+	err = plan.Apply(context.Background(), path, ApplyOptions{
+		Jobs: 3,
+		Fetch: func(ctx context.Context, start, end int64, w io.Writer) error {
+			_, err := w.Write(target[start : end+1])
+			return err
+		},
+		Progress: func(n int64) { fetched += n },
+	})
+	require.NoError(t, err)
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NoFileExists(t, path+".zsync-part")
+	return plan, got, fetched
+}
+
+func TestApply(t *testing.T) {
+	const bs = 256
+	target := randomBytes(40*bs+17, 4)
+
+	t.Run("in place", func(t *testing.T) {
+		old := bytes.Clone(target[:30*bs])
+		copy(old[3*bs:], randomBytes(bs, 5))
+		plan, got, fetched := applyTest(t, target, old, bs)
+		assert.True(t, plan.InPlace())
+		assert.Equal(t, target, got)
+		assert.Equal(t, int64(len(target)-29*bs), fetched)
+	})
+
+	t.Run("shifted", func(t *testing.T) {
+		old := append([]byte("prefix"), target[bs:]...)
+		plan, got, fetched := applyTest(t, target, old, bs)
+		assert.False(t, plan.InPlace())
+		assert.Equal(t, target, got)
+		assert.Equal(t, int64(bs), fetched)
+	})
+
+	t.Run("longer local file", func(t *testing.T) {
+		old := append(bytes.Clone(target), randomBytes(3*bs, 6)...)
+		_, got, fetched := applyTest(t, target, old, bs)
+		assert.Equal(t, target, got)
+		// The short last block is checksummed zero-padded, which the
+		// local copy's extra bytes aren't
+		assert.Equal(t, int64(17), fetched)
+	})
+}
+
+func TestApplyRejectsStaleControl(t *testing.T) {
+	const bs = 256
+	target := randomBytes(8*bs, 7)
+	path := filepath.Join(t.TempDir(), "f.bin")
+	old := bytes.Clone(target)
+	copy(old[2*bs:], randomBytes(bs, 8))
+	require.NoError(t, os.WriteFile(path, old, 0644))
+
+	c, err := Parse(bytes.NewReader(makeControl(target, bs, 1, 4, 16)))
+	require.NoError(t, err)
+	plan, err := Match(bytes.NewReader(old), c)
+	require.NoError(t, err)
+
+	// The server has moved on to yet another version
+	newer := randomBytes(8*bs, 9)
+	err = plan.Apply(context.Background(), path, ApplyOptions{
+		Retries: 3,
+		Fetch: func(ctx context.Context, start, end int64, w io.Writer) error {
+			_, err := w.Write(newer[start : end+1])
+			return err
+		},
+	})
+	assert.ErrorContains(t, err, "block 2 from the server doesn't match the control file")
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, old, got, "the local file is left untouched")
+}
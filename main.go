@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/redraw/rapel/cmd"
+	"github.com/redraw/rapel/internal/failure"
+	"github.com/redraw/rapel/internal/logging"
 )
 
 const version = "1.0.0"
@@ -20,14 +23,107 @@ func main() {
 	switch subcommand {
 	case "download":
 		if err := cmd.DownloadCommand(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 	case "merge":
 		if err := cmd.MergeCommand(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
+		}
+
+	case "split":
+		if err := cmd.SplitCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "plan":
+		if err := cmd.PlanCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "inspect":
+		if err := cmd.InspectCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "status":
+		if err := cmd.StatusCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "clean":
+		if err := cmd.CleanCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "hooks":
+		if err := cmd.HooksCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "export":
+		if err := cmd.ExportCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "import":
+		if err := cmd.ImportCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "list":
+		if err := cmd.ListCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "ctl":
+		if err := cmd.CtlCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "stats":
+		if err := cmd.StatsCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "report":
+		if err := cmd.ReportCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "probe":
+		if err := cmd.ProbeCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "sample":
+		if err := cmd.SampleCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "audit":
+		if err := cmd.AuditCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "sync":
+		if err := cmd.SyncCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "upload":
+		if err := cmd.UploadCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "queue":
+		if err := cmd.QueueCommand(os.Args[2:]); err != nil {
+			fail(err)
+		}
+
+	case "recipe":
+		if err := cmd.RecipeCommand(os.Args[2:]); err != nil {
+			fail(err)
 		}
 
 	case "version", "--version", "-v":
@@ -43,6 +139,20 @@ func main() {
 	}
 }
 
+// fail prints err and exits with the status of its failure kind: 2 for a
+// usage error, as the flag package does, 1 for one of no particular kind.
+// With --log-format json the error is a JSON object naming the kind.
+func fail(err error) {
+	kind := failure.Classify(err)
+	if logging.JSON() {
+		line, _ := json.Marshal(map[string]any{"error": err.Error(), "error_kind": kind.Name, "exit_code": kind.Code})
+		fmt.Fprintf(os.Stderr, "%s\n", line)
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	os.Exit(kind.Code)
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `rapel - Chunked HTTP downloader with resume support
 
@@ -52,6 +162,25 @@ Usage:
 Commands:
   download    Download a file using chunked HTTP Range requests
   merge       Merge chunk files into a single file
+  split       Split a local file into chunk files (inverse of merge)
+  plan        Split a download into plans for several machines
+  inspect     Show the saved state of a download
+  status      Show a download's progress and failed post-part commands
+  clean       Remove what finished or abandoned downloads left behind
+  hooks       Run post-part commands for chunks downloaded earlier
+  export      Pack an unfinished download's state to continue it elsewhere
+  import      Unpack an exported download into a directory
+  list        List downloads recorded in the state registry
+  ctl         Change jobs or rate limit of a running download
+  stats       Show lifetime download statistics
+  report      Summarize a download's transfers: throughput, retries, slow chunks
+  probe       Benchmark mirrors with a sample range request
+  sample      Measure a server for a while and suggest download settings
+  audit       Check that offloaded parts arrived intact
+  sync        Update a local copy by downloading only the blocks that changed
+  upload      Upload a local file in parallel parts (S3, tus, Content-Range PUT)
+  queue       Queue downloads to run one after another or a few at a time
+  recipe      Show built-in workflows as ready-to-run commands
   version     Show version information
   help        Show this help message
 
@@ -0,0 +1,179 @@
+package schema_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateAPI = flag.Bool("update-api", false, "Record additions to the exported API in api.txt")
+
+// TestAPICompatible compares the package's exported API with api.txt, one
+// declaration per line. A line gone from the API breaks the programs that
+// use it; a new line is recorded with go test ./schema -update-api.
+func TestAPICompatible(t *testing.T) {
+	api := exportedAPI(t, ".")
+	data, err := os.ReadFile("api.txt")
+	require.NoError(t, err)
+	golden := strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' })
+
+	var removed, added []string
+	for _, line := range golden {
+		if !slices.Contains(api, line) {
+			removed = append(removed, line)
+		}
+	}
+	for _, line := range api {
+		if !slices.Contains(golden, line) {
+			added = append(added, line)
+		}
+	}
+	if len(removed) > 0 {
+		t.Errorf("incompatible API change, fields and declarations are only ever added; gone:\n%s", strings.Join(removed, "\n"))
+		return
+	}
+
+	if len(added) > 0 && *updateAPI {
+		require.NoError(t, os.WriteFile("api.txt", []byte(strings.Join(api, "\n")+"\n"), 0644))
+		return
+	}
+	if len(added) > 0 {
+		t.Errorf("api.txt is stale, run go test ./schema -update-api to record:\n%s", strings.Join(added, "\n"))
+	}
+}
+
+// exportedAPI lists the exported declarations of the package in dir, in
+// the format of Go's own api/*.txt files: parameter names and struct tags
+// left out, since changing them breaks no program.
+func exportedAPI(t *testing.T, dir string) []string {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+
+	var lines []string
+	for _, pkg := range pkgs {
+		expr := func(e ast.Expr) string {
+			var b bytes.Buffer
+			printer.Fprint(&b, fset, e)
+			return b.String()
+		}
+		add := func(format string, args ...any) {
+			lines = append(lines, fmt.Sprintf("pkg %s, ", pkg.Name)+fmt.Sprintf(format, args...))
+		}
+
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if !decl.Name.IsExported() {
+						continue
+					}
+					if decl.Recv == nil {
+						add("func %s%s", decl.Name, signature(expr, decl.Type))
+						continue
+					}
+					recv := expr(decl.Recv.List[0].Type)
+					if ast.IsExported(strings.TrimPrefix(recv, "*")) {
+						add("method (%s) %s%s", recv, decl.Name, signature(expr, decl.Type))
+					}
+
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.ValueSpec:
+							for i, name := range spec.Names {
+								if !name.IsExported() {
+									continue
+								}
+								kind := map[token.Token]string{token.CONST: "const", token.VAR: "var"}[decl.Tok]
+								switch {
+								case spec.Type != nil:
+									add("%s %s %s", kind, name, expr(spec.Type))
+								case decl.Tok == token.CONST && i < len(spec.Values):
+									add("%s %s = %s", kind, name, expr(spec.Values[i]))
+								default:
+									add("%s %s", kind, name)
+								}
+							}
+
+						case *ast.TypeSpec:
+							if !spec.Name.IsExported() {
+								continue
+							}
+							switch typ := spec.Type.(type) {
+							case *ast.StructType:
+								add("type %s struct", spec.Name)
+								for _, field := range typ.Fields.List {
+									if len(field.Names) == 0 {
+										if ast.IsExported(strings.TrimPrefix(expr(field.Type), "*")) {
+											add("type %s struct, embedded %s", spec.Name, expr(field.Type))
+										}
+										continue
+									}
+									for _, name := range field.Names {
+										if name.IsExported() {
+											add("type %s struct, %s %s", spec.Name, name, expr(field.Type))
+										}
+									}
+								}
+							case *ast.InterfaceType:
+								add("type %s interface", spec.Name)
+								for _, m := range typ.Methods.List {
+									for _, name := range m.Names {
+										add("type %s interface, %s%s", spec.Name, name, signature(expr, m.Type.(*ast.FuncType)))
+									}
+								}
+							default:
+								if spec.Assign.IsValid() {
+									add("type %s = %s", spec.Name, expr(spec.Type))
+								} else {
+									add("type %s %s", spec.Name, expr(spec.Type))
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// signature prints the parameter and result types of fn without names.
+func signature(expr func(ast.Expr) string, fn *ast.FuncType) string {
+	types := func(fields *ast.FieldList) []string {
+		var list []string
+		if fields == nil {
+			return nil
+		}
+		for _, f := range fields.List {
+			for range max(len(f.Names), 1) {
+				list = append(list, expr(f.Type))
+			}
+		}
+		return list
+	}
+	s := "(" + strings.Join(types(fn.Params), ", ") + ")"
+	switch results := types(fn.Results); len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
@@ -0,0 +1,313 @@
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxEventSize bounds the JSON of a single event.
+const MaxEventSize = 1 << 20
+
+// Event types, the "type" field of every event.
+const (
+	EventStart            = "start"
+	EventChunkStart       = "chunk_start"
+	EventChunkRetry       = "chunk_retry"
+	EventChunkComplete    = "chunk_complete"
+	EventChunkFailed      = "chunk_failed"
+	EventPostPartComplete = "post_part_complete"
+	EventPostPartFailed   = "post_part_failed"
+	EventPaused           = "paused"
+	EventResumed          = "resumed"
+	EventSettings         = "settings"
+	EventDeadlineAtRisk   = "deadline_at_risk"
+	EventDeadlineMissed   = "deadline_missed"
+	EventBanCooldown      = "ban_cooldown"
+	EventGrew             = "grew"
+	EventSingleStream     = "single_stream"
+	EventDiskBound        = "disk_bound"
+	EventDiskRecovered    = "disk_recovered"
+	EventDownloadComplete = "download_complete"
+	EventMergeStart       = "merge_start"
+	EventMergeComplete    = "merge_complete"
+	EventDone             = "done"
+)
+
+// Event is one event of the --events-fd stream: a pointer to one of the
+// *Event types below, or to an OtherEvent for a type this version of the
+// package doesn't know.
+type Event interface {
+	Header() EventHeader
+}
+
+// EventHeader holds the fields every event has.
+type EventHeader struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	URL  string    `json:"url,omitempty"` // redacted; on every event of downloads started together from a URL template
+}
+
+// Header returns the fields every event has.
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// StartEvent is sent once the download's layout is known.
+type StartEvent struct {
+	EventHeader
+	URL       string `json:"url"` // redacted
+	File      string `json:"file"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Completed int    `json:"completed"` // chunks already on disk
+}
+
+// ChunkStartEvent is sent when a chunk's request starts.
+type ChunkStartEvent struct {
+	EventHeader
+	Chunk int   `json:"chunk"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive
+}
+
+// ChunkRetryEvent is sent before a chunk is tried again.
+type ChunkRetryEvent struct {
+	EventHeader
+	Chunk   int    `json:"chunk"`
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error"`
+}
+
+// ChunkCompleteEvent is sent when a chunk is complete.
+type ChunkCompleteEvent struct {
+	EventHeader
+	Chunk int   `json:"chunk"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ChunkFailedEvent is sent when a chunk has run out of retries.
+type ChunkFailedEvent struct {
+	EventHeader
+	Chunk int    `json:"chunk"`
+	Error string `json:"error"`
+}
+
+// PostPartCompleteEvent is sent when a --post-part command succeeded.
+type PostPartCompleteEvent struct {
+	EventHeader
+	Chunk int `json:"chunk"`
+}
+
+// PostPartFailedEvent is sent when a --post-part command failed for good.
+type PostPartFailedEvent struct {
+	EventHeader
+	Chunk int    `json:"chunk"`
+	Error string `json:"error"`
+}
+
+// PausedEvent is sent when --schedule pauses the download.
+type PausedEvent struct {
+	EventHeader
+	Until time.Time `json:"until"` // start of the next window
+}
+
+// ResumedEvent is sent when a --schedule window opens.
+type ResumedEvent struct {
+	EventHeader
+	Window string `json:"window"`
+}
+
+// SettingsEvent is sent when the jobs or rate limit change while running.
+type SettingsEvent struct {
+	EventHeader
+	Jobs  int   `json:"jobs"`
+	Limit int64 `json:"limit"` // bytes/s, 0 = unlimited
+}
+
+// DeadlineAtRiskEvent is sent once when --deadline can't be met at the
+// current speed.
+type DeadlineAtRiskEvent struct {
+	EventHeader
+	Required  int64     `json:"required"` // bytes/s
+	Speed     int64     `json:"speed"`    // bytes/s
+	Remaining int64     `json:"remaining"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// DeadlineMissedEvent is sent when --deadline passes.
+type DeadlineMissedEvent struct {
+	EventHeader
+	Remaining int64 `json:"remaining"`
+}
+
+// BanCooldownEvent is sent when --ban-cooldown pauses all requests.
+type BanCooldownEvent struct {
+	EventHeader
+	Status  int       `json:"status"`
+	Seconds int       `json:"seconds"`
+	Until   time.Time `json:"until"`
+}
+
+// GrewEvent is sent when a --growing or --follow file got larger.
+type GrewEvent struct {
+	EventHeader
+	Size     int64 `json:"size"`
+	Previous int64 `json:"previous"`
+}
+
+// SingleStreamEvent is sent when the server ignored Range and the file
+// is downloaded in one stream.
+type SingleStreamEvent struct {
+	EventHeader
+	Status int `json:"status"`
+}
+
+// DiskBoundEvent is sent when writing the chunks holds the download back
+// and the jobs are lowered.
+type DiskBoundEvent struct {
+	EventHeader
+	Share float64 `json:"share"` // of transfer time spent writing
+	Jobs  int     `json:"jobs"`
+}
+
+// DiskRecoveredEvent is sent when the jobs lowered for the disk are
+// restored.
+type DiskRecoveredEvent struct {
+	EventHeader
+	Jobs int `json:"jobs"`
+}
+
+// DownloadCompleteEvent is sent when every chunk is complete.
+type DownloadCompleteEvent struct {
+	EventHeader
+	Bytes int64 `json:"bytes"`
+}
+
+// MergeStartEvent is sent when --merge starts.
+type MergeStartEvent struct {
+	EventHeader
+	File string `json:"file"`
+}
+
+// MergeCompleteEvent is sent when --merge finished.
+type MergeCompleteEvent struct {
+	EventHeader
+	Outputs []string `json:"outputs"`
+	Seconds float64  `json:"seconds"`
+}
+
+// Done statuses.
+const (
+	DoneComplete  = "complete"
+	DoneSkipped   = "skipped"
+	DoneError     = "error"
+	DoneCancelled = "cancelled"
+)
+
+// DoneEvent is always the last event.
+type DoneEvent struct {
+	EventHeader
+	Status          string  `json:"status"` // one of the Done constants
+	Error           string  `json:"error,omitempty"`
+	ErrorKind       string  `json:"error_kind,omitempty"` // with DoneError, e.g. "checksum"
+	ExitCode        int     `json:"exit_code,omitempty"`  // with DoneError, the status rapel exits with
+	DownloadSeconds float64 `json:"download_seconds"`
+	MergeSeconds    float64 `json:"merge_seconds,omitempty"`
+}
+
+// OtherEvent is an event of a type added after this version of the
+// package, with its fields undecoded.
+type OtherEvent struct {
+	EventHeader
+	Fields map[string]json.RawMessage `json:"-"`
+}
+
+// eventTypes maps each event type to its Go type.
+var eventTypes = []struct {
+	typ string
+	new func() Event
+}{
+	{EventStart, func() Event { return &StartEvent{} }},
+	{EventChunkStart, func() Event { return &ChunkStartEvent{} }},
+	{EventChunkRetry, func() Event { return &ChunkRetryEvent{} }},
+	{EventChunkComplete, func() Event { return &ChunkCompleteEvent{} }},
+	{EventChunkFailed, func() Event { return &ChunkFailedEvent{} }},
+	{EventPostPartComplete, func() Event { return &PostPartCompleteEvent{} }},
+	{EventPostPartFailed, func() Event { return &PostPartFailedEvent{} }},
+	{EventPaused, func() Event { return &PausedEvent{} }},
+	{EventResumed, func() Event { return &ResumedEvent{} }},
+	{EventSettings, func() Event { return &SettingsEvent{} }},
+	{EventDeadlineAtRisk, func() Event { return &DeadlineAtRiskEvent{} }},
+	{EventDeadlineMissed, func() Event { return &DeadlineMissedEvent{} }},
+	{EventBanCooldown, func() Event { return &BanCooldownEvent{} }},
+	{EventGrew, func() Event { return &GrewEvent{} }},
+	{EventSingleStream, func() Event { return &SingleStreamEvent{} }},
+	{EventDiskBound, func() Event { return &DiskBoundEvent{} }},
+	{EventDiskRecovered, func() Event { return &DiskRecoveredEvent{} }},
+	{EventDownloadComplete, func() Event { return &DownloadCompleteEvent{} }},
+	{EventMergeStart, func() Event { return &MergeStartEvent{} }},
+	{EventMergeComplete, func() Event { return &MergeCompleteEvent{} }},
+	{EventDone, func() Event { return &DoneEvent{} }},
+}
+
+// DecodeEvent decodes the JSON of one event into its type.
+func DecodeEvent(data []byte) (Event, error) {
+	var h EventHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	for _, t := range eventTypes {
+		if t.typ == h.Type {
+			ev := t.new()
+			if err := json.Unmarshal(data, ev); err != nil {
+				return nil, fmt.Errorf("failed to parse %s event: %w", h.Type, err)
+			}
+			return ev, nil
+		}
+	}
+
+	other := &OtherEvent{EventHeader: h}
+	if err := json.Unmarshal(data, &other.Fields); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	return other, nil
+}
+
+// ReadEvent reads and decodes the next event of a stream. It returns
+// io.EOF at the end of the stream.
+func ReadEvent(r io.Reader) (Event, error) {
+	data, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeEvent(data)
+}
+
+// ReadFrame reads the JSON of the next event of a stream: a 4-byte
+// big-endian length followed by that many bytes. It returns io.EOF at the
+// end of the stream.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxEventSize {
+		return nil, fmt.Errorf("event of %d bytes exceeds limit", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,20 @@
+// Command gen writes rapel.schema.json from the types of package schema.
+// Run it with go generate ./schema.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/redraw/rapel/schema"
+)
+
+func main() {
+	data, err := schema.JSONSchema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("rapel.schema.json", data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
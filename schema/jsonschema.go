@@ -0,0 +1,142 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SchemaID is the $id of rapel.schema.json.
+const SchemaID = "https://github.com/redraw/rapel/schema/rapel.schema.json"
+
+// stateTypes lists the published file formats and --rpc-socket messages.
+var stateTypes = []any{Args{}, ArgsSegment{}, Progress{}, Piped{}, PostPartFailed{}, PostPartFailure{}, Follow{}, Transfer{}, Manifest{}, ManifestPart{}, PartMeta{}, RegistryEntry{}, RPCState{}, RPCSetParams{}}
+
+// JSONSchema returns rapel.schema.json: a JSON Schema (draft 2020-12)
+// with a definition for every type in this package. The "Event"
+// definition accepts any event, told apart by its "type".
+func JSONSchema() ([]byte, error) {
+	defs := make(map[string]any)
+	for _, v := range stateTypes {
+		t := reflect.TypeOf(v)
+		defs[t.Name()] = structSchema(t, "")
+	}
+
+	var events []any
+	for _, e := range eventTypes {
+		t := reflect.TypeOf(e.new()).Elem()
+		defs[t.Name()] = structSchema(t, e.typ)
+		events = append(events, map[string]any{"$ref": "#/$defs/" + t.Name()})
+	}
+	defs["Event"] = map[string]any{"oneOf": events}
+
+	data, err := json.MarshalIndent(map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     SchemaID,
+		"title":   "rapel state files and events",
+		"$defs":   defs,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// structSchema describes a struct the way encoding/json writes it. Fields
+// without omitempty are required. An event's "type" is fixed to eventType.
+func structSchema(t reflect.Type, eventType string) map[string]any {
+	props := make(map[string]any)
+	required := []string{}
+	addFields(t, props, &required)
+	if eventType != "" {
+		props["type"] = map[string]any{"const": eventType}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+// addFields adds the JSON fields of struct t to props. Embedded structs'
+// fields are promoted unless t has a field of the same name, as with
+// encoding/json.
+func addFields(t reflect.Type, props map[string]any, required *[]string) {
+	var embedded []reflect.Type
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous {
+			embedded = append(embedded, f.Type)
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = typeSchema(f.Type)
+		if opts != "omitempty" {
+			*required = append(*required, name)
+		}
+	}
+
+	for _, e := range embedded {
+		inner := make(map[string]any)
+		var innerRequired []string
+		addFields(e, inner, &innerRequired)
+		for name, p := range inner {
+			if _, ok := props[name]; !ok {
+				props[name] = p
+			}
+		}
+		for _, name := range innerRequired {
+			if !slices.Contains(*required, name) {
+				*required = append(*required, name)
+			}
+		}
+	}
+}
+
+// typeSchema describes the JSON encoding of a value of type t.
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		// Integer keys are written as strings
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() != "" && t.PkgPath() == reflect.TypeOf(Args{}).PkgPath() {
+			return map[string]any{"$ref": "#/$defs/" + t.Name()}
+		}
+		return structSchema(t, "")
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	}
+	panic(fmt.Sprintf("schema: no JSON Schema for %s", t))
+}
@@ -0,0 +1,49 @@
+package schema
+
+// Methods of the --rpc-socket JSON-RPC 2.0 interface. Requests and their
+// answers are JSON objects, one per line.
+const (
+	RPCStatus    = "status"    // no params; answers an RPCState
+	RPCSet       = "set"       // RPCSetParams; answers an RPCState
+	RPCPause     = "pause"     // no params; answers an RPCState
+	RPCResume    = "resume"    // no params; answers an RPCState
+	RPCCancel    = "cancel"    // no params; answers true, then the download stops as on Ctrl-C
+	RPCSubscribe = "subscribe" // no params; answers true, then the connection gets notifications
+)
+
+// Notifications sent to subscribed --rpc-socket connections.
+const (
+	RPCNotifyEvent    = "event"    // params: an Event, as on the --events-fd stream
+	RPCNotifyProgress = "progress" // params: an RPCState, every second while downloading
+)
+
+// States of a download over the --rpc-socket, RPCState.State.
+const (
+	RPCStarting    = "starting" // sizing the file, or waiting for its lock
+	RPCDownloading = "downloading"
+	RPCPaused      = "paused" // by the pause method or the --schedule
+	RPCFinished    = "finished"
+)
+
+// RPCState is a download's state, as the --rpc-socket methods answer it.
+// The layout fields are zero while it is starting.
+type RPCState struct {
+	State     string  `json:"state"` // one of the RPC state constants
+	File      string  `json:"file,omitempty"`
+	Size      int64   `json:"size"`
+	Bytes     int64   `json:"bytes"` // downloaded so far, earlier runs included
+	Chunks    int     `json:"chunks"`
+	Completed int     `json:"completed"` // chunks
+	Active    int     `json:"active"`    // chunks being downloaded
+	Speed     float64 `json:"speed"`     // bytes per second, over the last 10 seconds or so
+	ETA       float64 `json:"eta_seconds,omitempty"`
+	Jobs      int     `json:"jobs"`
+	Limit     int64   `json:"limit"` // bytes per second, 0 = unlimited
+}
+
+// RPCSetParams are the params of the set method. A field left out is left
+// as it is.
+type RPCSetParams struct {
+	Jobs  *int   `json:"jobs,omitempty"`  // >= 1
+	Limit *int64 `json:"limit,omitempty"` // bytes per second, 0 = unlimited
+}
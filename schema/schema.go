@@ -0,0 +1,148 @@
+// Package schema publishes the JSON formats rapel writes for other
+// programs to read: the state files kept next to a download, the
+// download registry, the part manifest, the --events-fd event stream and
+// the --rpc-socket messages.
+//
+// The types here are the documented form of those files. Fields are only
+// ever added, never renamed, retyped or removed, so a program decoding
+// into these types keeps working with later versions of rapel; fields it
+// doesn't know about are ignored by encoding/json. rapel.schema.json holds
+// the same definitions as JSON Schema for tools written in other languages
+// and is generated from the types by go generate.
+package schema
+
+//go:generate go run ./gen
+
+import "time"
+
+// Args is .{prefix}-args.json: the layout a download started with. A
+// resumed run refuses to continue against another URL or size.
+type Args struct {
+	URL            string        `json:"url"`                  // redacted: signatures, tokens and passwords replaced
+	URLHash        string        `json:"url_sha256,omitempty"` // SHA-256 fingerprint of the full URL
+	TotalSize      int64         `json:"total_size"`
+	ChunkSize      int64         `json:"chunk_size"`
+	Layout         []ArgsSegment `json:"layout,omitempty"` // set once a resume with another -c re-sliced the chunks left
+	FilenamePrefix string        `json:"filename_prefix"`  // chunks are <prefix>.NNNNNN.part
+}
+
+// ArgsSegment is a stretch of the file cut into chunks of ChunkSize
+// bytes, the last one possibly shorter. Without a layout, the whole file
+// is one segment of Args.ChunkSize.
+type ArgsSegment struct {
+	Offset    int64 `json:"offset"`
+	Size      int64 `json:"size"`
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// Progress is .{prefix}-progress.json: what earlier runs of a download
+// did, rewritten every few seconds while downloading.
+type Progress struct {
+	Elapsed time.Duration `json:"elapsed_ns"`       // time spent downloading
+	Bytes   int64         `json:"bytes"`            // bytes downloaded
+	Chunks  map[int]int64 `json:"chunks,omitempty"` // bytes of unfinished chunks, by index
+}
+
+// Piped is .{prefix}-piped.json: the chunks whose --pipe-part command
+// exited successfully.
+type Piped struct {
+	Piped []int `json:"piped"`
+}
+
+// PostPartFailed is .{prefix}-post-part-failed.json: the chunks whose
+// post-part command failed after all its retries, as rapel status lists
+// them.
+type PostPartFailed struct {
+	Failed []PostPartFailure `json:"failed"`
+}
+
+// PostPartFailure is one chunk in PostPartFailed.
+type PostPartFailure struct {
+	Chunk    int       `json:"chunk"`
+	Part     string    `json:"part"`    // the chunk's file, as {part} names it
+	Command  string    `json:"command"` // as run, placeholders filled in
+	Error    string    `json:"error"`
+	ExitCode int       `json:"exit_code,omitempty"` // 0 when it didn't exit on its own (timeout, not found)
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Follow is .{prefix}-follow.json: where a --follow capture stopped.
+type Follow struct {
+	URL     string `json:"url"` // redacted
+	URLHash string `json:"url_sha256"`
+	Output  string `json:"output"` // file the captured bytes are appended to
+	Offset  int64  `json:"offset"` // bytes captured so far
+}
+
+// Transfer is one line of .{prefix}-transfers.jsonl, the transfer log
+// rapel report summarizes: a request for a chunk's bytes. Every run of the
+// download appends to it; a fresh start empties it.
+type Transfer struct {
+	Chunk       int       `json:"chunk"`   // a coalesced request's first chunk
+	Attempt     int       `json:"attempt"` // the chunk's requests before this one in the run
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	From        int64     `json:"from"`   // first byte requested
+	To          int64     `json:"to"`     // last byte requested
+	Bytes       int64     `json:"bytes"`  // received, whether the request succeeded or not
+	Source      string    `json:"source"` // the host, or "fetch-cmd"
+	Error       string    `json:"error,omitempty"`
+	Interrupted bool      `json:"interrupted,omitempty"` // cut off by the download stopping or pausing, not a failure
+}
+
+// Manifest is <file>.manifest.json, written by --write-manifest and
+// rapel audit --create: every part's byte range and hashes.
+type Manifest struct {
+	File      string         `json:"file"`
+	Size      int64          `json:"size"`
+	ChunkSize int64          `json:"chunk_size"`
+	SHA256    string         `json:"sha256,omitempty"` // whole file, once known
+	Parts     []ManifestPart `json:"parts"`
+}
+
+// ManifestPart is one part of a Manifest.
+type ManifestPart struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"` // inclusive
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"` // matches single-request S3 ETags
+}
+
+// PartMeta is <part>.json, or the user.rapel.part extended attribute of
+// the part, written by --part-meta: one part described on its own.
+type PartMeta struct {
+	File     string `json:"file"`      // the whole file the part belongs to
+	FileSize int64  `json:"file_size"` // size of the whole file
+	URL      string `json:"url"`       // redacted
+	ManifestPart
+}
+
+// Registry statuses of a download.
+const (
+	StatusDownloading = "downloading"
+	StatusComplete    = "complete"
+	StatusFailed      = "failed"
+	StatusCancelled   = "cancelled"
+	StatusIncomplete  = "incomplete" // selected chunks done, others still missing
+)
+
+// RegistryEntry is one download in the registry, <state dir>/<id>.json
+// (~/.local/share/rapel/state by default), as listed by rapel list.
+type RegistryEntry struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"` // redacted
+	URLHash   string    `json:"url_sha256"`
+	Dir       string    `json:"dir"`    // directory holding the chunks
+	Prefix    string    `json:"prefix"` // filename prefix of the chunks
+	TotalSize int64     `json:"total_size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Status    string    `json:"status"` // one of the Status constants
+	Error     string    `json:"error,omitempty"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
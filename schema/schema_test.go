@@ -0,0 +1,140 @@
+package schema_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/redraw/rapel/internal/downloader"
+	"github.com/redraw/rapel/internal/events"
+	"github.com/redraw/rapel/internal/manifest"
+	"github.com/redraw/rapel/internal/registry"
+	"github.com/redraw/rapel/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertPublished checks that data, as rapel writes it, decodes into the
+// published type of v with no field left over and encodes back the same.
+func assertPublished(t *testing.T, data []byte, v any) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	require.NoError(t, dec.Decode(v), "%T is missing fields", v)
+	again, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(again), "%T", v)
+}
+
+func TestStateFilesPublished(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		written   any
+		published any
+	}{
+		{&downloader.DownloadArguments{URL: "https://x/f", URLHash: "ab", TotalSize: 10, ChunkSize: 4, FilenamePrefix: "f",
+			Layout: []downloader.Segment{{Offset: 0, Size: 6, ChunkSize: 6}, {Offset: 6, Size: 4, ChunkSize: 4}}}, &schema.Args{}},
+		{&downloader.ProgressState{Elapsed: time.Minute, Bytes: 5, Chunks: map[int]int64{2: 1}}, &schema.Progress{}},
+		{&downloader.PipeState{Piped: []int{0, 2}}, &schema.Piped{}},
+		{&downloader.DeadLetters{Failed: []downloader.DeadLetter{
+			{Chunk: 3, Part: "f.000003.part", Command: "rclone move f.000003.part r:", Error: "exit status 1", ExitCode: 1, Attempts: 4, FailedAt: now},
+		}}, &schema.PostPartFailed{}},
+		{&downloader.Transfer{Chunk: 2, Attempt: 1, Start: now, End: now.Add(time.Second), From: 8, To: 11, Bytes: 4,
+			Source: "example.com", Error: "unexpected status code: 503", Interrupted: true}, &schema.Transfer{}},
+		{&manifest.Manifest{File: "f", Size: 10, ChunkSize: 4, SHA256: "cd", Parts: []manifest.Part{
+			{Index: 0, Name: "f.000000.part", Start: 0, End: 3, Size: 4, SHA256: "ef", MD5: "01"},
+		}}, &schema.Manifest{}},
+		{&manifest.PartMeta{File: "f", FileSize: 10, URL: "https://x/f", Part: manifest.Part{
+			Index: 1, Name: "f.000001.part", Start: 4, End: 7, Size: 4, SHA256: "ef", MD5: "01",
+		}}, &schema.PartMeta{}},
+		{&registry.Entry{ID: "id", URL: "https://x/f", URLHash: "ab", Dir: "/d", Prefix: "f", TotalSize: 10, ChunkSize: 4,
+			Status: registry.StatusFailed, Error: "boom", PID: 1, StartedAt: now, UpdatedAt: now}, &schema.RegistryEntry{}},
+	} {
+		data, err := json.Marshal(tt.written)
+		require.NoError(t, err)
+		assertPublished(t, data, tt.published)
+	}
+}
+
+func TestEventsPublished(t *testing.T) {
+	var buf bytes.Buffer
+	w := events.NewWriter(&buf)
+	now := time.Now()
+	sent := []string{
+		events.Start, events.ChunkStart, events.ChunkRetry, events.ChunkComplete, events.ChunkFailed,
+		events.PostPartComplete, events.PostPartFailed, events.Paused, events.Resumed, events.Settings,
+		events.DeadlineAtRisk, events.DeadlineMissed, events.BanCooldown, events.Grew, events.SingleStream,
+		events.DiskBound, events.DiskRecovered, events.DownloadComplete, events.MergeStart, events.MergeComplete,
+		events.Done, events.Done,
+	}
+	// The fields and value types each event is emitted with
+	w.Emit(events.Start, "url", "https://x/f", "file", "f", "size", int64(10), "chunk_size", int64(4), "chunks", 3, "completed", 0)
+	w.Emit(events.ChunkStart, "chunk", 1, "start", int64(4), "end", int64(7))
+	w.Emit(events.ChunkRetry, "chunk", 1, "attempt", 2, "error", errors.New("reset"))
+	w.Emit(events.ChunkComplete, "chunk", 1, "bytes", int64(4))
+	w.Emit(events.ChunkFailed, "chunk", 1, "error", errors.New("reset"))
+	w.Emit(events.PostPartComplete, "chunk", 1)
+	w.Emit(events.PostPartFailed, "chunk", 1, "error", errors.New("exit status 1"))
+	w.Emit(events.Paused, "until", now.Format(time.RFC3339))
+	w.Emit(events.Resumed, "window", "23:00-07:00")
+	w.Emit(events.Settings, "jobs", 4, "limit", int64(1000))
+	w.Emit(events.DeadlineAtRisk, "required", int64(100), "speed", int64(50), "remaining", int64(6), "deadline", now.Format(time.RFC3339))
+	w.Emit(events.DeadlineMissed, "remaining", int64(6))
+	w.Emit(events.BanCooldown, "status", 429, "seconds", 60, "until", now.Format(time.RFC3339))
+	w.Emit(events.Grew, "size", int64(20), "previous", int64(10))
+	w.Emit(events.SingleStream, "status", 200)
+	w.Emit(events.DiskBound, "share", 0.75, "jobs", 2)
+	w.Emit(events.DiskRecovered, "jobs", 4)
+	w.Emit(events.DownloadComplete, "bytes", int64(10))
+	w.Emit(events.MergeStart, "file", "f")
+	w.Emit(events.MergeComplete, "outputs", []string{"f"}, "seconds", 1.5)
+	w.Emit(events.Done, "status", schema.DoneComplete, "download_seconds", 2.5, "merge_seconds", 1.5)
+	w.With("url", "https://x/g").Emit(events.Done, "status", schema.DoneError, "error", errors.New("boom"), "error_kind", "error", "exit_code", 1, "download_seconds", 0.5)
+
+	for _, typ := range sent {
+		data, err := schema.ReadFrame(&buf)
+		require.NoError(t, err)
+		ev, err := schema.DecodeEvent(data)
+		require.NoError(t, err)
+		assert.Equal(t, typ, ev.Header().Type)
+		assert.NotEqual(t, reflect.TypeOf(&schema.OtherEvent{}), reflect.TypeOf(ev), typ)
+		assertPublished(t, data, reflect.New(reflect.TypeOf(ev).Elem()).Interface())
+	}
+	_, err := schema.ReadEvent(&buf)
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestDecodeOtherEvent(t *testing.T) {
+	ev, err := schema.DecodeEvent([]byte(`{"type":"new_thing","time":"2026-01-02T03:04:05Z","answer":42}`))
+	require.NoError(t, err)
+	other, ok := ev.(*schema.OtherEvent)
+	require.True(t, ok)
+	assert.Equal(t, "new_thing", other.Type)
+	assert.JSONEq(t, "42", string(other.Fields["answer"]))
+}
+
+func TestJSONSchemaUpToDate(t *testing.T) {
+	want, err := schema.JSONSchema()
+	require.NoError(t, err)
+	got, err := os.ReadFile("rapel.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "rapel.schema.json is stale: run go generate ./schema")
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(got, &doc))
+	defs := doc["$defs"].(map[string]any)
+	// One definition for every event type
+	types := make(map[string]bool)
+	for _, v := range defs {
+		props, _ := v.(map[string]any)["properties"].(map[string]any)
+		if typ, ok := props["type"].(map[string]any); ok {
+			types[typ["const"].(string)] = true
+		}
+	}
+	assert.Len(t, defs["Event"].(map[string]any)["oneOf"], len(types))
+}